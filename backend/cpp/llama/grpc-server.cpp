@@ -9,6 +9,7 @@
 
 #include <iostream>
 #include <memory>
+#include <map>
 #include <string>
 #include <getopt.h>
 #include "clip.h"
@@ -33,6 +34,7 @@
 #include <grpcpp/grpcpp.h>
 #include <grpcpp/health_check_service_interface.h>
 #include <atomic>
+#include <cmath>
 #include <signal.h>
 
 using grpc::Server;
@@ -153,6 +155,7 @@ static json probs_vector_to_json(const llama_context *ctx, const std::vector<com
         std::string tok_str = tokens_to_output_formatted_string(ctx, prob.tok);
         out.push_back(json{
             {"content", tok_str},
+            {"prob",    prob.prob},
             {"probs",   probs_for_token},
         });
     }
@@ -187,6 +190,7 @@ struct llama_client_slot
     std::string generated_text;
     llama_token sampled;
     std::vector<llama_token> cache_tokens;
+    std::string cache_key; // key of the requests whose prompt is held in cache_tokens
     std::vector<completion_token_output> generated_token_probs;
 
     bool infill = false;
@@ -427,6 +431,10 @@ struct llama_server_context
 
     llama_metrics metrics;
 
+    // LoRA adapters registered in the model config, that requests can switch between
+    std::map<std::string, std::pair<llama_lora_adapter *, float>> lora_adapters;
+    std::string current_lora_adapter;
+
     ~llama_server_context()
     {
         if (ctx)
@@ -485,6 +493,36 @@ struct llama_server_context
         return true;
     }
 
+    bool load_lora_adapter(const std::string & name, const std::string & path, float scale)
+    {
+        // the adapter is checked against the model, and freed together with it
+        llama_lora_adapter * adapter = llama_lora_adapter_init(model, path.c_str());
+        if (adapter == nullptr)
+        {
+            LOG_ERR("unable to load LoRA adapter %s: %s", name.c_str(), path.c_str());
+            return false;
+        }
+        lora_adapters[name] = {adapter, scale};
+        return true;
+    }
+
+    // set_lora_adapter applies a registered adapter to the context, or none if name is empty
+    bool set_lora_adapter(const std::string & name)
+    {
+        llama_lora_adapter_clear(ctx);
+        if (!name.empty())
+        {
+            auto it = lora_adapters.find(name);
+            if (it == lora_adapters.end() || llama_lora_adapter_set(ctx, it->second.first, it->second.second) != 0)
+            {
+                current_lora_adapter = "";
+                return false;
+            }
+        }
+        current_lora_adapter = name;
+        return true;
+    }
+
     void validate_model_chat_template(server_params & sparams) {
         llama_chat_message chat[] = {{"user", "test"}};
         std::vector<char> buf(1);
@@ -606,7 +644,7 @@ struct llama_server_context
         return prompt_tokens;
     }
 
-    llama_client_slot* get_slot(int id) {
+    llama_client_slot* get_slot(int id, const std::string &cache_key = "") {
         int64_t t_last = ggml_time_us();
         llama_client_slot *last_used = nullptr;
 
@@ -617,6 +655,12 @@ struct llama_server_context
                 return &slot;
             }
 
+            // prefer the slot holding the cached prompt of the same key
+            if (!cache_key.empty() && slot.cache_key == cache_key && slot.available())
+            {
+                return &slot;
+            }
+
             if (slot.available() && slot.t_last_used < t_last)
             {
                 last_used = &slot;
@@ -954,6 +998,31 @@ struct llama_server_context
         system_need_update = false;
     }
 
+    // evict_idle_slot_caches frees the KV cache held by the cached prompts of the
+    // idle slots, keeping the system prompt. Returns false if there was nothing to evict.
+    bool evict_idle_slot_caches() {
+        bool evicted = false;
+        for (llama_client_slot &slot : slots)
+        {
+            if (!slot.available() || slot.cache_tokens.empty())
+            {
+                continue;
+            }
+            LOG_INFO("evicting slot prompt cache", {
+                {"slot_id",       slot.id},
+                {"cache_key",     slot.cache_key},
+                {"tokens_cached", slot.cache_tokens.size()},
+            });
+            llama_kv_cache_seq_rm(ctx, slot.id, system_tokens.size(), -1);
+            slot.cache_tokens.clear();
+            slot.cache_key = "";
+            slot.n_past    = 0;
+            slot.n_past_se = 0;
+            evicted = true;
+        }
+        return evicted;
+    }
+
     void notify_system_prompt_changed() {
         // release all slots
         for (llama_client_slot &slot : slots)
@@ -1260,6 +1329,7 @@ struct llama_server_context
             {"stopped_limit",       slot.stopped_limit},
             {"stopping_word",       slot.stopping_word},
             {"tokens_cached",       slot.n_past},
+            {"prompt_tokens_cached", slot.num_prompt_tokens - slot.num_prompt_tokens_processed},
             {"timings",             slot.get_formated_timings()}
         };
 
@@ -1310,7 +1380,12 @@ struct llama_server_context
         }
         else
         {
-            const float *data = llama_get_embeddings(ctx);
+            // the pooled embedding of the sequence, or the one of the last token without pooling
+            const float *data = llama_get_embeddings_seq(ctx, slot.id);
+            if (data == NULL)
+            {
+                data = llama_get_embeddings(ctx);
+            }
             std::vector<float> embedding(data, data + n_embd);
             res.result_json = json
             {
@@ -1468,7 +1543,8 @@ struct llama_server_context
         switch (task.type)
         {
             case TASK_TYPE_COMPLETION: {
-                llama_client_slot *slot = get_slot(json_value(task.data, "slot_id", -1));
+                const std::string cache_key = json_value(task.data, "cache_key", std::string());
+                llama_client_slot *slot = get_slot(json_value(task.data, "slot_id", -1), cache_key);
                 if (slot == nullptr)
                 {
                     // if no slot is available, we defer this task for processing later
@@ -1494,8 +1570,39 @@ struct llama_server_context
                     }
                 }
 
+                const std::string lora_adapter = json_value(task.data, "lora_adapter", std::string());
+                if (lora_adapter != current_lora_adapter)
+                {
+                    if (!all_slots_are_idle) {
+                        // the context is shared by the slots: wait for them to be idle
+                        queue_tasks.defer(task);
+                        break;
+                    }
+                    if (!set_lora_adapter(lora_adapter)) {
+                        send_error(task, "unable to apply LoRA adapter " + lora_adapter);
+                        break;
+                    }
+
+                    // the cached tokens were evaluated with another adapter
+                    for (llama_client_slot &slot : slots)
+                    {
+                        slot.cache_tokens.clear();
+                        slot.n_past    = 0;
+                        slot.n_past_se = 0;
+                    }
+                }
+
                 slot->reset();
 
+                if (slot->cache_key != cache_key && !slot->cache_tokens.empty())
+                {
+                    LOG_VERBOSE("slot cache taken over by another key", {
+                        {"slot_id",   slot->id},
+                        {"cache_key", cache_key},
+                    });
+                }
+                slot->cache_key = cache_key;
+
                 slot->infill       = task.infill_mode;
                 slot->embedding    = task.embedding_mode;
                 slot->task_id      = task.id;
@@ -1773,6 +1880,16 @@ struct llama_server_context
                             { "n_past",  slot.n_past },
                             { "num_prompt_tokens_processed", slot.num_prompt_tokens_processed }
                         });
+
+                        if (slot.n_past > 0)
+                        {
+                            LOG_INFO("prompt cache hit", {
+                                { "slot_id",        slot.id },
+                                { "cache_key",      slot.cache_key },
+                                { "tokens_reused",  slot.n_past },
+                                { "tokens_to_eval", slot.num_prompt_tokens_processed }
+                            });
+                        }
                     }
 
                     slot.cache_tokens = prompt_tokens;
@@ -1918,6 +2035,13 @@ struct llama_server_context
                     return false;
                 }
 
+                if (evict_idle_slot_caches())
+                {
+                    LOG("%s : failed to find free space in the KV cache, retrying after evicting the prompt caches of the idle slots\n", __func__);
+                    i -= n_batch;
+                    continue;
+                }
+
                 LOG("%s : failed to find free space in the KV cache, retrying with smaller n_batch = %d\n", __func__, n_batch / 2);
 
                 // retry with half the batch size to try to find a free slot in the KV cache
@@ -1965,6 +2089,15 @@ struct llama_server_context
                     });
                 }
 
+                if (slot.sparams.n_probs > 0) {
+                    for (size_t i = 0; i < cur_p->size; ++i) {
+                        if (cur_p->data[i].id == id) {
+                            result.prob = cur_p->data[i].p;
+                            break;
+                        }
+                    }
+                }
+
                 if (!process_token(result, slot))
                 {
                     slot.release();
@@ -2115,6 +2248,14 @@ json parse_options(bool streaming, const backend::PredictOptions* predict, llama
     data["prompt"] = predict->prompt();
     data["ignore_eos"] = predict->ignoreeos();
     data["embeddings"] = predict->embeddings();
+    data["lora_adapter"] = predict->loraadapter();
+    data["cache_key"] = predict->cachekey();
+
+    // Reusing the prompt cache across requests changes the batching of the
+    // evaluated tokens, which can alter the logits: disable it in deterministic mode
+    if (predict->deterministic()) {
+        data["cache_prompt"] = false;
+    }
 
     // Add the correlationid to json data
     data["correlation_id"] = predict->correlationid();
@@ -2130,7 +2271,7 @@ json parse_options(bool streaming, const backend::PredictOptions* predict, llama
     }
 
     data["stop"] = predict->stopprompts();
-    // data["n_probs"] = predict->nprobs();
+    data["n_probs"] = predict->nprobs();
     //TODO: images,
 
     return data;
@@ -2230,6 +2371,14 @@ static void params_parse(const backend::ModelOptions* request,
         params.n_parallel = 1;
     }
 
+    if (request->numapolicy() == "isolate") {
+        params.numa = GGML_NUMA_STRATEGY_ISOLATE;
+    } else if (request->numapolicy() == "numactl") {
+        params.numa = GGML_NUMA_STRATEGY_NUMACTL;
+    } else if (request->numa() || request->numapolicy() == "distribute") {
+        params.numa = GGML_NUMA_STRATEGY_DISTRIBUTE;
+    }
+
     const char *llama_grpc_servers = std::getenv("LLAMACPP_GRPC_SERVERS");
     if (llama_grpc_servers != NULL) {
         params.rpc_servers = std::string(llama_grpc_servers);
@@ -2276,9 +2425,18 @@ static void params_parse(const backend::ModelOptions* request,
 
     params.embedding = request->embeddings();
 
+    // the pooling of the embeddings is set when creating the context, unspecified uses the one of the model
+    if (request->pooling() == "mean")      { params.pooling_type = LLAMA_POOLING_TYPE_MEAN; }
+    else if (request->pooling() == "cls")  { params.pooling_type = LLAMA_POOLING_TYPE_CLS; }
+    else if (request->pooling() == "last") { params.pooling_type = LLAMA_POOLING_TYPE_LAST; }
+
+    // an unset scaling keeps the one of the model metadata
     if (request->ropescaling() == "none")   { params.rope_scaling_type = LLAMA_ROPE_SCALING_TYPE_NONE; }
     else if (request->ropescaling() == "yarn")   { params.rope_scaling_type = LLAMA_ROPE_SCALING_TYPE_YARN; }
-    else { params.rope_scaling_type = LLAMA_ROPE_SCALING_TYPE_LINEAR; }
+    else if (request->ropescaling() == "linear") { params.rope_scaling_type = LLAMA_ROPE_SCALING_TYPE_LINEAR; }
+    if ( request->yarnorigctx() != 0 ) {
+        params.yarn_orig_ctx = request->yarnorigctx();
+    }
     if ( request->yarnextfactor() != 0.0f ) {
         params.yarn_ext_factor = request->yarnextfactor();
     }
@@ -2301,6 +2459,48 @@ static void params_parse(const backend::ModelOptions* request,
 
 
 // GRPC Server start
+// set_reply_logprobs copies the token probabilities of a completion result
+// (present when n_probs > 0) to the reply as log probabilities
+static void set_reply_logprobs(backend::Reply* reply, const json &result_json)
+{
+    if (!result_json.contains("completion_probabilities")) {
+        return;
+    }
+    for (const auto &token : result_json.at("completion_probabilities"))
+    {
+        backend::TokenLogprob* logprob = reply->add_logprobs();
+        logprob->set_token(token.value("content", ""));
+        logprob->set_logprob(std::log(token.value("prob", 0.0f)));
+        for (const auto &top : token.at("probs"))
+        {
+            backend::TokenLogprob* top_logprob = logprob->add_top_logprobs();
+            top_logprob->set_token(top.value("tok_str", ""));
+            top_logprob->set_logprob(std::log(top.value("prob", 0.0f)));
+        }
+    }
+}
+
+// set_reply_finish_reason reports why the generation of the final result of a completion stopped
+static void set_reply_finish_reason(backend::Reply* reply, const json &result_json)
+{
+    if (result_json.value("stopped_limit", false)) {
+        reply->set_finish_reason("length");
+    } else if (result_json.value("stopped_eos", false) || result_json.value("stopped_word", false)) {
+        reply->set_finish_reason("stop");
+    }
+}
+
+// correlation ID of the request, forwarded by LocalAI in the gRPC metadata
+static std::string correlation_id(const ServerContext* context)
+{
+    const auto &metadata = context->client_metadata();
+    auto it = metadata.find("x-correlation-id");
+    if (it == metadata.end()) {
+        return "";
+    }
+    return std::string(it->second.data(), it->second.length());
+}
+
 class BackendServiceImpl final : public backend::Backend::Service {
 public:
   grpc::Status Health(ServerContext* context, const backend::HealthMessage* request, backend::Reply* reply) {
@@ -2317,6 +2517,12 @@ public:
     llama_backend_init();
     llama_numa_init(params.numa);
 
+    if (request->loraadapters_size() > 0 && !request->loraadapter().empty()) {
+        result->set_message("lora_adapters cannot be combined with lora_adapter");
+        result->set_success(false);
+        return Status::CANCELLED;
+    }
+
     // load the model
     if (!llama.load_model(params))
     {
@@ -2324,13 +2530,43 @@ public:
         result->set_success(false);
         return Status::CANCELLED;
     }
+
+    // register the adapters that requests can apply, relative to the model directory
+    std::string model_dir = params.model.substr(0, params.model.find_last_of("/\\"));
+    for (int i = 0; i < request->loraadapters_size(); i++) {
+        const backend::LoraAdapter & adapter = request->loraadapters(i);
+        float scale = adapter.scale() != 0.0f ? adapter.scale() : 1.0f;
+        if (!llama.load_lora_adapter(adapter.name(), model_dir + "/" + adapter.path(), scale)) {
+            result->set_message("Failed loading LoRA adapter " + adapter.name());
+            result->set_success(false);
+            return Status::CANCELLED;
+        }
+    }
     llama.initialize();
     result->set_message("Loading succeeded");
     result->set_success(true);
     loaded_model = true;
     return Status::OK;
   }
+  // check_lora_adapter validates the LoRA adapter requested for a prediction
+  grpc::Status check_lora_adapter(const backend::PredictOptions* request) {
+    if (request->loraadapter().empty()) {
+        return Status::OK;
+    }
+    if (llama.lora_adapters.find(request->loraadapter()) == llama.lora_adapters.end()) {
+        return grpc::Status(grpc::StatusCode::INVALID_ARGUMENT, "LoRA adapter not registered: " + request->loraadapter());
+    }
+    if (llama.params.n_parallel > 1) {
+        return grpc::Status(grpc::StatusCode::FAILED_PRECONDITION, "LoRA adapters can be switched per request only with a single slot (LLAMACPP_PARALLEL=1)");
+    }
+    return Status::OK;
+  }
+
   grpc::Status PredictStream(grpc::ServerContext* context, const backend::PredictOptions* request, grpc::ServerWriter<backend::Reply>* writer) override {
+        grpc::Status status = check_lora_adapter(request);
+        if (!status.ok()) {
+            return status;
+        }
         json data = parse_options(true, request, llama);
         const int task_id = llama.queue_tasks.get_new_id();
         llama.queue_results.add_waiting_task_id(task_id);
@@ -2356,14 +2592,22 @@ public:
                 reply.set_tokens(tokens_predicted);
                 int32_t tokens_evaluated = result.result_json.value("tokens_evaluated", 0);
                 reply.set_prompt_tokens(tokens_evaluated);
+                set_reply_logprobs(&reply, result.result_json);
+                reply.set_prompt_tokens_cached(result.result_json.value("prompt_tokens_cached", 0));
+                set_reply_finish_reason(&reply, result.result_json);
 
                 // Log Request Correlation Id
                 LOG_VERBOSE("correlation:", {
                     { "id", data["correlation_id"] }
                 });
 
-                // Send the reply
-                writer->Write(reply);
+                // Send the reply. When the client went away, e.g. LocalAI canceled the request of a
+                // disconnected HTTP client, release the slot rather than decoding for nobody
+                if (!writer->Write(reply) || context->IsCancelled()) {
+                    llama.request_cancel(task_id);
+                    llama.queue_results.remove_waiting_task_id(task_id);
+                    return grpc::Status(grpc::StatusCode::CANCELLED, "request canceled by the client");
+                }
 
                 if (result.stop) {
                     break;
@@ -2378,6 +2622,10 @@ public:
 
 
     grpc::Status Predict(ServerContext* context, const backend::PredictOptions* request, backend::Reply* reply) {
+        grpc::Status status = check_lora_adapter(request);
+        if (!status.ok()) {
+            return status;
+        }
         json data = parse_options(false, request, llama);
         const int task_id = llama.queue_tasks.get_new_id();
         llama.queue_results.add_waiting_task_id(task_id);
@@ -2397,6 +2645,16 @@ public:
             reply->set_prompt_tokens(tokens_evaluated);
             reply->set_tokens(tokens_predicted);
             reply->set_message(completion_text);
+            set_reply_logprobs(reply, result.result_json);
+            reply->set_prompt_tokens_cached(result.result_json.value("prompt_tokens_cached", 0));
+            set_reply_finish_reason(reply, result.result_json);
+
+            if (result.result_json.contains("timings")) {
+                double timing_prompt_processing = result.result_json.at("timings").value("prompt_ms", 0.0);
+                reply->set_timing_prompt_processing(timing_prompt_processing);
+                double timing_token_generation = result.result_json.at("timings").value("predicted_ms", 0.0);
+                reply->set_timing_token_generation(timing_token_generation);
+            }
         }
         else
         {
@@ -2412,6 +2670,11 @@ public:
         const int task_id = llama.queue_tasks.get_new_id();
         llama.queue_results.add_waiting_task_id(task_id);
         llama.request_completion(task_id, { {"prompt", data["embeddings"]}, { "n_predict", 0}, {"image_data", ""} }, false, true, -1);
+
+        // Log Request Correlation Id
+        LOG_VERBOSE("correlation:", {
+            { "id", correlation_id(context) }
+        });
         // get the result
         task_result result = llama.queue_results.recv(task_id);
         //std::cout << "Embedding result JSON" << result.result_json.dump() << std::endl;
@@ -2431,6 +2694,33 @@ public:
         return grpc::Status::OK;
     }
 
+    grpc::Status TokenizeString(ServerContext* context, const backend::PredictOptions* request, backend::TokenizationResponse* response) {
+        std::vector<llama_token> tokens = llama.tokenize(request->prompt(), false);
+        for (int i = 0; i < tokens.size(); i++) {
+            response->add_tokens(tokens[i]);
+        }
+        response->set_length(tokens.size());
+
+        return grpc::Status::OK;
+    }
+
+    grpc::Status Detokenize(ServerContext* context, const backend::DetokenizeRequest* request, backend::DetokenizationResponse* response) {
+        std::vector<llama_token> tokens(request->tokens().begin(), request->tokens().end());
+        response->set_text(tokens_to_str(llama.ctx, tokens.cbegin(), tokens.cend()));
+
+        return grpc::Status::OK;
+    }
+
+    grpc::Status Capabilities(ServerContext* context, const backend::HealthMessage* request, backend::CapabilitiesResponse* response) {
+        response->add_capabilities("generate");
+        response->add_capabilities("tokenize");
+        // without embeddings enabled at load time, the embeddings are all zeros
+        if (llama.params.embedding) {
+            response->add_capabilities("embed");
+        }
+        return grpc::Status::OK;
+    }
+
     grpc::Status GetMetrics(ServerContext* context, const backend::MetricsRequest* request, backend::MetricsResponse* response) {
         llama_client_slot* active_slot = llama.get_active_slot();
 
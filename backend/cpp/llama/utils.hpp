@@ -131,6 +131,7 @@ struct completion_token_output
 
     std::vector<token_prob> probs;
     llama_token tok;
+    float prob = 0.0f; // probability of the sampled token, set only when n_probs > 0
     std::string text_to_send;
 };
 
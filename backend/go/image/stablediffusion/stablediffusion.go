@@ -3,6 +3,9 @@ package main
 // This is a wrapper to statisfy the GRPC service interface
 // It is meant to be used by the main executable that is the server for the specific backend type (falcon, gpt3, etc)
 import (
+	"fmt"
+
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/stablediffusion"
@@ -20,7 +23,16 @@ func (image *Image) Load(opts *pb.ModelOptions) error {
 	return err
 }
 
+// Capabilities reports what the backend supports with the loaded model
+func (image *Image) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityImage}, nil
+}
+
 func (image *Image) GenerateImage(opts *pb.GenerateImageRequest) error {
+	// The ncnn implementation can start from an init image, but has no inpainting
+	if opts.Mask != "" {
+		return fmt.Errorf("inpainting is not supported by the stablediffusion backend")
+	}
 	return image.stablediffusion.GenerateImage(
 		int(opts.Height),
 		int(opts.Width),
@@ -29,5 +41,6 @@ func (image *Image) GenerateImage(opts *pb.GenerateImageRequest) error {
 		int(opts.Seed),
 		opts.PositivePrompt,
 		opts.NegativePrompt,
+		opts.Src,
 		opts.Dst)
 }
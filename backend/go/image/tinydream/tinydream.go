@@ -3,6 +3,7 @@ package main
 // This is a wrapper to statisfy the GRPC service interface
 // It is meant to be used by the main executable that is the server for the specific backend type (falcon, gpt3, etc)
 import (
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/tinydream"
@@ -20,6 +21,11 @@ func (image *Image) Load(opts *pb.ModelOptions) error {
 	return err
 }
 
+// Capabilities reports what the backend supports with the loaded model
+func (image *Image) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityImage}, nil
+}
+
 func (image *Image) GenerateImage(opts *pb.GenerateImageRequest) error {
 	return image.tinydream.GenerateImage(
 		int(opts.Height),
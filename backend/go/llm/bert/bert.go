@@ -5,6 +5,7 @@ package main
 import (
 	bert "github.com/go-skynet/go-bert.cpp"
 
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 )
@@ -20,6 +21,11 @@ func (llm *Embeddings) Load(opts *pb.ModelOptions) error {
 	return err
 }
 
+// Capabilities reports what the backend supports with the loaded model
+func (llm *Embeddings) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityEmbed}, nil
+}
+
 func (llm *Embeddings) Embeddings(opts *pb.PredictOptions) ([]float32, error) {
 
 	if len(opts.EmbeddingTokens) > 0 {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/langchain"
@@ -29,6 +30,11 @@ func (llm *LLM) Load(opts *pb.ModelOptions) error {
 	return err
 }
 
+// Capabilities reports what the backend supports with the loaded model
+func (llm *LLM) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityGenerate}, nil
+}
+
 func (llm *LLM) Predict(opts *pb.PredictOptions) (string, error) {
 	o := []langchain.PredictOption{
 		langchain.SetModel(llm.model),
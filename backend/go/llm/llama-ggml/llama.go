@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/go-skynet/go-llama.cpp"
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 )
@@ -13,10 +14,12 @@ import (
 type LLM struct {
 	base.SingleThread
 
-	llama *llama.LLama
+	llama      *llama.LLama
+	embeddings bool
 }
 
 func (llm *LLM) Load(opts *pb.ModelOptions) error {
+	llm.embeddings = opts.Embeddings
 	ropeFreqBase := float32(10000)
 	ropeFreqScale := float32(1)
 
@@ -166,6 +169,15 @@ func buildPredictOptions(opts *pb.PredictOptions) []llama.PredictOption {
 	return predictOptions
 }
 
+// Capabilities reports what the backend supports with the loaded model
+func (llm *LLM) Capabilities() ([]string, error) {
+	capabilities := []string{grpc.CapabilityGenerate}
+	if llm.embeddings {
+		capabilities = append(capabilities, grpc.CapabilityEmbed)
+	}
+	return capabilities, nil
+}
+
 func (llm *LLM) Predict(opts *pb.PredictOptions) (string, error) {
 	return llm.llama.Predict(opts.Prompt, buildPredictOptions(opts)...)
 }
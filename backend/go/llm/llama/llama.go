@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/go-skynet/go-llama.cpp"
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 )
@@ -16,9 +17,11 @@ type LLM struct {
 
 	llama      *llama.LLama
 	draftModel *llama.LLama
+	embeddings bool
 }
 
 func (llm *LLM) Load(opts *pb.ModelOptions) error {
+	llm.embeddings = opts.Embeddings
 	ropeFreqBase := float32(10000)
 	ropeFreqScale := float32(1)
 
@@ -198,6 +201,15 @@ func buildPredictOptions(opts *pb.PredictOptions) []llama.PredictOption {
 	return predictOptions
 }
 
+// Capabilities reports what the backend supports with the loaded model
+func (llm *LLM) Capabilities() ([]string, error) {
+	capabilities := []string{grpc.CapabilityGenerate, grpc.CapabilityTokenize}
+	if llm.embeddings {
+		capabilities = append(capabilities, grpc.CapabilityEmbed)
+	}
+	return capabilities, nil
+}
+
 func (llm *LLM) Predict(opts *pb.PredictOptions) (string, error) {
 	if llm.draftModel != nil {
 		return llm.llama.SpeculativeSampling(llm.draftModel, opts.Prompt, buildPredictOptions(opts)...)
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/donomii/go-rwkv.cpp"
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 )
@@ -37,6 +38,11 @@ func (llm *LLM) Load(opts *pb.ModelOptions) error {
 	return nil
 }
 
+// Capabilities reports what the backend supports with the loaded model
+func (llm *LLM) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityGenerate, grpc.CapabilityTokenize}, nil
+}
+
 func (llm *LLM) Predict(opts *pb.PredictOptions) (string, error) {
 	stopWord := "\n"
 	if len(opts.StopPrompts) > 0 {
@@ -93,3 +99,14 @@ func (llm *LLM) TokenizeString(opts *pb.PredictOptions) (pb.TokenizationResponse
 		Tokens: i32Tokens,
 	}, nil
 }
+
+func (llm *LLM) Detokenize(opts *pb.DetokenizeRequest) (pb.DetokenizationResponse, error) {
+	tokens := make([]int, len(opts.Tokens))
+	for i, t := range opts.Tokens {
+		tokens[i] = int(t)
+	}
+
+	return pb.DetokenizationResponse{
+		Text: rwkv.DeTokenise(*llm.rwkv.Tokenizer, tokens),
+	}, nil
+}
@@ -102,6 +102,11 @@ func (s *Store) Load(opts *pb.ModelOptions) error {
 	return nil
 }
 
+// Capabilities reports that the store supports none of the model features, only the stores calls
+func (s *Store) Capabilities() ([]string, error) {
+	return []string{}, nil
+}
+
 // Sort the incoming kvs and merge them with the existing sorted kvs
 func (s *Store) StoresSet(opts *pb.StoresSetOptions) error {
 	if len(opts.Keys) == 0 {
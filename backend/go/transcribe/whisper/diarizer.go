@@ -0,0 +1,59 @@
+package main
+
+/*
+#include <stdbool.h>
+#include <whisper.h>
+
+static void enable_tdrz(struct whisper_full_params * params) {
+	params->tdrz_enable = true;
+}
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go"
+)
+
+// diarizer counts the speaker turns detected by tinydiarize (https://github.com/akashmjn/tinydiarize).
+// Only models fine-tuned for it (e.g. small.en-tdrz) detect speaker turns: with other models
+// all the segments are in the first turn.
+// A turn is not a speaker ID: tinydiarize only detects that the speaker changes, so a speaker
+// who already talked before starts a new turn.
+type diarizer struct {
+	ctx   *whisper.Context
+	turns []int32
+}
+
+// newDiarizer enables the speaker turn detection in params. The bindings
+// don't expose tdrz_enable, which is the only field set on the C struct
+func newDiarizer(ctx *whisper.Context, params *whisper.Params) *diarizer {
+	C.enable_tdrz((*C.struct_whisper_full_params)(unsafe.Pointer(params)))
+	return &diarizer{ctx: ctx, turns: []int32{0}}
+}
+
+// turnNext returns true if the speaker changes after the i-th segment,
+// which ends with the speaker turn token of tinydiarize
+func (d *diarizer) turnNext(i int) bool {
+	solm := d.ctx.Whisper_token_solm()
+	for j := 0; j < d.ctx.Whisper_full_n_tokens(i); j++ {
+		if d.ctx.Whisper_full_get_token_id(i, j) == solm {
+			return true
+		}
+	}
+	return false
+}
+
+// turn returns the speaker turn of the i-th segment
+func (d *diarizer) turn(i int) int32 {
+	for len(d.turns) <= i {
+		prev := len(d.turns) - 1
+		turn := d.turns[prev]
+		if d.turnNext(prev) {
+			turn++
+		}
+		d.turns = append(d.turns, turn)
+	}
+	return d.turns[i]
+}
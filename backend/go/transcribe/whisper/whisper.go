@@ -3,11 +3,15 @@ package main
 // This is a wrapper to statisfy the GRPC service interface
 // It is meant to be used by the main executable that is the server for the specific backend type (falcon, gpt3, etc)
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go"
 	"github.com/go-audio/wav"
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/utils"
@@ -15,17 +19,39 @@ import (
 
 type Whisper struct {
 	base.SingleThread
-	whisper whisper.Model
+	ctx *whisper.Context
 }
 
 func (sd *Whisper) Load(opts *pb.ModelOptions) error {
 	// Note: the Model here is a path to a directory containing the model files
-	w, err := whisper.New(opts.ModelFile)
-	sd.whisper = w
-	return err
+	ctx := whisper.Whisper_init(opts.ModelFile)
+	if ctx == nil {
+		return fmt.Errorf("failed loading model %s", opts.ModelFile)
+	}
+	sd.ctx = ctx
+	return nil
+}
+
+// Capabilities reports what the backend supports with the loaded model
+func (sd *Whisper) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityTranscription}, nil
 }
 
 func (sd *Whisper) AudioTranscription(opts *pb.TranscriptRequest) (pb.TranscriptResult, error) {
+	return sd.transcribe(opts, nil)
+}
+
+// AudioTranscriptionStream sends the segments to results as soon as they are decoded
+func (sd *Whisper) AudioTranscriptionStream(opts *pb.TranscriptRequest, results chan *pb.TranscriptSegment) error {
+	defer close(results)
+
+	_, err := sd.transcribe(opts, func(s *pb.TranscriptSegment) {
+		results <- s
+	})
+	return err
+}
+
+func (sd *Whisper) transcribe(opts *pb.TranscriptRequest, segmentCallback func(*pb.TranscriptSegment)) (pb.TranscriptResult, error) {
 
 	dir, err := os.MkdirTemp("", "whisper")
 	if err != nil {
@@ -56,43 +82,51 @@ func (sd *Whisper) AudioTranscription(opts *pb.TranscriptRequest) (pb.Transcript
 	data := buf.AsFloat32Buffer().Data
 
 	// Process samples
-	context, err := sd.whisper.NewContext()
-	if err != nil {
-		return pb.TranscriptResult{}, err
-
+	params := sd.ctx.Whisper_full_default_params(whisper.SAMPLING_GREEDY)
+	params.SetPrintSpecial(false)
+	params.SetPrintProgress(false)
+	params.SetPrintRealtime(false)
+	params.SetPrintTimestamps(false)
+	params.SetNoContext(true)
+	params.SetThreads(int(opts.Threads))
+
+	// english-only models ignore the language
+	if sd.ctx.Whisper_is_multilingual() != 0 {
+		lang := -1 // auto-detect
+		if opts.Language != "" && opts.Language != "auto" {
+			lang = sd.ctx.Whisper_lang_id(opts.Language)
+		}
+		params.SetLanguage(lang)
 	}
 
-	context.SetThreads(uint(opts.Threads))
+	params.SetTranslate(opts.Translate)
 
-	if opts.Language != "" {
-		context.SetLanguage(opts.Language)
-	} else {
-		context.SetLanguage("auto")
+	if segmentCallback != nil {
+		params.SetSingleSegment(true)
 	}
 
-	if opts.Translate {
-		context.SetTranslate(true)
+	var diarization *diarizer
+	if opts.Diarize {
+		diarization = newDiarizer(sd.ctx, &params)
 	}
 
-	if err := context.Process(data, nil, nil); err != nil {
+	if err := sd.ctx.Whisper_full(params, data, nil, func(new int) {
+		if segmentCallback == nil {
+			return
+		}
+		n := sd.ctx.Whisper_full_n_segments()
+		for i := n - new; i < n; i++ {
+			segmentCallback(sd.segment(i, diarization))
+		}
+	}, nil); err != nil {
 		return pb.TranscriptResult{}, err
 	}
 
 	segments := []*pb.TranscriptSegment{}
 	text := ""
-	for {
-		s, err := context.NextSegment()
-		if err != nil {
-			break
-		}
-
-		var tokens []int32
-		for _, t := range s.Tokens {
-			tokens = append(tokens, int32(t.Id))
-		}
-
-		segment := &pb.TranscriptSegment{Id: int32(s.Num), Text: s.Text, Start: int64(s.Start), End: int64(s.End), Tokens: tokens}
-		segments = append(segments, segment)
+	for i := 0; i < sd.ctx.Whisper_full_n_segments(); i++ {
+		s := sd.segment(i, diarization)
+		segments = append(segments, s)
 
 		text += s.Text
 	}
@@ -103,3 +137,26 @@ func (sd *Whisper) AudioTranscription(opts *pb.TranscriptRequest) (pb.Transcript
 	}, nil
 
 }
+
+// segment returns the i-th decoded segment, with its speaker turn if the diarizer is set
+func (sd *Whisper) segment(i int, d *diarizer) *pb.TranscriptSegment {
+	var tokens []int32
+	for j := 0; j < sd.ctx.Whisper_full_n_tokens(i); j++ {
+		tokens = append(tokens, int32(sd.ctx.Whisper_full_get_token_id(i, j)))
+	}
+
+	// timestamps are expressed in units of 10ms
+	segment := &pb.TranscriptSegment{
+		Id:     int32(i),
+		Text:   strings.TrimSpace(sd.ctx.Whisper_full_get_segment_text(i)),
+		Start:  int64(time.Duration(sd.ctx.Whisper_full_get_segment_t0(i)) * time.Millisecond * 10),
+		End:    int64(time.Duration(sd.ctx.Whisper_full_get_segment_t1(i)) * time.Millisecond * 10),
+		Tokens: tokens,
+	}
+
+	if d != nil {
+		segment.SpeakerTurn = d.turn(i)
+	}
+
+	return segment
+}
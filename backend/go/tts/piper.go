@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/base"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	piper "github.com/mudler/go-piper"
@@ -27,7 +29,17 @@ func (sd *Piper) Load(opts *pb.ModelOptions) error {
 	return err
 }
 
+// Capabilities reports what the backend supports with the loaded model
+func (sd *Piper) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityTTS}, nil
+}
+
 func (sd *Piper) TTS(opts *pb.TTSRequest) error {
+	// the voices of the models with multiple speakers are the speaker ids, the other voices (e.g. the
+	// OpenAI ones sent by the clients) use the default speaker
+	if speaker, err := strconv.ParseInt(opts.Voice, 10, 64); err == nil {
+		return sd.piper.TTSSpeaker(opts.Text, opts.Model, opts.Dst, speaker)
+	}
 	return sd.piper.TTS(opts.Text, opts.Model, opts.Dst)
 }
 
@@ -47,3 +59,7 @@ func New(assetDir string) (*PiperB, error) {
 func (s *PiperB) TTS(text, model, dst string) error {
 	return piper.TextToWav(text, model, s.assetDir, "", dst)
 }
+
+func (s *PiperB) TTSSpeaker(text, model, dst string, speaker int64) error {
+	return piper.TextToWavSpeaker(text, model, s.assetDir, "", dst, speaker)
+}
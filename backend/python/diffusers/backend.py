@@ -3,11 +3,14 @@ from concurrent import futures
 import traceback
 import argparse
 from collections import defaultdict
+from contextlib import contextmanager
 from enum import Enum
 import signal
 import sys
 import time
 import os
+import queue
+import threading
 
 from PIL import Image
 import torch
@@ -19,7 +22,7 @@ import grpc
 
 from diffusers import StableDiffusion3Pipeline, StableDiffusionXLPipeline, StableDiffusionDepth2ImgPipeline, DPMSolverMultistepScheduler, StableDiffusionPipeline, DiffusionPipeline, \
     EulerAncestralDiscreteScheduler, FluxPipeline, FluxTransformer2DModel
-from diffusers import StableDiffusionImg2ImgPipeline, AutoPipelineForText2Image, ControlNetModel, StableVideoDiffusionPipeline
+from diffusers import StableDiffusionImg2ImgPipeline, StableDiffusionInpaintPipeline, AutoPipelineForText2Image, ControlNetModel, StableVideoDiffusionPipeline
 from diffusers.pipelines.stable_diffusion import safety_checker
 from diffusers.utils import load_image, export_to_video
 from compel import Compel, ReturnedEmbeddingsType
@@ -143,11 +146,41 @@ def get_scheduler(name: str, config: dict = {}):
     return sched_class.from_config(config)
 
 
+@contextmanager
+def cudnn_deterministic(enabled):
+    """
+    Makes cuDNN deterministic while generating the images of a request, restoring its previous settings afterwards.
+    The settings are process-wide: with PYTHON_GRPC_MAX_WORKERS > 1, the concurrent requests are affected too.
+    """
+    if not enabled:
+        yield
+        return
+
+    deterministic, benchmark = torch.backends.cudnn.deterministic, torch.backends.cudnn.benchmark
+    torch.backends.cudnn.deterministic = True
+    torch.backends.cudnn.benchmark = False
+    try:
+        yield
+    finally:
+        torch.backends.cudnn.deterministic = deterministic
+        torch.backends.cudnn.benchmark = benchmark
+
+
 # Implement the BackendServicer class with the service methods
 class BackendServicer(backend_pb2_grpc.BackendServicer):
     def Health(self, request, context):
         return backend_pb2.Reply(message=bytes("OK", 'utf-8'))
 
+    def Capabilities(self, request, context):
+        """
+        Reports the features supported with the loaded model: the images are generated in batches,
+        with the progress of the diffusion steps, except for the video pipelines.
+        """
+        capabilities = ["image"]
+        if not self.img2vid and not self.txt2vid:
+            capabilities += ["image_batch", "image_progress"]
+        return backend_pb2.CapabilitiesResponse(capabilities=capabilities)
+
     def LoadModel(self, request, context):
         try:
             print(f"Loading model {request.Model}...", file=sys.stderr)
@@ -193,6 +226,15 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
                     self.pipe = StableDiffusionImg2ImgPipeline.from_pretrained(request.Model,
                                                                                torch_dtype=torchType)
 
+            ## inpainting
+            elif request.PipelineType == "StableDiffusionInpaintPipeline":
+                if fromSingleFile:
+                    self.pipe = StableDiffusionInpaintPipeline.from_single_file(modelFile,
+                                                                                torch_dtype=torchType)
+                else:
+                    self.pipe = StableDiffusionInpaintPipeline.from_pretrained(request.Model,
+                                                                               torch_dtype=torchType)
+
             elif request.PipelineType == "StableDiffusionDepth2ImgPipeline":
                 self.pipe = StableDiffusionDepth2ImgPipeline.from_pretrained(request.Model,
                                                                              torch_dtype=torchType)
@@ -381,6 +423,45 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
                 curr_layer.weight.data += multiplier * alpha * torch.mm(weight_up, weight_down)
 
     def GenerateImage(self, request, context):
+        with cudnn_deterministic(request.deterministic):
+            return self.generate_image(request)
+
+    def GenerateImageStream(self, request, context):
+        """
+        Generates the images of the request, streaming the diffusion steps as they complete.
+        """
+        images = max(len(request.dsts), 1)
+        steps = queue.Queue()
+        done = object()
+        errors = []
+
+        def on_step(step, total):
+            steps.put(backend_pb2.ImageProgress(step=step, steps=total, image=0, images=images))
+
+        def generate():
+            try:
+                with cudnn_deterministic(request.deterministic):
+                    self.generate_image(request, on_step)
+            except Exception as err:
+                errors.append(err)
+            finally:
+                steps.put(done)
+
+        threading.Thread(target=generate, daemon=True).start()
+        while True:
+            progress = steps.get()
+            if progress is done:
+                break
+            yield progress
+
+        if errors:
+            context.abort(grpc.StatusCode.INTERNAL, f"Error generating image: {errors[0]}")
+
+    def generate_image(self, request, on_step=None):
+        """
+        Generates the image of the request to dst, or one image for each of dsts in a single batch.
+        on_step is called with the step and the number of steps after each diffusion step.
+        """
 
         prompt = request.positive_prompt
 
@@ -404,6 +485,12 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
             pose_image = load_image(request.src)
             options["image"] = pose_image
 
+        if request.mask != "":
+            options["mask_image"] = Image.open(request.mask)
+
+        if request.strength > 0:
+            options["strength"] = request.strength
+
         if CLIPSKIP and self.clip_skip != 0:
             options["clip_skip"] = self.clip_skip
 
@@ -420,7 +507,7 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
         kwargs = {key: options[key] for key in keys}
 
         # Set seed
-        if request.seed > 0:
+        if request.seed > 0 or request.deterministic:
             kwargs["generator"] = torch.Generator(device=self.device).manual_seed(
                 request.seed
             )
@@ -428,6 +515,16 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
         if self.PipelineType == "FluxPipeline":
             kwargs["max_sequence_length"] = 256
 
+        dsts = list(request.dsts) or [request.dst]
+        if len(dsts) > 1:
+            kwargs["num_images_per_prompt"] = len(dsts)
+
+        if on_step is not None:
+            def callback_on_step_end(pipe, step, timestep, callback_kwargs):
+                on_step(step + 1, getattr(pipe, "num_timesteps", steps))
+                return callback_kwargs
+            kwargs["callback_on_step_end"] = callback_on_step_end
+
         if self.PipelineType == "FluxTransformer2DModel":
             kwargs["output_type"] = "pil"
             kwargs["generator"] = torch.Generator("cpu").manual_seed(0)
@@ -447,26 +544,27 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
             export_to_video(video_frames, request.dst)
             return backend_pb2.Result(message="Media generated successfully", success=True)
 
-        image = {}
+        images = []
         if COMPEL:
             conditioning, pooled = self.compel.build_conditioning_tensor(prompt)
             kwargs["prompt_embeds"] = conditioning
             kwargs["pooled_prompt_embeds"] = pooled
             # pass the kwargs dictionary to the self.pipe method
-            image = self.pipe(
+            images = self.pipe(
                 guidance_scale=self.cfg_scale,
                 **kwargs
-            ).images[0]
+            ).images
         else:
             # pass the kwargs dictionary to the self.pipe method
-            image = self.pipe(
+            images = self.pipe(
                 prompt,
                 guidance_scale=self.cfg_scale,
                 **kwargs
-            ).images[0]
+            ).images
 
-        # save the result
-        image.save(request.dst)
+        # save the results
+        for image, dst in zip(images, dsts):
+            image.save(dst)
 
         return backend_pb2.Result(message="Media generated", success=True)
 
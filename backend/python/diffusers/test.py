@@ -2,6 +2,7 @@
 A test script to test the gRPC service
 """
 import unittest
+import os
 import subprocess
 import time
 import backend_pb2
@@ -82,3 +83,26 @@ class TestBackendServicer(unittest.TestCase):
             self.fail("Image gen service failed")
         finally:
             self.tearDown()
+
+    def test_batch_progress(self):
+        """
+        This method tests if the backend can generate a batch of images, streaming the diffusion steps
+        """
+        time.sleep(10)
+        try:
+            self.setUp()
+            with grpc.insecure_channel("localhost:50051") as channel:
+                stub = backend_pb2_grpc.BackendStub(channel)
+                response = stub.LoadModel(backend_pb2.ModelOptions(Model="Lykon/dreamshaper-8"))
+                self.assertTrue(response.success)
+                capabilities = stub.Capabilities(backend_pb2.HealthMessage()).capabilities
+                self.assertIn("image_batch", capabilities)
+                image_req = backend_pb2.GenerateImageRequest(positive_prompt="cat", width=16, height=16, step=2, dst="test1.jpg", dsts=["test1.jpg", "test2.jpg"])
+                steps = [p.step for p in stub.GenerateImageStream(image_req)]
+                self.assertEqual(steps, [1, 2])
+                self.assertTrue(os.path.exists("test2.jpg"))
+        except Exception as err:
+            print(err)
+            self.fail("Image batch service failed")
+        finally:
+            self.tearDown()
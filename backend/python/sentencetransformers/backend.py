@@ -42,6 +42,19 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
         """
         return backend_pb2.Reply(message=bytes("OK", 'utf-8'))
 
+    def Capabilities(self, request, context):
+        """
+        A gRPC method that reports the features supported with the loaded model.
+
+        Args:
+            request: A HealthMessage object.
+            context: A grpc.ServicerContext object that provides information about the RPC.
+
+        Returns:
+            A CapabilitiesResponse object with the supported features.
+        """
+        return backend_pb2.CapabilitiesResponse(capabilities=["embed", "embed_batch"])
+
     def LoadModel(self, request, context):
         """
         A gRPC method that loads a model into memory.
@@ -80,6 +93,30 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
         sentence_embeddings = self.model.encode(request.Embeddings)
         return backend_pb2.EmbeddingResult(embeddings=sentence_embeddings)
 
+    def EmbeddingBatch(self, request, context):
+        """
+        A gRPC method that calculates the embeddings of several sentences in a single call to the model.
+
+        Args:
+            request: An EmbeddingBatchRequest object with the options of each sentence.
+            context: A grpc.ServicerContext object that provides information about the RPC.
+
+        Returns:
+            An EmbeddingBatchResult object with an EmbeddingResult per sentence, in the order of the sentences.
+        """
+        if any(len(r.EmbeddingTokens) > 0 for r in request.requests):
+            context.set_code(grpc.StatusCode.INVALID_ARGUMENT)
+            context.set_details("token inputs are not supported")
+            return backend_pb2.EmbeddingBatchResult()
+
+        sentences = [r.Embeddings for r in request.requests]
+        if len(sentences) == 0:
+            return backend_pb2.EmbeddingBatchResult()
+        sentence_embeddings = self.model.encode(sentences)
+        tokens = [int(m.sum()) for m in self.model.tokenize(sentences)["attention_mask"]]
+        results = [backend_pb2.EmbeddingResult(embeddings=e, tokens=t) for e, t in zip(sentence_embeddings, tokens)]
+        return backend_pb2.EmbeddingBatchResult(results=results)
+
 
 def serve(address):
     server = grpc.server(futures.ThreadPoolExecutor(max_workers=MAX_WORKERS))
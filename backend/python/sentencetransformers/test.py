@@ -78,4 +78,29 @@ class TestBackendServicer(unittest.TestCase):
             print(err)
             self.fail("Embedding service failed")
         finally:
-            self.tearDown()
\ No newline at end of file
+            self.tearDown()
+    def test_embedding_batch(self):
+        """
+        This method tests if the embeddings of a batch are returned in the order of the sentences
+        """
+        try:
+            self.setUp()
+            with grpc.insecure_channel("localhost:50051") as channel:
+                stub = backend_pb2_grpc.BackendStub(channel)
+                response = stub.LoadModel(backend_pb2.ModelOptions(Model="bert-base-nli-mean-tokens"))
+                self.assertTrue(response.success)
+                sentences = ["This is a test sentence.", "Another one, a bit longer than the first."]
+                batch_response = stub.EmbeddingBatch(backend_pb2.EmbeddingBatchRequest(
+                    requests=[backend_pb2.PredictOptions(Embeddings=s) for s in sentences]))
+                self.assertEqual(len(batch_response.results), 2)
+                for sentence, result in zip(sentences, batch_response.results):
+                    single = stub.Embedding(backend_pb2.PredictOptions(Embeddings=sentence))
+                    self.assertEqual(len(result.embeddings), len(single.embeddings))
+                    for a, b in zip(result.embeddings, single.embeddings):
+                        self.assertAlmostEqual(a, b, places=4)
+                    self.assertGreater(result.tokens, 0)
+        except Exception as err:
+            print(err)
+            self.fail("EmbeddingBatch service failed")
+        finally:
+            self.tearDown()
@@ -41,6 +41,29 @@ def mean_pooling(model_output, attention_mask):
     sum_mask = torch.clamp(input_mask_expanded.sum(1), min=1e-9)
     return sum_embeddings / sum_mask
 
+
+def cls_pooling(model_output, attention_mask):
+    """
+    CLS pooling, the embedding of the first token, which the BERT-like models are trained to pool into.
+    """
+    return model_output[0][:, 0]
+
+
+def last_token_pooling(model_output, attention_mask):
+    """
+    Last token pooling, the embedding of the last token before the padding, for the decoder models.
+    """
+    token_embeddings = model_output[0]
+    last = attention_mask.sum(dim=1) - 1
+    return token_embeddings[torch.arange(token_embeddings.size(0)), last]
+
+
+POOLING = {
+    "mean": mean_pooling,
+    "cls": cls_pooling,
+    "last": last_token_pooling,
+}
+
 # Implement the BackendServicer class with the service methods
 class BackendServicer(backend_pb2_grpc.BackendServicer):
     """
@@ -61,6 +84,41 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
         """
         return backend_pb2.Reply(message=bytes("OK", 'utf-8'))
 
+    def SetThreads(self, request, context):
+        """
+        A gRPC method that changes the number of threads used by torch on CPU, without loading the model again.
+
+        Args:
+            request: A SetThreadsRequest object that contains the number of threads.
+            context: A grpc.ServicerContext object that provides information about the RPC.
+
+        Returns:
+            A Result object that indicates whether the threads were set.
+        """
+        if request.threads <= 0:
+            return backend_pb2.Result(success=False, message="threads must be positive")
+        torch.set_num_threads(request.threads)
+        return backend_pb2.Result(success=True, message="Threads set")
+
+    def Capabilities(self, request, context):
+        """
+        A gRPC method that reports the features supported with the loaded model, depending on its type.
+
+        Args:
+            request: A HealthMessage object.
+            context: A grpc.ServicerContext object that provides information about the RPC.
+
+        Returns:
+            A CapabilitiesResponse object with the supported features.
+        """
+        if self.model_type in ("AutoModelForCausalLM", "OVModelForCausalLM"):
+            capabilities = ["generate"]
+        elif self.model_type in ("AutoModelForSequenceClassification", "AutoModelForImageClassification"):
+            capabilities = []
+        else:
+            capabilities = ["embed", "embed_batch"]
+        return backend_pb2.CapabilitiesResponse(capabilities=capabilities)
+
     def LoadModel(self, request, context):
         """
         A gRPC method that loads a model into memory.
@@ -80,6 +138,7 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
 
         self.CUDA = torch.cuda.is_available()
         self.OV=False
+        self.model_type = request.Type
 
         device_map="cpu"
 
@@ -186,6 +245,21 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
                                                                 export=True,
                                                                 device=device_map)
                 self.OV = True
+            elif request.Type == "AutoModelForSequenceClassification":
+                from transformers import AutoModelForSequenceClassification
+                self.model = AutoModelForSequenceClassification.from_pretrained(model_name,
+                                                                                trust_remote_code=request.TrustRemoteCode,
+                                                                                use_safetensors=True,
+                                                                                device_map=device_map,
+                                                                                torch_dtype=compute)
+            elif request.Type == "AutoModelForImageClassification":
+                from transformers import AutoModelForImageClassification, AutoImageProcessor
+                self.model = AutoModelForImageClassification.from_pretrained(model_name,
+                                                                             trust_remote_code=request.TrustRemoteCode,
+                                                                             use_safetensors=True,
+                                                                             device_map=device_map,
+                                                                             torch_dtype=compute)
+                self.processor = AutoImageProcessor.from_pretrained(model_name)
             else:
                 print("Automodel", file=sys.stderr)
                 self.model = AutoModel.from_pretrained(model_name, 
@@ -197,9 +271,12 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
             if request.ContextSize > 0:
                 self.max_tokens = request.ContextSize
             else:
-                self.max_tokens = self.model.config.max_position_embeddings
- 
-            self.tokenizer = AutoTokenizer.from_pretrained(model_name, use_safetensors=True)
+                # the image classifiers have no context
+                self.max_tokens = getattr(self.model.config, "max_position_embeddings", 0)
+
+            # the image classifiers read their inputs with the image processor
+            if request.Type != "AutoModelForImageClassification":
+                self.tokenizer = AutoTokenizer.from_pretrained(model_name, use_safetensors=True)
             self.XPU = False
 
             if XPU and self.OV == False:
@@ -244,8 +321,98 @@ class BackendServicer(backend_pb2_grpc.BackendServicer):
             model_output = self.model(**encoded_input)
 
         # Pool to get sentence embeddings; i.e. generate one 1024 vector for the entire sentence
-        sentence_embeddings = mean_pooling(model_output, encoded_input['attention_mask'])
-        return backend_pb2.EmbeddingResult(embeddings=sentence_embeddings[0])
+        pooling = POOLING.get(request.Pooling or "mean")
+        if pooling is None:
+            context.set_code(grpc.StatusCode.INVALID_ARGUMENT)
+            context.set_details(f"unsupported pooling {request.Pooling}")
+            return backend_pb2.EmbeddingResult()
+        sentence_embeddings = pooling(model_output, encoded_input['attention_mask'])
+        return backend_pb2.EmbeddingResult(embeddings=sentence_embeddings[0], tokens=int(encoded_input['attention_mask'][0].sum()))
+
+    def EmbeddingBatch(self, request, context):
+        """
+        A gRPC method that calculates the embeddings of several inputs in a single forward pass.
+
+        Args:
+            request: An EmbeddingBatchRequest object with the options of each input, either a string
+                     or a list of tokens. The seed, the pooling and the maximum length of the first one apply to all.
+            context: A grpc.ServicerContext object that provides information about the RPC.
+
+        Returns:
+            An EmbeddingBatchResult object with an EmbeddingResult per input, in the order of the inputs.
+        """
+        if len(request.requests) == 0:
+            return backend_pb2.EmbeddingBatchResult()
+        first = request.requests[0]
+
+        pooling = POOLING.get(first.Pooling or "mean")
+        if pooling is None:
+            context.set_code(grpc.StatusCode.INVALID_ARGUMENT)
+            context.set_details(f"unsupported pooling {first.Pooling}")
+            return backend_pb2.EmbeddingBatchResult()
+
+        set_seed(first.Seed)
+        max_length = 512
+        if first.Tokens != 0:
+            max_length = first.Tokens
+
+        # the string inputs are tokenized, the token inputs are used as they are, then all are padded together
+        input_ids = []
+        for r in request.requests:
+            if len(r.EmbeddingTokens) > 0:
+                input_ids.append(list(r.EmbeddingTokens))
+            else:
+                input_ids.append(self.tokenizer(r.Embeddings, truncation=True, max_length=max_length)["input_ids"])
+        encoded_input = self.tokenizer.pad({"input_ids": input_ids}, padding=True, return_tensors="pt")
+
+        if self.CUDA:
+            encoded_input = encoded_input.to("cuda")
+
+        with torch.no_grad():
+            model_output = self.model(**encoded_input)
+
+        attention_mask = encoded_input['attention_mask']
+        sentence_embeddings = pooling(model_output, attention_mask)
+        results = [backend_pb2.EmbeddingResult(embeddings=e, tokens=int(m.sum())) for e, m in zip(sentence_embeddings, attention_mask)]
+        return backend_pb2.EmbeddingBatchResult(results=results)
+
+    def Classify(self, request, context):
+        """
+        A gRPC method that scores each input against the labels of a sequence classification model,
+        or each image against the labels of an image classification model.
+
+        Args:
+            request: A ClassifyRequest object that contains the inputs or the image files to classify.
+            context: A grpc.ServicerContext object that provides information about the RPC.
+
+        Returns:
+            A ClassifyResult object with one prediction (label scores) per input.
+        """
+        if len(request.images) > 0:
+            # image classifiers score the image files instead of the text inputs
+            from PIL import Image
+            images = [Image.open(path).convert("RGB") for path in request.images]
+            encoded_input = self.processor(images=images, return_tensors="pt")
+        else:
+            encoded_input = self.tokenizer(list(request.inputs), padding=True, truncation=True, max_length=self.max_tokens, return_tensors="pt")
+        if self.CUDA:
+            encoded_input = encoded_input.to("cuda")
+
+        with torch.no_grad():
+            logits = self.model(**encoded_input).logits
+
+        # multi-label classifiers score every label independently
+        if self.model.config.problem_type == "multi_label_classification":
+            scores = torch.sigmoid(logits)
+        else:
+            scores = torch.softmax(logits, dim=-1)
+
+        id2label = self.model.config.id2label
+        predictions = []
+        for row in scores.tolist():
+            labels = [backend_pb2.ClassifyLabel(label=id2label[i], score=score) for i, score in enumerate(row)]
+            predictions.append(backend_pb2.ClassifyPrediction(labels=labels))
+        return backend_pb2.ClassifyResult(predictions=predictions)
 
     async def _predict(self, request, context, streaming=False): 
         set_seed(request.Seed)
@@ -0,0 +1,18 @@
+package backend
+
+import (
+	"github.com/mudler/LocalAI/core/config"
+	model "github.com/mudler/LocalAI/pkg/model"
+)
+
+// CheckCapability returns an error wrapping model.ErrUnsupportedCapability if the backend of the loaded
+// model reports not supporting the capability, so that the request fails early with a clear message
+// rather than with an error of the backend
+func CheckCapability(loader *model.ModelLoader, c config.BackendConfig, capability string) error {
+	return loader.CheckCapability(modelID(c), capability)
+}
+
+// ReportsCapability reports whether the backend of the model reports an optional capability
+func ReportsCapability(loader *model.ModelLoader, c config.BackendConfig, capability string) bool {
+	return loader.ReportsCapability(modelID(c), capability)
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/grpc/proto"
+	model "github.com/mudler/LocalAI/pkg/model"
+)
+
+// Classify scores each input against the labels of a classifier model,
+// returning one prediction per input in the same order
+func Classify(ctx context.Context, inputs []string, loader *model.ModelLoader, appConfig *config.ApplicationConfig, backendConfig config.BackendConfig) (*proto.ClassifyResult, error) {
+	return classify(ctx, &proto.ClassifyRequest{Inputs: inputs}, len(inputs), loader, appConfig, backendConfig)
+}
+
+// ClassifyImages scores each image file against the labels of an image classifier model,
+// returning one prediction per image in the same order
+func ClassifyImages(ctx context.Context, images []string, loader *model.ModelLoader, appConfig *config.ApplicationConfig, backendConfig config.BackendConfig) (*proto.ClassifyResult, error) {
+	return classify(ctx, &proto.ClassifyRequest{Images: images}, len(images), loader, appConfig, backendConfig)
+}
+
+func classify(ctx context.Context, request *proto.ClassifyRequest, inputs int, loader *model.ModelLoader, appConfig *config.ApplicationConfig, backendConfig config.BackendConfig) (*proto.ClassifyResult, error) {
+
+	opts := ModelOptions(backendConfig, appConfig, []model.Option{model.WithModel(backendConfig.Model)})
+	classifyModel, err := loadModel(ctx, backendConfig, loader.BackendLoader, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if classifyModel == nil {
+		return nil, fmt.Errorf("could not load classifier model")
+	}
+
+	res, err := classifyModel.Classify(ctx, request)
+	reportInference(loader, backendConfig, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.GetPredictions()) != inputs {
+		return nil, fmt.Errorf("classifier returned %d predictions for %d inputs", len(res.GetPredictions()), inputs)
+	}
+
+	return res, nil
+}
@@ -1,31 +1,314 @@
 package backend
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"math"
+	"slices"
+	"strings"
 
 	"github.com/mudler/LocalAI/core/config"
 
 	"github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/grpc/proto"
 	model "github.com/mudler/LocalAI/pkg/model"
 )
 
-func ModelEmbedding(s string, tokens []int, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (func() ([]float32, error), error) {
-
-	var inferenceModel interface{}
-	var err error
-
+func loadEmbeddingModel(ctx context.Context, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (interface{}, error) {
 	opts := ModelOptions(backendConfig, appConfig, []model.Option{})
 
+	var inferenceModel grpc.Backend
+	var err error
 	if backendConfig.Backend == "" {
-		inferenceModel, err = loader.GreedyLoader(opts...)
+		inferenceModel, err = loadModel(ctx, backendConfig, loader.GreedyLoader, opts...)
 	} else {
 		opts = append(opts, model.WithBackendString(backendConfig.Backend))
-		inferenceModel, err = loader.BackendLoader(opts...)
+		inferenceModel, err = loadModel(ctx, backendConfig, loader.BackendLoader, opts...)
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	if err := CheckCapability(loader, backendConfig, grpc.CapabilityEmbed); err != nil {
+		return nil, err
+	}
+	return inferenceModel, nil
+}
+
+func ModelEmbedding(ctx context.Context, s string, tokens []int, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (func() ([]float32, error), error) {
+	inferenceModel, err := loadEmbeddingModel(ctx, loader, backendConfig, appConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return embeddingFunc(ctx, inferenceModel, s, tokens, loader, backendConfig, appConfig), nil
+}
+
+// The truncation strategies of the embedding inputs over the context of the model
+const (
+	// TruncateNone sends the inputs as they are, the backend fails on the inputs over the context
+	TruncateNone = "none"
+	// TruncateStart drops the tokens over the context from the start of the inputs
+	TruncateStart = "start"
+	// TruncateEnd drops the tokens over the context from the end of the inputs
+	TruncateEnd = "end"
+)
+
+// The pooling strategies of the embeddings
+const (
+	PoolingMean = "mean"
+	PoolingCLS  = "cls"
+	PoolingLast = "last"
+)
+
+// transformersBackend is the only backend pooling the embeddings as set by each request
+const transformersBackend = "transformers"
+
+// EmbeddingPooling returns the pooling of an embeddings request, the pooling of the request or else
+// the one of the model config, empty for the default of the backend. llama.cpp pools as set when
+// loading the model, so its requests can only use the pooling of the config, and the other backends
+// than llama.cpp and transformers do not support choosing the pooling.
+func EmbeddingPooling(c config.BackendConfig, pooling string) (string, error) {
+	pooling = cmp.Or(pooling, c.Pooling)
+	if pooling == "" {
+		return "", nil
+	}
+	if !slices.Contains([]string{PoolingMean, PoolingCLS, PoolingLast}, pooling) {
+		return "", fmt.Errorf("invalid pooling %q, should be mean, cls or last", pooling)
+	}
+
+	backend := c.Backend
+	if alias, exists := model.Aliases[backend]; exists {
+		backend = alias
+	}
+	switch {
+	case backend == "" || strings.HasPrefix(backend, model.LLamaCPP):
+		if pooling != c.Pooling {
+			return "", fmt.Errorf("the llama.cpp backend pools as set when loading the model, set pooling to %s in the model config", pooling)
+		}
+	case backend != transformersBackend:
+		return "", fmt.Errorf("pooling is not supported by the %s backend", backend)
+	}
+	return pooling, nil
+}
+
+// ValidTruncation reports whether truncate is a truncation strategy, the empty one being TruncateNone
+func ValidTruncation(truncate string) bool {
+	switch truncate {
+	case "", TruncateNone, TruncateStart, TruncateEnd:
+		return true
+	}
+	return false
+}
+
+// TruncateTokens drops the tokens over limit from the side of tokens given by truncate, and returns
+// the tokens left and the number of tokens dropped
+func TruncateTokens(tokens []int, limit int, truncate string) ([]int, int) {
+	if truncate == "" || truncate == TruncateNone || limit <= 0 || len(tokens) <= limit {
+		return tokens, 0
+	}
+	dropped := len(tokens) - limit
+	if truncate == TruncateStart {
+		return tokens[dropped:], dropped
+	}
+	return tokens[:limit], dropped
+}
+
+// ValidEmbeddingDimensions checks that the embeddings of the model can be reduced to the requested
+// dimensions, 0 keeping all the dimensions of the model
+func ValidEmbeddingDimensions(c config.BackendConfig, dimensions int) error {
+	switch {
+	case dimensions < 0:
+		return fmt.Errorf("dimensions must be a positive number, got %d", dimensions)
+	case dimensions > 0 && !c.Matryoshka:
+		return fmt.Errorf("the model %q does not support reducing the dimensions of its embeddings, set embeddings_matryoshka in the model config if it was trained for it", c.Name)
+	}
+	return nil
+}
+
+// ReduceEmbeddingDimensions keeps the first dimensions of the embeddings of a Matryoshka model, and
+// normalizes them back to unit length. The embeddings smaller than dimensions are an error.
+func ReduceEmbeddingDimensions(embeddings [][]float32, dimensions int) ([][]float32, error) {
+	if dimensions == 0 {
+		return embeddings, nil
+	}
+	reduced := make([][]float32, len(embeddings))
+	for i, e := range embeddings {
+		if dimensions > len(e) {
+			return nil, fmt.Errorf("dimensions %d exceeds the %d dimensions of the embeddings of the model", dimensions, len(e))
+		}
+		var norm float64
+		for _, v := range e[:dimensions] {
+			norm += float64(v) * float64(v)
+		}
+		norm = math.Sqrt(norm)
+
+		reduced[i] = make([]float32, dimensions)
+		for j, v := range e[:dimensions] {
+			if norm > 0 {
+				v = float32(float64(v) / norm)
+			}
+			reduced[i][j] = v
+		}
+	}
+	return reduced, nil
+}
+
+// EmbeddingsResult holds the embeddings computed by ModelEmbeddingBatch, in the order of its inputs
+type EmbeddingsResult struct {
+	Embeddings [][]float32
+	// Truncated is the number of tokens dropped from each input
+	Truncated []int
+	// Tokens is the number of tokens embedded: the tokens of the token inputs, and the tokens of the
+	// strings as reported by the backend
+	Tokens int
+}
+
+// ModelEmbeddingBatch computes the embeddings of all the given inputs, either strings or
+// lists of tokens, loading the model only once. The token inputs come first in the result.
+// The backends reporting the embed_batch capability embed the inputs in batches of up to
+// embeddings_batch_size inputs (all of them when it is not set), the others one at a time.
+// The inputs over the context size of the model are truncated according to truncate, the
+// strings being tokenized by the model.
+func ModelEmbeddingBatch(ctx context.Context, inputs []string, tokens [][]int, truncate string, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (*EmbeddingsResult, error) {
+	inferenceModel, err := loadEmbeddingModel(ctx, loader, backendConfig, appConfig)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := inferenceModel.(grpc.Backend)
+	if !ok {
+		return nil, fmt.Errorf("embeddings not supported by the backend")
+	}
+
+	limit := 0
+	if backendConfig.ContextSize != nil {
+		limit = *backendConfig.ContextSize
+	}
+
+	requests := make([]*proto.PredictOptions, 0, len(inputs)+len(tokens))
+	result := &EmbeddingsResult{Truncated: make([]int, 0, len(inputs)+len(tokens))}
+	// the number of tokens of each input, when known before embedding it
+	counts := make([]int, 0, len(inputs)+len(tokens))
+	for _, t := range tokens {
+		t, dropped := TruncateTokens(t, limit, truncate)
+		requests = append(requests, embeddingRequest(backendConfig, loader, "", t))
+		result.Truncated = append(result.Truncated, dropped)
+		counts = append(counts, len(t))
+	}
+	for _, s := range inputs {
+		t, dropped, err := truncateString(ctx, b, s, limit, truncate, backendConfig, loader)
+		if err != nil {
+			return nil, err
+		}
+		if dropped > 0 {
+			// the tokens left are embedded, rather than the string they would be detokenized to
+			requests = append(requests, embeddingRequest(backendConfig, loader, "", t))
+			counts = append(counts, len(t))
+		} else {
+			requests = append(requests, embeddingRequest(backendConfig, loader, s, nil))
+			counts = append(counts, 0)
+		}
+		result.Truncated = append(result.Truncated, dropped)
+	}
+
+	batch := ReportsCapability(loader, backendConfig, grpc.CapabilityEmbedBatch)
+	results, err := EmbedRequests(ctx, b, requests, batch, backendConfig.EmbeddingsBatchSize)
+	reportInference(loader, backendConfig, err)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, res := range results {
+		result.Embeddings = append(result.Embeddings, trimEmbeddings(res.Embeddings))
+		if counts[i] > 0 {
+			result.Tokens += counts[i]
+		} else {
+			result.Tokens += int(res.Tokens)
+		}
+	}
+	return result, nil
+}
+
+// EmbedRequests computes the embeddings of the requests, in the order of the requests. With batch, the
+// requests are sent in EmbeddingBatch calls of up to batchSize requests, all of them when batchSize is
+// not positive, otherwise they are sent one at a time. The error of a request names its index.
+func EmbedRequests(ctx context.Context, b grpc.Backend, requests []*proto.PredictOptions, batch bool, batchSize int) ([]*proto.EmbeddingResult, error) {
+	results := make([]*proto.EmbeddingResult, 0, len(requests))
+	if !batch {
+		for i, r := range requests {
+			res, err := b.Embeddings(ctx, r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to embed input %d: %w", i, err)
+			}
+			results = append(results, res)
+		}
+		return results, nil
+	}
+
+	if batchSize <= 0 {
+		batchSize = len(requests)
+	}
+	for start := 0; start < len(requests); start += batchSize {
+		end := min(start+batchSize, len(requests))
+		res, err := b.EmbeddingBatch(ctx, &proto.EmbeddingBatchRequest{Requests: requests[start:end]})
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed inputs %d to %d: %w", start, end-1, err)
+		}
+		if len(res.Results) != end-start {
+			return nil, fmt.Errorf("the backend returned %d embeddings for inputs %d to %d", len(res.Results), start, end-1)
+		}
+		results = append(results, res.Results...)
+	}
+	return results, nil
+}
+
+// embeddingRequest returns the options of the embeddings of the string s, or of tokens when s is empty
+func embeddingRequest(backendConfig config.BackendConfig, loader *model.ModelLoader, s string, tokens []int) *proto.PredictOptions {
+	predictOptions := gRPCPredictOpts(backendConfig, loader.ModelPath)
+	if s != "" || len(tokens) == 0 {
+		predictOptions.Embeddings = s
+		return predictOptions
+	}
+	predictOptions.EmbeddingTokens = make([]int32, len(tokens))
+	for i, t := range tokens {
+		predictOptions.EmbeddingTokens[i] = int32(t)
+	}
+	return predictOptions
+}
+
+// trimEmbeddings removes the trailing zeros of the embeddings
+func trimEmbeddings(embeds []float32) []float32 {
+	for i := len(embeds) - 1; i >= 0; i-- {
+		if embeds[i] != 0.0 {
+			return embeds[:i+1]
+		}
+	}
+	return embeds[:0]
+}
+
+// truncateString tokenizes s with the model when it has to be truncated, and returns its tokens
+// left and the number of tokens dropped, if any
+func truncateString(ctx context.Context, b grpc.Backend, s string, limit int, truncate string, backendConfig config.BackendConfig, loader *model.ModelLoader) ([]int, int, error) {
+	if truncate == "" || truncate == TruncateNone || limit <= 0 {
+		return nil, 0, nil
+	}
+
+	predictOptions := gRPCPredictOpts(backendConfig, loader.ModelPath)
+	predictOptions.Prompt = s
+	res, err := b.TokenizeString(ctx, predictOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed tokenizing the input to truncate it: %w", err)
+	}
+	tokens := make([]int, len(res.Tokens))
+	for i, t := range res.Tokens {
+		tokens[i] = int(t)
+	}
+	tokens, dropped := TruncateTokens(tokens, limit, truncate)
+	return tokens, dropped, nil
+}
+
+func embeddingFunc(ctx context.Context, inferenceModel interface{}, s string, tokens []int, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) func() ([]float32, error) {
 	var fn func() ([]float32, error)
 	switch model := inferenceModel.(type) {
 	case grpc.Backend:
@@ -39,7 +322,8 @@ func ModelEmbedding(s string, tokens []int, loader *model.ModelLoader, backendCo
 				}
 				predictOptions.EmbeddingTokens = embeds
 
-				res, err := model.Embeddings(appConfig.Context, predictOptions)
+				res, err := model.Embeddings(ctx, predictOptions)
+				reportInference(loader, backendConfig, err)
 				if err != nil {
 					return nil, err
 				}
@@ -48,7 +332,8 @@ func ModelEmbedding(s string, tokens []int, loader *model.ModelLoader, backendCo
 			}
 			predictOptions.Embeddings = s
 
-			res, err := model.Embeddings(appConfig.Context, predictOptions)
+			res, err := model.Embeddings(ctx, predictOptions)
+			reportInference(loader, backendConfig, err)
 			if err != nil {
 				return nil, err
 			}
@@ -66,14 +351,6 @@ func ModelEmbedding(s string, tokens []int, loader *model.ModelLoader, backendCo
 		if err != nil {
 			return embeds, err
 		}
-		// Remove trailing 0s
-		for i := len(embeds) - 1; i >= 0; i-- {
-			if embeds[i] == 0.0 {
-				embeds = embeds[:i]
-			} else {
-				break
-			}
-		}
-		return embeds, nil
-	}, nil
+		return trimEmbeddings(embeds), nil
+	}
 }
@@ -0,0 +1,196 @@
+package backend_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/grpc/base"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/mudler/LocalAI/pkg/model"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Embeddings truncation", func() {
+	tokens := []int{1, 2, 3, 4, 5}
+
+	It("drops the tokens over the limit from the chosen side", func() {
+		left, dropped := TruncateTokens(tokens, 3, TruncateStart)
+		Expect(left).To(Equal([]int{3, 4, 5}))
+		Expect(dropped).To(Equal(2))
+
+		left, dropped = TruncateTokens(tokens, 3, TruncateEnd)
+		Expect(left).To(Equal([]int{1, 2, 3}))
+		Expect(dropped).To(Equal(2))
+	})
+	It("keeps the inputs within the limit or without truncation", func() {
+		for _, truncate := range []string{"", TruncateNone} {
+			left, dropped := TruncateTokens(tokens, 3, truncate)
+			Expect(left).To(Equal(tokens))
+			Expect(dropped).To(BeZero())
+		}
+		left, dropped := TruncateTokens(tokens, 5, TruncateEnd)
+		Expect(left).To(Equal(tokens))
+		Expect(dropped).To(BeZero())
+	})
+	It("validates the truncation strategies", func() {
+		Expect(ValidTruncation("")).To(BeTrue())
+		Expect(ValidTruncation(TruncateStart)).To(BeTrue())
+		Expect(ValidTruncation("middle")).To(BeFalse())
+	})
+})
+
+var _ = Describe("Embeddings pooling", func() {
+	It("defaults to the pooling of the config", func() {
+		pooling, err := EmbeddingPooling(config.BackendConfig{Backend: "llama-cpp", Pooling: PoolingCLS}, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pooling).To(Equal(PoolingCLS))
+
+		pooling, err = EmbeddingPooling(config.BackendConfig{Backend: "bert-embeddings"}, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pooling).To(BeEmpty())
+	})
+	It("only lets the requests choose the pooling of the backends pooling per request", func() {
+		pooling, err := EmbeddingPooling(config.BackendConfig{Backend: "transformers", Pooling: PoolingMean}, PoolingLast)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pooling).To(Equal(PoolingLast))
+
+		_, err = EmbeddingPooling(config.BackendConfig{Backend: "llama", Pooling: PoolingMean}, PoolingLast)
+		Expect(err).To(MatchError(ContainSubstring("set pooling to last in the model config")))
+
+		_, err = EmbeddingPooling(config.BackendConfig{Backend: "sentencetransformers"}, PoolingMean)
+		Expect(err).To(MatchError(ContainSubstring("not supported by the sentencetransformers backend")))
+	})
+	It("rejects the unknown poolings", func() {
+		_, err := EmbeddingPooling(config.BackendConfig{Backend: "transformers"}, "max")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Embeddings dimensions", func() {
+	It("only reduces the embeddings of the Matryoshka models", func() {
+		Expect(ValidEmbeddingDimensions(config.BackendConfig{}, 0)).To(Succeed())
+		Expect(ValidEmbeddingDimensions(config.BackendConfig{Matryoshka: true}, 256)).To(Succeed())
+		Expect(ValidEmbeddingDimensions(config.BackendConfig{Name: "bert"}, 256)).To(MatchError(ContainSubstring(`the model "bert" does not support reducing the dimensions`)))
+		Expect(ValidEmbeddingDimensions(config.BackendConfig{Matryoshka: true}, -1)).To(HaveOccurred())
+	})
+	It("keeps the first dimensions and normalizes them", func() {
+		reduced, err := ReduceEmbeddingDimensions([][]float32{{3, 4, 12}, {0, 0, 1}}, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reduced).To(HaveLen(2))
+		Expect(reduced[0]).To(HaveLen(2))
+		Expect(reduced[0][0]).To(BeNumerically("~", 0.6, 1e-6))
+		Expect(reduced[0][1]).To(BeNumerically("~", 0.8, 1e-6))
+		// a zero vector is kept as it is
+		Expect(reduced[1]).To(Equal([]float32{0, 0}))
+
+		embeddings := [][]float32{{1, 2}}
+		reduced, err = ReduceEmbeddingDimensions(embeddings, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reduced).To(Equal(embeddings))
+
+		_, err = ReduceEmbeddingDimensions(embeddings, 3)
+		Expect(err).To(MatchError("dimensions 3 exceeds the 2 dimensions of the embeddings of the model"))
+	})
+})
+
+// embeddingLLM embeds an input as its length, in batches when batch is set, and fails to embed "fail"
+type embeddingLLM struct {
+	base.SingleThread
+	batch bool
+	calls []int
+}
+
+func (llm *embeddingLLM) Load(opts *pb.ModelOptions) error {
+	return nil
+}
+
+func (llm *embeddingLLM) Capabilities() ([]string, error) {
+	if llm.batch {
+		return []string{grpc.CapabilityEmbed, grpc.CapabilityEmbedBatch}, nil
+	}
+	return []string{grpc.CapabilityEmbed}, nil
+}
+
+func (llm *embeddingLLM) embed(opts *pb.PredictOptions) ([]float32, error) {
+	if opts.Embeddings == "fail" {
+		return nil, fmt.Errorf("cannot embed %q", opts.Embeddings)
+	}
+	if len(opts.EmbeddingTokens) > 0 {
+		return []float32{float32(len(opts.EmbeddingTokens)), 1}, nil
+	}
+	return []float32{float32(len(opts.Embeddings)), 1}, nil
+}
+
+func (llm *embeddingLLM) Embeddings(opts *pb.PredictOptions) ([]float32, error) {
+	llm.calls = append(llm.calls, 1)
+	return llm.embed(opts)
+}
+
+func (llm *embeddingLLM) EmbeddingBatch(opts []*pb.PredictOptions) ([][]float32, error) {
+	llm.calls = append(llm.calls, len(opts))
+	embeddings := make([][]float32, 0, len(opts))
+	for _, o := range opts {
+		e, err := llm.embed(o)
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, e)
+	}
+	return embeddings, nil
+}
+
+var _ = Describe("Embeddings batches", func() {
+	embed := func(llm *embeddingLLM, name string, batchSize int, inputs []string, tokens [][]int) (*EmbeddingsResult, error) {
+		grpc.Provide("embeddings-batch-test-"+name, llm)
+		appConfig := config.NewApplicationConfig(
+			config.WithContext(context.Background()),
+			config.WithExternalBackend("embedder", "embeddings-batch-test-"+name),
+		)
+		cfg := config.BackendConfig{Name: "embedder", Backend: "embedder", EmbeddingsBatchSize: batchSize}
+		cfg.Model = "embedder.model"
+		cfg.SetDefaults()
+		return ModelEmbeddingBatch(context.Background(), inputs, tokens, "", model.NewModelLoader(GinkgoT().TempDir()), cfg, appConfig)
+	}
+	inputs := []string{"a", "bb", "ccc"}
+	tokens := [][]int{{1, 2, 3, 4}, {5}}
+	expected := [][]float32{{4, 1}, {1, 1}, {1, 1}, {2, 1}, {3, 1}}
+
+	It("embeds the inputs in batches, in order", func() {
+		llm := &embeddingLLM{batch: true}
+		result, err := embed(llm, "batch", 2, inputs, tokens)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(llm.calls).To(Equal([]int{2, 2, 1}))
+		Expect(result.Embeddings).To(Equal(expected))
+		Expect(result.Truncated).To(Equal([]int{0, 0, 0, 0, 0}))
+		// the tokens of the token inputs, the backend does not report the tokens of the strings
+		Expect(result.Tokens).To(Equal(5))
+	})
+	It("embeds all the inputs in a batch without a batch size", func() {
+		llm := &embeddingLLM{batch: true}
+		result, err := embed(llm, "all", 0, inputs, tokens)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(llm.calls).To(Equal([]int{5}))
+		Expect(result.Embeddings).To(Equal(expected))
+	})
+	It("embeds the inputs one at a time when the backend does not batch them", func() {
+		llm := &embeddingLLM{}
+		result, err := embed(llm, "single", 2, inputs, tokens)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(llm.calls).To(Equal([]int{1, 1, 1, 1, 1}))
+		Expect(result.Embeddings).To(Equal(expected))
+		Expect(result.Tokens).To(Equal(5))
+	})
+	It("reports the inputs that failed", func() {
+		_, err := embed(&embeddingLLM{}, "single-error", 0, []string{"a", "fail", "b"}, nil)
+		Expect(err).To(MatchError(ContainSubstring("failed to embed input 1")))
+		Expect(err).To(MatchError(ContainSubstring(`cannot embed "fail"`)))
+
+		_, err = embed(&embeddingLLM{batch: true}, "batch-error", 2, []string{"a", "b", "fail"}, nil)
+		Expect(err).To(MatchError(ContainSubstring("failed to embed inputs 2 to 2")))
+	})
+})
@@ -1,41 +1,100 @@
 package backend
 
 import (
+	"context"
+
 	"github.com/mudler/LocalAI/core/config"
 
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/proto"
 	model "github.com/mudler/LocalAI/pkg/model"
 )
 
-func ImageGeneration(height, width, mode, step, seed int, positive_prompt, negative_prompt, src, dst string, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (func() error, error) {
+// ImageProgress reports a diffusion step of the images generated by ImageGeneration. The steps of a
+// batch apply to all its images at once.
+type ImageProgress struct {
+	// Image is the index in the destinations of the first image the step applies to
+	Image  int
+	Images int
+	Step   int
+	Steps  int
+}
+
+// ImageGeneration returns a function generating an image for each of the dsts. The backends reporting
+// the image_batch capability generate them in a single batch, the others one at a time.
+//
+// When progress is not nil it is called for each diffusion step by the backends reporting the
+// image_progress capability, and once each image is complete by the others.
+func ImageGeneration(ctx context.Context, height, width, mode, step int, strength float32, positive_prompt, negative_prompt, src, mask string, dsts []string, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig, progress func(ImageProgress)) (func() error, error) {
 
 	opts := ModelOptions(backendConfig, appConfig, []model.Option{})
 
-	inferenceModel, err := loader.BackendLoader(
-		opts...,
-	)
+	inferenceModel, err := loadModel(ctx, backendConfig, loader.BackendLoader, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckCapability(loader, backendConfig, grpc.CapabilityImage); err != nil {
+		return nil, err
+	}
 
-	fn := func() error {
-		_, err := inferenceModel.GenerateImage(
-			appConfig.Context,
-			&proto.GenerateImageRequest{
-				Height:           int32(height),
-				Width:            int32(width),
-				Mode:             int32(mode),
-				Step:             int32(step),
-				Seed:             int32(seed),
-				CLIPSkip:         int32(backendConfig.Diffusers.ClipSkip),
-				PositivePrompt:   positive_prompt,
-				NegativePrompt:   negative_prompt,
-				Dst:              dst,
-				Src:              src,
-				EnableParameters: backendConfig.Diffusers.EnableParameters,
+	batch := ReportsCapability(loader, backendConfig, grpc.CapabilityImageBatch)
+	stepProgress := ReportsCapability(loader, backendConfig, grpc.CapabilityImageProgress)
+
+	generate := func(first int, dsts []string) error {
+		request := &proto.GenerateImageRequest{
+			Height:           int32(height),
+			Width:            int32(width),
+			Mode:             int32(mode),
+			Step:             int32(step),
+			Seed:             ResolveSeed(backendConfig),
+			Deterministic:    backendConfig.Deterministic,
+			CLIPSkip:         int32(backendConfig.Diffusers.ClipSkip),
+			PositivePrompt:   positive_prompt,
+			NegativePrompt:   negative_prompt,
+			Dst:              dsts[0],
+			Src:              src,
+			Mask:             mask,
+			Strength:         strength,
+			EnableParameters: backendConfig.Diffusers.EnableParameters,
+		}
+		if len(dsts) > 1 {
+			request.Dsts = dsts
+		}
+
+		var err error
+		if progress != nil && stepProgress {
+			err = inferenceModel.GenerateImageStream(ctx, request, func(p *proto.ImageProgress) {
+				progress(ImageProgress{
+					Image:  first + int(p.GetImage()),
+					Images: max(int(p.GetImages()), 1),
+					Step:   int(p.GetStep()),
+					Steps:  int(p.GetSteps()),
+				})
 			})
+		} else {
+			_, err = inferenceModel.GenerateImage(ctx, request)
+			if err == nil && progress != nil {
+				progress(ImageProgress{Image: first, Images: len(dsts), Step: step, Steps: step})
+			}
+		}
+		reportInference(loader, backendConfig, err)
 		return err
 	}
 
+	fn := func() error {
+		if len(dsts) == 0 {
+			return nil
+		}
+		if batch {
+			return generate(0, dsts)
+		}
+		for i, dst := range dsts {
+			if err := generate(i, []string{dst}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	return fn, nil
 }
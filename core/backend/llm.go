@@ -1,20 +1,25 @@
 package backend
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"os"
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/schema"
 
 	"github.com/mudler/LocalAI/core/gallery"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/proto"
 	model "github.com/mudler/LocalAI/pkg/model"
@@ -24,11 +29,37 @@ import (
 type LLMResponse struct {
 	Response string // should this be []byte?
 	Usage    TokenUsage
+	// Logprobs are the log probabilities of the generated tokens, if requested
+	// and supported by the backend. They are not available when streaming.
+	Logprobs []*proto.TokenLogprob
+	// FinishReason is why the generation stopped: FinishReasonLength at the maximum number of tokens,
+	// FinishReasonStop otherwise
+	FinishReason string
 }
 
+const (
+	FinishReasonStop   = "stop"
+	FinishReasonLength = "length"
+)
+
 type TokenUsage struct {
 	Prompt     int
 	Completion int
+
+	// prompt tokens reused from the backend prompt cache
+	PromptCached int
+
+	// time spent processing the prompt and generating the completion, in milliseconds
+	TimingPromptProcessing float64
+	TimingTokenGeneration  float64
+}
+
+// TokensPerSecond returns the decoding rate of the completion, or 0 if it is not known
+func (u TokenUsage) TokensPerSecond() float64 {
+	if u.Completion == 0 || u.TimingTokenGeneration <= 0 {
+		return 0
+	}
+	return float64(u.Completion) / (u.TimingTokenGeneration / 1000)
 }
 
 func ModelInference(ctx context.Context, s string, messages []schema.Message, images, videos, audios []string, loader *model.ModelLoader, c config.BackendConfig, o *config.ApplicationConfig, tokenCallback func(string, TokenUsage) bool) (func() (LLMResponse, error), error) {
@@ -48,7 +79,7 @@ func ModelInference(ctx context.Context, s string, messages []schema.Message, im
 		if _, err := os.Stat(modelFile); os.IsNotExist(err) {
 			utils.ResetDownloadTimers()
 			// if we failed to load the model, we try to download it
-			err := gallery.InstallModelFromGallery(o.Galleries, modelFile, loader.ModelPath, gallery.GalleryModel{}, utils.DisplayDownloadFunction, o.EnforcePredownloadScans)
+			err := gallery.InstallModelFromGallery(o.Context, o.Galleries, modelFile, loader.ModelPath, gallery.GalleryModel{}, utils.DisplayDownloadFunction, o.EnforcePredownloadScans, o.TrustedKeys)
 			if err != nil {
 				return nil, err
 			}
@@ -56,14 +87,17 @@ func ModelInference(ctx context.Context, s string, messages []schema.Message, im
 	}
 
 	if c.Backend == "" {
-		inferenceModel, err = loader.GreedyLoader(opts...)
+		inferenceModel, err = loadModel(ctx, c, loader.GreedyLoader, opts...)
 	} else {
-		inferenceModel, err = loader.BackendLoader(opts...)
+		inferenceModel, err = loadModel(ctx, c, loader.BackendLoader, opts...)
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckCapability(loader, c, grpc.CapabilityGenerate); err != nil {
+		return nil, err
+	}
 
 	var protoMessages []*proto.Message
 	// if we are using the tokenizer template, we need to convert the messages to proto messages
@@ -84,20 +118,35 @@ func ModelInference(ctx context.Context, s string, messages []schema.Message, im
 	}
 
 	// in GRPC, the backend is supposed to answer to 1 single token if stream is not supported
-	fn := func() (LLMResponse, error) {
+	fn := func() (response LLMResponse, err error) {
+		ctx, span := startSpan(ctx, "llm.predict", c, trace.WithAttributes(attribute.Bool("stream", tokenCallback != nil)))
+		defer func() {
+			span.SetAttributes(
+				attribute.Int("prompt_tokens", response.Usage.Prompt),
+				attribute.Int("completion_tokens", response.Usage.Completion),
+			)
+			endSpan(span, err)
+			reportInference(loader, c, err)
+		}()
+
 		opts := gRPCPredictOpts(c, loader.ModelPath)
+		if o.PromptCache {
+			opts.PromptCacheAll = true
+		}
 		opts.Prompt = s
 		opts.Messages = protoMessages
 		opts.UseTokenizerTemplate = c.TemplateConfig.UseTokenizerTemplate
 		opts.Images = images
 		opts.Videos = videos
 		opts.Audios = audios
+		opts.CorrelationId = correlation.FromContext(ctx)
 
 		tokenUsage := TokenUsage{}
 
 		// check the per-model feature flag for usage, since tokenCallback may have a cost.
-		// Defaults to off as for now it is still experimental
-		if c.FeatureFlag.Enabled("usage") {
+		// Defaults to off as for now it is still experimental.
+		// It is skipped for logprobs requests, which are returned only by non-streaming predictions.
+		if c.FeatureFlag.Enabled("usage") && !(tokenCallback == nil && c.Logprobs.Enabled) {
 			userTokenCallback := tokenCallback
 			if userTokenCallback == nil {
 				userTokenCallback = func(token string, usage TokenUsage) bool {
@@ -116,11 +165,35 @@ func ModelInference(ctx context.Context, s string, messages []schema.Message, im
 			}
 		}
 
+		start := time.Now()
+
 		if tokenCallback != nil {
 			ss := ""
 
+			// the time to the first token is the prompt processing time,
+			// and everything after it is spent generating tokens
+			var firstToken time.Time
 			var partialRune []byte
-			err := inferenceModel.PredictStream(ctx, opts, func(chars []byte) {
+			// the backends reporting the tokens send the number generated so far with each reply;
+			// for the others, each chunk is counted as a token, an estimate of the tokens generated
+			reported, tokens, chunks := "", 0, 0
+			err := inferenceModel.PredictStream(ctx, opts, func(reply *proto.Reply) {
+				if r := reply.GetFinishReason(); r != "" {
+					reported = r
+				}
+				tokens = max(tokens, int(reply.GetTokens()))
+				chars := reply.GetMessage()
+				if len(chars) == 0 {
+					return
+				}
+				chunks++
+				now := time.Now()
+				if firstToken.IsZero() {
+					firstToken = now
+					tokenUsage.TimingPromptProcessing = milliseconds(now.Sub(start))
+				}
+				tokenUsage.TimingTokenGeneration = milliseconds(now.Sub(firstToken))
+
 				partialRune = append(partialRune, chars...)
 
 				for len(partialRune) > 0 {
@@ -136,9 +209,13 @@ func ModelInference(ctx context.Context, s string, messages []schema.Message, im
 					partialRune = partialRune[size:]
 				}
 			})
+			if !firstToken.IsZero() {
+				recordInferencePhases(ctx, c, start, firstToken, time.Now())
+			}
 			return LLMResponse{
-				Response: ss,
-				Usage:    tokenUsage,
+				Response:     ss,
+				Usage:        tokenUsage,
+				FinishReason: finishReason(reported, c, cmp.Or(tokens, chunks)),
 			}, err
 		} else {
 			// TODO: Is the chicken bit the only way to get here? is that acceptable?
@@ -152,9 +229,28 @@ func ModelInference(ctx context.Context, s string, messages []schema.Message, im
 			if tokenUsage.Completion == 0 {
 				tokenUsage.Completion = int(reply.Tokens)
 			}
+			tokenUsage.PromptCached = int(reply.PromptTokensCached)
+			tokenUsage.TimingPromptProcessing = reply.TimingPromptProcessing
+			tokenUsage.TimingTokenGeneration = reply.TimingTokenGeneration
+			if reply.TimingPromptProcessing > 0 || reply.TimingTokenGeneration > 0 {
+				// the phases are not observable from here, place them with the timings of the backend
+				firstToken := start.Add(time.Duration(reply.TimingPromptProcessing * float64(time.Millisecond)))
+				recordInferencePhases(ctx, c, start, firstToken, firstToken.Add(time.Duration(reply.TimingTokenGeneration*float64(time.Millisecond))))
+			}
+			if tokenUsage.PromptCached > 0 {
+				correlation.Logger(ctx).Debug().
+					Str("model", c.Name).
+					Str("cache_key", c.PromptCacheKey).
+					Int("prompt_tokens", tokenUsage.Prompt).
+					Int("cached_tokens", tokenUsage.PromptCached).
+					Float64("prompt_processing_ms", tokenUsage.TimingPromptProcessing).
+					Msg("prompt cache hit: skipped the evaluation of the cached prompt prefix")
+			}
 			return LLMResponse{
-				Response: string(reply.Message),
-				Usage:    tokenUsage,
+				Response:     string(reply.Message),
+				Usage:        tokenUsage,
+				Logprobs:     reply.Logprobs,
+				FinishReason: finishReason(reply.GetFinishReason(), c, tokenUsage.Completion),
 			}, err
 		}
 	}
@@ -162,6 +258,24 @@ func ModelInference(ctx context.Context, s string, messages []schema.Message, im
 	return fn, nil
 }
 
+// finishReason returns the reason the generation stopped reported by the backend. For the backends not
+// reporting it, the generation stopped at the limit if it produced the maximum number of tokens, as
+// reported by the backend or estimated from the streamed chunks.
+func finishReason(reported string, c config.BackendConfig, tokens int) string {
+	switch {
+	case reported != "":
+		return reported
+	case c.Maxtokens != nil && *c.Maxtokens > 0 && tokens >= *c.Maxtokens:
+		return FinishReasonLength
+	default:
+		return FinishReasonStop
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 var cutstrings map[string]*regexp.Regexp = make(map[string]*regexp.Regexp)
 var mu sync.Mutex = sync.Mutex{}
 
@@ -4,27 +4,26 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/mudler/LocalAI/core/config"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
 	"github.com/rs/zerolog/log"
 )
 
-func ModelOptions(c config.BackendConfig, so *config.ApplicationConfig, opts []model.Option) []model.Option {
-	name := c.Name
-	if name == "" {
-		name = c.Model
-	}
-
-	defOpts := []model.Option{
-		model.WithBackendString(c.Backend),
-		model.WithModel(c.Model),
-		model.WithAssetDir(so.AssetsDestination),
-		model.WithContext(so.Context),
-		model.WithModelID(name),
+// modelID is the ID the model of the config is loaded with
+func modelID(c config.BackendConfig) string {
+	if c.Name == "" {
+		return c.Model
 	}
+	return c.Name
+}
 
+// ModelThreads is the number of threads the model of the config is loaded with: the threads set for the
+// application override the ones of the config
+func ModelThreads(c config.BackendConfig, so *config.ApplicationConfig) int {
 	threads := 1
 
 	if c.Threads != nil {
@@ -35,9 +34,30 @@ func ModelOptions(c config.BackendConfig, so *config.ApplicationConfig, opts []m
 		threads = so.Threads
 	}
 
+	return threads
+}
+
+func ModelOptions(c config.BackendConfig, so *config.ApplicationConfig, opts []model.Option) []model.Option {
+	defOpts := []model.Option{
+		model.WithBackendString(c.Backend),
+		model.WithModel(c.Model),
+		model.WithAssetDir(so.AssetsDestination),
+		model.WithContext(so.Context),
+		model.WithModelID(modelID(c)),
+	}
+
+	threads := ModelThreads(c, so)
 	c.Threads = &threads
 
 	grpcOpts := grpcModelOpts(c)
+	if len(c.ExtraOptions) > 0 {
+		keys := make([]string, 0, len(c.ExtraOptions))
+		for k := range c.ExtraOptions {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		log.Debug().Str("model", modelID(c)).Strs("keys", keys).Msg("forwarding the extra options to the backend")
+	}
 	defOpts = append(defOpts, model.WithLoadGRPCLoadModelOpts(grpcOpts))
 
 	if so.SingleBackend {
@@ -48,6 +68,26 @@ func ModelOptions(c config.BackendConfig, so *config.ApplicationConfig, opts []m
 		defOpts = append(defOpts, model.EnableParallelRequests)
 	}
 
+	if len(so.BackendPriorityOverride) > 0 {
+		defOpts = append(defOpts, model.WithBackendPriority(so.BackendPriorityOverride))
+	}
+
+	if len(so.DisabledBackends) > 0 {
+		defOpts = append(defOpts, model.WithDisabledBackends(so.DisabledBackends))
+	}
+
+	if c.Backend == "" {
+		if backend, ok := config.MappedBackend(so.BackendMapping, modelID(c)); ok {
+			defOpts = append(defOpts, model.WithMappedBackend(backend))
+		}
+	}
+
+	if so.Warmup {
+		if warmup := warmupRequest(c); warmup != nil {
+			defOpts = append(defOpts, model.WithWarmup(warmup))
+		}
+	}
+
 	if c.GRPC.Attempts != 0 {
 		defOpts = append(defOpts, model.WithGRPCAttempts(c.GRPC.Attempts))
 	}
@@ -56,14 +96,33 @@ func ModelOptions(c config.BackendConfig, so *config.ApplicationConfig, opts []m
 		defOpts = append(defOpts, model.WithGRPCAttemptsDelay(c.GRPC.AttemptsSleepTime))
 	}
 
+	if c.GRPC.WorkDir != "" {
+		defOpts = append(defOpts, model.WithBackendWorkDir(c.GRPC.WorkDir))
+	}
+
+	if c.GRPC.CPUAffinity != "" {
+		// the configs are validated when loaded, an invalid set is only logged here
+		cpus, err := xsysinfo.ParseCPUSet(c.GRPC.CPUAffinity)
+		if err != nil {
+			log.Warn().Err(err).Str("model", modelID(c)).Msg("ignoring the CPU affinity")
+		} else {
+			defOpts = append(defOpts, model.WithCPUAffinity(cpus))
+		}
+	}
+
 	for k, v := range so.ExternalGRPCBackends {
 		defOpts = append(defOpts, model.WithExternalBackend(k, v))
 	}
+	if so.ContainerRuntime != "" {
+		defOpts = append(defOpts, model.WithContainerRuntime(so.ContainerRuntime))
+	}
 
 	return append(defOpts, opts...)
 }
 
-func getSeed(c config.BackendConfig) int32 {
+// ResolveSeed returns the seed to send to the backend. A random seed is
+// drawn when unset, unless deterministic mode pins it to DETERMINISTIC_SEED.
+func ResolveSeed(c config.BackendConfig) int32 {
 	var seed int32 = config.RAND_SEED
 
 	if c.Seed != nil {
@@ -71,12 +130,32 @@ func getSeed(c config.BackendConfig) int32 {
 	}
 
 	if seed == config.RAND_SEED {
+		if c.Deterministic {
+			return config.DETERMINISTIC_SEED
+		}
 		seed = rand.Int31()
 	}
 
 	return seed
 }
 
+// nProbs returns how many token probabilities the backend has to return for
+// each generated token. The backend needs at least one to report logprobs.
+func nProbs(c config.BackendConfig) int32 {
+	if !c.Logprobs.Enabled {
+		return 0
+	}
+	return int32(max(c.TopLogprobsCount(), 1))
+}
+
+func grpcLoraAdapters(adapters []config.LoraAdapter) []*pb.LoraAdapter {
+	res := []*pb.LoraAdapter{}
+	for _, a := range adapters {
+		res = append(res, &pb.LoraAdapter{Name: a.Name, Path: a.Path, Scale: a.Scale})
+	}
+	return res
+}
+
 func grpcModelOpts(c config.BackendConfig) *pb.ModelOptions {
 	b := 512
 	if c.Batch != 0 {
@@ -127,13 +206,14 @@ func grpcModelOpts(c config.BackendConfig) *pb.ModelOptions {
 		LoraScale:            c.LoraScale,
 		F16Memory:            f16,
 		LoraBase:             c.LoraBase,
+		LoraAdapters:         grpcLoraAdapters(c.LoraAdapters),
 		IMG2IMG:              c.Diffusers.IMG2IMG,
 		CLIPModel:            c.Diffusers.ClipModel,
 		CLIPSubfolder:        c.Diffusers.ClipSubFolder,
 		CLIPSkip:             int32(c.Diffusers.ClipSkip),
 		ControlNet:           c.Diffusers.ControlNet,
 		ContextSize:          int32(ctxSize),
-		Seed:                 getSeed(c),
+		Seed:                 ResolveSeed(c),
 		NBatch:               int32(b),
 		NoMulMatQ:            c.NoMulMatQ,
 		DraftModel:           c.DraftModel,
@@ -152,6 +232,7 @@ func grpcModelOpts(c config.BackendConfig) *pb.ModelOptions {
 		YarnAttnFactor:       c.YarnAttnFactor,
 		YarnBetaFast:         c.YarnBetaFast,
 		YarnBetaSlow:         c.YarnBetaSlow,
+		YarnOrigCtx:          int32(c.YarnOrigCtx),
 		NGQA:                 c.NGQA,
 		RMSNormEps:           c.RMSNormEps,
 		MLock:                mmlock,
@@ -159,7 +240,8 @@ func grpcModelOpts(c config.BackendConfig) *pb.ModelOptions {
 		RopeScaling:          c.RopeScaling,
 		Type:                 c.ModelType,
 		RopeFreqScale:        c.RopeFreqScale,
-		NUMA:                 c.NUMA,
+		NUMA:                 c.NUMA || c.NUMAPolicy != "",
+		NUMAPolicy:           c.NUMAPolicy,
 		Embeddings:           embeddings,
 		LowVRAM:              lowVRAM,
 		NGPULayers:           int32(nGPULayers),
@@ -173,7 +255,9 @@ func grpcModelOpts(c config.BackendConfig) *pb.ModelOptions {
 		UseTriton:        c.AutoGPTQ.Triton,
 		UseFastTokenizer: c.AutoGPTQ.UseFastTokenizer,
 		// RWKV
-		Tokenizer: c.Tokenizer,
+		Tokenizer:    c.Tokenizer,
+		ExtraOptions: c.ExtraOptions,
+		Pooling:      c.Pooling,
 	}
 }
 
@@ -196,7 +280,7 @@ func gRPCPredictOpts(c config.BackendConfig, modelPath string) *pb.PredictOption
 		TopK:                int32(*c.TopK),
 		Tokens:              int32(*c.Maxtokens),
 		Threads:             int32(*c.Threads),
-		PromptCacheAll:      c.PromptCacheAll,
+		PromptCacheAll:      c.PromptCacheAll || c.PromptCacheKey != "",
 		PromptCacheRO:       c.PromptCacheRO,
 		PromptCachePath:     promptCachePath,
 		F16KV:               *c.F16,
@@ -218,12 +302,17 @@ func gRPCPredictOpts(c config.BackendConfig, modelPath string) *pb.PredictOption
 		NKeep:               int32(c.Keep),
 		Batch:               int32(c.Batch),
 		IgnoreEOS:           c.IgnoreEOS,
-		Seed:                getSeed(c),
+		Seed:                ResolveSeed(c),
+		Deterministic:       c.Deterministic,
+		LoraAdapter:         c.Adapter,
+		NProbs:              nProbs(c),
+		CacheKey:            c.PromptCacheKey,
 		MLock:               *c.MMlock,
 		MMap:                *c.MMap,
 		MainGPU:             c.MainGPU,
 		TensorSplit:         c.TensorSplit,
 		TailFreeSamplingZ:   float32(*c.TFZ),
 		TypicalP:            float32(*c.TypicalP),
+		Pooling:             c.Pooling,
 	}
 }
@@ -0,0 +1,38 @@
+package backend_test
+
+import (
+	. "github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backend options", func() {
+	Context("ResolveSeed", func() {
+		seedConfig := func(seed int, deterministic bool) config.BackendConfig {
+			return config.BackendConfig{
+				PredictionOptions: schema.PredictionOptions{
+					Seed:          &seed,
+					Deterministic: deterministic,
+				},
+			}
+		}
+
+		It("uses the requested seed", func() {
+			Expect(ResolveSeed(seedConfig(1234, false))).To(Equal(int32(1234)))
+			Expect(ResolveSeed(seedConfig(1234, true))).To(Equal(int32(1234)))
+		})
+
+		It("pins the seed in deterministic mode", func() {
+			c := seedConfig(config.RAND_SEED, true)
+			Expect(ResolveSeed(c)).To(Equal(int32(config.DETERMINISTIC_SEED)))
+			Expect(ResolveSeed(config.BackendConfig{PredictionOptions: schema.PredictionOptions{Deterministic: true}})).To(Equal(int32(config.DETERMINISTIC_SEED)))
+		})
+
+		It("draws a random seed otherwise", func() {
+			Expect(ResolveSeed(seedConfig(config.RAND_SEED, false))).ToNot(Equal(int32(config.RAND_SEED)))
+		})
+	})
+})
@@ -0,0 +1,19 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/mudler/LocalAI/core/config"
+	model "github.com/mudler/LocalAI/pkg/model"
+)
+
+// PreloadModel loads the backend of the model, if it is not loaded already, without running an inference
+func PreloadModel(ctx context.Context, loader *model.ModelLoader, c config.BackendConfig, o *config.ApplicationConfig) error {
+	opts := ModelOptions(c, o, []model.Option{})
+	load := loader.BackendLoader
+	if c.Backend == "" {
+		load = loader.GreedyLoader
+	}
+	_, err := loadModel(ctx, c, load, opts...)
+	return err
+}
@@ -1,27 +1,50 @@
 package backend
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"slices"
 
 	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/proto"
 	model "github.com/mudler/LocalAI/pkg/model"
 )
 
-func Rerank(modelFile string, request *proto.RerankRequest, loader *model.ModelLoader, appConfig *config.ApplicationConfig, backendConfig config.BackendConfig) (*proto.RerankResult, error) {
+func Rerank(ctx context.Context, modelFile string, request *proto.RerankRequest, loader *model.ModelLoader, appConfig *config.ApplicationConfig, backendConfig config.BackendConfig) (*proto.RerankResult, error) {
 
 	opts := ModelOptions(backendConfig, appConfig, []model.Option{model.WithModel(modelFile)})
-	rerankModel, err := loader.BackendLoader(opts...)
+	rerankModel, err := loadModel(ctx, backendConfig, loader.BackendLoader, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckCapability(loader, backendConfig, grpc.CapabilityRerank); err != nil {
+		return nil, err
+	}
 
 	if rerankModel == nil {
 		return nil, fmt.Errorf("could not load rerank model")
 	}
 
-	res, err := rerankModel.Rerank(context.Background(), request)
+	res, err := rerankModel.Rerank(ctx, request)
+	reportInference(loader, backendConfig, err)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Results = RankDocuments(res.Results, int(request.TopN))
+	return res, nil
+}
 
-	return res, err
+// RankDocuments sorts the results of a rerank by decreasing relevance, and keeps the topN most relevant
+// (all of them if topN is not positive), whatever the order and the number of results of the backend
+func RankDocuments(results []*proto.DocumentResult, topN int) []*proto.DocumentResult {
+	slices.SortStableFunc(results, func(a, b *proto.DocumentResult) int {
+		return cmp.Compare(b.RelevanceScore, a.RelevanceScore)
+	})
+	if topN > 0 && topN < len(results) {
+		results = results[:topN]
+	}
+	return results
 }
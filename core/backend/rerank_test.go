@@ -0,0 +1,34 @@
+package backend_test
+
+import (
+	. "github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/pkg/grpc/proto"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RankDocuments", func() {
+	results := func() []*proto.DocumentResult {
+		return []*proto.DocumentResult{
+			{Index: 0, Text: "a", RelevanceScore: 0.1},
+			{Index: 1, Text: "b", RelevanceScore: 0.9},
+			{Index: 2, Text: "c", RelevanceScore: 0.5},
+		}
+	}
+	indexes := func(results []*proto.DocumentResult) []int32 {
+		var idx []int32
+		for _, r := range results {
+			idx = append(idx, r.Index)
+		}
+		return idx
+	}
+
+	It("sorts the documents by decreasing relevance", func() {
+		Expect(indexes(RankDocuments(results(), 0))).To(Equal([]int32{1, 2, 0}))
+	})
+	It("keeps the top n documents", func() {
+		Expect(indexes(RankDocuments(results(), 2))).To(Equal([]int32{1, 2}))
+		Expect(indexes(RankDocuments(results(), 5))).To(Equal([]int32{1, 2, 0}))
+	})
+})
@@ -7,12 +7,14 @@ import (
 	"path/filepath"
 
 	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/model"
 	"github.com/mudler/LocalAI/pkg/utils"
 )
 
 func SoundGeneration(
+	ctx context.Context,
 	modelFile string,
 	text string,
 	duration *float32,
@@ -27,10 +29,13 @@ func SoundGeneration(
 
 	opts := ModelOptions(backendConfig, appConfig, []model.Option{model.WithModel(modelFile)})
 
-	soundGenModel, err := loader.BackendLoader(opts...)
+	soundGenModel, err := loadModel(ctx, backendConfig, loader.BackendLoader, opts...)
 	if err != nil {
 		return "", nil, err
 	}
+	if err := CheckCapability(loader, backendConfig, grpc.CapabilitySoundGeneration); err != nil {
+		return "", nil, err
+	}
 
 	if soundGenModel == nil {
 		return "", nil, fmt.Errorf("could not load sound generation model")
@@ -43,7 +48,7 @@ func SoundGeneration(
 	fileName := utils.GenerateUniqueFileName(appConfig.AudioDir, "sound_generation", ".wav")
 	filePath := filepath.Join(appConfig.AudioDir, fileName)
 
-	res, err := soundGenModel.SoundGeneration(context.Background(), &proto.SoundGenerationRequest{
+	res, err := soundGenModel.SoundGeneration(ctx, &proto.SoundGenerationRequest{
 		Text:        text,
 		Model:       modelFile,
 		Dst:         filePath,
@@ -53,6 +58,10 @@ func SoundGeneration(
 		Src:         sourceFile,
 		SrcDivisor:  sourceDivisor,
 	})
+	reportInference(loader, backendConfig, err)
+	if err != nil {
+		return "", nil, err
+	}
 
 	// return RPC error if any
 	if !res.Success {
@@ -0,0 +1,12 @@
+package backend
+
+import (
+	"github.com/mudler/LocalAI/core/config"
+	model "github.com/mudler/LocalAI/pkg/model"
+)
+
+// reportInference records the outcome of a call to the backend of the model, so that the backend is
+// restarted after repeated failures when a supervisor is set
+func reportInference(loader *model.ModelLoader, c config.BackendConfig, err error) {
+	loader.ReportInference(modelID(c), err)
+}
@@ -4,26 +4,35 @@ import (
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/schema"
 	"github.com/mudler/LocalAI/pkg/grpc"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	model "github.com/mudler/LocalAI/pkg/model"
 )
 
-func ModelTokenize(s string, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (schema.TokenizeResponse, error) {
-
-	modelFile := backendConfig.Model
-
-	var inferenceModel grpc.Backend
-	var err error
-
+func loadTokenizerModel(loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (grpc.Backend, error) {
 	opts := ModelOptions(backendConfig, appConfig, []model.Option{
-		model.WithModel(modelFile),
+		model.WithModel(backendConfig.Model),
 	})
 
+	var inferenceModel grpc.Backend
+	var err error
 	if backendConfig.Backend == "" {
 		inferenceModel, err = loader.GreedyLoader(opts...)
 	} else {
 		opts = append(opts, model.WithBackendString(backendConfig.Backend))
 		inferenceModel, err = loader.BackendLoader(opts...)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CheckCapability(loader, backendConfig, grpc.CapabilityTokenize); err != nil {
+		return nil, err
+	}
+	return inferenceModel, nil
+}
+
+func ModelTokenize(s string, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (schema.TokenizeResponse, error) {
+	inferenceModel, err := loadTokenizerModel(loader, backendConfig, appConfig)
 	if err != nil {
 		return schema.TokenizeResponse{}, err
 	}
@@ -39,6 +48,23 @@ func ModelTokenize(s string, loader *model.ModelLoader, backendConfig config.Bac
 
 	return schema.TokenizeResponse{
 		Tokens: resp.Tokens,
+		Count:  len(resp.Tokens),
 	}, nil
 
 }
+
+func ModelDetokenize(tokens []int32, loader *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (schema.DetokenizeResponse, error) {
+	inferenceModel, err := loadTokenizerModel(loader, backendConfig, appConfig)
+	if err != nil {
+		return schema.DetokenizeResponse{}, err
+	}
+
+	resp, err := inferenceModel.Detokenize(appConfig.Context, &pb.DetokenizeRequest{Tokens: tokens})
+	if err != nil {
+		return schema.DetokenizeResponse{}, err
+	}
+
+	return schema.DetokenizeResponse{
+		Content: resp.Text,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/grpc"
+	model "github.com/mudler/LocalAI/pkg/model"
+)
+
+// tracer creates the spans of the backend calls. They are dropped unless tracing is enabled.
+var tracer = otel.Tracer("github.com/mudler/LocalAI/core/backend")
+
+// startSpan starts a span with the model and the backend of the config as attributes
+func startSpan(ctx context.Context, name string, c config.BackendConfig, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	opts = append(opts, trace.WithAttributes(
+		attribute.String("model", c.Name),
+		attribute.String("backend", c.Backend),
+	))
+	return tracer.Start(ctx, name, opts...)
+}
+
+// endSpan records the error of the call, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// loadModel loads the model of the config within a span, which is short when the model is already loaded
+func loadModel(ctx context.Context, c config.BackendConfig, load func(...model.Option) (grpc.Backend, error), opts ...model.Option) (grpc.Backend, error) {
+	_, span := startSpan(ctx, "model.load", c)
+	m, err := load(opts...)
+	endSpan(span, err)
+	return m, err
+}
+
+// recordInferencePhases records the prefill (the processing of the prompt) and the decode (the generation
+// of the tokens) phases of a prediction, as child spans of the one in ctx
+func recordInferencePhases(ctx context.Context, c config.BackendConfig, start, firstToken, end time.Time) {
+	_, prefill := startSpan(ctx, "llm.prefill", c, trace.WithTimestamp(start))
+	prefill.End(trace.WithTimestamp(firstToken))
+	_, decode := startSpan(ctx, "llm.decode", c, trace.WithTimestamp(firstToken))
+	decode.End(trace.WithTimestamp(end))
+}
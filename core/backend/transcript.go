@@ -8,33 +8,47 @@ import (
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/schema"
 
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/model"
 )
 
-func ModelTranscription(audio, language string, translate bool, ml *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (*schema.TranscriptionResult, error) {
-
+func loadTranscriptionModel(ctx context.Context, ml *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (grpc.Backend, error) {
 	if backendConfig.Backend == "" {
 		backendConfig.Backend = model.WhisperBackend
 	}
 
 	opts := ModelOptions(backendConfig, appConfig, []model.Option{})
 
-	transcriptionModel, err := ml.BackendLoader(opts...)
+	transcriptionModel, err := loadModel(ctx, backendConfig, ml.BackendLoader, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckCapability(ml, backendConfig, grpc.CapabilityTranscription); err != nil {
+		return nil, err
+	}
 
 	if transcriptionModel == nil {
 		return nil, fmt.Errorf("could not load transcription model")
 	}
 
-	r, err := transcriptionModel.AudioTranscription(context.Background(), &proto.TranscriptRequest{
+	return transcriptionModel, nil
+}
+
+func ModelTranscription(ctx context.Context, audio, language string, translate, diarize bool, ml *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig) (*schema.TranscriptionResult, error) {
+	transcriptionModel, err := loadTranscriptionModel(ctx, ml, backendConfig, appConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := transcriptionModel.AudioTranscription(ctx, &proto.TranscriptRequest{
 		Dst:       audio,
 		Language:  language,
 		Translate: translate,
+		Diarize:   diarize,
 		Threads:   uint32(*backendConfig.Threads),
 	})
+	reportInference(ml, backendConfig, err)
 	if err != nil {
 		return nil, err
 	}
@@ -42,18 +56,43 @@ func ModelTranscription(audio, language string, translate bool, ml *model.ModelL
 		Text: r.Text,
 	}
 	for _, s := range r.Segments {
-		var tks []int
-		for _, t := range s.Tokens {
-			tks = append(tks, int(t))
-		}
-		tr.Segments = append(tr.Segments,
-			schema.Segment{
-				Text:   s.Text,
-				Id:     int(s.Id),
-				Start:  time.Duration(s.Start),
-				End:    time.Duration(s.End),
-				Tokens: tks,
-			})
+		tr.Segments = append(tr.Segments, toSchemaSegment(s))
 	}
 	return tr, err
 }
+
+// ModelTranscriptionStream transcribes the audio file calling segmentCallback for each segment
+// as soon as it is decoded by the backend
+func ModelTranscriptionStream(ctx context.Context, audio, language string, translate, diarize bool, ml *model.ModelLoader, backendConfig config.BackendConfig, appConfig *config.ApplicationConfig, segmentCallback func(schema.Segment)) error {
+	transcriptionModel, err := loadTranscriptionModel(ctx, ml, backendConfig, appConfig)
+	if err != nil {
+		return err
+	}
+
+	err = transcriptionModel.AudioTranscriptionStream(ctx, &proto.TranscriptRequest{
+		Dst:       audio,
+		Language:  language,
+		Translate: translate,
+		Diarize:   diarize,
+		Threads:   uint32(*backendConfig.Threads),
+	}, func(s *proto.TranscriptSegment) {
+		segmentCallback(toSchemaSegment(s))
+	})
+	reportInference(ml, backendConfig, err)
+	return err
+}
+
+func toSchemaSegment(s *proto.TranscriptSegment) schema.Segment {
+	var tks []int
+	for _, t := range s.Tokens {
+		tks = append(tks, int(t))
+	}
+	return schema.Segment{
+		Text:        s.Text,
+		Id:          int(s.Id),
+		Start:       time.Duration(s.Start),
+		End:         time.Duration(s.End),
+		Tokens:      tks,
+		SpeakerTurn: int(s.SpeakerTurn),
+	}
+}
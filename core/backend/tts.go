@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"os"
@@ -8,12 +9,14 @@ import (
 
 	"github.com/mudler/LocalAI/core/config"
 
+	"github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/model"
 	"github.com/mudler/LocalAI/pkg/utils"
 )
 
 func ModelTTS(
+	ctx context.Context,
 	backend,
 	text,
 	modelFile,
@@ -28,14 +31,26 @@ func ModelTTS(
 		bb = model.PiperBackend
 	}
 
+	// the model is loaded under the ID of the config, or of the piper model of the voice:
+	// piper has a model per voice, the voices naming a model select it
+	id := cmp.Or(modelID(backendConfig), modelFile)
+	if bb == model.PiperBackend && filepath.Ext(voice) == ".onnx" {
+		modelFile, voice = voice, ""
+		id = modelFile
+	}
+
 	opts := ModelOptions(config.BackendConfig{}, appConfig, []model.Option{
 		model.WithBackendString(bb),
 		model.WithModel(modelFile),
+		model.WithModelID(id),
 	})
-	ttsModel, err := loader.BackendLoader(opts...)
+	ttsModel, err := loadModel(ctx, backendConfig, loader.BackendLoader, opts...)
 	if err != nil {
 		return "", nil, err
 	}
+	if err := loader.CheckCapability(id, grpc.CapabilityTTS); err != nil {
+		return "", nil, err
+	}
 
 	if ttsModel == nil {
 		return "", nil, fmt.Errorf("could not load piper model")
@@ -52,27 +67,30 @@ func ModelTTS(
 	modelPath := ""
 	if modelFile != "" {
 		// If the model file is not empty, we pass it joined with the model path
-		// Checking first that it exists and is not outside ModelPath
+		// Checking first that it exists and is not outside the model paths
 		// TODO: we should actually first check if the modelFile is looking like
 		// a FS path
-		mp := filepath.Join(loader.ModelPath, modelFile)
-		if _, err := os.Stat(mp); err == nil {
-			if err := utils.VerifyPath(mp, appConfig.ModelPath); err != nil {
-				return "", nil, err
+		modelPath = modelFile
+		for _, base := range loader.ModelPaths() {
+			mp := filepath.Join(base, modelFile)
+			if _, err := os.Stat(mp); err == nil {
+				if err := utils.VerifyPath(mp, base); err != nil {
+					return "", nil, err
+				}
+				modelPath = mp
+				break
 			}
-			modelPath = mp
-		} else {
-			modelPath = modelFile
 		}
 	}
 
-	res, err := ttsModel.TTS(context.Background(), &proto.TTSRequest{
+	res, err := ttsModel.TTS(ctx, &proto.TTSRequest{
 		Text:     text,
 		Model:    modelPath,
 		Voice:    voice,
 		Dst:      filePath,
 		Language: &language,
 	})
+	loader.ReportInference(id, err)
 	if err != nil {
 		return "", nil, err
 	}
@@ -0,0 +1,57 @@
+package backend_test
+
+import (
+	"context"
+
+	. "github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/grpc/base"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/mudler/LocalAI/pkg/model"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// generateLLM only generates text, and fails the test if asked to speak
+type generateLLM struct {
+	base.SingleThread
+}
+
+func (llm *generateLLM) Load(opts *pb.ModelOptions) error {
+	return nil
+}
+
+func (llm *generateLLM) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityGenerate}, nil
+}
+
+func (llm *generateLLM) TTS(opts *pb.TTSRequest) error {
+	defer GinkgoRecover()
+	Fail("the backend was asked to speak")
+	return nil
+}
+
+var _ = Describe("ModelTTS", func() {
+	tts := func(name, backend, voice string) error {
+		grpc.Provide("tts-capability-test-"+name, &generateLLM{})
+		appConfig := config.NewApplicationConfig(
+			config.WithContext(context.Background()),
+			config.WithAudioDir(GinkgoT().TempDir()),
+			config.WithExternalBackend(backend, "tts-capability-test-"+name),
+		)
+		cfg := config.BackendConfig{Name: "speaker", Backend: backend}
+		cfg.Model = "speaker.model"
+		cfg.SetDefaults()
+		_, _, err := ModelTTS(context.Background(), backend, "hello", cfg.Model, voice, "", model.NewModelLoader(GinkgoT().TempDir()), appConfig, cfg)
+		return err
+	}
+
+	It("rejects the backends which report not supporting text to speech", func() {
+		Expect(tts("config", "speaker", "")).To(MatchError(model.ErrUnsupportedCapability))
+	})
+	It("checks the piper model selected by the voice", func() {
+		Expect(tts("voice", model.PiperBackend, "voice.onnx")).To(MatchError(model.ErrUnsupportedCapability))
+	})
+})
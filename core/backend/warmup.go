@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"cmp"
+	"context"
+	"os"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/grpc"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
+)
+
+const (
+	defaultTextWarmupPrompt  = "Hello"
+	defaultImageWarmupPrompt = "a cat"
+
+	// the warmup image is as small and quick to generate as possible
+	warmupImageSize = 64
+)
+
+// warmupRequest returns the dummy request sent to the backend once the model is loaded, so that the
+// first request of the users does not pay for the lazy initialization and the allocations of the backend.
+// It returns nil for the models without a meaningful warmup, e.g. the audio models and the rerankers.
+func warmupRequest(c config.BackendConfig) func(context.Context, grpc.Backend) error {
+	if c.Warmup.Disable {
+		return nil
+	}
+	return InferenceProbe(c)
+}
+
+// InferenceProbe returns the smallest inference the model can run, to check that the backend works. It
+// is the request of the warmup, and returns nil for the same models.
+func InferenceProbe(c config.BackendConfig) func(context.Context, grpc.Backend) error {
+	var threads int32
+	if c.Threads != nil {
+		threads = int32(*c.Threads)
+	}
+
+	switch {
+	case c.Embeddings != nil && *c.Embeddings:
+		prompt := cmp.Or(c.Warmup.Prompt, defaultTextWarmupPrompt)
+		return func(ctx context.Context, b grpc.Backend) error {
+			_, err := b.Embeddings(ctx, &pb.PredictOptions{Embeddings: prompt, Threads: threads})
+			return err
+		}
+	case c.HasUsecases(config.FLAG_IMAGE):
+		prompt := cmp.Or(c.Warmup.Prompt, defaultImageWarmupPrompt)
+		return func(ctx context.Context, b grpc.Backend) error {
+			f, err := os.CreateTemp("", "localai-warmup-*.png")
+			if err != nil {
+				return err
+			}
+			f.Close()
+			defer os.Remove(f.Name())
+
+			_, err = b.GenerateImage(ctx, &pb.GenerateImageRequest{
+				Height:         warmupImageSize,
+				Width:          warmupImageSize,
+				Step:           1,
+				PositivePrompt: prompt,
+				Dst:            f.Name(),
+			})
+			return err
+		}
+	case c.HasUsecases(config.FLAG_CHAT) || c.HasUsecases(config.FLAG_COMPLETION) || c.TemplateConfig.UseTokenizerTemplate:
+		prompt := cmp.Or(c.Warmup.Prompt, defaultTextWarmupPrompt)
+		tokens := cmp.Or(c.Warmup.Tokens, 1)
+		return func(ctx context.Context, b grpc.Backend) error {
+			_, err := b.Predict(ctx, &pb.PredictOptions{Prompt: prompt, Tokens: int32(tokens), Threads: threads})
+			return err
+		}
+	}
+	return nil
+}
@@ -5,6 +5,11 @@ import "embed"
 type Context struct {
 	Debug    bool    `env:"LOCALAI_DEBUG,DEBUG" default:"false" hidden:"" help:"DEPRECATED, use --log-level=debug instead. Enable debug logging"`
 	LogLevel *string `env:"LOCALAI_LOG_LEVEL" enum:"error,warn,info,debug,trace" help:"Set the level of logs to output [${enum}]"`
+	// LogLevels overrides the level of the logs of components, e.g. model=debug,http=warn
+	LogLevels string `env:"LOCALAI_LOG_LEVELS" help:"Override the level of logs of components, e.g. model=debug,http=warn [model, startup, http]"`
+	LogFormat string `env:"LOCALAI_LOG_FORMAT" enum:"console,json" default:"console" help:"Set the format of logs to output [${enum}]"`
+	// LogFields renames the standard fields of the log lines, e.g. timestamp=@timestamp,level=severity
+	LogFields string `env:"LOCALAI_LOG_FIELDS" help:"Rename the standard fields of the log lines, e.g. timestamp=@timestamp,level=severity,message=msg [timestamp, level, message, error]"`
 
 	// This field is not a command line argument/flag, the struct tag excludes it from the parsed CLI
 	BackendAssets embed.FS `kong:"-"`
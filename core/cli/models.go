@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/mudler/LocalAI/core/gallery"
 	"github.com/mudler/LocalAI/pkg/downloader"
+	"github.com/mudler/LocalAI/pkg/signature"
 	"github.com/mudler/LocalAI/pkg/startup"
 	"github.com/rs/zerolog/log"
 	"github.com/schollz/progressbar/v3"
@@ -26,6 +28,7 @@ type ModelsList struct {
 
 type ModelsInstall struct {
 	DisablePredownloadScan bool     `env:"LOCALAI_DISABLE_PREDOWNLOAD_SCAN" help:"If true, disables the best-effort security scanner before downloading any files." group:"hardening" default:"false"`
+	TrustedKeys            []string `env:"LOCALAI_TRUSTED_KEYS" help:"A list of minisign public keys. When set, the files of the models installed from galleries must have a valid detached signature from one of these keys" group:"hardening"`
 	ModelArgs              []string `arg:"" optional:"" name:"models" help:"Model configuration URLs to load"`
 
 	ModelsCMDFlags `embed:""`
@@ -41,6 +44,9 @@ func (ml *ModelsList) Run(ctx *cliContext.Context) error {
 	if err := json.Unmarshal([]byte(ml.Galleries), &galleries); err != nil {
 		log.Error().Err(err).Msg("unable to load galleries")
 	}
+	if err := gallery.SetGalleriesCredentials(galleries); err != nil {
+		return err
+	}
 
 	models, err := gallery.AvailableGalleryModels(galleries, ml.ModelsPath)
 	if err != nil {
@@ -61,6 +67,12 @@ func (mi *ModelsInstall) Run(ctx *cliContext.Context) error {
 	if err := json.Unmarshal([]byte(mi.Galleries), &galleries); err != nil {
 		log.Error().Err(err).Msg("unable to load galleries")
 	}
+	if err := gallery.SetGalleriesCredentials(galleries); err != nil {
+		return err
+	}
+	if _, err := signature.ParsePublicKeys(mi.TrustedKeys); err != nil {
+		return err
+	}
 
 	for _, modelName := range mi.ModelArgs {
 
@@ -100,7 +112,7 @@ func (mi *ModelsInstall) Run(ctx *cliContext.Context) error {
 			log.Info().Str("model", modelName).Str("license", model.License).Msg("installing model")
 		}
 
-		err = startup.InstallModels(galleries, "", mi.ModelsPath, !mi.DisablePredownloadScan, progressCallback, modelName)
+		err = startup.InstallModels(context.Background(), galleries, "", mi.ModelsPath, !mi.DisablePredownloadScan, mi.TrustedKeys, progressCallback, modelName)
 		if err != nil {
 			return err
 		}
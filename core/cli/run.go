@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,15 +21,20 @@ type RunCMD struct {
 	ModelArgs []string `arg:"" optional:"" name:"models" help:"Model configuration URLs to load"`
 
 	ModelsPath                   string        `env:"LOCALAI_MODELS_PATH,MODELS_PATH" type:"path" default:"${basepath}/models" help:"Path containing models used for inferencing" group:"storage"`
+	ExtraModelsPaths             []string      `env:"LOCALAI_EXTRA_MODELS_PATHS,EXTRA_MODELS_PATHS" help:"Additional paths searched, in order, for the models missing from the models path (e.g. a read-only shared directory)" group:"storage"`
 	BackendAssetsPath            string        `env:"LOCALAI_BACKEND_ASSETS_PATH,BACKEND_ASSETS_PATH" type:"path" default:"/tmp/localai/backend_data" help:"Path used to extract libraries that are required by some of the backends in runtime" group:"storage"`
 	ImagePath                    string        `env:"LOCALAI_IMAGE_PATH,IMAGE_PATH" type:"path" default:"/tmp/generated/images" help:"Location for images generated by backends (e.g. stablediffusion)" group:"storage"`
 	AudioPath                    string        `env:"LOCALAI_AUDIO_PATH,AUDIO_PATH" type:"path" default:"/tmp/generated/audio" help:"Location for audio generated by backends (e.g. piper)" group:"storage"`
 	UploadPath                   string        `env:"LOCALAI_UPLOAD_PATH,UPLOAD_PATH" type:"path" default:"/tmp/localai/upload" help:"Path to store uploads from files api" group:"storage"`
+	CleanupOnStartup             bool          `env:"LOCALAI_CLEANUP_ON_STARTUP" help:"Remove at startup the stale files left in the upload, audio and image paths by the previous runs" group:"storage"`
+	CleanupMaxAge                time.Duration `env:"LOCALAI_CLEANUP_MAX_AGE" default:"24h" help:"Age after which the files are removed by --cleanup-on-startup" group:"storage"`
 	ConfigPath                   string        `env:"LOCALAI_CONFIG_PATH,CONFIG_PATH" default:"/tmp/localai/config" group:"storage"`
 	LocalaiConfigDir             string        `env:"LOCALAI_CONFIG_DIR" type:"path" default:"${basepath}/configuration" help:"Directory for dynamic loading of certain configuration files (currently api_keys.json and external_backends.json)" group:"storage"`
 	LocalaiConfigDirPollInterval time.Duration `env:"LOCALAI_CONFIG_DIR_POLL_INTERVAL" help:"Typically the config path picks up changes automatically, but if your system has broken fsnotify events, set this to an interval to poll the LocalAI Config Dir (example: 1m)" group:"storage"`
 	// The alias on this option is there to preserve functionality with the old `--config-file` parameter
-	ModelsConfigFile string `env:"LOCALAI_MODELS_CONFIG_FILE,CONFIG_FILE" aliases:"config-file" help:"YAML file containing a list of model backend configs" group:"storage"`
+	ModelsConfigFile       string        `env:"LOCALAI_MODELS_CONFIG_FILE,CONFIG_FILE" aliases:"config-file" help:"YAML file containing a list of model backend configs" group:"storage"`
+	ModelsConfigURL        string        `env:"LOCALAI_MODELS_CONFIG_URL" help:"URL serving a YAML file of model backend configs, or a manifest listing the URLs of such files" group:"storage"`
+	ModelsConfigURLRefresh time.Duration `env:"LOCALAI_MODELS_CONFIG_URL_REFRESH" default:"5m" help:"Interval between the fetches of --models-config-url (0 disables the refresh)" group:"storage"`
 
 	Galleries           string   `env:"LOCALAI_GALLERIES,GALLERIES" help:"JSON list of galleries" group:"models" default:"${galleries}"`
 	AutoloadGalleries   bool     `env:"LOCALAI_AUTOLOAD_GALLERIES,AUTOLOAD_GALLERIES" group:"models"`
@@ -36,6 +42,7 @@ type RunCMD struct {
 	PreloadModels       string   `env:"LOCALAI_PRELOAD_MODELS,PRELOAD_MODELS" help:"A List of models to apply in JSON at start" group:"models"`
 	Models              []string `env:"LOCALAI_MODELS,MODELS" help:"A List of model configuration URLs to load" group:"models"`
 	PreloadModelsConfig string   `env:"LOCALAI_PRELOAD_MODELS_CONFIG,PRELOAD_MODELS_CONFIG" help:"A List of models to apply at startup. Path to a YAML config file" group:"models"`
+	PreloadInBackground bool     `env:"LOCALAI_PRELOAD_IN_BACKGROUND" default:"false" help:"Install the models of --preload-models and --preload-models-config once the API is started, /readyz failing until they are installed, instead of before starting the API" group:"models"`
 
 	F16         bool `name:"f16" env:"LOCALAI_F16,F16" help:"Enable GPU acceleration" group:"performance"`
 	Threads     int  `env:"LOCALAI_THREADS,THREADS" short:"t" help:"Number of threads used for parallel computation. Usage of the number of physical cores in the system is suggested" group:"performance"`
@@ -44,16 +51,53 @@ type RunCMD struct {
 	Address                            string   `env:"LOCALAI_ADDRESS,ADDRESS" default:":8080" help:"Bind address for the API server" group:"api"`
 	CORS                               bool     `env:"LOCALAI_CORS,CORS" help:"" group:"api"`
 	CORSAllowOrigins                   string   `env:"LOCALAI_CORS_ALLOW_ORIGINS,CORS_ALLOW_ORIGINS" group:"api"`
+	CORSAllowMethods                   string   `env:"LOCALAI_CORS_ALLOW_METHODS" help:"Methods allowed by the CORS preflight requests, comma-separated (GET,POST,HEAD,PUT,DELETE,PATCH by default)" group:"api"`
+	CORSAllowHeaders                   string   `env:"LOCALAI_CORS_ALLOW_HEADERS" help:"Headers allowed by the CORS preflight requests, comma-separated. By default the headers requested by the preflight are allowed" group:"api"`
+	CORSAllowCredentials               bool     `env:"LOCALAI_CORS_ALLOW_CREDENTIALS" help:"Allow the browsers to send credentials with the CORS requests. It requires --cors-allow-origins" group:"api"`
+	CORSMaxAge                         int      `env:"LOCALAI_CORS_MAX_AGE" default:"0" help:"Seconds the browsers cache the CORS preflight responses (0 is not cached)" group:"api"`
 	LibraryPath                        string   `env:"LOCALAI_LIBRARY_PATH,LIBRARY_PATH" help:"Path to the library directory (for e.g. external libraries used by backends)" default:"/usr/share/local-ai/libs" group:"backends"`
-	CSRF                               bool     `env:"LOCALAI_CSRF" help:"Enables fiber CSRF middleware" group:"api"`
+	CSRF                               bool     `env:"LOCALAI_CSRF" help:"Enables fiber CSRF middleware. The requests authenticated with an API key header are exempt" group:"api"`
+	CSRFUIOnly                         bool     `env:"LOCALAI_CSRF_UI_ONLY" help:"Limit the CSRF protection to the routes of the web UI, so that the API clients without an API key are not blocked" group:"api"`
+	HTTPReadTimeout                    string   `env:"LOCALAI_HTTP_READ_TIMEOUT" default:"0" help:"Maximum duration for reading a request, including its body (0 is unlimited)" group:"api"`
+	HTTPWriteTimeout                   string   `env:"LOCALAI_HTTP_WRITE_TIMEOUT" default:"0" help:"Maximum duration for writing a response (0 is unlimited). It also bounds the streamed responses" group:"api"`
+	HTTPIdleTimeout                    string   `env:"LOCALAI_HTTP_IDLE_TIMEOUT" default:"0" help:"Maximum duration a keep-alive connection waits for the next request (0 falls back to the read timeout)" group:"api"`
+	HTTPConcurrency                    int      `env:"LOCALAI_HTTP_CONCURRENCY" default:"0" help:"Maximum number of concurrent connections (0 is the default of 262144)" group:"api"`
+	H2C                                bool     `env:"LOCALAI_H2C" default:"false" help:"Serve HTTP/2 cleartext (h2c) besides HTTP/1.1, so that a connection can multiplex several requests. The concurrency limit does not apply to it" group:"api"`
+	StreamKeepAlive                    string   `env:"LOCALAI_STREAM_KEEP_ALIVE" default:"0" help:"Interval of the keep-alive comments sent on the idle completion streams until their first token, so that the proxies do not close them during a long prompt processing (0 disables them)" group:"api"`
+	StreamBatchTokens                  int      `env:"LOCALAI_STREAM_BATCH_TOKENS" default:"1" help:"Number of tokens merged in each event of the completion streams. The requests can override it with stream_options.batch_tokens" group:"api"`
+	StreamFlushInterval                string   `env:"LOCALAI_STREAM_FLUSH_INTERVAL" default:"0" help:"Minimum time between two flushes of the completion streams (0 flushes each event). The requests can override it with stream_options.flush_interval_ms" group:"api"`
 	UploadLimit                        int      `env:"LOCALAI_UPLOAD_LIMIT,UPLOAD_LIMIT" default:"15" help:"Default upload-limit in MB" group:"api"`
+	MaxImages                          int      `env:"LOCALAI_MAX_IMAGES" default:"10" help:"Maximum number of images in a chat completion request (0 is unlimited)" group:"api"`
+	MaxImageSize                       int      `env:"LOCALAI_MAX_IMAGE_SIZE" default:"10" help:"Maximum size in MB of each image in a chat completion request (0 is unlimited)" group:"api"`
+	MaxChoices                         int      `env:"LOCALAI_MAX_CHOICES" default:"8" help:"Maximum number of completions (n) returned for a single request (0 is unlimited)" group:"api"`
+	MaxGeneratedImages                 int      `env:"LOCALAI_MAX_GENERATED_IMAGES" default:"10" help:"Maximum number of images (n) generated for a single image generation request (0 is unlimited)" group:"api"`
+	ImageSafetyModel                   string   `env:"LOCALAI_IMAGE_SAFETY_MODEL" help:"Image classification model checking the generated images. Its moderation config maps its labels to the flagged categories (defaults to the nsfw label)" group:"api"`
+	ImageSafetyAction                  string   `env:"LOCALAI_IMAGE_SAFETY_ACTION" default:"block" enum:"block,placeholder,flag" help:"What happens to the generated images flagged by the image safety model: the request fails (block), they are replaced (placeholder) or only marked (flag)" group:"api"`
+	ImageSafetyPlaceholder             string   `env:"LOCALAI_IMAGE_SAFETY_PLACEHOLDER" help:"Image replacing the flagged images with --image-safety-action=placeholder (defaults to a black image)" group:"api"`
+	ResponseCacheSize                  int      `env:"LOCALAI_RESPONSE_CACHE_SIZE" default:"0" help:"Number of responses to cache for identical requests with temperature 0 (0 disables the cache)" group:"api"`
+	ResponseCacheTTL                   string   `env:"LOCALAI_RESPONSE_CACHE_TTL" default:"1h" help:"How long the cached responses are kept (0 keeps them until evicted)" group:"api"`
+	CorrelationIDHeader                string   `env:"LOCALAI_CORRELATION_ID_HEADER" default:"X-Correlation-ID" help:"HTTP header carrying the correlation ID of the requests. It is generated when missing, echoed in the responses, logged and forwarded to the backends" group:"api"`
+	EnableTracing                      bool     `env:"LOCALAI_ENABLE_TRACING" default:"false" help:"Export OpenTelemetry traces of the requests and of the backend calls. The exporter is configured with the standard OTEL_EXPORTER_OTLP_* environment variables" group:"api"`
+	UsageFile                          string   `env:"LOCALAI_USAGE_FILE" help:"File where the requests and the tokens accounted to each API key are saved, so that they survive restarts. When empty, the usage is kept only in memory" group:"api"`
 	APIKeys                            []string `env:"LOCALAI_API_KEY,API_KEY" help:"List of API Keys to enable API authentication. When this is set, all the requests must be authenticated with one of these API keys" group:"api"`
+	APIKeyModels                       []string `env:"LOCALAI_API_KEY_MODELS" help:"Models each API key is allowed to use, as a list of key=pattern|pattern entries. In the patterns, * matches any sequence of characters" group:"api"`
+	APIKeyModelsDefaultPolicy          string   `env:"LOCALAI_API_KEY_MODELS_DEFAULT_POLICY" default:"allow" enum:"allow,deny" help:"Whether the API keys without an entry in --api-key-models can use all the models (allow) or none (deny)" group:"api"`
+	APIKeyQuotas                       []string `env:"LOCALAI_API_KEY_QUOTAS" help:"Tokens each API key can use per window of time, as a list of key=tokens/window entries (e.g. key=100000/24h). Once over its budget, the requests of a key are rejected until the end of the window" group:"api"`
+	APIKeyQuotaMode                    string   `env:"LOCALAI_API_KEY_QUOTA_MODE" default:"hard" enum:"hard,soft" help:"Whether the requests of the API keys over their quota are rejected (hard) or only logged (soft)" group:"api"`
 	DisableWebUI                       bool     `env:"LOCALAI_DISABLE_WEBUI,DISABLE_WEBUI" default:"false" help:"Disable webui" group:"api"`
 	DisablePredownloadScan             bool     `env:"LOCALAI_DISABLE_PREDOWNLOAD_SCAN" help:"If true, disables the best-effort security scanner before downloading any files." group:"hardening" default:"false"`
+	TrustedKeys                        []string `env:"LOCALAI_TRUSTED_KEYS" help:"A list of minisign public keys. When set, the files of the models installed from galleries must have a valid detached signature from one of these keys" group:"hardening"`
+	DownloadConcurrency                int      `env:"LOCALAI_DOWNLOAD_CONCURRENCY" default:"3" help:"Number of files downloaded at once when installing models, shared by all the installs in progress" group:"models"`
+	MaxDownloadRate                    int64    `env:"LOCALAI_MAX_DOWNLOAD_RATE" default:"0" help:"Maximum rate of the downloads of the models together, in bytes per second, so that installing models doesn't saturate the link of the inference traffic. 0 for no limit" group:"models"`
+	DiskHeadroomMB                     int      `env:"LOCALAI_DISK_HEADROOM_MB" default:"512" help:"Disk space in MB to leave free after the downloads of the models and the extraction of the backend assets. They fail before writing anything if they don't fit" group:"storage"`
 	OpaqueErrors                       bool     `env:"LOCALAI_OPAQUE_ERRORS" default:"false" help:"If true, all error responses are replaced with blank 500 errors. This is intended only for hardening against information leaks and is normally not recommended." group:"hardening"`
 	UseSubtleKeyComparison             bool     `env:"LOCALAI_SUBTLE_KEY_COMPARISON" default:"false" help:"If true, API Key validation comparisons will be performed using constant-time comparisons rather than simple equality. This trades off performance on each request for resiliancy against timing attacks." group:"hardening"`
 	DisableApiKeyRequirementForHttpGet bool     `env:"LOCALAI_DISABLE_API_KEY_REQUIREMENT_FOR_HTTP_GET" default:"false" help:"If true, a valid API key is not required to issue GET requests to portions of the web ui. This should only be enabled in secure testing environments" group:"hardening"`
 	HttpGetExemptedEndpoints           []string `env:"LOCALAI_HTTP_GET_EXEMPTED_ENDPOINTS" default:"^/$,^/browse/?$,^/talk/?$,^/p2p/?$,^/chat/?$,^/text2image/?$,^/tts/?$,^/static/.*$,^/swagger.*$" help:"If LOCALAI_DISABLE_API_KEY_REQUIREMENT_FOR_HTTP_GET is overriden to true, this is the list of endpoints to exempt. Only adjust this in case of a security incident or as a result of a personal security posture review" group:"hardening"`
+	IPAllowList                        []string `env:"LOCALAI_IP_ALLOW_LIST" help:"CIDRs (or IP addresses) of the clients allowed to use the API. When set, the requests of any other client are rejected with 403. The health endpoints are exempt" group:"hardening"`
+	IPDenyList                         []string `env:"LOCALAI_IP_DENY_LIST" help:"CIDRs (or IP addresses) of the clients whose requests are rejected with 403, even if they are in --ip-allow-list. The health endpoints are exempt" group:"hardening"`
+	TrustedProxies                     []string `env:"LOCALAI_TRUSTED_PROXIES" help:"CIDRs (or IP addresses) of the reverse proxies whose --proxy-header is trusted to find the address of the clients, for the access logs and the IP lists. By default no proxy is trusted" group:"hardening"`
+	ProxyHeader                        string   `env:"LOCALAI_PROXY_HEADER" default:"X-Forwarded-For" enum:"X-Forwarded-For,X-Real-IP" help:"Header carrying the address of the clients in the requests of the trusted proxies" group:"hardening"`
 	Peer2Peer                          bool     `env:"LOCALAI_P2P,P2P" name:"p2p" default:"false" help:"Enable P2P mode" group:"p2p"`
 	Peer2PeerDHTInterval               int      `env:"LOCALAI_P2P_DHT_INTERVAL,P2P_DHT_INTERVAL" default:"360" name:"p2p-dht-interval" help:"Interval for DHT refresh (used during token generation)" group:"p2p"`
 	Peer2PeerOTPInterval               int      `env:"LOCALAI_P2P_OTP_INTERVAL,P2P_OTP_INTERVAL" default:"9000" name:"p2p-otp-interval" help:"Interval for OTP refresh (used during token generation)" group:"p2p"`
@@ -61,8 +105,24 @@ type RunCMD struct {
 	Peer2PeerNetworkID                 string   `env:"LOCALAI_P2P_NETWORK_ID,P2P_NETWORK_ID" help:"Network ID for P2P mode, can be set arbitrarly by the user for grouping a set of instances" group:"p2p"`
 	ParallelRequests                   bool     `env:"LOCALAI_PARALLEL_REQUESTS,PARALLEL_REQUESTS" help:"Enable backends to handle multiple requests in parallel if they support it (e.g.: llama.cpp or vllm)" group:"backends"`
 	SingleActiveBackend                bool     `env:"LOCALAI_SINGLE_ACTIVE_BACKEND,SINGLE_ACTIVE_BACKEND" help:"Allow only one backend to be run at a time" group:"backends"`
+	HealthProbeInterval                string   `env:"LOCALAI_HEALTH_PROBE_INTERVAL" default:"0" help:"Interval between the deep health checks, which run a tiny inference on each loaded model and report the failures in /readyz (0 disables them)" group:"backends"`
+	HealthProbeTimeout                 string   `env:"LOCALAI_HEALTH_PROBE_TIMEOUT" default:"30s" help:"Time after which a deep health check fails" group:"backends"`
+	HealthProbeFailures                int      `env:"LOCALAI_HEALTH_PROBE_FAILURES" default:"3" help:"Number of deep health checks a model must fail in a row to be marked unhealthy and reloaded" group:"backends"`
+	BackendPriorityOverride            []string `env:"LOCALAI_BACKEND_PRIORITY" help:"Backends tried first, in order, when loading a model without a backend in its configuration. The other backends are tried after them, in their default order" group:"backends"`
+	DisabledBackends                   []string `env:"LOCALAI_DISABLED_BACKENDS" help:"Backends never tried when loading a model without a backend in its configuration. They can still be set explicitly in the model configurations" group:"backends"`
+	BackendMappingFile                 string   `env:"LOCALAI_BACKEND_MAPPING_FILE" help:"YAML file mapping the model name globs to the backends of the models without a backend in their configuration, used instead of detecting the backend" group:"backends"`
+	BackendRestartFailures             int      `env:"LOCALAI_BACKEND_RESTART_FAILURES" default:"0" help:"Restart the backend of a model after this number of inferences failed in a row (0 disables the restarts)" group:"backends"`
+	BackendRestartBackoff              string   `env:"LOCALAI_BACKEND_RESTART_BACKOFF" default:"30s" help:"Minimum time between two restarts of the backend of a model, doubled at each restart" group:"backends"`
+	BackendMaxRestarts                 int      `env:"LOCALAI_BACKEND_MAX_RESTARTS" default:"5" help:"Maximum number of restarts of the backend of a model (0 is unlimited)" group:"backends"`
+	BackendLogLines                    int      `env:"LOCALAI_BACKEND_LOG_LINES" default:"1000" help:"Number of lines of output of each backend kept in memory, served by /system/backends/{model}/logs (0 disables the capture)" group:"backends"`
+	ForwardBackendLogs                 bool     `env:"LOCALAI_FORWARD_BACKEND_LOGS" default:"false" help:"Write the output of the backends to the logs of LocalAI, with the model and the backend of each line and the level parsed from its prefix" group:"backends"`
+	Warmup                             bool     `env:"LOCALAI_WARMUP" default:"false" help:"Send a small dummy request to the backends after loading a model, so that the first request is not slowed down by the warmup of the backend" group:"backends"`
+	PromptCache                        bool     `env:"LOCALAI_PROMPT_CACHE" help:"Reuse the cached prompt prefixes across requests to the same model, if the backend supports it (e.g.: llama.cpp)" group:"backends"`
+	SchedulerPolicy                    string   `env:"LOCALAI_SCHEDULER_POLICY" help:"Queue the requests per model and dispatch them with this policy: 'fair' (weighted round-robin across models) or 'fifo' (arrival order). Empty disables queueing" group:"backends"`
+	SchedulerMaxConcurrency            int      `env:"LOCALAI_SCHEDULER_MAX_CONCURRENCY" default:"0" help:"Maximum number of requests running at a time across all the models when queueing is enabled (0 is unlimited)" group:"backends"`
 	PreloadBackendOnly                 bool     `env:"LOCALAI_PRELOAD_BACKEND_ONLY,PRELOAD_BACKEND_ONLY" default:"false" help:"Do not launch the API services, only the preloaded models / backends are started (useful for multi-node setups)" group:"backends"`
 	ExternalGRPCBackends               []string `env:"LOCALAI_EXTERNAL_GRPC_BACKENDS,EXTERNAL_GRPC_BACKENDS" help:"A list of external grpc backends" group:"backends"`
+	ContainerRuntime                   string   `env:"LOCALAI_CONTAINER_RUNTIME" default:"docker" help:"Container runtime (docker, podman) running the external backends given as container://image" group:"backends"`
 	EnableWatchdogIdle                 bool     `env:"LOCALAI_WATCHDOG_IDLE,WATCHDOG_IDLE" default:"false" help:"Enable watchdog for stopping backends that are idle longer than the watchdog-idle-timeout" group:"backends"`
 	WatchdogIdleTimeout                string   `env:"LOCALAI_WATCHDOG_IDLE_TIMEOUT,WATCHDOG_IDLE_TIMEOUT" default:"15m" help:"Threshold beyond which an idle backend should be stopped" group:"backends"`
 	EnableWatchdogBusy                 bool     `env:"LOCALAI_WATCHDOG_BUSY,WATCHDOG_BUSY" default:"false" help:"Enable watchdog for stopping backends that are busy longer than the watchdog-busy-timeout" group:"backends"`
@@ -70,19 +130,25 @@ type RunCMD struct {
 	Federated                          bool     `env:"LOCALAI_FEDERATED,FEDERATED" help:"Enable federated instance" group:"federated"`
 	DisableGalleryEndpoint             bool     `env:"LOCALAI_DISABLE_GALLERY_ENDPOINT,DISABLE_GALLERY_ENDPOINT" help:"Disable the gallery endpoints" group:"api"`
 	LoadToMemory                       []string `env:"LOCALAI_LOAD_TO_MEMORY,LOAD_TO_MEMORY" help:"A list of models to load into memory at startup" group:"models"`
+	KeepWarm                           []string `env:"LOCALAI_KEEP_WARM" help:"Models loaded on their first request like the others, but never stopped by the idle watchdog once loaded" group:"models"`
+	SignalReload                       bool     `env:"LOCALAI_SIGNAL_RELOAD,SIGNAL_RELOAD" default:"false" help:"Reload the model configurations when receiving SIGHUP" group:"models"`
+	Offline                            bool     `env:"LOCALAI_OFFLINE,OFFLINE" default:"false" help:"Do not download anything: only the models already present on disk are loaded and gallery operations are disabled (useful for air-gapped hosts)" group:"models"`
 }
 
 func (r *RunCMD) Run(ctx *cliContext.Context) error {
 	opts := []config.AppOption{
 		config.WithConfigFile(r.ModelsConfigFile),
+		config.WithConfigURL(r.ModelsConfigURL, r.ModelsConfigURLRefresh),
 		config.WithJSONStringPreload(r.PreloadModels),
 		config.WithYAMLConfigPreload(r.PreloadModelsConfig),
 		config.WithModelPath(r.ModelsPath),
+		config.WithExtraModelPaths(r.ExtraModelsPaths...),
 		config.WithContextSize(r.ContextSize),
 		config.WithDebug(zerolog.GlobalLevel() <= zerolog.DebugLevel),
 		config.WithImageDir(r.ImagePath),
 		config.WithAudioDir(r.AudioPath),
 		config.WithUploadDir(r.UploadPath),
+		config.WithCleanupOnStartup(r.CleanupOnStartup, r.CleanupMaxAge),
 		config.WithConfigsDir(r.ConfigPath),
 		config.WithDynamicConfigDir(r.LocalaiConfigDir),
 		config.WithDynamicConfigDirPollInterval(r.LocalaiConfigDirPollInterval),
@@ -91,21 +157,39 @@ func (r *RunCMD) Run(ctx *cliContext.Context) error {
 		config.WithModelLibraryURL(r.RemoteLibrary),
 		config.WithCors(r.CORS),
 		config.WithCorsAllowOrigins(r.CORSAllowOrigins),
+		config.WithCorsAllowMethods(r.CORSAllowMethods),
+		config.WithCorsAllowHeaders(r.CORSAllowHeaders),
+		config.WithCorsAllowCredentials(r.CORSAllowCredentials),
+		config.WithCorsMaxAge(r.CORSMaxAge),
 		config.WithCsrf(r.CSRF),
+		config.WithCsrfUIOnly(r.CSRFUIOnly),
 		config.WithLibPath(r.LibraryPath),
 		config.WithThreads(r.Threads),
 		config.WithBackendAssets(ctx.BackendAssets),
 		config.WithBackendAssetsOutput(r.BackendAssetsPath),
 		config.WithUploadLimitMB(r.UploadLimit),
+		config.WithMaxImagesPerRequest(r.MaxImages),
+		config.WithMaxImageSizeMB(r.MaxImageSize),
+		config.WithMaxChoices(r.MaxChoices),
+		config.WithMaxGeneratedImages(r.MaxGeneratedImages),
+		config.WithImageSafety(r.ImageSafetyModel, r.ImageSafetyAction),
+		config.WithImageSafetyPlaceholder(r.ImageSafetyPlaceholder),
+		config.WithCorrelationIDHeader(r.CorrelationIDHeader),
+		config.WithWarmup(r.Warmup),
 		config.WithApiKeys(r.APIKeys),
 		config.WithModelsURL(append(r.Models, r.ModelArgs...)...),
 		config.WithOpaqueErrors(r.OpaqueErrors),
 		config.WithEnforcedPredownloadScans(!r.DisablePredownloadScan),
+		config.WithTrustedKeys(r.TrustedKeys),
+		config.WithDownloadConcurrency(r.DownloadConcurrency),
+		config.WithMaxDownloadRate(r.MaxDownloadRate),
+		config.WithDiskHeadroomMB(r.DiskHeadroomMB),
 		config.WithSubtleKeyComparison(r.UseSubtleKeyComparison),
 		config.WithDisableApiKeyRequirementForHttpGet(r.DisableApiKeyRequirementForHttpGet),
 		config.WithHttpGetExemptedEndpoints(r.HttpGetExemptedEndpoints),
 		config.WithP2PNetworkID(r.Peer2PeerNetworkID),
 		config.WithLoadToMemory(r.LoadToMemory),
+		config.WithKeepWarm(r.KeepWarm),
 	}
 
 	token := ""
@@ -168,18 +252,164 @@ func (r *RunCMD) Run(ctx *cliContext.Context) error {
 	if r.SingleActiveBackend {
 		opts = append(opts, config.EnableSingleBackend)
 	}
+	if r.PromptCache {
+		opts = append(opts, config.EnablePromptCache)
+	}
+	if r.EnableTracing {
+		opts = append(opts, config.EnableTracing)
+	}
+	if r.HealthProbeInterval != "0" {
+		interval, err := time.ParseDuration(r.HealthProbeInterval)
+		if err != nil {
+			return err
+		}
+		timeout, err := time.ParseDuration(r.HealthProbeTimeout)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, config.WithHealthProbe(interval, timeout, r.HealthProbeFailures))
+	}
+	if len(r.BackendPriorityOverride) > 0 {
+		opts = append(opts, config.WithBackendPriorityOverride(r.BackendPriorityOverride))
+	}
+	if len(r.DisabledBackends) > 0 {
+		opts = append(opts, config.WithDisabledBackends(r.DisabledBackends))
+	}
+	if r.BackendMappingFile != "" {
+		rules, err := config.ReadBackendMappingFile(r.BackendMappingFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, config.WithBackendMapping(rules))
+	}
+	if r.BackendRestartFailures > 0 {
+		backoff, err := time.ParseDuration(r.BackendRestartBackoff)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, config.WithBackendRestart(r.BackendRestartFailures, backoff, r.BackendMaxRestarts))
+	}
+	opts = append(opts, config.WithBackendLogLines(r.BackendLogLines))
+	if r.PreloadInBackground {
+		opts = append(opts, config.EnablePreloadInBackground)
+	}
+	if r.ForwardBackendLogs {
+		opts = append(opts, config.EnableForwardBackendLogs)
+	}
+	if r.UsageFile != "" {
+		opts = append(opts, config.WithUsageFile(r.UsageFile))
+	}
+	if r.SchedulerPolicy != "" {
+		opts = append(opts, config.WithScheduler(r.SchedulerPolicy, r.SchedulerMaxConcurrency))
+	}
+	if len(r.APIKeyModels) > 0 || r.APIKeyModelsDefaultPolicy != config.ApiKeyModelsAllowAll {
+		models := map[string][]string{}
+		for _, entry := range r.APIKeyModels {
+			// split on the last '=', as the keys may end with base64 padding
+			i := strings.LastIndex(entry, "=")
+			if i < 0 {
+				return fmt.Errorf("invalid API key models %q: expected key=pattern|pattern", entry)
+			}
+			models[entry[:i]] = strings.Split(entry[i+1:], "|")
+		}
+		opts = append(opts, config.WithApiKeyModels(models, r.APIKeyModelsDefaultPolicy))
+	}
+	if len(r.APIKeyQuotas) > 0 {
+		quotas := map[string]config.ApiKeyQuota{}
+		for _, entry := range r.APIKeyQuotas {
+			// split on the last '=', as the keys may end with base64 padding
+			i := strings.LastIndex(entry, "=")
+			tokens, window, found := strings.Cut(entry[i+1:], "/")
+			if i < 0 || !found {
+				return fmt.Errorf("invalid API key quota %q: expected key=tokens/window", entry)
+			}
+			n, err := strconv.ParseInt(tokens, 10, 64)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid API key quota %q: the tokens must be a positive number", entry)
+			}
+			d, err := time.ParseDuration(window)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid API key quota %q: the window must be a positive duration", entry)
+			}
+			quotas[entry[:i]] = config.ApiKeyQuota{Tokens: n, Window: d}
+		}
+		opts = append(opts, config.WithApiKeyQuotas(quotas, r.APIKeyQuotaMode))
+	}
+	if len(r.IPAllowList) > 0 || len(r.IPDenyList) > 0 {
+		allow, err := config.ParsePrefixes(r.IPAllowList)
+		if err != nil {
+			return fmt.Errorf("invalid IP allow list: %w", err)
+		}
+		deny, err := config.ParsePrefixes(r.IPDenyList)
+		if err != nil {
+			return fmt.Errorf("invalid IP deny list: %w", err)
+		}
+		opts = append(opts, config.WithIPFilter(allow, deny))
+	}
+	if len(r.TrustedProxies) > 0 {
+		trustedProxies, err := config.ParsePrefixes(r.TrustedProxies)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxies: %w", err)
+		}
+		opts = append(opts, config.WithTrustedProxies(trustedProxies, r.ProxyHeader))
+	}
+	httpTimeouts := make([]time.Duration, 3)
+	for i, t := range []string{r.HTTPReadTimeout, r.HTTPWriteTimeout, r.HTTPIdleTimeout} {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return err
+		}
+		httpTimeouts[i] = d
+	}
+	streamKeepAlive, err := time.ParseDuration(r.StreamKeepAlive)
+	if err != nil {
+		return err
+	}
+	streamFlushInterval, err := time.ParseDuration(r.StreamFlushInterval)
+	if err != nil {
+		return err
+	}
+	opts = append(opts,
+		config.WithHTTPTimeouts(httpTimeouts[0], httpTimeouts[1], httpTimeouts[2]),
+		config.WithHTTPConcurrency(r.HTTPConcurrency),
+		config.WithStreamKeepAlive(streamKeepAlive),
+		config.WithStreamBatching(r.StreamBatchTokens, streamFlushInterval),
+	)
+
+	if r.ResponseCacheSize > 0 {
+		ttl, err := time.ParseDuration(r.ResponseCacheTTL)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, config.WithResponseCache(r.ResponseCacheSize, ttl))
+	}
 
 	// split ":" to get backend name and the uri
 	for _, v := range r.ExternalGRPCBackends {
 		backend := v[:strings.IndexByte(v, ':')]
 		uri := v[strings.IndexByte(v, ':')+1:]
+		// the backends are separated by commas here, the addresses of the replicas of a backend by "|"
+		uri = strings.ReplaceAll(uri, "|", ",")
 		opts = append(opts, config.WithExternalBackend(backend, uri))
 	}
+	opts = append(opts, config.WithContainerRuntime(r.ContainerRuntime))
 
 	if r.AutoloadGalleries {
 		opts = append(opts, config.EnableGalleriesAutoload)
 	}
 
+	if r.SignalReload {
+		opts = append(opts, config.EnableSignalReload)
+	}
+
+	if r.H2C {
+		opts = append(opts, config.EnableH2C)
+	}
+
+	if r.Offline {
+		opts = append(opts, config.EnableOfflineMode)
+	}
+
 	if r.PreloadBackendOnly {
 		_, _, _, err := startup.Startup(opts...)
 		return err
@@ -196,5 +426,8 @@ func (r *RunCMD) Run(ctx *cliContext.Context) error {
 		return err
 	}
 
+	if options.H2C {
+		return http.ListenH2C(appHTTP, r.Address, options)
+	}
 	return appHTTP.Listen(r.Address)
 }
@@ -19,6 +19,7 @@ type TranscriptCMD struct {
 	Model             string `short:"m" required:"" help:"Model name to run the TTS"`
 	Language          string `short:"l" help:"Language of the audio file"`
 	Translate         bool   `short:"c" help:"Translate the transcription to english"`
+	Diarization       bool   `short:"d" help:"Label the segments with their speaker turn (requires a model that supports diarization)"`
 	Threads           int    `short:"t" default:"1" help:"Number of threads used for parallel computation"`
 	ModelsPath        string `env:"LOCALAI_MODELS_PATH,MODELS_PATH" type:"path" default:"${basepath}/models" help:"Path containing models used for inferencing" group:"storage"`
 	BackendAssetsPath string `env:"LOCALAI_BACKEND_ASSETS_PATH,BACKEND_ASSETS_PATH" type:"path" default:"/tmp/localai/backend_data" help:"Path used to extract libraries that are required by some of the backends in runtime" group:"storage"`
@@ -51,11 +52,15 @@ func (t *TranscriptCMD) Run(ctx *cliContext.Context) error {
 		}
 	}()
 
-	tr, err := backend.ModelTranscription(t.Filename, t.Language, t.Translate, ml, c, opts)
+	tr, err := backend.ModelTranscription(context.Background(), t.Filename, t.Language, t.Translate, t.Diarization, ml, c, opts)
 	if err != nil {
 		return err
 	}
 	for _, segment := range tr.Segments {
+		if t.Diarization {
+			fmt.Println(segment.Start.String(), "-", fmt.Sprintf("[turn %d]", segment.SpeakerTurn), segment.Text)
+			continue
+		}
 		fmt.Println(segment.Start.String(), "-", segment.Text)
 	}
 	return nil
@@ -1,9 +1,13 @@
 package cli
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 
@@ -11,11 +15,13 @@ import (
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/gallery"
 	"github.com/mudler/LocalAI/pkg/downloader"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
 	gguf "github.com/thxcode/gguf-parser-go"
 )
 
 type UtilCMD struct {
 	GGUFInfo         GGUFInfoCMD         `cmd:"" name:"gguf-info" help:"Get information about a GGUF file"`
+	GGUFConfig       GGUFConfigCMD       `cmd:"" name:"gguf-config" help:"Generate a starter model config for a GGUF file of the models directory"`
 	HFScan           HFScanCMD           `cmd:"" name:"hf-scan" help:"Checks installed models for known security issues. WARNING: this is a best-effort feature and may not catch everything!"`
 	UsecaseHeuristic UsecaseHeuristicCMD `cmd:"" name:"usecase-heuristic" help:"Checks a specific model config and prints what usecase LocalAI will offer for it."`
 }
@@ -25,6 +31,13 @@ type GGUFInfoCMD struct {
 	Header bool     `optional:"" default:"false" name:"header" help:"Show header information"`
 }
 
+type GGUFConfigCMD struct {
+	File       string `arg:"" name:"file" type:"path" help:"The GGUF file, in the models directory"`
+	ModelsPath string `env:"LOCALAI_MODELS_PATH,MODELS_PATH" type:"path" default:"${basepath}/models" help:"Path containing models used for inferencing" group:"storage"`
+	VRAM       uint64 `name:"vram" help:"VRAM in MiB to fit the GPU layers in. Defaults to the free VRAM of the NVIDIA GPUs, none without"`
+	Yes        bool   `short:"y" help:"Write the config without asking for confirmation"`
+}
+
 type HFScanCMD struct {
 	ModelsPath string   `env:"LOCALAI_MODELS_PATH,MODELS_PATH" type:"path" default:"${basepath}/models" help:"Path containing models used for inferencing" group:"storage"`
 	Galleries  string   `env:"LOCALAI_GALLERIES,GALLERIES" help:"JSON list of galleries" group:"models" default:"${galleries}"`
@@ -72,6 +85,42 @@ func (u *GGUFInfoCMD) Run(ctx *cliContext.Context) error {
 	return nil
 }
 
+func (g *GGUFConfigCMD) Run(ctx *cliContext.Context) error {
+	vram := g.VRAM << 20
+	if vram == 0 {
+		if _, free, err := xsysinfo.GPUMemory(); err == nil {
+			vram = free
+		} else {
+			log.Info().Msg("no GPU memory detected, the config offloads no layers")
+		}
+	}
+
+	name, data, err := config.GenerateGGUFConfig(g.ModelsPath, g.File, vram)
+	if err != nil {
+		return err
+	}
+
+	configFile := filepath.Join(g.ModelsPath, name+".yaml")
+	if _, err := os.Stat(configFile); err == nil {
+		return fmt.Errorf("the config %s already exists", configFile)
+	}
+
+	fmt.Printf("%s\n", data)
+	if !g.Yes {
+		fmt.Printf("Write the config to %s? [y/N] ", configFile)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			return nil
+		}
+	}
+
+	if err := os.WriteFile(configFile, data, 0600); err != nil {
+		return err
+	}
+	log.Info().Str("config", configFile).Msg("model config written, it is loaded on the next start")
+	return nil
+}
+
 func (hfscmd *HFScanCMD) Run(ctx *cliContext.Context) error {
 	log.Info().Msg("LocalAI Security Scanner - This is BEST EFFORT functionality! Currently limited to huggingface models!")
 	if len(hfscmd.ToScan) == 0 {
@@ -80,6 +129,9 @@ func (hfscmd *HFScanCMD) Run(ctx *cliContext.Context) error {
 		if err := json.Unmarshal([]byte(hfscmd.Galleries), &galleries); err != nil {
 			log.Error().Err(err).Msg("unable to load galleries")
 		}
+		if err := gallery.SetGalleriesCredentials(galleries); err != nil {
+			return err
+		}
 
 		err := gallery.SafetyScanGalleryModels(galleries, hfscmd.ModelsPath)
 		if err == nil {
@@ -4,9 +4,14 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"fmt"
+	"net/netip"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/xsysinfo"
 	"github.com/rs/zerolog/log"
 )
@@ -14,34 +19,89 @@ import (
 type ApplicationConfig struct {
 	Context                             context.Context
 	ConfigFile                          string
+	ConfigURL                           string
+	ConfigURLRefresh                    time.Duration
 	ModelPath                           string
+	ExtraModelPaths                     []string
 	LibPath                             string
 	UploadLimitMB, Threads, ContextSize int
+	HTTPReadTimeout, HTTPWriteTimeout   time.Duration
+	HTTPIdleTimeout                     time.Duration
+	HTTPConcurrency                     int
+	StreamKeepAlive                     time.Duration
+	StreamBatchTokens                   int
+	StreamFlushInterval                 time.Duration
+	MaxImagesPerRequest, MaxImageSizeMB int
+	MaxChoices                          int
+	MaxGeneratedImages                  int
+	ImageSafetyModel                    string
+	ImageSafetyAction                   string
+	ImageSafetyPlaceholder              string
+	PromptCache                         bool
+	ResponseCacheSize                   int
+	ResponseCacheTTL                    time.Duration
+	SchedulerPolicy                     string
+	SchedulerMaxConcurrency             int
+	CorrelationIDHeader                 string
+	EnableTracing                       bool
+	UsageFile                           string
+	Warmup                              bool
+	HealthProbeInterval                 time.Duration
+	HealthProbeTimeout                  time.Duration
+	HealthProbeFailures                 int
+	BackendPriorityOverride             []string
+	DisabledBackends                    []string
+	BackendMapping                      []BackendMappingRule
+	RestartAfterFailures                int
+	BackendLogLines                     int
+	ForwardBackendLogs                  bool
+	RestartBackoff                      time.Duration
+	MaxRestarts                         int
 	F16                                 bool
 	Debug                               bool
 	ImageDir                            string
 	AudioDir                            string
 	UploadDir                           string
+	CleanupOnStartup                    bool
+	CleanupMaxAge                       time.Duration
 	ConfigsDir                          string
 	DynamicConfigsDir                   string
 	DynamicConfigsDirPollInterval       time.Duration
 	CORS                                bool
 	CSRF                                bool
+	CSRFUIOnly                          bool
+	IPAllowList, IPDenyList             []netip.Prefix
+	TrustedProxies                      []netip.Prefix
+	ProxyHeader                         string
 	PreloadJSONModels                   string
 	PreloadModelsFromPath               string
+	PreloadInBackground                 bool
 	CORSAllowOrigins                    string
+	CORSAllowMethods, CORSAllowHeaders  string
+	CORSAllowCredentials                bool
+	CORSMaxAge                          int
 	ApiKeys                             []string
+	ApiKeyLabels                        map[string]string
+	ApiKeyModels                        map[string][]*regexp.Regexp
+	ApiKeyModelsDefaultPolicy           string
+	ApiKeyQuotas                        map[string]ApiKeyQuota
+	ApiKeyQuotaMode                     string
 	P2PToken                            string
 	P2PNetworkID                        string
 
 	DisableWebUI                       bool
 	EnforcePredownloadScans            bool
+	TrustedKeys                        []string
+	DownloadConcurrency                int
+	MaxDownloadRate                    int64
+	DiskHeadroomMB                     int
 	OpaqueErrors                       bool
 	UseSubtleKeyComparison             bool
 	DisableApiKeyRequirementForHttpGet bool
 	HttpGetExemptedEndpoints           []*regexp.Regexp
 	DisableGalleryEndpoint             bool
 	LoadToMemory                       []string
+	KeepWarm                           []string
 
 	ModelLibraryURL string
 
@@ -51,6 +111,7 @@ type ApplicationConfig struct {
 	AssetsDestination string
 
 	ExternalGRPCBackends map[string]string
+	ContainerRuntime     string
 
 	AutoloadGalleries bool
 
@@ -61,6 +122,13 @@ type ApplicationConfig struct {
 	WatchDogBusy bool
 	WatchDog     bool
 
+	EnableSignalReload bool
+
+	// H2C serves HTTP/2 cleartext connections besides HTTP/1.1
+	H2C bool
+
+	OfflineMode bool
+
 	ModelsURL []string
 
 	WatchDogBusyTimeout, WatchDogIdleTimeout time.Duration
@@ -68,12 +136,46 @@ type ApplicationConfig struct {
 
 type AppOption func(*ApplicationConfig)
 
+const (
+	// ApiKeyModelsAllowAll lets the API keys without a model allow-list use all the models
+	ApiKeyModelsAllowAll = "allow"
+	// ApiKeyModelsDenyAll prevents the API keys without a model allow-list from using any model
+	ApiKeyModelsDenyAll = "deny"
+
+	// ApiKeyQuotaHard rejects the requests of the API keys over their token budget
+	ApiKeyQuotaHard = "hard"
+	// ApiKeyQuotaSoft only logs the requests of the API keys over their token budget
+	ApiKeyQuotaSoft = "soft"
+
+	// ImageSafetyBlock fails the image generation requests with a flagged image
+	ImageSafetyBlock = "block"
+	// ImageSafetyPlaceholder replaces the flagged images with a placeholder
+	ImageSafetyPlaceholder = "placeholder"
+	// ImageSafetyFlag returns the flagged images marked as such
+	ImageSafetyFlag = "flag"
+
+	// UploadedFilesFile is the file of UploadDir listing the uploaded files
+	UploadedFilesFile = "uploadedFiles.json"
+)
+
+// ApiKeyQuota is the number of tokens an API key can use in each window of time
+type ApiKeyQuota struct {
+	Tokens int64
+	Window time.Duration
+}
+
 func NewApplicationConfig(o ...AppOption) *ApplicationConfig {
 	opt := &ApplicationConfig{
-		Context:       context.Background(),
-		UploadLimitMB: 15,
-		ContextSize:   512,
-		Debug:         true,
+		Context:             context.Background(),
+		UploadLimitMB:       15,
+		MaxImagesPerRequest: 10,
+		MaxImageSizeMB:      10,
+		MaxChoices:          8,
+		MaxGeneratedImages:  10,
+		CorrelationIDHeader: correlation.DefaultHeader,
+		ContextSize:         512,
+		Debug:               true,
+		BackendLogLines:     1000,
 	}
 	for _, oo := range o {
 		oo(opt)
@@ -93,6 +195,13 @@ func WithModelPath(path string) AppOption {
 	}
 }
 
+// WithExtraModelPaths sets the paths searched, in order, for the models missing from the model path
+func WithExtraModelPaths(paths ...string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ExtraModelPaths = paths
+	}
+}
+
 func WithCors(b bool) AppOption {
 	return func(o *ApplicationConfig) {
 		o.CORS = b
@@ -111,6 +220,55 @@ func WithCsrf(b bool) AppOption {
 	}
 }
 
+// WithCsrfUIOnly limits the CSRF protection to the routes of the web UI
+func WithCsrfUIOnly(b bool) AppOption {
+	return func(o *ApplicationConfig) {
+		o.CSRFUIOnly = b
+	}
+}
+
+// WithIPFilter restricts the API to the clients in the allow list, if not empty, and outside of the deny list
+func WithIPFilter(allow, deny []netip.Prefix) AppOption {
+	return func(o *ApplicationConfig) {
+		o.IPAllowList = allow
+		o.IPDenyList = deny
+	}
+}
+
+// WithTrustedProxies sets the reverse proxies whose header, X-Forwarded-For or X-Real-IP, carries the
+// address of the clients. The header of the other peers is ignored, so that it cannot be spoofed.
+func WithTrustedProxies(proxies []netip.Prefix, header string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.TrustedProxies = proxies
+		o.ProxyHeader = header
+	}
+}
+
+// ParsePrefixes parses a list of CIDRs, where a bare IP address stands for the address alone
+func ParsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP address %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
 func WithP2PToken(s string) AppOption {
 	return func(o *ApplicationConfig) {
 		o.P2PToken = s
@@ -171,10 +329,43 @@ var EnableParallelBackendRequests = func(o *ApplicationConfig) {
 	o.ParallelBackendRequests = true
 }
 
+// EnablePromptCache lets the backends reuse the cached prompt prefixes across requests
+var EnablePromptCache = func(o *ApplicationConfig) {
+	o.PromptCache = true
+}
+
 var EnableGalleriesAutoload = func(o *ApplicationConfig) {
 	o.AutoloadGalleries = true
 }
 
+var EnableSignalReload = func(o *ApplicationConfig) {
+	o.EnableSignalReload = true
+}
+
+var EnableH2C = func(o *ApplicationConfig) {
+	o.H2C = true
+}
+
+var EnableOfflineMode = func(o *ApplicationConfig) {
+	o.OfflineMode = true
+}
+
+// EnablePreloadInBackground installs the models to preload once the API is started, the readiness check
+// failing until they are installed, instead of before starting the API
+var EnablePreloadInBackground = func(o *ApplicationConfig) {
+	o.PreloadInBackground = true
+}
+
+// EnableForwardBackendLogs writes the output of the backends to the logs of LocalAI, at the level of each line
+var EnableForwardBackendLogs = func(o *ApplicationConfig) {
+	o.ForwardBackendLogs = true
+}
+
+// EnableTracing exports OpenTelemetry traces of the requests and of the backend calls
+var EnableTracing = func(o *ApplicationConfig) {
+	o.EnableTracing = true
+}
+
 func WithExternalBackend(name string, uri string) AppOption {
 	return func(o *ApplicationConfig) {
 		if o.ExternalGRPCBackends == nil {
@@ -184,12 +375,49 @@ func WithExternalBackend(name string, uri string) AppOption {
 	}
 }
 
+// WithContainerRuntime sets the runtime (docker, podman) running the external backends given as
+// container://image URIs
+func WithContainerRuntime(runtime string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ContainerRuntime = runtime
+	}
+}
+
 func WithCorsAllowOrigins(b string) AppOption {
 	return func(o *ApplicationConfig) {
 		o.CORSAllowOrigins = b
 	}
 }
 
+// WithCorsAllowMethods sets the methods allowed by the preflight requests, comma-separated
+func WithCorsAllowMethods(methods string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.CORSAllowMethods = methods
+	}
+}
+
+// WithCorsAllowHeaders sets the headers allowed by the preflight requests, comma-separated. When empty,
+// the headers requested by the preflight are allowed.
+func WithCorsAllowHeaders(headers string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.CORSAllowHeaders = headers
+	}
+}
+
+// WithCorsAllowCredentials allows the browsers to send the credentials, which requires the allowed origins
+func WithCorsAllowCredentials(b bool) AppOption {
+	return func(o *ApplicationConfig) {
+		o.CORSAllowCredentials = b
+	}
+}
+
+// WithCorsMaxAge sets how long, in seconds, the browsers cache the preflight responses (0 is not cached)
+func WithCorsMaxAge(seconds int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.CORSMaxAge = seconds
+	}
+}
+
 func WithBackendAssetsOutput(out string) AppOption {
 	return func(o *ApplicationConfig) {
 		o.AssetsDestination = out
@@ -245,12 +473,190 @@ func WithConfigFile(configFile string) AppOption {
 	}
 }
 
+// WithConfigURL loads the model configurations served at url, either a configuration file or a manifest
+// listing the URLs of the configuration files, fetching them again every refresh (0 disables it)
+func WithConfigURL(url string, refresh time.Duration) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ConfigURL = url
+		o.ConfigURLRefresh = refresh
+	}
+}
+
+// WithHTTPTimeouts bounds the time spent reading the requests, writing the responses and waiting for
+// the next request on a keep-alive connection (0 is unlimited)
+func WithHTTPTimeouts(read, write, idle time.Duration) AppOption {
+	return func(o *ApplicationConfig) {
+		o.HTTPReadTimeout = read
+		o.HTTPWriteTimeout = write
+		o.HTTPIdleTimeout = idle
+	}
+}
+
+// WithStreamKeepAlive sends an SSE comment every interval the completion streams are idle before their
+// first token, while the backend processes the prompt (0 disables it)
+func WithStreamKeepAlive(interval time.Duration) AppOption {
+	return func(o *ApplicationConfig) {
+		o.StreamKeepAlive = interval
+	}
+}
+
+// WithStreamBatching merges the tokens of the completion streams by batches of tokens in each event, and
+// flushes the events at most every flushInterval (0 flushes them as they are written)
+func WithStreamBatching(tokens int, flushInterval time.Duration) AppOption {
+	return func(o *ApplicationConfig) {
+		o.StreamBatchTokens = tokens
+		o.StreamFlushInterval = flushInterval
+	}
+}
+
+// WithHTTPConcurrency limits the concurrent connections of the API server (0 keeps the default of fiber)
+func WithHTTPConcurrency(concurrency int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.HTTPConcurrency = concurrency
+	}
+}
+
 func WithUploadLimitMB(limit int) AppOption {
 	return func(o *ApplicationConfig) {
 		o.UploadLimitMB = limit
 	}
 }
 
+// WithMaxImagesPerRequest sets how many images a chat request can carry (0 is unlimited)
+func WithMaxImagesPerRequest(n int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.MaxImagesPerRequest = n
+	}
+}
+
+// WithMaxImageSizeMB sets the maximum size of each image in a chat request (0 is unlimited)
+func WithMaxImageSizeMB(size int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.MaxImageSizeMB = size
+	}
+}
+
+// WithMaxChoices caps the number of completions (`n`) a request can ask for (0 is unlimited)
+func WithMaxChoices(n int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.MaxChoices = n
+	}
+}
+
+// WithMaxGeneratedImages caps the number of images (`n`) an image generation request can ask for (0 is unlimited)
+func WithMaxGeneratedImages(n int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.MaxGeneratedImages = n
+	}
+}
+
+// WithImageSafety checks the generated images with the image classification model, whose moderation
+// config maps its labels to the flagged categories. The flagged images are handled according to action
+// (ImageSafetyBlock, ImageSafetyPlaceholder or ImageSafetyFlag). An empty model disables the check.
+func WithImageSafety(model, action string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ImageSafetyModel = model
+		o.ImageSafetyAction = action
+	}
+}
+
+// WithImageSafetyPlaceholder sets the image file replacing the flagged images in ImageSafetyPlaceholder
+// mode. When empty, they are replaced by a black image.
+func WithImageSafetyPlaceholder(path string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ImageSafetyPlaceholder = path
+	}
+}
+
+// WithResponseCache caches up to size responses to deterministic requests for ttl (0 never expires them)
+func WithResponseCache(size int, ttl time.Duration) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ResponseCacheSize = size
+		o.ResponseCacheTTL = ttl
+	}
+}
+
+// WithHealthProbe runs a tiny inference on each loaded model every interval, failing after timeout. The
+// models failing failures probes in a row are reported as unhealthy by the readiness check, and reloaded.
+func WithHealthProbe(interval, timeout time.Duration, failures int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.HealthProbeInterval = interval
+		o.HealthProbeTimeout = timeout
+		o.HealthProbeFailures = failures
+	}
+}
+
+// WithBackendPriorityOverride sets the backends tried first, in order, when loading a model without a
+// backend. The other backends are tried after them, in their default order.
+func WithBackendPriorityOverride(backends []string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.BackendPriorityOverride = backends
+	}
+}
+
+// WithDisabledBackends excludes backends from the ones tried when loading a model without a backend
+func WithDisabledBackends(backends []string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.DisabledBackends = backends
+	}
+}
+
+// WithBackendMapping sets the rules assigning the backends of the models without a backend in their
+// configuration, consulted before the backends are detected
+func WithBackendMapping(rules []BackendMappingRule) AppOption {
+	return func(o *ApplicationConfig) {
+		o.BackendMapping = rules
+	}
+}
+
+// WithBackendLogLines sets the number of lines of output of each backend kept in memory, 0 disables the capture
+func WithBackendLogLines(lines int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.BackendLogLines = lines
+	}
+}
+
+// WithBackendRestart restarts the backend of a model after failures inferences failed in a row. The
+// restarts of a model are spaced by backoff, doubled at each restart, up to maxRestarts (0 is unlimited).
+func WithBackendRestart(failures int, backoff time.Duration, maxRestarts int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.RestartAfterFailures = failures
+		o.RestartBackoff = backoff
+		o.MaxRestarts = maxRestarts
+	}
+}
+
+// WithUsageFile persists the usage of the API keys to path, restoring it on restart
+func WithUsageFile(path string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.UsageFile = path
+	}
+}
+
+// WithScheduler queues the requests to the backends per model, and dispatches them with the given
+// policy ("fifo" or "fair"), running up to maxConcurrency requests at a time (0 is unlimited)
+func WithScheduler(policy string, maxConcurrency int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.SchedulerPolicy = policy
+		o.SchedulerMaxConcurrency = maxConcurrency
+	}
+}
+
+// WithWarmup sends a dummy request to the backends once they loaded a model, so that the first
+// request of the users does not pay for the warmup of the backend
+func WithWarmup(enabled bool) AppOption {
+	return func(o *ApplicationConfig) {
+		o.Warmup = enabled
+	}
+}
+
+// WithCorrelationIDHeader sets the HTTP header carrying the correlation ID of the requests
+func WithCorrelationIDHeader(header string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.CorrelationIDHeader = header
+	}
+}
+
 func WithThreads(threads int) AppOption {
 	return func(o *ApplicationConfig) {
 		if threads == 0 { // 0 is not allowed
@@ -290,6 +696,15 @@ func WithImageDir(imageDir string) AppOption {
 	}
 }
 
+// WithCleanupOnStartup removes at startup the files older than maxAge from the upload, audio and
+// image directories
+func WithCleanupOnStartup(enabled bool, maxAge time.Duration) AppOption {
+	return func(o *ApplicationConfig) {
+		o.CleanupOnStartup = enabled
+		o.CleanupMaxAge = maxAge
+	}
+}
+
 func WithUploadDir(uploadDir string) AppOption {
 	return func(o *ApplicationConfig) {
 		o.UploadDir = uploadDir
@@ -320,12 +735,121 @@ func WithApiKeys(apiKeys []string) AppOption {
 	}
 }
 
+// WithApiKeyLabels names the API keys, to attribute their requests in the logs and in the usage
+func WithApiKeyLabels(labels map[string]string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ApiKeyLabels = labels
+	}
+}
+
+// ApiKeyLabel returns the label of the API key, or an empty string if it has none
+func (o *ApplicationConfig) ApiKeyLabel(apiKey string) string {
+	return o.ApiKeyLabels[apiKey]
+}
+
+// ParseApiKeys reads the API keys of a keys file, which is either a list of keys or an object
+// mapping each key to its label
+func ParseApiKeys(dat []byte) ([]string, map[string]string, error) {
+	var keys []string
+	if err := json.Unmarshal(dat, &keys); err == nil {
+		return keys, map[string]string{}, nil
+	}
+
+	labels := map[string]string{}
+	if err := json.Unmarshal(dat, &labels); err != nil {
+		return nil, nil, fmt.Errorf("the API keys must be a list of keys or an object mapping the keys to their labels: %w", err)
+	}
+	keys = make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, labels, nil
+}
+
+// WithApiKeyModels restricts the models each API key can use, with a list of patterns where * matches
+// any sequence of characters. The keys without a list are allowed all the models, or none of them if
+// the default policy is ApiKeyModelsDenyAll.
+func WithApiKeyModels(models map[string][]string, defaultPolicy string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ApiKeyModels = map[string][]*regexp.Regexp{}
+		for key, patterns := range models {
+			o.ApiKeyModels[key] = []*regexp.Regexp{}
+			for _, pattern := range patterns {
+				o.ApiKeyModels[key] = append(o.ApiKeyModels[key], modelPatternRegexp(pattern))
+			}
+		}
+		o.ApiKeyModelsDefaultPolicy = defaultPolicy
+	}
+}
+
+// modelPatternRegexp compiles a model pattern, where * matches any sequence of characters
+func modelPatternRegexp(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSpace(pattern)), `\*`, ".*") + "$")
+}
+
+// ModelAllowedForApiKey reports whether the API key can use the model. Everything is allowed
+// when the API is not protected by keys.
+func (o *ApplicationConfig) ModelAllowedForApiKey(apiKey, model string) bool {
+	if len(o.ApiKeys) == 0 {
+		return true
+	}
+	patterns, exists := o.ApiKeyModels[apiKey]
+	if !exists {
+		return o.ApiKeyModelsDefaultPolicy != ApiKeyModelsDenyAll
+	}
+	for _, p := range patterns {
+		if p.MatchString(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithApiKeyQuotas limits the tokens each API key can use per window of time. Once over its budget, the
+// requests of a key are rejected until the end of the window in ApiKeyQuotaHard mode, and only logged in
+// ApiKeyQuotaSoft mode. The keys without a quota are not limited.
+func WithApiKeyQuotas(quotas map[string]ApiKeyQuota, mode string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.ApiKeyQuotas = quotas
+		o.ApiKeyQuotaMode = mode
+	}
+}
+
 func WithEnforcedPredownloadScans(enforced bool) AppOption {
 	return func(o *ApplicationConfig) {
 		o.EnforcePredownloadScans = enforced
 	}
 }
 
+func WithTrustedKeys(keys []string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.TrustedKeys = keys
+	}
+}
+
+// WithDownloadConcurrency sets the number of files downloaded at once by the installs of the models
+func WithDownloadConcurrency(n int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.DownloadConcurrency = n
+	}
+}
+
+// WithMaxDownloadRate caps the rate of the downloads of the models together, in bytes per second
+func WithMaxDownloadRate(bytesPerSecond int64) AppOption {
+	return func(o *ApplicationConfig) {
+		o.MaxDownloadRate = bytesPerSecond
+	}
+}
+
+// WithDiskHeadroomMB sets the space left free on a volume by the downloads and the extractions, which fail
+// early if they don't fit
+func WithDiskHeadroomMB(headroom int) AppOption {
+	return func(o *ApplicationConfig) {
+		o.DiskHeadroomMB = headroom
+	}
+}
+
 func WithOpaqueErrors(opaque bool) AppOption {
 	return func(o *ApplicationConfig) {
 		o.OpaqueErrors = opaque
@@ -338,6 +862,14 @@ func WithLoadToMemory(models []string) AppOption {
 	}
 }
 
+// WithKeepWarm exempts the models from the idle check of the watchdog: they are still loaded on their
+// first request, but then never stopped for being idle
+func WithKeepWarm(models []string) AppOption {
+	return func(o *ApplicationConfig) {
+		o.KeepWarm = models
+	}
+}
+
 func WithSubtleKeyComparison(subtle bool) AppOption {
 	return func(o *ApplicationConfig) {
 		o.UseSubtleKeyComparison = subtle
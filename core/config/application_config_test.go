@@ -0,0 +1,106 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplicationConfig", func() {
+	Context("ModelAllowedForApiKey", func() {
+		models := map[string][]string{
+			"key-a": {"llama-*", "gpt-4"},
+			"key-b": {"whisper-1"},
+		}
+
+		It("matches the models against the allow-list of the key", func() {
+			o := NewApplicationConfig(WithApiKeys([]string{"key-a", "key-b", "key-c"}), WithApiKeyModels(models, ApiKeyModelsAllowAll))
+			Expect(o.ModelAllowedForApiKey("key-a", "llama-3-8b")).To(BeTrue())
+			Expect(o.ModelAllowedForApiKey("key-a", "gpt-4")).To(BeTrue())
+			Expect(o.ModelAllowedForApiKey("key-a", "gpt-4o")).To(BeFalse())
+			Expect(o.ModelAllowedForApiKey("key-a", "whisper-1")).To(BeFalse())
+			Expect(o.ModelAllowedForApiKey("key-b", "whisper-1")).To(BeTrue())
+			Expect(o.ModelAllowedForApiKey("key-b", "llama-3-8b")).To(BeFalse())
+		})
+
+		It("applies the default policy to the keys without an allow-list", func() {
+			o := NewApplicationConfig(WithApiKeys([]string{"key-a", "key-c"}), WithApiKeyModels(models, ApiKeyModelsAllowAll))
+			Expect(o.ModelAllowedForApiKey("key-c", "gpt-4o")).To(BeTrue())
+
+			o = NewApplicationConfig(WithApiKeys([]string{"key-a", "key-c"}), WithApiKeyModels(models, ApiKeyModelsDenyAll))
+			Expect(o.ModelAllowedForApiKey("key-c", "gpt-4o")).To(BeFalse())
+			Expect(o.ModelAllowedForApiKey("key-a", "gpt-4")).To(BeTrue())
+		})
+
+		It("allows everything when the API is not protected by keys", func() {
+			o := NewApplicationConfig(WithApiKeyModels(models, ApiKeyModelsDenyAll))
+			Expect(o.ModelAllowedForApiKey("", "gpt-4o")).To(BeTrue())
+		})
+
+		It("does not treat the patterns as regular expressions", func() {
+			o := NewApplicationConfig(WithApiKeys([]string{"key-a"}), WithApiKeyModels(map[string][]string{"key-a": {"model.v1"}}, ApiKeyModelsAllowAll))
+			Expect(o.ModelAllowedForApiKey("key-a", "model.v1")).To(BeTrue())
+			Expect(o.ModelAllowedForApiKey("key-a", "modelxv1")).To(BeFalse())
+		})
+	})
+
+	Context("ParseApiKeys", func() {
+		It("reads a list of keys", func() {
+			keys, labels, err := ParseApiKeys([]byte(`["key-a", "key-b"]`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(Equal([]string{"key-a", "key-b"}))
+			Expect(labels).To(BeEmpty())
+		})
+
+		It("reads the labels of the keys", func() {
+			keys, labels, err := ParseApiKeys([]byte(`{"key-b": "billing", "key-a": "team-a"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(Equal([]string{"key-a", "key-b"}))
+
+			o := NewApplicationConfig(WithApiKeys(keys), WithApiKeyLabels(labels))
+			Expect(o.ApiKeyLabel("key-a")).To(Equal("team-a"))
+			Expect(o.ApiKeyLabel("key-c")).To(BeEmpty())
+		})
+
+		It("rejects the other formats", func() {
+			_, _, err := ParseApiKeys([]byte(`{"key-a": 1}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("Backend mapping", func() {
+	writeMapping := func(content string) string {
+		file := filepath.Join(GinkgoT().TempDir(), "backends.yaml")
+		Expect(os.WriteFile(file, []byte(content), 0600)).To(Succeed())
+		return file
+	}
+
+	It("maps the models to the backend of the first matching rule", func() {
+		rules, err := ReadBackendMappingFile(writeMapping(`
+- model: "tts-*"
+  backend: my-tts
+- model: "*"
+  backend: transformers
+`))
+		Expect(err).ToNot(HaveOccurred())
+		backend, ok := MappedBackend(rules, "tts-english")
+		Expect(ok).To(BeTrue())
+		Expect(backend).To(Equal("my-tts"))
+		backend, ok = MappedBackend(rules, "llama-3")
+		Expect(ok).To(BeTrue())
+		Expect(backend).To(Equal("transformers"))
+
+		_, ok = MappedBackend(rules[:1], "llama-3")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("rejects the invalid globs and the incomplete rules", func() {
+		_, err := ReadBackendMappingFile(writeMapping(`[{model: "tts-[", backend: my-tts}]`))
+		Expect(err).To(MatchError(ContainSubstring(`invalid model glob "tts-["`)))
+		_, err = ReadBackendMappingFile(writeMapping(`[{model: "tts-*"}]`))
+		Expect(err).To(MatchError(ContainSubstring("needs a model and a backend")))
+	})
+})
@@ -1,19 +1,26 @@
 package config
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/mudler/LocalAI/core/schema"
 	"github.com/mudler/LocalAI/pkg/downloader"
 	"github.com/mudler/LocalAI/pkg/functions"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
 const (
 	RAND_SEED = -1
+	// DETERMINISTIC_SEED is used in deterministic mode when no seed is set
+	DETERMINISTIC_SEED = 1
 )
 
 type TTSConfig struct {
@@ -21,6 +28,10 @@ type TTSConfig struct {
 	// Voice wav path or id
 	Voice string `yaml:"voice"`
 
+	// Voices maps the voices of the requests to the voices of the backend, e.g. the OpenAI voices to
+	// piper models. When set, the requests can only use these voices.
+	Voices map[string]string `yaml:"voices"`
+
 	// Vall-e-x
 	VallE VallE `yaml:"vall-e"`
 }
@@ -28,17 +39,28 @@ type TTSConfig struct {
 type BackendConfig struct {
 	schema.PredictionOptions `yaml:"parameters"`
 	Name                     string `yaml:"name"`
+	// Aliases are other names of the model, served by the same backend
+	Aliases []string `yaml:"aliases"`
 
 	F16                 *bool                  `yaml:"f16"`
 	Threads             *int                   `yaml:"threads"`
 	Debug               *bool                  `yaml:"debug"`
 	Roles               map[string]string      `yaml:"roles"`
 	Embeddings          *bool                  `yaml:"embeddings"`
+	EmbeddingsBatchSize int                    `yaml:"embeddings_batch_size"` // Maximum number of inputs sent to the backend in a batch when computing embeddings, all of them when not set
+	Pooling             string                 `yaml:"pooling"`               // Pooling of the embeddings: mean, cls or last, the default of the backend for the model if empty
+	Matryoshka          bool                   `yaml:"embeddings_matryoshka"` // The model was trained with Matryoshka representations: its embeddings can be reduced to fewer dimensions
 	Backend             string                 `yaml:"backend"`
+	RequestTimeout      string                 `yaml:"request_timeout"` // Maximum duration of the text generation requests, e.g. 2m. Empty for no timeout
 	TemplateConfig      TemplateConfig         `yaml:"template"`
 	KnownUsecaseStrings []string               `yaml:"known_usecases"`
 	KnownUsecases       *BackendConfigUsecases `yaml:"-"`
 
+	// MaxContextLength is the context length the model was trained with, read from the GGUF metadata
+	MaxContextLength int `yaml:"-"`
+	// EndOfTurnTokens are the tokens the model ends its turns with, read from the GGUF metadata
+	EndOfTurnTokens []string `yaml:"-"`
+
 	PromptStrings, InputStrings                []string               `yaml:"-"`
 	InputToken                                 [][]int                `yaml:"-"`
 	functionCallString, functionCallNameString string                 `yaml:"-"`
@@ -61,9 +83,22 @@ type BackendConfig struct {
 	// GRPC Options
 	GRPC GRPC `yaml:"grpc"`
 
+	// ExtraOptions are backend specific options, forwarded as they are to the backend when loading
+	// the model, for the options not yet part of the config
+	ExtraOptions map[string]string `yaml:"extra_options"`
+
 	// TTS specifics
 	TTSConfig `yaml:"tts"`
 
+	// Moderation specifics
+	Moderation ModerationConfig `yaml:"moderation"`
+
+	// Request scheduling, when queueing is enabled
+	Scheduler SchedulerConfig `yaml:"scheduler"`
+
+	// Warmup request sent after loading the model, when warmup is enabled
+	Warmup WarmupConfig `yaml:"warmup"`
+
 	// CUDA
 	// Explicitly enable CUDA or not (some backends might need it)
 	CUDA bool `yaml:"cuda"`
@@ -80,6 +115,34 @@ type File struct {
 	URI      downloader.URI `yaml:"uri" json:"uri"`
 }
 
+// ModerationConfig maps the labels of a classifier model to the OpenAI moderation categories
+type ModerationConfig struct {
+	// Categories maps an OpenAI moderation category (e.g. "hate", "violence") to the
+	// classifier labels that score it. The category score is the highest label score.
+	Categories map[string][]string `yaml:"categories"`
+	// Threshold is the score above which a category is flagged (defaults to 0.5)
+	Threshold float32 `yaml:"threshold"`
+}
+
+// SchedulerConfig sets how the requests to the model are dispatched by the request scheduler
+type SchedulerConfig struct {
+	// MaxConcurrency is the number of requests to the model running at a time. Defaults to 1,
+	// or to unlimited when parallel backend requests are enabled.
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// Weight is the share of the dispatched requests the model gets when other models are queued too (defaults to 1)
+	Weight int `yaml:"weight"`
+}
+
+// WarmupConfig sets the dummy request sent to the backend once the model is loaded
+type WarmupConfig struct {
+	// Disable skips the warmup of the model
+	Disable bool `yaml:"disable"`
+	// Prompt of the warmup request, the default depends on the kind of model (text, embeddings or image)
+	Prompt string `yaml:"prompt"`
+	// Tokens is the number of tokens generated by the warmup of text models (defaults to 1)
+	Tokens int `yaml:"tokens"`
+}
+
 type VallE struct {
 	AudioPath string `yaml:"audio_path"`
 }
@@ -92,8 +155,11 @@ func (ff FeatureFlag) Enabled(s string) bool {
 }
 
 type GRPC struct {
-	Attempts          int `yaml:"attempts"`
-	AttemptsSleepTime int `yaml:"attempts_sleep_time"`
+	Attempts          int    `yaml:"attempts"`
+	AttemptsSleepTime int    `yaml:"attempts_sleep_time"`
+	WorkDir           string `yaml:"workdir"`
+	// CPUAffinity binds the backend process to a set of CPUs, such as "0-15,32-47"
+	CPUAffinity string `yaml:"cpu_affinity"`
 }
 
 type Diffusers struct {
@@ -129,6 +195,7 @@ type LLMConfig struct {
 	LowVRAM         *bool    `yaml:"low_vram"`
 	Grammar         string   `yaml:"grammar"`
 	StopWords       []string `yaml:"stopwords"`
+	AutoStopWords   *bool    `yaml:"auto_stopwords"` // Add the end-of-turn tokens of the model to the stop words of the requests without stop. Enabled if unset
 	Cutstrings      []string `yaml:"cutstrings"`
 	ExtractRegex    []string `yaml:"extract_regex"`
 	TrimSpace       []string `yaml:"trimspace"`
@@ -136,6 +203,7 @@ type LLMConfig struct {
 
 	ContextSize          *int    `yaml:"context_size"`
 	NUMA                 bool    `yaml:"numa"`
+	NUMAPolicy           string  `yaml:"numa_policy"`
 	LoraAdapter          string  `yaml:"lora_adapter"`
 	LoraBase             string  `yaml:"lora_base"`
 	LoraScale            float32 `yaml:"lora_scale"`
@@ -161,6 +229,18 @@ type LLMConfig struct {
 	YarnAttnFactor float32 `yaml:"yarn_attn_factor"`
 	YarnBetaFast   float32 `yaml:"yarn_beta_fast"`
 	YarnBetaSlow   float32 `yaml:"yarn_beta_slow"`
+	YarnOrigCtx    int     `yaml:"yarn_orig_ctx"`
+
+	// LoraAdapters are the adapters that requests can select by name with `adapter`
+	LoraAdapters []LoraAdapter `yaml:"lora_adapters"`
+}
+
+// LoraAdapter is a LoRA adapter of the model that can be applied per request
+type LoraAdapter struct {
+	Name string `yaml:"name"`
+	// Path is relative to the model file directory
+	Path  string  `yaml:"path"`
+	Scale float32 `yaml:"scale"`
 }
 
 // AutoGPTQ is a struct that holds the configuration specific to the AutoGPTQ backend
@@ -197,6 +277,15 @@ type TemplateConfig struct {
 	// It defaults to \n
 	JoinChatMessagesByCharacter *string `yaml:"join_chat_messages_by_character"`
 
+	// ForcedSystemMessage is sent to the model with every chat request, whatever system message the
+	// client sends: it is prepended to the system message of the request, or added as the first message
+	ForcedSystemMessage string `yaml:"forced_system_message"`
+
+	// PromptPrefix and PromptSuffix are added around the prompts of the chat and completion requests,
+	// once the templates are applied. They are not used with the tokenizer template.
+	PromptPrefix string `yaml:"prompt_prefix"`
+	PromptSuffix string `yaml:"prompt_suffix"`
+
 	Video string `yaml:"video"`
 	Image string `yaml:"image"`
 	Audio string `yaml:"audio"`
@@ -375,6 +464,8 @@ func (cfg *BackendConfig) SetDefaults(opts ...ConfigLoaderOption) {
 		cfg.ContextSize = &ctx
 	}
 
+	cfg.clampSampling()
+
 	if threads == 0 {
 		// Threads can't be 0
 		threads = 4
@@ -406,6 +497,9 @@ func (c *BackendConfig) Validate() bool {
 	}
 	validationTargets := []string{c.Backend, c.Model, c.MMProj}
 	validationTargets = append(validationTargets, downloadedFileNames...)
+	for _, a := range c.LoraAdapters {
+		validationTargets = append(validationTargets, a.Path)
+	}
 	// Simple validation to make sure the model can be correctly loaded
 	for _, n := range validationTargets {
 		if n == "" {
@@ -417,6 +511,21 @@ func (c *BackendConfig) Validate() bool {
 		}
 	}
 
+	if err := c.ValidateAffinity(); err != nil {
+		log.Warn().Err(err).Str("model", c.Name).Msg("invalid NUMA or CPU affinity options")
+		return false
+	}
+
+	if _, err := c.RequestTimeoutDuration(); err != nil {
+		log.Warn().Err(err).Str("model", c.Name).Msg("invalid request timeout")
+		return false
+	}
+
+	if err := c.ValidateRope(); err != nil {
+		log.Warn().Err(err).Str("model", c.Name).Msg("invalid RoPE scaling options")
+		return false
+	}
+
 	if c.Backend != "" {
 		// a regex that checks that is a string name with no special characters, except '-' and '_'
 		re := regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
@@ -426,10 +535,226 @@ func (c *BackendConfig) Validate() bool {
 	return true
 }
 
+// ValidateAffinity checks the NUMA policy, and that the CPUs of the affinity are available
+func (c *BackendConfig) ValidateAffinity() error {
+	switch c.NUMAPolicy {
+	case "", "distribute", "isolate", "numactl":
+	default:
+		return fmt.Errorf("invalid numa_policy %q: must be distribute, isolate or numactl", c.NUMAPolicy)
+	}
+	if c.GRPC.CPUAffinity == "" {
+		return nil
+	}
+	cpus, err := xsysinfo.ParseCPUSet(c.GRPC.CPUAffinity)
+	if err != nil {
+		return err
+	}
+	return xsysinfo.CheckCPUSet(cpus)
+}
+
+// RequestTimeoutDuration parses the request timeout of the model, 0 when it has none
+func (c *BackendConfig) RequestTimeoutDuration() (time.Duration, error) {
+	if c.RequestTimeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(c.RequestTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid request_timeout %q: %w", c.RequestTimeout, err)
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("request_timeout must be positive, got %s", c.RequestTimeout)
+	}
+	return timeout, nil
+}
+
+// ValidateRope checks the RoPE scaling options and, when the context length of the model is known, that
+// the context size fits in the length they extend it to
+func (c *BackendConfig) ValidateRope() error {
+	switch c.RopeScaling {
+	case "", "none", "linear", "yarn":
+	default:
+		return fmt.Errorf("invalid rope_scaling %q: must be none, linear or yarn", c.RopeScaling)
+	}
+	if c.RopeFreqScale < 0 || c.RopeFreqScale > 1 {
+		return fmt.Errorf("rope_freq_scale must be between 0 and 1, got %v", c.RopeFreqScale)
+	}
+	if c.RopeFreqBase < 0 {
+		return fmt.Errorf("rope_freq_base must be positive, got %v", c.RopeFreqBase)
+	}
+	if c.YarnOrigCtx < 0 {
+		return fmt.Errorf("yarn_orig_ctx must be positive, got %d", c.YarnOrigCtx)
+	}
+	if c.ropeExtended() && c.MaxContextLength > 0 && c.ContextSize != nil {
+		if length := c.EffectiveContextLength(); *c.ContextSize > length {
+			return fmt.Errorf("context_size %d exceeds the context length of the model extended by the RoPE scaling (%d tokens)", *c.ContextSize, length)
+		}
+	}
+	return nil
+}
+
+// ropeExtended reports whether the RoPE scaling of the config extends the context of the model
+func (c *BackendConfig) ropeExtended() bool {
+	return c.RopeScaling != "none" && c.RopeFreqScale > 0 && c.RopeFreqScale < 1
+}
+
+// EffectiveContextLength is the context length of the model, extended by the RoPE frequency scale of the
+// config: a scale of 0.25 extends it 4 times. It is 0 when the context length of the model is unknown.
+func (c *BackendConfig) EffectiveContextLength() int {
+	if !c.ropeExtended() {
+		return c.MaxContextLength
+	}
+	length := c.MaxContextLength
+	if c.RopeScaling == "yarn" && c.YarnOrigCtx > 0 {
+		length = c.YarnOrigCtx
+	}
+	return int(math.Round(float64(length) / float64(c.RopeFreqScale)))
+}
+
+// samplingRange is the range of a sampling parameter
+type samplingRange struct {
+	name     string
+	value    *float64
+	min, max float64
+	set      func(float64)
+}
+
+func (c *BackendConfig) samplingRanges() []samplingRange {
+	return []samplingRange{
+		{"temperature", c.Temperature, 0, 2, func(v float64) { c.Temperature = &v }},
+		{"top_p", c.TopP, 0, 1, func(v float64) { c.TopP = &v }},
+		{"typical_p", c.TypicalP, 0, 1, func(v float64) { c.TypicalP = &v }},
+		{"tfz", c.TFZ, 0, 1, func(v float64) { c.TFZ = &v }},
+		{"frequency_penalty", &c.FrequencyPenalty, -2, 2, func(v float64) { c.FrequencyPenalty = v }},
+		{"presence_penalty", &c.PresencePenalty, -2, 2, func(v float64) { c.PresencePenalty = v }},
+		{"repeat_penalty", &c.RepeatPenalty, 0, math.Inf(1), func(v float64) { c.RepeatPenalty = v }},
+	}
+}
+
+// ValidateSampling checks the ranges of the sampling parameters of a request overriding the defaults
+// of the model. The defaults out of range are clamped when the config is loaded.
+func (c *BackendConfig) ValidateSampling() error {
+	for _, r := range c.samplingRanges() {
+		if r.value == nil || (*r.value >= r.min && *r.value <= r.max) {
+			continue
+		}
+		if math.IsInf(r.max, 1) {
+			return fmt.Errorf("%s must be a positive number, got %g", r.name, *r.value)
+		}
+		return fmt.Errorf("%s must be between %g and %g, got %g", r.name, r.min, r.max, *r.value)
+	}
+	if c.TopK != nil && *c.TopK < 0 {
+		return fmt.Errorf("top_k must be a positive number, got %d", *c.TopK)
+	}
+	return nil
+}
+
+// clampSampling brings the sampling parameters of the config back in their ranges, warning about
+// the ones out of range, so that the model stays usable with the closest valid defaults
+func (c *BackendConfig) clampSampling() {
+	for _, r := range c.samplingRanges() {
+		if r.value == nil || (*r.value >= r.min && *r.value <= r.max) {
+			continue
+		}
+		clamped := min(max(*r.value, r.min), r.max)
+		log.Warn().Str("model", c.Name).Msgf("%s must be between %g and %g, got %g: using %g", r.name, r.min, r.max, *r.value, clamped)
+		r.set(clamped)
+	}
+	if c.TopK != nil && *c.TopK < 0 {
+		log.Warn().Str("model", c.Name).Msgf("top_k must be a positive number, got %d: using 0", *c.TopK)
+		zero := 0
+		c.TopK = &zero
+	}
+}
+
+// SupportsLoraHotSwap returns true if the backend can switch LoRA adapters per request,
+// without reloading the model
+func (c *BackendConfig) SupportsLoraHotSwap() bool {
+	return c.isLlamaCPPBackend()
+}
+
+// FindLoraAdapter returns the LoRA adapter registered with the given name
+func (c *BackendConfig) FindLoraAdapter(name string) (LoraAdapter, bool) {
+	for _, a := range c.LoraAdapters {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return LoraAdapter{}, false
+}
+
+// SupportsGrammar returns true if the backend can constrain the generation
+// with BNF grammars (used by tools and structured outputs)
+func (c *BackendConfig) SupportsGrammar() bool {
+	return c.isLlamaCPPBackend()
+}
+
+// SupportsLogprobs returns true if the backend can return the log probabilities of the generated tokens
+func (c *BackendConfig) SupportsLogprobs() bool {
+	return c.isLlamaCPPBackend()
+}
+
+// SupportsImages returns true if the model can take images as input.
+// llama.cpp models need a multimodal projector (mmproj), while the
+// backends listed below are known to be text or audio only.
+func (c *BackendConfig) SupportsImages() bool {
+	if c.isLlamaCPPBackend() {
+		return c.MMProj != ""
+	}
+	switch c.Backend {
+	case "llama-ggml", "rwkv", "bert-embeddings", "whisper", "piper", "stablediffusion", "tinydream":
+		return false
+	}
+	return true
+}
+
+// isLlamaCPPBackend returns true if the model runs on llama.cpp (the default backend)
+func (c *BackendConfig) isLlamaCPPBackend() bool {
+	switch c.Backend {
+	case "", "llama", "go-llama":
+		return true
+	}
+	return strings.HasPrefix(c.Backend, "llama-cpp")
+}
+
 func (c *BackendConfig) HasTemplate() bool {
 	return c.TemplateConfig.Completion != "" || c.TemplateConfig.Edit != "" || c.TemplateConfig.Chat != "" || c.TemplateConfig.ChatMessage != ""
 }
 
+// AutoStops returns the end-of-turn tokens of the model to add to the stop words of a request that doesn't
+// set its own. The tokens already stop words are skipped, and so are the ones the processing of the output
+// relies on (e.g. a function response regex or a cut string), as the model needs to emit them.
+func (c *BackendConfig) AutoStops() []string {
+	if c.AutoStopWords != nil && !*c.AutoStopWords {
+		return nil
+	}
+
+	var stops []string
+	for _, token := range c.EndOfTurnTokens {
+		if token == "" || slices.Contains(c.StopWords, token) || slices.Contains(stops, token) || c.outputUsesToken(token) {
+			continue
+		}
+		stops = append(stops, token)
+	}
+	return stops
+}
+
+// outputUsesToken reports whether the processing of the output of the model looks for the token
+func (c *BackendConfig) outputUsesToken(token string) bool {
+	patterns := slices.Concat(c.Cutstrings, c.ExtractRegex, c.TrimSuffix,
+		c.FunctionsConfig.ResponseRegex, c.FunctionsConfig.JSONRegexMatch, c.FunctionsConfig.CaptureLLMResult)
+	for _, r := range slices.Concat(c.FunctionsConfig.ReplaceFunctionResults, c.FunctionsConfig.ReplaceLLMResult) {
+		patterns = append(patterns, r.Key)
+	}
+
+	quoted := regexp.QuoteMeta(token)
+	for _, p := range patterns {
+		if strings.Contains(p, token) || strings.Contains(p, quoted) {
+			return true
+		}
+	}
+	return false
+}
+
 type BackendConfigUsecases int
 
 const (
@@ -443,6 +768,7 @@ const (
 	FLAG_TRANSCRIPT       BackendConfigUsecases = 0b001000000
 	FLAG_TTS              BackendConfigUsecases = 0b010000000
 	FLAG_SOUND_GENERATION BackendConfigUsecases = 0b100000000
+	FLAG_MODERATION       BackendConfigUsecases = 0b1000000000
 
 	// Common Subsets
 	FLAG_LLM BackendConfigUsecases = FLAG_CHAT & FLAG_COMPLETION & FLAG_EDIT
@@ -460,6 +786,7 @@ func GetAllBackendConfigUsecases() map[string]BackendConfigUsecases {
 		"FLAG_TRANSCRIPT":       FLAG_TRANSCRIPT,
 		"FLAG_TTS":              FLAG_TTS,
 		"FLAG_SOUND_GENERATION": FLAG_SOUND_GENERATION,
+		"FLAG_MODERATION":       FLAG_MODERATION,
 		"FLAG_LLM":              FLAG_LLM,
 	}
 }
@@ -487,6 +814,35 @@ func (c *BackendConfig) HasUsecases(u BackendConfigUsecases) bool {
 	return c.GuessUsecases(u)
 }
 
+// Capabilities reports what the model can be used for, derived from its config and backend
+func (c *BackendConfig) Capabilities() schema.ModelCapabilities {
+	caps := schema.ModelCapabilities{
+		Chat:             c.HasUsecases(FLAG_CHAT),
+		Completion:       c.HasUsecases(FLAG_COMPLETION),
+		Embeddings:       c.HasUsecases(FLAG_EMBEDDINGS),
+		Image:            c.HasUsecases(FLAG_IMAGE),
+		Transcription:    c.HasUsecases(FLAG_TRANSCRIPT),
+		TTS:              c.HasUsecases(FLAG_TTS),
+		SoundGeneration:  c.HasUsecases(FLAG_SOUND_GENERATION),
+		Rerank:           c.HasUsecases(FLAG_RERANK),
+		Moderation:       c.HasUsecases(FLAG_MODERATION),
+		MaxContextLength: c.EffectiveContextLength(),
+	}
+	if caps.MaxContextLength != c.MaxContextLength {
+		caps.TrainedContextLength = c.MaxContextLength
+	}
+
+	if caps.Chat || caps.Completion {
+		if c.ContextSize != nil {
+			caps.ContextSize = *c.ContextSize
+		}
+		// tools are either constrained with grammars, or parsed from the model output
+		caps.Tools = caps.Chat && (c.SupportsGrammar() || c.TemplateConfig.Functions != "" || c.FunctionsConfig.ToolCallParser != "")
+	}
+
+	return caps
+}
+
 // GuessUsecases is a **heuristic based** function, as the backend in question may not be loaded yet, and the config may not record what it's useful at.
 // In its current state, this function should ideally check for properties of the config like templates, rather than the direct backend name checks for the lower half.
 // This avoids the maintenance burden of updating this list for each new backend - but unfortunately, that's the best option for some services currently.
@@ -545,5 +901,11 @@ func (c *BackendConfig) GuessUsecases(u BackendConfigUsecases) bool {
 		}
 	}
 
+	if (u & FLAG_MODERATION) == FLAG_MODERATION {
+		if len(c.Moderation.Categories) == 0 {
+			return false
+		}
+	}
+
 	return true
 }
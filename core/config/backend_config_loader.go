@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -179,11 +180,20 @@ func (bcl *BackendConfigLoader) LoadBackendConfig(file string, opts ...ConfigLoa
 	return nil
 }
 
+// GetBackendConfig returns the config of the model named m, or of the model having m among its
+// aliases. The name of a model takes precedence over the aliases of the others.
 func (bcl *BackendConfigLoader) GetBackendConfig(m string) (BackendConfig, bool) {
 	bcl.Lock()
 	defer bcl.Unlock()
-	v, exists := bcl.configs[m]
-	return v, exists
+	if v, exists := bcl.configs[m]; exists {
+		return v, true
+	}
+	for _, v := range bcl.configs {
+		if slices.Contains(v.Aliases, m) {
+			return v, true
+		}
+	}
+	return BackendConfig{}, false
 }
 
 func (bcl *BackendConfigLoader) GetAllBackendConfigs() []BackendConfig {
@@ -4,9 +4,11 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
 )
 
 var _ = Describe("Test cases for config related functions", func() {
@@ -65,6 +67,31 @@ parameters:
 			Expect(config.Name).To(Equal("hermes-2-pro-mistral"))
 			Expect(config.Validate()).To(BeTrue())
 		})
+		It("Clamps the sampling parameters of the config out of range", func() {
+			tmp, err := os.CreateTemp("", "config.yaml")
+			Expect(err).To(BeNil())
+			defer os.Remove(tmp.Name())
+			_, err = tmp.WriteString(
+				`name: coder
+parameters:
+  model: "coder.gguf"
+  temperature: 0
+  top_p: 80
+  presence_penalty: -3`)
+			Expect(err).ToNot(HaveOccurred())
+			config, err := readBackendConfigFromFile(tmp.Name())
+			Expect(err).To(BeNil())
+			// the model is kept, with the closest valid defaults
+			Expect(config.Validate()).To(BeTrue())
+			Expect(config.ValidateSampling()).To(Succeed())
+			Expect(*config.TopP).To(Equal(1.0))
+			Expect(config.PresencePenalty).To(Equal(-2.0))
+			Expect(*config.Temperature).To(Equal(0.0))
+
+			// the values of the requests are not clamped
+			config.PresencePenalty = -3
+			Expect(config.ValidateSampling()).To(MatchError("presence_penalty must be between -2 and 2, got -3"))
+		})
 	})
 	It("Properly handles backend usecase matching", func() {
 
@@ -146,6 +173,7 @@ parameters:
 		Expect(h.HasUsecases(FLAG_TRANSCRIPT)).To(BeFalse())
 		Expect(h.HasUsecases(FLAG_TTS)).To(BeTrue())
 		Expect(h.HasUsecases(FLAG_SOUND_GENERATION)).To(BeTrue())
+		Expect(h.HasUsecases(FLAG_MODERATION)).To(BeFalse())
 
 		knownUsecases := FLAG_CHAT | FLAG_COMPLETION
 		i := BackendConfig{
@@ -161,4 +189,150 @@ parameters:
 		Expect(i.HasUsecases(FLAG_CHAT)).To(BeTrue())
 
 	})
+	It("Reports the model capabilities", func() {
+		ctx := 4096
+		llm := BackendConfig{
+			Name:    "llm",
+			Backend: "llama-cpp",
+			TemplateConfig: TemplateConfig{
+				Chat: "chat",
+			},
+			LLMConfig: LLMConfig{
+				ContextSize: &ctx,
+			},
+			MaxContextLength: 8192,
+		}
+		caps := llm.Capabilities()
+		Expect(caps.Chat).To(BeTrue())
+		Expect(caps.Completion).To(BeFalse())
+		Expect(caps.Image).To(BeFalse())
+		Expect(caps.Tools).To(BeTrue())
+		Expect(caps.ContextSize).To(Equal(4096))
+		Expect(caps.MaxContextLength).To(Equal(8192))
+
+		image := BackendConfig{
+			Name:    "image",
+			Backend: "stablediffusion",
+		}
+		caps = image.Capabilities()
+		Expect(caps.Image).To(BeTrue())
+		Expect(caps.Chat).To(BeFalse())
+		Expect(caps.Tools).To(BeFalse())
+		Expect(caps.ContextSize).To(BeZero())
+	})
+	It("Memory maps the models unless their config disables it", func() {
+		mmap := func(config string) *bool {
+			cfg := BackendConfig{}
+			Expect(yaml.Unmarshal([]byte(config), &cfg)).To(Succeed())
+			cfg.SetDefaults()
+			return cfg.MMap
+		}
+		Expect(*mmap("name: llm")).To(BeTrue())
+		Expect(*mmap("mmap: false")).To(BeFalse())
+
+		// the per model option overrides the default of the Intel GPUs
+		os.Setenv("XPU", "1")
+		DeferCleanup(os.Unsetenv, "XPU")
+		Expect(*mmap("name: llm")).To(BeFalse())
+		Expect(*mmap("mmap: true")).To(BeTrue())
+	})
+	It("Validates the NUMA policy and the CPU affinity", func() {
+		c := BackendConfig{Name: "llm"}
+		c.NUMAPolicy = "isolate"
+		c.GRPC.CPUAffinity = "0"
+		Expect(c.ValidateAffinity()).To(Succeed())
+		Expect(c.Validate()).To(BeTrue())
+
+		c.NUMAPolicy = "interleave"
+		Expect(c.ValidateAffinity()).To(MatchError(ContainSubstring("invalid numa_policy")))
+		Expect(c.Validate()).To(BeFalse())
+
+		c.NUMAPolicy = ""
+		c.GRPC.CPUAffinity = "0-3,x"
+		Expect(c.ValidateAffinity()).To(HaveOccurred())
+		c.GRPC.CPUAffinity = "100000"
+		Expect(c.ValidateAffinity()).To(MatchError(ContainSubstring("CPU 100000 is not available")))
+	})
+	It("Parses the request timeout of the model", func() {
+		c := BackendConfig{Name: "llm"}
+		Expect(c.RequestTimeoutDuration()).To(BeZero())
+		c.RequestTimeout = "2m"
+		Expect(c.RequestTimeoutDuration()).To(Equal(2 * time.Minute))
+		Expect(c.Validate()).To(BeTrue())
+		c.RequestTimeout = "2 minutes"
+		_, err := c.RequestTimeoutDuration()
+		Expect(err).To(MatchError(ContainSubstring(`invalid request_timeout "2 minutes"`)))
+		Expect(c.Validate()).To(BeFalse())
+	})
+	It("Extends the context length of the model with the RoPE scaling", func() {
+		contextSize := 16384
+		c := BackendConfig{Name: "llm", MaxContextLength: 4096}
+		c.ContextSize = &contextSize
+		Expect(c.EffectiveContextLength()).To(Equal(4096))
+		Expect(c.ValidateRope()).To(Succeed())
+
+		c.RopeFreqScale = 0.25
+		Expect(c.EffectiveContextLength()).To(Equal(16384))
+		Expect(c.ValidateRope()).To(Succeed())
+		caps := c.Capabilities()
+		Expect(caps.MaxContextLength).To(Equal(16384))
+		Expect(caps.TrainedContextLength).To(Equal(4096))
+
+		c.RopeScaling = "yarn"
+		c.YarnOrigCtx = 2048
+		Expect(c.EffectiveContextLength()).To(Equal(8192))
+		Expect(c.ValidateRope()).To(MatchError("context_size 16384 exceeds the context length of the model extended by the RoPE scaling (8192 tokens)"))
+		Expect(c.Validate()).To(BeFalse())
+
+		c.RopeScaling = "none"
+		Expect(c.EffectiveContextLength()).To(Equal(4096))
+		Expect(c.Capabilities().TrainedContextLength).To(BeZero())
+
+		c.RopeScaling = "ntk"
+		Expect(c.ValidateRope()).To(MatchError(ContainSubstring("invalid rope_scaling")))
+		c.RopeScaling = ""
+		c.RopeFreqScale = 2
+		Expect(c.ValidateRope()).To(MatchError("rope_freq_scale must be between 0 and 1, got 2"))
+	})
+	It("Finds the LoRA adapters that can be applied per request", func() {
+		c := BackendConfig{
+			Name:    "c",
+			Backend: "llama-cpp",
+			LLMConfig: LLMConfig{
+				LoraAdapters: []LoraAdapter{
+					{Name: "sql", Path: "sql.gguf", Scale: 0.5},
+				},
+			},
+		}
+		a, exists := c.FindLoraAdapter("sql")
+		Expect(exists).To(BeTrue())
+		Expect(a.Path).To(Equal("sql.gguf"))
+		_, exists = c.FindLoraAdapter("support")
+		Expect(exists).To(BeFalse())
+		Expect(c.SupportsLoraHotSwap()).To(BeTrue())
+		Expect(c.Validate()).To(BeTrue())
+
+		c.LoraAdapters = append(c.LoraAdapters, LoraAdapter{Name: "evil", Path: "../evil.gguf"})
+		Expect(c.Validate()).To(BeFalse())
+
+		vllm := BackendConfig{Name: "vllm", Backend: "vllm"}
+		Expect(vllm.SupportsLoraHotSwap()).To(BeFalse())
+	})
+	It("Resolves the aliases of the models", func() {
+		bcl := NewBackendConfigLoader("")
+		bcl.configs["gpt-4"] = BackendConfig{Name: "gpt-4", Aliases: []string{"gpt-4-a", "gpt-4-b"}}
+		bcl.configs["gpt-4-b"] = BackendConfig{Name: "gpt-4-b"}
+
+		c, exists := bcl.GetBackendConfig("gpt-4-a")
+		Expect(exists).To(BeTrue())
+		Expect(c.Name).To(Equal("gpt-4"))
+
+		// the name of a model takes precedence over the aliases
+		c, exists = bcl.GetBackendConfig("gpt-4-b")
+		Expect(exists).To(BeTrue())
+		Expect(c.Name).To(Equal("gpt-4-b"))
+
+		_, exists = bcl.GetBackendConfig("gpt-3")
+		Expect(exists).To(BeFalse())
+	})
 })
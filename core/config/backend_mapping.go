@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BackendMappingRule assigns the backend to the models whose name matches the glob, when their
+// configuration sets no backend
+type BackendMappingRule struct {
+	Model   string `yaml:"model"`
+	Backend string `yaml:"backend"`
+}
+
+// ReadBackendMappingFile reads the rules of a backend mapping file, a YAML list of model globs and
+// backends, checking the globs
+func ReadBackendMappingFile(file string) ([]BackendMappingRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the backend mapping file: %w", err)
+	}
+	rules := []BackendMappingRule{}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed parsing the backend mapping file %s: %w", file, err)
+	}
+	for i, r := range rules {
+		if r.Model == "" || r.Backend == "" {
+			return nil, fmt.Errorf("rule %d of the backend mapping file needs a model and a backend", i+1)
+		}
+		if _, err := path.Match(r.Model, ""); err != nil {
+			return nil, fmt.Errorf("invalid model glob %q in the backend mapping file: %w", r.Model, err)
+		}
+	}
+	return rules, nil
+}
+
+// MappedBackend returns the backend of the first rule matching the name of the model
+func MappedBackend(rules []BackendMappingRule, model string) (string, bool) {
+	for _, r := range rules {
+		if ok, _ := path.Match(r.Model, model); ok {
+			return r.Backend, true
+		}
+	}
+	return "", false
+}
@@ -1,6 +1,104 @@
 package config
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
 type Gallery struct {
 	URL  string `json:"url" yaml:"url"`
 	Name string `json:"name" yaml:"name"`
+	// Auth are the credentials of a private gallery, sent with the requests to the host of its URL
+	Auth *GalleryAuth `json:"auth,omitempty" yaml:"auth,omitempty"`
+}
+
+// GalleryAuth are the credentials of a private gallery: a bearer token, a username and a password for the basic
+// authentication, or the value of a header. The secrets can reference an environment variable as ${NAME}, to
+// keep them out of the configuration.
+type GalleryAuth struct {
+	Token    string `json:"token,omitempty" yaml:"token,omitempty"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	Header   string `json:"header,omitempty" yaml:"header,omitempty"`
+	Value    string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// redactedSecret replaces the secrets of the credentials when they are listed or logged
+const redactedSecret = "*****"
+
+var envReference = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// HeaderValue returns the header carrying the credentials and its value, with the environment variables resolved
+func (a GalleryAuth) HeaderValue() (string, string, error) {
+	switch {
+	case a.Token != "" && a.Username == "" && a.Header == "":
+		token, err := resolveSecret(a.Token)
+		if err != nil {
+			return "", "", err
+		}
+		return "Authorization", "Bearer " + token, nil
+	case a.Username != "" && a.Token == "" && a.Header == "":
+		username, err := resolveSecret(a.Username)
+		if err != nil {
+			return "", "", err
+		}
+		password, err := resolveSecret(a.Password)
+		if err != nil {
+			return "", "", err
+		}
+		return "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case a.Header != "" && a.Token == "" && a.Username == "":
+		value, err := resolveSecret(a.Value)
+		if err != nil {
+			return "", "", err
+		}
+		return a.Header, value, nil
+	default:
+		return "", "", fmt.Errorf("the credentials must set one of token, username or header")
+	}
+}
+
+// Redacted returns the credentials with the secrets replaced, keeping the references to environment variables
+func (a GalleryAuth) Redacted() GalleryAuth {
+	redact := func(s string) string {
+		if s == "" || envReference.MatchString(s) {
+			return s
+		}
+		return redactedSecret
+	}
+	a.Token, a.Password, a.Value = redact(a.Token), redact(a.Password), redact(a.Value)
+	return a
+}
+
+// MarshalJSON marshals the credentials without their secrets, as the galleries are listed by the API
+func (a GalleryAuth) MarshalJSON() ([]byte, error) {
+	type auth GalleryAuth
+	return json.Marshal(auth(a.Redacted()))
+}
+
+// MarshalYAML marshals the credentials without their secrets
+func (a GalleryAuth) MarshalYAML() (interface{}, error) {
+	type auth GalleryAuth
+	return auth(a.Redacted()), nil
+}
+
+// String formats the credentials without their secrets, for the logs
+func (a GalleryAuth) String() string {
+	type auth GalleryAuth
+	return fmt.Sprintf("%+v", auth(a.Redacted()))
+}
+
+func resolveSecret(s string) (string, error) {
+	m := envReference.FindStringSubmatch(s)
+	if m == nil {
+		return s, nil
+	}
+	value, ok := os.LookupEnv(m[1])
+	if !ok {
+		return "", fmt.Errorf("the environment variable %s of the credentials is not set", m[1])
+	}
+	return value, nil
 }
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Gallery credentials", func() {
+	It("returns the header of the credentials, with the environment variables resolved", func() {
+		GinkgoT().Setenv("GALLERY_TOKEN", "from-env")
+
+		header, value, err := GalleryAuth{Token: "${GALLERY_TOKEN}"}.HeaderValue()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header).To(Equal("Authorization"))
+		Expect(value).To(Equal("Bearer from-env"))
+
+		_, value, err = GalleryAuth{Username: "user", Password: "pass"}.HeaderValue()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("Basic dXNlcjpwYXNz"))
+
+		header, value, err = GalleryAuth{Header: "X-Api-Key", Value: "$literal"}.HeaderValue()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header).To(Equal("X-Api-Key"))
+		Expect(value).To(Equal("$literal"))
+
+		_, _, err = GalleryAuth{Token: "${MISSING_GALLERY_TOKEN}"}.HeaderValue()
+		Expect(err).To(MatchError(ContainSubstring("MISSING_GALLERY_TOKEN")))
+		_, _, err = GalleryAuth{Token: "token", Header: "X-Api-Key"}.HeaderValue()
+		Expect(err).To(HaveOccurred())
+		_, _, err = GalleryAuth{}.HeaderValue()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not list or log the secrets", func() {
+		g := Gallery{Name: "private", URL: "https://models.example.com/index.yaml", Auth: &GalleryAuth{Username: "user", Password: "hunter2"}}
+		dat, err := json.Marshal([]Gallery{g})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(dat)).ToNot(ContainSubstring("hunter2"))
+		Expect(string(dat)).To(ContainSubstring(`"username":"user"`))
+		Expect(fmt.Sprintf("%+v", g)).ToNot(ContainSubstring("hunter2"))
+
+		g.Auth = &GalleryAuth{Token: "${GALLERY_TOKEN}"}
+		dat, err = json.Marshal(g)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(dat)).To(ContainSubstring("${GALLERY_TOKEN}"))
+
+		// the secrets are read from the configuration
+		Expect(json.Unmarshal([]byte(`{"name":"private","auth":{"token":"secret"}}`), &g)).To(Succeed())
+		Expect(g.Auth.Token).To(Equal("secret"))
+	})
+})
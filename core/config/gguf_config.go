@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mudler/LocalAI/pkg/utils"
+	"github.com/rs/zerolog/log"
+	gguf "github.com/thxcode/gguf-parser-go"
+	"gopkg.in/yaml.v3"
+)
+
+// ggufStarterConfig is the config generated for a GGUF file, with only the settings read or estimated from it
+type ggufStarterConfig struct {
+	Name        string `yaml:"name"`
+	Backend     string `yaml:"backend"`
+	ContextSize int    `yaml:"context_size,omitempty"`
+	GPULayers   *int   `yaml:"gpu_layers,omitempty"`
+	Parameters  struct {
+		Model string `yaml:"model"`
+	} `yaml:"parameters"`
+	StopWords []string          `yaml:"stopwords,omitempty"`
+	Template  map[string]string `yaml:"template,omitempty"`
+}
+
+// maxStarterContextSize caps the context size of the generated configs: the KV cache of the context many
+// models are trained with, 128k tokens and more, would not fit in the memory of most machines
+const maxStarterContextSize = 8192
+
+// GenerateGGUFConfig generates a starter config for a GGUF file of the models directory: the llama-cpp backend,
+// the context size the model was trained with up to maxStarterContextSize, the chat template of its family and, when vram is not zero,
+// the number of layers estimated to fit in that many bytes of VRAM. It returns the name of the model and the
+// YAML of the config, to review before writing it.
+func GenerateGGUFConfig(modelsPath, modelFile string, vram uint64) (string, []byte, error) {
+	rel, err := filepath.Rel(modelsPath, modelFile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil, fmt.Errorf("the GGUF file %s is not in the models directory %s", modelFile, modelsPath)
+	}
+
+	f, err := parseGGUFFile(modelFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot parse the GGUF file %s: %w", modelFile, err)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+	if shards := utils.GGUFShards(modelFile); shards != nil {
+		// the model is named after its shards, and the backend loads the first one
+		name = filepath.Base(shards[0][:len(shards[0])-len("-00001-of-00000.gguf")])
+		rel = filepath.Join(filepath.Dir(rel), filepath.Base(shards[0]))
+	}
+
+	cfg := ggufStarterConfig{
+		Name:        name,
+		Backend:     "llama-cpp",
+		ContextSize: starterContextSize(f.Architecture().MaximumContextLength),
+	}
+	cfg.Parameters.Model = filepath.ToSlash(rel)
+
+	family, source := identifyFamily(f)
+	if settings, ok := defaultsSettings[family]; ok {
+		log.Debug().Str("model", cfg.Name).Any("family", family).Str("source", source).Msg("generated the chat template of the model family")
+		cfg.StopWords = settings.StopWords
+		cfg.Template = templateSettings(settings.TemplateConfig)
+	} else {
+		log.Warn().Str("model", cfg.Name).Msg("the chat format of the model was not recognized, the template has to be written")
+	}
+
+	if vram > 0 {
+		layers := fitGPULayers(f.Architecture().BlockCount+1, vram, func(n uint64) uint64 {
+			e := f.EstimateLLaMACppUsage(gguf.WithContextSize(int32(cfg.ContextSize)), gguf.WithOffloadLayers(n))
+			return uint64(e.SummarizeMemory(true, 0, 0).NonUMA.VRAM)
+		})
+		cfg.GPULayers = &layers
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	return cfg.Name, data, nil
+}
+
+// starterContextSize returns the context size of a generated config for a model trained with the context
+// length trained, unset (0) if it is unknown
+func starterContextSize(trained uint64) int {
+	return int(min(trained, maxStarterContextSize))
+}
+
+// fitGPULayers returns the most layers, up to the layers of the model, whose estimated VRAM fits in vram
+func fitGPULayers(layers, vram uint64, estimate func(n uint64) uint64) int {
+	for n := layers; n > 0; n-- {
+		if estimate(n) <= vram {
+			return int(n)
+		}
+	}
+	return 0
+}
+
+// templateSettings returns the templates set in a template config, by their YAML key
+func templateSettings(t TemplateConfig) map[string]string {
+	templates := map[string]string{}
+	for key, template := range map[string]string{
+		"chat":         t.Chat,
+		"chat_message": t.ChatMessage,
+		"completion":   t.Completion,
+		"function":     t.Functions,
+	} {
+		if template != "" {
+			templates[key] = template
+		}
+	}
+	return templates
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generating the config of a GGUF file", func() {
+	It("fits the most layers in the VRAM", func() {
+		// 1GiB of footprint and 100MiB per layer
+		estimate := func(n uint64) uint64 { return 1<<30 + n*100<<20 }
+
+		Expect(fitGPULayers(33, 2<<30, estimate)).To(Equal(10))
+		Expect(fitGPULayers(33, 8<<30, estimate)).To(Equal(33))
+		Expect(fitGPULayers(33, 512<<20, estimate)).To(Equal(0))
+	})
+
+	It("caps the context size", func() {
+		Expect(starterContextSize(131072)).To(Equal(8192))
+		Expect(starterContextSize(4096)).To(Equal(4096))
+		// unknown, left to the default
+		Expect(starterContextSize(0)).To(Equal(0))
+	})
+
+	It("only generates the config of the files of the models directory", func() {
+		models := GinkgoT().TempDir()
+		_, _, err := GenerateGGUFConfig(models, filepath.Join(filepath.Dir(models), "model.gguf"), 0)
+		Expect(err).To(MatchError(ContainSubstring("is not in the models directory")))
+	})
+
+	It("keeps the templates that are set", func() {
+		Expect(templateSettings(defaultsSettings[LLaMa3].TemplateConfig)).To(Equal(map[string]string{
+			"chat":         defaultsSettings[LLaMa3].TemplateConfig.Chat,
+			"chat_message": defaultsSettings[LLaMa3].TemplateConfig.ChatMessage,
+		}))
+	})
+})
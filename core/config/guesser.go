@@ -1,10 +1,15 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/mudler/LocalAI/pkg/functions"
+	"github.com/mudler/LocalAI/pkg/utils"
 	"github.com/rs/zerolog/log"
 
 	gguf "github.com/thxcode/gguf-parser-go"
@@ -26,14 +31,21 @@ const (
 type settingsConfig struct {
 	StopWords      []string
 	TemplateConfig TemplateConfig
-	RepeatPenalty float64
+	RepeatPenalty  float64
+}
+
+// tool call parsers matching the format each model family emits tool calls in
+var defaultToolCallParsers = map[familyType]string{
+	LLaMa3:    functions.ToolCallParserLLaMa3,
+	ChatML:    functions.ToolCallParserHermes,
+	Mistral03: functions.ToolCallParserMistral,
 }
 
 // default settings to adopt with a given model family
 var defaultsSettings map[familyType]settingsConfig = map[familyType]settingsConfig{
 	Gemma: {
 		RepeatPenalty: 1.0,
-		StopWords: []string{"<|im_end|>", "<end_of_turn>", "<start_of_turn>"},
+		StopWords:     []string{"<|im_end|>", "<end_of_turn>", "<start_of_turn>"},
 		TemplateConfig: TemplateConfig{
 			Chat:        "{{.Input }}\n<start_of_turn>model\n",
 			ChatMessage: "<start_of_turn>{{if eq .RoleName \"assistant\" }}model{{else}}{{ .RoleName }}{{end}}\n{{ if .Content -}}\n{{.Content -}}\n{{ end -}}<end_of_turn>",
@@ -136,12 +148,40 @@ Function response:
 	},
 }
 
+// end-of-turn tokens of each model family, added to the stop words of the requests that don't set their own
+var endOfTurnTokens = map[familyType][]string{
+	LLaMa3:    {"<|eot_id|>"},
+	CommandR:  {"<|END_OF_TURN_TOKEN|>"},
+	Phi3:      {"<|end|>"},
+	ChatML:    {"<|im_end|>"},
+	Gemma:     {"<end_of_turn>"},
+	DeepSeek2: {"<｜end▁of▁sentence｜>"},
+}
+
 // this maps well known template used in HF to model families defined above
 var knownTemplates = map[string]familyType{
 	`{% if messages[0]['role'] == 'system' %}{% set system_message = messages[0]['content'] %}{% endif %}{% if system_message is defined %}{{ system_message }}{% endif %}{% for message in messages %}{% set content = message['content'] %}{% if message['role'] == 'user' %}{{ '<|im_start|>user\n' + content + '<|im_end|>\n<|im_start|>assistant\n' }}{% elif message['role'] == 'assistant' %}{{ content + '<|im_end|>' + '\n' }}{% endif %}{% endfor %}`:                              ChatML,
 	`{{ bos_token }}{% for message in messages %}{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}{% if message['role'] == 'user' %}{{ '[INST] ' + message['content'] + ' [/INST]' }}{% elif message['role'] == 'assistant' %}{{ message['content'] + eos_token}}{% else %}{{ raise_exception('Only user and assistant roles are supported!') }}{% endif %}{% endfor %}`: Mistral03,
 }
 
+// chatTemplateMarkers maps special tokens that identify a chat format to model families.
+// They are used to recognize templates embedded in GGUF files that don't match exactly one of the knownTemplates
+var chatTemplateMarkers = []struct {
+	marker string
+	family familyType
+}{
+	{"<|start_header_id|>", LLaMa3},
+	{"<|START_OF_TURN_TOKEN|>", CommandR},
+	{"<start_of_turn>", Gemma},
+	{"<|im_start|>", ChatML},
+	{"[AVAILABLE_TOOLS]", Mistral03},
+	{"[INST]", Mistral03},
+	{"<|assistant|>", Phi3},
+}
+
+// specialTokenRegex matches the special tokens of a chat template, such as <|im_start|>, <start_of_turn> or [INST]
+var specialTokenRegex = regexp.MustCompile(`<\|[A-Za-z0-9_]+\|>|<[A-Za-z0-9_]+>|\[/?[A-Z_]+\]`)
+
 func guessDefaultsFromFile(cfg *BackendConfig, modelPath string) {
 
 	if os.Getenv("LOCALAI_DISABLE_GUESSING") == "true" {
@@ -155,19 +195,24 @@ func guessDefaultsFromFile(cfg *BackendConfig, modelPath string) {
 	}
 
 	if cfg.HasTemplate() {
-		// nothing to guess here
-		log.Debug().Any("name", cfg.Name).Msgf("guessDefaultsFromFile: %s", "template already set")
+		// We try to guess only if we don't have a template defined already,
+		// without parsing the file
+		log.Debug().Any("name", cfg.Name).Str("source", "config").Msgf("guessDefaultsFromFile: %s", "template already set")
 		return
 	}
 
-	// We try to guess only if we don't have a template defined already
-	f, err := gguf.ParseGGUFFile(filepath.Join(modelPath, cfg.ModelFileName()))
+	f, err := parseGGUFFile(filepath.Join(modelPath, cfg.ModelFileName()))
 	if err != nil {
 		// Only valid for gguf files
 		log.Debug().Msgf("guessDefaultsFromFile: %s", "not a GGUF file")
 		return
 	}
 
+	cfg.MaxContextLength = int(f.Architecture().MaximumContextLength)
+
+	family, source := identifyFamily(f)
+	cfg.EndOfTurnTokens = modelEndOfTurnTokens(f, family)
+
 	log.Debug().
 		Any("eosTokenID", f.Tokenizer().EOSTokenID).
 		Any("bosTokenID", f.Tokenizer().BOSTokenID).
@@ -179,13 +224,13 @@ func guessDefaultsFromFile(cfg *BackendConfig, modelPath string) {
 		cfg.Name = f.Model().Name
 	}
 
-	family := identifyFamily(f)
-
 	if family == Unknown {
 		log.Debug().Msgf("guessDefaultsFromFile: %s", "family not identified")
 		return
 	}
 
+	log.Info().Str("model", cfg.Name).Any("family", family).Str("source", source).Msg("guessDefaultsFromFile: selected chat template")
+
 	// identify template
 	settings, ok := defaultsSettings[family]
 	if ok {
@@ -200,18 +245,104 @@ func guessDefaultsFromFile(cfg *BackendConfig, modelPath string) {
 	} else {
 		log.Debug().Any("family", family).Msgf("guessDefaultsFromFile: no template found for family")
 	}
+
+	if parser, ok := defaultToolCallParsers[family]; ok && cfg.FunctionsConfig.ToolCallParser == "" {
+		cfg.FunctionsConfig.ToolCallParser = parser
+		log.Debug().Any("family", family).Str("parser", parser).Msg("guessDefaultsFromFile: selected tool call parser")
+	}
 }
 
-func identifyFamily(f *gguf.GGUFFile) familyType {
+// parseGGUFFile parses a GGUF file. The metadata of a model split in shards is read from its first shard,
+// and the tensors and the sizes of all the shards are merged into it for the memory estimates.
+func parseGGUFFile(path string) (*gguf.GGUFFile, error) {
+	shards := utils.GGUFShards(path)
+	if shards == nil {
+		return gguf.ParseGGUFFile(path)
+	}
+	if missing := utils.MissingGGUFShards(path); len(missing) > 0 {
+		return nil, fmt.Errorf("missing the GGUF shards %s", strings.Join(missing, ", "))
+	}
+
+	f, err := gguf.ParseGGUFFile(shards[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, shard := range shards[1:] {
+		s, err := gguf.ParseGGUFFile(shard, gguf.SkipLargeMetadata())
+		if err != nil {
+			return nil, err
+		}
+		f.TensorInfos = append(f.TensorInfos, s.TensorInfos...)
+		f.Size += s.Size
+		f.ModelSize += s.ModelSize
+		f.ModelParameters += s.ModelParameters
+	}
+	return f, nil
+}
 
-	// identify from well known templates first
+// modelEndOfTurnTokens returns the tokens the model ends its turns with: the end of sentence and end of turn
+// tokens of the GGUF tokenizer, and the end-of-turn tokens of its family
+func modelEndOfTurnTokens(f *gguf.GGUFFile, family familyType) []string {
+	var eots []string
+
+	tokens, found := f.Header.MetadataKV.Get("tokenizer.ggml.tokens")
+	if found {
+		vocab := tokens.ValueArray().ValuesString()
+		ids := []int64{f.Tokenizer().EOSTokenID}
+		if eot, found := f.Header.MetadataKV.Get("tokenizer.ggml.eot_token_id"); found {
+			ids = append(ids, gguf.ValueNumeric[int64](eot))
+		}
+		for _, id := range ids {
+			if id >= 0 && id < int64(len(vocab)) && vocab[id] != "" && !slices.Contains(eots, vocab[id]) {
+				eots = append(eots, vocab[id])
+			}
+		}
+	}
+
+	for _, token := range endOfTurnTokens[family] {
+		if !slices.Contains(eots, token) {
+			eots = append(eots, token)
+		}
+	}
+	return eots
+}
+
+// identifyFamily returns the model family and where it was identified from:
+// the chat template embedded in the GGUF file, or the model architecture
+func identifyFamily(f *gguf.GGUFFile) (familyType, string) {
+
+	// identify from the embedded chat template first
 	chatTemplate, found := f.Header.MetadataKV.Get("tokenizer.chat_template")
 	if found && chatTemplate.ValueString() != "" {
-		if family, ok := knownTemplates[chatTemplate.ValueString()]; ok {
-			return family
+		if family := identifyFamilyFromChatTemplate(chatTemplate.ValueString()); family != Unknown {
+			return family, "gguf chat template"
 		}
+		log.Debug().Msgf("guessDefaultsFromFile: %s", "embedded chat template not recognized, falling back to the architecture")
 	}
 
+	return identifyFamilyFromArchitecture(f), "architecture"
+}
+
+// identifyFamilyFromChatTemplate matches a Jinja chat template against the well known
+// templates, and then against the special tokens used by each family. The markers must match
+// a whole special token of the template: [INST] is not matched by [INST_SYSTEM]
+func identifyFamilyFromChatTemplate(chatTemplate string) familyType {
+	if family, ok := knownTemplates[chatTemplate]; ok {
+		return family
+	}
+
+	tokens := specialTokenRegex.FindAllString(chatTemplate, -1)
+	for _, m := range chatTemplateMarkers {
+		if slices.Contains(tokens, m.marker) {
+			return m.family
+		}
+	}
+
+	return Unknown
+}
+
+func identifyFamilyFromArchitecture(f *gguf.GGUFFile) familyType {
+
 	// otherwise try to identify from the model properties
 	arch := f.Architecture().Architecture
 	eosTokenID := f.Tokenizer().EOSTokenID
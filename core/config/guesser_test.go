@@ -0,0 +1,30 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test cases for the model family guesser", func() {
+	Context("identifyFamilyFromChatTemplate", func() {
+		It("matches well known templates", func() {
+			for template, family := range knownTemplates {
+				Expect(identifyFamilyFromChatTemplate(template)).To(Equal(family))
+			}
+		})
+		It("recognizes the special tokens of a family", func() {
+			Expect(identifyFamilyFromChatTemplate(`{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}`)).To(Equal(ChatML))
+			Expect(identifyFamilyFromChatTemplate(`{% set content = '<|start_header_id|>' + message['role'] + '<|end_header_id|>\n\n' + message['content'] | trim + '<|eot_id|>' %}`)).To(Equal(LLaMa3))
+			Expect(identifyFamilyFromChatTemplate(`{{ '<start_of_turn>' + role + '\n' + message['content'] | trim + '<end_of_turn>\n' }}`)).To(Equal(Gemma))
+		})
+		It("matches the special tokens exactly", func() {
+			Expect(identifyFamilyFromChatTemplate(`{{ '[INST_SYSTEM]' + message['content'] + '[/INST_SYSTEM]' }}`)).To(Equal(Unknown))
+			Expect(identifyFamilyFromChatTemplate(`{{ '<|start_of_turn|>' + message['content'] }}`)).To(Equal(Unknown))
+			Expect(identifyFamilyFromChatTemplate(`{{ '<|assistant_start|>' + message['content'] }}`)).To(Equal(Unknown))
+			Expect(identifyFamilyFromChatTemplate(`{{ '[INST] ' + message['content'] + ' [/INST]' }}`)).To(Equal(Mistral03))
+		})
+		It("returns Unknown for unrecognized templates", func() {
+			Expect(identifyFamilyFromChatTemplate(`{% for message in messages %}{{ message['content'] }}{% endfor %}`)).To(Equal(Unknown))
+		})
+	})
+})
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mudler/LocalAI/core/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrBackendConfigNotFound is returned when no configuration file configures a model
+var ErrBackendConfigNotFound = errors.New("model configuration not found")
+
+// FindBackendConfigFile returns the YAML file configuring the model named name in the paths. As when
+// loading them, the first path configuring the model wins.
+func FindBackendConfigFile(name string, paths []string, opts ...ConfigLoaderOption) (string, error) {
+	for _, path := range paths {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") ||
+				!strings.HasSuffix(entry.Name(), ".yaml") && !strings.HasSuffix(entry.Name(), ".yml") {
+				continue
+			}
+			file := filepath.Join(path, entry.Name())
+			c, err := readBackendConfigFromFile(file, opts...)
+			if err == nil && c.Name == name {
+				return file, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrBackendConfigNotFound, name)
+}
+
+// DiffBackendConfigs returns the fields changed between two configurations of a model, by YAML path
+func DiffBackendConfigs(old, new BackendConfig) ([]schema.ConfigChange, error) {
+	oldFields, err := flattenConfig(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flattenConfig(new)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []schema.ConfigChange{}
+	for field, v := range newFields {
+		if o, exists := oldFields[field]; !exists || !reflect.DeepEqual(o, v) {
+			changes = append(changes, schema.ConfigChange{Field: field, Old: oldFields[field], New: v})
+		}
+	}
+	for field, o := range oldFields {
+		if _, exists := newFields[field]; !exists {
+			changes = append(changes, schema.ConfigChange{Field: field, Old: o})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// flattenConfig returns the fields of the configuration as it is written in YAML, the nested ones
+// joined with dots
+func flattenConfig(c BackendConfig) (map[string]any, error) {
+	dat, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]any{}
+	if err := yaml.Unmarshal(dat, &m); err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	var flatten func(prefix string, m map[string]any)
+	flatten = func(prefix string, m map[string]any) {
+		for k, v := range m {
+			if nested, ok := v.(map[string]any); ok && len(nested) > 0 {
+				flatten(prefix+k+".", nested)
+				continue
+			}
+			fields[prefix+k] = v
+		}
+	}
+	flatten("", m)
+	return fields, nil
+}
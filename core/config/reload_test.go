@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/LocalAI/core/schema"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloading a model configuration", func() {
+	It("finds the file configuring the model in the first path", func() {
+		primary, extra := GinkgoT().TempDir(), GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(primary, "chat.yaml"), []byte("name: gpt-4\nbackend: llama-cpp\n"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(extra, "gpt-4.yaml"), []byte("name: gpt-4\nbackend: vllm\n"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(extra, "ada.yml"), []byte("name: ada\nbackend: bert-embeddings\n"), 0600)).To(Succeed())
+
+		file, err := FindBackendConfigFile("gpt-4", []string{primary, extra})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file).To(Equal(filepath.Join(primary, "chat.yaml")))
+
+		file, err = FindBackendConfigFile("ada", []string{primary, extra})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file).To(Equal(filepath.Join(extra, "ada.yml")))
+
+		_, err = FindBackendConfigFile("whisper", []string{primary, extra})
+		Expect(err).To(MatchError(ErrBackendConfigNotFound))
+	})
+
+	It("lists the changed fields", func() {
+		threads := 4
+		old := BackendConfig{Name: "gpt-4", Backend: "llama-cpp"}
+		updated := old
+		updated.Threads = &threads
+		updated.GRPC.Attempts = 3
+
+		changes, err := DiffBackendConfigs(old, updated)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(Equal([]schema.ConfigChange{
+			{Field: "grpc.attempts", Old: 0, New: 3},
+			{Field: "threads", Old: nil, New: 4},
+		}))
+
+		changes, err = DiffBackendConfigs(updated, updated)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(BeEmpty())
+	})
+})
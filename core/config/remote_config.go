@@ -0,0 +1,196 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// remoteConfigTimeout bounds the fetch of each document of the remote configuration
+const remoteConfigTimeout = 30 * time.Second
+
+// RemoteConfigSource fetches the model configurations served over HTTP. The URL serves either a
+// configuration file, with one or a list of models, or a manifest listing the URLs of the configuration
+// files. The documents are cached on disk, and the last known good version of a document is used when
+// fetching it fails.
+type RemoteConfigSource struct {
+	sync.Mutex
+	url      string
+	cacheDir string
+	client   *http.Client
+
+	etags map[string]string
+	// names are the models loaded from the source, to remove those it stops serving
+	names map[string]bool
+}
+
+// NewRemoteConfigSource returns the source of the configurations served at url, caching the documents
+// in cacheDir
+func NewRemoteConfigSource(url, cacheDir string) *RemoteConfigSource {
+	return &RemoteConfigSource{
+		url:      url,
+		cacheDir: cacheDir,
+		client:   &http.Client{Timeout: remoteConfigTimeout},
+		etags:    map[string]string{},
+		names:    map[string]bool{},
+	}
+}
+
+func (s *RemoteConfigSource) cacheFile(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(s.cacheDir, hex.EncodeToString(sum[:])+".yaml")
+}
+
+// document returns the document served at u, or its cached version when the server fails or
+// answers that it did not change. It must be called with the lock held.
+func (s *RemoteConfigSource) document(ctx context.Context, u string) ([]byte, error) {
+	cached, cacheErr := os.ReadFile(s.cacheFile(u))
+
+	body, err := s.fetch(ctx, u, cacheErr == nil)
+	switch {
+	case err != nil && cacheErr == nil:
+		log.Warn().Err(err).Str("url", u).Msg("failed fetching the remote model configuration, using the last known good one")
+		return cached, nil
+	case err != nil:
+		return nil, err
+	case body == nil:
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(s.cacheDir, 0750); err != nil {
+		return nil, fmt.Errorf("cannot create the cache directory: %w", err)
+	}
+	if err := os.WriteFile(s.cacheFile(u), body, 0600); err != nil {
+		return nil, fmt.Errorf("cannot cache the remote configuration: %w", err)
+	}
+	return body, nil
+}
+
+// fetch downloads the document at u, returning a nil body when it did not change since the cached one
+func (s *RemoteConfigSource) fetch(ctx context.Context, u string, cached bool) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag := s.etags[u]; etag != "" && cached {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.etags[u] = resp.Header.Get("ETag")
+	return body, nil
+}
+
+// Fetch returns the configurations served by the source. It fails only if a document could neither
+// be fetched nor read from the cache, or is not valid.
+func (s *RemoteConfigSource) Fetch(ctx context.Context, opts ...ConfigLoaderOption) ([]*BackendConfig, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := s.document(ctx, s.url)
+	if err != nil {
+		return nil, err
+	}
+
+	// a manifest is a list of URLs, relative to the one of the manifest
+	var manifest []string
+	if yaml.Unmarshal(data, &manifest) != nil {
+		return parseBackendConfigs(data, opts...)
+	}
+	base, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+	var configs []*BackendConfig
+	for _, ref := range manifest {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL in the manifest %q: %w", ref, err)
+		}
+		data, err := s.document(ctx, u.String())
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch %s: %w", u, err)
+		}
+		c, err := parseBackendConfigs(data, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", u, err)
+		}
+		configs = append(configs, c...)
+	}
+	return configs, nil
+}
+
+// parseBackendConfigs reads a configuration file holding either a list of models or a single one
+func parseBackendConfigs(data []byte, opts ...ConfigLoaderOption) ([]*BackendConfig, error) {
+	var configs []*BackendConfig
+	if err := yaml.Unmarshal(data, &configs); err != nil {
+		c := &BackendConfig{}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal config file: %w", err)
+		}
+		configs = []*BackendConfig{c}
+	}
+	for _, c := range configs {
+		c.SetDefaults(opts...)
+	}
+	return configs, nil
+}
+
+// LoadRemoteBackendConfigs loads the configurations of the remote source, replacing the ones it loaded
+// before. When the source fails, the configurations loaded before are kept.
+func (bcl *BackendConfigLoader) LoadRemoteBackendConfigs(ctx context.Context, s *RemoteConfigSource, opts ...ConfigLoaderOption) error {
+	configs, err := s.Fetch(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	var invalid error
+	bcl.Lock()
+	for _, c := range configs {
+		if !c.Validate() {
+			invalid = errors.Join(invalid, fmt.Errorf("config %q is not valid", c.Name))
+			continue
+		}
+		bcl.configs[c.Name] = *c
+		names[c.Name] = true
+	}
+	s.Lock()
+	for name := range s.names {
+		if !names[name] {
+			delete(bcl.configs, name)
+		}
+	}
+	s.names = names
+	s.Unlock()
+	bcl.Unlock()
+
+	return invalid
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Remote model configurations", func() {
+	var (
+		mu        sync.Mutex
+		documents map[string]string
+		server    *httptest.Server
+		bcl       *BackendConfigLoader
+	)
+
+	serve := func(path, body string) {
+		mu.Lock()
+		defer mu.Unlock()
+		documents[path] = body
+	}
+
+	BeforeEach(func() {
+		documents = map[string]string{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			body, exists := documents[r.URL.Path]
+			mu.Unlock()
+			if !exists {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			etag := `"` + body + `"`
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(body))
+		}))
+		DeferCleanup(server.Close)
+		bcl = NewBackendConfigLoader("")
+	})
+
+	names := func() []string {
+		var res []string
+		for _, c := range bcl.GetAllBackendConfigs() {
+			res = append(res, c.Name)
+		}
+		return res
+	}
+
+	It("loads a single configuration file", func() {
+		serve("/model.yaml", "name: gpt-4\nbackend: llama-cpp\nparameters:\n  model: gpt-4.gguf\n")
+		source := NewRemoteConfigSource(server.URL+"/model.yaml", GinkgoT().TempDir())
+		Expect(bcl.LoadRemoteBackendConfigs(context.Background(), source)).To(Succeed())
+		c, exists := bcl.GetBackendConfig("gpt-4")
+		Expect(exists).To(BeTrue())
+		Expect(c.Model).To(Equal("gpt-4.gguf"))
+	})
+
+	It("loads the files of a manifest and removes the models it stops serving", func() {
+		serve("/manifest.yaml", "- models/chat.yaml\n- "+server.URL+"/models/embeddings.yaml\n")
+		serve("/models/chat.yaml", "- name: gpt-4\n  backend: llama-cpp\n- name: gpt-3\n  backend: llama-cpp\n")
+		serve("/models/embeddings.yaml", "name: ada\nbackend: bert-embeddings\n")
+		source := NewRemoteConfigSource(server.URL+"/manifest.yaml", GinkgoT().TempDir())
+		Expect(bcl.LoadRemoteBackendConfigs(context.Background(), source)).To(Succeed())
+		Expect(names()).To(Equal([]string{"ada", "gpt-3", "gpt-4"}))
+
+		serve("/models/chat.yaml", "- name: gpt-4\n  backend: llama-cpp\n")
+		Expect(bcl.LoadRemoteBackendConfigs(context.Background(), source)).To(Succeed())
+		Expect(names()).To(Equal([]string{"ada", "gpt-4"}))
+	})
+
+	It("uses the last known good configuration when the server fails", func() {
+		cache := GinkgoT().TempDir()
+		serve("/model.yaml", "name: gpt-4\nbackend: llama-cpp\n")
+		source := NewRemoteConfigSource(server.URL+"/model.yaml", cache)
+		Expect(bcl.LoadRemoteBackendConfigs(context.Background(), source)).To(Succeed())
+		// not modified
+		Expect(bcl.LoadRemoteBackendConfigs(context.Background(), source)).To(Succeed())
+		Expect(names()).To(Equal([]string{"gpt-4"}))
+
+		mu.Lock()
+		delete(documents, "/model.yaml")
+		mu.Unlock()
+
+		// a new process starts from the cache on disk
+		bcl = NewBackendConfigLoader("")
+		source = NewRemoteConfigSource(server.URL+"/model.yaml", cache)
+		Expect(bcl.LoadRemoteBackendConfigs(context.Background(), source)).To(Succeed())
+		Expect(names()).To(Equal([]string{"gpt-4"}))
+
+		// without a cache, the models loaded before are kept
+		source = NewRemoteConfigSource(server.URL+"/model.yaml", GinkgoT().TempDir())
+		Expect(bcl.LoadRemoteBackendConfigs(context.Background(), source)).To(MatchError(ContainSubstring("500")))
+		Expect(names()).To(Equal([]string{"gpt-4"}))
+	})
+})
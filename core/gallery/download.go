@@ -0,0 +1,76 @@
+package gallery
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultDownloadConcurrency is the number of files downloaded at once when it is not configured
+const DefaultDownloadConcurrency = 3
+
+var (
+	downloadSlotsMu sync.Mutex
+	downloadSlots   = make(chan struct{}, DefaultDownloadConcurrency)
+)
+
+// SetDownloadConcurrency sets the number of files downloaded at once. The limit is shared by all the installs
+// in progress, so that installing several models at once doesn't open more connections.
+func SetDownloadConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	downloadSlotsMu.Lock()
+	defer downloadSlotsMu.Unlock()
+	downloadSlots = make(chan struct{}, n)
+}
+
+// acquireDownloadSlot waits for a file to be downloaded, and returns the function to call once done
+func acquireDownloadSlot(ctx context.Context) (func(), error) {
+	downloadSlotsMu.Lock()
+	slots := downloadSlots
+	downloadSlotsMu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// downloadProgress reports the progress of the files of a model downloaded at once as a whole, the
+// average of the progress of each file
+type downloadProgress struct {
+	sync.Mutex
+	percentages    []float64
+	downloadStatus func(string, string, string, float64)
+}
+
+func newDownloadProgress(files int, downloadStatus func(string, string, string, float64)) *downloadProgress {
+	return &downloadProgress{percentages: make([]float64, files), downloadStatus: downloadStatus}
+}
+
+// file returns the status callback of the i-th file
+func (p *downloadProgress) file(i int) func(string, string, string, float64) {
+	return func(fileName, current, total string, percentage float64) {
+		p.Lock()
+		defer p.Unlock()
+		p.percentages[i] = percentage
+		p.downloadStatus(fileName, current, total, p.total())
+	}
+}
+
+// done marks the i-th file as downloaded, as the files already present report no progress
+func (p *downloadProgress) done(i int) {
+	p.Lock()
+	defer p.Unlock()
+	p.percentages[i] = 100
+}
+
+func (p *downloadProgress) total() float64 {
+	var sum float64
+	for _, pc := range p.percentages {
+		sum += pc
+	}
+	return sum / float64(len(p.percentages))
+}
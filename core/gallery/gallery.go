@@ -1,8 +1,10 @@
 package gallery
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,72 +18,96 @@ import (
 )
 
 // Installs a model from the gallery
-func InstallModelFromGallery(galleries []config.Gallery, name string, basePath string, req GalleryModel, downloadStatus func(string, string, string, float64), enforceScan bool) error {
-
-	applyModel := func(model *GalleryModel) error {
-		name = strings.ReplaceAll(name, string(os.PathSeparator), "__")
-
-		var config Config
-
-		if len(model.URL) > 0 {
-			var err error
-			config, err = GetGalleryConfigFromURL(model.URL, basePath)
-			if err != nil {
-				return err
-			}
-		} else if len(model.ConfigFile) > 0 {
-			// TODO: is this worse than using the override method with a blank cfg yaml?
-			reYamlConfig, err := yaml.Marshal(model.ConfigFile)
-			if err != nil {
-				return err
-			}
-			config = Config{
-				ConfigFile:  string(reYamlConfig),
-				Description: model.Description,
-				License:     model.License,
-				URLs:        model.URLs,
-				Name:        model.Name,
-				Files:       make([]File, 0), // Real values get added below, must be blank
-				// Prompt Template Skipped for now - I expect in this mode that they will be delivered as files.
-			}
-		} else {
-			return fmt.Errorf("invalid gallery model %+v", model)
-		}
-
-		installName := model.Name
-		if req.Name != "" {
-			installName = req.Name
-		}
+func InstallModelFromGallery(ctx context.Context, galleries []config.Gallery, name string, basePath string, req GalleryModel, downloadStatus func(string, string, string, float64), enforceScan bool, trustedKeys []string) error {
+	model, err := findGalleryModel(galleries, name, basePath)
+	if err != nil {
+		return err
+	}
 
-		// Copy the model configuration from the request schema
-		config.URLs = append(config.URLs, model.URLs...)
-		config.Icon = model.Icon
-		config.Files = append(config.Files, req.AdditionalFiles...)
-		config.Files = append(config.Files, model.AdditionalFiles...)
+	config, installName, err := galleryModelConfig(model, basePath, req)
+	if err != nil {
+		return err
+	}
 
-		// TODO model.Overrides could be merged with user overrides (not defined yet)
-		if err := mergo.Merge(&model.Overrides, req.Overrides, mergo.WithOverride); err != nil {
-			return err
-		}
+	return InstallModel(ctx, basePath, installName, &config, model.Overrides, downloadStatus, enforceScan, trustedKeys)
+}
 
-		if err := InstallModel(basePath, installName, &config, model.Overrides, downloadStatus, enforceScan); err != nil {
-			return err
-		}
+// DryRunModelFromGallery reports the files the install of a model from the gallery would download, and
+// whether they fit on the disk, without installing it
+func DryRunModelFromGallery(ctx context.Context, galleries []config.Gallery, name string, basePath string, req GalleryModel) (*InstallReport, error) {
+	model, err := findGalleryModel(galleries, name, basePath)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil
+	config, installName, err := galleryModelConfig(model, basePath, req)
+	if err != nil {
+		return nil, err
 	}
 
+	return PlanInstall(ctx, basePath, installName, &config)
+}
+
+func findGalleryModel(galleries []config.Gallery, name string, basePath string) (*GalleryModel, error) {
 	models, err := AvailableGalleryModels(galleries, basePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	model := FindModel(models, name, basePath)
 	if model == nil {
-		return fmt.Errorf("no model found with name %q", name)
+		return nil, fmt.Errorf("no model found with name %q", name)
+	}
+	return model, nil
+}
+
+// galleryModelConfig returns the config of a model of the gallery, with the files and the overrides of the
+// request, and the name to install it with
+func galleryModelConfig(model *GalleryModel, basePath string, req GalleryModel) (Config, string, error) {
+	var config Config
+
+	if len(model.URL) > 0 {
+		var err error
+		config, err = GetGalleryConfigFromURL(model.URL, basePath)
+		if err != nil {
+			return config, "", err
+		}
+	} else if len(model.ConfigFile) > 0 {
+		// TODO: is this worse than using the override method with a blank cfg yaml?
+		reYamlConfig, err := yaml.Marshal(model.ConfigFile)
+		if err != nil {
+			return config, "", err
+		}
+		config = Config{
+			ConfigFile:  string(reYamlConfig),
+			Description: model.Description,
+			License:     model.License,
+			URLs:        model.URLs,
+			Name:        model.Name,
+			Files:       make([]File, 0), // Real values get added below, must be blank
+			// Prompt Template Skipped for now - I expect in this mode that they will be delivered as files.
+		}
+	} else {
+		return config, "", fmt.Errorf("invalid gallery model %+v", model)
+	}
+
+	installName := model.Name
+	if req.Name != "" {
+		installName = req.Name
+	}
+
+	// Copy the model configuration from the request schema
+	config.URLs = append(config.URLs, model.URLs...)
+	config.Icon = model.Icon
+	config.Files = append(config.Files, req.AdditionalFiles...)
+	config.Files = append(config.Files, model.AdditionalFiles...)
+
+	// TODO model.Overrides could be merged with user overrides (not defined yet)
+	if err := mergo.Merge(&model.Overrides, req.Overrides, mergo.WithOverride); err != nil {
+		return config, "", err
 	}
 
-	return applyModel(model)
+	return config, installName, nil
 }
 
 func FindModel(models []*GalleryModel, name string, basePath string) *GalleryModel {
@@ -129,6 +155,60 @@ func AvailableGalleryModels(galleries []config.Gallery, basePath string) ([]*Gal
 	return models, nil
 }
 
+// SetGalleriesCredentials sends the credentials of each private gallery with the requests to the scheme and the
+// host of its URL: its index, and the configs and the files of its models on the same host
+func SetGalleriesCredentials(galleries []config.Gallery) error {
+	var credentials []downloader.Credential
+	for _, g := range galleries {
+		if g.Auth == nil {
+			continue
+		}
+		header, value, err := g.Auth.HeaderValue()
+		if err != nil {
+			return fmt.Errorf("invalid credentials of the gallery %s: %w", g.Name, err)
+		}
+		u, err := url.Parse(downloader.URI(g.URL).ResolveURL())
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid URL of the gallery %s: %s", g.Name, g.URL)
+		}
+		if u.Scheme != "https" {
+			log.Warn().Str("gallery", g.Name).Str("url", g.URL).Msg("the credentials of the gallery are sent in clear, its URL is not https")
+		}
+		log.Debug().Str("gallery", g.Name).Str("host", u.Host).Str("header", header).Msg("sending the credentials of the gallery")
+		credentials = append(credentials, downloader.Credential{Scheme: u.Scheme, Host: u.Host, Header: header, Value: value})
+	}
+	downloader.SetCredentials(credentials)
+	return nil
+}
+
+// GalleryIndex is the index of a gallery, as fetched by FetchGalleryIndexes
+type GalleryIndex struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Models is the number of models of the index
+	Models int `json:"models"`
+	// Error is set when the index can't be fetched or parsed
+	Error string `json:"error,omitempty"`
+}
+
+// FetchGalleryIndexes fetches the index of each gallery again, and reports the number of models of each one, or
+// the error fetching it. Unlike AvailableGalleryModels, a gallery failing doesn't hide the others.
+func FetchGalleryIndexes(galleries []config.Gallery, basePath string) []GalleryIndex {
+	indexes := []GalleryIndex{}
+	for _, gallery := range galleries {
+		index := GalleryIndex{Name: gallery.Name, URL: gallery.URL}
+		models, err := getGalleryModels(gallery, basePath)
+		if err != nil {
+			log.Warn().Err(err).Str("gallery", gallery.Name).Msg("cannot fetch the index of the gallery")
+			index.Error = err.Error()
+		} else {
+			index.Models = len(models)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
 func findGalleryURLFromReferenceURL(url string, basePath string) (string, error) {
 	var refFile string
 	uri := downloader.URI(url)
@@ -192,7 +272,9 @@ func DeleteModelFromSystem(basePath string, name string, additionalFiles []strin
 
 	galleryFile := filepath.Join(basePath, galleryFileName(name))
 
-	for _, f := range []string{configFile, galleryFile} {
+	manifestFile := filepath.Join(basePath, manifestFileName(name))
+
+	for _, f := range []string{configFile, galleryFile, manifestFile} {
 		if err := utils.VerifyPath(f, basePath); err != nil {
 			return fmt.Errorf("failed to verify path %s: %w", f, err)
 		}
@@ -223,6 +305,9 @@ func DeleteModelFromSystem(basePath string, name string, additionalFiles []strin
 
 	filesToRemove = append(filesToRemove, configFile)
 	filesToRemove = append(filesToRemove, galleryFile)
+	if _, e := os.Stat(manifestFile); e == nil {
+		filesToRemove = append(filesToRemove, manifestFile)
+	}
 
 	// skip duplicates
 	filesToRemove = utils.Unique(filesToRemove)
@@ -0,0 +1,39 @@
+package gallery_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/mudler/LocalAI/core/config"
+	. "github.com/mudler/LocalAI/core/gallery"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Gallery indexes", func() {
+	It("fetches the index of each gallery, and reports the galleries failing", func() {
+		index := "- name: first\n- name: second\n"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/index.yaml" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(index))
+		}))
+		defer server.Close()
+
+		galleries := []config.Gallery{
+			{Name: "ok", URL: server.URL + "/index.yaml"},
+			{Name: "missing", URL: server.URL + "/missing.yaml"},
+		}
+		indexes := FetchGalleryIndexes(galleries, GinkgoT().TempDir())
+		Expect(indexes).To(HaveLen(2))
+		Expect(indexes[0]).To(Equal(GalleryIndex{Name: "ok", URL: server.URL + "/index.yaml", Models: 2}))
+		Expect(indexes[1].Name).To(Equal("missing"))
+		Expect(indexes[1].Error).ToNot(BeEmpty())
+
+		// a model published in the index is listed by the next fetch
+		index += "- name: third\n"
+		Expect(FetchGalleryIndexes(galleries[:1], GinkgoT().TempDir())[0].Models).To(Equal(3))
+	})
+})
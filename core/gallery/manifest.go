@@ -0,0 +1,191 @@
+package gallery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mudler/LocalAI/pkg/downloader"
+	"github.com/mudler/LocalAI/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const manifestFilePrefix = "._manifest_"
+
+// The statuses of a file of the manifest of a model, after it is verified
+const (
+	FileOK               = "ok"
+	FileMissing          = "missing"
+	FileSizeMismatch     = "size_mismatch"
+	FileChecksumMismatch = "checksum_mismatch"
+)
+
+// ManifestEntry records a file installed with a model, as it was when the install completed
+type ManifestEntry struct {
+	Path   string `yaml:"path" json:"path"`
+	SHA256 string `yaml:"sha256" json:"sha256"`
+	Size   int64  `yaml:"size" json:"size"`
+}
+
+// FileVerification is the result of the verification of a file of the manifest of a model
+type FileVerification struct {
+	ManifestEntry
+	Status string `json:"status"`
+	// ActualSHA256 and ActualSize are what is on the disk, when it differs from the manifest
+	ActualSHA256 string `json:"actual_sha256,omitempty"`
+	ActualSize   int64  `json:"actual_size,omitempty"`
+}
+
+// ModelVerification is the result of the verification of the files of an installed model
+type ModelVerification struct {
+	Name  string             `json:"name"`
+	OK    bool               `json:"ok"`
+	Files []FileVerification `json:"files"`
+	// Error is set when the manifest of the model can't be read
+	Error string `json:"error,omitempty"`
+}
+
+func manifestFileName(name string) string {
+	return manifestFilePrefix + name + ".yaml"
+}
+
+// writeManifest records the path, the SHA256 and the size of the files downloaded by the install of a model.
+// The checksum of the gallery is reused when the file was verified against it, the others are hashed. The
+// files that can't be read are left out of the manifest.
+func writeManifest(basePath, name string, config *Config) error {
+	entries := []ManifestEntry{}
+	for _, file := range config.Files {
+		filePath := filepath.Join(basePath, file.Filename)
+		fi, err := os.Stat(filePath)
+		if err != nil {
+			// e.g. a file the install removed, the model is installed anyway
+			log.Warn().Err(err).Str("file", file.Filename).Str("model", name).Msg("the file is not recorded in the manifest of the model")
+			continue
+		}
+		if fi.IsDir() {
+			// the OCI images are extracted to a directory, there is no single file to hash
+			continue
+		}
+
+		sha := strings.ToLower(file.SHA256)
+		if sha == "" {
+			if sha, err = downloader.CalculateSHA(filePath); err != nil {
+				log.Warn().Err(err).Str("file", file.Filename).Str("model", name).Msg("the file is not recorded in the manifest of the model")
+				continue
+			}
+		}
+		entries = append(entries, ManifestEntry{Path: file.Filename, SHA256: sha, Size: fi.Size()})
+	}
+
+	data, err := yaml.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	manifestFile := filepath.Join(basePath, manifestFileName(name))
+	log.Debug().Msgf("Written manifest file %s", manifestFile)
+	return os.WriteFile(manifestFile, data, 0600)
+}
+
+// ReadManifest returns the files recorded when the model was installed
+func ReadManifest(basePath, name string) ([]ManifestEntry, error) {
+	name = strings.ReplaceAll(name, string(os.PathSeparator), "__")
+	manifestFile := filepath.Join(basePath, manifestFileName(name))
+	if err := utils.VerifyPath(manifestFileName(name), basePath); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("cannot parse the manifest %s: %w", manifestFile, err)
+	}
+	return entries, nil
+}
+
+// ManifestModels returns the names of the models of the models directory with a manifest
+func ManifestModels(basePath string) ([]string, error) {
+	files, err := os.ReadDir(basePath)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), manifestFilePrefix) || !strings.HasSuffix(f.Name(), ".yaml") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(f.Name(), manifestFilePrefix), ".yaml"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// VerifyModels re-hashes the files of the manifests of the given models, or of all the models with a manifest
+// when names is empty, and reports the files missing or changed since the install
+func VerifyModels(basePath string, names []string) ([]ModelVerification, error) {
+	if len(names) == 0 {
+		var err error
+		if names, err = ManifestModels(basePath); err != nil {
+			return nil, err
+		}
+	}
+
+	results := []ModelVerification{}
+	for _, name := range names {
+		result := ModelVerification{Name: name, OK: true, Files: []FileVerification{}}
+		entries, err := ReadManifest(basePath, name)
+		if err != nil {
+			result.OK, result.Error = false, err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		for _, entry := range entries {
+			fv := verifyManifestEntry(basePath, entry)
+			if fv.Status != FileOK {
+				result.OK = false
+			}
+			result.Files = append(result.Files, fv)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// verifyManifestEntry compares a file with its manifest entry. The size is compared first, so that the
+// truncated files are reported without hashing them.
+func verifyManifestEntry(basePath string, entry ManifestEntry) FileVerification {
+	fv := FileVerification{ManifestEntry: entry, Status: FileOK}
+	if err := utils.VerifyPath(entry.Path, basePath); err != nil {
+		fv.Status = FileMissing
+		return fv
+	}
+
+	filePath := filepath.Join(basePath, entry.Path)
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		fv.Status = FileMissing
+		return fv
+	}
+	if fi.Size() != entry.Size {
+		fv.Status, fv.ActualSize = FileSizeMismatch, fi.Size()
+		return fv
+	}
+
+	sha, err := downloader.CalculateSHA(filePath)
+	if err != nil {
+		log.Warn().Err(err).Str("file", entry.Path).Msg("cannot hash the file")
+		fv.Status = FileMissing
+		return fv
+	}
+	if !strings.EqualFold(sha, entry.SHA256) {
+		fv.Status, fv.ActualSHA256 = FileChecksumMismatch, sha
+	}
+	return fv
+}
@@ -1,14 +1,17 @@
 package gallery
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"dario.cat/mergo"
 	lconfig "github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/pkg/downloader"
+	"github.com/mudler/LocalAI/pkg/signature"
 	"github.com/mudler/LocalAI/pkg/utils"
 
 	"github.com/rs/zerolog/log"
@@ -59,6 +62,12 @@ type File struct {
 	Filename string `yaml:"filename" json:"filename"`
 	SHA256   string `yaml:"sha256" json:"sha256"`
 	URI      string `yaml:"uri" json:"uri"`
+	// Size is the size of the file in bytes, if known. The dry runs and the disk space checks of the installs
+	// ask the server for the size of the files without it
+	Size int64 `yaml:"size,omitempty" json:"size,omitempty"`
+	// Signature is the URI of the detached minisign signature of the file.
+	// If not set, it defaults to the file URI with the .minisig suffix
+	Signature string `yaml:"signature,omitempty" json:"signature,omitempty"`
 }
 
 type PromptTemplate struct {
@@ -96,7 +105,62 @@ func ReadConfigFile(filePath string) (*Config, error) {
 	return &config, nil
 }
 
-func InstallModel(basePath, nameOverride string, config *Config, configOverrides map[string]interface{}, downloadStatus func(string, string, string, float64), enforceScan bool) error {
+// installFile downloads a file of a model, once a download slot is free, and verifies its SHA and its signature
+func installFile(ctx context.Context, basePath, model string, file File, downloadStatus func(string, string, string, float64), enforceScan bool, trustedKeys []string) error {
+	release, err := acquireDownloadSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	log.Debug().Msgf("Checking %q exists and matches SHA", file.Filename)
+
+	// Create file path
+	filePath := filepath.Join(basePath, file.Filename)
+
+	if enforceScan {
+		scanResults, err := downloader.HuggingFaceScan(downloader.URI(file.URI))
+		if err != nil && errors.Is(err, downloader.ErrUnsafeFilesFound) {
+			log.Error().Str("model", model).Strs("clamAV", scanResults.ClamAVInfectedFiles).Strs("pickles", scanResults.DangerousPickles).Msg("Contains unsafe file(s)!")
+			return err
+		}
+	}
+	uri := downloader.URI(file.URI)
+	// the progress of each file is reported on its own, and then summed with the other files
+	if err := uri.DownloadFileWithContext(ctx, filePath, file.SHA256, 1, 1, downloadStatus); err != nil {
+		return err
+	}
+
+	if len(trustedKeys) > 0 {
+		if err := verifyFileSignature(basePath, filePath, file, trustedKeys); err != nil {
+			log.Error().Err(err).Str("model", model).Str("file", file.Filename).Msg("signature verification failed")
+			if err := os.Remove(filePath); err != nil {
+				log.Warn().Err(err).Str("file", filePath).Msg("failed to remove file")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// firstError returns the first error that is not a cancellation, as the failure of a download cancels the others
+func firstError(errs []error) error {
+	var canceled error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		if canceled == nil {
+			canceled = err
+		}
+	}
+	return canceled
+}
+
+func InstallModel(ctx context.Context, basePath, nameOverride string, config *Config, configOverrides map[string]interface{}, downloadStatus func(string, string, string, float64), enforceScan bool, trustedKeys []string) error {
 	// Create base path if it doesn't exist
 	err := os.MkdirAll(basePath, 0750)
 	if err != nil {
@@ -107,28 +171,37 @@ func InstallModel(basePath, nameOverride string, config *Config, configOverrides
 		log.Debug().Msgf("Config overrides %+v", configOverrides)
 	}
 
-	// Download files and verify their SHA
-	for i, file := range config.Files {
-		log.Debug().Msgf("Checking %q exists and matches SHA", file.Filename)
-
+	for _, file := range config.Files {
 		if err := utils.VerifyPath(file.Filename, basePath); err != nil {
 			return err
 		}
+	}
 
-		// Create file path
-		filePath := filepath.Join(basePath, file.Filename)
+	if err := checkDiskSpace(ctx, basePath, nameOverride, config); err != nil {
+		return err
+	}
 
-		if enforceScan {
-			scanResults, err := downloader.HuggingFaceScan(downloader.URI(file.URI))
-			if err != nil && errors.Is(err, downloader.ErrUnsafeFilesFound) {
-				log.Error().Str("model", config.Name).Strs("clamAV", scanResults.ClamAVInfectedFiles).Strs("pickles", scanResults.DangerousPickles).Msg("Contains unsafe file(s)!")
-				return err
+	// Download files and verify their SHA, several at once. The first failure interrupts the other downloads
+	downloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	progress := newDownloadProgress(len(config.Files), downloadStatus)
+	errs := make([]error, len(config.Files))
+	var wg sync.WaitGroup
+	for i, file := range config.Files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := installFile(downloadCtx, basePath, config.Name, file, progress.file(i), enforceScan, trustedKeys); err != nil {
+				errs[i] = err
+				cancel()
+				return
 			}
-		}
-		uri := downloader.URI(file.URI)
-		if err := uri.DownloadFile(filePath, file.SHA256, i, len(config.Files), downloadStatus); err != nil {
-			return err
-		}
+			progress.done(i)
+		}()
+	}
+	wg.Wait()
+	if err := firstError(errs); err != nil {
+		return err
 	}
 
 	// Write prompt template contents to separate files
@@ -211,11 +284,39 @@ func InstallModel(basePath, nameOverride string, config *Config, configOverrides
 
 	log.Debug().Msgf("Written gallery file %s", modelFile)
 
-	return os.WriteFile(modelFile, data, 0600)
+	if err := os.WriteFile(modelFile, data, 0600); err != nil {
+		return err
+	}
+
+	return writeManifest(basePath, name, config)
 
 	//return nil
 }
 
+// verifyFileSignature checks the detached signature of a downloaded file against the trusted keys
+func verifyFileSignature(basePath, filePath string, file File, trustedKeys []string) error {
+	sigURI := file.Signature
+	if sigURI == "" {
+		sigURI = file.URI + ".minisig"
+	}
+
+	var sig []byte
+	err := downloader.URI(sigURI).DownloadWithCallback(basePath, func(_ string, dat []byte) error {
+		sig = dat
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch signature for %q: %w", file.Filename, err)
+	}
+
+	if err := signature.VerifyFileWithKeys(filePath, sig, trustedKeys); err != nil {
+		return fmt.Errorf("failed to verify signature for %q: %w", file.Filename, err)
+	}
+
+	log.Debug().Msgf("Signature of %q verified", file.Filename)
+	return nil
+}
+
 func galleryFileName(name string) string {
 	return "._gallery_" + name + ".yaml"
 }
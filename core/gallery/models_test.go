@@ -1,9 +1,17 @@
 package gallery_test
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/mudler/LocalAI/core/config"
 	. "github.com/mudler/LocalAI/core/gallery"
@@ -21,7 +29,7 @@ var _ = Describe("Model test", func() {
 			defer os.RemoveAll(tempdir)
 			c, err := ReadConfigFile(filepath.Join(os.Getenv("FIXTURES"), "gallery_simple.yaml"))
 			Expect(err).ToNot(HaveOccurred())
-			err = InstallModel(tempdir, "", c, map[string]interface{}{}, func(string, string, string, float64) {}, true)
+			err = InstallModel(context.TODO(), tempdir, "", c, map[string]interface{}{}, func(string, string, string, float64) {}, true, nil)
 			Expect(err).ToNot(HaveOccurred())
 
 			for _, f := range []string{"cerebras", "cerebras-completion.tmpl", "cerebras-chat.tmpl", "cerebras.yaml"} {
@@ -69,7 +77,7 @@ var _ = Describe("Model test", func() {
 			Expect(models[0].URL).To(Equal("https://raw.githubusercontent.com/go-skynet/model-gallery/main/bert-embeddings.yaml"))
 			Expect(models[0].Installed).To(BeFalse())
 
-			err = InstallModelFromGallery(galleries, "test@bert", tempdir, GalleryModel{}, func(s1, s2, s3 string, f float64) {}, true)
+			err = InstallModelFromGallery(context.TODO(), galleries, "test@bert", tempdir, GalleryModel{}, func(s1, s2, s3 string, f float64) {}, true, nil)
 			Expect(err).ToNot(HaveOccurred())
 
 			dat, err := os.ReadFile(filepath.Join(tempdir, "bert.yaml"))
@@ -106,7 +114,7 @@ var _ = Describe("Model test", func() {
 			c, err := ReadConfigFile(filepath.Join(os.Getenv("FIXTURES"), "gallery_simple.yaml"))
 			Expect(err).ToNot(HaveOccurred())
 
-			err = InstallModel(tempdir, "foo", c, map[string]interface{}{}, func(string, string, string, float64) {}, true)
+			err = InstallModel(context.TODO(), tempdir, "foo", c, map[string]interface{}{}, func(string, string, string, float64) {}, true, nil)
 			Expect(err).ToNot(HaveOccurred())
 
 			for _, f := range []string{"cerebras", "cerebras-completion.tmpl", "cerebras-chat.tmpl", "foo.yaml"} {
@@ -122,7 +130,7 @@ var _ = Describe("Model test", func() {
 			c, err := ReadConfigFile(filepath.Join(os.Getenv("FIXTURES"), "gallery_simple.yaml"))
 			Expect(err).ToNot(HaveOccurred())
 
-			err = InstallModel(tempdir, "foo", c, map[string]interface{}{"backend": "foo"}, func(string, string, string, float64) {}, true)
+			err = InstallModel(context.TODO(), tempdir, "foo", c, map[string]interface{}{"backend": "foo"}, func(string, string, string, float64) {}, true, nil)
 			Expect(err).ToNot(HaveOccurred())
 
 			for _, f := range []string{"cerebras", "cerebras-completion.tmpl", "cerebras-chat.tmpl", "foo.yaml"} {
@@ -141,6 +149,174 @@ var _ = Describe("Model test", func() {
 			Expect(content["backend"]).To(Equal("foo"))
 		})
 
+		It("downloads the files at once, up to the download concurrency", func() {
+			tempdir := GinkgoT().TempDir()
+			var mu sync.Mutex
+			inFlight, maxInFlight := 0, 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				inFlight++
+				maxInFlight = max(maxInFlight, inFlight)
+				mu.Unlock()
+				time.Sleep(50 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				w.Write([]byte(r.URL.Path))
+			}))
+			defer server.Close()
+
+			SetDownloadConcurrency(2)
+			defer SetDownloadConcurrency(DefaultDownloadConcurrency)
+
+			c := &Config{Name: "shards"}
+			for i := range 4 {
+				name := fmt.Sprintf("model-%05d-of-00004.gguf", i+1)
+				c.Files = append(c.Files, File{Filename: name, URI: server.URL + "/" + name, SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("/"+name)))})
+			}
+			var lastPercentage float64
+			err := InstallModel(context.TODO(), tempdir, "", c, map[string]interface{}{}, func(_, _, _ string, p float64) { lastPercentage = p }, false, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(maxInFlight).To(Equal(2))
+			Expect(lastPercentage).To(BeNumerically("~", 100, 0.01))
+			for _, f := range c.Files {
+				Expect(filepath.Join(tempdir, f.Filename)).To(BeARegularFile())
+			}
+
+			// the checksum of each file is still verified
+			c.Files[2].SHA256 = fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+			os.Remove(filepath.Join(tempdir, c.Files[2].Filename))
+			err = InstallModel(context.TODO(), tempdir, "", c, map[string]interface{}{}, func(string, string, string, float64) {}, false, nil)
+			Expect(err).To(MatchError(ContainSubstring("SHA mismatch")))
+		})
+
+		It("reports the size of the files without downloading them, and refuses the installs not fitting on the disk", func() {
+			tempdir := GinkgoT().TempDir()
+			var downloads, heads []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					heads = append(heads, r.URL.Path)
+				}
+				if r.URL.Path == "/huge.gguf" {
+					w.Header().Set("Content-Length", fmt.Sprint(uint64(1)<<60))
+					return
+				}
+				if r.Method == http.MethodGet {
+					downloads = append(downloads, r.URL.Path)
+				}
+				w.Write([]byte("0123456789"))
+			}))
+			defer server.Close()
+			Expect(os.WriteFile(filepath.Join(tempdir, "present.bin"), []byte("abc"), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(tempdir, "partial.bin.partial"), []byte("0123"), 0600)).To(Succeed())
+
+			c := &Config{Name: "model", Files: []File{
+				{Filename: "present.bin", URI: server.URL + "/present.bin"},
+				{Filename: "partial.bin", URI: server.URL + "/partial.bin"},
+				{Filename: "new.bin", URI: server.URL + "/new.bin"},
+				{Filename: "image", URI: "ollama://gemma:2b"},
+				{Filename: "sized.bin", URI: server.URL + "/sized.bin", Size: 20},
+			}}
+			report, err := PlanInstall(context.TODO(), tempdir, "", c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(downloads).To(BeEmpty())
+			// the servers are only asked for the sizes not known
+			Expect(heads).To(ConsistOf("/partial.bin", "/new.bin"))
+			Expect(report.Name).To(Equal("model"))
+			Expect(report.Files).To(Equal([]FileReport{
+				{Filename: "present.bin", URI: server.URL + "/present.bin", Size: 3, Present: true},
+				{Filename: "partial.bin", URI: server.URL + "/partial.bin", Size: 10, Download: 6},
+				{Filename: "new.bin", URI: server.URL + "/new.bin", Size: 10, Download: 10},
+				{Filename: "image", URI: "ollama://gemma:2b", Size: -1},
+				{Filename: "sized.bin", URI: server.URL + "/sized.bin", Size: 20, Download: 20},
+			}))
+			Expect(report.DownloadSize).To(Equal(uint64(36)))
+			Expect(report.UnknownSizes).To(BeTrue())
+			Expect(report.FreeSpace).To(BeNumerically(">", 0))
+			Expect(report.Fits).To(BeTrue())
+
+			c = &Config{Name: "huge", Files: []File{{Filename: "huge.gguf", URI: server.URL + "/huge.gguf"}}}
+			err = InstallModel(context.TODO(), tempdir, "", c, map[string]interface{}{}, func(string, string, string, float64) {}, false, nil)
+			Expect(err).To(MatchError(ErrInsufficientDisk))
+			Expect(downloads).To(BeEmpty())
+		})
+
+		It("records a manifest of the files, and reports the files missing or changed since the install", func() {
+			tempdir := GinkgoT().TempDir()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.URL.Path))
+			}))
+			defer server.Close()
+
+			c := &Config{Name: "model", Files: []File{
+				{Filename: "a.bin", URI: server.URL + "/a.bin", SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("/a.bin")))},
+				{Filename: "b.bin", URI: server.URL + "/b.bin"},
+				{Filename: "c.bin", URI: server.URL + "/c.bin"},
+			}}
+			err := InstallModel(context.TODO(), tempdir, "", c, map[string]interface{}{}, func(string, string, string, float64) {}, false, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			manifest, err := ReadManifest(tempdir, "model")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest).To(Equal([]ManifestEntry{
+				{Path: "a.bin", SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("/a.bin"))), Size: 6},
+				{Path: "b.bin", SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("/b.bin"))), Size: 6},
+				{Path: "c.bin", SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("/c.bin"))), Size: 6},
+			}))
+
+			results, err := VerifyModels(tempdir, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].OK).To(BeTrue())
+
+			Expect(os.Remove(filepath.Join(tempdir, "a.bin"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(tempdir, "b.bin"), []byte("/b"), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(tempdir, "c.bin"), []byte("/x.bin"), 0600)).To(Succeed())
+			results, err = VerifyModels(tempdir, []string{"model", "unknown"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].OK).To(BeFalse())
+			Expect(results[0].Files[0].Status).To(Equal(FileMissing))
+			Expect(results[0].Files[1].Status).To(Equal(FileSizeMismatch))
+			Expect(results[0].Files[1].ActualSize).To(Equal(int64(2)))
+			Expect(results[0].Files[2].Status).To(Equal(FileChecksumMismatch))
+			Expect(results[0].Files[2].ActualSHA256).To(Equal(fmt.Sprintf("%x", sha256.Sum256([]byte("/x.bin")))))
+			Expect(results[1].OK).To(BeFalse())
+			Expect(results[1].Error).ToNot(BeEmpty())
+
+			// the files removed by hand are reported, the others and the manifest are deleted
+			Expect(DeleteModelFromSystem(tempdir, "model", nil)).To(MatchError(ContainSubstring("a.bin")))
+			names, err := ManifestModels(tempdir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(names).To(BeEmpty())
+		})
+
+		It("leaves the files which can't be read out of the manifest", func() {
+			tempdir := GinkgoT().TempDir()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.URL.Path))
+			}))
+			defer server.Close()
+
+			// a socket is already present, so it is not downloaded, and it can't be opened to be hashed
+			socket, err := net.Listen("unix", filepath.Join(tempdir, "b.sock"))
+			Expect(err).ToNot(HaveOccurred())
+			defer socket.Close()
+
+			c := &Config{Name: "model", Files: []File{
+				{Filename: "a.bin", URI: server.URL + "/a.bin"},
+				{Filename: "b.sock", URI: server.URL + "/b.sock"},
+			}}
+			err = InstallModel(context.TODO(), tempdir, "", c, map[string]interface{}{}, func(string, string, string, float64) {}, false, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			manifest, err := ReadManifest(tempdir, "model")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest).To(Equal([]ManifestEntry{
+				{Path: "a.bin", SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("/a.bin"))), Size: 6},
+			}))
+		})
+
 		It("catches path traversals", func() {
 			tempdir, err := os.MkdirTemp("", "test")
 			Expect(err).ToNot(HaveOccurred())
@@ -148,7 +324,7 @@ var _ = Describe("Model test", func() {
 			c, err := ReadConfigFile(filepath.Join(os.Getenv("FIXTURES"), "gallery_simple.yaml"))
 			Expect(err).ToNot(HaveOccurred())
 
-			err = InstallModel(tempdir, "../../../foo", c, map[string]interface{}{}, func(string, string, string, float64) {}, true)
+			err = InstallModel(context.TODO(), tempdir, "../../../foo", c, map[string]interface{}{}, func(string, string, string, float64) {}, true, nil)
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -7,13 +7,16 @@ type GalleryOp struct {
 	GalleryModelName string
 	ConfigURL        string
 	Delete           bool
+	// Startup is true for the installs of the models preloaded at startup, which the readiness waits for
+	Startup bool
 
 	Req       GalleryModel
 	Galleries []config.Gallery
 }
 
 type GalleryOpStatus struct {
-	Deletion           bool    `json:"deletion"` // Deletion is true if the operation is a deletion
+	Deletion           bool    `json:"deletion"`  // Deletion is true if the operation is a deletion
+	Cancelled          bool    `json:"cancelled"` // Cancelled is true if the operation was cancelled by the user
 	FileName           string  `json:"file_name"`
 	Error              error   `json:"error"`
 	Processed          bool    `json:"processed"`
@@ -0,0 +1,102 @@
+package gallery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/LocalAI/pkg/downloader"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrInsufficientDisk is returned by the installs whose files don't fit in the free space of the models directory
+var ErrInsufficientDisk = xsysinfo.ErrInsufficientDisk
+
+// FileReport describes a file downloaded by the install of a model
+type FileReport struct {
+	Filename string `json:"filename"`
+	URI      string `json:"uri"`
+	// Size is the size of the file in bytes, -1 if it is not known in advance (e.g. the OCI images)
+	Size int64 `json:"size"`
+	// Present is true if the file is already in the models directory, and is not downloaded again
+	Present bool `json:"present"`
+	// Download are the bytes left to download, after a partial download
+	Download int64 `json:"download"`
+}
+
+// InstallReport describes the files the install of a model downloads, and whether they fit on the disk
+type InstallReport struct {
+	Name  string       `json:"name"`
+	Files []FileReport `json:"files"`
+	// DownloadSize are the bytes to download for the files whose size is known
+	DownloadSize uint64 `json:"download_size"`
+	// UnknownSizes is true if the size of some files is not known in advance, and is not in DownloadSize
+	UnknownSizes bool   `json:"unknown_sizes"`
+	FreeSpace    uint64 `json:"free_space"`
+	// Fits is true if the files to download leave the configured headroom free
+	Fits bool `json:"fits"`
+}
+
+// PlanInstall resolves the size of the files of a model without downloading them, and compares the bytes to
+// download with the free space of the volume of the models directory
+func PlanInstall(ctx context.Context, basePath, name string, config *Config) (*InstallReport, error) {
+	report := &InstallReport{Name: name, Files: []FileReport{}}
+	if report.Name == "" {
+		report.Name = config.Name
+	}
+
+	for _, file := range config.Files {
+		fr := FileReport{Filename: file.Filename, URI: file.URI, Size: -1}
+		filePath := filepath.Join(basePath, file.Filename)
+
+		if fi, err := os.Stat(filePath); err == nil {
+			fr.Present, fr.Size = true, fi.Size()
+			report.Files = append(report.Files, fr)
+			continue
+		}
+
+		size := file.Size
+		if size <= 0 {
+			var err error
+			size, err = downloader.URI(file.URI).ContentLength(ctx)
+			if err != nil {
+				log.Debug().Err(err).Str("file", file.Filename).Msg("the size of the file is not known")
+			}
+		}
+		fr.Size = size
+		if size < 0 {
+			report.UnknownSizes = true
+			report.Files = append(report.Files, fr)
+			continue
+		}
+
+		fr.Download = size
+		if fi, err := os.Stat(filePath + ".partial"); err == nil && fi.Size() <= size {
+			fr.Download -= fi.Size()
+		}
+		report.DownloadSize += uint64(fr.Download)
+		report.Files = append(report.Files, fr)
+	}
+
+	free, err := xsysinfo.FreeDiskSpace(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the free space of %s: %w", basePath, err)
+	}
+	report.FreeSpace = free
+	report.Fits = report.DownloadSize == 0 || report.DownloadSize+xsysinfo.DiskHeadroom() <= free
+
+	return report, nil
+}
+
+// checkDiskSpace fails the install of a model whose files don't fit on the disk. The install goes on when
+// the space can't be checked.
+func checkDiskSpace(ctx context.Context, basePath, name string, config *Config) error {
+	report, err := PlanInstall(ctx, basePath, name, config)
+	if err != nil {
+		log.Warn().Err(err).Str("model", name).Msg("cannot check the disk space for the model")
+		return nil
+	}
+	return xsysinfo.CheckDiskSpace(basePath, report.DownloadSize)
+}
@@ -1,14 +1,17 @@
 package http
 
 import (
+	"context"
 	"embed"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/dave-gray101/v2keyauth"
 	"github.com/mudler/LocalAI/pkg/utils"
 
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 	"github.com/mudler/LocalAI/core/http/endpoints/localai"
 	"github.com/mudler/LocalAI/core/http/endpoints/openai"
 	"github.com/mudler/LocalAI/core/http/middleware"
@@ -17,18 +20,19 @@ import (
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/schema"
 	"github.com/mudler/LocalAI/core/services"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/mudler/LocalAI/pkg/xlog"
 
 	"github.com/gofiber/contrib/fiberzerolog"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/csrf"
 	"github.com/gofiber/fiber/v2/middleware/favicon"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
 	// swagger handler
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 // Embed a directory
@@ -57,6 +61,14 @@ func App(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *confi
 		// We disable the Fiber startup message as it does not conform to structured logging.
 		// We register a startup log line with connection information in the OnListen hook to keep things user friendly though
 		DisableStartupMessage: true,
+		// The zero values keep the defaults of fiber: no timeouts and 256k concurrent connections
+		ReadTimeout:  appConfig.HTTPReadTimeout,
+		WriteTimeout: appConfig.HTTPWriteTimeout,
+		IdleTimeout:  appConfig.HTTPIdleTimeout,
+		Concurrency:  appConfig.HTTPConcurrency,
+		// The forwarding headers (X-Forwarded-Proto, X-Forwarded-Host) are only honored from the trusted proxies
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          middleware.TrustedProxyConfig(appConfig),
 		// Override default error handler
 	}
 
@@ -71,6 +83,10 @@ func App(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *confi
 			if errors.As(err, &e) {
 				code = e.Code
 			}
+			// the request needs a feature the backend of the model does not support
+			if errors.Is(err, model.ErrUnsupportedCapability) {
+				code = fiber.StatusBadRequest
+			}
 
 			// Send custom error page
 			return ctx.Status(code).JSON(
@@ -88,19 +104,40 @@ func App(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *confi
 
 	app := fiber.New(fiberCfg)
 
+	// The features register their shutdown work here rather than in the hooks of fiber
+	shutdownHooks := services.NewShutdownHooks()
+	app.Hooks().OnShutdown(shutdownHooks.Run)
+
 	app.Hooks().OnListen(func(listenData fiber.ListenData) error {
 		scheme := "http"
 		if listenData.TLS {
 			scheme = "https"
 		}
-		log.Info().Str("endpoint", scheme+"://"+listenData.Host+":"+listenData.Port).Msg("LocalAI API is listening! Please connect to the endpoint for API documentation.")
+		xlog.HTTP.Info().Str("endpoint", scheme+"://"+listenData.Host+":"+listenData.Port).Msg("LocalAI API is listening! Please connect to the endpoint for API documentation.")
 		return nil
 	})
 
+	// Generate or propagate the correlation ID first, so that every log line of the request carries it
+	app.Use(middleware.CorrelationID(appConfig))
+
+	if appConfig.EnableTracing {
+		tracerProvider, err := services.NewTracerProvider(appConfig.Context)
+		if err != nil {
+			return nil, err
+		}
+		app.Use(middleware.Tracing())
+		shutdownHooks.RegisterShutdownHook(func() error {
+			return tracerProvider.Shutdown(context.Background())
+		})
+	}
+
 	// Have Fiber use zerolog like the rest of the application rather than it's built-in logger
-	logger := log.Logger
+	// The address of the client is resolved from the trusted proxies rather than by fiber
 	app.Use(fiberzerolog.New(fiberzerolog.Config{
-		Logger: &logger,
+		GetLogger: func(c *fiber.Ctx) zerolog.Logger {
+			return correlation.Logger(c.UserContext()).Level(xlog.HTTP.Level()).With().Str(fiberzerolog.FieldIP, middleware.ClientIP(c, appConfig).String()).Logger()
+		},
+		Fields: []string{fiberzerolog.FieldLatency, fiberzerolog.FieldStatus, fiberzerolog.FieldMethod, fiberzerolog.FieldURL, fiberzerolog.FieldError},
 	}))
 
 	// Default middleware config
@@ -116,13 +153,79 @@ func App(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *confi
 
 	if metricsService != nil {
 		app.Use(localai.LocalAIMetricsAPIMiddleware(metricsService))
-		app.Hooks().OnShutdown(func() error {
-			return metricsService.Shutdown()
+		if supervisor := ml.Supervisor(); supervisor != nil {
+			supervisor.OnRestart(metricsService.ObserveBackendRestart)
+		}
+		ml.OnLoad(metricsService.ObserveModelLoad)
+		shutdownHooks.RegisterShutdownHook(metricsService.Shutdown)
+	}
+
+	usageTracker, err := services.NewUsageTracker(appConfig.UsageFile, metricsService)
+	if err != nil {
+		return nil, err
+	}
+	usageTracker.Start(appConfig.Context, time.Minute)
+	app.Use(func(c *fiber.Ctx) error {
+		fiberContext.WithUsageTracker(c, usageTracker)
+		return c.Next()
+	})
+	shutdownHooks.RegisterShutdownHook(usageTracker.Save)
+
+	if len(appConfig.ApiKeyQuotas) > 0 {
+		quotas := services.NewQuotaEnforcer(appConfig.ApiKeyQuotas, appConfig.ApiKeyQuotaMode)
+		app.Use(func(c *fiber.Ctx) error {
+			fiberContext.WithQuotaEnforcer(c, quotas)
+			err := c.Next()
+			if err != nil {
+				// the failed requests do not consume their reservation, which would hold the
+				// budget until the end of the window
+				quotas.Release(fiberContext.QuotaReservationFromContext(c))
+			}
+			return err
 		})
 	}
 
- // Health Checks should always be exempt from auth, so register these first
-	routes.HealthRoutes(app)
+	if appConfig.ResponseCacheSize > 0 {
+		responseCache := services.NewResponseCache(appConfig.ResponseCacheSize, appConfig.ResponseCacheTTL)
+		app.Use(func(c *fiber.Ctx) error {
+			fiberContext.WithResponseCache(c, responseCache)
+			return c.Next()
+		})
+	}
+
+	if appConfig.SchedulerPolicy != "" {
+		scheduler, err := services.NewRequestScheduler(appConfig.SchedulerPolicy, appConfig.SchedulerMaxConcurrency)
+		if err != nil {
+			return nil, err
+		}
+		app.Use(func(c *fiber.Ctx) error {
+			fiberContext.WithRequestScheduler(c, scheduler)
+			return c.Next()
+		})
+	}
+
+	var modelHealth *services.ModelHealthService
+	if appConfig.HealthProbeInterval > 0 {
+		modelHealth = services.NewModelHealthService(cl, ml, appConfig)
+		modelHealth.Start(appConfig.Context)
+	}
+
+	galleryService := services.NewGalleryService(appConfig)
+	galleryService.Start(appConfig.Context, cl)
+	if appConfig.PreloadInBackground && !appConfig.OfflineMode {
+		if err := galleryService.QueueStartupInstalls(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Health Checks should always be exempt from auth, so register these first
+	routes.HealthRoutes(app, modelHealth, galleryService)
+
+	// The addresses are filtered before the API keys are checked, so that the clients outside of the allowed
+	// networks cannot probe for the keys
+	if len(appConfig.IPAllowList) > 0 || len(appConfig.IPDenyList) > 0 {
+		app.Use(middleware.IPFilter(appConfig))
+	}
 
 	kaConfig, err := middleware.GetKeyAuthConfig(appConfig)
 	if err != nil || kaConfig == nil {
@@ -133,29 +236,23 @@ func App(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *confi
 	app.Use(v2keyauth.New(*kaConfig))
 
 	if appConfig.CORS {
-		var c func(ctx *fiber.Ctx) error
-		if appConfig.CORSAllowOrigins == "" {
-			c = cors.New()
-		} else {
-			c = cors.New(cors.Config{AllowOrigins: appConfig.CORSAllowOrigins})
+		corsConfig, err := middleware.CORSConfig(appConfig)
+		if err != nil {
+			return nil, err
 		}
-
-		app.Use(c)
+		app.Use(cors.New(corsConfig))
 	}
 
 	if appConfig.CSRF {
-		log.Debug().Msg("Enabling CSRF middleware. Tokens are now required for state-modifying requests")
-		app.Use(csrf.New())
+		xlog.HTTP.Debug().Msg("Enabling CSRF middleware. Tokens are now required for state-modifying requests without an API key")
+		app.Use(middleware.CSRF(appConfig))
 	}
 
 	// Load config jsons
-	utils.LoadConfig(appConfig.UploadDir, openai.UploadedFilesFile, &openai.UploadedFiles)
+	utils.LoadConfig(appConfig.UploadDir, config.UploadedFilesFile, &openai.UploadedFiles)
 	utils.LoadConfig(appConfig.ConfigsDir, openai.AssistantsConfigFile, &openai.Assistants)
 	utils.LoadConfig(appConfig.ConfigsDir, openai.AssistantsFileConfigFile, &openai.AssistantFiles)
 
-	galleryService := services.NewGalleryService(appConfig)
-	galleryService.Start(appConfig.Context, cl)
-
 	routes.RegisterElevenLabsRoutes(app, cl, ml, appConfig)
 	routes.RegisterLocalAIRoutes(app, cl, ml, appConfig, galleryService)
 	routes.RegisterOpenAIRoutes(app, cl, ml, appConfig)
@@ -807,6 +807,20 @@ var _ = Describe("API test", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(len(models.Models)).To(Equal(6)) // If "config.yaml" should be included, this should be 8?
 		})
+		It("echoes or generates the correlation ID", func() {
+			req, err := http.NewRequest("GET", "http://127.0.0.1:9090/v1/models", nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set("X-Correlation-ID", "test-correlation-id")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.Header.Get("X-Correlation-ID")).To(Equal("test-correlation-id"))
+
+			resp, err = http.Get("http://127.0.0.1:9090/v1/models")
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.Header.Get("X-Correlation-ID")).ToNot(BeEmpty())
+		})
 		It("can generate completions via ggml", func() {
 			resp, err := client.CreateCompletion(context.TODO(), openai.CompletionRequest{Model: "testmodel.ggml", Prompt: testPrompt})
 			Expect(err).ToNot(HaveOccurred())
@@ -821,6 +835,38 @@ var _ = Describe("API test", func() {
 			Expect(resp.Choices[0].Message.Content).ToNot(BeEmpty())
 		})
 
+		It("generates identical chat completions for the same seed and prompt", func() {
+			seed := 42
+			request := openai.ChatCompletionRequest{Model: "testmodel.ggml", Seed: &seed, Messages: []openai.ChatCompletionMessage{openai.ChatCompletionMessage{Role: "user", Content: testPrompt}}}
+
+			resp, err := client.CreateChatCompletion(context.TODO(), request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(resp.Choices)).To(Equal(1))
+
+			resp2, err := client.CreateChatCompletion(context.TODO(), request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(resp2.Choices)).To(Equal(1))
+			Expect(resp2.Choices[0].Message.Content).To(Equal(resp.Choices[0].Message.Content))
+		})
+
+		It("returns the logprobs of chat completions", func() {
+			resp, err := client.CreateChatCompletion(context.TODO(), openai.ChatCompletionRequest{Model: "testmodel.ggml", LogProbs: true, TopLogProbs: 2, Messages: []openai.ChatCompletionMessage{openai.ChatCompletionMessage{Role: "user", Content: testPrompt}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(resp.Choices)).To(Equal(1))
+			Expect(resp.Choices[0].LogProbs).ToNot(BeNil())
+			Expect(resp.Choices[0].LogProbs.Content).ToNot(BeEmpty())
+			for _, token := range resp.Choices[0].LogProbs.Content {
+				Expect(token.LogProb).To(BeNumerically("<=", 0))
+				Expect(len(token.TopLogProbs)).To(BeNumerically("<=", 2))
+			}
+		})
+
+		It("rejects logprobs when streaming", func() {
+			_, err := client.CreateChatCompletionStream(context.TODO(), openai.ChatCompletionRequest{Model: "testmodel.ggml", Stream: true, LogProbs: true, Messages: []openai.ChatCompletionMessage{openai.ChatCompletionMessage{Role: "user", Content: testPrompt}}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("logprobs are not supported when streaming"))
+		})
+
 		It("returns errors", func() {
 			_, err := client.CreateCompletion(context.TODO(), openai.CompletionRequest{Model: "foomodel", Prompt: testPrompt})
 			Expect(err).To(HaveOccurred())
@@ -0,0 +1,62 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/http/middleware"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CORS", func() {
+	preflight := func(appConfig *config.ApplicationConfig) *http.Response {
+		corsConfig, err := middleware.CORSConfig(appConfig)
+		Expect(err).ToNot(HaveOccurred())
+		app := fiber.New()
+		app.Use(cors.New(corsConfig))
+
+		req := httptest.NewRequest(fiber.MethodOptions, "/v1/chat/completions", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+		resp, err := app.Test(req)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	It("keeps the permissive defaults", func() {
+		resp := preflight(&config.ApplicationConfig{})
+		Expect(resp.StatusCode).To(Equal(fiber.StatusNoContent))
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("*"))
+		Expect(resp.Header.Get("Access-Control-Allow-Methods")).To(Equal(cors.ConfigDefault.AllowMethods))
+		Expect(resp.Header.Get("Access-Control-Allow-Headers")).To(Equal("authorization,content-type"))
+		Expect(resp.Header.Get("Access-Control-Allow-Credentials")).To(BeEmpty())
+		Expect(resp.Header.Get("Access-Control-Max-Age")).To(BeEmpty())
+	})
+
+	It("applies the configured options", func() {
+		resp := preflight(&config.ApplicationConfig{
+			CORSAllowOrigins:     "https://app.example.com",
+			CORSAllowMethods:     "GET,POST",
+			CORSAllowHeaders:     "Authorization,Content-Type",
+			CORSAllowCredentials: true,
+			CORSMaxAge:           600,
+		})
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+		Expect(resp.Header.Get("Access-Control-Allow-Methods")).To(Equal("GET,POST"))
+		Expect(resp.Header.Get("Access-Control-Allow-Headers")).To(Equal("Authorization,Content-Type"))
+		Expect(resp.Header.Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+		Expect(resp.Header.Get("Access-Control-Max-Age")).To(Equal("600"))
+	})
+
+	It("rejects the credentials for all the origins", func() {
+		_, err := middleware.CORSConfig(&config.ApplicationConfig{CORSAllowCredentials: true})
+		Expect(err).To(MatchError(ContainSubstring("credentials")))
+		_, err = middleware.CORSConfig(&config.ApplicationConfig{CORSAllowCredentials: true, CORSAllowOrigins: "https://a.example.com, *"})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,45 @@
+package http_test
+
+import (
+	"net/http/httptest"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/http/middleware"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CSRF", func() {
+	newApp := func(appConfig *config.ApplicationConfig) *fiber.App {
+		app := fiber.New()
+		app.Use(middleware.CSRF(appConfig))
+		ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+		app.Post("/v1/chat/completions", ok)
+		app.Post("/browse/install/model/:id", ok)
+		return app
+	}
+	status := func(app *fiber.App, path string, headers map[string]string) int {
+		req := httptest.NewRequest(fiber.MethodPost, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := app.Test(req)
+		Expect(err).ToNot(HaveOccurred())
+		return resp.StatusCode
+	}
+
+	It("exempts the requests authenticated with an API key", func() {
+		app := newApp(&config.ApplicationConfig{})
+		Expect(status(app, "/v1/chat/completions", nil)).To(Equal(fiber.StatusForbidden))
+		Expect(status(app, "/v1/chat/completions", map[string]string{"Authorization": "Bearer key"})).To(Equal(fiber.StatusOK))
+		Expect(status(app, "/v1/chat/completions", map[string]string{"xi-api-key": "key"})).To(Equal(fiber.StatusOK))
+		Expect(status(app, "/browse/install/model/foo", nil)).To(Equal(fiber.StatusForbidden))
+	})
+
+	It("protects only the web UI when scoped to it", func() {
+		app := newApp(&config.ApplicationConfig{CSRFUIOnly: true})
+		Expect(status(app, "/v1/chat/completions", nil)).To(Equal(fiber.StatusOK))
+		Expect(status(app, "/browse/install/model/foo", nil)).To(Equal(fiber.StatusForbidden))
+	})
+})
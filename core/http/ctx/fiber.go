@@ -8,9 +8,96 @@ import (
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/services"
 	"github.com/mudler/LocalAI/pkg/model"
-	"github.com/rs/zerolog/log"
+	"github.com/mudler/LocalAI/pkg/xlog"
 )
 
+const (
+	metricsServiceKey   = "metricsService"
+	responseCacheKey    = "responseCache"
+	schedulerKey        = "requestScheduler"
+	usageTrackerKey     = "usageTracker"
+	quotaEnforcerKey    = "quotaEnforcer"
+	quotaReservationKey = "quotaReservation"
+	apiKeyLabelKey      = "apiKeyLabel"
+)
+
+// WithMetricsService makes the metrics service available to the handlers of the request
+func WithMetricsService(ctx *fiber.Ctx, metrics *services.LocalAIMetricsService) {
+	ctx.Locals(metricsServiceKey, metrics)
+}
+
+// MetricsServiceFromContext returns the metrics service attached to the request, or nil if there is none
+func MetricsServiceFromContext(ctx *fiber.Ctx) *services.LocalAIMetricsService {
+	metrics, _ := ctx.Locals(metricsServiceKey).(*services.LocalAIMetricsService)
+	return metrics
+}
+
+// WithResponseCache makes the response cache available to the handlers of the request
+func WithResponseCache(ctx *fiber.Ctx, cache *services.ResponseCache) {
+	ctx.Locals(responseCacheKey, cache)
+}
+
+// ResponseCacheFromContext returns the response cache attached to the request, or nil if it is disabled
+func ResponseCacheFromContext(ctx *fiber.Ctx) *services.ResponseCache {
+	cache, _ := ctx.Locals(responseCacheKey).(*services.ResponseCache)
+	return cache
+}
+
+// WithRequestScheduler makes the request scheduler available to the handlers of the request
+func WithRequestScheduler(ctx *fiber.Ctx, scheduler *services.RequestScheduler) {
+	ctx.Locals(schedulerKey, scheduler)
+}
+
+// RequestSchedulerFromContext returns the request scheduler attached to the request, or nil if queueing is disabled
+func RequestSchedulerFromContext(ctx *fiber.Ctx) *services.RequestScheduler {
+	scheduler, _ := ctx.Locals(schedulerKey).(*services.RequestScheduler)
+	return scheduler
+}
+
+// WithUsageTracker makes the usage tracker available to the handlers of the request
+func WithUsageTracker(ctx *fiber.Ctx, tracker *services.UsageTracker) {
+	ctx.Locals(usageTrackerKey, tracker)
+}
+
+// UsageTrackerFromContext returns the usage tracker attached to the request, or nil if there is none
+func UsageTrackerFromContext(ctx *fiber.Ctx) *services.UsageTracker {
+	tracker, _ := ctx.Locals(usageTrackerKey).(*services.UsageTracker)
+	return tracker
+}
+
+// WithQuotaEnforcer makes the quota enforcer available to the handlers of the request
+func WithQuotaEnforcer(ctx *fiber.Ctx, quotas *services.QuotaEnforcer) {
+	ctx.Locals(quotaEnforcerKey, quotas)
+}
+
+// QuotaEnforcerFromContext returns the quota enforcer attached to the request, or nil if no key has a quota
+func QuotaEnforcerFromContext(ctx *fiber.Ctx) *services.QuotaEnforcer {
+	quotas, _ := ctx.Locals(quotaEnforcerKey).(*services.QuotaEnforcer)
+	return quotas
+}
+
+// WithQuotaReservation records the tokens reserved in the quota of the API key by the request
+func WithQuotaReservation(ctx *fiber.Ctx, reservation *services.QuotaReservation) {
+	ctx.Locals(quotaReservationKey, reservation)
+}
+
+// QuotaReservationFromContext returns the tokens reserved by the request, or nil if it reserved none
+func QuotaReservationFromContext(ctx *fiber.Ctx) *services.QuotaReservation {
+	reservation, _ := ctx.Locals(quotaReservationKey).(*services.QuotaReservation)
+	return reservation
+}
+
+// WithApiKeyLabel records the label of the API key the request was authenticated with
+func WithApiKeyLabel(ctx *fiber.Ctx, label string) {
+	ctx.Locals(apiKeyLabelKey, label)
+}
+
+// ApiKeyLabelFromContext returns the label of the API key of the request, or an empty string if it has none
+func ApiKeyLabelFromContext(ctx *fiber.Ctx) string {
+	label, _ := ctx.Locals(apiKeyLabelKey).(string)
+	return label
+}
+
 // ModelFromContext returns the model from the context
 // If no model is specified, it will take the first available
 // Takes a model string as input which should be the one received from the user request.
@@ -31,16 +118,16 @@ func ModelFromContext(ctx *fiber.Ctx, cl *config.BackendConfigLoader, loader *mo
 		models, _ := services.ListModels(cl, loader, config.NoFilterFn, services.SKIP_IF_CONFIGURED)
 		if len(models) > 0 {
 			modelInput = models[0]
-			log.Debug().Msgf("No model specified, using: %s", modelInput)
+			xlog.HTTP.Debug().Msgf("No model specified, using: %s", modelInput)
 		} else {
-			log.Debug().Msgf("No model specified, returning error")
+			xlog.HTTP.Debug().Msgf("No model specified, returning error")
 			return "", fmt.Errorf("no model specified")
 		}
 	}
 
 	// If a model is found in bearer token takes precedence
 	if bearerExists {
-		log.Debug().Msgf("Using model from bearer token: %s", bearer)
+		xlog.HTTP.Debug().Msgf("Using model from bearer token: %s", bearer)
 		modelInput = bearer
 	}
 	return modelInput, nil
@@ -1,15 +1,19 @@
 package jina
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/mudler/LocalAI/core/backend"
 	"github.com/mudler/LocalAI/core/config"
 
 	"github.com/gofiber/fiber/v2"
 	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/model"
-	"github.com/rs/zerolog/log"
 )
 
 // JINARerankEndpoint acts like the Jina reranker endpoint (https://jina.ai/reranker/)
@@ -19,24 +23,28 @@ import (
 // @Router /v1/rerank [post]
 func JINARerankEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
 		req := new(schema.JINARerankRequest)
 		if err := c.BodyParser(req); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "Cannot parse JSON",
 			})
 		}
-
-		input := new(schema.TTSRequest)
-
-		// Get input data from the request body
-		if err := c.BodyParser(input); err != nil {
-			return err
+		if req.Query == "" || len(req.Documents) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "query and documents are required")
 		}
 
-		modelFile, err := fiberContext.ModelFromContext(c, cl, ml, input.Model, false)
+		modelFile, err := fiberContext.ModelFromContext(c, cl, ml, req.Model, false)
 		if err != nil {
-			modelFile = input.Model
-			log.Warn().Msgf("Model not found in context: %s", input.Model)
+			modelFile = req.Model
+			logger.Warn().Msgf("Model not found in context: %s", req.Model)
+		}
+		// without a model, the first model able to rerank is used
+		if modelFile == "" {
+			modelFile, err = firstRerankModel(cl)
+			if err != nil {
+				return err
+			}
 		}
 
 		cfg, err := cl.LoadBackendConfigFileByName(modelFile, appConfig.ModelPath,
@@ -46,17 +54,17 @@ func JINARerankEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 			config.LoadOptionF16(appConfig.F16),
 		)
 		if err != nil {
-			modelFile = input.Model
-			log.Warn().Msgf("Model not found in context: %s", input.Model)
-		} else {
-			modelFile = cfg.Model
+			return err
 		}
-
-		log.Debug().Msgf("Request for model: %s", modelFile)
-
-		if input.Backend != "" {
-			cfg.Backend = input.Backend
+		if req.Backend != "" {
+			cfg.Backend = req.Backend
+		}
+		if !cfg.HasUsecases(config.FLAG_RERANK) {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("model %s can not rerank, it should use the rerankers backend or list rerank in its known_usecases", modelFile))
 		}
+		modelFile = cfg.Model
+
+		logger.Debug().Msgf("Request for model: %s", modelFile)
 
 		request := &proto.RerankRequest{
 			Query:     req.Query,
@@ -64,13 +72,14 @@ func JINARerankEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 			Documents: req.Documents,
 		}
 
-		results, err := backend.Rerank(modelFile, request, ml, appConfig, *cfg)
+		results, err := backend.Rerank(c.UserContext(), modelFile, request, ml, appConfig, *cfg)
 		if err != nil {
 			return err
 		}
 
 		response := &schema.JINARerankResponse{
-			Model: req.Model,
+			Model:   req.Model,
+			Results: []schema.JINADocumentResult{},
 		}
 
 		for _, r := range results.Results {
@@ -81,9 +90,20 @@ func JINARerankEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 			})
 		}
 
-		response.Usage.TotalTokens = int(results.Usage.TotalTokens)
-		response.Usage.PromptTokens = int(results.Usage.PromptTokens)
+		if results.Usage != nil {
+			response.Usage.TotalTokens = int(results.Usage.TotalTokens)
+			response.Usage.PromptTokens = int(results.Usage.PromptTokens)
+		}
 
 		return c.Status(fiber.StatusOK).JSON(response)
 	}
 }
+
+// firstRerankModel returns the name of the first model able to rerank, in the order of the names
+func firstRerankModel(cl *config.BackendConfigLoader) (string, error) {
+	models := cl.GetBackendConfigsByFilter(config.BuildUsecaseFilterFn(config.FLAG_RERANK))
+	if len(models) == 0 {
+		return "", fiber.NewError(fiber.StatusBadRequest, "no rerank model configured, add a model using the rerankers backend or set the model of the request")
+	}
+	return slices.MinFunc(models, func(a, b config.BackendConfig) int { return strings.Compare(a.Name, b.Name) }).Name, nil
+}
@@ -0,0 +1,31 @@
+package localai
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/model"
+)
+
+// BackendLogsEndpoint returns the last lines of output of the backend of a model
+// @Summary Show the last lines of output of the backend of a model
+// @Param model path string true "Model name"
+// @Param lines query int false "Number of lines, all the lines kept by default"
+// @Success 200 {object} schema.BackendLogsResponse "Response"
+// @Router /system/backends/{model}/logs [get]
+func BackendLogsEndpoint(ml *model.ModelLoader) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		modelID := c.Params("model")
+		lines := c.QueryInt("lines", 0)
+		if lines < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "lines cannot be negative")
+		}
+
+		logs, exists := ml.BackendLogs(modelID, lines)
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("no backend output captured for model %s", modelID))
+		}
+		return c.JSON(schema.BackendLogsResponse{Model: modelID, Lines: logs})
+	}
+}
@@ -1,9 +1,12 @@
 package localai
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -11,7 +14,15 @@ import (
 	"github.com/mudler/LocalAI/core/gallery"
 	"github.com/mudler/LocalAI/core/schema"
 	"github.com/mudler/LocalAI/core/services"
-	"github.com/rs/zerolog/log"
+	"github.com/mudler/LocalAI/pkg/xlog"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// how often the job status is checked for changes when streaming it
+	statusStreamPollInterval = 500 * time.Millisecond
+	// how long the job status stream can stay silent before sending a keep-alive
+	statusStreamKeepAlive = 15 * time.Second
 )
 
 type ModelGalleryEndpointService struct {
@@ -23,6 +34,8 @@ type ModelGalleryEndpointService struct {
 type GalleryModel struct {
 	ID        string `json:"id"`
 	ConfigURL string `json:"config_url"`
+	// DryRun returns the files the install would download and whether they fit on the disk, instead of installing the model
+	DryRun bool `json:"dry_run"`
 	gallery.GalleryModel
 }
 
@@ -48,6 +61,59 @@ func (mgs *ModelGalleryEndpointService) GetOpStatusEndpoint() func(c *fiber.Ctx)
 	}
 }
 
+// GetOpStatusStreamEndpoint streams the job status progress as server-sent events, until the job is processed
+// @Summary Streams the job status progress
+// @Success 200 {object} gallery.GalleryOpStatus "Response"
+// @Router /models/jobs/{uuid}/stream [get]
+func (mgs *ModelGalleryEndpointService) GetOpStatusStreamEndpoint() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		jobID := c.Params("uuid")
+		if mgs.galleryApplier.GetStatus(jobID) == nil {
+			return fmt.Errorf("could not find any status for ID")
+		}
+
+		c.Context().SetContentType("text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Set("Transfer-Encoding", "chunked")
+
+		c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+			var last *gallery.GalleryOpStatus
+			lastWrite := time.Now()
+			for {
+				status := mgs.galleryApplier.GetStatus(jobID)
+				switch {
+				case status != last:
+					last = status
+					dat, err := json.Marshal(status)
+					if err != nil {
+						xlog.HTTP.Error().Err(err).Msg("failed to marshal job status")
+						return
+					}
+					fmt.Fprintf(w, "data: %s\n\n", dat)
+				case time.Since(lastWrite) > statusStreamKeepAlive:
+					// send a comment, so we notice if the client went away while the job is idle
+					w.WriteString(": keep-alive\n\n")
+				default:
+					time.Sleep(statusStreamPollInterval)
+					continue
+				}
+
+				if err := w.Flush(); err != nil {
+					xlog.HTTP.Debug().Str("job", jobID).Msg("client disconnected from job status stream")
+					return
+				}
+				lastWrite = time.Now()
+
+				if last.Processed {
+					return
+				}
+			}
+		}))
+		return nil
+	}
+}
+
 // GetAllStatusEndpoint returns all the jobs status progress
 // @Summary Returns all the jobs status progress
 // @Success 200 {object} map[string]gallery.GalleryOpStatus "Response"
@@ -58,19 +124,36 @@ func (mgs *ModelGalleryEndpointService) GetAllStatusEndpoint() func(c *fiber.Ctx
 	}
 }
 
-// ApplyModelGalleryEndpoint installs a new model to a LocalAI instance from the model gallery
+// ApplyModelGalleryEndpoint installs a new model to a LocalAI instance from the model gallery. With dry_run, it
+// returns the files the install would download and whether they fit on the disk instead
 // @Summary Install models to LocalAI.
 // @Param request body GalleryModel true "query params"
 // @Success 200 {object} schema.GalleryResponse "Response"
+// @Success 200 {object} gallery.InstallReport "Response with dry_run"
 // @Router /models/apply [post]
 func (mgs *ModelGalleryEndpointService) ApplyModelGalleryEndpoint() func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		if mgs.galleryApplier.Offline() {
+			return fiber.NewError(fiber.StatusServiceUnavailable, services.ErrOfflineMode.Error())
+		}
+
 		input := new(GalleryModel)
 		// Get input data from the request body
 		if err := c.BodyParser(input); err != nil {
 			return err
 		}
 
+		if input.DryRun {
+			if input.ConfigURL != "" {
+				return fiber.NewError(fiber.StatusBadRequest, "dry_run is not supported with config_url")
+			}
+			report, err := mgs.galleryApplier.DryRun(c.Context(), input.ID, input.GalleryModel, mgs.galleries)
+			if err != nil {
+				return err
+			}
+			return c.JSON(report)
+		}
+
 		uuid, err := uuid.NewUUID()
 		if err != nil {
 			return err
@@ -86,6 +169,31 @@ func (mgs *ModelGalleryEndpointService) ApplyModelGalleryEndpoint() func(c *fibe
 	}
 }
 
+// CancelJobEndpoint cancels an in-progress model installation
+// @Summary Cancels a model installation job.
+// @Param uuid	path string	true	"Job ID"
+// @Success 200 {object} gallery.GalleryOpStatus "Response"
+// @Router /models/apply/{uuid}/cancel [post]
+func (mgs *ModelGalleryEndpointService) CancelJobEndpoint() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		jobID := c.Params("uuid")
+		status := mgs.galleryApplier.GetStatus(jobID)
+		if status == nil {
+			return fiber.NewError(fiber.StatusNotFound, "could not find any status for ID")
+		}
+		if status.Processed {
+			return fiber.NewError(fiber.StatusConflict, "job already completed")
+		}
+		if err := mgs.galleryApplier.CancelOperation(jobID); err != nil {
+			if errors.Is(err, services.ErrOpNotRunning) {
+				return fiber.NewError(fiber.StatusConflict, "job already completed")
+			}
+			return err
+		}
+		return c.JSON(mgs.galleryApplier.GetStatus(jobID))
+	}
+}
+
 // DeleteModelGalleryEndpoint lets delete models from a LocalAI instance
 // @Summary delete models to LocalAI.
 // @Param name	path string	true	"Model name"
@@ -115,15 +223,19 @@ func (mgs *ModelGalleryEndpointService) DeleteModelGalleryEndpoint() func(c *fib
 // @Router /models/available [get]
 func (mgs *ModelGalleryEndpointService) ListModelFromGalleryEndpoint() func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
-		log.Debug().Msgf("Listing models from galleries: %+v", mgs.galleries)
+		if mgs.galleryApplier.Offline() {
+			return fiber.NewError(fiber.StatusServiceUnavailable, services.ErrOfflineMode.Error())
+		}
+
+		xlog.HTTP.Debug().Msgf("Listing models from galleries: %+v", mgs.galleries)
 
 		models, err := gallery.AvailableGalleryModels(mgs.galleries, mgs.modelPath)
 		if err != nil {
 			return err
 		}
-		log.Debug().Msgf("Models found from galleries: %+v", models)
+		xlog.HTTP.Debug().Msgf("Models found from galleries: %+v", models)
 		for _, m := range models {
-			log.Debug().Msgf("Model found from galleries: %+v", m)
+			xlog.HTTP.Debug().Msgf("Model found from galleries: %+v", m)
 		}
 		dat, err := json.Marshal(models)
 		if err != nil {
@@ -133,6 +245,37 @@ func (mgs *ModelGalleryEndpointService) ListModelFromGalleryEndpoint() func(c *f
 	}
 }
 
+// RefreshGalleriesResponse is the number of models of the index of each gallery, after fetching them again
+type RefreshGalleriesResponse struct {
+	Galleries []gallery.GalleryIndex `json:"galleries"`
+	// Models is the number of models of all the indexes fetched
+	Models int `json:"models"`
+}
+
+// RefreshGalleriesEndpoint fetches the index of each gallery again, e.g. to check that a model just published
+// is listed, and returns the number of models of each one. It answers 502 if an index can't be fetched.
+// @Summary Fetch the indexes of the galleries again
+// @Success 200 {object} RefreshGalleriesResponse "Response"
+// @Failure 502 {object} RefreshGalleriesResponse "Response"
+// @Router /models/galleries/refresh [post]
+func (mgs *ModelGalleryEndpointService) RefreshGalleriesEndpoint() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if mgs.galleryApplier.Offline() {
+			return fiber.NewError(fiber.StatusServiceUnavailable, services.ErrOfflineMode.Error())
+		}
+
+		response := RefreshGalleriesResponse{Galleries: gallery.FetchGalleryIndexes(mgs.galleries, mgs.modelPath)}
+		for _, index := range response.Galleries {
+			response.Models += index.Models
+			if index.Error != "" {
+				c.Status(fiber.StatusBadGateway)
+			}
+		}
+		xlog.HTTP.Info().Int("models", response.Models).Int("galleries", len(response.Galleries)).Msg("refreshed the indexes of the galleries")
+		return c.JSON(response)
+	}
+}
+
 // ListModelGalleriesEndpoint list the available galleries configured in LocalAI
 // @Summary List all Galleries
 // @Success 200 {object} []config.Gallery "Response"
@@ -140,7 +283,7 @@ func (mgs *ModelGalleryEndpointService) ListModelFromGalleryEndpoint() func(c *f
 // NOTE: This is different (and much simpler!) than above! This JUST lists the model galleries that have been loaded, not their contents!
 func (mgs *ModelGalleryEndpointService) ListModelGalleriesEndpoint() func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
-		log.Debug().Msgf("Listing model galleries %+v", mgs.galleries)
+		xlog.HTTP.Debug().Msgf("Listing model galleries %+v", mgs.galleries)
 		dat, err := json.Marshal(mgs.galleries)
 		if err != nil {
 			return err
@@ -170,8 +313,12 @@ func (mgs *ModelGalleryEndpointService) AddModelGalleryEndpoint() func(c *fiber.
 		if err != nil {
 			return err
 		}
-		log.Debug().Msgf("Adding %+v to gallery list", *input)
-		mgs.galleries = append(mgs.galleries, *input)
+		xlog.HTTP.Debug().Msgf("Adding %+v to gallery list", *input)
+		galleries := append(slices.Clone(mgs.galleries), *input)
+		if err := gallery.SetGalleriesCredentials(galleries); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		mgs.galleries = galleries
 		return c.Send(dat)
 	}
 }
@@ -196,6 +343,9 @@ func (mgs *ModelGalleryEndpointService) RemoveModelGalleryEndpoint() func(c *fib
 		mgs.galleries = slices.DeleteFunc(mgs.galleries, func(gallery config.Gallery) bool {
 			return gallery.Name == input.Name
 		})
+		if err := gallery.SetGalleriesCredentials(mgs.galleries); err != nil {
+			return err
+		}
 		dat, err := json.Marshal(mgs.galleries)
 		if err != nil {
 			return err
@@ -6,8 +6,9 @@ import (
 	"github.com/mudler/LocalAI/core/config"
 	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 	"github.com/mudler/LocalAI/core/schema"
-	"github.com/rs/zerolog/log"
+	"github.com/mudler/LocalAI/pkg/xlog"
 
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/model"
 )
 
@@ -21,6 +22,7 @@ import (
 //	@Router		/tokenMetrics [get]
 func TokenMetricsEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
 
 		input := new(schema.TokenMetricsRequest)
 
@@ -32,7 +34,7 @@ func TokenMetricsEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader,
 		modelFile, err := fiberContext.ModelFromContext(c, cl, ml, input.Model, false)
 		if err != nil {
 			modelFile = input.Model
-			log.Warn().Msgf("Model not found in context: %s", input.Model)
+			logger.Warn().Msgf("Model not found in context: %s", input.Model)
 		}
 
 		cfg, err := cl.LoadBackendConfigFileByName(modelFile, appConfig.ModelPath,
@@ -43,13 +45,13 @@ func TokenMetricsEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader,
 		)
 
 		if err != nil {
-			log.Err(err)
+			xlog.HTTP.Err(err)
 			modelFile = input.Model
-			log.Warn().Msgf("Model not found in context: %s", input.Model)
+			logger.Warn().Msgf("Model not found in context: %s", input.Model)
 		} else {
 			modelFile = cfg.Model
 		}
-		log.Debug().Msgf("Token Metrics for model: %s", modelFile)
+		logger.Debug().Msgf("Token Metrics for model: %s", modelFile)
 
 		response, err := backend.TokenMetrics(modelFile, ml, appConfig, *cfg)
 		if err != nil {
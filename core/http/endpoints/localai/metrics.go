@@ -5,6 +5,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 	"github.com/mudler/LocalAI/core/services"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -34,6 +35,8 @@ func LocalAIMetricsAPIMiddleware(metrics *services.LocalAIMetricsService) fiber.
 		if cfg.Filter != nil && cfg.Filter(c) {
 			return c.Next()
 		}
+		fiberContext.WithMetricsService(c, cfg.metricsService)
+
 		path := c.Path()
 		method := c.Method()
 
@@ -0,0 +1,62 @@
+package localai
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/mudler/LocalAI/pkg/xlog"
+)
+
+// ReloadModelEndpoint reads again the configuration of a model from disk and, when it changed and the
+// model is loaded, loads its backend again with the new configuration
+// @Summary Reload the configuration of a model from disk
+// @Param name path string true "Model name"
+// @Success 200 {object} schema.ModelReloadResponse "Response"
+// @Router /models/{name}/reload [post]
+func ReloadModelEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		// an alias reloads the model it names
+		old, known := cl.GetBackendConfig(name)
+		if known {
+			name = old.Name
+		}
+
+		configLoaderOpts := appConfig.ToConfigLoaderOptions()
+		file, err := config.FindBackendConfigFile(name, append([]string{appConfig.ModelPath}, appConfig.ExtraModelPaths...), configLoaderOpts...)
+		if errors.Is(err, config.ErrBackendConfigNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("no configuration file for model %s", name))
+		}
+		if err != nil {
+			return err
+		}
+		if err := cl.LoadBackendConfig(file, configLoaderOpts...); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("failed loading %s: %s", file, err))
+		}
+		updated, _ := cl.GetBackendConfig(name)
+
+		changes, err := config.DiffBackendConfigs(old, updated)
+		if err != nil {
+			return err
+		}
+		resp := schema.ModelReloadResponse{Model: name, Changes: changes}
+		if !known || len(changes) == 0 || ml.CheckIsLoaded(name) == nil {
+			return c.JSON(resp)
+		}
+
+		if err := ml.ShutdownModel(name); err != nil {
+			return fmt.Errorf("failed shutting down model %s: %w", name, err)
+		}
+		if err := backend.PreloadModel(c.UserContext(), ml, updated, appConfig); err != nil {
+			return fmt.Errorf("failed loading model %s with the new configuration: %w", name, err)
+		}
+		resp.Reloaded = true
+		xlog.HTTP.Info().Str("model", name).Int("changes", len(changes)).Msg("model reloaded with its new configuration")
+		return c.JSON(resp)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
 	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
 )
 
 // SystemInformations returns the system informations
@@ -29,3 +31,82 @@ func SystemInformations(ml *model.ModelLoader, appConfig *config.ApplicationConf
 		)
 	}
 }
+
+// VerifyModelsRequest selects the models to verify, all the models with a manifest when empty
+type VerifyModelsRequest struct {
+	Models []string `json:"models"`
+}
+
+// VerifyModelsEndpoint starts re-hashing the files of the installed models in the background, to compare them
+// with the manifest recorded at install
+// @Summary Verify the checksums of the files of the installed models
+// @Param request body VerifyModelsRequest false "query params"
+// @Success 200 {object} schema.GalleryResponse "Response"
+// @Router /system/verify-models [post]
+func VerifyModelsEndpoint(verifier *services.ModelVerificationService) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		input := new(VerifyModelsRequest)
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(input); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+		}
+
+		id := verifier.Start(input.Models)
+		return c.JSON(schema.GalleryResponse{ID: id, StatusURL: c.BaseURL() + "/system/verify-models/" + id})
+	}
+}
+
+// VerifyModelsStatusEndpoint returns the status of a verification of the installed models, with the files
+// missing or changed once it is processed
+// @Summary Returns the status of a verification of the installed models
+// @Param uuid	path string	true	"Verification ID"
+// @Success 200 {object} services.ModelVerificationStatus "Response"
+// @Router /system/verify-models/{uuid} [get]
+func VerifyModelsStatusEndpoint(verifier *services.ModelVerificationService) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		status := verifier.GetStatus(c.Params("uuid"))
+		if status == nil {
+			return fiber.NewError(fiber.StatusNotFound, "could not find any verification for ID")
+		}
+		return c.JSON(status)
+	}
+}
+
+// SystemCapabilitiesEndpoint returns the CPU capabilities and the GPUs of the node
+// @Summary Show the CPU capabilities and the GPUs of the node
+// @Success 200 {object} schema.SystemCapabilitiesResponse "Response"
+// @Router /system/capabilities [get]
+func SystemCapabilitiesEndpoint() func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		caps, err := xsysinfo.CPUCapabilities()
+		if err != nil {
+			return err
+		}
+		cards, err := xsysinfo.GPUs()
+		if err != nil {
+			return err
+		}
+
+		gpus := []schema.GPUInformation{}
+		for _, card := range cards {
+			gpu := schema.GPUInformation{Index: card.Index, Address: card.Address}
+			if d := card.DeviceInfo; d != nil {
+				gpu.Driver = d.Driver
+				if d.Vendor != nil {
+					gpu.Vendor = d.Vendor.Name
+				}
+				if d.Product != nil {
+					gpu.Product = d.Product.Name
+				}
+			}
+			gpus = append(gpus, gpu)
+		}
+
+		return c.JSON(schema.SystemCapabilitiesResponse{
+			CPUCapabilities:  caps,
+			CPUPhysicalCores: xsysinfo.CPUPhysicalCores(),
+			GPUs:             gpus,
+		})
+	}
+}
@@ -6,12 +6,13 @@ import (
 	"github.com/mudler/LocalAI/core/config"
 	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/model"
-	"github.com/rs/zerolog/log"
 )
 
 // TokenizeEndpoint exposes a REST API to tokenize the content
 // @Summary Tokenize the input.
+// @Param request body schema.TokenizeRequest true "Request"
 // @Success 200 {object} schema.TokenizeResponse "Response"
 // @Router /v1/tokenize [post]
 func TokenizeEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
@@ -24,35 +25,68 @@ func TokenizeEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, app
 			return err
 		}
 
-		modelFile, err := fiberContext.ModelFromContext(c, cl, ml, input.Model, false)
+		cfg, err := tokenizerConfig(c, cl, ml, appConfig, input.Model)
 		if err != nil {
-			modelFile = input.Model
-			log.Warn().Msgf("Model not found in context: %s", input.Model)
+			return err
+		}
+
+		tokenResponse, err := backend.ModelTokenize(input.Content, ml, *cfg, appConfig)
+		if err != nil {
+			return err
 		}
 
-		cfg, err := cl.LoadBackendConfigFileByName(modelFile, appConfig.ModelPath,
-			config.LoadOptionDebug(appConfig.Debug),
-			config.LoadOptionThreads(appConfig.Threads),
-			config.LoadOptionContextSize(appConfig.ContextSize),
-			config.LoadOptionF16(appConfig.F16),
-		)
+		return c.JSON(tokenResponse)
+	}
+}
+
+// DetokenizeEndpoint exposes a REST API to convert tokens back to text
+// @Summary Detokenize the input.
+// @Param request body schema.DetokenizeRequest true "Request"
+// @Success 200 {object} schema.DetokenizeResponse "Response"
+// @Router /v1/detokenize [post]
+func DetokenizeEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+
+		input := new(schema.DetokenizeRequest)
 
+		// Get input data from the request body
+		if err := c.BodyParser(input); err != nil {
+			return err
+		}
+
+		cfg, err := tokenizerConfig(c, cl, ml, appConfig, input.Model)
 		if err != nil {
-			log.Err(err)
-			modelFile = input.Model
-			log.Warn().Msgf("Model not found in context: %s", input.Model)
-		} else {
-			modelFile = cfg.Model
+			return err
 		}
-		log.Debug().Msgf("Request for model: %s", modelFile)
 
-		tokenResponse, err := backend.ModelTokenize(input.Content, ml, *cfg, appConfig)
+		detokenizeResponse, err := backend.ModelDetokenize(input.Tokens, ml, *cfg, appConfig)
 		if err != nil {
 			return err
 		}
 
-		c.JSON(tokenResponse)
-		return nil
+		return c.JSON(detokenizeResponse)
+	}
+}
 
+// tokenizerConfig returns the configuration of the model whose tokenizer is requested
+func tokenizerConfig(c *fiber.Ctx, cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig, modelName string) (*config.BackendConfig, error) {
+	logger := correlation.Logger(c.UserContext())
+	modelFile, err := fiberContext.ModelFromContext(c, cl, ml, modelName, false)
+	if err != nil {
+		modelFile = modelName
+		logger.Warn().Msgf("Model not found in context: %s", modelName)
 	}
+
+	cfg, err := cl.LoadBackendConfigFileByName(modelFile, appConfig.ModelPath,
+		config.LoadOptionDebug(appConfig.Debug),
+		config.LoadOptionThreads(appConfig.Threads),
+		config.LoadOptionContextSize(appConfig.ContextSize),
+		config.LoadOptionF16(appConfig.F16),
+	)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug().Msgf("Request for model: %s", cfg.Model)
+
+	return cfg, nil
 }
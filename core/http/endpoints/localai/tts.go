@@ -1,26 +1,41 @@
 package localai
 
 import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
 	"github.com/mudler/LocalAI/core/backend"
 	"github.com/mudler/LocalAI/core/config"
 	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/mudler/LocalAI/pkg/utils"
+	"github.com/mudler/LocalAI/pkg/xlog"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/mudler/LocalAI/core/schema"
-	"github.com/rs/zerolog/log"
 )
 
 // TTSEndpoint is the OpenAI Speech API endpoint https://platform.openai.com/docs/api-reference/audio/createSpeech
-//	@Summary	Generates audio from the input text.
-//  @Accept json
-//  @Produce audio/x-wav
-//	@Param		request	body		schema.TTSRequest	true	"query params"
-//	@Success	200		{string}	binary				"generated audio/wav file"
-//	@Router		/v1/audio/speech [post]
-//	@Router		/tts [post]
+//
+//		@Summary	Generates audio from the input text.
+//	 @Accept json
+//	 @Produce audio/x-wav
+//	 @Produce audio/mpeg
+//	 @Produce audio/ogg
+//		@Param		request	body		schema.TTSRequest	true	"query params"
+//		@Success	200		{string}	binary				"generated audio file, in the requested format"
+//		@Router		/v1/audio/speech [post]
+//		@Router		/tts [post]
 func TTSEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
 
 		input := new(schema.TTSRequest)
 
@@ -29,10 +44,15 @@ func TTSEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfi
 			return err
 		}
 
+		contentType, err := validateSpeechFormat(input)
+		if err != nil {
+			return err
+		}
+
 		modelFile, err := fiberContext.ModelFromContext(c, cl, ml, input.Model, false)
 		if err != nil {
 			modelFile = input.Model
-			log.Warn().Msgf("Model not found in context: %s", input.Model)
+			logger.Warn().Msgf("Model not found in context: %s", input.Model)
 		}
 
 		cfg, err := cl.LoadBackendConfigFileByName(modelFile, appConfig.ModelPath,
@@ -43,13 +63,13 @@ func TTSEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfi
 		)
 
 		if err != nil {
-			log.Err(err)
+			xlog.HTTP.Err(err)
 			modelFile = input.Model
-			log.Warn().Msgf("Model not found in context: %s", input.Model)
+			logger.Warn().Msgf("Model not found in context: %s", input.Model)
 		} else {
 			modelFile = cfg.Model
 		}
-		log.Debug().Msgf("Request for model: %s", modelFile)
+		logger.Debug().Msgf("Request for model: %s", modelFile)
 
 		if input.Backend != "" {
 			cfg.Backend = input.Backend
@@ -59,14 +79,69 @@ func TTSEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfi
 			cfg.Language = input.Language
 		}
 
-		if input.Voice != "" {
-			cfg.Voice = input.Voice
+		voice, err := resolveVoice(cfg.TTSConfig, input.Voice)
+		if err != nil {
+			return err
 		}
 
-		filePath, _, err := backend.ModelTTS(cfg.Backend, input.Input, modelFile, cfg.Voice, cfg.Language, ml, appConfig, *cfg)
+		filePath, _, err := backend.ModelTTS(c.UserContext(), cfg.Backend, input.Input, modelFile, voice, cfg.Language, ml, appConfig, *cfg)
 		if err != nil {
 			return err
 		}
-		return c.Download(filePath)
+
+		c.Attachment(strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)) + "." + cmp.Or(input.ResponseFormat, "wav"))
+		c.Set(fiber.HeaderContentType, contentType)
+
+		// the backends generate wav audio, the other formats and speeds are transcoded by ffmpeg and
+		// streamed as they are encoded
+		if input.ResponseFormat == "" || (input.ResponseFormat == "wav" && input.Speed == 1) {
+			f, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			return c.SendStream(f)
+		}
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := utils.AudioConvert(filePath, input.ResponseFormat, input.Speed, w); err != nil {
+				logger.Error().Err(err).Str("format", input.ResponseFormat).Msg("failed transcoding the audio")
+			}
+		})
+		return nil
+	}
+}
+
+// validateSpeechFormat checks the response format and the speed of the request, defaulting the speed to 1,
+// and returns the content type of the response
+func validateSpeechFormat(input *schema.TTSRequest) (string, error) {
+	if input.Speed == 0 {
+		input.Speed = 1
+	}
+	if input.Speed < 0.25 || input.Speed > 4 {
+		return "", fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("speed %g is out of range, should be between 0.25 and 4.0", input.Speed))
+	}
+	// without a response format, the speed is applied to the wav audio of the backend
+	if input.ResponseFormat == "" && input.Speed != 1 {
+		input.ResponseFormat = "wav"
+	}
+	contentType, supported := utils.AudioContentType(cmp.Or(input.ResponseFormat, "wav"))
+	if !supported {
+		return "", fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unsupported response format %q, should be one of mp3, opus, aac, flac, wav or pcm", input.ResponseFormat))
+	}
+	return contentType, nil
+}
+
+// resolveVoice returns the voice of the backend for the voice of the request. When the model declares
+// its voices, the request can only use one of them, otherwise its voice is passed as is to the backend.
+func resolveVoice(cfg config.TTSConfig, voice string) (string, error) {
+	if voice == "" {
+		return cfg.Voice, nil
+	}
+	if len(cfg.Voices) == 0 {
+		return voice, nil
+	}
+	if backendVoice, exists := cfg.Voices[voice]; exists {
+		return backendVoice, nil
 	}
+	available := slices.Sorted(maps.Keys(cfg.Voices))
+	return "", fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("voice %q is not available, should be one of %s", voice, strings.Join(available, ", ")))
 }
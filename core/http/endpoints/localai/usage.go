@@ -0,0 +1,31 @@
+package localai
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
+)
+
+// UsageEndpoint returns the requests and the tokens accounted to each API key
+// @Summary Show the usage of each API key, identified by a hash of the key and by its label
+// @Success 200 {object} schema.UsageResponse "Response"
+// @Router /system/usage [get]
+func UsageEndpoint(appConfig *config.ApplicationConfig) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		resp := schema.UsageResponse{Keys: []schema.KeyUsage{}}
+		if tracker := fiberContext.UsageTrackerFromContext(c); tracker != nil {
+			resp.Keys = tracker.Usage()
+		}
+
+		labels := map[string]string{}
+		for key, label := range appConfig.ApiKeyLabels {
+			labels[services.UsageKeyID(key)] = label
+		}
+		for i := range resp.Keys {
+			resp.Keys[i].Label = labels[resp.Keys[i].Key]
+		}
+		return c.JSON(resp)
+	}
+}
@@ -0,0 +1,35 @@
+package localai
+
+import (
+	"github.com/dave-gray101/v2keyauth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
+	"github.com/mudler/LocalAI/pkg/model"
+)
+
+// ListVoicesEndpoint lists the voices of the TTS models, which can be used as the voice of the speech requests
+// @Summary List the voices of the TTS models
+// @Param model query string false "Only list the voices of this model"
+// @Success 200 {object} schema.VoicesResponse "Response"
+// @Router /v1/audio/voices [get]
+func ListVoicesEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		modelFilter := c.Query("model")
+		apiKey := v2keyauth.TokenFromContext(c)
+
+		voices := []schema.Voice{}
+		for _, v := range services.ListVoices(cl, ml) {
+			if (modelFilter != "" && v.Model != modelFilter) || !appConfig.ModelAllowedForApiKey(apiKey, v.Model) {
+				continue
+			}
+			voices = append(voices, v)
+		}
+
+		return c.JSON(schema.VoicesResponse{
+			Object: "list",
+			Data:   voices,
+		})
+	}
+}
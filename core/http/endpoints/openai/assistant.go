@@ -15,7 +15,7 @@ import (
 	"github.com/mudler/LocalAI/core/services"
 	model "github.com/mudler/LocalAI/pkg/model"
 	"github.com/mudler/LocalAI/pkg/utils"
-	"github.com/rs/zerolog/log"
+	"github.com/mudler/LocalAI/pkg/xlog"
 )
 
 // ToolType defines a type for tool options
@@ -77,12 +77,12 @@ func CreateAssistantEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoad
 	return func(c *fiber.Ctx) error {
 		request := new(AssistantRequest)
 		if err := c.BodyParser(request); err != nil {
-			log.Warn().AnErr("Unable to parse AssistantRequest", err)
+			xlog.HTTP.Warn().AnErr("Unable to parse AssistantRequest", err)
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
 		}
 
 		if !modelExists(cl, ml, request.Model) {
-			log.Warn().Msgf("Model: %s was not found in list of models.", request.Model)
+			xlog.HTTP.Warn().Msgf("Model: %s was not found in list of models.", request.Model)
 			return c.Status(fiber.StatusBadRequest).SendString("Model " + request.Model + " not found")
 		}
 
@@ -262,7 +262,7 @@ func DeleteAssistantEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoad
 			}
 		}
 
-		log.Warn().Msgf("Unable to find assistant %s for deletion", assistantID)
+		xlog.HTTP.Warn().Msgf("Unable to find assistant %s for deletion", assistantID)
 		return c.Status(fiber.StatusNotFound).JSON(schema.DeleteAssistantResponse{
 			ID:      assistantID,
 			Object:  "assistant.deleted",
@@ -411,7 +411,7 @@ func ModifyAssistantEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoad
 	return func(c *fiber.Ctx) error {
 		request := new(AssistantRequest)
 		if err := c.BodyParser(request); err != nil {
-			log.Warn().AnErr("Unable to parse AssistantRequest", err)
+			xlog.HTTP.Warn().AnErr("Unable to parse AssistantRequest", err)
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
 		}
 
@@ -474,7 +474,7 @@ func DeleteAssistantFileEndpoint(cl *config.BackendConfigLoader, ml *model.Model
 					}
 				}
 
-				log.Warn().Msgf("Unable to locate file_id: %s in assistants: %s. Continuing to delete assistant file.", fileId, assistantID)
+				xlog.HTTP.Warn().Msgf("Unable to locate file_id: %s in assistants: %s. Continuing to delete assistant file.", fileId, assistantID)
 				for i, assistantFile := range AssistantFiles {
 					if assistantFile.AssistantID == assistantID {
 
@@ -490,7 +490,7 @@ func DeleteAssistantFileEndpoint(cl *config.BackendConfigLoader, ml *model.Model
 				}
 			}
 		}
-		log.Warn().Msgf("Unable to find assistant: %s", assistantID)
+		xlog.HTTP.Warn().Msgf("Unable to find assistant: %s", assistantID)
 
 		return c.Status(fiber.StatusNotFound).JSON(schema.DeleteAssistantFileResponse{
 			ID:      fileId,
@@ -12,10 +12,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/mudler/LocalAI/core/backend"
 	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/functions"
+	"github.com/mudler/LocalAI/pkg/grpc"
 	model "github.com/mudler/LocalAI/pkg/model"
-	"github.com/rs/zerolog/log"
+	"github.com/mudler/LocalAI/pkg/xlog"
 	"github.com/valyala/fasthttp"
 )
 
@@ -28,7 +31,8 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 	var id, textContentToReturn string
 	var created int
 
-	process := func(s string, req *schema.OpenAIRequest, config *config.BackendConfig, loader *model.ModelLoader, responses chan schema.OpenAIResponse) {
+	// the stream processors set the finish reason of the stream before closing the responses
+	process := func(s string, req *schema.OpenAIRequest, config *config.BackendConfig, loader *model.ModelLoader, responses chan schema.OpenAIResponse, finishReason *string) {
 		initialMessage := schema.OpenAIResponse{
 			ID:      id,
 			Created: created,
@@ -38,39 +42,51 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 		}
 		responses <- initialMessage
 
-		ComputeChoices(req, s, config, startupOptions, loader, func(s string, c *[]schema.Choice) {}, func(s string, usage backend.TokenUsage) bool {
+		choices, _, _ := ComputeChoices(req, s, config, startupOptions, loader, func(s string, c *[]schema.Choice) {
+			*c = append(*c, schema.Choice{FinishReason: "stop"})
+		}, func(s string, usage backend.TokenUsage) bool {
 			resp := schema.OpenAIResponse{
 				ID:      id,
 				Created: created,
 				Model:   req.Model, // we have to return what the user sent here, due to OpenAI spec.
 				Choices: []schema.Choice{{Delta: &schema.Message{Content: &s}, Index: 0}},
 				Object:  "chat.completion.chunk",
-				Usage: schema.OpenAIUsage{
-					PromptTokens:     usage.Prompt,
-					CompletionTokens: usage.Completion,
-					TotalTokens:      usage.Prompt + usage.Completion,
-				},
+				Usage:   openAIUsage(usage),
 			}
 
 			responses <- resp
 			return true
 		})
+		if len(choices) > 0 {
+			*finishReason = choices[0].FinishReason
+		}
 		close(responses)
 	}
-	processTools := func(noAction string, prompt string, req *schema.OpenAIRequest, config *config.BackendConfig, loader *model.ModelLoader, responses chan schema.OpenAIResponse) {
+	processTools := func(noAction string, prompt string, req *schema.OpenAIRequest, config *config.BackendConfig, loader *model.ModelLoader, responses chan schema.OpenAIResponse, finishReason *string) {
+		logger := correlation.Logger(req.Context)
 		result := ""
-		_, tokenUsage, _ := ComputeChoices(req, prompt, config, startupOptions, loader, func(s string, c *[]schema.Choice) {}, func(s string, usage backend.TokenUsage) bool {
+		toolCalls := functions.NewToolCallStream(config.FunctionsConfig, noAction)
+		sendToolCalls := func(deltas []functions.ToolCallDelta) {
+			for _, d := range deltas {
+				responses <- toolCallChunk(id, created, req.Model, d)
+			}
+		}
+		choices, tokenUsage, _ := ComputeChoices(req, prompt, config, startupOptions, loader, func(s string, c *[]schema.Choice) {
+			*c = append(*c, schema.Choice{FinishReason: "stop"})
+		}, func(s string, usage backend.TokenUsage) bool {
 			result += s
-			// TODO: Change generated BNF grammar to be compliant with the schema so we can
-			// stream the result token by token here.
+			sendToolCalls(toolCalls.Push(s))
 			return true
 		})
+		if len(choices) > 0 {
+			*finishReason = choices[0].FinishReason
+		}
 
 		textContentToReturn = functions.ParseTextContent(result, config.FunctionsConfig)
 		result = functions.CleanupLLMResult(result, config.FunctionsConfig)
 		functionResults := functions.ParseFunctionCall(result, config.FunctionsConfig)
-		log.Debug().Msgf("Text content to return: %s", textContentToReturn)
-		noActionToRun := len(functionResults) > 0 && functionResults[0].Name == noAction || len(functionResults) == 0
+		logger.Debug().Msgf("Text content to return: %s", textContentToReturn)
+		noActionToRun := toolCalls.Streamed() == 0 && (len(functionResults) > 0 && functionResults[0].Name == noAction || len(functionResults) == 0)
 
 		switch {
 		case noActionToRun:
@@ -85,7 +101,7 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 
 			result, err := handleQuestion(config, req, ml, startupOptions, functionResults, result, prompt)
 			if err != nil {
-				log.Error().Err(err).Msg("error handling question")
+				logger.Error().Err(err).Msg("error handling question")
 				return
 			}
 
@@ -95,78 +111,35 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 				Model:   req.Model, // we have to return what the user sent here, due to OpenAI spec.
 				Choices: []schema.Choice{{Delta: &schema.Message{Content: &result}, Index: 0}},
 				Object:  "chat.completion.chunk",
-				Usage: schema.OpenAIUsage{
-					PromptTokens:     tokenUsage.Prompt,
-					CompletionTokens: tokenUsage.Completion,
-					TotalTokens:      tokenUsage.Prompt + tokenUsage.Completion,
-				},
+				Usage:   openAIUsage(tokenUsage),
 			}
 
 			responses <- resp
 
 		default:
-			for i, ss := range functionResults {
-				name, args := ss.Name, ss.Arguments
-
-				initialMessage := schema.OpenAIResponse{
-					ID:      id,
-					Created: created,
-					Model:   req.Model, // we have to return what the user sent here, due to OpenAI spec.
-					Choices: []schema.Choice{{
-						Delta: &schema.Message{
-							Role: "assistant",
-							ToolCalls: []schema.ToolCall{
-								{
-									Index: i,
-									ID:    id,
-									Type:  "function",
-									FunctionCall: schema.FunctionCall{
-										Name: name,
-									},
-								},
-							},
-						}}},
-					Object: "chat.completion.chunk",
-				}
-				responses <- initialMessage
-
+			if textContentToReturn != "" {
+				// the text the LLM generated along with the calls
+				content := textContentToReturn
 				responses <- schema.OpenAIResponse{
 					ID:      id,
 					Created: created,
 					Model:   req.Model, // we have to return what the user sent here, due to OpenAI spec.
-					Choices: []schema.Choice{{
-						Delta: &schema.Message{
-							Role:    "assistant",
-							Content: &textContentToReturn,
-							ToolCalls: []schema.ToolCall{
-								{
-									Index: i,
-									ID:    id,
-									Type:  "function",
-									FunctionCall: schema.FunctionCall{
-										Arguments: args,
-									},
-								},
-							},
-						}}},
-					Object: "chat.completion.chunk",
+					Choices: []schema.Choice{{Delta: &schema.Message{Role: "assistant", Content: &content}}},
+					Object:  "chat.completion.chunk",
 				}
 			}
+			// the calls which could not be parsed while streaming are sent whole
+			sendToolCalls(toolCalls.Finish(functionResults))
 		}
 
 		close(responses)
 	}
 
 	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
 		textContentToReturn = ""
 		id = uuid.New().String()
 		created = int(time.Now().Unix())
-		// Set CorrelationID
-		correlationID := c.Get("X-Correlation-ID")
-		if len(strings.TrimSpace(correlationID)) == 0 {
-			correlationID = id
-		}
-		c.Set("X-Correlation-ID", correlationID)
 
 		modelFile, input, err := readRequest(c, cl, ml, startupOptions, true)
 		if err != nil {
@@ -177,7 +150,17 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
 		}
-		log.Debug().Msgf("Configuration read: %+v", config)
+		logger.Debug().Msgf("Configuration read: %+v", config)
+		applyRequestTimeout(config, input)
+
+		if err := validateChoicesCount(input, startupOptions); err != nil {
+			return err
+		}
+
+		if err := decodeMessageContents(config, input, startupOptions); err != nil {
+			return err
+		}
+		injectSystemMessage(logger, config, input)
 
 		funcs := input.Functions
 		shouldUseFn := len(input.Functions) > 0 && config.ShouldUseFunctions()
@@ -202,6 +185,9 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 			noActionDescription = config.FunctionsConfig.NoActionDescriptionName
 		}
 
+		// responseSchema is the JSON schema the response has to match (response_format: json_schema)
+		var responseSchema map[string]interface{}
+
 		if config.ResponseFormatMap != nil {
 			d := schema.ChatCompletionResponseFormat{}
 			dat, err := json.Marshal(config.ResponseFormatMap)
@@ -224,12 +210,22 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 				if err != nil {
 					return err
 				}
-				fs := &functions.JSONFunctionStructure{
-					AnyOf: []functions.Item{d.JsonSchema.Schema},
+				if d.JsonSchema.Schema == nil {
+					return fiber.NewError(fiber.StatusBadRequest, "response_format: json_schema requires a schema")
 				}
-				g, err := fs.Grammar(config.FunctionsConfig.GrammarOptions()...)
-				if err == nil {
+				responseSchema = d.JsonSchema.Schema
+
+				if config.SupportsGrammar() {
+					g, err := functions.JSONSchemaGrammar(responseSchema, config.FunctionsConfig.GrammarConfig.PropOrder)
+					if err != nil {
+						return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("response_format: %s", err))
+					}
 					input.Grammar = g
+				} else {
+					if err := functions.CheckJSONSchema(responseSchema); err != nil {
+						return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("response_format: %s", err))
+					}
+					logger.Debug().Str("backend", config.Backend).Msg("backend does not support grammars, the structured output is only validated")
 				}
 			}
 		}
@@ -237,7 +233,7 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 		config.Grammar = input.Grammar
 
 		if shouldUseFn {
-			log.Debug().Msgf("Response needs to process functions")
+			logger.Debug().Msgf("Response needs to process functions")
 		}
 
 		switch {
@@ -287,7 +283,7 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 		// functions are not supported in stream mode (yet?)
 		toStream := input.Stream
 
-		log.Debug().Msgf("Parameters: %+v", config)
+		logger.Debug().Msgf("Parameters: %+v", config)
 
 		var predInput string
 
@@ -332,13 +328,13 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 					}
 					templatedChatMessage, err := ml.EvaluateTemplateForChatMessage(config.TemplateConfig.ChatMessage, chatMessageData)
 					if err != nil {
-						log.Error().Err(err).Interface("message", chatMessageData).Str("template", config.TemplateConfig.ChatMessage).Msg("error processing message with template, skipping")
+						logger.Error().Err(err).Interface("message", chatMessageData).Str("template", config.TemplateConfig.ChatMessage).Msg("error processing message with template, skipping")
 					} else {
 						if templatedChatMessage == "" {
-							log.Warn().Msgf("template \"%s\" produced blank output for %+v. Skipping!", config.TemplateConfig.ChatMessage, chatMessageData)
+							logger.Warn().Msgf("template \"%s\" produced blank output for %+v. Skipping!", config.TemplateConfig.ChatMessage, chatMessageData)
 							continue // TODO: This continue is here intentionally to skip over the line `mess = append(mess, content)` below, and to prevent the sprintf
 						}
-						log.Debug().Msgf("templated message for chat: %s", templatedChatMessage)
+						logger.Debug().Msgf("templated message for chat: %s", templatedChatMessage)
 						content = templatedChatMessage
 					}
 				}
@@ -402,7 +398,7 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 			}
 
 			predInput = strings.Join(mess, joinCharacter)
-			log.Debug().Msgf("Prompt (before templating): %s", predInput)
+			logger.Debug().Msgf("Prompt (before templating): %s", predInput)
 
 			templateFile := ""
 
@@ -428,42 +424,60 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 				})
 				if err == nil {
 					predInput = templatedInput
-					log.Debug().Msgf("Template found, input modified to: %s", predInput)
+					logger.Debug().Msgf("Template found, input modified to: %s", predInput)
 				} else {
-					log.Debug().Msgf("Template failed loading: %s", err.Error())
+					logger.Debug().Msgf("Template failed loading: %s", err.Error())
 				}
 			}
 
-			log.Debug().Msgf("Prompt (after templating): %s", predInput)
+			predInput = injectPrompt(logger, config, predInput)
+			logger.Debug().Msgf("Prompt (after templating): %s", predInput)
 			if shouldUseFn && config.Grammar != "" {
-				log.Debug().Msgf("Grammar: %+v", config.Grammar)
+				logger.Debug().Msgf("Grammar: %+v", config.Grammar)
 			}
 		}
 
 		switch {
 		case toStream:
 
-			log.Debug().Msgf("Stream request received")
+			logger.Debug().Msgf("Stream request received")
 			c.Context().SetContentType("text/event-stream")
 			//c.Response().Header.SetContentType(fiber.MIMETextHTMLCharsetUTF8)
 			//	c.Set("Content-Type", "text/event-stream")
 			c.Set("Cache-Control", "no-cache")
 			c.Set("Connection", "keep-alive")
 			c.Set("Transfer-Encoding", "chunked")
-			c.Set("X-Correlation-ID", id)
+
+			settings, err := streamSettingsFor(startupOptions, input)
+			if err != nil {
+				return err
+			}
+
+			// fail before the stream starts when the model is known not to generate text
+			if err := backend.CheckCapability(ml, *config, grpc.CapabilityGenerate); err != nil {
+				return err
+			}
+
+			release, err := scheduleRequest(c, config, input, startupOptions)
+			if err != nil {
+				return err
+			}
 
 			responses := make(chan schema.OpenAIResponse)
+			metrics := fiberContext.MetricsServiceFromContext(c)
+			recordUsage := usageRecorder(c, config)
 
+			finishReason := "stop"
 			if !shouldUseFn {
-				go process(predInput, input, config, ml, responses)
+				go process(predInput, input, config, ml, responses, &finishReason)
 			} else {
-				go processTools(noActionName, predInput, input, config, ml, responses)
+				go processTools(noActionName, predInput, input, config, ml, responses, &finishReason)
 			}
 
 			c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
 				usage := &schema.OpenAIUsage{}
 				toolsCalled := false
-				for ev := range responses {
+				streamResponses(w, responses, settings, input.Cancel, func(ev schema.OpenAIResponse) {
 					usage = &ev.Usage // Copy a pointer to the latest usage chunk so that the stop message can reference it
 					if len(ev.Choices[0].Delta.ToolCalls) > 0 {
 						toolsCalled = true
@@ -471,20 +485,26 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 					var buf bytes.Buffer
 					enc := json.NewEncoder(&buf)
 					enc.Encode(ev)
-					log.Debug().Msgf("Sending chunk: %s", buf.String())
+					logger.Debug().Msgf("Sending chunk: %s", buf.String())
 					_, err := fmt.Fprintf(w, "data: %v\n", buf.String())
 					if err != nil {
-						log.Debug().Msgf("Sending chunk failed: %v", err)
+						logger.Debug().Msgf("Sending chunk failed: %v", err)
 						input.Cancel()
 					}
-					w.Flush()
+				})
+				release()
+
+				if requestTimedOut(input) {
+					recordUsage(*usage)
+					writeStreamTimeout(w, metrics, config)
+					return
 				}
 
-				finishReason := "stop"
-				if toolsCalled {
-					finishReason = "tool_calls"
-				} else if toolsCalled && len(input.Tools) == 0 {
+				switch {
+				case toolsCalled && len(input.Tools) == 0:
 					finishReason = "function_call"
+				case toolsCalled:
+					finishReason = "tool_calls"
 				}
 
 				resp := &schema.OpenAIResponse{
@@ -501,6 +521,8 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 					Usage:  *usage,
 				}
 				respData, _ := json.Marshal(resp)
+				observeTokensPerSecond(metrics, config, *usage)
+				recordUsage(*usage)
 
 				w.WriteString(fmt.Sprintf("data: %s\n\n", respData))
 				w.WriteString("data: [DONE]\n\n")
@@ -510,80 +532,107 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 
 		// no streaming mode
 		default:
-			result, tokenUsage, err := ComputeChoices(input, predInput, config, startupOptions, ml, func(s string, c *[]schema.Choice) {
-				if !shouldUseFn {
-					// no function is called, just reply and use stop as finish reason
-					*c = append(*c, schema.Choice{FinishReason: "stop", Index: 0, Message: &schema.Message{Role: "assistant", Content: &s}})
-					return
-				}
+			cacheKey := responseCacheKey("chat", config, input, predInput)
+			if resp, hit := cachedResponse(c, config, cacheKey, id, created); hit {
+				return c.JSON(resp)
+			}
 
-				textContentToReturn = functions.ParseTextContent(s, config.FunctionsConfig)
-				s = functions.CleanupLLMResult(s, config.FunctionsConfig)
-				results := functions.ParseFunctionCall(s, config.FunctionsConfig)
-				log.Debug().Msgf("Text content to return: %s", textContentToReturn)
-				noActionsToRun := len(results) > 0 && results[0].Name == noActionName || len(results) == 0
+			release, err := scheduleRequest(c, config, input, startupOptions)
+			if err != nil {
+				return err
+			}
+			defer release()
 
-				switch {
-				case noActionsToRun:
-					result, err := handleQuestion(config, input, ml, startupOptions, results, s, predInput)
-					if err != nil {
-						log.Error().Err(err).Msg("error handling question")
+			computeChoices := func() ([]schema.Choice, backend.TokenUsage, error) {
+				return ComputeChoices(input, predInput, config, startupOptions, ml, func(s string, c *[]schema.Choice) {
+					if !shouldUseFn {
+						// no function is called, just reply and use stop as finish reason
+						*c = append(*c, schema.Choice{FinishReason: "stop", Index: 0, Message: &schema.Message{Role: "assistant", Content: &s}})
 						return
 					}
-					*c = append(*c, schema.Choice{
-						Message: &schema.Message{Role: "assistant", Content: &result}})
-				default:
-					toolChoice := schema.Choice{
-						Message: &schema.Message{
-							Role: "assistant",
-						},
-					}
 
-					if len(input.Tools) > 0 {
-						toolChoice.FinishReason = "tool_calls"
-					}
+					textContentToReturn = functions.ParseTextContent(s, config.FunctionsConfig)
+					s = functions.CleanupLLMResult(s, config.FunctionsConfig)
+					results := functions.ParseFunctionCall(s, config.FunctionsConfig)
+					logger.Debug().Msgf("Text content to return: %s", textContentToReturn)
+					noActionsToRun := len(results) > 0 && results[0].Name == noActionName || len(results) == 0
+
+					switch {
+					case noActionsToRun:
+						result, err := handleQuestion(config, input, ml, startupOptions, results, s, predInput)
+						if err != nil {
+							logger.Error().Err(err).Msg("error handling question")
+							return
+						}
+						*c = append(*c, schema.Choice{
+							FinishReason: "stop",
+							Message:      &schema.Message{Role: "assistant", Content: &result}})
+					default:
+						toolChoice := schema.Choice{
+							Message: &schema.Message{
+								Role: "assistant",
+							},
+						}
 
-					for _, ss := range results {
-						name, args := ss.Name, ss.Arguments
 						if len(input.Tools) > 0 {
-							// If we are using tools, we condense the function calls into
-							// a single response choice with all the tools
-							toolChoice.Message.Content = textContentToReturn
-							toolChoice.Message.ToolCalls = append(toolChoice.Message.ToolCalls,
-								schema.ToolCall{
-									ID:   id,
-									Type: "function",
-									FunctionCall: schema.FunctionCall{
-										Name:      name,
-										Arguments: args,
+							toolChoice.FinishReason = "tool_calls"
+						}
+
+						for _, ss := range results {
+							name, args := ss.Name, ss.Arguments
+							if len(input.Tools) > 0 {
+								// If we are using tools, we condense the function calls into
+								// a single response choice with all the tools
+								toolChoice.Message.Content = textContentToReturn
+								toolChoice.Message.ToolCalls = append(toolChoice.Message.ToolCalls,
+									schema.ToolCall{
+										ID:   id,
+										Type: "function",
+										FunctionCall: schema.FunctionCall{
+											Name:      name,
+											Arguments: args,
+										},
 									},
-								},
-							)
-						} else {
-							// otherwise we return more choices directly
-							*c = append(*c, schema.Choice{
-								FinishReason: "function_call",
-								Message: &schema.Message{
-									Role:    "assistant",
-									Content: &textContentToReturn,
-									FunctionCall: map[string]interface{}{
-										"name":      name,
-										"arguments": args,
+								)
+							} else {
+								// otherwise we return more choices directly
+								*c = append(*c, schema.Choice{
+									FinishReason: "function_call",
+									Message: &schema.Message{
+										Role:    "assistant",
+										Content: &textContentToReturn,
+										FunctionCall: map[string]interface{}{
+											"name":      name,
+											"arguments": args,
+										},
 									},
-								},
-							})
+								})
+							}
 						}
-					}
 
-					if len(input.Tools) > 0 {
-						// we need to append our result if we are using tools
-						*c = append(*c, toolChoice)
+						if len(input.Tools) > 0 {
+							// we need to append our result if we are using tools
+							*c = append(*c, toolChoice)
+						}
 					}
-				}
 
-			}, nil)
+				}, nil)
+			}
+
+			result, tokenUsage, err := computeChoices()
 			if err != nil {
-				return err
+				return timeoutError(c, config, input, err)
+			}
+
+			if responseSchema != nil && !shouldUseFn {
+				result, tokenUsage, err = ensureStructuredOutput(responseSchema, result, tokenUsage, computeChoices)
+				if err != nil {
+					return timeoutError(c, config, input, err)
+				}
+			}
+
+			for i := range result {
+				result[i].Index = i
 			}
 
 			resp := &schema.OpenAIResponse{
@@ -592,14 +641,13 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 				Model:   input.Model, // we have to return what the user sent here, due to OpenAI spec.
 				Choices: result,
 				Object:  "chat.completion",
-				Usage: schema.OpenAIUsage{
-					PromptTokens:     tokenUsage.Prompt,
-					CompletionTokens: tokenUsage.Completion,
-					TotalTokens:      tokenUsage.Prompt + tokenUsage.Completion,
-				},
+				Usage:   openAIUsage(tokenUsage),
 			}
 			respData, _ := json.Marshal(resp)
-			log.Debug().Msgf("Response: %s", respData)
+			logger.Debug().Msgf("Response: %s", respData)
+			observeTokensPerSecond(fiberContext.MetricsServiceFromContext(c), config, resp.Usage)
+			usageRecorder(c, config)(resp.Usage)
+			cacheResponse(c, cacheKey, resp)
 
 			// Return the prediction in the response body
 			return c.JSON(resp)
@@ -607,15 +655,59 @@ func ChatEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, startup
 	}
 }
 
+// ensureStructuredOutput validates the choices against the response_format schema.
+// If the output does not match, the choices are computed once more.
+func ensureStructuredOutput(responseSchema map[string]interface{}, result []schema.Choice, usage backend.TokenUsage, compute func() ([]schema.Choice, backend.TokenUsage, error)) ([]schema.Choice, backend.TokenUsage, error) {
+	err := validateChoices(responseSchema, result)
+	if err == nil {
+		return result, usage, nil
+	}
+	xlog.HTTP.Warn().Err(err).Msg("structured output does not match the schema, retrying")
+
+	retry, retryUsage, err := compute()
+	if err != nil {
+		return nil, usage, err
+	}
+	usage.Prompt += retryUsage.Prompt
+	usage.Completion += retryUsage.Completion
+	usage.TimingPromptProcessing += retryUsage.TimingPromptProcessing
+	usage.TimingTokenGeneration += retryUsage.TimingTokenGeneration
+
+	if err := validateChoices(responseSchema, retry); err != nil {
+		return nil, usage, fiber.NewError(fiber.StatusUnprocessableEntity, fmt.Sprintf("output does not match the response_format schema: %s", err))
+	}
+	return retry, usage, nil
+}
+
+func validateChoices(responseSchema map[string]interface{}, choices []schema.Choice) error {
+	for _, choice := range choices {
+		if choice.Message == nil {
+			continue
+		}
+		content := ""
+		switch c := choice.Message.Content.(type) {
+		case *string:
+			content = *c
+		case string:
+			content = c
+		}
+		if err := functions.ValidateJSONSchema(responseSchema, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func handleQuestion(config *config.BackendConfig, input *schema.OpenAIRequest, ml *model.ModelLoader, o *config.ApplicationConfig, funcResults []functions.FuncCallResults, result, prompt string) (string, error) {
+	logger := correlation.Logger(input.Context)
 
 	if len(funcResults) == 0 && result != "" {
-		log.Debug().Msgf("nothing function results but we had a message from the LLM")
+		logger.Debug().Msgf("nothing function results but we had a message from the LLM")
 
 		return result, nil
 	}
 
-	log.Debug().Msgf("nothing to do, computing a reply")
+	logger.Debug().Msgf("nothing to do, computing a reply")
 	arg := ""
 	if len(funcResults) > 0 {
 		arg = funcResults[0].Arguments
@@ -623,23 +715,23 @@ func handleQuestion(config *config.BackendConfig, input *schema.OpenAIRequest, m
 	// If there is a message that the LLM already sends as part of the JSON reply, use it
 	arguments := map[string]interface{}{}
 	if err := json.Unmarshal([]byte(arg), &arguments); err != nil {
-		log.Debug().Msg("handleQuestion: function result did not contain a valid JSON object")
+		logger.Debug().Msg("handleQuestion: function result did not contain a valid JSON object")
 	}
 	m, exists := arguments["message"]
 	if exists {
 		switch message := m.(type) {
 		case string:
 			if message != "" {
-				log.Debug().Msgf("Reply received from LLM: %s", message)
+				logger.Debug().Msgf("Reply received from LLM: %s", message)
 				message = backend.Finetune(*config, prompt, message)
-				log.Debug().Msgf("Reply received from LLM(finetuned): %s", message)
+				logger.Debug().Msgf("Reply received from LLM(finetuned): %s", message)
 
 				return message, nil
 			}
 		}
 	}
 
-	log.Debug().Msgf("No action received from LLM, without a message, computing a reply")
+	logger.Debug().Msgf("No action received from LLM, without a message, computing a reply")
 	// Otherwise ask the LLM to understand the JSON output and the context, and return a message
 	// Note: This costs (in term of CPU/GPU) another computation
 	config.Grammar = ""
@@ -658,14 +750,38 @@ func handleQuestion(config *config.BackendConfig, input *schema.OpenAIRequest, m
 
 	predFunc, err := backend.ModelInference(input.Context, prompt, input.Messages, images, videos, audios, ml, *config, o, nil)
 	if err != nil {
-		log.Error().Err(err).Msg("model inference failed")
+		logger.Error().Err(err).Msg("model inference failed")
 		return "", err
 	}
 
 	prediction, err := predFunc()
 	if err != nil {
-		log.Error().Err(err).Msg("prediction failed")
+		logger.Error().Err(err).Msg("prediction failed")
 		return "", err
 	}
 	return backend.Finetune(*config, prompt, prediction.Response), nil
 }
+
+// toolCallChunk returns the chunk of a stream carrying a fragment of a tool call
+func toolCallChunk(id string, created int, model string, d functions.ToolCallDelta) schema.OpenAIResponse {
+	delta := &schema.Message{ToolCalls: []schema.ToolCall{{
+		Index: d.Index,
+		FunctionCall: schema.FunctionCall{
+			Name:      d.Name,
+			Arguments: d.Arguments,
+		},
+	}}}
+	if d.Name != "" {
+		// the first fragment of a call
+		delta.Role = "assistant"
+		delta.ToolCalls[0].ID = id
+		delta.ToolCalls[0].Type = "function"
+	}
+	return schema.OpenAIResponse{
+		ID:      id,
+		Created: created,
+		Model:   model, // we have to return what the user sent here, due to OpenAI spec.
+		Choices: []schema.Choice{{Delta: delta}},
+		Object:  "chat.completion.chunk",
+	}
+}
@@ -13,10 +13,12 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/functions"
+	"github.com/mudler/LocalAI/pkg/grpc"
 	model "github.com/mudler/LocalAI/pkg/model"
-	"github.com/rs/zerolog/log"
 	"github.com/valyala/fasthttp"
 )
 
@@ -29,8 +31,12 @@ func CompletionEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 	id := uuid.New().String()
 	created := int(time.Now().Unix())
 
-	process := func(s string, req *schema.OpenAIRequest, config *config.BackendConfig, loader *model.ModelLoader, responses chan schema.OpenAIResponse) {
-		ComputeChoices(req, s, config, appConfig, loader, func(s string, c *[]schema.Choice) {}, func(s string, usage backend.TokenUsage) bool {
+	// process sets the finish reason of the stream before closing the responses
+	process := func(s string, req *schema.OpenAIRequest, config *config.BackendConfig, loader *model.ModelLoader, responses chan schema.OpenAIResponse, finishReason *string) {
+		logger := correlation.Logger(req.Context)
+		choices, _, _ := ComputeChoices(req, s, config, appConfig, loader, func(s string, c *[]schema.Choice) {
+			*c = append(*c, schema.Choice{FinishReason: "stop"})
+		}, func(s string, usage backend.TokenUsage) bool {
 			resp := schema.OpenAIResponse{
 				ID:      id,
 				Created: created,
@@ -48,29 +54,36 @@ func CompletionEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 					TotalTokens:      usage.Prompt + usage.Completion,
 				},
 			}
-			log.Debug().Msgf("Sending goroutine: %s", s)
+			logger.Debug().Msgf("Sending goroutine: %s", s)
 
 			responses <- resp
 			return true
 		})
+		if len(choices) > 0 {
+			*finishReason = choices[0].FinishReason
+		}
 		close(responses)
 	}
 
 	return func(c *fiber.Ctx) error {
-		// Add Correlation
-		c.Set("X-Correlation-ID", id)
+		logger := correlation.Logger(c.UserContext())
 		modelFile, input, err := readRequest(c, cl, ml, appConfig, true)
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
 		}
 
-		log.Debug().Msgf("`input`: %+v", input)
+		logger.Debug().Msgf("`input`: %+v", input)
 
 		config, input, err := mergeRequestWithConfig(modelFile, input, cl, ml, appConfig.Debug, appConfig.Threads, appConfig.ContextSize, appConfig.F16)
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
 		}
 
+		if err := validateChoicesCount(input, appConfig); err != nil {
+			return err
+		}
+		applyRequestTimeout(config, input)
+
 		if config.ResponseFormatMap != nil {
 			d := schema.ChatCompletionResponseFormat{}
 			dat, _ := json.Marshal(config.ResponseFormatMap)
@@ -82,10 +95,10 @@ func CompletionEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 
 		config.Grammar = input.Grammar
 
-		log.Debug().Msgf("Parameter Config: %+v", config)
+		logger.Debug().Msgf("Parameter Config: %+v", config)
 
 		if input.Stream {
-			log.Debug().Msgf("Stream request received")
+			logger.Debug().Msgf("Stream request received")
 			c.Context().SetContentType("text/event-stream")
 			//c.Response().Header.SetContentType(fiber.MIMETextHTMLCharsetUTF8)
 			//c.Set("Content-Type", "text/event-stream")
@@ -105,38 +118,84 @@ func CompletionEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 			templateFile = config.TemplateConfig.Completion
 		}
 
+		// the suffix can only reach the model through a fill-in-the-middle completion template
+		if input.Suffix != "" && templateFile == "" {
+			logger.Warn().Str("model", input.Model).Msg("the model has no completion template, ignoring the suffix")
+		}
+
 		if input.Stream {
 			if len(config.PromptStrings) > 1 {
 				return errors.New("cannot handle more than 1 `PromptStrings` when Streaming")
 			}
 
-			predInput := config.PromptStrings[0]
+			prompt := config.PromptStrings[0]
+			predInput := prompt
 
 			if templateFile != "" {
 				templatedInput, err := ml.EvaluateTemplateForPrompt(model.CompletionPromptTemplate, templateFile, model.PromptTemplateData{
 					Input:        predInput,
 					SystemPrompt: config.SystemPrompt,
+					Suffix:       input.Suffix,
 				})
 				if err == nil {
 					predInput = templatedInput
-					log.Debug().Msgf("Template found, input modified to: %s", predInput)
+					logger.Debug().Msgf("Template found, input modified to: %s", predInput)
 				}
 			}
+			predInput = injectPrompt(logger, config, predInput)
+
+			settings, err := streamSettingsFor(appConfig, input)
+			if err != nil {
+				return err
+			}
+
+			// fail before the stream starts when the model is known not to generate text
+			if err := backend.CheckCapability(ml, *config, grpc.CapabilityGenerate); err != nil {
+				return err
+			}
+
+			release, err := scheduleRequest(c, config, input, appConfig)
+			if err != nil {
+				return err
+			}
 
 			responses := make(chan schema.OpenAIResponse)
 
-			go process(predInput, input, config, ml, responses)
+			finishReason := "stop"
+			go process(predInput, input, config, ml, responses, &finishReason)
+			recordUsage := usageRecorder(c, config)
+			metrics := fiberContext.MetricsServiceFromContext(c)
 
 			c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+				// the echoed prompt is the first chunk, before the tokens of the completion
+				if input.Echo {
+					respData, _ := json.Marshal(schema.OpenAIResponse{
+						ID:      id,
+						Created: created,
+						Model:   input.Model,
+						Choices: []schema.Choice{{Index: 0, Text: prompt}},
+						Object:  "text_completion",
+					})
+					fmt.Fprintf(w, "data: %s\n\n", respData)
+					w.Flush()
+				}
 
-				for ev := range responses {
+				usage := schema.OpenAIUsage{}
+				streamResponses(w, responses, settings, input.Cancel, func(ev schema.OpenAIResponse) {
+					usage = ev.Usage
 					var buf bytes.Buffer
 					enc := json.NewEncoder(&buf)
 					enc.Encode(ev)
 
-					log.Debug().Msgf("Sending chunk: %s", buf.String())
+					logger.Debug().Msgf("Sending chunk: %s", buf.String())
 					fmt.Fprintf(w, "data: %v\n", buf.String())
-					w.Flush()
+				})
+				release()
+				recordUsage(usage)
+
+				if requestTimedOut(input) {
+					writeStreamTimeout(w, metrics, config)
+					return
 				}
 
 				resp := &schema.OpenAIResponse{
@@ -146,7 +205,7 @@ func CompletionEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 					Choices: []schema.Choice{
 						{
 							Index:        0,
-							FinishReason: "stop",
+							FinishReason: finishReason,
 						},
 					},
 					Object: "text_completion",
@@ -160,34 +219,58 @@ func CompletionEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 			return nil
 		}
 
+		cacheKey := responseCacheKey("completion", config, input, templateFile)
+		if resp, hit := cachedResponse(c, config, cacheKey, id, created); hit {
+			return c.JSON(resp)
+		}
+
+		release, err := scheduleRequest(c, config, input, appConfig)
+		if err != nil {
+			return err
+		}
+		defer release()
+
 		var result []schema.Choice
 
 		totalTokenUsage := backend.TokenUsage{}
 
-		for k, i := range config.PromptStrings {
+		for _, prompt := range config.PromptStrings {
+			i := prompt
 			if templateFile != "" {
 				// A model can have a "file.bin.tmpl" file associated with a prompt template prefix
 				templatedInput, err := ml.EvaluateTemplateForPrompt(model.CompletionPromptTemplate, templateFile, model.PromptTemplateData{
 					SystemPrompt: config.SystemPrompt,
 					Input:        i,
+					Suffix:       input.Suffix,
 				})
 				if err == nil {
 					i = templatedInput
-					log.Debug().Msgf("Template found, input modified to: %s", i)
+					logger.Debug().Msgf("Template found, input modified to: %s", i)
 				}
 			}
+			i = injectPrompt(logger, config, i)
 
 			r, tokenUsage, err := ComputeChoices(
 				input, i, config, appConfig, ml, func(s string, c *[]schema.Choice) {
-					*c = append(*c, schema.Choice{Text: s, FinishReason: "stop", Index: k})
+					// choices are numbered across all the prompts, n for each prompt
+					*c = append(*c, schema.Choice{Text: s, FinishReason: "stop", Index: len(result) + len(*c)})
 				}, nil)
 			if err != nil {
-				return err
+				return timeoutError(c, config, input, err)
 			}
 
 			totalTokenUsage.Prompt += tokenUsage.Prompt
 			totalTokenUsage.Completion += tokenUsage.Completion
 
+			for j := range r {
+				if l, ok := r[j].Logprobs.(*schema.ChatLogprobs); ok {
+					r[j].Logprobs = completionLogprobs(l)
+				}
+				if input.Echo {
+					echoPrompt(prompt, &r[j])
+				}
+			}
+
 			result = append(result, r...)
 		}
 
@@ -205,9 +288,22 @@ func CompletionEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 		}
 
 		jsonResult, _ := json.Marshal(resp)
-		log.Debug().Msgf("Response: %s", jsonResult)
+		logger.Debug().Msgf("Response: %s", jsonResult)
+		usageRecorder(c, config)(resp.Usage)
+		cacheResponse(c, cacheKey, resp)
 
 		// Return the prediction in the response body
 		return c.JSON(resp)
 	}
 }
+
+// echoPrompt prepends the prompt to the text of a completion choice, as requested by `echo`, shifting
+// the offsets of its logprobs accordingly
+func echoPrompt(prompt string, choice *schema.Choice) {
+	choice.Text = prompt + choice.Text
+	if l, ok := choice.Logprobs.(*schema.CompletionLogprobs); ok {
+		for i := range l.TextOffset {
+			l.TextOffset[i] += len(prompt)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package openai
+
+import (
+	"testing"
+
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEchoPrompt(t *testing.T) {
+	choice := schema.Choice{
+		Text: " world",
+		Logprobs: &schema.CompletionLogprobs{
+			Tokens:     []string{" world"},
+			TextOffset: []int{0},
+		},
+	}
+	echoPrompt("Hello", &choice)
+	assert.Equal(t, "Hello world", choice.Text)
+	assert.Equal(t, []int{5}, choice.Logprobs.(*schema.CompletionLogprobs).TextOffset)
+
+	plain := schema.Choice{Text: "!"}
+	echoPrompt("Hi", &plain)
+	assert.Equal(t, "Hi!", plain.Text)
+	assert.Nil(t, plain.Logprobs)
+}
@@ -11,9 +11,8 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	model "github.com/mudler/LocalAI/pkg/model"
-
-	"github.com/rs/zerolog/log"
 )
 
 // EditEndpoint is the OpenAI edit API endpoint
@@ -23,17 +22,23 @@ import (
 // @Router /v1/edits [post]
 func EditEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
 		modelFile, input, err := readRequest(c, cl, ml, appConfig, true)
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
 		}
 
+		if err := validateChoicesCount(input, appConfig); err != nil {
+			return err
+		}
+
 		config, input, err := mergeRequestWithConfig(modelFile, input, cl, ml, appConfig.Debug, appConfig.Threads, appConfig.ContextSize, appConfig.F16)
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
 		}
 
-		log.Debug().Msgf("Parameter Config: %+v", config)
+		logger.Debug().Msgf("Parameter Config: %+v", config)
+		applyRequestTimeout(config, input)
 
 		templateFile := ""
 
@@ -46,6 +51,12 @@ func EditEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConf
 			templateFile = config.TemplateConfig.Edit
 		}
 
+		release, err := scheduleRequest(c, config, input, appConfig)
+		if err != nil {
+			return err
+		}
+		defer release()
+
 		var result []schema.Choice
 		totalTokenUsage := backend.TokenUsage{}
 
@@ -58,20 +69,26 @@ func EditEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConf
 				})
 				if err == nil {
 					i = templatedInput
-					log.Debug().Msgf("Template found, input modified to: %s", i)
+					logger.Debug().Msgf("Template found, input modified to: %s", i)
 				}
 			}
 
 			r, tokenUsage, err := ComputeChoices(input, i, config, appConfig, ml, func(s string, c *[]schema.Choice) {
-				*c = append(*c, schema.Choice{Text: s})
+				*c = append(*c, schema.Choice{Text: s, FinishReason: "stop", Index: len(result) + len(*c)})
 			}, nil)
 			if err != nil {
-				return err
+				return timeoutError(c, config, input, err)
 			}
 
 			totalTokenUsage.Prompt += tokenUsage.Prompt
 			totalTokenUsage.Completion += tokenUsage.Completion
 
+			for j := range r {
+				if l, ok := r[j].Logprobs.(*schema.ChatLogprobs); ok {
+					r[j].Logprobs = completionLogprobs(l)
+				}
+			}
+
 			result = append(result, r...)
 		}
 
@@ -91,7 +108,8 @@ func EditEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConf
 		}
 
 		jsonResult, _ := json.Marshal(resp)
-		log.Debug().Msgf("Response: %s", jsonResult)
+		logger.Debug().Msgf("Response: %s", jsonResult)
+		usageRecorder(c, config)(resp.Usage)
 
 		// Return the prediction in the response body
 		return c.JSON(resp)
@@ -7,13 +7,13 @@ import (
 
 	"github.com/mudler/LocalAI/core/backend"
 	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/model"
 
 	"github.com/google/uuid"
 	"github.com/mudler/LocalAI/core/schema"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/rs/zerolog/log"
 )
 
 // EmbeddingsEndpoint is the OpenAI Embeddings API endpoint https://platform.openai.com/docs/api-reference/embeddings
@@ -23,6 +23,7 @@ import (
 // @Router /v1/embeddings [post]
 func EmbeddingsEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
 		model, input, err := readRequest(c, cl, ml, appConfig, true)
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
@@ -33,35 +34,43 @@ func EmbeddingsEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 			return fmt.Errorf("failed reading parameters from request:%w", err)
 		}
 
-		log.Debug().Msgf("Parameter Config: %+v", config)
+		logger.Debug().Msgf("Parameter Config: %+v", config)
 		items := []schema.Item{}
 
-		for i, s := range config.InputToken {
-			// get the model function to call for the result
-			embedFn, err := backend.ModelEmbedding("", s, ml, *config, appConfig)
-			if err != nil {
-				return err
-			}
+		if !backend.ValidTruncation(input.Truncate) {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid truncate %q, should be none, start or end", input.Truncate))
+		}
+		pooling, err := backend.EmbeddingPooling(*config, input.Pooling)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		config.Pooling = pooling
+		if err := backend.ValidEmbeddingDimensions(*config, input.Dimensions); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
 
-			embeddings, err := embedFn()
-			if err != nil {
-				return err
-			}
-			items = append(items, schema.Item{Embedding: embeddings, Index: i, Object: "embedding"})
+		release, err := scheduleRequest(c, config, input, appConfig)
+		if err != nil {
+			return err
 		}
+		defer release()
 
-		for i, s := range config.InputStrings {
-			// get the model function to call for the result
-			embedFn, err := backend.ModelEmbedding(s, []int{}, ml, *config, appConfig)
-			if err != nil {
-				return err
-			}
+		result, err := backend.ModelEmbeddingBatch(input.Context, config.InputStrings, config.InputToken, input.Truncate, ml, *config, appConfig)
+		if err != nil {
+			return err
+		}
+		embeddings, err := backend.ReduceEmbeddingDimensions(result.Embeddings, input.Dimensions)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
 
-			embeddings, err := embedFn()
-			if err != nil {
-				return err
+		// token inputs come first, and each kind of input is indexed separately
+		for i, e := range embeddings {
+			index := i
+			if i >= len(config.InputToken) {
+				index = i - len(config.InputToken)
 			}
-			items = append(items, schema.Item{Embedding: embeddings, Index: i, Object: "embedding"})
+			items = append(items, schema.Item{Embedding: e, Index: index, Object: "embedding", TruncatedTokens: result.Truncated[i], Dimensions: len(e)})
 		}
 
 		id := uuid.New().String()
@@ -72,10 +81,15 @@ func EmbeddingsEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 			Model:   input.Model, // we have to return what the user sent here, due to OpenAI spec.
 			Data:    items,
 			Object:  "list",
+			Usage: schema.OpenAIUsage{
+				PromptTokens: result.Tokens,
+				TotalTokens:  result.Tokens,
+			},
 		}
 
 		jsonResult, _ := json.Marshal(resp)
-		log.Debug().Msgf("Response: %s", jsonResult)
+		logger.Debug().Msgf("Response: %s", jsonResult)
+		usageRecorder(c, config)(resp.Usage)
 
 		// Return the prediction in the response body
 		return c.JSON(resp)
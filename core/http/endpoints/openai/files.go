@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -17,7 +19,8 @@ import (
 
 var UploadedFiles []schema.File
 
-const UploadedFilesFile = "uploadedFiles.json"
+// uploadedFilesMu guards the changes of UploadedFiles and of its persisted copy
+var uploadedFilesMu sync.Mutex
 
 // UploadFilesEndpoint https://platform.openai.com/docs/api-reference/files/create
 func UploadFilesEndpoint(cm *config.BackendConfigLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
@@ -61,8 +64,10 @@ func UploadFilesEndpoint(cm *config.BackendConfigLoader, appConfig *config.Appli
 			Purpose:   purpose,
 		}
 
+		uploadedFilesMu.Lock()
 		UploadedFiles = append(UploadedFiles, f)
-		utils.SaveConfig(appConfig.UploadDir, UploadedFilesFile, UploadedFiles)
+		utils.SaveConfig(appConfig.UploadDir, config.UploadedFilesFile, UploadedFiles)
+		uploadedFilesMu.Unlock()
 		return c.Status(fiber.StatusOK).JSON(f)
 	}
 }
@@ -155,6 +160,7 @@ func DeleteFilesEndpoint(cm *config.BackendConfigLoader, appConfig *config.Appli
 		}
 
 		// Remove upload from list
+		uploadedFilesMu.Lock()
 		for i, f := range UploadedFiles {
 			if f.ID == file.ID {
 				UploadedFiles = append(UploadedFiles[:i], UploadedFiles[i+1:]...)
@@ -162,7 +168,8 @@ func DeleteFilesEndpoint(cm *config.BackendConfigLoader, appConfig *config.Appli
 			}
 		}
 
-		utils.SaveConfig(appConfig.UploadDir, UploadedFilesFile, UploadedFiles)
+		utils.SaveConfig(appConfig.UploadDir, config.UploadedFilesFile, UploadedFiles)
+		uploadedFilesMu.Unlock()
 		return c.JSON(DeleteStatus{
 			Id:      file.ID,
 			Object:  "file",
@@ -191,3 +198,113 @@ func GetFilesContentsEndpoint(cm *config.BackendConfigLoader, appConfig *config.
 		return c.Send(fileContents)
 	}
 }
+
+// UploadedFilesEndpoint lists the uploaded files, oldest first, with the size of the store
+// @Summary List the uploaded files with the total size of the store.
+// @Success 200 {object} schema.UploadedFilesResponse "Response"
+// @Router /system/files [get]
+func UploadedFilesEndpoint() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		uploadedFilesMu.Lock()
+		files := sortedUploadedFiles()
+		uploadedFilesMu.Unlock()
+
+		resp := schema.UploadedFilesResponse{Files: files, Count: len(files)}
+		for _, f := range files {
+			resp.TotalBytes += int64(f.Bytes)
+		}
+		return c.JSON(resp)
+	}
+}
+
+// PurgeFilesEndpoint deletes the uploaded files older than an age, then the oldest ones until the store
+// fits in a total size, so that the upload directory of long-running instances does not grow unbounded
+// @Summary Delete the old uploaded files.
+// @Param request body schema.PurgeFilesRequest true "query params"
+// @Success 200 {object} schema.PurgeFilesResponse "Response"
+// @Router /system/files/purge [post]
+func PurgeFilesEndpoint(appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		input := new(schema.PurgeFilesRequest)
+		if err := c.BodyParser(input); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		var olderThan time.Duration
+		if input.OlderThan != "" {
+			d, err := time.ParseDuration(input.OlderThan)
+			if err != nil || d <= 0 {
+				return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("invalid older_than %q, expected a positive duration (e.g. 72h)", input.OlderThan))
+			}
+			olderThan = d
+		}
+		if input.MaxTotalBytes < 0 {
+			return c.Status(fiber.StatusBadRequest).SendString("max_total_bytes cannot be negative")
+		}
+		if olderThan == 0 && input.MaxTotalBytes == 0 {
+			return c.Status(fiber.StatusBadRequest).SendString("either older_than or max_total_bytes is required")
+		}
+
+		resp, err := purgeUploadedFiles(appConfig.UploadDir, olderThan, input.MaxTotalBytes, time.Now())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		return c.JSON(resp)
+	}
+}
+
+// sortedUploadedFiles returns a copy of the uploaded files, oldest first. It must be called with
+// uploadedFilesMu held.
+func sortedUploadedFiles() []schema.File {
+	files := append([]schema.File{}, UploadedFiles...)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].CreatedAt.Before(files[j].CreatedAt) })
+	return files
+}
+
+// purgeUploadedFiles deletes the files created more than olderThan before now, then the oldest files
+// until the total size is within maxTotalBytes. A zero olderThan or maxTotalBytes disables the criterion.
+func purgeUploadedFiles(uploadDir string, olderThan time.Duration, maxTotalBytes int64, now time.Time) (schema.PurgeFilesResponse, error) {
+	uploadedFilesMu.Lock()
+	defer uploadedFilesMu.Unlock()
+
+	files := sortedUploadedFiles()
+	var total int64
+	for _, f := range files {
+		total += int64(f.Bytes)
+	}
+
+	resp := schema.PurgeFilesResponse{Deleted: []schema.File{}}
+	deleted := map[string]bool{}
+	var removeErr error
+	for _, f := range files {
+		expired := olderThan > 0 && now.Sub(f.CreatedAt) > olderThan
+		overBudget := maxTotalBytes > 0 && total > maxTotalBytes
+		if !expired && !overBudget {
+			break
+		}
+		err := os.Remove(filepath.Join(uploadDir, utils.SanitizeFileName(f.Filename)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			removeErr = fmt.Errorf("unable to delete file: %s, %w", f.Filename, err)
+			break
+		}
+		deleted[f.ID] = true
+		resp.Deleted = append(resp.Deleted, f)
+		resp.FreedBytes += int64(f.Bytes)
+		total -= int64(f.Bytes)
+	}
+
+	if len(deleted) > 0 {
+		remaining := make([]schema.File, 0, len(UploadedFiles)-len(deleted))
+		for _, f := range UploadedFiles {
+			if !deleted[f.ID] {
+				remaining = append(remaining, f)
+			}
+		}
+		UploadedFiles = remaining
+		utils.SaveConfig(uploadDir, config.UploadedFilesFile, UploadedFiles)
+	}
+
+	resp.Remaining = len(UploadedFiles)
+	resp.TotalBytes = total
+	return resp, removeErr
+}
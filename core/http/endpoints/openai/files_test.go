@@ -10,11 +10,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-
-	"github.com/rs/zerolog/log"
+	"time"
 
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/xlog"
 
 	"github.com/gofiber/fiber/v2"
 	utils2 "github.com/mudler/LocalAI/pkg/utils"
@@ -167,6 +167,78 @@ func TestUploadFileExceedSizeLimit(t *testing.T) {
 	})
 }
 
+func TestPurgeFiles(t *testing.T) {
+	option := &config.ApplicationConfig{UploadDir: t.TempDir()}
+	app := fiber.New()
+	app.Get("/system/files", UploadedFilesEndpoint())
+	app.Post("/system/files/purge", PurgeFilesEndpoint(option))
+
+	previous := UploadedFiles
+	t.Cleanup(func() { UploadedFiles = previous })
+
+	now := time.Now()
+	UploadedFiles = []schema.File{
+		{ID: "file-new", Bytes: 30, CreatedAt: now.Add(-time.Minute), Filename: "new.txt"},
+		{ID: "file-old", Bytes: 10, CreatedAt: now.Add(-48 * time.Hour), Filename: "old.txt"},
+		{ID: "file-mid", Bytes: 20, CreatedAt: now.Add(-time.Hour), Filename: "mid.txt"},
+	}
+	for _, f := range UploadedFiles {
+		assert.NoError(t, os.WriteFile(filepath.Join(option.UploadDir, f.Filename), []byte("x"), 0600))
+	}
+
+	purge := func(body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/system/files/purge", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("lists the files oldest first", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/system/files", nil))
+		assert.NoError(t, err)
+		var files schema.UploadedFilesResponse
+		assert.NoError(t, json.Unmarshal(bodyToByteArray(resp, t), &files))
+		assert.Equal(t, 3, files.Count)
+		assert.Equal(t, int64(60), files.TotalBytes)
+		assert.Equal(t, "file-old", files.Files[0].ID)
+		assert.Equal(t, "file-new", files.Files[2].ID)
+	})
+	t.Run("rejects a request without criteria", func(t *testing.T) {
+		assert.Equal(t, fiber.StatusBadRequest, purge(`{}`).StatusCode)
+		assert.Equal(t, fiber.StatusBadRequest, purge(`{"older_than": "yesterday"}`).StatusCode)
+	})
+	t.Run("purges the files older than the age", func(t *testing.T) {
+		resp := purge(`{"older_than": "24h"}`)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		var result schema.PurgeFilesResponse
+		assert.NoError(t, json.Unmarshal(bodyToByteArray(resp, t), &result))
+		assert.Len(t, result.Deleted, 1)
+		assert.Equal(t, "file-old", result.Deleted[0].ID)
+		assert.Equal(t, int64(10), result.FreedBytes)
+		assert.Equal(t, 2, result.Remaining)
+		assert.NoFileExists(t, filepath.Join(option.UploadDir, "old.txt"))
+	})
+	t.Run("purges the oldest files over the size budget", func(t *testing.T) {
+		resp := purge(`{"max_total_bytes": 40}`)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		var result schema.PurgeFilesResponse
+		assert.NoError(t, json.Unmarshal(bodyToByteArray(resp, t), &result))
+		assert.Len(t, result.Deleted, 1)
+		assert.Equal(t, "file-mid", result.Deleted[0].ID)
+		assert.Equal(t, int64(30), result.TotalBytes)
+		assert.NoFileExists(t, filepath.Join(option.UploadDir, "mid.txt"))
+		assert.FileExists(t, filepath.Join(option.UploadDir, "new.txt"))
+
+		var persisted []schema.File
+		data, err := os.ReadFile(filepath.Join(option.UploadDir, config.UploadedFilesFile))
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(data, &persisted))
+		assert.Len(t, persisted, 1)
+		assert.Equal(t, "file-new", persisted[0].ID)
+	})
+}
+
 func CallListFilesEndpoint(t *testing.T, app *fiber.App, purpose string) (*http.Response, error) {
 	var target string
 	if purpose != "" {
@@ -294,7 +366,7 @@ func responseToListFile(t *testing.T, resp *http.Response) schema.ListFiles {
 
 	err := json.NewDecoder(strings.NewReader(responseToString)).Decode(&listFiles)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to decode response")
+		xlog.HTTP.Error().Err(err).Msg("failed to decode response")
 	}
 
 	return listFiles
@@ -4,7 +4,11 @@ import (
 	"bufio"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"net/http"
 	"os"
@@ -20,8 +24,9 @@ import (
 	"github.com/mudler/LocalAI/core/backend"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	model "github.com/mudler/LocalAI/pkg/model"
-	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
 )
 
 func downloadFile(url string) (string, error) {
@@ -44,6 +49,105 @@ func downloadFile(url string) (string, error) {
 	return out.Name(), err
 }
 
+// writeImageInput stores an image given as URL or base64 string into a
+// temporary file in dir, returning its path. The caller removes the file.
+func writeImageInput(file, dir string) (string, error) {
+	fileData := []byte{}
+	// check if file is an URL, if so download it and save it
+	// to a temporary file
+	if strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") {
+		out, err := downloadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed downloading file:%w", err)
+		}
+		defer os.RemoveAll(out)
+
+		fileData, err = os.ReadFile(out)
+		if err != nil {
+			return "", fmt.Errorf("failed reading file:%w", err)
+		}
+	} else {
+		// base 64 decode the file and write it somewhere
+		// that we will cleanup
+		var err error
+		fileData, err = base64.StdEncoding.DecodeString(file)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Create a temporary file
+	outputFile, err := os.CreateTemp(dir, "b64")
+	if err != nil {
+		return "", err
+	}
+	// write the base64 result
+	writer := bufio.NewWriter(outputFile)
+	_, err = writer.Write(fileData)
+	if err == nil {
+		err = writer.Flush()
+	}
+	outputFile.Close()
+	if err != nil {
+		os.RemoveAll(outputFile.Name())
+		return "", err
+	}
+	return outputFile.Name(), nil
+}
+
+// imageSize returns the dimensions of the image stored at path
+func imageSize(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// validateMask checks that the inpainting mask has the same dimensions as the init image
+func validateMask(src, mask string) error {
+	srcWidth, srcHeight, err := imageSize(src)
+	if err != nil {
+		return fmt.Errorf("failed decoding init image:%w", err)
+	}
+	maskWidth, maskHeight, err := imageSize(mask)
+	if err != nil {
+		return fmt.Errorf("failed decoding mask:%w", err)
+	}
+	if srcWidth != maskWidth || srcHeight != maskHeight {
+		return fmt.Errorf("mask size %dx%d does not match image size %dx%d", maskWidth, maskHeight, srcWidth, srcHeight)
+	}
+	return nil
+}
+
+// createImageOutput creates the file in dir an image is generated to
+func createImageOutput(dir string) (string, error) {
+	// Create a temporary file
+	outputFile, err := os.CreateTemp(dir, "b64")
+	if err != nil {
+		return "", err
+	}
+	outputFile.Close()
+	output := outputFile.Name() + ".png"
+	// Rename the temporary file
+	if err := os.Rename(outputFile.Name(), output); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+func removeFiles(files []string) {
+	for _, f := range files {
+		os.RemoveAll(f)
+	}
+}
+
 //
 
 /*
@@ -66,66 +170,68 @@ func downloadFile(url string) (string, error) {
 // @Router /v1/images/generations [post]
 func ImageEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
 		m, input, err := readRequest(c, cl, ml, appConfig, false)
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
 		}
 
+		if err := validateImageCount(input, appConfig); err != nil {
+			return err
+		}
+
 		if m == "" {
 			m = model.StableDiffusionBackend
+			if err := checkModelAccess(c, appConfig, m); err != nil {
+				return err
+			}
 		}
-		log.Debug().Msgf("Loading model: %+v", m)
+		logger.Debug().Msgf("Loading model: %+v", m)
 
 		config, input, err := mergeRequestWithConfig(m, input, cl, ml, appConfig.Debug, 0, 0, false)
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
 		}
 
+		// the inputs are removed once the images are generated, in the background when streaming
+		var inputs []string
+		removeInputs := true
+		defer func() {
+			if removeInputs {
+				removeFiles(inputs)
+			}
+		}()
+
 		src := ""
 		if input.File != "" {
-
-			fileData := []byte{}
-			// check if input.File is an URL, if so download it and save it
-			// to a temporary file
-			if strings.HasPrefix(input.File, "http://") || strings.HasPrefix(input.File, "https://") {
-				out, err := downloadFile(input.File)
-				if err != nil {
-					return fmt.Errorf("failed downloading file:%w", err)
-				}
-				defer os.RemoveAll(out)
-
-				fileData, err = os.ReadFile(out)
-				if err != nil {
-					return fmt.Errorf("failed reading file:%w", err)
-				}
-
-			} else {
-				// base 64 decode the file and write it somewhere
-				// that we will cleanup
-				fileData, err = base64.StdEncoding.DecodeString(input.File)
-				if err != nil {
-					return err
-				}
+			src, err = writeImageInput(input.File, appConfig.ImageDir)
+			if err != nil {
+				return err
 			}
+			inputs = append(inputs, src)
+		}
 
-			// Create a temporary file
-			outputFile, err := os.CreateTemp(appConfig.ImageDir, "b64")
+		mask := ""
+		if input.Mask != "" {
+			if src == "" {
+				return fmt.Errorf("a mask requires an init image in 'file'")
+			}
+			mask, err = writeImageInput(input.Mask, appConfig.ImageDir)
 			if err != nil {
 				return err
 			}
-			// write the base64 result
-			writer := bufio.NewWriter(outputFile)
-			_, err = writer.Write(fileData)
-			if err != nil {
-				outputFile.Close()
+			inputs = append(inputs, mask)
+
+			if err := validateMask(src, mask); err != nil {
 				return err
 			}
-			outputFile.Close()
-			src = outputFile.Name()
-			defer os.RemoveAll(src)
 		}
 
-		log.Debug().Msgf("Parameter Config: %+v", config)
+		if input.Strength < 0 || input.Strength > 1 {
+			return fmt.Errorf("invalid value for 'strength': must be between 0 and 1")
+		}
+
+		logger.Debug().Msgf("Parameter Config: %+v", config)
 
 		switch config.Backend {
 		case "stablediffusion":
@@ -151,14 +257,36 @@ func ImageEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appCon
 
 		b64JSON := config.ResponseFormat == "b64_json"
 
-		// src and clip_skip
-		var result []schema.Item
-		for _, i := range config.PromptStrings {
-			n := input.N
-			if input.N == 0 {
-				n = 1
-			}
-			for j := 0; j < n; j++ {
+		mode := 0
+		step := config.Step
+		if step == 0 {
+			step = 15
+		}
+
+		if input.Mode != 0 {
+			mode = input.Mode
+		}
+
+		if input.Step != 0 {
+			step = input.Step
+		}
+
+		n := input.N
+		if input.N == 0 {
+			n = 1
+		}
+
+		tempDir := ""
+		if !b64JSON {
+			tempDir = appConfig.ImageDir
+		}
+		baseURL := c.BaseURL()
+
+		// generate returns the n images of each prompt, generated in a batch by the backends supporting it
+		generate := func(progress func(backend.ImageProgress)) ([]schema.Item, error) {
+			var result []schema.Item
+			var generated []string
+			for _, i := range config.PromptStrings {
 				prompts := strings.Split(i, "|")
 				positive_prompt := prompts[0]
 				negative_prompt := ""
@@ -166,63 +294,82 @@ func ImageEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appCon
 					negative_prompt = prompts[1]
 				}
 
-				mode := 0
-				step := config.Step
-				if step == 0 {
-					step = 15
-				}
-
-				if input.Mode != 0 {
-					mode = input.Mode
+				outputs := make([]string, n)
+				for j := range outputs {
+					output, err := createImageOutput(tempDir)
+					if err != nil {
+						return nil, err
+					}
+					if b64JSON {
+						defer os.RemoveAll(output)
+					}
+					outputs[j] = output
 				}
-
-				if input.Step != 0 {
-					step = input.Step
+				generated = append(generated, outputs...)
+
+				// the images of the progress are numbered across the prompts
+				var promptProgress func(backend.ImageProgress)
+				if progress != nil {
+					first := len(result)
+					promptProgress = func(p backend.ImageProgress) {
+						p.Image += first
+						progress(p)
+					}
 				}
 
-				tempDir := ""
-				if !b64JSON {
-					tempDir = appConfig.ImageDir
-				}
-				// Create a temporary file
-				outputFile, err := os.CreateTemp(tempDir, "b64")
+				fn, err := backend.ImageGeneration(input.Context, height, width, mode, step, input.Strength, positive_prompt, negative_prompt, src, mask, outputs, ml, *config, appConfig, promptProgress)
 				if err != nil {
-					return err
+					return nil, err
 				}
-				outputFile.Close()
-				output := outputFile.Name() + ".png"
-				// Rename the temporary file
-				err = os.Rename(outputFile.Name(), output)
-				if err != nil {
-					return err
+				if err := fn(); err != nil {
+					return nil, err
 				}
 
-				baseURL := c.BaseURL()
-
-				fn, err := backend.ImageGeneration(height, width, mode, step, *config.Seed, positive_prompt, negative_prompt, src, output, ml, *config, appConfig)
+				flagged, err := checkImageSafety(input.Context, outputs, cl, ml, appConfig)
 				if err != nil {
-					return err
+					return nil, err
 				}
-				if err := fn(); err != nil {
-					return err
+				if err := applyImageSafety(outputs, flagged, appConfig); err != nil {
+					// none of the images of a blocked request is served
+					removeFiles(generated)
+					return nil, err
 				}
 
-				item := &schema.Item{}
-
-				if b64JSON {
-					defer os.RemoveAll(output)
-					data, err := os.ReadFile(output)
-					if err != nil {
-						return err
+				for j, output := range outputs {
+					if flagged[j] {
+						logger.Warn().Msgf("Generated image %d flagged by the image safety model", len(result))
+					}
+					item := &schema.Item{Flagged: flagged[j]}
+
+					if b64JSON {
+						data, err := os.ReadFile(output)
+						if err != nil {
+							return nil, err
+						}
+						item.B64JSON = base64.StdEncoding.EncodeToString(data)
+					} else {
+						base := filepath.Base(output)
+						item.URL = baseURL + "/generated-images/" + base
 					}
-					item.B64JSON = base64.StdEncoding.EncodeToString(data)
-				} else {
-					base := filepath.Base(output)
-					item.URL = baseURL + "/generated-images/" + base
-				}
 
-				result = append(result, *item)
+					result = append(result, *item)
+				}
 			}
+			return result, nil
+		}
+
+		if input.Stream {
+			removeInputs = false
+			streamImages(c, input, inputs, generate)
+			return nil
+		}
+
+		result, err := generate(nil)
+		if errors.Is(err, errImageBlocked) {
+			return imageBlockedResponse(c, appConfig)
+		}
+		if err != nil {
+			return err
 		}
 
 		id := uuid.New().String()
@@ -234,9 +381,80 @@ func ImageEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appCon
 		}
 
 		jsonResult, _ := json.Marshal(resp)
-		log.Debug().Msgf("Response: %s", jsonResult)
+		logger.Debug().Msgf("Response: %s", jsonResult)
 
 		// Return the prediction in the response body
 		return c.JSON(resp)
 	}
 }
+
+// streamImages sends the diffusion steps as server-sent events while the images are generated,
+// followed by a final event with the images. It removes the inputs when the generation is over.
+func streamImages(c *fiber.Ctx, input *schema.OpenAIRequest, inputs []string, generate func(func(backend.ImageProgress)) ([]schema.Item, error)) {
+	logger := correlation.Logger(input.Context)
+	progress := make(chan backend.ImageProgress)
+	var result []schema.Item
+	var generationErr error
+
+	go func() {
+		defer removeFiles(inputs)
+		defer close(progress)
+		result, generationErr = generate(func(p backend.ImageProgress) {
+			progress <- p
+		})
+	}()
+
+	c.Context().SetContentType("text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("Transfer-Encoding", "chunked")
+
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		disconnected := false
+		for p := range progress {
+			// keep draining the steps if the client went away, until the backend stops
+			if disconnected {
+				continue
+			}
+			if err := writeImageEvent(w, schema.ImageStreamEvent{
+				Type:   "image_generation.progress",
+				Image:  p.Image,
+				Images: p.Images,
+				Step:   p.Step,
+				Steps:  p.Steps,
+			}); err != nil {
+				logger.Debug().Msgf("Sending image generation progress failed: %v", err)
+				disconnected = true
+				input.Cancel()
+			}
+		}
+
+		if disconnected {
+			return
+		}
+
+		if generationErr != nil {
+			writeImageEvent(w, schema.ImageStreamEvent{
+				Type:  "error",
+				Error: generationErr.Error(),
+			})
+			return
+		}
+
+		writeImageEvent(w, schema.ImageStreamEvent{
+			Type: "image_generation.completed",
+			Data: result,
+		})
+	}))
+}
+
+func writeImageEvent(w *bufio.Writer, event schema.ImageStreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
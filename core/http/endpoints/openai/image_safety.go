@@ -0,0 +1,114 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/model"
+)
+
+// defaultImageSafetyCategories flag the images labeled nsfw, when the config of the image safety model
+// has no moderation categories
+var defaultImageSafetyCategories = map[string][]string{"sexual": {"nsfw"}}
+
+// errImageBlocked is returned when a generated image is flagged by the image safety model in block mode
+var errImageBlocked = errors.New("a generated image was flagged by the safety classifier")
+
+// checkImageSafety classifies the generated images with the image safety model of the application config,
+// returning whether each is flagged. Nothing is flagged when no model is configured.
+func checkImageSafety(ctx context.Context, images []string, cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) ([]bool, error) {
+	flagged := make([]bool, len(images))
+	if appConfig.ImageSafetyModel == "" || len(images) == 0 {
+		return flagged, nil
+	}
+
+	cfg, err := cl.LoadBackendConfigFileByName(appConfig.ImageSafetyModel, appConfig.ModelPath,
+		config.LoadOptionDebug(appConfig.Debug),
+		config.LoadOptionThreads(appConfig.Threads),
+		config.LoadOptionContextSize(appConfig.ContextSize),
+		config.LoadOptionF16(appConfig.F16),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := backend.ClassifyImages(ctx, images, ml, appConfig, *cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed checking the safety of the generated images:%w", err)
+	}
+
+	moderation := cfg.Moderation
+	if len(moderation.Categories) == 0 {
+		moderation.Categories = defaultImageSafetyCategories
+	}
+	for i, p := range res.GetPredictions() {
+		flagged[i] = moderationResult(moderation, p).Flagged
+	}
+	return flagged, nil
+}
+
+// applyImageSafety handles the flagged images according to the action of the application config: it
+// returns errImageBlocked in block mode, and replaces them with the placeholder in placeholder mode
+func applyImageSafety(images []string, flagged []bool, appConfig *config.ApplicationConfig) error {
+	for i, f := range flagged {
+		if !f {
+			continue
+		}
+		switch appConfig.ImageSafetyAction {
+		case config.ImageSafetyFlag:
+		case config.ImageSafetyPlaceholder:
+			if err := writePlaceholder(images[i], appConfig.ImageSafetyPlaceholder); err != nil {
+				return err
+			}
+		default:
+			return errImageBlocked
+		}
+	}
+	return nil
+}
+
+// writePlaceholder replaces the image at path with the placeholder file, or with a black image of the
+// same size when there is none
+func writePlaceholder(path, placeholder string) error {
+	if placeholder != "" {
+		data, err := os.ReadFile(placeholder)
+		if err != nil {
+			return fmt.Errorf("failed reading the image safety placeholder:%w", err)
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+
+	width, height, err := imageSize(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, image.NewGray(image.Rect(0, 0, width, height)))
+}
+
+// imageBlockedResponse fails the request whose images were blocked with a structured error, as OpenAI
+// does for the prompts violating its content policy
+func imageBlockedResponse(c *fiber.Ctx, appConfig *config.ApplicationConfig) error {
+	if appConfig.OpaqueErrors {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(schema.ErrorResponse{
+		Error: &schema.APIError{
+			Code:    "content_policy_violation",
+			Message: errImageBlocked.Error(),
+			Type:    "image_generation_user_error",
+		},
+	})
+}
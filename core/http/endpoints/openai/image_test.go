@@ -0,0 +1,241 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/grpc/base"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func pngBase64(t *testing.T, width, height int) string {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestValidateMask(t *testing.T) {
+	dir := t.TempDir()
+
+	src, err := writeImageInput(pngBase64(t, 64, 32), dir)
+	assert.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	t.Run("MatchingSize", func(t *testing.T) {
+		mask, err := writeImageInput(pngBase64(t, 64, 32), dir)
+		assert.NoError(t, err)
+		defer os.RemoveAll(mask)
+
+		assert.NoError(t, validateMask(src, mask))
+	})
+
+	t.Run("MismatchedSize", func(t *testing.T) {
+		mask, err := writeImageInput(pngBase64(t, 32, 32), dir)
+		assert.NoError(t, err)
+		defer os.RemoveAll(mask)
+
+		err = validateMask(src, mask)
+		assert.EqualError(t, err, "mask size 32x32 does not match image size 64x32")
+	})
+
+	t.Run("NotAnImage", func(t *testing.T) {
+		mask, err := writeImageInput(base64.StdEncoding.EncodeToString([]byte("not an image")), dir)
+		assert.NoError(t, err)
+		defer os.RemoveAll(mask)
+
+		assert.Error(t, validateMask(src, mask))
+	})
+}
+
+func TestValidateImageCount(t *testing.T) {
+	appConfig := config.NewApplicationConfig(config.WithMaxGeneratedImages(4))
+
+	request := func(n int) *schema.OpenAIRequest {
+		return &schema.OpenAIRequest{PredictionOptions: schema.PredictionOptions{N: n}}
+	}
+	assert.NoError(t, validateImageCount(request(0), appConfig))
+	assert.NoError(t, validateImageCount(request(4), appConfig))
+	assert.EqualError(t, validateImageCount(request(5), appConfig), "n must be at most 4")
+	assert.EqualError(t, validateImageCount(request(-1), appConfig), "n must be a positive number")
+}
+
+// diffusionLLM writes an empty image to the destinations of the requests, in a batch and with the
+// progress of its steps when batch is set
+type diffusionLLM struct {
+	base.SingleThread
+	batch bool
+	calls int
+}
+
+func (llm *diffusionLLM) Load(opts *pb.ModelOptions) error {
+	return nil
+}
+
+func (llm *diffusionLLM) Capabilities() ([]string, error) {
+	if llm.batch {
+		return []string{grpc.CapabilityImage, grpc.CapabilityImageBatch, grpc.CapabilityImageProgress}, nil
+	}
+	return []string{grpc.CapabilityImage}, nil
+}
+
+func (llm *diffusionLLM) GenerateImage(opts *pb.GenerateImageRequest) error {
+	llm.calls++
+	dsts := opts.Dsts
+	if len(dsts) == 0 {
+		dsts = []string{opts.Dst}
+	}
+	for _, dst := range dsts {
+		if err := os.WriteFile(dst, []byte("png"), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (llm *diffusionLLM) GenerateImageStream(opts *pb.GenerateImageRequest, progress chan *pb.ImageProgress) error {
+	defer close(progress)
+	for step := 1; step <= int(opts.Step); step++ {
+		progress <- &pb.ImageProgress{Step: int32(step), Steps: opts.Step, Images: int32(max(len(opts.Dsts), 1))}
+	}
+	return llm.GenerateImage(opts)
+}
+
+func TestImageGenerationBatch(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		batch    bool
+		calls    int
+		progress []backend.ImageProgress
+	}{
+		{
+			name:  "Batch",
+			batch: true,
+			calls: 1,
+			progress: []backend.ImageProgress{
+				{Image: 0, Images: 3, Step: 1, Steps: 2},
+				{Image: 0, Images: 3, Step: 2, Steps: 2},
+			},
+		},
+		{
+			name:  "OneAtATime",
+			calls: 3,
+			progress: []backend.ImageProgress{
+				{Image: 0, Images: 1, Step: 2, Steps: 2},
+				{Image: 1, Images: 1, Step: 2, Steps: 2},
+				{Image: 2, Images: 1, Step: 2, Steps: 2},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			llm := &diffusionLLM{batch: tc.batch}
+			grpc.Provide("image-batch-test-"+tc.name, llm)
+			modelPath := t.TempDir()
+			appConfig := config.NewApplicationConfig(
+				config.WithContext(context.Background()),
+				config.WithExternalBackend("diffusion", "image-batch-test-"+tc.name),
+			)
+			ml := model.NewModelLoader(modelPath)
+			cfg := config.BackendConfig{Name: "diffusion", Backend: "diffusion"}
+			cfg.Model = "diffusion.model"
+			cfg.SetDefaults()
+
+			dsts := []string{}
+			for _, name := range []string{"a.png", "b.png", "c.png"} {
+				dsts = append(dsts, filepath.Join(modelPath, name))
+			}
+			var progress []backend.ImageProgress
+			fn, err := backend.ImageGeneration(context.Background(), 16, 16, 0, 2, 0, "a cat", "", "", "", dsts, ml, cfg, appConfig, func(p backend.ImageProgress) {
+				progress = append(progress, p)
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, fn())
+
+			assert.Equal(t, tc.calls, llm.calls)
+			assert.Equal(t, tc.progress, progress)
+			for _, dst := range dsts {
+				assert.FileExists(t, dst)
+			}
+		})
+	}
+}
+
+func TestApplyImageSafety(t *testing.T) {
+	dir := t.TempDir()
+	images := func() []string {
+		var paths []string
+		for i := 0; i < 2; i++ {
+			path, err := writeImageInput(pngBase64(t, 64, 32), dir)
+			assert.NoError(t, err)
+			paths = append(paths, path)
+		}
+		return paths
+	}
+	flagged := []bool{false, true}
+
+	t.Run("Block", func(t *testing.T) {
+		appConfig := config.NewApplicationConfig(config.WithImageSafety("nsfw-classifier", config.ImageSafetyBlock))
+		assert.ErrorIs(t, applyImageSafety(images(), flagged, appConfig), errImageBlocked)
+		assert.NoError(t, applyImageSafety(images(), []bool{false, false}, appConfig))
+	})
+
+	t.Run("Flag", func(t *testing.T) {
+		appConfig := config.NewApplicationConfig(config.WithImageSafety("nsfw-classifier", config.ImageSafetyFlag))
+		paths := images()
+		before, err := os.ReadFile(paths[1])
+		assert.NoError(t, err)
+
+		assert.NoError(t, applyImageSafety(paths, flagged, appConfig))
+		after, err := os.ReadFile(paths[1])
+		assert.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("Placeholder", func(t *testing.T) {
+		appConfig := config.NewApplicationConfig(config.WithImageSafety("nsfw-classifier", config.ImageSafetyPlaceholder))
+		paths := images()
+		original, err := os.ReadFile(paths[0])
+		assert.NoError(t, err)
+
+		placeholder := filepath.Join(dir, "placeholder.png")
+		assert.NoError(t, os.WriteFile(placeholder, []byte("placeholder"), 0600))
+		appConfig.ImageSafetyPlaceholder = placeholder
+
+		assert.NoError(t, applyImageSafety(paths, flagged, appConfig))
+		data, err := os.ReadFile(paths[1])
+		assert.NoError(t, err)
+		assert.Equal(t, "placeholder", string(data))
+		data, err = os.ReadFile(paths[0])
+		assert.NoError(t, err)
+		assert.Equal(t, original, data)
+	})
+
+	t.Run("BlackPlaceholder", func(t *testing.T) {
+		appConfig := config.NewApplicationConfig(config.WithImageSafety("nsfw-classifier", config.ImageSafetyPlaceholder))
+		paths := images()
+
+		assert.NoError(t, applyImageSafety(paths, flagged, appConfig))
+		width, height, err := imageSize(paths[1])
+		assert.NoError(t, err)
+		assert.Equal(t, []int{64, 32}, []int{width, height})
+	})
+}
+
+func TestCheckImageSafetyDisabled(t *testing.T) {
+	appConfig := config.NewApplicationConfig()
+	flagged, err := checkImageSafety(context.Background(), []string{"a.png", "b.png"}, nil, nil, appConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false, false}, flagged)
+}
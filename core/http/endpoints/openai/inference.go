@@ -1,10 +1,14 @@
 package openai
 
 import (
+	"github.com/dave-gray101/v2keyauth"
+	"github.com/gofiber/fiber/v2"
 	"github.com/mudler/LocalAI/core/backend"
 	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
 	model "github.com/mudler/LocalAI/pkg/model"
 )
 
@@ -50,14 +54,75 @@ func ComputeChoices(
 			return result, backend.TokenUsage{}, err
 		}
 
-		tokenUsage.Prompt += prediction.Usage.Prompt
+		// the prompt is the same for all the choices, count it once as OpenAI does
+		tokenUsage.Prompt = prediction.Usage.Prompt
+		tokenUsage.PromptCached = prediction.Usage.PromptCached
 		tokenUsage.Completion += prediction.Usage.Completion
+		tokenUsage.TimingPromptProcessing += prediction.Usage.TimingPromptProcessing
+		tokenUsage.TimingTokenGeneration += prediction.Usage.TimingTokenGeneration
 
 		finetunedResponse := backend.Finetune(*config, predInput, prediction.Response)
+		choices := len(result)
 		cb(finetunedResponse, &result)
 
-		//result = append(result, Choice{Text: prediction})
+		// the callbacks set the tool calls, a text completion stopped at the token limit is cut
+		for j := choices; j < len(result); j++ {
+			if result[j].FinishReason == backend.FinishReasonStop {
+				result[j].FinishReason = prediction.FinishReason
+			}
+		}
 
+		if config.Logprobs.Enabled {
+			for j := choices; j < len(result); j++ {
+				result[j].Logprobs = chatLogprobs(prediction.Logprobs, config.TopLogprobsCount())
+			}
+		}
 	}
 	return result, tokenUsage, err
 }
+
+// openAIUsage converts the token usage of a prediction to the usage object returned to the client,
+// adding the timings in the LocalAI extension when they are known
+func openAIUsage(u backend.TokenUsage) schema.OpenAIUsage {
+	usage := schema.OpenAIUsage{
+		PromptTokens:     u.Prompt,
+		CompletionTokens: u.Completion,
+		TotalTokens:      u.Prompt + u.Completion,
+	}
+	if u.PromptCached > 0 {
+		usage.PromptTokensDetails = &schema.PromptTokensDetails{CachedTokens: u.PromptCached}
+	}
+	if u.TimingPromptProcessing > 0 || u.TimingTokenGeneration > 0 {
+		usage.Timings = &schema.UsageTimings{
+			PromptProcessingMs: u.TimingPromptProcessing,
+			TokenGenerationMs:  u.TimingTokenGeneration,
+			TokensPerSecond:    u.TokensPerSecond(),
+		}
+	}
+	return usage
+}
+
+// observeTokensPerSecond records the decoding rate of a completion, if known
+func observeTokensPerSecond(metrics *services.LocalAIMetricsService, config *config.BackendConfig, usage schema.OpenAIUsage) {
+	if metrics == nil || usage.Timings == nil || usage.Timings.TokensPerSecond == 0 {
+		return
+	}
+	metrics.ObserveTokensPerSecond(config.Name, config.Backend, usage.Timings.TokensPerSecond)
+}
+
+// usageRecorder returns a function accounting the usage of a request to its API key, and to its quota.
+// The tracker and the key are read upfront, as the function is called after the response is streamed.
+func usageRecorder(c *fiber.Ctx, config *config.BackendConfig) func(schema.OpenAIUsage) {
+	tracker := fiberContext.UsageTrackerFromContext(c)
+	quotas := fiberContext.QuotaEnforcerFromContext(c)
+	reservation := fiberContext.QuotaReservationFromContext(c)
+	apiKey := v2keyauth.TokenFromContext(c)
+	return func(usage schema.OpenAIUsage) {
+		if tracker != nil {
+			tracker.Record(apiKey, config.Name, usage.PromptTokens, usage.CompletionTokens)
+		}
+		if quotas != nil {
+			quotas.Consume(reservation, usage.TotalTokens)
+		}
+	}
+}
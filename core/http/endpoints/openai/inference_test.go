@@ -0,0 +1,112 @@
+package openai
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/grpc/base"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAIUsage(t *testing.T) {
+	usage := openAIUsage(backend.TokenUsage{Prompt: 100, Completion: 10})
+	assert.Equal(t, 110, usage.TotalTokens)
+	assert.Nil(t, usage.PromptTokensDetails)
+	assert.Nil(t, usage.Timings)
+
+	usage = openAIUsage(backend.TokenUsage{Prompt: 100, PromptCached: 80, Completion: 10, TimingTokenGeneration: 1000})
+	assert.Equal(t, &schema.PromptTokensDetails{CachedTokens: 80}, usage.PromptTokensDetails)
+	assert.Equal(t, 10.0, usage.Timings.TokensPerSecond)
+}
+
+// wordsLLM generates the words of its output one token at a time, up to the token limit of the request
+type wordsLLM struct {
+	base.SingleThread
+	words []string
+}
+
+func (llm *wordsLLM) Load(opts *pb.ModelOptions) error {
+	return nil
+}
+
+func (llm *wordsLLM) generate(opts *pb.PredictOptions) []string {
+	if opts.Tokens > 0 && int(opts.Tokens) < len(llm.words) {
+		return llm.words[:opts.Tokens]
+	}
+	return llm.words
+}
+
+func (llm *wordsLLM) Predict(opts *pb.PredictOptions) (string, error) {
+	return strings.Join(llm.generate(opts), ""), nil
+}
+
+func (llm *wordsLLM) PredictStream(opts *pb.PredictOptions, results chan string) error {
+	for _, w := range llm.generate(opts) {
+		results <- w
+	}
+	close(results)
+	return nil
+}
+
+func TestComputeChoicesFinishReason(t *testing.T) {
+	grpc.Provide("finish-reason-test", &wordsLLM{words: []string{"The", " sky", " is", " blue", "."}})
+	modelPath := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(modelPath, "words.yaml"), []byte(`name: words
+backend: words
+parameters:
+  model: words.model
+`), 0600))
+	bcl := config.NewBackendConfigLoader(modelPath)
+	assert.NoError(t, bcl.LoadBackendConfigsFromPath(modelPath))
+	appConfig := config.NewApplicationConfig(
+		config.WithContext(context.Background()),
+		config.WithExternalBackend("words", "finish-reason-test"),
+	)
+	ml := model.NewModelLoader(modelPath)
+
+	text := func(s string, c *[]schema.Choice) {
+		*c = append(*c, schema.Choice{Text: s, FinishReason: "stop"})
+	}
+	compute := func(maxTokens int, stream bool, cb func(string, *[]schema.Choice)) schema.Choice {
+		cfg, _ := bcl.GetBackendConfig("words")
+		cfg.Maxtokens = &maxTokens
+		var tokenCallback func(string, backend.TokenUsage) bool
+		if stream {
+			tokenCallback = func(string, backend.TokenUsage) bool { return true }
+		}
+		choices, _, err := ComputeChoices(&schema.OpenAIRequest{Context: context.Background()}, "prompt", &cfg, appConfig, ml, cb, tokenCallback)
+		assert.NoError(t, err)
+		assert.Len(t, choices, 1)
+		return choices[0]
+	}
+
+	// the stream reaching max_tokens is cut
+	assert.Equal(t, "length", compute(3, true, text).FinishReason)
+	// the stream ending before max_tokens, or without a limit, stopped
+	assert.Equal(t, "stop", compute(10, true, text).FinishReason)
+	assert.Equal(t, "stop", compute(0, true, text).FinishReason)
+	// the Go backends do not report the tokens of the predictions, which are assumed to stop
+	assert.Equal(t, "stop", compute(3, false, text).FinishReason)
+	// the tool calls are kept, even at the limit
+	toolCalls := func(s string, c *[]schema.Choice) {
+		*c = append(*c, schema.Choice{FinishReason: "tool_calls"})
+	}
+	assert.Equal(t, "tool_calls", compute(3, true, toolCalls).FinishReason)
+
+	// the duration of a prediction includes the prompt processing: without the timings of the backend,
+	// no decoding rate is reported
+	cfg, _ := bcl.GetBackendConfig("words")
+	_, usage, err := ComputeChoices(&schema.OpenAIRequest{Context: context.Background()}, "prompt", &cfg, appConfig, ml, text, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, usage.TimingTokenGeneration)
+	assert.Nil(t, openAIUsage(usage).Timings)
+}
@@ -0,0 +1,39 @@
+package openai
+
+import (
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/rs/zerolog"
+)
+
+// injectSystemMessage adds the forced system message of the model to the chat messages. It is prepended to
+// the first system message of the request, or added as the first message, so that the chat templates and the
+// tokenizer templates format it as any system message.
+func injectSystemMessage(logger *zerolog.Logger, cfg *config.BackendConfig, input *schema.OpenAIRequest) {
+	forced := cfg.TemplateConfig.ForcedSystemMessage
+	if forced == "" {
+		return
+	}
+
+	if len(input.Messages) > 0 && input.Messages[0].Role == "system" {
+		content := forced
+		if input.Messages[0].StringContent != "" {
+			content += "\n\n" + input.Messages[0].StringContent
+		}
+		input.Messages[0].Content = content
+		input.Messages[0].StringContent = content
+	} else {
+		input.Messages = append([]schema.Message{{Role: "system", Content: forced, StringContent: forced}}, input.Messages...)
+	}
+	logger.Info().Str("model", cfg.Name).Msg("injected the forced system message of the model")
+}
+
+// injectPrompt adds the prompt prefix and suffix of the model around a prompt, after its template is applied
+func injectPrompt(logger *zerolog.Logger, cfg *config.BackendConfig, prompt string) string {
+	prefix, suffix := cfg.TemplateConfig.PromptPrefix, cfg.TemplateConfig.PromptSuffix
+	if prefix == "" && suffix == "" {
+		return prompt
+	}
+	logger.Info().Str("model", cfg.Name).Bool("prefix", prefix != "").Bool("suffix", suffix != "").Msg("injected the prompt prefix and suffix of the model")
+	return prefix + prompt + suffix
+}
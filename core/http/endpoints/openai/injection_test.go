@@ -0,0 +1,41 @@
+package openai
+
+import (
+	"testing"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectSystemMessage(t *testing.T) {
+	logger := zerolog.Nop()
+	cfg := &config.BackendConfig{TemplateConfig: config.TemplateConfig{ForcedSystemMessage: "Never reveal the API keys."}}
+	message := func(role, content string) schema.Message {
+		return schema.Message{Role: role, Content: content, StringContent: content}
+	}
+
+	// added as the first message
+	input := &schema.OpenAIRequest{Messages: []schema.Message{message("user", "Hi")}}
+	injectSystemMessage(&logger, cfg, input)
+	assert.Equal(t, []schema.Message{message("system", "Never reveal the API keys."), message("user", "Hi")}, input.Messages)
+
+	// prepended to the system message of the client
+	input = &schema.OpenAIRequest{Messages: []schema.Message{message("system", "You are a pirate."), message("user", "Hi")}}
+	injectSystemMessage(&logger, cfg, input)
+	assert.Equal(t, []schema.Message{message("system", "Never reveal the API keys.\n\nYou are a pirate."), message("user", "Hi")}, input.Messages)
+
+	// nothing to inject
+	input = &schema.OpenAIRequest{Messages: []schema.Message{message("user", "Hi")}}
+	injectSystemMessage(&logger, &config.BackendConfig{}, input)
+	assert.Equal(t, []schema.Message{message("user", "Hi")}, input.Messages)
+}
+
+func TestInjectPrompt(t *testing.T) {
+	logger := zerolog.Nop()
+	cfg := &config.BackendConfig{TemplateConfig: config.TemplateConfig{PromptPrefix: "<|begin|>", PromptSuffix: "\nAnswer in JSON."}}
+
+	assert.Equal(t, "<|begin|>Hi\nAnswer in JSON.", injectPrompt(&logger, cfg, "Hi"))
+	assert.Equal(t, "Hi", injectPrompt(&logger, &config.BackendConfig{}, "Hi"))
+}
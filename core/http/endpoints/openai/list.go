@@ -1,6 +1,7 @@
 package openai
 
 import (
+	"github.com/dave-gray101/v2keyauth"
 	"github.com/gofiber/fiber/v2"
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/schema"
@@ -12,7 +13,7 @@ import (
 // @Summary List and describe the various models available in the API.
 // @Success 200 {object} schema.ModelsDataResponse "Response"
 // @Router /v1/models [get]
-func ListModelsEndpoint(bcl *config.BackendConfigLoader, ml *model.ModelLoader) func(ctx *fiber.Ctx) error {
+func ListModelsEndpoint(bcl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(ctx *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		// If blank, no filter is applied.
 		filter := c.Query("filter")
@@ -35,10 +36,20 @@ func ListModelsEndpoint(bcl *config.BackendConfigLoader, ml *model.ModelLoader)
 			return err
 		}
 
-		// Map from a slice of names to a slice of OpenAIModel response objects
+		// Map from a slice of names to a slice of OpenAIModel response objects,
+		// hiding the models the API key of the request is not allowed to use
+		apiKey := v2keyauth.TokenFromContext(c)
 		dataModels := []schema.OpenAIModel{}
 		for _, m := range modelNames {
-			dataModels = append(dataModels, schema.OpenAIModel{ID: m, Object: "model"})
+			if !appConfig.ModelAllowedForApiKey(apiKey, m) {
+				continue
+			}
+			dataModel := schema.OpenAIModel{ID: m, Object: "model"}
+			if cfg, exists := bcl.GetBackendConfig(m); exists {
+				capabilities := cfg.Capabilities()
+				dataModel.Capabilities = &capabilities
+			}
+			dataModels = append(dataModels, dataModel)
 		}
 
 		return c.JSON(schema.ModelsDataResponse{
@@ -0,0 +1,77 @@
+package openai
+
+import (
+	"math"
+
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/grpc/proto"
+)
+
+// minLogprob replaces the log probability of tokens the backend reports as
+// impossible, as -Inf can't be encoded in JSON
+const minLogprob = -9999.0
+
+func logprob(lp float32) float64 {
+	if math.IsInf(float64(lp), 0) || math.IsNaN(float64(lp)) {
+		return minLogprob
+	}
+	return float64(lp)
+}
+
+func tokenBytes(token string) []int {
+	b := []int{}
+	for _, c := range []byte(token) {
+		b = append(b, int(c))
+	}
+	return b
+}
+
+// chatLogprobs maps the token log probabilities returned by the backend to the
+// chat completion format, keeping the top most likely tokens at each position
+func chatLogprobs(tokens []*proto.TokenLogprob, top int) *schema.ChatLogprobs {
+	res := &schema.ChatLogprobs{Content: []schema.TokenLogprob{}}
+	for _, t := range tokens {
+		tl := schema.TokenLogprob{
+			Token:       t.GetToken(),
+			Logprob:     logprob(t.GetLogprob()),
+			Bytes:       tokenBytes(t.GetToken()),
+			TopLogprobs: []schema.TopLogprob{},
+		}
+		for i, tt := range t.GetTopLogprobs() {
+			if i >= top {
+				break
+			}
+			tl.TopLogprobs = append(tl.TopLogprobs, schema.TopLogprob{
+				Token:   tt.GetToken(),
+				Logprob: logprob(tt.GetLogprob()),
+				Bytes:   tokenBytes(tt.GetToken()),
+			})
+		}
+		res.Content = append(res.Content, tl)
+	}
+	return res
+}
+
+// completionLogprobs converts the chat completion logprobs to the legacy completions format
+func completionLogprobs(l *schema.ChatLogprobs) *schema.CompletionLogprobs {
+	res := &schema.CompletionLogprobs{
+		Tokens:        []string{},
+		TokenLogprobs: []float64{},
+		TopLogprobs:   []map[string]float64{},
+		TextOffset:    []int{},
+	}
+	offset := 0
+	for _, t := range l.Content {
+		res.Tokens = append(res.Tokens, t.Token)
+		res.TokenLogprobs = append(res.TokenLogprobs, t.Logprob)
+		res.TextOffset = append(res.TextOffset, offset)
+		offset += len(t.Token)
+
+		top := map[string]float64{}
+		for _, tt := range t.TopLogprobs {
+			top[tt.Token] = tt.Logprob
+		}
+		res.TopLogprobs = append(res.TopLogprobs, top)
+	}
+	return res
+}
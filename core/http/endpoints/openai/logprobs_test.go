@@ -0,0 +1,52 @@
+package openai
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogprobs(t *testing.T) {
+	tokens := []*proto.TokenLogprob{
+		{Token: "Hi", Logprob: -0.5, TopLogprobs: []*proto.TokenLogprob{{Token: "Hi", Logprob: -0.5}, {Token: "Hey", Logprob: -1}}},
+		{Token: "!", Logprob: float32(math.Inf(-1))},
+	}
+
+	chat := chatLogprobs(tokens, 1)
+	assert.Len(t, chat.Content, 2)
+	assert.Equal(t, []int{72, 105}, chat.Content[0].Bytes)
+	assert.Equal(t, []schema.TopLogprob{{Token: "Hi", Logprob: -0.5, Bytes: []int{72, 105}}}, chat.Content[0].TopLogprobs)
+	assert.Equal(t, minLogprob, chat.Content[1].Logprob)
+	assert.Empty(t, chat.Content[1].TopLogprobs)
+
+	completion := completionLogprobs(chat)
+	assert.Equal(t, []string{"Hi", "!"}, completion.Tokens)
+	assert.Equal(t, []float64{-0.5, minLogprob}, completion.TokenLogprobs)
+	assert.Equal(t, []int{0, 2}, completion.TextOffset)
+	assert.Equal(t, []map[string]float64{{"Hi": -0.5}, {}}, completion.TopLogprobs)
+
+	// no tokens is an empty structure rather than a missing one
+	dat, err := json.Marshal(chatLogprobs(nil, 0))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"content": []}`, string(dat))
+}
+
+func TestLogprobsOption(t *testing.T) {
+	var chat schema.OpenAIRequest
+	assert.NoError(t, json.Unmarshal([]byte(`{"logprobs": true, "top_logprobs": 3}`), &chat))
+	assert.True(t, chat.Logprobs.Enabled)
+	assert.Equal(t, 3, chat.TopLogprobsCount())
+
+	var completion schema.OpenAIRequest
+	assert.NoError(t, json.Unmarshal([]byte(`{"logprobs": 2}`), &completion))
+	assert.True(t, completion.Logprobs.Enabled)
+	assert.Equal(t, 2, completion.TopLogprobsCount())
+
+	var disabled schema.OpenAIRequest
+	assert.NoError(t, json.Unmarshal([]byte(`{"logprobs": null}`), &disabled))
+	assert.False(t, disabled.Logprobs.Enabled)
+}
@@ -0,0 +1,119 @@
+package openai
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/correlation"
+	"github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/mudler/LocalAI/pkg/model"
+)
+
+// defaultModerationThreshold is the category score above which an input is flagged,
+// when the model config does not set one
+const defaultModerationThreshold = 0.5
+
+// ModerationsEndpoint is the OpenAI Moderations API endpoint https://platform.openai.com/docs/api-reference/moderations
+// @Summary Classifies if text is potentially harmful.
+// @Param request body schema.OpenAIRequest true "query params"
+// @Success 200 {object} schema.ModerationResponse "Response"
+// @Router /v1/moderations [post]
+func ModerationsEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
+		modelName, input, err := readRequest(c, cl, ml, appConfig, false)
+		if err != nil {
+			return fmt.Errorf("failed reading parameters from request:%w", err)
+		}
+
+		// without an explicit model, use the first one with a moderation mapping
+		if modelName == "" {
+			modelName = firstModerationModel(cl)
+			if modelName == "" {
+				return fiber.NewError(fiber.StatusBadRequest, "no moderation model configured: set moderation.categories in the config of a classifier model")
+			}
+			if err := checkModelAccess(c, appConfig, modelName); err != nil {
+				return err
+			}
+		}
+
+		cfg, input, err := mergeRequestWithConfig(modelName, input, cl, ml, appConfig.Debug, appConfig.Threads, appConfig.ContextSize, appConfig.F16)
+		if err != nil {
+			return fmt.Errorf("failed reading parameters from request:%w", err)
+		}
+
+		if len(cfg.Moderation.Categories) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("model %q is not configured for moderation: set moderation.categories in its config", cfg.Name))
+		}
+		if len(cfg.InputStrings) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "input must be a string or a list of strings")
+		}
+
+		logger.Debug().Msgf("Parameter Config: %+v", cfg)
+
+		res, err := backend.Classify(input.Context, cfg.InputStrings, ml, appConfig, *cfg)
+		if err != nil {
+			return err
+		}
+
+		resp := &schema.ModerationResponse{
+			ID:    "modr-" + uuid.New().String(),
+			Model: cfg.Name,
+		}
+		for _, p := range res.Predictions {
+			resp.Results = append(resp.Results, moderationResult(cfg.Moderation, p))
+		}
+
+		return c.JSON(resp)
+	}
+}
+
+// firstModerationModel returns the name of the first model, in name order, with a moderation mapping
+func firstModerationModel(cl *config.BackendConfigLoader) string {
+	names := []string{}
+	for _, cfg := range cl.GetBackendConfigsByFilter(config.BuildUsecaseFilterFn(config.FLAG_MODERATION)) {
+		if len(cfg.Moderation.Categories) > 0 {
+			names = append(names, cfg.Name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	slices.Sort(names)
+	return names[0]
+}
+
+// moderationResult maps the label scores of a classifier prediction to the configured
+// moderation categories. A category scores as its highest scoring label, and is flagged
+// when that score reaches the threshold.
+func moderationResult(m config.ModerationConfig, p *proto.ClassifyPrediction) schema.ModerationResult {
+	threshold := float64(m.Threshold)
+	if threshold <= 0 {
+		threshold = defaultModerationThreshold
+	}
+
+	scores := map[string]float64{}
+	for _, l := range p.GetLabels() {
+		scores[l.GetLabel()] = float64(l.GetScore())
+	}
+
+	result := schema.ModerationResult{
+		Categories:     map[string]bool{},
+		CategoryScores: map[string]float64{},
+	}
+	for category, labels := range m.Categories {
+		score := 0.0
+		for _, label := range labels {
+			score = max(score, scores[label])
+		}
+		result.CategoryScores[category] = score
+		result.Categories[category] = score >= threshold
+		result.Flagged = result.Flagged || result.Categories[category]
+	}
+	return result
+}
@@ -0,0 +1,33 @@
+package openai
+
+import (
+	"testing"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModerationResult(t *testing.T) {
+	prediction := &proto.ClassifyPrediction{Labels: []*proto.ClassifyLabel{
+		{Label: "toxic", Score: 0.25},
+		{Label: "insult", Score: 0.75},
+		{Label: "threat", Score: 0.125},
+	}}
+
+	m := config.ModerationConfig{Categories: map[string][]string{
+		"harassment": {"toxic", "insult"},
+		"violence":   {"threat"},
+		"sexual":     {"obscene"},
+	}}
+
+	result := moderationResult(m, prediction)
+	assert.True(t, result.Flagged)
+	assert.Equal(t, map[string]float64{"harassment": 0.75, "violence": 0.125, "sexual": 0}, result.CategoryScores)
+	assert.Equal(t, map[string]bool{"harassment": true, "violence": false, "sexual": false}, result.Categories)
+
+	m.Threshold = 0.8
+	result = moderationResult(m, prediction)
+	assert.False(t, result.Flagged)
+	assert.False(t, result.Categories["harassment"])
+}
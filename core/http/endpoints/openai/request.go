@@ -3,25 +3,26 @@ package openai
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"time"
 
+	"github.com/dave-gray101/v2keyauth"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/mudler/LocalAI/core/config"
 	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
 	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	"github.com/mudler/LocalAI/pkg/functions"
 	"github.com/mudler/LocalAI/pkg/model"
 	"github.com/mudler/LocalAI/pkg/templates"
 	"github.com/mudler/LocalAI/pkg/utils"
-	"github.com/rs/zerolog/log"
+	"github.com/mudler/LocalAI/pkg/xlog"
 )
 
-type correlationIDKeyType string
-
-// CorrelationIDKey to track request across process boundary
-const CorrelationIDKey correlationIDKeyType = "correlationID"
-
 func readRequest(c *fiber.Ctx, cl *config.BackendConfigLoader, ml *model.ModelLoader, o *config.ApplicationConfig, firstModel bool) (string, *schema.OpenAIRequest, error) {
 	input := new(schema.OpenAIRequest)
 
@@ -31,21 +32,72 @@ func readRequest(c *fiber.Ctx, cl *config.BackendConfigLoader, ml *model.ModelLo
 	}
 
 	received, _ := json.Marshal(input)
-	// Extract or generate the correlation ID
-	correlationID := c.Get("X-Correlation-ID", uuid.New().String())
-
-	ctx, cancel := context.WithCancel(o.Context)
-	// Add the correlation ID to the new context
-	ctxWithCorrelationID := context.WithValue(ctx, CorrelationIDKey, correlationID)
+	// The context of the backend calls carries the correlation ID, for their logs and the gRPC metadata,
+	// and the span of the request. Both are set by the middlewares, generate an ID if they did not run
+	ctx := c.UserContext()
+	if correlation.FromContext(ctx) == "" {
+		ctx = correlation.WithID(o.Context, uuid.New().String())
+	}
+	ctx, cancel := context.WithCancel(ctx)
 
-	input.Context = ctxWithCorrelationID
+	input.Context = ctx
 	input.Cancel = cancel
 
-	log.Debug().Msgf("Request received: %s", string(received))
+	correlation.Logger(ctx).Debug().Msgf("Request received: %s", string(received))
 
 	modelFile, err := fiberContext.ModelFromContext(c, cl, ml, input.Model, firstModel)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := checkQuota(c, input); err != nil {
+		return "", nil, err
+	}
 
-	return modelFile, input, err
+	// the handlers picking a default model check it themselves
+	if modelFile != "" {
+		if err := checkModelAccess(c, o, modelFile); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return modelFile, input, nil
+}
+
+// checkModelAccess rejects the requests to a model the API key of the request is not allowed to use
+func checkModelAccess(c *fiber.Ctx, o *config.ApplicationConfig, model string) error {
+	if !o.ModelAllowedForApiKey(v2keyauth.TokenFromContext(c), model) {
+		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("the API key is not allowed to use the model %q", model))
+	}
+	return nil
+}
+
+// checkQuota rejects the requests of an API key over its token quota until the end of the window.
+// The allowed requests reserve their estimated tokens in the quota, until they are done.
+func checkQuota(c *fiber.Ctx, input *schema.OpenAIRequest) error {
+	quotas := fiberContext.QuotaEnforcerFromContext(c)
+	if quotas == nil {
+		return nil
+	}
+	apiKey := v2keyauth.TokenFromContext(c)
+	allowed, retryAfter, reservation := quotas.Allow(apiKey, estimateTokens(c, input))
+	if allowed {
+		fiberContext.WithQuotaReservation(c, reservation)
+		return nil
+	}
+	quota, _ := quotas.Quota(apiKey)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return fiber.NewError(fiber.StatusTooManyRequests, fmt.Sprintf("the API key exceeded its quota of %d tokens per %s, retry in %s", quota.Tokens, quota.Window, retryAfter.Round(time.Second)))
+}
+
+// estimateTokens roughly estimates the tokens of a request before it is processed: about four bytes
+// of the body per token of the prompt, and the tokens of the completion when they are limited
+func estimateTokens(c *fiber.Ctx, input *schema.OpenAIRequest) int {
+	tokens := len(c.Body()) / 4
+	if input.Maxtokens != nil && *input.Maxtokens > 0 {
+		tokens += *input.Maxtokens
+	}
+	return tokens
 }
 
 func updateRequestConfig(config *config.BackendConfig, input *schema.OpenAIRequest) {
@@ -112,6 +164,13 @@ func updateRequestConfig(config *config.BackendConfig, input *schema.OpenAIReque
 		}
 	}
 
+	if input.Stop == nil {
+		if stops := config.AutoStops(); len(stops) > 0 {
+			config.StopWords = append(config.StopWords, stops...)
+			xlog.HTTP.Debug().Str("model", config.Name).Strs("stopwords", stops).Msg("added the end-of-turn tokens of the model to the stop words")
+		}
+	}
+
 	switch stop := input.Stop.(type) {
 	case string:
 		if stop != "" {
@@ -146,73 +205,6 @@ func updateRequestConfig(config *config.BackendConfig, input *schema.OpenAIReque
 		}
 	}
 
-	// Decode each request's message content
-	imgIndex, vidIndex, audioIndex := 0, 0, 0
-	for i, m := range input.Messages {
-		switch content := m.Content.(type) {
-		case string:
-			input.Messages[i].StringContent = content
-		case []interface{}:
-			dat, _ := json.Marshal(content)
-			c := []schema.Content{}
-			json.Unmarshal(dat, &c)
-		CONTENT:
-			for _, pp := range c {
-				switch pp.Type {
-				case "text":
-					input.Messages[i].StringContent = pp.Text
-				case "video", "video_url":
-					// Decode content as base64 either if it's an URL or base64 text
-					base64, err := utils.GetContentURIAsBase64(pp.VideoURL.URL)
-					if err != nil {
-						log.Error().Msgf("Failed encoding video: %s", err)
-						continue CONTENT
-					}
-					input.Messages[i].StringVideos = append(input.Messages[i].StringVideos, base64) // TODO: make sure that we only return base64 stuff
-
-					t := "[vid-{{.ID}}]{{.Text}}"
-					if config.TemplateConfig.Video != "" {
-						t = config.TemplateConfig.Video
-					}
-					// set a placeholder for each image
-					input.Messages[i].StringContent, _ = templates.TemplateMultiModal(t, vidIndex, input.Messages[i].StringContent)
-					vidIndex++
-				case "audio_url", "audio":
-					// Decode content as base64 either if it's an URL or base64 text
-					base64, err := utils.GetContentURIAsBase64(pp.AudioURL.URL)
-					if err != nil {
-						log.Error().Msgf("Failed encoding image: %s", err)
-						continue CONTENT
-					}
-					input.Messages[i].StringAudios = append(input.Messages[i].StringAudios, base64) // TODO: make sure that we only return base64 stuff
-					// set a placeholder for each image
-					t := "[audio-{{.ID}}]{{.Text}}"
-					if config.TemplateConfig.Audio != "" {
-						t = config.TemplateConfig.Audio
-					}
-					input.Messages[i].StringContent, _ = templates.TemplateMultiModal(t, audioIndex, input.Messages[i].StringContent)
-					audioIndex++
-				case "image_url", "image":
-					// Decode content as base64 either if it's an URL or base64 text
-					base64, err := utils.GetContentURIAsBase64(pp.ImageURL.URL)
-					if err != nil {
-						log.Error().Msgf("Failed encoding image: %s", err)
-						continue CONTENT
-					}
-
-					t := "[img-{{.ID}}]{{.Text}}"
-					if config.TemplateConfig.Image != "" {
-						t = config.TemplateConfig.Image
-					}
-					input.Messages[i].StringImages = append(input.Messages[i].StringImages, base64) // TODO: make sure that we only return base64 stuff
-					// set a placeholder for each image
-					input.Messages[i].StringContent, _ = templates.TemplateMultiModal(t, imgIndex, input.Messages[i].StringContent)
-					imgIndex++
-				}
-			}
-		}
-	}
-
 	if input.RepeatPenalty != 0 {
 		config.RepeatPenalty = input.RepeatPenalty
 	}
@@ -237,10 +229,30 @@ func updateRequestConfig(config *config.BackendConfig, input *schema.OpenAIReque
 		config.IgnoreEOS = input.IgnoreEOS
 	}
 
+	if input.Logprobs.Enabled {
+		config.Logprobs = input.Logprobs
+	}
+
+	if input.TopLogprobs != nil {
+		config.TopLogprobs = input.TopLogprobs
+	}
+
+	if input.PromptCacheKey != "" {
+		config.PromptCacheKey = input.PromptCacheKey
+	}
+
 	if input.Seed != nil {
 		config.Seed = input.Seed
 	}
 
+	if input.Deterministic {
+		config.Deterministic = input.Deterministic
+	}
+
+	if input.Adapter != "" {
+		config.Adapter = input.Adapter
+	}
+
 	if input.TypicalP != nil {
 		config.TypicalP = input.TypicalP
 	}
@@ -295,6 +307,187 @@ func updateRequestConfig(config *config.BackendConfig, input *schema.OpenAIReque
 	}
 }
 
+// validateAdapter checks that the LoRA adapter requested can be applied to the model
+func validateAdapter(cfg *config.BackendConfig) error {
+	if cfg.Adapter == "" {
+		return nil
+	}
+	if _, exists := cfg.FindLoraAdapter(cfg.Adapter); !exists {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("LoRA adapter %q is not registered in lora_adapters of model %q", cfg.Adapter, cfg.Name))
+	}
+	if !cfg.SupportsLoraHotSwap() {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("backend %q cannot switch LoRA adapters per request", cfg.Backend))
+	}
+	return nil
+}
+
+// decodeMessageContents decodes the multimodal content parts of the chat messages,
+// downloading the media referenced by URL, and adds the placeholders for each of them
+// to the message text. Images are rejected if the model can't see them, or if they
+// exceed the limits set in the application config.
+func decodeMessageContents(config *config.BackendConfig, input *schema.OpenAIRequest, appConfig *config.ApplicationConfig) error {
+	maxImageSize := int64(appConfig.MaxImageSizeMB) * 1024 * 1024
+
+	imgIndex, vidIndex, audioIndex := 0, 0, 0
+	for i, m := range input.Messages {
+		switch content := m.Content.(type) {
+		case string:
+			input.Messages[i].StringContent = content
+		case []interface{}:
+			dat, _ := json.Marshal(content)
+			c := []schema.Content{}
+			json.Unmarshal(dat, &c)
+		CONTENT:
+			for _, pp := range c {
+				switch pp.Type {
+				case "text":
+					input.Messages[i].StringContent = pp.Text
+				case "video", "video_url":
+					// Decode content as base64 either if it's an URL or base64 text
+					base64, err := utils.GetContentURIAsBase64(pp.VideoURL.URL)
+					if err != nil {
+						xlog.HTTP.Error().Msgf("Failed encoding video: %s", err)
+						continue CONTENT
+					}
+					input.Messages[i].StringVideos = append(input.Messages[i].StringVideos, base64) // TODO: make sure that we only return base64 stuff
+
+					t := "[vid-{{.ID}}]{{.Text}}"
+					if config.TemplateConfig.Video != "" {
+						t = config.TemplateConfig.Video
+					}
+					// set a placeholder for each image
+					input.Messages[i].StringContent, _ = templates.TemplateMultiModal(t, vidIndex, input.Messages[i].StringContent)
+					vidIndex++
+				case "audio_url", "audio":
+					// Decode content as base64 either if it's an URL or base64 text
+					base64, err := utils.GetContentURIAsBase64(pp.AudioURL.URL)
+					if err != nil {
+						xlog.HTTP.Error().Msgf("Failed encoding image: %s", err)
+						continue CONTENT
+					}
+					input.Messages[i].StringAudios = append(input.Messages[i].StringAudios, base64) // TODO: make sure that we only return base64 stuff
+					// set a placeholder for each image
+					t := "[audio-{{.ID}}]{{.Text}}"
+					if config.TemplateConfig.Audio != "" {
+						t = config.TemplateConfig.Audio
+					}
+					input.Messages[i].StringContent, _ = templates.TemplateMultiModal(t, audioIndex, input.Messages[i].StringContent)
+					audioIndex++
+				case "image_url", "image":
+					if !config.SupportsImages() {
+						return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("model %q does not support image inputs", config.Name))
+					}
+					if appConfig.MaxImagesPerRequest > 0 && imgIndex >= appConfig.MaxImagesPerRequest {
+						return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("too many images in the request, the maximum is %d", appConfig.MaxImagesPerRequest))
+					}
+
+					// Decode content as base64 either if it's an URL or base64 text
+					base64, err := utils.GetContentURIAsBase64WithLimit(pp.ImageURL.URL, maxImageSize)
+					if errors.Is(err, utils.ErrContentTooLarge) {
+						return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("image %d exceeds the maximum size of %dMB", imgIndex, appConfig.MaxImageSizeMB))
+					}
+					if err != nil {
+						return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("failed decoding image %d: %s", imgIndex, err))
+					}
+
+					t := "[img-{{.ID}}]{{.Text}}"
+					if config.TemplateConfig.Image != "" {
+						t = config.TemplateConfig.Image
+					}
+					input.Messages[i].StringImages = append(input.Messages[i].StringImages, base64) // TODO: make sure that we only return base64 stuff
+					// set a placeholder for each image
+					input.Messages[i].StringContent, _ = templates.TemplateMultiModal(t, imgIndex, input.Messages[i].StringContent)
+					imgIndex++
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// maxTopLogprobs is the maximum number of most likely tokens returned at each position
+const maxTopLogprobs = 20
+
+// validateLogprobs checks that the log probabilities requested can be returned
+func validateLogprobs(cfg *config.BackendConfig, input *schema.OpenAIRequest) error {
+	if !cfg.Logprobs.Enabled {
+		if cfg.TopLogprobs != nil && *cfg.TopLogprobs > 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "top_logprobs requires logprobs to be enabled")
+		}
+		return nil
+	}
+	if top := cfg.TopLogprobsCount(); top < 0 || top > maxTopLogprobs {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("top_logprobs must be between 0 and %d", maxTopLogprobs))
+	}
+	if input.Stream {
+		return fiber.NewError(fiber.StatusBadRequest, "logprobs are not supported when streaming")
+	}
+	if !cfg.SupportsLogprobs() {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("backend %q does not support logprobs", cfg.Backend))
+	}
+	return nil
+}
+
+// validateStop checks that stop is a string or an array of strings, as the other values would be
+// silently ignored
+func validateStop(input *schema.OpenAIRequest) error {
+	switch stop := input.Stop.(type) {
+	case nil, string:
+	case []interface{}:
+		for i, s := range stop {
+			if _, ok := s.(string); !ok {
+				return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("stop[%d] must be a string, got %T", i, s))
+			}
+		}
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("stop must be a string or an array of strings, got %T", stop))
+	}
+	return nil
+}
+
+// validateMaxTokens checks that max_tokens is positive and, when the context length of the model is known
+// from its GGUF metadata, that it fits in it, as extended by the RoPE scaling of the config
+func validateMaxTokens(cfg *config.BackendConfig) error {
+	if cfg.Maxtokens == nil {
+		return nil
+	}
+	maxTokens := *cfg.Maxtokens
+	switch {
+	case maxTokens < 0:
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("max_tokens must be a positive number, got %d", maxTokens))
+	case cfg.MaxContextLength > 0 && maxTokens > cfg.EffectiveContextLength():
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("max_tokens %d exceeds the context length of the model (%d tokens)", maxTokens, cfg.EffectiveContextLength()))
+	}
+	return nil
+}
+
+// validateChoicesCount checks the number of completions (`n`) requested
+// against the limit set in the application config
+func validateChoicesCount(input *schema.OpenAIRequest, appConfig *config.ApplicationConfig) error {
+	switch {
+	case input.N < 0:
+		return fiber.NewError(fiber.StatusBadRequest, "n must be a positive number")
+	case appConfig.MaxChoices > 0 && input.N > appConfig.MaxChoices:
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("n must be at most %d", appConfig.MaxChoices))
+	case input.Stream && input.N > 1:
+		return fiber.NewError(fiber.StatusBadRequest, "n greater than 1 is not supported when streaming")
+	}
+	return nil
+}
+
+// validateImageCount checks the number of images (`n`) requested for each prompt
+// against the limit set in the application config
+func validateImageCount(input *schema.OpenAIRequest, appConfig *config.ApplicationConfig) error {
+	switch {
+	case input.N < 0:
+		return fiber.NewError(fiber.StatusBadRequest, "n must be a positive number")
+	case appConfig.MaxGeneratedImages > 0 && input.N > appConfig.MaxGeneratedImages:
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("n must be at most %d", appConfig.MaxGeneratedImages))
+	}
+	return nil
+}
+
 func mergeRequestWithConfig(modelFile string, input *schema.OpenAIRequest, cm *config.BackendConfigLoader, loader *model.ModelLoader, debug bool, threads, ctx int, f16 bool) (*config.BackendConfig, *schema.OpenAIRequest, error) {
 	cfg, err := cm.LoadBackendConfigFileByName(modelFile, loader.ModelPath,
 		config.LoadOptionDebug(debug),
@@ -304,12 +497,33 @@ func mergeRequestWithConfig(modelFile string, input *schema.OpenAIRequest, cm *c
 		config.ModelPath(loader.ModelPath),
 	)
 
+	if err := validateStop(input); err != nil {
+		return nil, nil, err
+	}
+
 	// Set the parameters for the language model prediction
 	updateRequestConfig(cfg, input)
 
+	if err := validateMaxTokens(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	// the defaults of the config are clamped when it is loaded, this checks the values of the request
+	if err := cfg.ValidateSampling(); err != nil {
+		return nil, nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
 	if !cfg.Validate() {
 		return nil, nil, fmt.Errorf("failed to validate config")
 	}
 
+	if err := validateAdapter(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateLogprobs(cfg, input); err != nil {
+		return nil, nil, err
+	}
+
 	return cfg, input, err
 }
@@ -0,0 +1,187 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dave-gray101/v2keyauth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
+	"github.com/mudler/LocalAI/core/http/middleware"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateChoicesCount(t *testing.T) {
+	appConfig := &config.ApplicationConfig{MaxChoices: 4}
+	request := func(n int, stream bool) *schema.OpenAIRequest {
+		return &schema.OpenAIRequest{PredictionOptions: schema.PredictionOptions{N: n}, Stream: stream}
+	}
+
+	assert.NoError(t, validateChoicesCount(request(0, false), appConfig))
+	assert.NoError(t, validateChoicesCount(request(4, false), appConfig))
+	assert.NoError(t, validateChoicesCount(request(1, true), appConfig))
+	assert.EqualError(t, validateChoicesCount(request(5, false), appConfig), "n must be at most 4")
+	assert.Error(t, validateChoicesCount(request(-1, false), appConfig))
+	assert.Error(t, validateChoicesCount(request(2, true), appConfig))
+
+	assert.NoError(t, validateChoicesCount(request(100, false), &config.ApplicationConfig{}))
+}
+
+func TestCheckModelAccess(t *testing.T) {
+	appConfig := config.NewApplicationConfig(
+		config.WithApiKeys([]string{"key-a", "key-b"}),
+		config.WithApiKeyModels(map[string][]string{"key-a": {"llama-*"}}, config.ApiKeyModelsDenyAll),
+	)
+	kaConfig, err := middleware.GetKeyAuthConfig(appConfig)
+	assert.NoError(t, err)
+
+	app := fiber.New()
+	app.Use(v2keyauth.New(*kaConfig))
+	app.Get("/:model", func(c *fiber.Ctx) error {
+		if err := checkModelAccess(c, appConfig, c.Params("model")); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	status := func(key, model string) int {
+		req := httptest.NewRequest("GET", "/"+model, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusOK, status("key-a", "llama-3"))
+	assert.Equal(t, fiber.StatusForbidden, status("key-a", "whisper-1"))
+	// key-b has no allow-list, and the default policy denies everything
+	assert.Equal(t, fiber.StatusForbidden, status("key-b", "llama-3"))
+}
+
+func TestCheckQuota(t *testing.T) {
+	appConfig := config.NewApplicationConfig(config.WithApiKeys([]string{"key-a", "key-b"}))
+	kaConfig, err := middleware.GetKeyAuthConfig(appConfig)
+	assert.NoError(t, err)
+	quotas := services.NewQuotaEnforcer(map[string]config.ApiKeyQuota{"key-a": {Tokens: 10, Window: time.Hour}}, config.ApiKeyQuotaHard)
+
+	app := fiber.New()
+	app.Use(v2keyauth.New(*kaConfig))
+	app.Use(func(c *fiber.Ctx) error {
+		fiberContext.WithQuotaEnforcer(c, quotas)
+		return c.Next()
+	})
+	app.Get("/", func(c *fiber.Ctx) error {
+		if err := checkQuota(c, &schema.OpenAIRequest{}); err != nil {
+			return err
+		}
+		usageRecorder(c, &config.BackendConfig{})(schema.OpenAIUsage{TotalTokens: 6})
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	request := func(key string) *http.Response {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, fiber.StatusOK, request("key-a").StatusCode)
+	// the request crossing the budget completes, the next ones are rejected
+	assert.Equal(t, fiber.StatusOK, request("key-a").StatusCode)
+	resp := request("key-a")
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "3600", resp.Header.Get(fiber.HeaderRetryAfter))
+	// key-b has no quota
+	for range 3 {
+		assert.Equal(t, fiber.StatusOK, request("key-b").StatusCode)
+	}
+}
+
+func TestValidateStop(t *testing.T) {
+	request := func(stop interface{}) *schema.OpenAIRequest {
+		return &schema.OpenAIRequest{Stop: stop}
+	}
+
+	assert.NoError(t, validateStop(request(nil)))
+	assert.NoError(t, validateStop(request("\n")))
+	assert.NoError(t, validateStop(request([]interface{}{"\n", "</s>"})))
+	assert.EqualError(t, validateStop(request([]interface{}{"\n", 42.0})), "stop[1] must be a string, got float64")
+	assert.EqualError(t, validateStop(request(map[string]interface{}{"a": "b"})), "stop must be a string or an array of strings, got map[string]interface {}")
+}
+
+func TestUpdateRequestConfigSampling(t *testing.T) {
+	float := func(f float64) *float64 { return &f }
+	// the defaults of a coding model
+	defaults := func() *config.BackendConfig {
+		return &config.BackendConfig{PredictionOptions: schema.PredictionOptions{Temperature: float(0), TopP: float(0.9)}}
+	}
+
+	cfg := defaults()
+	updateRequestConfig(cfg, &schema.OpenAIRequest{})
+	assert.Equal(t, 0.0, *cfg.Temperature)
+	assert.Equal(t, 0.9, *cfg.TopP)
+
+	cfg = defaults()
+	updateRequestConfig(cfg, &schema.OpenAIRequest{PredictionOptions: schema.PredictionOptions{Temperature: float(0.7)}})
+	assert.Equal(t, 0.7, *cfg.Temperature)
+	assert.Equal(t, 0.9, *cfg.TopP)
+	assert.NoError(t, cfg.ValidateSampling())
+
+	cfg = defaults()
+	updateRequestConfig(cfg, &schema.OpenAIRequest{PredictionOptions: schema.PredictionOptions{Temperature: float(3)}})
+	assert.EqualError(t, cfg.ValidateSampling(), "temperature must be between 0 and 2, got 3")
+}
+
+func TestUpdateRequestConfigAutoStops(t *testing.T) {
+	model := func() *config.BackendConfig {
+		cfg := &config.BackendConfig{EndOfTurnTokens: []string{"<|im_end|>", "<|endoftext|>"}}
+		cfg.StopWords = []string{"<|endoftext|>"}
+		return cfg
+	}
+
+	cfg := model()
+	updateRequestConfig(cfg, &schema.OpenAIRequest{})
+	assert.Equal(t, []string{"<|endoftext|>", "<|im_end|>"}, cfg.StopWords)
+
+	// the stop of the request overrides the end-of-turn tokens
+	cfg = model()
+	updateRequestConfig(cfg, &schema.OpenAIRequest{Stop: "\n"})
+	assert.Equal(t, []string{"<|endoftext|>", "\n"}, cfg.StopWords)
+
+	disabled := false
+	cfg = model()
+	cfg.AutoStopWords = &disabled
+	updateRequestConfig(cfg, &schema.OpenAIRequest{})
+	assert.Equal(t, []string{"<|endoftext|>"}, cfg.StopWords)
+
+	// the model emits the token for the function calls to be parsed
+	cfg = model()
+	cfg.FunctionsConfig.ResponseRegex = []string{`(?s)(?P<arguments>.*?)<\|im_end\|>`}
+	updateRequestConfig(cfg, &schema.OpenAIRequest{})
+	assert.Equal(t, []string{"<|endoftext|>"}, cfg.StopWords)
+}
+
+func TestValidateMaxTokens(t *testing.T) {
+	maxTokens := func(n int, contextLength int) *config.BackendConfig {
+		return &config.BackendConfig{PredictionOptions: schema.PredictionOptions{Maxtokens: &n}, MaxContextLength: contextLength}
+	}
+
+	assert.NoError(t, validateMaxTokens(&config.BackendConfig{}))
+	assert.NoError(t, validateMaxTokens(maxTokens(0, 4096)))
+	assert.NoError(t, validateMaxTokens(maxTokens(4096, 4096)))
+	// without GGUF metadata the context length is unknown
+	assert.NoError(t, validateMaxTokens(maxTokens(100000, 0)))
+	assert.EqualError(t, validateMaxTokens(maxTokens(8192, 4096)), "max_tokens 8192 exceeds the context length of the model (4096 tokens)")
+	assert.Error(t, validateMaxTokens(maxTokens(-1, 4096)))
+
+	// the RoPE scaling extends the context length
+	extended := maxTokens(8192, 4096)
+	extended.RopeFreqScale = 0.5
+	assert.NoError(t, validateMaxTokens(extended))
+}
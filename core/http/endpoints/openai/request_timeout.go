@@ -0,0 +1,71 @@
+package openai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
+)
+
+// applyRequestTimeout bounds the backend calls of the request by the request timeout of its model, if any.
+// The timeout starts when the config of the model is merged, so it covers the wait in the queue.
+func applyRequestTimeout(cfg *config.BackendConfig, input *schema.OpenAIRequest) {
+	timeout, _ := cfg.RequestTimeoutDuration()
+	if timeout == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(input.Context, timeout)
+	parentCancel := input.Cancel
+	input.Context = ctx
+	input.Cancel = func() {
+		cancel()
+		parentCancel()
+	}
+}
+
+// requestTimedOut reports whether the request was cancelled by the request timeout of its model
+func requestTimedOut(input *schema.OpenAIRequest) bool {
+	return errors.Is(input.Context.Err(), context.DeadlineExceeded)
+}
+
+// requestTimeoutMessage is the error of the requests cancelled by the request timeout of their model
+func requestTimeoutMessage(cfg *config.BackendConfig) string {
+	return fmt.Sprintf("the request to the model %s timed out after %s", cfg.Name, cfg.RequestTimeout)
+}
+
+// timeoutError replaces the error of a request cancelled by the request timeout of its model with a 504,
+// recording the timeout. The other errors are returned as they are.
+func timeoutError(c *fiber.Ctx, cfg *config.BackendConfig, input *schema.OpenAIRequest, err error) error {
+	if err == nil || !requestTimedOut(input) {
+		return err
+	}
+	observeRequestTimeout(fiberContext.MetricsServiceFromContext(c), cfg)
+	return fiber.NewError(fiber.StatusGatewayTimeout, requestTimeoutMessage(cfg))
+}
+
+// writeStreamTimeout ends a stream cut by the request timeout of its model with an error event, after the
+// chunks generated until then, recording the timeout
+func writeStreamTimeout(w *bufio.Writer, metrics *services.LocalAIMetricsService, cfg *config.BackendConfig) {
+	observeRequestTimeout(metrics, cfg)
+	data, _ := json.Marshal(schema.ErrorResponse{Error: &schema.APIError{
+		Code:    fiber.StatusGatewayTimeout,
+		Message: requestTimeoutMessage(cfg),
+		Type:    "timeout",
+	}})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	w.WriteString("data: [DONE]\n\n")
+	w.Flush()
+}
+
+func observeRequestTimeout(metrics *services.LocalAIMetricsService, cfg *config.BackendConfig) {
+	if metrics != nil {
+		metrics.ObserveRequestTimeout(cfg.Name)
+	}
+}
@@ -0,0 +1,97 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	request := func() *schema.OpenAIRequest {
+		ctx, cancel := context.WithCancel(context.Background())
+		return &schema.OpenAIRequest{Context: ctx, Cancel: cancel}
+	}
+	cfg := &config.BackendConfig{Name: "slow", RequestTimeout: "10ms"}
+
+	app := fiber.New()
+	app.Get("/:timeout", func(c *fiber.Ctx) error {
+		cfg := &config.BackendConfig{Name: "slow", RequestTimeout: c.Params("timeout")}
+		if cfg.RequestTimeout == "none" {
+			cfg.RequestTimeout = ""
+		}
+		input := request()
+		applyRequestTimeout(cfg, input)
+		select {
+		case <-input.Context.Done():
+			return timeoutError(c, cfg, input, input.Context.Err())
+		case <-time.After(50 * time.Millisecond):
+			return timeoutError(c, cfg, input, errors.New("backend failure"))
+		}
+	})
+	status := func(timeout string) int {
+		resp, err := app.Test(httptest.NewRequest("GET", "/"+timeout, nil))
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, fiber.StatusGatewayTimeout, status("10ms"))
+	// the other errors are kept, and there is no timeout by default
+	assert.Equal(t, fiber.StatusInternalServerError, status("1m"))
+	assert.Equal(t, fiber.StatusInternalServerError, status("none"))
+
+	// cancelling the request does not time it out
+	input := request()
+	applyRequestTimeout(cfg, input)
+	input.Cancel()
+	assert.False(t, requestTimedOut(input))
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeStreamTimeout(w, nil, cfg)
+	assert.Equal(t, "data: {\"error\":{\"code\":504,\"message\":\"the request to the model slow timed out after 10ms\",\"type\":\"timeout\"}}\n\ndata: [DONE]\n\n", buf.String())
+}
+
+func TestRequestTimeoutQueued(t *testing.T) {
+	scheduler, err := services.NewRequestScheduler(services.SchedulerPolicyFIFO, 0)
+	assert.NoError(t, err)
+	// the only slot of the model is taken
+	busy, _, err := scheduler.Acquire(context.Background(), "slow", 1, 0)
+	assert.NoError(t, err)
+	defer busy()
+
+	app := fiber.New()
+	app.Get("/:timeout", func(c *fiber.Ctx) error {
+		fiberContext.WithRequestScheduler(c, scheduler)
+		cfg := &config.BackendConfig{Name: "slow", RequestTimeout: c.Params("timeout")}
+		ctx, cancel := context.WithCancel(context.Background())
+		input := &schema.OpenAIRequest{Context: ctx, Cancel: cancel}
+		applyRequestTimeout(cfg, input)
+		if c.Query("cancel") != "" {
+			input.Cancel()
+		}
+		release, err := scheduleRequest(c, cfg, input, &config.ApplicationConfig{})
+		if err != nil {
+			return err
+		}
+		release()
+		return nil
+	})
+	status := func(target string) int {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil))
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+	// timing out in the queue is a timeout, a request cancelled in the queue is unavailable
+	assert.Equal(t, fiber.StatusGatewayTimeout, status("/10ms"))
+	assert.Equal(t, fiber.StatusServiceUnavailable, status("/1m?cancel=1"))
+}
@@ -0,0 +1,70 @@
+package openai
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
+	"github.com/mudler/LocalAI/pkg/correlation"
+	"github.com/mudler/LocalAI/pkg/functions"
+)
+
+// responseCacheKey returns the response cache key of a request, or "" if it must not be cached.
+// Only the non-streaming requests with temperature 0 are cached. The key covers the endpoint,
+// the model configuration merged with the request (model, backend, sampling parameters, grammar,
+// stop words...), the prompt and the suffix of the fill-in-the-middle completions.
+func responseCacheKey(endpoint string, cfg *config.BackendConfig, input *schema.OpenAIRequest, prompt string) string {
+	logger := correlation.Logger(input.Context)
+	if input.Stream || cfg.Temperature == nil || *cfg.Temperature != 0 {
+		return ""
+	}
+
+	key, err := services.ResponseCacheKey(struct {
+		Endpoint     string
+		Config       *config.BackendConfig
+		Prompt       string
+		Suffix       string
+		Messages     []schema.Message
+		Functions    functions.Functions
+		FunctionCall interface{}
+	}{endpoint, cfg, prompt, input.Suffix, input.Messages, input.Functions, input.FunctionCall})
+	if err != nil {
+		logger.Debug().Err(err).Msg("unable to compute the response cache key")
+		return ""
+	}
+	return key
+}
+
+// cachedResponse returns the cached response for the key, if any, renewing its ID and creation time
+func cachedResponse(c *fiber.Ctx, cfg *config.BackendConfig, key, id string, created int) (*schema.OpenAIResponse, bool) {
+	logger := correlation.Logger(c.UserContext())
+	cache := fiberContext.ResponseCacheFromContext(c)
+	if cache == nil || key == "" {
+		return nil, false
+	}
+
+	resp, hit := cache.Get(key)
+	if metrics := fiberContext.MetricsServiceFromContext(c); metrics != nil {
+		metrics.ObserveResponseCache(cfg.Name, hit)
+	}
+	if !hit {
+		c.Set("X-LocalAI-Response-Cache", "miss")
+		return nil, false
+	}
+
+	logger.Debug().Str("model", cfg.Name).Msg("response cache hit")
+	c.Set("X-LocalAI-Response-Cache", "hit")
+	resp.ID = id
+	resp.Created = created
+	return &resp, true
+}
+
+// cacheResponse stores the response in the response cache, if enabled
+func cacheResponse(c *fiber.Ctx, key string, resp *schema.OpenAIResponse) {
+	cache := fiberContext.ResponseCacheFromContext(c)
+	if cache == nil || key == "" {
+		return
+	}
+	cache.Add(key, *resp)
+}
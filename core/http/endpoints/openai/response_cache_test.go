@@ -0,0 +1,43 @@
+package openai
+
+import (
+	"testing"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	zero, one, topP := 0.0, 1.0, 0.9
+	cfg := func(name, backend string, temperature *float64) *config.BackendConfig {
+		c := &config.BackendConfig{Name: name, Backend: backend}
+		c.Temperature = temperature
+		return c
+	}
+	input := &schema.OpenAIRequest{}
+
+	key := responseCacheKey("chat", cfg("model", "llama-cpp", &zero), input, "prompt")
+	assert.NotEmpty(t, key)
+	assert.Equal(t, key, responseCacheKey("chat", cfg("model", "llama-cpp", &zero), input, "prompt"))
+
+	assert.NotEqual(t, key, responseCacheKey("completion", cfg("model", "llama-cpp", &zero), input, "prompt"))
+	assert.NotEqual(t, key, responseCacheKey("chat", cfg("other", "llama-cpp", &zero), input, "prompt"))
+	assert.NotEqual(t, key, responseCacheKey("chat", cfg("model", "vllm", &zero), input, "prompt"))
+	assert.NotEqual(t, key, responseCacheKey("chat", cfg("model", "llama-cpp", &zero), input, "another prompt"))
+
+	// the fill-in-the-middle completions differ by their suffix
+	completion := responseCacheKey("completion", cfg("model", "llama-cpp", &zero), input, "prompt")
+	assert.NotEqual(t, completion, responseCacheKey("completion", cfg("model", "llama-cpp", &zero), &schema.OpenAIRequest{Suffix: "a"}, "prompt"))
+	assert.NotEqual(t, responseCacheKey("completion", cfg("model", "llama-cpp", &zero), &schema.OpenAIRequest{Suffix: "a"}, "prompt"),
+		responseCacheKey("completion", cfg("model", "llama-cpp", &zero), &schema.OpenAIRequest{Suffix: "b"}, "prompt"))
+
+	withTopP := cfg("model", "llama-cpp", &zero)
+	withTopP.TopP = &topP
+	assert.NotEqual(t, key, responseCacheKey("chat", withTopP, input, "prompt"))
+
+	// only the non-streaming requests with temperature 0 are cached
+	assert.Empty(t, responseCacheKey("chat", cfg("model", "llama-cpp", &one), input, "prompt"))
+	assert.Empty(t, responseCacheKey("chat", cfg("model", "llama-cpp", nil), input, "prompt"))
+	assert.Empty(t, responseCacheKey("chat", cfg("model", "llama-cpp", &zero), &schema.OpenAIRequest{Stream: true}, "prompt"))
+}
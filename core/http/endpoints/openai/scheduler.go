@@ -0,0 +1,49 @@
+package openai
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
+	"github.com/mudler/LocalAI/core/schema"
+)
+
+// queueWaitHeader reports how long, in milliseconds, the request waited in the scheduler queue
+const queueWaitHeader = "X-LocalAI-Queue-Wait"
+
+// scheduleRequest waits until the request scheduler, when enabled, has a free slot for the model.
+// It returns the function releasing the slot, which must be called once the backend is done.
+func scheduleRequest(c *fiber.Ctx, cfg *config.BackendConfig, input *schema.OpenAIRequest, appConfig *config.ApplicationConfig) (func(), error) {
+	scheduler := fiberContext.RequestSchedulerFromContext(c)
+	if scheduler == nil {
+		return func() {}, nil
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Model
+	}
+
+	// backends serve one request at a time, unless parallel requests are enabled
+	limit := cfg.Scheduler.MaxConcurrency
+	if limit == 0 && !appConfig.ParallelBackendRequests {
+		limit = 1
+	}
+
+	release, wait, err := scheduler.Acquire(input.Context, name, limit, cfg.Scheduler.Weight)
+	if metrics := fiberContext.MetricsServiceFromContext(c); metrics != nil {
+		metrics.ObserveQueueWait(name, wait.Seconds())
+	}
+	if err != nil && requestTimedOut(input) {
+		// the request timeout of the model covers the wait in the queue
+		return nil, timeoutError(c, cfg, input, err)
+	}
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, fmt.Sprintf("request canceled while queued: %v", err))
+	}
+
+	c.Set(queueWaitHeader, strconv.FormatInt(wait.Milliseconds(), 10))
+	return release, nil
+}
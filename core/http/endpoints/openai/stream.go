@@ -0,0 +1,174 @@
+package openai
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+)
+
+// streamSettings tune how the chunks of a stream are written
+type streamSettings struct {
+	// keepAlive is the interval of the SSE comments written while the stream is idle before its first token
+	keepAlive time.Duration
+	// batchTokens is the number of token chunks merged in each SSE event
+	batchTokens int
+	// flushInterval is the minimum time between the flushes of the events, they are flushed as written if 0
+	flushInterval time.Duration
+}
+
+// streamSettingsFor returns the stream settings of the application, overridden by the stream options of the request
+func streamSettingsFor(appConfig *config.ApplicationConfig, input *schema.OpenAIRequest) (streamSettings, error) {
+	s := streamSettings{
+		keepAlive:     appConfig.StreamKeepAlive,
+		batchTokens:   appConfig.StreamBatchTokens,
+		flushInterval: appConfig.StreamFlushInterval,
+	}
+	if input.StreamOptions == nil {
+		return s, nil
+	}
+	if n := input.StreamOptions.BatchTokens; n != nil {
+		if *n < 0 {
+			return s, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("stream_options.batch_tokens must be a positive number, got %d", *n))
+		}
+		s.batchTokens = *n
+	}
+	if ms := input.StreamOptions.FlushIntervalMs; ms != nil {
+		if *ms < 0 {
+			return s, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("stream_options.flush_interval_ms must be a positive number, got %d", *ms))
+		}
+		s.flushInterval = time.Duration(*ms) * time.Millisecond
+	}
+	return s, nil
+}
+
+// streamResponses calls send with each response of the stream until it is closed, and flushes what send wrote.
+//
+// Until the first token, while the backend processes the prompt, an SSE comment is written every keep-alive
+// interval the stream stays idle, so that the proxies and the clients do not close the connection during a
+// long prefill. The consecutive token chunks are merged by batches of batchTokens, and the writes are flushed
+// at most every flushInterval, to cut the overhead of the events and of the flushes.
+// A client gone away cancels the request.
+func streamResponses(w *bufio.Writer, responses <-chan schema.OpenAIResponse, settings streamSettings, cancel func(), send func(schema.OpenAIResponse)) {
+	var heartbeat <-chan time.Time
+	var ticker *time.Ticker
+	if settings.keepAlive > 0 {
+		ticker = time.NewTicker(settings.keepAlive)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	var flushTimer <-chan time.Time
+	flush := func() {
+		flushTimer = nil
+		if err := w.Flush(); err != nil {
+			cancel()
+		}
+	}
+	written := func() {
+		switch {
+		case settings.flushInterval <= 0:
+			flush()
+		case flushTimer == nil:
+			flushTimer = time.After(settings.flushInterval)
+		}
+	}
+
+	var batch *schema.OpenAIResponse
+	batched := 0
+	sendBatch := func() {
+		if batch != nil {
+			send(*batch)
+			batch, batched = nil, 0
+			written()
+		}
+	}
+
+	for {
+		select {
+		case ev, ok := <-responses:
+			if !ok {
+				sendBatch()
+				flush()
+				return
+			}
+			if carriesTokens(ev) {
+				heartbeat = nil
+			} else if heartbeat != nil {
+				ticker.Reset(settings.keepAlive)
+			}
+			if settings.batchTokens <= 1 || !mergeable(ev) {
+				sendBatch()
+				send(ev)
+				written()
+				continue
+			}
+			if batch == nil {
+				batch = &ev
+			} else {
+				mergeChunk(batch, ev)
+			}
+			if batched++; batched >= settings.batchTokens {
+				sendBatch()
+			}
+		case <-heartbeat:
+			w.WriteString(": keep-alive\n\n")
+			flush()
+		case <-flushTimer:
+			flush()
+		}
+	}
+}
+
+// carriesTokens reports whether a chunk of a stream carries generated tokens, rather than only announcing
+// the role of the message as the first chunk of the chat completions
+func carriesTokens(ev schema.OpenAIResponse) bool {
+	if ev.Usage.CompletionTokens > 0 {
+		return true
+	}
+	for _, c := range ev.Choices {
+		if c.Text != "" {
+			return true
+		}
+		if c.Delta == nil {
+			continue
+		}
+		if len(c.Delta.ToolCalls) > 0 || c.Delta.FunctionCall != nil {
+			return true
+		}
+		if content, ok := c.Delta.Content.(*string); ok && content != nil && *content != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeable reports whether a chunk only carries the text of tokens, which can be merged with the next chunks
+func mergeable(ev schema.OpenAIResponse) bool {
+	if len(ev.Choices) != 1 || ev.Choices[0].FinishReason != "" || ev.Choices[0].Logprobs != nil {
+		return false
+	}
+	delta := ev.Choices[0].Delta
+	if delta == nil {
+		return true
+	}
+	_, text := delta.Content.(*string)
+	return text && delta.Role == "" && len(delta.ToolCalls) == 0 && delta.FunctionCall == nil
+}
+
+// mergeChunk appends the tokens of the mergeable chunk ev to the batch, which takes its usage
+func mergeChunk(batch *schema.OpenAIResponse, ev schema.OpenAIResponse) {
+	c := &batch.Choices[0]
+	if c.Delta == nil {
+		c.Text += ev.Choices[0].Text
+	} else {
+		content := *c.Delta.Content.(*string) + *ev.Choices[0].Delta.Content.(*string)
+		delta := *c.Delta
+		delta.Content = &content
+		c.Delta = &delta
+	}
+	batch.Usage = ev.Usage
+}
@@ -0,0 +1,150 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamResponsesKeepAlive(t *testing.T) {
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	responses := make(chan schema.OpenAIResponse)
+	role, token := "", "Hello"
+
+	go func() {
+		responses <- schema.OpenAIResponse{Choices: []schema.Choice{{Delta: &schema.Message{Role: "assistant", Content: &role}}}}
+		// the prefill of the prompt
+		time.Sleep(50 * time.Millisecond)
+		responses <- schema.OpenAIResponse{Choices: []schema.Choice{{Delta: &schema.Message{Content: &token}}}}
+		// no keep-alive once the tokens flow
+		time.Sleep(50 * time.Millisecond)
+		responses <- schema.OpenAIResponse{Choices: []schema.Choice{{Delta: &schema.Message{Content: &token}}}}
+		close(responses)
+	}()
+
+	var sent []string
+	streamResponses(w, responses, streamSettings{keepAlive: 10 * time.Millisecond}, func() {}, func(ev schema.OpenAIResponse) {
+		sent = append(sent, *ev.Choices[0].Delta.Content.(*string))
+		w.WriteString("data\n\n")
+	})
+
+	assert.Equal(t, []string{"", "Hello", "Hello"}, sent)
+	keepAlives := strings.Count(out.String(), ": keep-alive\n\n")
+	assert.GreaterOrEqual(t, keepAlives, 2)
+	// the keep-alives are only sent before the first token
+	assert.True(t, strings.HasSuffix(out.String(), "data\n\ndata\n\n"), out.String())
+}
+
+func TestStreamResponsesWithoutKeepAlive(t *testing.T) {
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	responses := make(chan schema.OpenAIResponse)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		responses <- schema.OpenAIResponse{Choices: []schema.Choice{{Text: "Hello"}}}
+		close(responses)
+	}()
+
+	count := 0
+	streamResponses(w, responses, streamSettings{}, func() {}, func(ev schema.OpenAIResponse) { count++ })
+	w.Flush()
+
+	assert.Equal(t, 1, count)
+	assert.Empty(t, out.String())
+}
+
+func TestStreamResponsesBatching(t *testing.T) {
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	responses := make(chan schema.OpenAIResponse)
+	role := ""
+
+	go func() {
+		responses <- schema.OpenAIResponse{Choices: []schema.Choice{{Delta: &schema.Message{Role: "assistant", Content: &role}}}}
+		for i, token := range []string{"a", "b", "c", "d", "e"} {
+			responses <- schema.OpenAIResponse{
+				Choices: []schema.Choice{{Delta: &schema.Message{Content: &token}}},
+				Usage:   schema.OpenAIUsage{CompletionTokens: i + 1},
+			}
+		}
+		close(responses)
+	}()
+
+	var sent []string
+	var usage []int
+	streamResponses(w, responses, streamSettings{batchTokens: 2}, func() {}, func(ev schema.OpenAIResponse) {
+		sent = append(sent, *ev.Choices[0].Delta.Content.(*string))
+		usage = append(usage, ev.Usage.CompletionTokens)
+	})
+
+	// the role is sent alone, and the last batch when the stream ends
+	assert.Equal(t, []string{"", "ab", "cd", "e"}, sent)
+	assert.Equal(t, []int{0, 2, 4, 5}, usage)
+}
+
+// syncBuffer is a buffer written by the stream while the test reads it
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestStreamResponsesFlushInterval(t *testing.T) {
+	var out syncBuffer
+	w := bufio.NewWriter(&out)
+	responses := make(chan schema.OpenAIResponse)
+	early, flushed := make(chan string, 1), make(chan string, 1)
+
+	go func() {
+		responses <- schema.OpenAIResponse{Choices: []schema.Choice{{Text: "a"}}}
+		responses <- schema.OpenAIResponse{Choices: []schema.Choice{{Text: "b"}}}
+		early <- out.String()
+		// the events are flushed together once the interval elapsed
+		time.Sleep(100 * time.Millisecond)
+		flushed <- out.String()
+		close(responses)
+	}()
+
+	streamResponses(w, responses, streamSettings{flushInterval: 20 * time.Millisecond}, func() {}, func(ev schema.OpenAIResponse) {
+		w.WriteString("data: " + ev.Choices[0].Text + "\n\n")
+	})
+
+	assert.Empty(t, <-early)
+	assert.Equal(t, "data: a\n\ndata: b\n\n", <-flushed)
+}
+
+func TestStreamSettingsFor(t *testing.T) {
+	appConfig := &config.ApplicationConfig{StreamBatchTokens: 4, StreamFlushInterval: time.Second}
+	one, zero, negative := 1, 0, -1
+
+	settings, err := streamSettingsFor(appConfig, &schema.OpenAIRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, streamSettings{batchTokens: 4, flushInterval: time.Second}, settings)
+
+	settings, err = streamSettingsFor(appConfig, &schema.OpenAIRequest{StreamOptions: &schema.StreamOptions{BatchTokens: &one, FlushIntervalMs: &zero}})
+	assert.NoError(t, err)
+	assert.Equal(t, streamSettings{batchTokens: 1}, settings)
+
+	_, err = streamSettingsFor(appConfig, &schema.OpenAIRequest{StreamOptions: &schema.StreamOptions{BatchTokens: &negative}})
+	assert.Error(t, err)
+}
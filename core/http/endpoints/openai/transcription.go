@@ -1,19 +1,24 @@
 package openai
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 
 	"github.com/mudler/LocalAI/core/backend"
 	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/correlation"
 	model "github.com/mudler/LocalAI/pkg/model"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
 )
 
 // TranscriptEndpoint is the OpenAI Whisper API endpoint https://platform.openai.com/docs/api-reference/audio/create
@@ -21,10 +26,13 @@ import (
 // @accept multipart/form-data
 // @Param model formData string true "model"
 // @Param file formData file true "file"
+// @Param stream formData bool false "stream the segments as server-sent events"
+// @Param diarization formData bool false "label the segments with their speaker turn"
 // @Success 200 {object} map[string]string	 "Response"
 // @Router /v1/audio/transcriptions [post]
 func TranscriptEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, appConfig *config.ApplicationConfig) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		logger := correlation.Logger(c.UserContext())
 		m, input, err := readRequest(c, cl, ml, appConfig, false)
 		if err != nil {
 			return fmt.Errorf("failed reading parameters from request:%w", err)
@@ -50,7 +58,13 @@ func TranscriptEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 		if err != nil {
 			return err
 		}
-		defer os.RemoveAll(dir)
+		// when streaming, the directory is removed once the transcription is over
+		removeDir := true
+		defer func() {
+			if removeDir {
+				os.RemoveAll(dir)
+			}
+		}()
 
 		dst := filepath.Join(dir, path.Base(file.Filename))
 		dstFile, err := os.Create(dst)
@@ -59,19 +73,109 @@ func TranscriptEndpoint(cl *config.BackendConfigLoader, ml *model.ModelLoader, a
 		}
 
 		if _, err := io.Copy(dstFile, f); err != nil {
-			log.Debug().Msgf("Audio file copying error %+v - %+v - err %+v", file.Filename, dst, err)
+			logger.Debug().Msgf("Audio file copying error %+v - %+v - err %+v", file.Filename, dst, err)
 			return err
 		}
 
-		log.Debug().Msgf("Audio file copied to: %+v", dst)
+		logger.Debug().Msgf("Audio file copied to: %+v", dst)
+
+		// the form is read here: the Ctx is not valid anymore while the transcription is streamed
+		diarization := diarize(c, input, config)
+
+		if stream, _ := strconv.ParseBool(c.FormValue("stream")); stream {
+			removeDir = false
+			streamTranscription(c, dir, dst, diarization, input, config, ml, appConfig)
+			return nil
+		}
 
-		tr, err := backend.ModelTranscription(dst, input.Language, input.Translate, ml, *config, appConfig)
+		tr, err := backend.ModelTranscription(input.Context, dst, input.Language, input.Translate, diarization, ml, *config, appConfig)
 		if err != nil {
 			return err
 		}
 
-		log.Debug().Msgf("Trascribed: %+v", tr)
+		logger.Debug().Msgf("Trascribed: %+v", tr)
 		// TODO: handle different outputs here
 		return c.Status(http.StatusOK).JSON(tr)
 	}
 }
+
+// streamTranscription sends the segments of the transcription as server-sent events as soon as
+// the backend decodes them, followed by a final event with the whole transcription.
+// It removes dir when the transcription is over.
+func streamTranscription(c *fiber.Ctx, dir, dst string, diarization bool, input *schema.OpenAIRequest, config *config.BackendConfig, ml *model.ModelLoader, appConfig *config.ApplicationConfig) {
+	logger := correlation.Logger(input.Context)
+	segments := make(chan schema.Segment)
+	var transcriptionErr error
+
+	go func() {
+		defer os.RemoveAll(dir)
+		defer close(segments)
+		transcriptionErr = backend.ModelTranscriptionStream(input.Context, dst, input.Language, input.Translate, diarization, ml, *config, appConfig, func(s schema.Segment) {
+			segments <- s
+		})
+	}()
+
+	c.Context().SetContentType("text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("Transfer-Encoding", "chunked")
+
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		result := schema.TranscriptionResult{}
+		disconnected := false
+		for s := range segments {
+			result.Segments = append(result.Segments, s)
+			result.Text += s.Text
+
+			// keep draining the segments if the client went away, until the backend stops
+			if disconnected {
+				continue
+			}
+			if err := writeTranscriptionEvent(w, schema.TranscriptionStreamEvent{
+				Type:    "transcript.text.delta",
+				Delta:   s.Text,
+				Segment: &s,
+			}); err != nil {
+				logger.Debug().Msgf("Sending transcription segment failed: %v", err)
+				disconnected = true
+				input.Cancel()
+			}
+		}
+
+		if disconnected {
+			return
+		}
+
+		if transcriptionErr != nil {
+			writeTranscriptionEvent(w, schema.TranscriptionStreamEvent{
+				Type:  "error",
+				Error: transcriptionErr.Error(),
+			})
+			return
+		}
+
+		writeTranscriptionEvent(w, schema.TranscriptionStreamEvent{
+			Type:     "transcript.text.done",
+			Text:     result.Text,
+			Segments: result.Segments,
+		})
+	}))
+}
+
+func writeTranscriptionEvent(w *bufio.Writer, event schema.TranscriptionStreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// diarize returns true if the speaker turns should be detected, either because
+// it was requested or because it is enabled in the model configuration
+func diarize(c *fiber.Ctx, input *schema.OpenAIRequest, config *config.BackendConfig) bool {
+	requested, _ := strconv.ParseBool(c.FormValue("diarization"))
+	return requested || input.Diarization || config.Diarization
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/xlog"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ListenH2C serves the app on address with net/http, speaking both HTTP/1.1 and HTTP/2 cleartext (h2c),
+// as fasthttp only speaks HTTP/1.1. The connections of HTTP/2 multiplex their requests, streamed ones
+// included.
+func ListenH2C(app *fiber.App, address string, appConfig *config.ApplicationConfig) error {
+	server := &http.Server{
+		Addr:         address,
+		Handler:      NewH2CHandler(app, appConfig),
+		ReadTimeout:  appConfig.HTTPReadTimeout,
+		WriteTimeout: appConfig.HTTPWriteTimeout,
+		IdleTimeout:  appConfig.HTTPIdleTimeout,
+	}
+	xlog.HTTP.Info().Str("endpoint", "http://"+address).Msg("LocalAI API is listening with HTTP/1.1 and h2c! Please connect to the endpoint for API documentation.")
+	return server.ListenAndServe()
+}
+
+// NewH2CHandler returns a net/http handler running the HTTP/1.1 and h2c requests through the app
+func NewH2CHandler(app *fiber.App, appConfig *config.ApplicationConfig) http.Handler {
+	return h2c.NewHandler(fiberHandler(app, int64(appConfig.UploadLimitMB)*1024*1024), &http2.Server{IdleTimeout: appConfig.HTTPIdleTimeout})
+}
+
+// fiberHandler returns a net/http handler running the requests through the app. Unlike the adaptor of
+// fiber, the streamed responses are flushed as they are written rather than buffered, so that the
+// server-sent events of the completions reach the client token by token. The request bodies larger than
+// bodyLimit, the BodyLimit of the app, are rejected with 413 before being read in full.
+func fiberHandler(app *fiber.App, bodyLimit int64) http.Handler {
+	handler := app.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		if r.Body != nil {
+			if bodyLimit > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, bodyLimit)
+			}
+			n, err := io.Copy(req.BodyWriter(), r.Body)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, fiber.ErrRequestEntityTooLarge.Message, http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "failed reading the request body", http.StatusBadRequest)
+				return
+			}
+			req.Header.SetContentLength(int(n))
+		}
+		req.Header.SetMethod(r.Method)
+		req.SetRequestURI(r.RequestURI)
+		req.SetHost(r.Host)
+		for key, values := range r.Header {
+			for _, v := range values {
+				req.Header.Add(key, v)
+			}
+		}
+
+		var remoteAddr net.Addr
+		if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+			remoteAddr = addr
+		}
+		var fctx fasthttp.RequestCtx
+		fctx.Init(req, remoteAddr, nil)
+		handler(&fctx)
+		defer fctx.Response.Reset()
+
+		stream := fctx.Response.IsBodyStream()
+		fctx.Response.Header.VisitAll(func(k, v []byte) {
+			key := string(k)
+			// net/http frames the responses itself, and HTTP/2 forbids the connection headers
+			switch {
+			case strings.EqualFold(key, fiber.HeaderConnection), strings.EqualFold(key, fiber.HeaderTransferEncoding):
+				return
+			case stream && strings.EqualFold(key, fiber.HeaderContentLength):
+				return
+			}
+			w.Header().Add(key, string(v))
+		})
+		w.WriteHeader(fctx.Response.StatusCode())
+
+		if !stream {
+			w.Write(fctx.Response.Body())
+			return
+		}
+		_, err := io.Copy(&flushWriter{w: w, rc: http.NewResponseController(w)}, fctx.Response.BodyStream())
+		// closing the stream stops its writer when the client went away
+		fctx.Response.CloseBodyStream()
+		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			xlog.HTTP.Debug().Err(err).Str("path", r.URL.Path).Msg("streamed response interrupted")
+		}
+	})
+}
+
+// flushWriter flushes each write to the client
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.rc.Flush()
+}
@@ -0,0 +1,106 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	. "github.com/mudler/LocalAI/core/http"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+)
+
+var _ = Describe("h2c", func() {
+	var (
+		server *httptest.Server
+		client *http.Client
+		next   chan struct{}
+	)
+
+	BeforeEach(func() {
+		next = make(chan struct{})
+		app := fiber.New()
+		app.Get("/hello", func(c *fiber.Ctx) error {
+			return c.SendString("hello " + c.Query("name"))
+		})
+		app.Post("/echo", func(c *fiber.Ctx) error {
+			return c.SendString(fmt.Sprint(len(c.Body())))
+		})
+		app.Get("/stream", func(c *fiber.Ctx) error {
+			c.Set("Content-Type", "text/event-stream")
+			c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+				for i := 0; i < 2; i++ {
+					fmt.Fprintf(w, "data: %d\n\n", i)
+					w.Flush()
+					<-next
+				}
+			}))
+			return nil
+		})
+		server = httptest.NewServer(NewH2CHandler(app, &config.ApplicationConfig{UploadLimitMB: 1}))
+		DeferCleanup(server.Close)
+
+		client = &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+	})
+
+	It("serves the requests over HTTP/2", func() {
+		resp, err := client.Get(server.URL + "/hello?name=h2c")
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.ProtoMajor).To(Equal(2))
+		body := make([]byte, 9)
+		_, err = resp.Body.Read(body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal("hello h2c"))
+	})
+
+	It("rejects the request bodies over the upload limit", func() {
+		resp, err := client.Post(server.URL+"/echo", "text/plain", strings.NewReader(strings.Repeat("a", 1024)))
+		Expect(err).ToNot(HaveOccurred())
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(string(body)).To(Equal("1024"))
+
+		resp, err = client.Post(server.URL+"/echo", "text/plain", strings.NewReader(strings.Repeat("a", 1024*1024+1)))
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("streams the server-sent events as they are written", func() {
+		resp, err := client.Get(server.URL + "/stream")
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.ProtoMajor).To(Equal(2))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		reader := bufio.NewReader(resp.Body)
+		line, err := reader.ReadString('\n')
+		Expect(err).ToNot(HaveOccurred())
+		// the first event arrives while the handler waits to write the second one
+		Expect(line).To(Equal("data: 0\n"))
+		Eventually(next).WithTimeout(time.Second).Should(BeSent(struct{}{}))
+		reader.ReadString('\n')
+		line, err = reader.ReadString('\n')
+		Expect(err).ToNot(HaveOccurred())
+		Expect(line).To(Equal("data: 1\n"))
+		next <- struct{}{}
+	})
+})
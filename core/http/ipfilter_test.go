@@ -0,0 +1,94 @@
+package http_test
+
+import (
+	"io"
+	"net/http/httptest"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/http/middleware"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IPFilter", func() {
+	// the requests of fiber's test client come from 0.0.0.0
+	newApp := func(allow, deny, trusted []string) *fiber.App {
+		allowList, err := config.ParsePrefixes(allow)
+		Expect(err).ToNot(HaveOccurred())
+		denyList, err := config.ParsePrefixes(deny)
+		Expect(err).ToNot(HaveOccurred())
+		trustedProxies, err := config.ParsePrefixes(trusted)
+		Expect(err).ToNot(HaveOccurred())
+
+		app := fiber.New()
+		app.Get("/healthz", func(c *fiber.Ctx) error { return c.SendString("ok") })
+		app.Use(middleware.IPFilter(config.NewApplicationConfig(config.WithIPFilter(allowList, denyList), config.WithTrustedProxies(trustedProxies, "X-Forwarded-For"))))
+		app.Get("/v1/models", func(c *fiber.Ctx) error { return c.SendString("ok") })
+		return app
+	}
+	status := func(app *fiber.App, path, forwardedFor string) int {
+		req := httptest.NewRequest(fiber.MethodGet, path, nil)
+		if forwardedFor != "" {
+			req.Header.Set(fiber.HeaderXForwardedFor, forwardedFor)
+		}
+		resp, err := app.Test(req)
+		Expect(err).ToNot(HaveOccurred())
+		return resp.StatusCode
+	}
+
+	It("filters the peer of the connection", func() {
+		Expect(status(newApp([]string{"0.0.0.0"}, nil, nil), "/v1/models", "")).To(Equal(fiber.StatusOK))
+		Expect(status(newApp([]string{"10.0.0.0/8"}, nil, nil), "/v1/models", "")).To(Equal(fiber.StatusForbidden))
+		Expect(status(newApp([]string{"0.0.0.0/8"}, []string{"0.0.0.0"}, nil), "/v1/models", "")).To(Equal(fiber.StatusForbidden))
+	})
+
+	It("exempts the health endpoints", func() {
+		Expect(status(newApp([]string{"10.0.0.0/8"}, nil, nil), "/healthz", "")).To(Equal(fiber.StatusOK))
+	})
+
+	It("ignores X-Forwarded-For without trusted proxies", func() {
+		app := newApp([]string{"10.0.0.0/8"}, nil, nil)
+		Expect(status(app, "/v1/models", "10.1.2.3")).To(Equal(fiber.StatusForbidden))
+	})
+
+	It("reads the client from X-Forwarded-For behind a trusted proxy", func() {
+		app := newApp([]string{"10.0.0.0/8"}, nil, []string{"0.0.0.0", "192.168.1.0/24"})
+		Expect(status(app, "/v1/models", "10.1.2.3")).To(Equal(fiber.StatusOK))
+		Expect(status(app, "/v1/models", "10.1.2.3, 192.168.1.10")).To(Equal(fiber.StatusOK))
+		Expect(status(app, "/v1/models", "172.16.0.1")).To(Equal(fiber.StatusForbidden))
+		// the addresses added by the client before the trusted proxies are not trusted
+		Expect(status(app, "/v1/models", "10.1.2.3, 172.16.0.1")).To(Equal(fiber.StatusForbidden))
+	})
+
+	It("reads the client from X-Real-IP behind a trusted proxy", func() {
+		trustedProxies, err := config.ParsePrefixes([]string{"0.0.0.0"})
+		Expect(err).ToNot(HaveOccurred())
+		appConfig := config.NewApplicationConfig(config.WithTrustedProxies(trustedProxies, "X-Real-IP"))
+
+		app := fiber.New()
+		app.Get("/ip", func(c *fiber.Ctx) error { return c.SendString(middleware.ClientIP(c, appConfig).String()) })
+		ip := func(headers map[string]string) string {
+			req := httptest.NewRequest(fiber.MethodGet, "/ip", nil)
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := app.Test(req)
+			Expect(err).ToNot(HaveOccurred())
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			return string(body)
+		}
+
+		Expect(ip(map[string]string{"X-Real-IP": "10.1.2.3", fiber.HeaderXForwardedFor: "172.16.0.1"})).To(Equal("10.1.2.3"))
+		Expect(ip(map[string]string{"X-Real-IP": "invalid"})).To(Equal("0.0.0.0"))
+		Expect(ip(nil)).To(Equal("0.0.0.0"))
+	})
+
+	It("rejects the invalid CIDRs", func() {
+		_, err := config.ParsePrefixes([]string{"10.0.0.0/33"})
+		Expect(err).To(HaveOccurred())
+		_, err = config.ParsePrefixes([]string{"localhost"})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -8,8 +8,13 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/keyauth"
 	"github.com/mudler/LocalAI/core/config"
+	fiberContext "github.com/mudler/LocalAI/core/http/ctx"
+	"github.com/mudler/LocalAI/pkg/correlation"
 )
 
+// apiKeyLabelLogField is the field of the log lines holding the label of the API key of the request
+const apiKeyLabelLogField = "api_key_label"
+
 // This file contains the configuration generators and handler functions that are used along with the fiber/keyauth middleware
 // Currently this requires an upstream patch - and feature patches are no longer accepted to v2
 // Therefore `dave-gray101/v2keyauth` contains the v2 backport of the middleware until v3 stabilizes and we migrate.
@@ -56,6 +61,7 @@ func getApiKeyValidationFunction(applicationConfig *config.ApplicationConfig) fu
 			}
 			for _, validKey := range applicationConfig.ApiKeys {
 				if subtle.ConstantTimeCompare([]byte(apiKey), []byte(validKey)) == 1 {
+					attachApiKeyLabel(ctx, applicationConfig, apiKey)
 					return true, nil
 				}
 			}
@@ -69,6 +75,7 @@ func getApiKeyValidationFunction(applicationConfig *config.ApplicationConfig) fu
 		}
 		for _, validKey := range applicationConfig.ApiKeys {
 			if apiKey == validKey {
+				attachApiKeyLabel(ctx, applicationConfig, apiKey)
 				return true, nil
 			}
 		}
@@ -76,6 +83,19 @@ func getApiKeyValidationFunction(applicationConfig *config.ApplicationConfig) fu
 	}
 }
 
+// attachApiKeyLabel makes the label of the API key available to the handlers, and adds it to the logs of the request
+func attachApiKeyLabel(ctx *fiber.Ctx, applicationConfig *config.ApplicationConfig, apiKey string) {
+	label := applicationConfig.ApiKeyLabel(apiKey)
+	if label == "" {
+		return
+	}
+	fiberContext.WithApiKeyLabel(ctx, label)
+	if correlation.FromContext(ctx.UserContext()) != "" {
+		logger := correlation.Logger(ctx.UserContext()).With().Str(apiKeyLabelLogField, label).Logger()
+		ctx.SetUserContext(logger.WithContext(ctx.UserContext()))
+	}
+}
+
 func getApiKeyRequiredFilterFunction(applicationConfig *config.ApplicationConfig) func(*fiber.Ctx) bool {
 	if applicationConfig.DisableApiKeyRequirementForHttpGet {
 		return func(c *fiber.Ctx) bool {
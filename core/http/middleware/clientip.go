@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+)
+
+// ClientIP returns the address of the client of the request. It is the peer of the connection, unless
+// the peer is a trusted proxy: the address is then read from the proxy header. X-Forwarded-For is walked
+// from the right, skipping the trusted proxies, as the proxies append the address of their peer and
+// anything on its left may have been sent by the client itself.
+func ClientIP(c *fiber.Ctx, appConfig *config.ApplicationConfig) netip.Addr {
+	ip, _ := netip.AddrFromSlice(c.Context().RemoteIP())
+	ip = ip.Unmap()
+	if !containsAddr(appConfig.TrustedProxies, ip) {
+		return ip
+	}
+
+	if appConfig.ProxyHeader != "" && !strings.EqualFold(appConfig.ProxyHeader, fiber.HeaderXForwardedFor) {
+		if forwarded, err := netip.ParseAddr(strings.TrimSpace(c.Get(appConfig.ProxyHeader))); err == nil {
+			return forwarded.Unmap()
+		}
+		return ip
+	}
+
+	forwarded := []string{}
+	for _, header := range c.Request().Header.PeekAll(fiber.HeaderXForwardedFor) {
+		forwarded = append(forwarded, strings.Split(string(header), ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !containsAddr(appConfig.TrustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// TrustedProxyConfig returns the trusted proxies in the format of the fiber configuration. Fiber trusts
+// the X-Forwarded-Proto and X-Forwarded-Host headers of these proxies only.
+func TrustedProxyConfig(appConfig *config.ApplicationConfig) []string {
+	proxies := []string{}
+	for _, prefix := range appConfig.TrustedProxies {
+		proxies = append(proxies, prefix.String())
+	}
+	return proxies
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
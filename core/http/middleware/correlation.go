@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/pkg/correlation"
+	"github.com/mudler/LocalAI/pkg/xlog"
+	"github.com/rs/zerolog"
+)
+
+// maxCorrelationIDLength bounds the correlation IDs accepted from the clients, as they end up in every log line
+const maxCorrelationIDLength = 128
+
+// CorrelationID reads the correlation ID of the request from the configured header, or generates one,
+// and echoes it in the response. The ID is attached to the user context of the request, which the
+// handlers use for their logs and their backend calls. The logger of the request logs at the level of the
+// http component.
+func CorrelationID(applicationConfig *config.ApplicationConfig) fiber.Handler {
+	header := applicationConfig.CorrelationIDHeader
+	if header == "" {
+		header = correlation.DefaultHeader
+	}
+	return func(c *fiber.Ctx) error {
+		id := strings.TrimSpace(c.Get(header))
+		if id == "" || len(id) > maxCorrelationIDLength {
+			id = uuid.New().String()
+		}
+		c.Set(header, id)
+		ctx := correlation.WithID(applicationConfig.Context, id)
+		logger := zerolog.Ctx(ctx).Level(xlog.HTTP.Level())
+		c.SetUserContext(logger.WithContext(ctx))
+		return c.Next()
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/mudler/LocalAI/core/config"
+)
+
+// CORSConfig returns the configuration of the CORS middleware. The unset options keep the permissive
+// defaults of fiber: all the origins, the common methods, the headers requested by the preflight, no
+// credentials and no caching of the preflight responses.
+func CORSConfig(appConfig *config.ApplicationConfig) (cors.Config, error) {
+	c := cors.ConfigDefault
+	if appConfig.CORSAllowOrigins != "" {
+		c.AllowOrigins = appConfig.CORSAllowOrigins
+	}
+	if appConfig.CORSAllowMethods != "" {
+		c.AllowMethods = appConfig.CORSAllowMethods
+	}
+	c.AllowHeaders = appConfig.CORSAllowHeaders
+	c.AllowCredentials = appConfig.CORSAllowCredentials
+	c.MaxAge = appConfig.CORSMaxAge
+
+	// the browsers reject the credentials of the responses allowing any origin
+	if c.AllowCredentials {
+		for _, origin := range strings.Split(c.AllowOrigins, ",") {
+			if strings.TrimSpace(origin) == "*" {
+				return cors.Config{}, errors.New("CORS credentials cannot be allowed for all the origins, set the allowed origins")
+			}
+		}
+	}
+	return c, nil
+}
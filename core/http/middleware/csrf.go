@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/csrf"
+	"github.com/mudler/LocalAI/core/config"
+)
+
+// uiRoutePrefixes are the paths of the state-modifying routes of the web UI
+var uiRoutePrefixes = []string{"/browse/"}
+
+// CSRF returns the CSRF middleware of the application.
+//
+// A cross-site request forgery rides on the credentials the browser attaches by itself to the requests,
+// the cookies. The requests authenticated with an API key in a header (Authorization: Bearer, x-api-key
+// or xi-api-key) cannot be forged this way, as a page of another site cannot set these headers without
+// passing CORS, so they are always exempt: the API clients keep working with CSRF enabled.
+//
+// With CSRFUIOnly, only the state-modifying routes of the web UI are protected, so that the API clients
+// without an API key keep working too.
+func CSRF(appConfig *config.ApplicationConfig) fiber.Handler {
+	return csrf.New(csrf.Config{
+		Next: func(c *fiber.Ctx) bool {
+			if hasAPIKeyHeader(c) {
+				return true
+			}
+			return appConfig.CSRFUIOnly && !isUIRoute(c.Path())
+		},
+	})
+}
+
+func hasAPIKeyHeader(c *fiber.Ctx) bool {
+	if strings.HasPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ") {
+		return true
+	}
+	return c.Get("x-api-key") != "" || c.Get("xi-api-key") != ""
+}
+
+func isUIRoute(path string) bool {
+	for _, prefix := range uiRoutePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/netip"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/config"
+)
+
+// IPFilter rejects with 403 the requests of the clients outside of the allow list, when it is not empty,
+// or in the deny list, which takes precedence. The client is found with ClientIP.
+func IPFilter(appConfig *config.ApplicationConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ip := ClientIP(c, appConfig)
+		if ipAllowed(ip, appConfig.IPAllowList, appConfig.IPDenyList) {
+			return c.Next()
+		}
+		if appConfig.OpaqueErrors {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+		return fiber.NewError(fiber.StatusForbidden, "access denied for the address "+ip.String())
+	}
+}
+
+func ipAllowed(ip netip.Addr, allow, deny []netip.Prefix) bool {
+	if !ip.IsValid() {
+		return len(allow) == 0 && len(deny) == 0
+	}
+	if containsAddr(deny, ip) {
+		return false
+	}
+	return len(allow) == 0 || containsAddr(allow, ip)
+}
@@ -1,13 +1,41 @@
 package routes
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/core/services"
+)
 
-func HealthRoutes(app *fiber.App) {
+// HealthRoutes registers the liveness and the readiness checks. The readiness check fails while the models
+// preloaded at startup are installed, and for good once one of them failed to install. With the deep health
+// checks enabled, it also fails while a loaded model is unhealthy, and returns the status of each model.
+func HealthRoutes(app *fiber.App, modelHealth *services.ModelHealthService, galleryService *services.GalleryService) {
 	// Service health checks
 	ok := func(c *fiber.Ctx) error {
 		return c.SendStatus(200)
 	}
 
 	app.Get("/healthz", ok)
-	app.Get("/readyz", ok)
+
+	app.Get("/readyz", func(c *fiber.Ctx) error {
+		pending := 0
+		var failed []string
+		if galleryService != nil {
+			pending = galleryService.StartupInstallsPending()
+			failed = galleryService.StartupInstallsFailed()
+		}
+		installed := pending == 0 && len(failed) == 0
+		if modelHealth == nil {
+			if installed {
+				return ok(c)
+			}
+			return c.Status(fiber.StatusServiceUnavailable).JSON(schema.HealthResponse{Models: []schema.ModelHealth{}, PendingInstalls: pending, FailedInstalls: failed})
+		}
+
+		models, healthy := modelHealth.Status()
+		if !healthy || !installed {
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+		return c.JSON(schema.HealthResponse{Models: models, PendingInstalls: pending, FailedInstalls: failed})
+	})
 }
@@ -23,13 +23,16 @@ func RegisterLocalAIRoutes(app *fiber.App,
 	if !appConfig.DisableGalleryEndpoint {
 		modelGalleryEndpointService := localai.CreateModelGalleryEndpointService(appConfig.Galleries, appConfig.ModelPath, galleryService)
 		app.Post("/models/apply", modelGalleryEndpointService.ApplyModelGalleryEndpoint())
+		app.Post("/models/apply/:uuid/cancel", modelGalleryEndpointService.CancelJobEndpoint())
 		app.Post("/models/delete/:name", modelGalleryEndpointService.DeleteModelGalleryEndpoint())
 
 		app.Get("/models/available", modelGalleryEndpointService.ListModelFromGalleryEndpoint())
 		app.Get("/models/galleries", modelGalleryEndpointService.ListModelGalleriesEndpoint())
 		app.Post("/models/galleries", modelGalleryEndpointService.AddModelGalleryEndpoint())
 		app.Delete("/models/galleries", modelGalleryEndpointService.RemoveModelGalleryEndpoint())
+		app.Post("/models/galleries/refresh", modelGalleryEndpointService.RefreshGalleriesEndpoint())
 		app.Get("/models/jobs/:uuid", modelGalleryEndpointService.GetOpStatusEndpoint())
+		app.Get("/models/jobs/:uuid/stream", modelGalleryEndpointService.GetOpStatusStreamEndpoint())
 		app.Get("/models/jobs", modelGalleryEndpointService.GetAllStatusEndpoint())
 	}
 
@@ -48,6 +51,8 @@ func RegisterLocalAIRoutes(app *fiber.App,
 	backendMonitorService := services.NewBackendMonitorService(ml, cl, appConfig) // Split out for now
 	app.Get("/backend/monitor", localai.BackendMonitorEndpoint(backendMonitorService))
 	app.Post("/backend/shutdown", localai.BackendShutdownEndpoint(backendMonitorService))
+	app.Post("/models/:name/reload", localai.ReloadModelEndpoint(cl, ml, appConfig))
+	app.Get("/system/backends/:model/logs", localai.BackendLogsEndpoint(ml))
 
 	// p2p
 	if p2p.IsP2PEnabled() {
@@ -62,8 +67,16 @@ func RegisterLocalAIRoutes(app *fiber.App,
 	})
 
 	app.Get("/system", localai.SystemInformations(ml, appConfig))
+	app.Get("/system/usage", localai.UsageEndpoint(appConfig))
+	app.Get("/system/capabilities", localai.SystemCapabilitiesEndpoint())
+	modelVerificationService := services.NewModelVerificationService(appConfig.ModelPath)
+	app.Post("/system/verify-models", localai.VerifyModelsEndpoint(modelVerificationService))
+	app.Get("/system/verify-models/:uuid", localai.VerifyModelsStatusEndpoint(modelVerificationService))
 
 	// misc
 	app.Post("/v1/tokenize", localai.TokenizeEndpoint(cl, ml, appConfig))
+	app.Post("/tokenize", localai.TokenizeEndpoint(cl, ml, appConfig))
+	app.Post("/v1/detokenize", localai.DetokenizeEndpoint(cl, ml, appConfig))
+	app.Post("/detokenize", localai.DetokenizeEndpoint(cl, ml, appConfig))
 
 }
@@ -53,12 +53,18 @@ func RegisterOpenAIRoutes(app *fiber.App,
 	app.Delete("/files/:file_id", openai.DeleteFilesEndpoint(cl, appConfig))
 	app.Get("/v1/files/:file_id/content", openai.GetFilesContentsEndpoint(cl, appConfig))
 	app.Get("/files/:file_id/content", openai.GetFilesContentsEndpoint(cl, appConfig))
+	app.Get("/system/files", openai.UploadedFilesEndpoint())
+	app.Post("/system/files/purge", openai.PurgeFilesEndpoint(appConfig))
 
 	// completion
 	app.Post("/v1/completions", openai.CompletionEndpoint(cl, ml, appConfig))
 	app.Post("/completions", openai.CompletionEndpoint(cl, ml, appConfig))
 	app.Post("/v1/engines/:model/completions", openai.CompletionEndpoint(cl, ml, appConfig))
 
+	// moderations
+	app.Post("/v1/moderations", openai.ModerationsEndpoint(cl, ml, appConfig))
+	app.Post("/moderations", openai.ModerationsEndpoint(cl, ml, appConfig))
+
 	// embeddings
 	app.Post("/v1/embeddings", openai.EmbeddingsEndpoint(cl, ml, appConfig))
 	app.Post("/embeddings", openai.EmbeddingsEndpoint(cl, ml, appConfig))
@@ -67,6 +73,7 @@ func RegisterOpenAIRoutes(app *fiber.App,
 	// audio
 	app.Post("/v1/audio/transcriptions", openai.TranscriptEndpoint(cl, ml, appConfig))
 	app.Post("/v1/audio/speech", localai.TTSEndpoint(cl, ml, appConfig))
+	app.Get("/v1/audio/voices", localai.ListVoicesEndpoint(cl, ml, appConfig))
 
 	// images
 	app.Post("/v1/images/generations", openai.ImageEndpoint(cl, ml, appConfig))
@@ -80,6 +87,6 @@ func RegisterOpenAIRoutes(app *fiber.App,
 	}
 
 	// List models
-	app.Get("/v1/models", openai.ListModelsEndpoint(cl, ml))
-	app.Get("/models", openai.ListModelsEndpoint(cl, ml))
+	app.Get("/v1/models", openai.ListModelsEndpoint(cl, ml, appConfig))
+	app.Get("/models", openai.ListModelsEndpoint(cl, ml, appConfig))
 }
@@ -14,8 +14,8 @@ import (
 	"github.com/mudler/LocalAI/core/services"
 	"github.com/mudler/LocalAI/internal"
 	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/mudler/LocalAI/pkg/xlog"
 	"github.com/mudler/LocalAI/pkg/xsync"
-	"github.com/rs/zerolog/log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -124,7 +124,11 @@ func RegisterUIRoutes(app *fiber.App,
 		app.Get("/browse", func(c *fiber.Ctx) error {
 			term := c.Query("term")
 
-			models, _ := gallery.AvailableGalleryModels(appConfig.Galleries, appConfig.ModelPath)
+			// In offline mode the galleries cannot be fetched
+			var models []*gallery.GalleryModel
+			if !appConfig.OfflineMode {
+				models, _ = gallery.AvailableGalleryModels(appConfig.Galleries, appConfig.ModelPath)
+			}
 
 			// Get all available tags
 			allTags := map[string]struct{}{}
@@ -174,7 +178,11 @@ func RegisterUIRoutes(app *fiber.App,
 				return c.Status(fiber.StatusBadRequest).SendString(err.Error())
 			}
 
-			models, _ := gallery.AvailableGalleryModels(appConfig.Galleries, appConfig.ModelPath)
+			// In offline mode the galleries cannot be fetched
+			var models []*gallery.GalleryModel
+			if !appConfig.OfflineMode {
+				models, _ = gallery.AvailableGalleryModels(appConfig.Galleries, appConfig.ModelPath)
+			}
 
 			return c.SendString(elements.ListModels(gallery.GalleryModels(models).Search(form.Search), processingModels, galleryService))
 		})
@@ -189,7 +197,7 @@ func RegisterUIRoutes(app *fiber.App,
 		// https://htmx.org/examples/progress-bar/
 		app.Post("/browse/install/model/:id", func(c *fiber.Ctx) error {
 			galleryID := strings.Clone(c.Params("id")) // note: strings.Clone is required for multiple requests!
-			log.Debug().Msgf("UI job submitted to install  : %+v\n", galleryID)
+			xlog.HTTP.Debug().Msgf("UI job submitted to install  : %+v\n", galleryID)
 
 			id, err := uuid.NewUUID()
 			if err != nil {
@@ -216,7 +224,7 @@ func RegisterUIRoutes(app *fiber.App,
 		// https://htmx.org/examples/progress-bar/
 		app.Post("/browse/delete/model/:id", func(c *fiber.Ctx) error {
 			galleryID := strings.Clone(c.Params("id")) // note: strings.Clone is required for multiple requests!
-			log.Debug().Msgf("UI job submitted to delete  : %+v\n", galleryID)
+			xlog.HTTP.Debug().Msgf("UI job submitted to delete  : %+v\n", galleryID)
 			var galleryName = galleryID
 			if strings.Contains(galleryID, "@") {
 				// if the galleryID contains a @ it means that it's a model from a gallery
@@ -286,10 +294,10 @@ func RegisterUIRoutes(app *fiber.App,
 			galleryID := ""
 			processingModels.DeleteUUID(jobUID)
 			if galleryID == "" {
-				log.Debug().Msgf("no processing model found for job : %+v\n", jobUID)
+				xlog.HTTP.Debug().Msgf("no processing model found for job : %+v\n", jobUID)
 			}
 
-			log.Debug().Msgf("JOB finished  : %+v\n", status)
+			xlog.HTTP.Debug().Msgf("JOB finished  : %+v\n", status)
 			showDelete := true
 			displayText := "Installation completed"
 			if status.Deletion {
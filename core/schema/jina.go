@@ -6,6 +6,7 @@ type JINARerankRequest struct {
 	Query     string   `json:"query"`
 	Documents []string `json:"documents"`
 	TopN      int      `json:"top_n"`
+	Backend   string   `json:"backend,omitempty"`
 }
 
 // DocumentResult represents a single document result
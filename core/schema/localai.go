@@ -1,6 +1,8 @@
 package schema
 
 import (
+	"time"
+
 	"github.com/mudler/LocalAI/core/p2p"
 	"github.com/mudler/LocalAI/pkg/model"
 	gopsutil "github.com/shirou/gopsutil/v3/process"
@@ -32,6 +34,24 @@ type TTSRequest struct {
 	Voice    string `json:"voice" yaml:"voice"` // voice audio file or speaker id
 	Backend  string `json:"backend" yaml:"backend"`
 	Language string `json:"language,omitempty" yaml:"language,omitempty"` // (optional) language to use with TTS model
+
+	ResponseFormat string  `json:"response_format,omitempty" yaml:"response_format,omitempty"` // (optional) mp3, opus, aac, flac, wav or pcm, the audio of the backend by default
+	Speed          float64 `json:"speed,omitempty" yaml:"speed,omitempty"`                     // (optional) from 0.25 to 4.0, 1.0 by default
+}
+
+// Voice is a voice of a TTS model, as used by the voice of the speech requests
+type Voice struct {
+	ID         string         `json:"id"`
+	Model      string         `json:"model"`
+	Backend    string         `json:"backend"`
+	Language   string         `json:"language,omitempty"`
+	SampleRate int            `json:"sample_rate,omitempty"`
+	Speakers   map[string]int `json:"speakers,omitempty"` // the speaker ids of the models with multiple speakers
+}
+
+type VoicesResponse struct {
+	Object string  `json:"object"`
+	Data   []Voice `json:"data"`
 }
 
 type StoresSet struct {
@@ -80,3 +100,98 @@ type SystemInformationResponse struct {
 	Backends []string      `json:"backends"`
 	Models   []model.Model `json:"loaded_models"`
 }
+
+// GPUInformation is a graphics card of the system
+type GPUInformation struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Driver  string `json:"driver"`
+}
+
+// SystemCapabilitiesResponse is the hardware of the node, for the schedulers placing the models
+type SystemCapabilitiesResponse struct {
+	// CPUCapabilities are the CPU flags, such as avx, avx2 or avx512f
+	CPUCapabilities  []string         `json:"cpu_capabilities"`
+	CPUPhysicalCores int              `json:"cpu_physical_cores"`
+	GPUs             []GPUInformation `json:"gpus"`
+}
+
+// UsageCounters are the requests and the tokens accounted to an API key
+type UsageCounters struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// KeyUsage is the usage of an API key, identified by a hash of the key, in total and per model
+type KeyUsage struct {
+	Key   string `json:"key"`
+	Label string `json:"label,omitempty"`
+	UsageCounters
+	Models map[string]UsageCounters `json:"models"`
+}
+
+type UsageResponse struct {
+	Keys []KeyUsage `json:"keys"`
+}
+
+type BackendLogsResponse struct {
+	Model string                 `json:"model"`
+	Lines []model.BackendLogLine `json:"lines"`
+}
+
+// UploadedFilesResponse lists the uploaded files, oldest first, with the size of the store
+type UploadedFilesResponse struct {
+	Files      []File `json:"files"`
+	Count      int    `json:"count"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+// PurgeFilesRequest selects the uploaded files to delete: the ones older than OlderThan (e.g. "72h"),
+// then the oldest ones until the store fits in MaxTotalBytes
+type PurgeFilesRequest struct {
+	OlderThan     string `json:"older_than,omitempty"`
+	MaxTotalBytes int64  `json:"max_total_bytes,omitempty"`
+}
+
+type PurgeFilesResponse struct {
+	Deleted    []File `json:"deleted"`
+	FreedBytes int64  `json:"freed_bytes"`
+	Remaining  int    `json:"remaining"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+// ModelHealth is the result of the deep health checks of a loaded model
+type ModelHealth struct {
+	Model               string    `json:"model"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastProbe           time.Time `json:"last_probe"`
+}
+
+type HealthResponse struct {
+	Models []ModelHealth `json:"models"`
+	// PendingInstalls is the number of models preloaded at startup not installed yet
+	PendingInstalls int `json:"pending_installs,omitempty"`
+	// FailedInstalls are the names of the models preloaded at startup whose install failed
+	FailedInstalls []string `json:"failed_installs,omitempty"`
+}
+
+// ConfigChange is a field of a model configuration changed by a reload, identified by its YAML path
+type ConfigChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// ModelReloadResponse lists the changes applied by the reload of a model configuration, and whether
+// its backend was loaded again
+type ModelReloadResponse struct {
+	Model    string         `json:"model"`
+	Changes  []ConfigChange `json:"changes"`
+	Reloaded bool           `json:"reloaded"`
+}
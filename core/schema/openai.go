@@ -23,16 +23,65 @@ type OpenAIUsage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+
+	// LocalAI extension, not part of the OpenAI API
+	Timings *UsageTimings `json:"localai_timings,omitempty"`
+}
+
+type PromptTokensDetails struct {
+	// CachedTokens is the number of prompt tokens reused from the prompt cache
+	CachedTokens int `json:"cached_tokens"`
+}
+
+type UsageTimings struct {
+	PromptProcessingMs float64 `json:"prompt_processing_ms"`
+	TokenGenerationMs  float64 `json:"token_generation_ms"`
+	TokensPerSecond    float64 `json:"tokens_per_second"`
 }
 
 type Item struct {
 	Embedding []float32 `json:"embedding"`
 	Index     int       `json:"index"`
 	Object    string    `json:"object,omitempty"`
+	// TruncatedTokens is the number of tokens dropped from the input of an embedding over the context
+	TruncatedTokens int `json:"truncated_tokens,omitempty"`
+	// Dimensions is the number of dimensions of an embedding
+	Dimensions int `json:"dimensions,omitempty"`
 
 	// Images
 	URL     string `json:"url,omitempty"`
 	B64JSON string `json:"b64_json,omitempty"`
+	// Flagged is set on the generated images flagged by the image safety model
+	Flagged bool `json:"flagged,omitempty"`
+}
+
+// ImageStreamEvent is sent for every diffusion step when the image generation is streamed,
+// and once at the end with the images
+type ImageStreamEvent struct {
+	Type string `json:"type"`
+	// Image is the index of the first image the step applies to, and Images their number
+	Image  int    `json:"image"`
+	Images int    `json:"images,omitempty"`
+	Step   int    `json:"step,omitempty"`
+	Steps  int    `json:"steps,omitempty"`
+	Data   []Item `json:"data,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ModerationResponse is the response of the OpenAI moderations API
+type ModerationResponse struct {
+	ID      string             `json:"id"`
+	Model   string             `json:"model"`
+	Results []ModerationResult `json:"results"`
+}
+
+// ModerationResult holds the moderation outcome of a single input
+type ModerationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
 }
 
 type OpenAIResponse struct {
@@ -52,6 +101,34 @@ type Choice struct {
 	Message      *Message `json:"message,omitempty"`
 	Delta        *Message `json:"delta,omitempty"`
 	Text         string   `json:"text,omitempty"`
+	// Logprobs is a *ChatLogprobs in chat completions, a *CompletionLogprobs in completions
+	Logprobs interface{} `json:"logprobs,omitempty"`
+}
+
+// ChatLogprobs are the log probabilities of the tokens of a chat completion choice
+type ChatLogprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+type TokenLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+// CompletionLogprobs are the log probabilities of the tokens of a completion choice
+type CompletionLogprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []float64            `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
+	TextOffset    []int                `json:"text_offset"`
 }
 
 type Content struct {
@@ -99,9 +176,44 @@ type FunctionCall struct {
 	Arguments string `json:"arguments"`
 }
 
+// StreamOptions are LocalAI extensions batching the chunks of the streamed responses, overriding the
+// settings of the server
+type StreamOptions struct {
+	// BatchTokens is the number of tokens merged in each chunk, 0 or 1 sends each token in its own chunk
+	BatchTokens *int `json:"batch_tokens,omitempty"`
+	// FlushIntervalMs is the minimum time between two writes of the chunks to the connection, 0 writes
+	// each chunk as it is generated
+	FlushIntervalMs *int `json:"flush_interval_ms,omitempty"`
+}
+
 type OpenAIModel struct {
 	ID     string `json:"id"`
 	Object string `json:"object"`
+
+	// Capabilities are not present in the OpenAI API, and are reported only for configured models
+	Capabilities *ModelCapabilities `json:"capabilities,omitempty"`
+}
+
+// ModelCapabilities describes what a model can be used for, as derived from its config and backend
+type ModelCapabilities struct {
+	Chat            bool `json:"chat"`
+	Completion      bool `json:"completion"`
+	Embeddings      bool `json:"embeddings"`
+	Image           bool `json:"image"`
+	Transcription   bool `json:"transcription"`
+	TTS             bool `json:"tts"`
+	SoundGeneration bool `json:"sound_generation"`
+	Rerank          bool `json:"rerank"`
+	Moderation      bool `json:"moderation"`
+	Tools           bool `json:"tools"`
+
+	// ContextSize is the context size the model is loaded with
+	ContextSize int `json:"context_size,omitempty"`
+	// MaxContextLength is the context length of the model, read from the GGUF metadata and extended by
+	// the RoPE scaling of its config
+	MaxContextLength int `json:"max_context_length,omitempty"`
+	// TrainedContextLength is the context length the model was trained with, when the RoPE scaling extends it
+	TrainedContextLength int `json:"trained_context_length,omitempty"`
 }
 
 type DeleteAssistantResponse struct {
@@ -149,9 +261,9 @@ type JsonSchemaRequest struct {
 }
 
 type JsonSchema struct {
-	Name   string         `json:"name"`
-	Strict bool           `json:"strict"`
-	Schema functions.Item `json:"schema"`
+	Name   string                 `json:"name"`
+	Strict bool                   `json:"strict"`
+	Schema map[string]interface{} `json:"schema"`
 }
 
 type OpenAIRequest struct {
@@ -168,11 +280,21 @@ type OpenAIRequest struct {
 	Size string `json:"size"`
 	// Prompt is read only by completion/image API calls
 	Prompt interface{} `json:"prompt" yaml:"prompt"`
+	// Suffix is the text following the completion, read only by completion API calls
+	Suffix string `json:"suffix" yaml:"suffix"`
 
 	// Edit endpoint
 	Instruction string      `json:"instruction" yaml:"instruction"`
 	Input       interface{} `json:"input" yaml:"input"`
 
+	// Truncate is how the embeddings API truncates the inputs over the context of the model: none
+	// (the default, the backend fails), start or end, the side of the input the tokens are dropped from
+	Truncate string `json:"truncate" yaml:"truncate"`
+	// Pooling is the pooling of the embeddings: mean, cls or last, the one of the model config by default
+	Pooling string `json:"pooling" yaml:"pooling"`
+	// Dimensions is the number of dimensions the embeddings are reduced to, for the Matryoshka models
+	Dimensions int `json:"dimensions" yaml:"dimensions"`
+
 	Stop interface{} `json:"stop" yaml:"stop"`
 
 	// Messages is read only by chat/completion API calls
@@ -186,10 +308,16 @@ type OpenAIRequest struct {
 	ToolsChoice interface{}      `json:"tool_choice,omitempty" yaml:"tool_choice"`
 
 	Stream bool `json:"stream"`
+	// StreamOptions tune how the chunks of the stream are sent
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
 
 	// Image (not supported by OpenAI)
 	Mode int `json:"mode"`
 	Step int `json:"step"`
+	// Mask is the inpainting mask (URL or base64), applied to the init image in File
+	Mask string `json:"mask"`
+	// Strength controls how much the init image is transformed (0 to 1)
+	Strength float32 `json:"strength"`
 
 	// A grammar to constrain the LLM output
 	Grammar string `json:"grammar" yaml:"grammar"`
@@ -1,5 +1,7 @@
 package schema
 
+import "encoding/json"
+
 type PredictionOptions struct {
 
 	// Also part of the OpenAI official spec
@@ -11,9 +13,16 @@ type PredictionOptions struct {
 	// Only for audio transcription
 	Translate bool `json:"translate"`
 
+	// Only for audio transcription: label the segments with their speaker turn, if the backend supports it
+	Diarization bool `json:"diarization"`
+
 	// Also part of the OpenAI official spec. use it for returning multiple results
 	N int `json:"n"`
 
+	// Also part of the OpenAI official spec: return the log probabilities of the generated tokens
+	Logprobs    LogprobsOption `json:"logprobs" yaml:"-"`
+	TopLogprobs *int           `json:"top_logprobs" yaml:"-"`
+
 	// Common options between all the API calls, part of the OpenAI spec
 	TopP        *float64 `json:"top_p" yaml:"top_p"`
 	TopK        *int     `json:"top_k" yaml:"top_k"`
@@ -37,6 +46,17 @@ type PredictionOptions struct {
 	TypicalP *float64 `json:"typical_p" yaml:"typical_p"`
 	Seed     *int     `json:"seed" yaml:"seed"`
 
+	// Deterministic pins the seed and disables nondeterministic paths
+	// (e.g. prompt cache reuse) in the backends that support it
+	Deterministic bool `json:"deterministic" yaml:"deterministic"`
+
+	// PromptCacheKey groups the requests sharing a prompt prefix (e.g. a long system prompt),
+	// so that the backend can reuse the cached prefix across them
+	PromptCacheKey string `json:"prompt_cache_key" yaml:"prompt_cache_key"`
+
+	// Adapter is the name of the LoRA adapter (from lora_adapters in the model config) to apply
+	Adapter string `json:"adapter" yaml:"adapter"`
+
 	NegativePrompt      string  `json:"negative_prompt" yaml:"negative_prompt"`
 	RopeFreqBase        float32 `json:"rope_freq_base" yaml:"rope_freq_base"`
 	RopeFreqScale       float32 `json:"rope_freq_scale" yaml:"rope_freq_scale"`
@@ -50,3 +70,43 @@ type PredictionOptions struct {
 	// RWKV (?)
 	Tokenizer string `json:"tokenizer" yaml:"tokenizer"`
 }
+
+// TopLogprobsCount returns the number of most likely tokens to return at each position
+func (p PredictionOptions) TopLogprobsCount() int {
+	if p.TopLogprobs != nil {
+		return *p.TopLogprobs
+	}
+	return p.Logprobs.Top
+}
+
+// LogprobsOption is the `logprobs` parameter of the requests: a boolean in chat
+// completions, or the number of most likely tokens to return in completions
+type LogprobsOption struct {
+	Enabled bool
+	Top     int
+}
+
+func (l *LogprobsOption) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		*l = LogprobsOption{Enabled: enabled}
+		return nil
+	}
+
+	var top *int
+	if err := json.Unmarshal(data, &top); err != nil {
+		return err
+	}
+	*l = LogprobsOption{}
+	if top != nil {
+		*l = LogprobsOption{Enabled: true, Top: *top}
+	}
+	return nil
+}
+
+func (l LogprobsOption) MarshalJSON() ([]byte, error) {
+	if l.Top > 0 {
+		return json.Marshal(l.Top)
+	}
+	return json.Marshal(l.Enabled)
+}
@@ -7,4 +7,14 @@ type TokenizeRequest struct {
 
 type TokenizeResponse struct {
 	Tokens []int32 `json:"tokens"`
+	Count  int     `json:"count"`
+}
+
+type DetokenizeRequest struct {
+	Tokens []int32 `json:"tokens"`
+	Model  string  `json:"model"`
+}
+
+type DetokenizeResponse struct {
+	Content string `json:"content"`
 }
@@ -8,9 +8,23 @@ type Segment struct {
 	End    time.Duration `json:"end"`
 	Text   string        `json:"text"`
 	Tokens []int         `json:"tokens"`
+	// SpeakerTurn counts the changes of speaker before the segment when diarization is requested and supported
+	// by the backend. It is omitted for the first turn, and a speaker talking again starts a new turn.
+	SpeakerTurn int `json:"speaker_turn,omitempty"`
 }
 
 type TranscriptionResult struct {
 	Segments []Segment `json:"segments"`
 	Text     string    `json:"text"`
 }
+
+// TranscriptionStreamEvent is sent for every segment when the transcription is streamed,
+// and once at the end with the whole transcription
+type TranscriptionStreamEvent struct {
+	Type     string    `json:"type"`
+	Delta    string    `json:"delta,omitempty"`
+	Segment  *Segment  `json:"segment,omitempty"`
+	Text     string    `json:"text,omitempty"`
+	Segments []Segment `json:"segments,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
@@ -1,13 +1,18 @@
 package services
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
 
+	"github.com/google/uuid"
 	"github.com/mudler/LocalAI/core/config"
 	"github.com/mudler/LocalAI/core/gallery"
 	"github.com/mudler/LocalAI/pkg/startup"
@@ -15,22 +20,33 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// ErrOfflineMode is returned by gallery operations that require network access when offline mode is enabled
+var ErrOfflineMode = errors.New("offline mode is enabled: downloading models from galleries is disabled")
+
+// ErrOpNotRunning is returned when trying to cancel an operation that is not in progress
+var ErrOpNotRunning = errors.New("operation is not running")
+
 type GalleryService struct {
 	appConfig *config.ApplicationConfig
 	sync.Mutex
-	C        chan gallery.GalleryOp
-	statuses map[string]*gallery.GalleryOpStatus
+	C             chan gallery.GalleryOp
+	statuses      map[string]*gallery.GalleryOpStatus
+	cancellations map[string]context.CancelFunc
+	startupFailed []string
+
+	startupPending atomic.Int32
 }
 
 func NewGalleryService(appConfig *config.ApplicationConfig) *GalleryService {
 	return &GalleryService{
-		appConfig: appConfig,
-		C:         make(chan gallery.GalleryOp),
-		statuses:  make(map[string]*gallery.GalleryOpStatus),
+		appConfig:     appConfig,
+		C:             make(chan gallery.GalleryOp),
+		statuses:      make(map[string]*gallery.GalleryOpStatus),
+		cancellations: make(map[string]context.CancelFunc),
 	}
 }
 
-func prepareModel(modelPath string, req gallery.GalleryModel, downloadStatus func(string, string, string, float64), enforceScan bool) error {
+func prepareModel(ctx context.Context, modelPath string, req gallery.GalleryModel, downloadStatus func(string, string, string, float64), enforceScan bool, trustedKeys []string) error {
 
 	config, err := gallery.GetGalleryConfigFromURL(req.URL, modelPath)
 	if err != nil {
@@ -39,7 +55,28 @@ func prepareModel(modelPath string, req gallery.GalleryModel, downloadStatus fun
 
 	config.Files = append(config.Files, req.AdditionalFiles...)
 
-	return gallery.InstallModel(modelPath, req.Name, &config, req.Overrides, downloadStatus, enforceScan)
+	return gallery.InstallModel(ctx, modelPath, req.Name, &config, req.Overrides, downloadStatus, enforceScan, trustedKeys)
+}
+
+// dryRunModel reports the files the install of a model from its config URL would download, without installing it
+func dryRunModel(ctx context.Context, modelPath string, req gallery.GalleryModel) (*gallery.InstallReport, error) {
+	config, err := gallery.GetGalleryConfigFromURL(req.URL, modelPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Files = append(config.Files, req.AdditionalFiles...)
+
+	return gallery.PlanInstall(ctx, modelPath, req.Name, &config)
+}
+
+// DryRun reports the files the install of a model would download and whether they fit on the disk, by its id
+// in the galleries or by the URL of its config
+func (g *GalleryService) DryRun(ctx context.Context, id string, req gallery.GalleryModel, galleries []config.Gallery) (*gallery.InstallReport, error) {
+	if id != "" {
+		return gallery.DryRunModelFromGallery(ctx, galleries, id, g.appConfig.ModelPath, req)
+	}
+	return dryRunModel(ctx, g.appConfig.ModelPath, req)
 }
 
 func (g *GalleryService) UpdateStatus(s string, op *gallery.GalleryOpStatus) {
@@ -62,6 +99,105 @@ func (g *GalleryService) GetAllStatus() map[string]*gallery.GalleryOpStatus {
 	return g.statuses
 }
 
+// CancelOperation cancels an operation that is in progress
+func (g *GalleryService) CancelOperation(s string) error {
+	g.Lock()
+	defer g.Unlock()
+
+	cancel, ok := g.cancellations[s]
+	if !ok {
+		return ErrOpNotRunning
+	}
+	cancel()
+	delete(g.cancellations, s)
+	return nil
+}
+
+// finish sets the final status of an operation. The operation can't be cancelled afterwards, and it is reported
+// as cancelled if it was cancelled before, whatever its outcome.
+func (g *GalleryService) finish(ctx context.Context, op gallery.GalleryOp, status *gallery.GalleryOpStatus) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.cancellations, op.Id)
+	if ctx.Err() != nil {
+		status = &gallery.GalleryOpStatus{Cancelled: true, Processed: true, GalleryModelName: op.GalleryModelName, Message: "cancelled"}
+	}
+	g.statuses[op.Id] = status
+}
+
+func (g *GalleryService) setCancellation(s string, cancel context.CancelFunc) {
+	g.Lock()
+	defer g.Unlock()
+	g.cancellations[s] = cancel
+}
+
+func (g *GalleryService) removeCancellation(s string) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.cancellations, s)
+}
+
+// Offline returns true if the gallery service refuses to download models
+func (g *GalleryService) Offline() bool {
+	return g.appConfig.OfflineMode
+}
+
+// StartupInstallsPending returns the number of models preloaded at startup not installed yet
+func (g *GalleryService) StartupInstallsPending() int {
+	return int(g.startupPending.Load())
+}
+
+// StartupInstallsFailed returns the names of the models preloaded at startup whose install failed
+func (g *GalleryService) StartupInstallsFailed() []string {
+	g.Lock()
+	defer g.Unlock()
+
+	return slices.Clone(g.startupFailed)
+}
+
+// QueueStartupInstalls queues the installs of the models to preload of the application config, instead of
+// installing them before the API starts. The installs are counted as pending until they are processed,
+// whether they succeed or not, and the failed ones are kept to be reported by the readiness check.
+func (g *GalleryService) QueueStartupInstalls() error {
+	var requests []galleryModel
+	if g.appConfig.PreloadJSONModels != "" {
+		if err := json.Unmarshal([]byte(g.appConfig.PreloadJSONModels), &requests); err != nil {
+			return err
+		}
+	}
+	if g.appConfig.PreloadModelsFromPath != "" {
+		dat, err := os.ReadFile(g.appConfig.PreloadModelsFromPath)
+		if err != nil {
+			return err
+		}
+		var fromFile []galleryModel
+		if err := yaml.Unmarshal(dat, &fromFile); err != nil {
+			return err
+		}
+		requests = append(requests, fromFile...)
+	}
+
+	g.startupPending.Add(int32(len(requests)))
+	go func() {
+		for _, r := range requests {
+			op := gallery.GalleryOp{
+				Id:               uuid.New().String(),
+				GalleryModelName: r.ID,
+				Req:              r.GalleryModel,
+				Galleries:        g.appConfig.Galleries,
+				Startup:          true,
+			}
+			g.UpdateStatus(op.Id, &gallery.GalleryOpStatus{Message: "waiting", GalleryModelName: r.ID})
+			select {
+			case g.C <- op:
+			case <-g.appConfig.Context.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
 func (g *GalleryService) Start(c context.Context, cl *config.BackendConfigLoader) {
 	go func() {
 		for {
@@ -69,105 +205,126 @@ func (g *GalleryService) Start(c context.Context, cl *config.BackendConfigLoader
 			case <-c.Done():
 				return
 			case op := <-g.C:
-				utils.ResetDownloadTimers()
-
-				g.UpdateStatus(op.Id, &gallery.GalleryOpStatus{Message: "processing", Progress: 0})
-
-				// updates the status with an error
-				var updateError func(e error)
-				if !g.appConfig.OpaqueErrors {
-					updateError = func(e error) {
-						g.UpdateStatus(op.Id, &gallery.GalleryOpStatus{Error: e, Processed: true, Message: "error: " + e.Error()})
-					}
-				} else {
-					updateError = func(_ error) {
-						g.UpdateStatus(op.Id, &gallery.GalleryOpStatus{Error: fmt.Errorf("an error occurred"), Processed: true})
+				g.process(c, cl, op)
+				if op.Startup {
+					g.Lock()
+					if s := g.statuses[op.Id]; s == nil || s.Error != nil || s.Cancelled {
+						g.startupFailed = append(g.startupFailed, cmp.Or(op.GalleryModelName, op.Req.Name, op.Req.URL))
 					}
+					g.Unlock()
+					g.startupPending.Add(-1)
 				}
+			}
+		}
+	}()
+}
 
-				// displayDownload displays the download progress
-				progressCallback := func(fileName string, current string, total string, percentage float64) {
-					g.UpdateStatus(op.Id, &gallery.GalleryOpStatus{Message: "processing", FileName: fileName, Progress: percentage, TotalFileSize: total, DownloadedFileSize: current})
-					utils.DisplayDownloadFunction(fileName, current, total, percentage)
-				}
+func (g *GalleryService) process(c context.Context, cl *config.BackendConfigLoader, op gallery.GalleryOp) {
+	utils.ResetDownloadTimers()
 
-				var err error
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+	g.setCancellation(op.Id, cancel)
+	defer g.removeCancellation(op.Id)
 
-				// delete a model
-				if op.Delete {
-					modelConfig := &config.BackendConfig{}
+	g.UpdateStatus(op.Id, &gallery.GalleryOpStatus{Message: "processing", Progress: 0})
 
-					// Galleryname is the name of the model in this case
-					dat, err := os.ReadFile(filepath.Join(g.appConfig.ModelPath, op.GalleryModelName+".yaml"))
-					if err != nil {
-						updateError(err)
-						continue
-					}
-					err = yaml.Unmarshal(dat, modelConfig)
-					if err != nil {
-						updateError(err)
-						continue
-					}
+	// updates the status with an error
+	var updateError func(e error)
+	if !g.appConfig.OpaqueErrors {
+		updateError = func(e error) {
+			g.finish(ctx, op, &gallery.GalleryOpStatus{Error: e, Processed: true, Message: "error: " + e.Error()})
+		}
+	} else {
+		updateError = func(_ error) {
+			g.finish(ctx, op, &gallery.GalleryOpStatus{Error: fmt.Errorf("an error occurred"), Processed: true})
+		}
+	}
 
-					files := []string{}
-					// Remove the model from the config
-					if modelConfig.Model != "" {
-						files = append(files, modelConfig.ModelFileName())
-					}
+	// displayDownload displays the download progress
+	progressCallback := func(fileName string, current string, total string, percentage float64) {
+		g.UpdateStatus(op.Id, &gallery.GalleryOpStatus{Message: "processing", FileName: fileName, Progress: percentage, TotalFileSize: total, DownloadedFileSize: current})
+		utils.DisplayDownloadFunction(fileName, current, total, percentage)
+	}
 
-					if modelConfig.MMProj != "" {
-						files = append(files, modelConfig.MMProjFileName())
-					}
+	var err error
 
-					err = gallery.DeleteModelFromSystem(g.appConfig.ModelPath, op.GalleryModelName, files)
-					if err != nil {
-						updateError(err)
-						continue
-					}
-				} else {
-					// if the request contains a gallery name, we apply the gallery from the gallery list
-					if op.GalleryModelName != "" {
-						err = gallery.InstallModelFromGallery(op.Galleries, op.GalleryModelName, g.appConfig.ModelPath, op.Req, progressCallback, g.appConfig.EnforcePredownloadScans)
-					} else if op.ConfigURL != "" {
-						err = startup.InstallModels(op.Galleries, op.ConfigURL, g.appConfig.ModelPath, g.appConfig.EnforcePredownloadScans, progressCallback, op.ConfigURL)
-						if err != nil {
-							updateError(err)
-							continue
-						}
-						err = cl.Preload(g.appConfig.ModelPath)
-					} else {
-						err = prepareModel(g.appConfig.ModelPath, op.Req, progressCallback, g.appConfig.EnforcePredownloadScans)
-					}
-				}
+	// delete a model
+	if op.Delete {
+		modelConfig := &config.BackendConfig{}
 
-				if err != nil {
-					updateError(err)
-					continue
-				}
+		// Galleryname is the name of the model in this case
+		dat, err := os.ReadFile(filepath.Join(g.appConfig.ModelPath, op.GalleryModelName+".yaml"))
+		if err != nil {
+			updateError(err)
+			return
+		}
+		err = yaml.Unmarshal(dat, modelConfig)
+		if err != nil {
+			updateError(err)
+			return
+		}
 
-				// Reload models
-				err = cl.LoadBackendConfigsFromPath(g.appConfig.ModelPath)
-				if err != nil {
-					updateError(err)
-					continue
-				}
+		files := []string{}
+		// Remove the model from the config
+		if modelConfig.Model != "" {
+			files = append(files, modelConfig.ModelFileName())
+		}
 
-				err = cl.Preload(g.appConfig.ModelPath)
-				if err != nil {
-					updateError(err)
-					continue
-				}
+		if modelConfig.MMProj != "" {
+			files = append(files, modelConfig.MMProjFileName())
+		}
 
-				g.UpdateStatus(op.Id,
-					&gallery.GalleryOpStatus{
-						Deletion:         op.Delete,
-						Processed:        true,
-						GalleryModelName: op.GalleryModelName,
-						Message:          "completed",
-						Progress:         100})
+		err = gallery.DeleteModelFromSystem(g.appConfig.ModelPath, op.GalleryModelName, files)
+		if err != nil {
+			updateError(err)
+			return
+		}
+	} else if g.appConfig.OfflineMode {
+		updateError(ErrOfflineMode)
+		return
+	} else {
+		// if the request contains a gallery name, we apply the gallery from the gallery list
+		if op.GalleryModelName != "" {
+			err = gallery.InstallModelFromGallery(ctx, op.Galleries, op.GalleryModelName, g.appConfig.ModelPath, op.Req, progressCallback, g.appConfig.EnforcePredownloadScans, g.appConfig.TrustedKeys)
+		} else if op.ConfigURL != "" {
+			err = startup.InstallModels(ctx, op.Galleries, g.appConfig.ModelLibraryURL, g.appConfig.ModelPath, g.appConfig.EnforcePredownloadScans, g.appConfig.TrustedKeys, progressCallback, op.ConfigURL)
+			if err == nil {
+				err = cl.Preload(g.appConfig.ModelPath)
 			}
+		} else {
+			err = prepareModel(ctx, g.appConfig.ModelPath, op.Req, progressCallback, g.appConfig.EnforcePredownloadScans, g.appConfig.TrustedKeys)
 		}
-	}()
+	}
+
+	if err != nil {
+		// reported as cancelled if ctx was cancelled
+		updateError(err)
+		return
+	}
+
+	// Reload models
+	err = cl.LoadBackendConfigsFromPath(g.appConfig.ModelPath)
+	if err != nil {
+		updateError(err)
+		return
+	}
+
+	if !g.appConfig.OfflineMode {
+		err = cl.Preload(g.appConfig.ModelPath)
+		if err != nil {
+			updateError(err)
+			return
+		}
+	}
+
+	g.finish(ctx, op,
+		&gallery.GalleryOpStatus{
+			Deletion:         op.Delete,
+			Processed:        true,
+			GalleryModelName: op.GalleryModelName,
+			Message:          "completed",
+			Progress:         100})
 }
 
 type galleryModel struct {
@@ -175,22 +332,22 @@ type galleryModel struct {
 	ID                   string           `json:"id"`
 }
 
-func processRequests(modelPath string, enforceScan bool, galleries []config.Gallery, requests []galleryModel) error {
+func processRequests(modelPath string, enforceScan bool, trustedKeys []string, galleries []config.Gallery, requests []galleryModel) error {
 	var err error
 	for _, r := range requests {
 		utils.ResetDownloadTimers()
 		if r.ID == "" {
-			err = prepareModel(modelPath, r.GalleryModel, utils.DisplayDownloadFunction, enforceScan)
+			err = prepareModel(context.Background(), modelPath, r.GalleryModel, utils.DisplayDownloadFunction, enforceScan, trustedKeys)
 
 		} else {
-			err = gallery.InstallModelFromGallery(
-				galleries, r.ID, modelPath, r.GalleryModel, utils.DisplayDownloadFunction, enforceScan)
+			err = gallery.InstallModelFromGallery(context.Background(),
+				galleries, r.ID, modelPath, r.GalleryModel, utils.DisplayDownloadFunction, enforceScan, trustedKeys)
 		}
 	}
 	return err
 }
 
-func ApplyGalleryFromFile(modelPath, s string, enforceScan bool, galleries []config.Gallery) error {
+func ApplyGalleryFromFile(modelPath, s string, enforceScan bool, trustedKeys []string, galleries []config.Gallery) error {
 	dat, err := os.ReadFile(s)
 	if err != nil {
 		return err
@@ -201,15 +358,15 @@ func ApplyGalleryFromFile(modelPath, s string, enforceScan bool, galleries []con
 		return err
 	}
 
-	return processRequests(modelPath, enforceScan, galleries, requests)
+	return processRequests(modelPath, enforceScan, trustedKeys, galleries, requests)
 }
 
-func ApplyGalleryFromString(modelPath, s string, enforceScan bool, galleries []config.Gallery) error {
+func ApplyGalleryFromString(modelPath, s string, enforceScan bool, trustedKeys []string, galleries []config.Gallery) error {
 	var requests []galleryModel
 	err := json.Unmarshal([]byte(s), &requests)
 	if err != nil {
 		return err
 	}
 
-	return processRequests(modelPath, enforceScan, galleries, requests)
+	return processRequests(modelPath, enforceScan, trustedKeys, galleries, requests)
 }
@@ -0,0 +1,101 @@
+package services_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/gallery"
+	. "github.com/mudler/LocalAI/core/services"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GalleryService", func() {
+	It("counts the startup installs as pending until they are processed", func() {
+		modelPath := GinkgoT().TempDir()
+		// the local gallery configs are read from the models path
+		Expect(os.Mkdir(filepath.Join(modelPath, "gallery"), 0750)).To(Succeed())
+		galleryConfig := filepath.Join(modelPath, "gallery", "model.yaml")
+		Expect(os.WriteFile(galleryConfig, []byte("name: model\nconfig_file: |\n  backend: llama-cpp\n"), 0600)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		appConfig := config.NewApplicationConfig(
+			config.WithContext(ctx),
+			config.WithModelPath(modelPath),
+			config.WithJSONStringPreload(`[{"url": "file://`+galleryConfig+`", "name": "first"}, {"url": "file://`+galleryConfig+`", "name": "second"}]`),
+			config.EnablePreloadInBackground,
+		)
+		g := NewGalleryService(appConfig)
+		Expect(g.QueueStartupInstalls()).To(Succeed())
+		Expect(g.StartupInstallsPending()).To(Equal(2))
+
+		cl := config.NewBackendConfigLoader(modelPath)
+		g.Start(ctx, cl)
+		Eventually(g.StartupInstallsPending).Should(BeZero())
+		Expect(g.StartupInstallsFailed()).To(BeEmpty())
+		for _, s := range g.GetAllStatus() {
+			Expect(s.Error).ToNot(HaveOccurred())
+		}
+		Expect(filepath.Join(modelPath, "first.yaml")).To(BeARegularFile())
+		Expect(filepath.Join(modelPath, "second.yaml")).To(BeARegularFile())
+		_, exists := cl.GetBackendConfig("second")
+		Expect(exists).To(BeTrue())
+	})
+
+	It("cancels the installs from a config URL and removes their partial files", func() {
+		started := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "1024")
+			w.Write(make([]byte, 100))
+			w.(http.Flusher).Flush()
+			close(started)
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		modelPath := GinkgoT().TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		g := NewGalleryService(config.NewApplicationConfig(config.WithContext(ctx), config.WithModelPath(modelPath)))
+		g.Start(ctx, config.NewBackendConfigLoader(modelPath))
+
+		g.C <- gallery.GalleryOp{Id: "op", ConfigURL: server.URL + "/model.bin"}
+		Eventually(started).Should(BeClosed())
+		Expect(g.CancelOperation("op")).To(Succeed())
+
+		Eventually(func() bool { return g.GetStatus("op").Processed }).Should(BeTrue())
+		Expect(g.GetStatus("op").Cancelled).To(BeTrue())
+		Expect(g.CancelOperation("op")).To(MatchError(ErrOpNotRunning))
+		Expect(filepath.Join(modelPath, "model.bin.partial")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(modelPath, "model.bin")).ToNot(BeAnExistingFile())
+	})
+
+	It("reports the models preloaded in background which failed to install", func() {
+		modelPath := GinkgoT().TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		appConfig := config.NewApplicationConfig(
+			config.WithContext(ctx),
+			config.WithModelPath(modelPath),
+			config.WithJSONStringPreload(`[{"url": "file://`+filepath.Join(modelPath, "missing.yaml")+`", "name": "missing"}]`),
+			config.EnablePreloadInBackground,
+		)
+		g := NewGalleryService(appConfig)
+		Expect(g.QueueStartupInstalls()).To(Succeed())
+
+		g.Start(ctx, config.NewBackendConfigLoader(modelPath))
+		Eventually(g.StartupInstallsPending).Should(BeZero())
+		Expect(g.StartupInstallsFailed()).To(Equal([]string{"missing"}))
+	})
+
+	It("fails on invalid models to preload", func() {
+		g := NewGalleryService(config.NewApplicationConfig(config.WithJSONStringPreload(`{"url":`)))
+		Expect(g.QueueStartupInstalls()).ToNot(Succeed())
+		Expect(g.StartupInstallsPending()).To(BeZero())
+	})
+})
@@ -27,6 +27,7 @@ func ListModels(bcl *config.BackendConfigLoader, ml *model.ModelLoader, filter c
 				skipMap[c.Model] = nil
 			}
 			dataModels = append(dataModels, c.Name)
+			dataModels = append(dataModels, c.Aliases...)
 		}
 	}
 
@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"time"
 
+	"github.com/mudler/LocalAI/pkg/model"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/prometheus"
@@ -11,8 +13,16 @@ import (
 )
 
 type LocalAIMetricsService struct {
-	Meter         metric.Meter
-	ApiTimeMetric metric.Float64Histogram
+	Meter                 metric.Meter
+	ApiTimeMetric         metric.Float64Histogram
+	TokensPerSecondMetric metric.Float64Histogram
+	ResponseCacheMetric   metric.Int64Counter
+	QueueWaitMetric       metric.Float64Histogram
+	TokensMetric          metric.Int64Counter
+	BackendRestartsMetric metric.Int64Counter
+	ModelLoadMetric       metric.Float64Histogram
+	ModelLoadErrorsMetric metric.Int64Counter
+	RequestTimeoutsMetric metric.Int64Counter
 }
 
 func (m *LocalAIMetricsService) ObserveAPICall(method string, path string, duration float64) {
@@ -23,6 +33,72 @@ func (m *LocalAIMetricsService) ObserveAPICall(method string, path string, durat
 	m.ApiTimeMetric.Record(context.Background(), duration, opts)
 }
 
+func (m *LocalAIMetricsService) ObserveTokensPerSecond(model string, backend string, tokensPerSecond float64) {
+	opts := metric.WithAttributes(
+		attribute.String("model", model),
+		attribute.String("backend", backend),
+	)
+	m.TokensPerSecondMetric.Record(context.Background(), tokensPerSecond, opts)
+}
+
+func (m *LocalAIMetricsService) ObserveResponseCache(model string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	opts := metric.WithAttributes(
+		attribute.String("model", model),
+		attribute.String("result", result),
+	)
+	m.ResponseCacheMetric.Add(context.Background(), 1, opts)
+}
+
+func (m *LocalAIMetricsService) ObserveRequestTimeout(model string) {
+	m.RequestTimeoutsMetric.Add(context.Background(), 1, metric.WithAttributes(attribute.String("model", model)))
+}
+
+func (m *LocalAIMetricsService) ObserveQueueWait(model string, seconds float64) {
+	opts := metric.WithAttributes(
+		attribute.String("model", model),
+	)
+	m.QueueWaitMetric.Record(context.Background(), seconds, opts)
+}
+
+// ObserveTokens counts the prompt and completion tokens of a request, labeled by the hash of its API key
+func (m *LocalAIMetricsService) ObserveTokens(apiKeyID string, model string, promptTokens, completionTokens int) {
+	for tokenType, n := range map[string]int{"prompt": promptTokens, "completion": completionTokens} {
+		opts := metric.WithAttributes(
+			attribute.String("api_key", apiKeyID),
+			attribute.String("model", model),
+			attribute.String("type", tokenType),
+		)
+		m.TokensMetric.Add(context.Background(), int64(n), opts)
+	}
+}
+
+// ObserveBackendRestart counts the restarts of the backend of a model after repeated failures
+func (m *LocalAIMetricsService) ObserveBackendRestart(model string) {
+	m.BackendRestartsMetric.Add(context.Background(), 1, metric.WithAttributes(attribute.String("model", model)))
+}
+
+// ObserveModelLoad records the duration of the successful loads of the models, and counts the failed
+// ones by category of error
+func (m *LocalAIMetricsService) ObserveModelLoad(modelID, backend string, duration time.Duration, err error) {
+	if err != nil {
+		opts := metric.WithAttributes(
+			attribute.String("backend", backend),
+			attribute.String("reason", model.LoadErrorCategory(err)),
+		)
+		m.ModelLoadErrorsMetric.Add(context.Background(), 1, opts)
+		return
+	}
+	opts := metric.WithAttributes(
+		attribute.String("model", modelID),
+		attribute.String("backend", backend),
+	)
+	m.ModelLoadMetric.Record(context.Background(), duration.Seconds(), opts)
+}
+
 // setupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
 func NewLocalAIMetricsService() (*LocalAIMetricsService, error) {
@@ -38,9 +114,57 @@ func NewLocalAIMetricsService() (*LocalAIMetricsService, error) {
 		return nil, err
 	}
 
+	tokensPerSecondMetric, err := meter.Float64Histogram("tokens_per_second", metric.WithDescription("token generation rate of completions"))
+	if err != nil {
+		return nil, err
+	}
+
+	responseCacheMetric, err := meter.Int64Counter("response_cache", metric.WithDescription("lookups in the response cache, by result (hit or miss)"))
+	if err != nil {
+		return nil, err
+	}
+
+	queueWaitMetric, err := meter.Float64Histogram("queue_wait", metric.WithDescription("time in seconds requests wait in the scheduler queue"), metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	tokensMetric, err := meter.Int64Counter("tokens", metric.WithDescription("prompt and completion tokens, by hash of the API key and model"))
+	if err != nil {
+		return nil, err
+	}
+
+	backendRestartsMetric, err := meter.Int64Counter("backend_restarts", metric.WithDescription("restarts of the backends after repeated inference failures, by model"))
+	if err != nil {
+		return nil, err
+	}
+
+	modelLoadMetric, err := meter.Float64Histogram("model_load_duration", metric.WithDescription("time in seconds the backends took to load the models"), metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	modelLoadErrorsMetric, err := meter.Int64Counter("model_load_failures", metric.WithDescription("failed loads of the models, by backend and reason (not-found, oom, timeout or other)"))
+	if err != nil {
+		return nil, err
+	}
+
+	requestTimeoutsMetric, err := meter.Int64Counter("request_timeouts", metric.WithDescription("requests cancelled by the request timeout of their model, by model"))
+	if err != nil {
+		return nil, err
+	}
+
 	return &LocalAIMetricsService{
-		Meter:         meter,
-		ApiTimeMetric: apiTimeMetric,
+		Meter:                 meter,
+		ApiTimeMetric:         apiTimeMetric,
+		TokensPerSecondMetric: tokensPerSecondMetric,
+		ResponseCacheMetric:   responseCacheMetric,
+		QueueWaitMetric:       queueWaitMetric,
+		TokensMetric:          tokensMetric,
+		BackendRestartsMetric: backendRestartsMetric,
+		ModelLoadMetric:       modelLoadMetric,
+		ModelLoadErrorsMetric: modelLoadErrorsMetric,
+		RequestTimeoutsMetric: requestTimeoutsMetric,
 	}, nil
 }
 
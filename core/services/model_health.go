@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// ModelHealthService checks that the loaded models can actually infer, as a backend may answer the
+// health checks of its gRPC server while failing every inference. It periodically runs a tiny inference
+// on each loaded model, and reloads the models failing too many probes in a row.
+type ModelHealthService struct {
+	sync.Mutex
+	backendConfigLoader *config.BackendConfigLoader
+	modelLoader         *model.ModelLoader
+	appConfig           *config.ApplicationConfig
+	status              map[string]*schema.ModelHealth
+}
+
+func NewModelHealthService(configLoader *config.BackendConfigLoader, modelLoader *model.ModelLoader, appConfig *config.ApplicationConfig) *ModelHealthService {
+	return &ModelHealthService{
+		backendConfigLoader: configLoader,
+		modelLoader:         modelLoader,
+		appConfig:           appConfig,
+		status:              map[string]*schema.ModelHealth{},
+	}
+}
+
+// Start probes the loaded models every HealthProbeInterval until the context is done
+func (h *ModelHealthService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(h.appConfig.HealthProbeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.ProbeAll(ctx)
+			}
+		}
+	}()
+}
+
+// ProbeAll runs the probe of each loaded model, one at a time
+func (h *ModelHealthService) ProbeAll(ctx context.Context) {
+	loaded := map[string]bool{}
+	models := h.modelLoader.ListModels()
+	for i := range models {
+		loaded[models[i].ID] = true
+		h.probe(ctx, models[i].ID)
+	}
+
+	// forget the models which were unloaded, unless they are unhealthy: a model whose reload failed
+	// stays unhealthy until it is loaded again and passes a probe
+	h.Lock()
+	for id, status := range h.status {
+		if !loaded[id] && status.Healthy {
+			delete(h.status, id)
+		}
+	}
+	h.Unlock()
+}
+
+func (h *ModelHealthService) probe(ctx context.Context, modelID string) {
+	cfg, exists := h.backendConfigLoader.GetBackendConfig(modelID)
+	if !exists {
+		return
+	}
+	probe := backend.InferenceProbe(cfg)
+	if probe == nil {
+		return
+	}
+	m := h.modelLoader.CheckIsLoaded(modelID)
+	if m == nil {
+		return
+	}
+	client := m.GRPC(h.appConfig.ParallelBackendRequests, nil)
+	// a busy backend would delay the probe past its timeout, it proved to work anyway
+	if client.IsBusy() {
+		return
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, h.appConfig.HealthProbeTimeout)
+	err := probe(probeCtx, client)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	h.Lock()
+	status, exists := h.status[modelID]
+	if !exists {
+		status = &schema.ModelHealth{Model: modelID}
+		h.status[modelID] = status
+	}
+	status.LastProbe = time.Now()
+	if err == nil {
+		status.Healthy = true
+		status.ConsecutiveFailures = 0
+		status.LastError = ""
+		h.Unlock()
+		return
+	}
+	status.ConsecutiveFailures++
+	status.LastError = err.Error()
+	failures := status.ConsecutiveFailures
+	unhealthy := failures >= h.appConfig.HealthProbeFailures
+	status.Healthy = !unhealthy
+	h.Unlock()
+
+	log.Warn().Err(err).Str("model", modelID).Int("failures", failures).Msg("health probe failed")
+	if unhealthy {
+		log.Error().Str("model", modelID).Msg("model is unhealthy, reloading it")
+		if err := h.modelLoader.RestartModel(modelID); err != nil {
+			log.Error().Err(err).Str("model", modelID).Msg("failed reloading the unhealthy model")
+		}
+	}
+}
+
+// Status returns the result of the last probes of each model, sorted by model, and whether all the
+// models are healthy
+func (h *ModelHealthService) Status() ([]schema.ModelHealth, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	healthy := true
+	models := make([]schema.ModelHealth, 0, len(h.status))
+	for _, status := range h.status {
+		models = append(models, *status)
+		healthy = healthy && status.Healthy
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Model < models[j].Model })
+	return models, healthy
+}
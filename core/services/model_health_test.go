@@ -0,0 +1,96 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	. "github.com/mudler/LocalAI/core/services"
+	"github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/grpc/base"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/mudler/LocalAI/pkg/model"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ModelHealthService", func() {
+	It("reloads the models failing the probes in a row", func() {
+		llm := &flakyLLM{}
+		grpc.Provide("health-test", llm)
+
+		modelPath := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(modelPath, "flaky.yaml"), []byte(`name: flaky
+backend: flaky-backend
+parameters:
+  model: flaky.model
+template:
+  use_tokenizer_template: true
+`), 0600)).To(Succeed())
+		bcl := config.NewBackendConfigLoader(modelPath)
+		Expect(bcl.LoadBackendConfigsFromPath(modelPath)).To(Succeed())
+		cfg, exists := bcl.GetBackendConfig("flaky")
+		Expect(exists).To(BeTrue())
+
+		appConfig := config.NewApplicationConfig(
+			config.WithContext(context.Background()),
+			config.WithExternalBackend("flaky-backend", "health-test"),
+			config.WithHealthProbe(time.Minute, time.Second, 2),
+		)
+		ml := model.NewModelLoader(modelPath)
+		_, err := ml.BackendLoader(backend.ModelOptions(cfg, appConfig, []model.Option{})...)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(llm.loads.Load()).To(Equal(int32(1)))
+
+		h := NewModelHealthService(bcl, ml, appConfig)
+		h.ProbeAll(context.Background())
+		models, healthy := h.Status()
+		Expect(healthy).To(BeTrue())
+		Expect(models).To(HaveLen(1))
+		Expect(models[0].Model).To(Equal("flaky"))
+
+		llm.fail.Store(true)
+		h.ProbeAll(context.Background())
+		models, healthy = h.Status()
+		Expect(healthy).To(BeTrue())
+		Expect(models[0].ConsecutiveFailures).To(Equal(1))
+		Expect(models[0].LastError).To(ContainSubstring("backend degraded"))
+
+		h.ProbeAll(context.Background())
+		models, healthy = h.Status()
+		Expect(healthy).To(BeFalse())
+		Expect(models[0].ConsecutiveFailures).To(Equal(2))
+		Expect(llm.loads.Load()).To(Equal(int32(2)))
+
+		// the reloaded model works again
+		llm.fail.Store(false)
+		h.ProbeAll(context.Background())
+		models, healthy = h.Status()
+		Expect(healthy).To(BeTrue())
+		Expect(models[0].ConsecutiveFailures).To(Equal(0))
+	})
+})
+
+// flakyLLM is a backend whose predictions fail on demand, counting its loads
+type flakyLLM struct {
+	base.Base
+	loads atomic.Int32
+	fail  atomic.Bool
+}
+
+func (llm *flakyLLM) Load(opts *pb.ModelOptions) error {
+	llm.loads.Add(1)
+	return nil
+}
+
+func (llm *flakyLLM) Predict(opts *pb.PredictOptions) (string, error) {
+	if llm.fail.Load() {
+		return "", errors.New("backend degraded")
+	}
+	return opts.Prompt, nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAI/core/gallery"
+	"github.com/rs/zerolog/log"
+)
+
+// ModelVerificationStatus is the progress of a verification of the installed models against their manifest
+type ModelVerificationStatus struct {
+	// Processed is true once the verification is over
+	Processed bool `json:"processed"`
+	// OK is true if no file is missing or changed
+	OK     bool                        `json:"ok"`
+	Models []gallery.ModelVerification `json:"models"`
+	// Error is set when the models to verify can't be listed
+	Error string `json:"error,omitempty"`
+}
+
+// ModelVerificationService runs the verifications of the installed models in the background: hashing the
+// files of large models takes minutes, longer than a request should wait
+type ModelVerificationService struct {
+	modelPath string
+
+	sync.Mutex
+	statuses map[string]*ModelVerificationStatus
+}
+
+func NewModelVerificationService(modelPath string) *ModelVerificationService {
+	return &ModelVerificationService{
+		modelPath: modelPath,
+		statuses:  make(map[string]*ModelVerificationStatus),
+	}
+}
+
+// Start verifies the given models, or all the models with a manifest when names is empty, and returns the id
+// of the verification to get its status with
+func (s *ModelVerificationService) Start(names []string) string {
+	id := uuid.New().String()
+	s.setStatus(id, &ModelVerificationStatus{Models: []gallery.ModelVerification{}})
+
+	go func() {
+		status := &ModelVerificationStatus{Processed: true, OK: true}
+		results, err := gallery.VerifyModels(s.modelPath, names)
+		if err != nil {
+			log.Error().Err(err).Str("id", id).Msg("failed to verify the models")
+			status.OK, status.Error = false, err.Error()
+		}
+		status.Models = results
+		for _, r := range results {
+			status.OK = status.OK && r.OK
+		}
+		s.setStatus(id, status)
+	}()
+	return id
+}
+
+// GetStatus returns the status of a verification, nil if there is none with the id
+func (s *ModelVerificationService) GetStatus(id string) *ModelVerificationStatus {
+	s.Lock()
+	defer s.Unlock()
+	return s.statuses[id]
+}
+
+func (s *ModelVerificationService) setStatus(id string, status *ModelVerificationStatus) {
+	s.Lock()
+	defer s.Unlock()
+	s.statuses[id] = status
+}
@@ -0,0 +1,41 @@
+package services_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/LocalAI/core/gallery"
+	. "github.com/mudler/LocalAI/core/services"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ModelVerificationService", func() {
+	It("verifies the models in the background", func() {
+		modelPath := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(modelPath, "model.bin"), []byte("model"), 0600)).To(Succeed())
+		manifest := fmt.Sprintf("- path: model.bin\n  sha256: %x\n  size: 5\n", sha256.Sum256([]byte("model")))
+		Expect(os.WriteFile(filepath.Join(modelPath, "._manifest_model.yaml"), []byte(manifest), 0600)).To(Succeed())
+
+		verifier := NewModelVerificationService(modelPath)
+		Expect(verifier.GetStatus("unknown")).To(BeNil())
+
+		id := verifier.Start(nil)
+		Expect(verifier.GetStatus(id)).ToNot(BeNil())
+		Eventually(func() bool { return verifier.GetStatus(id).Processed }).Should(BeTrue())
+		status := verifier.GetStatus(id)
+		Expect(status.OK).To(BeTrue())
+		Expect(status.Models).To(HaveLen(1))
+		Expect(status.Models[0].Files).To(HaveLen(1))
+		Expect(status.Models[0].Files[0].Status).To(Equal(gallery.FileOK))
+
+		Expect(os.WriteFile(filepath.Join(modelPath, "model.bin"), []byte("broken"), 0600)).To(Succeed())
+		id = verifier.Start([]string{"model"})
+		Eventually(func() bool { return verifier.GetStatus(id).Processed }).Should(BeTrue())
+		status = verifier.GetStatus(id)
+		Expect(status.OK).To(BeFalse())
+		Expect(status.Models[0].Files[0].Status).To(Equal(gallery.FileSizeMismatch))
+	})
+})
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/rs/zerolog/log"
+)
+
+// QuotaEnforcer limits the tokens each API key uses per window of time. The window of a key starts
+// with its first request, and the budget is reset once it ends.
+type QuotaEnforcer struct {
+	sync.Mutex
+	quotas  map[string]config.ApiKeyQuota
+	hard    bool
+	windows map[string]*quotaWindow
+}
+
+type quotaWindow struct {
+	start time.Time
+	used  int64
+	// the tokens estimated for the requests in progress
+	reserved int64
+}
+
+// QuotaReservation holds the estimated tokens of a request in the budget of its API key until the
+// request consumes its actual tokens, so that the concurrent requests of the key account for it.
+// A nil reservation is valid, for the keys without a quota.
+type QuotaReservation struct {
+	apiKey string
+	window *quotaWindow
+	tokens int64
+	done   bool
+}
+
+// NewQuotaEnforcer returns an enforcer of the quotas, rejecting the keys over budget in config.ApiKeyQuotaHard
+// mode and only logging them in config.ApiKeyQuotaSoft mode
+func NewQuotaEnforcer(quotas map[string]config.ApiKeyQuota, mode string) *QuotaEnforcer {
+	return &QuotaEnforcer{
+		quotas:  quotas,
+		hard:    mode != config.ApiKeyQuotaSoft,
+		windows: map[string]*quotaWindow{},
+	}
+}
+
+// window returns the current window of the key, starting a new one if the last is over. It must be
+// called with the lock held.
+func (q *QuotaEnforcer) window(apiKey string, quota config.ApiKeyQuota, now time.Time) *quotaWindow {
+	w, exists := q.windows[apiKey]
+	if !exists || now.Sub(w.start) >= quota.Window {
+		w = &quotaWindow{start: now}
+		q.windows[apiKey] = w
+	}
+	return w
+}
+
+// Allow reports whether the API key can make a request estimated to use the given tokens, counting the
+// estimates of its requests in progress. An allowed request reserves its estimate in the budget, until
+// it is replaced by the actual tokens with Consume or dropped with Release. When the key is over its
+// budget, Allow also returns the time left until the end of the window, when the budget is reset.
+func (q *QuotaEnforcer) Allow(apiKey string, estimate int) (bool, time.Duration, *QuotaReservation) {
+	quota, exists := q.quotas[apiKey]
+	if !exists {
+		return true, 0, nil
+	}
+
+	now := time.Now()
+	q.Lock()
+	defer q.Unlock()
+	w := q.window(apiKey, quota, now)
+	if used := w.used + w.reserved; used >= quota.Tokens {
+		if q.hard {
+			return false, w.start.Add(quota.Window).Sub(now), nil
+		}
+		log.Warn().Str("api_key", UsageKeyID(apiKey)).Int64("used", used).Int64("budget", quota.Tokens).Msg("API key over its token quota")
+	}
+
+	w.reserved += int64(estimate)
+	return true, 0, &QuotaReservation{apiKey: apiKey, window: w, tokens: int64(estimate)}
+}
+
+// Consume replaces the estimate of a request with the tokens it used, in the budget of the current window.
+// A request is allowed as long as the key is under budget, so the last one may exceed it.
+func (q *QuotaEnforcer) Consume(r *QuotaReservation, tokens int) {
+	if r == nil {
+		return
+	}
+	quota, exists := q.quotas[r.apiKey]
+	if !exists {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	q.release(r)
+	q.window(r.apiKey, quota, time.Now()).used += int64(tokens)
+}
+
+// Release drops the estimate of a request that did not consume any token, e.g. because it failed
+func (q *QuotaEnforcer) Release(r *QuotaReservation) {
+	if r == nil {
+		return
+	}
+	q.Lock()
+	defer q.Unlock()
+	q.release(r)
+}
+
+// release drops the estimate of the reservation, once. It must be called with the lock held.
+func (q *QuotaEnforcer) release(r *QuotaReservation) {
+	if r.done {
+		return
+	}
+	r.window.reserved -= r.tokens
+	r.done = true
+}
+
+// Quota returns the quota of the API key, if any
+func (q *QuotaEnforcer) Quota(apiKey string) (config.ApiKeyQuota, bool) {
+	quota, exists := q.quotas[apiKey]
+	return quota, exists
+}
@@ -0,0 +1,97 @@
+package services_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mudler/LocalAI/core/config"
+	. "github.com/mudler/LocalAI/core/services"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QuotaEnforcer", func() {
+	quotas := map[string]config.ApiKeyQuota{"key": {Tokens: 100, Window: 200 * time.Millisecond}}
+
+	It("rejects the keys over budget until the end of the window", func() {
+		q := NewQuotaEnforcer(quotas, config.ApiKeyQuotaHard)
+
+		for range 10 {
+			allowed, _, reservation := q.Allow("key", 0)
+			Expect(allowed).To(BeTrue())
+			q.Consume(reservation, 10)
+		}
+
+		allowed, retryAfter, reservation := q.Allow("key", 0)
+		Expect(allowed).To(BeFalse())
+		Expect(reservation).To(BeNil())
+		Expect(retryAfter).To(BeNumerically(">", 0))
+		Expect(retryAfter).To(BeNumerically("<=", 200*time.Millisecond))
+
+		allowed, _, reservation = q.Allow("other", 1000)
+		Expect(allowed).To(BeTrue())
+		Expect(reservation).To(BeNil())
+
+		Eventually(func() bool {
+			allowed, _, _ := q.Allow("key", 0)
+			return allowed
+		}).WithTimeout(time.Second).Should(BeTrue())
+	})
+
+	It("counts the estimates of the concurrent requests", func() {
+		q := NewQuotaEnforcer(quotas, config.ApiKeyQuotaHard)
+
+		var wg sync.WaitGroup
+		var allowed atomic.Int32
+		reservations := make(chan *QuotaReservation, 50)
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if ok, _, reservation := q.Allow("key", 10); ok {
+					allowed.Add(1)
+					reservations <- reservation
+				}
+			}()
+		}
+		wg.Wait()
+		close(reservations)
+		Expect(allowed.Load()).To(BeEquivalentTo(10))
+
+		for reservation := range reservations {
+			q.Consume(reservation, 10)
+		}
+		ok, _, _ := q.Allow("key", 0)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("replaces the estimates with the tokens used", func() {
+		q := NewQuotaEnforcer(quotas, config.ApiKeyQuotaHard)
+
+		_, _, reservation := q.Allow("key", 100)
+		ok, _, _ := q.Allow("key", 0)
+		Expect(ok).To(BeFalse())
+
+		q.Consume(reservation, 5)
+		// consuming twice adds the tokens, but only drops the estimate once
+		q.Consume(reservation, 5)
+		ok, _, reservation = q.Allow("key", 90)
+		Expect(ok).To(BeTrue())
+		ok, _, _ = q.Allow("key", 0)
+		Expect(ok).To(BeFalse())
+
+		q.Release(reservation)
+		ok, _, _ = q.Allow("key", 0)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("only logs the keys over budget in soft mode", func() {
+		q := NewQuotaEnforcer(quotas, config.ApiKeyQuotaSoft)
+		_, _, reservation := q.Allow("key", 0)
+		q.Consume(reservation, 150)
+
+		allowed, _, _ := q.Allow("key", 0)
+		Expect(allowed).To(BeTrue())
+	})
+})
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/mudler/LocalAI/core/schema"
+)
+
+// ResponseCache is an LRU cache of the responses to deterministic requests,
+// so that repeated requests are answered without running the backend again
+type ResponseCache struct {
+	lru *expirable.LRU[string, schema.OpenAIResponse]
+}
+
+// NewResponseCache returns a cache holding up to size responses for ttl (0 never expires them)
+func NewResponseCache(size int, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		lru: expirable.NewLRU[string, schema.OpenAIResponse](size, nil, ttl),
+	}
+}
+
+// ResponseCacheKey hashes the normalized request. The request must include everything
+// affecting the output, e.g. the model, the backend, the prompt and the sampling parameters.
+func ResponseCacheKey(request any) (string, error) {
+	dat, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (rc *ResponseCache) Get(key string) (schema.OpenAIResponse, bool) {
+	return rc.lru.Get(key)
+}
+
+func (rc *ResponseCache) Add(key string, resp schema.OpenAIResponse) {
+	rc.lru.Add(key, resp)
+}
+
+func (rc *ResponseCache) Len() int {
+	return rc.lru.Len()
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// SchedulerPolicyFIFO dispatches the queued requests in arrival order, regardless of the model
+	SchedulerPolicyFIFO = "fifo"
+	// SchedulerPolicyFair dispatches the queued requests across models with a weighted round-robin,
+	// so that a flood of requests to one model can not starve the others
+	SchedulerPolicyFair = "fair"
+)
+
+// RequestScheduler queues the requests to the backends, per model, and dispatches them
+// according to its policy when a slot frees up. Each model runs at most its own concurrency
+// limit at a time, and all the models together at most the scheduler's one.
+type RequestScheduler struct {
+	sync.Mutex
+	policy         string
+	maxConcurrency int
+	running        int
+	queues         map[string]*modelQueue
+}
+
+type modelQueue struct {
+	limit   int
+	weight  int
+	running int
+	// current is the smooth weighted round-robin counter of the queue
+	current int
+	waiting []*schedulerTicket
+}
+
+type schedulerTicket struct {
+	ready      chan struct{}
+	enqueued   time.Time
+	dispatched bool
+}
+
+// NewRequestScheduler returns a scheduler running up to maxConcurrency requests at a time (0 is unlimited)
+func NewRequestScheduler(policy string, maxConcurrency int) (*RequestScheduler, error) {
+	switch policy {
+	case SchedulerPolicyFIFO, SchedulerPolicyFair:
+	default:
+		return nil, fmt.Errorf("unknown scheduler policy %q (expected %q or %q)", policy, SchedulerPolicyFIFO, SchedulerPolicyFair)
+	}
+	return &RequestScheduler{
+		policy:         policy,
+		maxConcurrency: maxConcurrency,
+		queues:         map[string]*modelQueue{},
+	}, nil
+}
+
+// Acquire waits for a slot to run a request to the model, which runs up to limit requests
+// at a time (0 is unlimited) and is weighted against the other models by weight.
+// It returns the function releasing the slot, and how long the request was queued.
+func (rs *RequestScheduler) Acquire(ctx context.Context, model string, limit, weight int) (func(), time.Duration, error) {
+	if weight <= 0 {
+		weight = 1
+	}
+	t := &schedulerTicket{ready: make(chan struct{}), enqueued: time.Now()}
+
+	rs.Lock()
+	q, exists := rs.queues[model]
+	if !exists {
+		q = &modelQueue{}
+		rs.queues[model] = q
+	}
+	q.limit, q.weight = limit, weight
+	q.waiting = append(q.waiting, t)
+	rs.dispatch()
+	rs.Unlock()
+
+	release := func() {
+		rs.Lock()
+		defer rs.Unlock()
+		q.running--
+		rs.running--
+		rs.cleanup(model, q)
+		rs.dispatch()
+	}
+
+	select {
+	case <-t.ready:
+		return sync.OnceFunc(release), time.Since(t.enqueued), nil
+	case <-ctx.Done():
+		rs.Lock()
+		dispatched := t.dispatched
+		if !dispatched {
+			for i, w := range q.waiting {
+				if w == t {
+					q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+					break
+				}
+			}
+			rs.cleanup(model, q)
+		}
+		rs.Unlock()
+		// the request was dispatched while being canceled: give the slot back
+		if dispatched {
+			release()
+		}
+		return nil, time.Since(t.enqueued), ctx.Err()
+	}
+}
+
+// Queued returns the number of requests waiting for a slot
+func (rs *RequestScheduler) Queued() int {
+	rs.Lock()
+	defer rs.Unlock()
+	n := 0
+	for _, q := range rs.queues {
+		n += len(q.waiting)
+	}
+	return n
+}
+
+// dispatch starts queued requests while there are free slots. Must be called with the lock held.
+func (rs *RequestScheduler) dispatch() {
+	for rs.maxConcurrency <= 0 || rs.running < rs.maxConcurrency {
+		q := rs.next()
+		if q == nil {
+			break
+		}
+		t := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.running++
+		rs.running++
+		t.dispatched = true
+		close(t.ready)
+	}
+}
+
+// next picks the queue to dispatch from, among the ones with waiting requests and
+// below their concurrency limit
+func (rs *RequestScheduler) next() *modelQueue {
+	var (
+		selected      *modelQueue
+		selectedModel string
+		totalWeight   int
+	)
+	for model, q := range rs.queues {
+		if len(q.waiting) == 0 || (q.limit > 0 && q.running >= q.limit) {
+			continue
+		}
+		switch rs.policy {
+		case SchedulerPolicyFIFO:
+			if selected == nil || q.waiting[0].enqueued.Before(selected.waiting[0].enqueued) {
+				selected, selectedModel = q, model
+			}
+		case SchedulerPolicyFair:
+			// smooth weighted round-robin, as in nginx
+			q.current += q.weight
+			totalWeight += q.weight
+			if selected == nil || q.current > selected.current || (q.current == selected.current && model < selectedModel) {
+				selected, selectedModel = q, model
+			}
+		}
+	}
+	if selected != nil && rs.policy == SchedulerPolicyFair {
+		selected.current -= totalWeight
+	}
+	return selected
+}
+
+// cleanup forgets the queue of a model once idle. Must be called with the lock held.
+func (rs *RequestScheduler) cleanup(model string, q *modelQueue) {
+	if len(q.waiting) == 0 && q.running == 0 {
+		delete(rs.queues, model)
+	}
+}
@@ -0,0 +1,105 @@
+package services_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/mudler/LocalAI/core/services"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestScheduler", func() {
+	// enqueue queues a request to the model in the background, and sends its model
+	// on order once dispatched
+	enqueue := func(rs *RequestScheduler, model string, weight int, order chan<- string, releases chan<- func()) {
+		queued := rs.Queued()
+		go func() {
+			defer GinkgoRecover()
+			release, _, err := rs.Acquire(context.Background(), model, 0, weight)
+			Expect(err).ToNot(HaveOccurred())
+			order <- model
+			releases <- release
+		}()
+		Eventually(rs.Queued).Should(Equal(queued + 1))
+	}
+
+	It("rejects unknown policies", func() {
+		_, err := NewRequestScheduler("lifo", 1)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("limits the concurrency of each model", func() {
+		rs, err := NewRequestScheduler(SchedulerPolicyFair, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		release, wait, err := rs.Acquire(context.Background(), "a", 1, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(wait).To(BeNumerically("<", time.Second))
+
+		// another model is not held back
+		releaseB, _, err := rs.Acquire(context.Background(), "b", 1, 1)
+		Expect(err).ToNot(HaveOccurred())
+		releaseB()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, _, err = rs.Acquire(ctx, "a", 1, 1)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(rs.Queued()).To(BeZero())
+
+		release()
+		release, _, err = rs.Acquire(context.Background(), "a", 1, 1)
+		Expect(err).ToNot(HaveOccurred())
+		release()
+	})
+
+	It("dispatches fairly across models", func() {
+		rs, err := NewRequestScheduler(SchedulerPolicyFair, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		release, _, err := rs.Acquire(context.Background(), "busy", 0, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		order := make(chan string, 6)
+		releases := make(chan func(), 6)
+		for range 4 {
+			enqueue(rs, "heavy", 1, order, releases)
+		}
+		enqueue(rs, "light", 1, order, releases)
+		Eventually(rs.Queued).Should(Equal(5))
+
+		release()
+		dispatched := []string{}
+		for range 5 {
+			dispatched = append(dispatched, <-order)
+			(<-releases)()
+		}
+		// the light model is served before the heavy queue drains
+		Expect(dispatched[:2]).To(ContainElement("light"))
+	})
+
+	It("dispatches in arrival order with the fifo policy", func() {
+		rs, err := NewRequestScheduler(SchedulerPolicyFIFO, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		release, _, err := rs.Acquire(context.Background(), "busy", 0, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		order := make(chan string, 6)
+		releases := make(chan func(), 6)
+		for range 3 {
+			enqueue(rs, "heavy", 1, order, releases)
+		}
+		enqueue(rs, "light", 1, order, releases)
+		Eventually(rs.Queued).Should(Equal(4))
+
+		release()
+		dispatched := []string{}
+		for range 4 {
+			dispatched = append(dispatched, <-order)
+			(<-releases)()
+		}
+		Expect(dispatched).To(Equal([]string{"heavy", "heavy", "heavy", "light"}))
+	})
+})
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ShutdownHooks is the registry of the work the features of the application run when it shuts down,
+// such as flushing their state to disk
+type ShutdownHooks struct {
+	sync.Mutex
+	hooks []func() error
+}
+
+func NewShutdownHooks() *ShutdownHooks {
+	return &ShutdownHooks{}
+}
+
+// RegisterShutdownHook adds a hook run on shutdown, after the ones registered before it
+func (s *ShutdownHooks) RegisterShutdownHook(fn func() error) {
+	s.Lock()
+	defer s.Unlock()
+	s.hooks = append(s.hooks, fn)
+}
+
+// Run runs the hooks in order. A failing hook is logged, and does not prevent the next ones from running.
+func (s *ShutdownHooks) Run() error {
+	s.Lock()
+	hooks := s.hooks
+	s.Unlock()
+
+	for i, fn := range hooks {
+		if err := fn(); err != nil {
+			log.Error().Err(err).Int("hook", i).Msg("shutdown hook failed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package services_test
+
+import (
+	"errors"
+
+	. "github.com/mudler/LocalAI/core/services"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ShutdownHooks", func() {
+	It("runs all the hooks in order, even after a failure", func() {
+		hooks := NewShutdownHooks()
+		var ran []int
+		hooks.RegisterShutdownHook(func() error { ran = append(ran, 1); return nil })
+		hooks.RegisterShutdownHook(func() error { ran = append(ran, 2); return errors.New("failed") })
+		hooks.RegisterShutdownHook(func() error { ran = append(ran, 3); return nil })
+
+		Expect(hooks.Run()).To(Succeed())
+		Expect(ran).To(Equal([]int{1, 2, 3}))
+	})
+})
@@ -0,0 +1,139 @@
+package services_test
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/mudler/LocalAI/core/services"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// traceCollector receives the spans exported over gRPC
+type traceCollector struct {
+	coltracepb.UnimplementedTraceServiceServer
+	requests chan *coltracepb.ExportTraceServiceRequest
+	headers  chan metadata.MD
+}
+
+func (c *traceCollector) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.headers <- md
+	c.requests <- req
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+var _ = Describe("OTLP trace exporter", func() {
+	// exportSpans exports a child span with attributes and an error status, and returns the IDs of its parent
+	exportSpans := func(exporter sdktrace.SpanExporter) (parentTraceID, parentSpanID string) {
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		DeferCleanup(provider.Shutdown, context.Background())
+		tracer := provider.Tracer("test")
+		ctx, parent := tracer.Start(context.Background(), "parent")
+		_, child := tracer.Start(ctx, "child")
+		child.SetAttributes(attribute.String("model", "gpt-4"), attribute.Int("prompt_tokens", 12))
+		child.SetStatus(codes.Error, "failed")
+		child.End()
+		return parent.SpanContext().TraceID().String(), parent.SpanContext().SpanID().String()
+	}
+
+	expectChildSpan := func(req *coltracepb.ExportTraceServiceRequest, traceID, parentSpanID string) {
+		scopeSpans := req.ResourceSpans[0].ScopeSpans[0]
+		Expect(scopeSpans.Scope.Name).To(Equal("test"))
+		span := scopeSpans.Spans[0]
+		Expect(span.Name).To(Equal("child"))
+		Expect(hex.EncodeToString(span.TraceId)).To(Equal(traceID))
+		Expect(hex.EncodeToString(span.ParentSpanId)).To(Equal(parentSpanID))
+		Expect(span.Status.Code).To(Equal(tracepb.Status_STATUS_CODE_ERROR))
+		Expect(span.Status.Message).To(Equal("failed"))
+		var keys []string
+		for _, a := range span.Attributes {
+			keys = append(keys, a.Key)
+		}
+		Expect(keys).To(ConsistOf("model", "prompt_tokens"))
+	}
+
+	It("rejects the unsupported protocols", func() {
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+		_, err := NewOTLPTraceExporter(context.Background())
+		Expect(err).To(MatchError(ContainSubstring(`unsupported OTLP protocol "http/json"`)))
+	})
+
+	It("exports the spans over HTTP with protobuf by default", func() {
+		requests := make(chan *http.Request, 2)
+		bodies := make(chan *coltracepb.ExportTraceServiceRequest, 2)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			data, err := io.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			req := &coltracepb.ExportTraceServiceRequest{}
+			Expect(proto.Unmarshal(data, req)).To(Succeed())
+			requests <- r
+			bodies <- req
+		}))
+		DeferCleanup(server.Close)
+
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS", "authorization=Bearer%20secret")
+		exporter, err := NewOTLPTraceExporter(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		traceID, parentSpanID := exportSpans(exporter)
+		r := <-requests
+		Expect(r.URL.Path).To(Equal("/v1/traces"))
+		Expect(r.Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+		Expect(r.Header.Get("Authorization")).To(Equal("Bearer secret"))
+		expectChildSpan(<-bodies, traceID, parentSpanID)
+	})
+
+	It("exports the spans over gRPC", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		collector := &traceCollector{
+			requests: make(chan *coltracepb.ExportTraceServiceRequest, 2),
+			headers:  make(chan metadata.MD, 2),
+		}
+		server := grpc.NewServer()
+		coltracepb.RegisterTraceServiceServer(server, collector)
+		go server.Serve(listener)
+		DeferCleanup(server.Stop)
+
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://"+listener.Addr().String())
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer%20secret")
+		exporter, err := NewOTLPTraceExporter(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		traceID, parentSpanID := exportSpans(exporter)
+		Expect((<-collector.headers).Get("authorization")).To(ConsistOf("Bearer secret"))
+		expectChildSpan(<-collector.requests, traceID, parentSpanID)
+	})
+
+	It("fails when the collector rejects the spans", func() {
+		rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		DeferCleanup(rejecting.Close)
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", rejecting.URL+"/custom")
+		exporter, err := NewOTLPTraceExporter(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		provider := sdktrace.NewTracerProvider()
+		_, span := provider.Tracer("test").Start(context.Background(), "span")
+		span.End()
+		err = exporter.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{span.(sdktrace.ReadOnlySpan)})
+		Expect(err).To(MatchError(ContainSubstring("400")))
+	})
+})
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/rs/zerolog/log"
+)
+
+// anonymousKey identifies the requests without an API key, when the API is not protected
+const anonymousKey = "anonymous"
+
+// UsageTracker accounts the requests and the tokens of each API key, per model. The keys are
+// identified by a short hash, so that they are neither stored nor exposed in the metrics.
+type UsageTracker struct {
+	sync.Mutex
+	path    string
+	metrics *LocalAIMetricsService
+	// usage maps the key hashes to the counters of each model
+	usage map[string]map[string]*schema.UsageCounters
+	dirty bool
+}
+
+// NewUsageTracker returns a tracker persisting the counters to path, if not empty, and restoring
+// the ones saved by a previous run. The metrics service, if any, gets the tokens labeled by key hash.
+func NewUsageTracker(path string, metrics *LocalAIMetricsService) (*UsageTracker, error) {
+	ut := &UsageTracker{
+		path:    path,
+		metrics: metrics,
+		usage:   map[string]map[string]*schema.UsageCounters{},
+	}
+	if path == "" {
+		return ut, nil
+	}
+
+	dat, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ut, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(dat, &ut.usage); err != nil {
+		return nil, err
+	}
+	return ut, nil
+}
+
+// UsageKeyID returns the hash identifying an API key in the usage and the metrics
+func UsageKeyID(apiKey string) string {
+	if apiKey == "" {
+		return anonymousKey
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Record accounts a request to the model, and its tokens, to the API key
+func (ut *UsageTracker) Record(apiKey, model string, promptTokens, completionTokens int) {
+	key := UsageKeyID(apiKey)
+
+	ut.Lock()
+	models, exists := ut.usage[key]
+	if !exists {
+		models = map[string]*schema.UsageCounters{}
+		ut.usage[key] = models
+	}
+	counters, exists := models[model]
+	if !exists {
+		counters = &schema.UsageCounters{}
+		models[model] = counters
+	}
+	counters.Requests++
+	counters.PromptTokens += int64(promptTokens)
+	counters.CompletionTokens += int64(completionTokens)
+	counters.TotalTokens += int64(promptTokens + completionTokens)
+	ut.dirty = true
+	ut.Unlock()
+
+	if ut.metrics != nil {
+		ut.metrics.ObserveTokens(key, model, promptTokens, completionTokens)
+	}
+}
+
+// Usage returns the usage of every API key, sorted by key hash
+func (ut *UsageTracker) Usage() []schema.KeyUsage {
+	ut.Lock()
+	defer ut.Unlock()
+
+	usage := make([]schema.KeyUsage, 0, len(ut.usage))
+	for key, models := range ut.usage {
+		ku := schema.KeyUsage{Key: key, Models: map[string]schema.UsageCounters{}}
+		for model, counters := range models {
+			ku.Models[model] = *counters
+			ku.Requests += counters.Requests
+			ku.PromptTokens += counters.PromptTokens
+			ku.CompletionTokens += counters.CompletionTokens
+			ku.TotalTokens += counters.TotalTokens
+		}
+		usage = append(usage, ku)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Key < usage[j].Key })
+	return usage
+}
+
+// Save writes the counters to the file of the tracker, if they changed since the last save
+func (ut *UsageTracker) Save() error {
+	if ut.path == "" {
+		return nil
+	}
+
+	ut.Lock()
+	if !ut.dirty {
+		ut.Unlock()
+		return nil
+	}
+	dat, err := json.Marshal(ut.usage)
+	ut.dirty = false
+	ut.Unlock()
+
+	if err == nil {
+		err = writeFileAtomic(ut.path, dat)
+	}
+	if err != nil {
+		// retry with the next save
+		ut.Lock()
+		ut.dirty = true
+		ut.Unlock()
+	}
+	return err
+}
+
+// writeFileAtomic writes to a temporary file first, so that a crash does not leave a truncated file behind
+func writeFileAtomic(path string, dat []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(dat); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Start saves the counters every interval until the context is done
+func (ut *UsageTracker) Start(ctx context.Context, interval time.Duration) {
+	if ut.path == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ut.Save(); err != nil {
+					log.Error().Err(err).Str("path", ut.path).Msg("failed saving the usage of the API keys")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,55 @@
+package services_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/LocalAI/core/schema"
+	. "github.com/mudler/LocalAI/core/services"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UsageTracker", func() {
+	It("accounts the tokens to the hash of the API keys", func() {
+		tracker, err := NewUsageTracker("", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		tracker.Record("secret", "llama", 10, 5)
+		tracker.Record("secret", "llama", 3, 2)
+		tracker.Record("secret", "phi", 1, 1)
+		tracker.Record("", "llama", 4, 0)
+
+		usage := tracker.Usage()
+		Expect(usage).To(HaveLen(2))
+		Expect(usage[0].Key).To(Equal(UsageKeyID("secret")))
+		Expect(usage[0].Key).ToNot(ContainSubstring("secret"))
+		Expect(usage[0].UsageCounters).To(Equal(schema.UsageCounters{Requests: 3, PromptTokens: 14, CompletionTokens: 8, TotalTokens: 22}))
+		Expect(usage[0].Models).To(HaveKeyWithValue("llama", schema.UsageCounters{Requests: 2, PromptTokens: 13, CompletionTokens: 7, TotalTokens: 20}))
+		Expect(usage[0].Models).To(HaveKeyWithValue("phi", schema.UsageCounters{Requests: 1, PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2}))
+
+		Expect(usage[1].Key).To(Equal("anonymous"))
+		Expect(usage[1].UsageCounters).To(Equal(schema.UsageCounters{Requests: 1, PromptTokens: 4, TotalTokens: 4}))
+	})
+
+	It("restores the counters saved to disk", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "usage.json")
+		tracker, err := NewUsageTracker(path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tracker.Usage()).To(BeEmpty())
+
+		tracker.Record("secret", "llama", 10, 5)
+		Expect(tracker.Save()).To(Succeed())
+		Expect(path).To(BeAnExistingFile())
+		dat, err := os.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(dat)).ToNot(ContainSubstring("secret"))
+
+		restored, err := NewUsageTracker(path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restored.Usage()).To(Equal(tracker.Usage()))
+
+		restored.Record("secret", "llama", 1, 1)
+		Expect(restored.Usage()[0].Requests).To(Equal(int64(2)))
+	})
+})
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// piperVoiceConfig is the part of the .onnx.json config of the piper voices describing the voice
+type piperVoiceConfig struct {
+	Audio struct {
+		SampleRate int `json:"sample_rate"`
+	} `json:"audio"`
+	Espeak struct {
+		Voice string `json:"voice"`
+	} `json:"espeak"`
+	Language struct {
+		Code string `json:"code"`
+	} `json:"language"`
+	SpeakerIDMap map[string]int `json:"speaker_id_map"`
+}
+
+// ListVoices returns the voices declared by the voices of the model configs, followed by the piper voices
+// installed in the model paths, each sorted by model and id. A piper voice is a .onnx model with its .onnx.json config,
+// which gives its language and sample rate.
+func ListVoices(bcl *config.BackendConfigLoader, ml *model.ModelLoader) []schema.Voice {
+	piperVoices := listPiperVoices(ml.ModelPaths())
+	installed := map[string]schema.Voice{}
+	for _, v := range piperVoices {
+		installed[v.ID] = v
+	}
+
+	voices := []schema.Voice{}
+	for _, c := range bcl.GetAllBackendConfigs() {
+		backend := cmp.Or(c.Backend, model.PiperBackend)
+		for id, backendVoice := range c.Voices {
+			voice := schema.Voice{ID: id, Model: c.Name, Backend: backend}
+			if v, exists := installed[backendVoice]; exists && backend == model.PiperBackend {
+				voice.Language, voice.SampleRate, voice.Speakers = v.Language, v.SampleRate, v.Speakers
+			}
+			voices = append(voices, voice)
+		}
+	}
+	sortVoices(voices)
+
+	return append(voices, piperVoices...)
+}
+
+func listPiperVoices(paths []string) []schema.Voice {
+	voices := []schema.Voice{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		files, err := os.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			name := f.Name()
+			if f.IsDir() || filepath.Ext(name) != ".onnx" || seen[name] {
+				continue
+			}
+			dat, err := os.ReadFile(filepath.Join(path, name+".json"))
+			if err != nil {
+				// not a piper voice, e.g. a model of another backend
+				continue
+			}
+			var cfg piperVoiceConfig
+			if err := json.Unmarshal(dat, &cfg); err != nil {
+				log.Warn().Err(err).Str("voice", name).Msg("cannot parse the config of the piper voice")
+				continue
+			}
+			seen[name] = true
+			voices = append(voices, schema.Voice{
+				ID:         name,
+				Model:      name,
+				Backend:    model.PiperBackend,
+				Language:   cmp.Or(cfg.Language.Code, cfg.Espeak.Voice),
+				SampleRate: cfg.Audio.SampleRate,
+				Speakers:   cfg.SpeakerIDMap,
+			})
+		}
+	}
+	sortVoices(voices)
+	return voices
+}
+
+func sortVoices(voices []schema.Voice) {
+	slices.SortFunc(voices, func(a, b schema.Voice) int {
+		return cmp.Or(strings.Compare(a.Model, b.Model), strings.Compare(a.ID, b.ID))
+	})
+}
@@ -0,0 +1,53 @@
+package services_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	. "github.com/mudler/LocalAI/core/services"
+	"github.com/mudler/LocalAI/pkg/model"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ListVoices", func() {
+	It("lists the voices of the configs and the installed piper voices", func() {
+		modelPath := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(modelPath, "tts-1.yaml"), []byte(`name: tts-1
+backend: piper
+parameters:
+  model: en-us-amy-low.onnx
+tts:
+  voices:
+    nova: en-us-amy-low.onnx
+    alloy: unknown.onnx
+`), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(modelPath, "en-us-amy-low.onnx"), []byte{}, 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(modelPath, "en-us-amy-low.onnx.json"), []byte(`{
+  "audio": {"sample_rate": 16000},
+  "espeak": {"voice": "en-us"},
+  "language": {"code": "en_US"},
+  "speaker_id_map": {}
+}`), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(modelPath, "libritts.onnx"), []byte{}, 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(modelPath, "libritts.onnx.json"), []byte(`{
+  "audio": {"sample_rate": 22050},
+  "espeak": {"voice": "en-us"},
+  "speaker_id_map": {"p3922": 0, "p8699": 1}
+}`), 0600)).To(Succeed())
+		// a model of another backend, without a piper config
+		Expect(os.WriteFile(filepath.Join(modelPath, "other.onnx"), []byte{}, 0600)).To(Succeed())
+
+		bcl := config.NewBackendConfigLoader(modelPath)
+		Expect(bcl.LoadBackendConfigsFromPath(modelPath)).To(Succeed())
+
+		Expect(ListVoices(bcl, model.NewModelLoader(modelPath))).To(Equal([]schema.Voice{
+			{ID: "alloy", Model: "tts-1", Backend: "piper"},
+			{ID: "nova", Model: "tts-1", Backend: "piper", Language: "en_US", SampleRate: 16000, Speakers: map[string]int{}},
+			{ID: "en-us-amy-low.onnx", Model: "en-us-amy-low.onnx", Backend: "piper", Language: "en_US", SampleRate: 16000, Speakers: map[string]int{}},
+			{ID: "libritts.onnx", Model: "libritts.onnx", Backend: "piper", Language: "en-us", SampleRate: 22050, Speakers: map[string]int{"p3922": 0, "p8699": 1}},
+		}))
+	})
+})
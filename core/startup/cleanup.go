@@ -0,0 +1,60 @@
+package startup
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/schema"
+	"github.com/mudler/LocalAI/pkg/utils"
+	"github.com/mudler/LocalAI/pkg/xlog"
+)
+
+// cleanupStaleFiles removes the files older than CleanupMaxAge from the upload, audio and image
+// directories, which accumulate the leftovers of the previous runs when an instance crashes and restarts.
+// The uploaded files still listed in the persisted uploads, and the list itself, are kept.
+func cleanupStaleFiles(options *config.ApplicationConfig, now time.Time) {
+	keep := map[string]bool{}
+	if options.UploadDir != "" {
+		uploaded := []schema.File{}
+		utils.LoadConfig(options.UploadDir, config.UploadedFilesFile, &uploaded)
+		keep[filepath.Clean(filepath.Join(options.UploadDir, config.UploadedFilesFile))] = true
+		for _, f := range uploaded {
+			keep[filepath.Clean(filepath.Join(options.UploadDir, utils.SanitizeFileName(f.Filename)))] = true
+		}
+	}
+
+	cutoff := now.Add(-options.CleanupMaxAge)
+	var files, bytes int64
+	for _, dir := range []string{options.UploadDir, options.AudioDir, options.ImageDir} {
+		if dir == "" {
+			continue
+		}
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || keep[filepath.Clean(path)] {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+				return nil
+			}
+			if err := os.Remove(path); err != nil {
+				xlog.Startup.Warn().Err(err).Str("file", path).Msg("cannot remove stale file")
+				return nil
+			}
+			files++
+			bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			xlog.Startup.Warn().Err(err).Str("dir", dir).Msg("cannot clean up the stale files")
+		}
+	}
+
+	xlog.Startup.Info().Int64("files", files).Int64("bytes", bytes).Dur("max_age", options.CleanupMaxAge).Msg("cleaned up the stale files")
+}
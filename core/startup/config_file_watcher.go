@@ -6,12 +6,13 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"dario.cat/mergo"
+	"github.com/fsnotify/fsnotify"
 	"github.com/mudler/LocalAI/core/config"
-	"github.com/rs/zerolog/log"
+	"github.com/mudler/LocalAI/pkg/xlog"
 )
 
 type fileHandler func(fileContent []byte, appConfig *config.ApplicationConfig) error
@@ -33,11 +34,11 @@ func newConfigFileHandler(appConfig *config.ApplicationConfig) configFileHandler
 	}
 	err := c.Register("api_keys.json", readApiKeysJson(*appConfig), true)
 	if err != nil {
-		log.Error().Err(err).Str("file", "api_keys.json").Msg("unable to register config file handler")
+		xlog.Startup.Error().Err(err).Str("file", "api_keys.json").Msg("unable to register config file handler")
 	}
 	err = c.Register("external_backends.json", readExternalBackendsJson(*appConfig), true)
 	if err != nil {
-		log.Error().Err(err).Str("file", "external_backends.json").Msg("unable to register config file handler")
+		xlog.Startup.Error().Err(err).Str("file", "external_backends.json").Msg("unable to register config file handler")
 	}
 	return c
 }
@@ -56,14 +57,14 @@ func (c *configFileHandler) Register(filename string, handler fileHandler, runNo
 
 func (c *configFileHandler) callHandler(filename string, handler fileHandler) {
 	rootedFilePath := filepath.Join(c.appConfig.DynamicConfigsDir, filepath.Clean(filename))
-	log.Trace().Str("filename", rootedFilePath).Msg("reading file for dynamic config update")
+	xlog.Startup.Trace().Str("filename", rootedFilePath).Msg("reading file for dynamic config update")
 	fileContent, err := os.ReadFile(rootedFilePath)
 	if err != nil && !os.IsNotExist(err) {
-		log.Error().Err(err).Str("filename", rootedFilePath).Msg("could not read file")
+		xlog.Startup.Error().Err(err).Str("filename", rootedFilePath).Msg("could not read file")
 	}
 
 	if err = handler(fileContent, c.appConfig); err != nil {
-		log.Error().Err(err).Msg("WatchConfigDirectory goroutine failed to update options")
+		xlog.Startup.Error().Err(err).Msg("WatchConfigDirectory goroutine failed to update options")
 	}
 }
 
@@ -75,13 +76,13 @@ func (c *configFileHandler) Watch() error {
 	}
 
 	if c.appConfig.DynamicConfigsDirPollInterval > 0 {
-		log.Debug().Msg("Poll interval set, falling back to polling for configuration changes")
+		xlog.Startup.Debug().Msg("Poll interval set, falling back to polling for configuration changes")
 		ticker := time.NewTicker(c.appConfig.DynamicConfigsDirPollInterval)
 		go func() {
 			for {
 				<-ticker.C
 				for file, handler := range c.handlers {
-					log.Debug().Str("file", file).Msg("polling config file")
+					xlog.Startup.Debug().Str("file", file).Msg("polling config file")
 					c.callHandler(file, handler)
 				}
 			}
@@ -96,7 +97,7 @@ func (c *configFileHandler) Watch() error {
 				if !ok {
 					return
 				}
-				if event.Has(fsnotify.Write | fsnotify.Create | fsnotify.Remove) {
+				if event.Has(fsnotify.Write | fsnotify.Create | fsnotify.Remove | fsnotify.Rename) {
 					handler, ok := c.handlers[path.Base(event.Name)]
 					if !ok {
 						continue
@@ -105,7 +106,7 @@ func (c *configFileHandler) Watch() error {
 					c.callHandler(filepath.Base(event.Name), handler)
 				}
 			case err, ok := <-c.watcher.Errors:
-				log.Error().Err(err).Msg("config watcher error received")
+				xlog.Startup.Error().Err(err).Msg("config watcher error received")
 				if !ok {
 					return
 				}
@@ -129,25 +130,34 @@ func (c *configFileHandler) Stop() error {
 
 func readApiKeysJson(startupAppConfig config.ApplicationConfig) fileHandler {
 	handler := func(fileContent []byte, appConfig *config.ApplicationConfig) error {
-		log.Debug().Msg("processing api keys runtime update")
-		log.Trace().Int("numKeys", len(startupAppConfig.ApiKeys)).Msg("api keys provided at startup")
+		xlog.Startup.Debug().Msg("processing api keys runtime update")
+		xlog.Startup.Trace().Int("numKeys", len(startupAppConfig.ApiKeys)).Msg("api keys provided at startup")
 
 		if len(fileContent) > 0 {
-			// Parse JSON content from the file
-			var fileKeys []string
-			err := json.Unmarshal(fileContent, &fileKeys)
+			// Parse JSON content from the file, either a list of keys or the labels of the keys
+			fileKeys, fileLabels, err := config.ParseApiKeys(fileContent)
 			if err != nil {
 				return err
 			}
 
-			log.Trace().Int("numKeys", len(fileKeys)).Msg("discovered API keys from api keys dynamic config dile")
+			xlog.Startup.Trace().Int("numKeys", len(fileKeys)).Msg("discovered API keys from api keys dynamic config dile")
 
-			appConfig.ApiKeys = append(startupAppConfig.ApiKeys, fileKeys...)
+			// the keys and the labels are replaced as a whole, so that the revoked keys are dropped
+			labels := map[string]string{}
+			for key, label := range startupAppConfig.ApiKeyLabels {
+				labels[key] = label
+			}
+			for key, label := range fileLabels {
+				labels[key] = label
+			}
+			appConfig.ApiKeys = append(slices.Clone(startupAppConfig.ApiKeys), fileKeys...)
+			appConfig.ApiKeyLabels = labels
 		} else {
-			log.Trace().Msg("no API keys discovered from dynamic config file")
+			xlog.Startup.Trace().Msg("no API keys discovered from dynamic config file")
 			appConfig.ApiKeys = startupAppConfig.ApiKeys
+			appConfig.ApiKeyLabels = startupAppConfig.ApiKeyLabels
 		}
-		log.Trace().Int("numKeys", len(appConfig.ApiKeys)).Msg("total api keys after processing")
+		xlog.Startup.Trace().Int("numKeys", len(appConfig.ApiKeys)).Msg("total api keys after processing")
 		return nil
 	}
 
@@ -156,7 +166,7 @@ func readApiKeysJson(startupAppConfig config.ApplicationConfig) fileHandler {
 
 func readExternalBackendsJson(startupAppConfig config.ApplicationConfig) fileHandler {
 	handler := func(fileContent []byte, appConfig *config.ApplicationConfig) error {
-		log.Debug().Msg("processing external_backends.json")
+		xlog.Startup.Debug().Msg("processing external_backends.json")
 
 		if len(fileContent) > 0 {
 			// Parse JSON content from the file
@@ -173,7 +183,7 @@ func readExternalBackendsJson(startupAppConfig config.ApplicationConfig) fileHan
 		} else {
 			appConfig.ExternalGRPCBackends = startupAppConfig.ExternalGRPCBackends
 		}
-		log.Debug().Msg("external backends loaded from external_backends.json")
+		xlog.Startup.Debug().Msg("external backends loaded from external_backends.json")
 		return nil
 	}
 	return handler
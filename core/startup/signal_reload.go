@@ -0,0 +1,186 @@
+package startup
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mudler/LocalAI/core/backend"
+	"github.com/mudler/LocalAI/core/config"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
+	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/mudler/LocalAI/pkg/xlog"
+)
+
+// setThreadsTimeout bounds the wait for the backend to finish its inference before changing its threads
+const setThreadsTimeout = 30 * time.Second
+
+// remoteConfigCacheDir is the directory of the model path caching the remote model configurations
+const remoteConfigCacheDir = ".remote-configs"
+
+// signalReloader re-reads the model configurations when the process receives SIGHUP, and every
+// refresh interval of the remote configurations
+type signalReloader struct {
+	cl        *config.BackendConfigLoader
+	ml        *model.ModelLoader
+	appConfig *config.ApplicationConfig
+	remote    *config.RemoteConfigSource
+
+	sync.Mutex
+}
+
+func startSignalReloader(cl *config.BackendConfigLoader, ml *model.ModelLoader, options *config.ApplicationConfig, remote *config.RemoteConfigSource) {
+	r := &signalReloader{
+		cl:        cl,
+		ml:        ml,
+		appConfig: options,
+		remote:    remote,
+	}
+
+	if remote != nil && options.ConfigURLRefresh > 0 {
+		go func() {
+			ticker := time.NewTicker(options.ConfigURLRefresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-options.Context.Done():
+					return
+				case <-ticker.C:
+					r.reload()
+				}
+			}
+		}()
+	}
+
+	if !options.EnableSignalReload {
+		return
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-options.Context.Done():
+				signal.Stop(sigs)
+				return
+			case <-sigs:
+				xlog.Startup.Info().Msg("SIGHUP received, reloading model configurations")
+				r.reload()
+			}
+		}
+	}()
+}
+
+// reload reads again the model configurations from disk and from the remote source, and runs the preload step.
+// Models that are already loaded and whose configuration changed are shut down,
+// so the next request loads them again with the new configuration. The models whose
+// threads only changed are updated live instead, if their backend supports it.
+// Reloads are serialized, so it is safe to call it repeatedly.
+func (r *signalReloader) reload() {
+	r.Lock()
+	defer r.Unlock()
+
+	before := map[string]config.BackendConfig{}
+	for _, c := range r.cl.GetAllBackendConfigs() {
+		before[c.Name] = c
+	}
+
+	configLoaderOpts := r.appConfig.ToConfigLoaderOptions()
+
+	loadBackendConfigsFromPaths(r.cl, r.appConfig, configLoaderOpts...)
+
+	if r.appConfig.ConfigFile != "" {
+		if err := r.cl.LoadMultipleBackendConfigsSingleFile(r.appConfig.ConfigFile, configLoaderOpts...); err != nil {
+			xlog.Startup.Error().Err(err).Msg("error loading config file")
+		}
+	}
+
+	if r.remote != nil {
+		if err := r.cl.LoadRemoteBackendConfigs(r.appConfig.Context, r.remote, configLoaderOpts...); err != nil {
+			xlog.Startup.Error().Err(err).Str("url", r.appConfig.ConfigURL).Msg("error loading the remote model configurations")
+		}
+	}
+
+	if !r.appConfig.OfflineMode {
+		if err := r.cl.Preload(r.appConfig.ModelPath); err != nil {
+			xlog.Startup.Error().Err(err).Msg("error downloading models")
+		}
+	}
+
+	loaded := map[string]bool{}
+	models := r.ml.ListModels()
+	for i := range models {
+		loaded[models[i].ID] = true
+	}
+
+	added, changed, updated, unloaded := []string{}, []string{}, []string{}, []string{}
+	for _, c := range r.cl.GetAllBackendConfigs() {
+		old, exists := before[c.Name]
+		switch {
+		case !exists:
+			added = append(added, c.Name)
+		case !reflect.DeepEqual(old, c):
+			changed = append(changed, c.Name)
+			if !loaded[c.Name] {
+				continue
+			}
+			if threadsOnlyChange(old, c) && r.setThreads(old, c) {
+				updated = append(updated, c.Name)
+				continue
+			}
+			if err := r.ml.ShutdownModel(c.Name); err != nil {
+				xlog.Startup.Error().Err(err).Str("model", c.Name).Msg("error shutting down model after config change")
+				continue
+			}
+			unloaded = append(unloaded, c.Name)
+		}
+	}
+
+	xlog.Startup.Info().
+		Strs("added", added).
+		Strs("changed", changed).
+		Strs("updated", updated).
+		Strs("unloaded", unloaded).
+		Msg("model configurations reloaded")
+}
+
+// threadsOnlyChange reports whether the threads are the only change between the configurations
+func threadsOnlyChange(old, c config.BackendConfig) bool {
+	if reflect.DeepEqual(old.Threads, c.Threads) || c.Threads == nil {
+		return false
+	}
+	old.Threads = c.Threads
+	return reflect.DeepEqual(old, c)
+}
+
+// setThreads changes the threads of the loaded model without loading it again, and reports whether
+// the backend supports it. The threads are resolved as when loading the model, so that the threads set
+// for the application keep overriding the ones of the config.
+func (r *signalReloader) setThreads(old, c config.BackendConfig) bool {
+	m := r.ml.CheckIsLoaded(c.Name)
+	if m == nil {
+		return false
+	}
+
+	threads := backend.ModelThreads(c, r.appConfig)
+	if threads == backend.ModelThreads(old, r.appConfig) {
+		xlog.Startup.Debug().Str("model", c.Name).Int("threads", threads).Msg("the threads of the model are set for the application, keeping them")
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(r.appConfig.Context, setThreadsTimeout)
+	defer cancel()
+	res, err := m.GRPC(r.appConfig.ParallelBackendRequests, nil).SetThreads(ctx, &pb.SetThreadsRequest{Threads: int32(threads)})
+	if err != nil || res == nil || !res.Success {
+		xlog.Startup.Debug().Err(err).Str("model", c.Name).Msg("backend can not change its threads live, reloading the model")
+		return false
+	}
+	xlog.Startup.Info().Str("model", c.Name).Int("threads", threads).Msg("threads changed without reloading the model")
+	return true
+}
@@ -3,34 +3,42 @@ package startup
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/mudler/LocalAI/core"
 	"github.com/mudler/LocalAI/core/backend"
 	"github.com/mudler/LocalAI/core/config"
+	"github.com/mudler/LocalAI/core/gallery"
 	"github.com/mudler/LocalAI/core/services"
 	"github.com/mudler/LocalAI/internal"
 	"github.com/mudler/LocalAI/pkg/assets"
+	"github.com/mudler/LocalAI/pkg/downloader"
 	"github.com/mudler/LocalAI/pkg/library"
 	"github.com/mudler/LocalAI/pkg/model"
+	"github.com/mudler/LocalAI/pkg/signature"
 	pkgStartup "github.com/mudler/LocalAI/pkg/startup"
+	"github.com/mudler/LocalAI/pkg/xlog"
 	"github.com/mudler/LocalAI/pkg/xsysinfo"
-	"github.com/rs/zerolog/log"
 )
 
 func Startup(opts ...config.AppOption) (*config.BackendConfigLoader, *model.ModelLoader, *config.ApplicationConfig, error) {
 	options := config.NewApplicationConfig(opts...)
+	timer := newPhaseTimer()
 
-	log.Info().Msgf("Starting LocalAI using %d threads, with models path: %s", options.Threads, options.ModelPath)
-	log.Info().Msgf("LocalAI version: %s", internal.PrintableVersion())
+	xlog.Startup.Info().Msgf("Starting LocalAI using %d threads, with models path: %s", options.Threads, options.ModelPath)
+	xlog.Startup.Info().Msgf("LocalAI version: %s", internal.PrintableVersion())
 	caps, err := xsysinfo.CPUCapabilities()
 	if err == nil {
-		log.Debug().Msgf("CPU capabilities: %v", caps)
+		xlog.Startup.Debug().Msgf("CPU capabilities: %v", caps)
 	}
 	gpus, err := xsysinfo.GPUs()
 	if err == nil {
-		log.Debug().Msgf("GPU count: %d", len(gpus))
+		xlog.Startup.Debug().Msgf("GPU count: %d", len(gpus))
 		for _, gpu := range gpus {
-			log.Debug().Msgf("GPU: %s", gpu.String())
+			xlog.Startup.Debug().Msgf("GPU: %s", gpu.String())
 		}
 	}
 
@@ -61,71 +69,117 @@ func Startup(opts ...config.AppOption) (*config.BackendConfigLoader, *model.Mode
 		}
 	}
 
-	if err := pkgStartup.InstallModels(options.Galleries, options.ModelLibraryURL, options.ModelPath, options.EnforcePredownloadScans, nil, options.ModelsURL...); err != nil {
-		log.Error().Err(err).Msg("error installing models")
+	if options.CleanupOnStartup {
+		cleanupStaleFiles(options, time.Now())
 	}
 
+	timer.mark("setup")
+
+	if options.DownloadConcurrency > 0 {
+		gallery.SetDownloadConcurrency(options.DownloadConcurrency)
+	}
+	if options.MaxDownloadRate > 0 {
+		downloader.SetMaxDownloadRate(options.MaxDownloadRate)
+	}
+	if options.DiskHeadroomMB > 0 {
+		xsysinfo.SetDiskHeadroom(uint64(options.DiskHeadroomMB) << 20)
+	}
+	if err := gallery.SetGalleriesCredentials(options.Galleries); err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := signature.ParsePublicKeys(options.TrustedKeys); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if options.OfflineMode {
+		xlog.Startup.Info().Msg("offline mode: skipping downloads")
+	} else if err := pkgStartup.InstallModels(options.Context, options.Galleries, options.ModelLibraryURL, options.ModelPath, options.EnforcePredownloadScans, options.TrustedKeys, nil, options.ModelsURL...); err != nil {
+		xlog.Startup.Error().Err(err).Msg("error installing models")
+	}
+
+	timer.mark("install_models")
+
 	cl := config.NewBackendConfigLoader(options.ModelPath)
-	ml := model.NewModelLoader(options.ModelPath)
+	ml := model.NewModelLoader(options.ModelPath, options.ExtraModelPaths...)
 
 	configLoaderOpts := options.ToConfigLoaderOptions()
 
-	if err := cl.LoadBackendConfigsFromPath(options.ModelPath, configLoaderOpts...); err != nil {
-		log.Error().Err(err).Msg("error loading config files")
-	}
+	loadBackendConfigsFromPaths(cl, options, configLoaderOpts...)
 
 	if options.ConfigFile != "" {
 		if err := cl.LoadMultipleBackendConfigsSingleFile(options.ConfigFile, configLoaderOpts...); err != nil {
-			log.Error().Err(err).Msg("error loading config file")
+			xlog.Startup.Error().Err(err).Msg("error loading config file")
 		}
 	}
 
-	if err := cl.Preload(options.ModelPath); err != nil {
-		log.Error().Err(err).Msg("error downloading models")
+	var remoteConfigs *config.RemoteConfigSource
+	if options.ConfigURL != "" {
+		remoteConfigs = config.NewRemoteConfigSource(options.ConfigURL, filepath.Join(options.ModelPath, remoteConfigCacheDir))
+		if err := cl.LoadRemoteBackendConfigs(options.Context, remoteConfigs, configLoaderOpts...); err != nil {
+			xlog.Startup.Error().Err(err).Str("url", options.ConfigURL).Msg("error loading the remote model configurations")
+		}
 	}
 
-	if options.PreloadJSONModels != "" {
-		if err := services.ApplyGalleryFromString(options.ModelPath, options.PreloadJSONModels, options.EnforcePredownloadScans, options.Galleries); err != nil {
+	timer.mark("config_load")
+
+	if !options.OfflineMode {
+		if err := cl.Preload(options.ModelPath); err != nil {
+			xlog.Startup.Error().Err(err).Msg("error downloading models")
+		}
+	}
+
+	if options.PreloadInBackground && !options.OfflineMode {
+		xlog.Startup.Info().Msg("the models to preload are installed in the background once the API is started")
+	} else if options.PreloadJSONModels != "" && !options.OfflineMode {
+		if err := services.ApplyGalleryFromString(options.ModelPath, options.PreloadJSONModels, options.EnforcePredownloadScans, options.TrustedKeys, options.Galleries); err != nil {
 			return nil, nil, nil, err
 		}
 	}
 
-	if options.PreloadModelsFromPath != "" {
-		if err := services.ApplyGalleryFromFile(options.ModelPath, options.PreloadModelsFromPath, options.EnforcePredownloadScans, options.Galleries); err != nil {
+	if options.PreloadModelsFromPath != "" && !options.OfflineMode && !options.PreloadInBackground {
+		if err := services.ApplyGalleryFromFile(options.ModelPath, options.PreloadModelsFromPath, options.EnforcePredownloadScans, options.TrustedKeys, options.Galleries); err != nil {
 			return nil, nil, nil, err
 		}
 	}
 
+	timer.mark("preload")
+
 	if options.Debug {
 		for _, v := range cl.GetAllBackendConfigs() {
-			log.Debug().Msgf("Model: %s (config: %+v)", v.Name, v)
+			xlog.Startup.Debug().Msgf("Model: %s (config: %+v)", v.Name, v)
 		}
 	}
 
 	if options.AssetsDestination != "" {
 		// Extract files from the embedded FS
 		err := assets.ExtractFiles(options.BackendAssets, options.AssetsDestination)
-		log.Debug().Msgf("Extracting backend assets files to %s", options.AssetsDestination)
+		xlog.Startup.Debug().Msgf("Extracting backend assets files to %s", options.AssetsDestination)
 		if err != nil {
-			log.Warn().Msgf("Failed extracting backend assets files: %s (might be required for some backends to work properly)", err)
+			xlog.Startup.Warn().Msgf("Failed extracting backend assets files: %s (might be required for some backends to work properly)", err)
 		}
 	}
 
+	timer.mark("assets_extraction")
+
+	if err := validateBackendOverrides(ml, options); err != nil {
+		return nil, nil, nil, err
+	}
+
 	if options.LibPath != "" {
 		// If there is a lib directory, set LD_LIBRARY_PATH to include it
 		err := library.LoadExternal(options.LibPath)
 		if err != nil {
-			log.Error().Err(err).Str("LibPath", options.LibPath).Msg("Error while loading external libraries")
+			xlog.Startup.Error().Err(err).Str("LibPath", options.LibPath).Msg("Error while loading external libraries")
 		}
 	}
 
 	// turn off any process that was started by GRPC if the context is canceled
 	go func() {
 		<-options.Context.Done()
-		log.Debug().Msgf("Context canceled, shutting down")
+		xlog.Startup.Debug().Msgf("Context canceled, shutting down")
 		err := ml.StopAllGRPC()
 		if err != nil {
-			log.Error().Err(err).Msg("error while stopping all grpc backends")
+			xlog.Startup.Error().Err(err).Msg("error while stopping all grpc backends")
 		}
 	}()
 
@@ -136,15 +190,25 @@ func Startup(opts ...config.AppOption) (*config.BackendConfigLoader, *model.Mode
 			options.WatchDogIdleTimeout,
 			options.WatchDogBusy,
 			options.WatchDogIdle)
+		wd.KeepWarm(options.KeepWarm...)
 		ml.SetWatchDog(wd)
 		go wd.Run()
 		go func() {
 			<-options.Context.Done()
-			log.Debug().Msgf("Context canceled, shutting down")
+			xlog.Startup.Debug().Msgf("Context canceled, shutting down")
 			wd.Shutdown()
 		}()
 	}
 
+	ml.SetBackendLogLines(options.BackendLogLines)
+	ml.SetForwardBackendLogs(options.ForwardBackendLogs)
+
+	if options.RestartAfterFailures > 0 {
+		ml.SetSupervisor(model.NewSupervisor(ml, options.RestartAfterFailures, options.RestartBackoff, options.MaxRestarts))
+	}
+
+	timer.mark("backends_setup")
+
 	if options.LoadToMemory != nil {
 		for _, m := range options.LoadToMemory {
 			cfg, err := cl.LoadBackendConfigFileByName(m, options.ModelPath,
@@ -158,7 +222,7 @@ func Startup(opts ...config.AppOption) (*config.BackendConfigLoader, *model.Mode
 				return nil, nil, nil, err
 			}
 
-			log.Debug().Msgf("Auto loading model %s into memory from file: %s", m, cfg.Model)
+			xlog.Startup.Debug().Msgf("Auto loading model %s into memory from file: %s", m, cfg.Model)
 
 			o := backend.ModelOptions(*cfg, options, []model.Option{})
 
@@ -175,10 +239,17 @@ func Startup(opts ...config.AppOption) (*config.BackendConfigLoader, *model.Mode
 		}
 	}
 
+	timer.mark("models_warmup")
+
 	// Watch the configuration directory
 	startWatcher(options)
 
-	log.Info().Msg("core/startup process completed!")
+	// Reload the model configurations on SIGHUP, and when the remote configurations are refreshed
+	startSignalReloader(cl, ml, options, remoteConfigs)
+
+	timer.mark("watchers")
+
+	xlog.Startup.Info().Dur("total", timer.total()).Dict("phases", timer.dict()).Msg("core/startup process completed!")
 	return cl, ml, options, nil
 }
 
@@ -192,18 +263,18 @@ func startWatcher(options *config.ApplicationConfig) {
 		if os.IsNotExist(err) {
 			// We try to create the directory if it does not exist and was specified
 			if err := os.MkdirAll(options.DynamicConfigsDir, 0700); err != nil {
-				log.Error().Err(err).Msg("failed creating DynamicConfigsDir")
+				xlog.Startup.Error().Err(err).Msg("failed creating DynamicConfigsDir")
 			}
 		} else {
 			// something else happened, we log the error and don't start the watcher
-			log.Error().Err(err).Msg("failed to read DynamicConfigsDir, watcher will not be started")
+			xlog.Startup.Error().Err(err).Msg("failed to read DynamicConfigsDir, watcher will not be started")
 			return
 		}
 	}
 
 	configHandler := newConfigFileHandler(options)
 	if err := configHandler.Watch(); err != nil {
-		log.Error().Err(err).Msg("failed creating watcher")
+		xlog.Startup.Error().Err(err).Msg("failed creating watcher")
 	}
 }
 
@@ -213,7 +284,7 @@ func createApplication(appConfig *config.ApplicationConfig) *core.Application {
 	app := &core.Application{
 		ApplicationConfig:   appConfig,
 		BackendConfigLoader: config.NewBackendConfigLoader(appConfig.ModelPath),
-		ModelLoader:         model.NewModelLoader(appConfig.ModelPath),
+		ModelLoader:         model.NewModelLoader(appConfig.ModelPath, appConfig.ExtraModelPaths...),
 	}
 
 	var err error
@@ -230,8 +301,62 @@ func createApplication(appConfig *config.ApplicationConfig) *core.Application {
 
 	app.LocalAIMetricsService, err = services.NewLocalAIMetricsService()
 	if err != nil {
-		log.Error().Err(err).Msg("encountered an error initializing metrics service, startup will continue but metrics will not be tracked.")
+		xlog.Startup.Error().Err(err).Msg("encountered an error initializing metrics service, startup will continue but metrics will not be tracked.")
 	}
 
 	return app
 }
+
+// validateBackendOverrides checks that the backends of the priority override and of the backend mapping
+// are available, so that a typo does not silently leave the default order or detection, and warns about
+// the unknown disabled backends
+func validateBackendOverrides(ml *model.ModelLoader, options *config.ApplicationConfig) error {
+	if len(options.BackendPriorityOverride) == 0 && len(options.DisabledBackends) == 0 && len(options.BackendMapping) == 0 {
+		return nil
+	}
+	available, err := ml.ListAvailableBackends(options.AssetsDestination)
+	if err != nil {
+		return fmt.Errorf("failed listing the backends to validate the overrides: %w", err)
+	}
+	for _, r := range options.BackendMapping {
+		if slices.Contains(available, r.Backend) || isKnownBackend(options, r.Backend) {
+			continue
+		}
+		return fmt.Errorf("unknown backend %q for the models %q in the backend mapping, the available backends are: %s", r.Backend, r.Model, strings.Join(available, ", "))
+	}
+	for _, b := range options.BackendPriorityOverride {
+		if !slices.Contains(available, b) {
+			return fmt.Errorf("unknown backend %q in the backend priority override, the available backends are: %s", b, strings.Join(available, ", "))
+		}
+	}
+	for _, b := range options.DisabledBackends {
+		if !slices.Contains(available, b) {
+			xlog.Startup.Warn().Str("backend", b).Strs("available", available).Msg("unknown disabled backend, ignoring it")
+		}
+	}
+	return nil
+}
+
+// isKnownBackend reports whether the backend is an external backend or an alias, which are not in the
+// asset directory
+func isKnownBackend(options *config.ApplicationConfig, backend string) bool {
+	if _, ok := options.ExternalGRPCBackends[backend]; ok {
+		return true
+	}
+	_, ok := model.Aliases[strings.ToLower(backend)]
+	return ok
+}
+
+// loadBackendConfigsFromPaths loads the configurations of the models from the model path and the extra
+// model paths. The extra paths are loaded first and in reverse order, so that a model configured in
+// several paths keeps the configuration of the first one.
+func loadBackendConfigsFromPaths(cl *config.BackendConfigLoader, options *config.ApplicationConfig, opts ...config.ConfigLoaderOption) {
+	for i := len(options.ExtraModelPaths) - 1; i >= 0; i-- {
+		if err := cl.LoadBackendConfigsFromPath(options.ExtraModelPaths[i], opts...); err != nil {
+			xlog.Startup.Error().Err(err).Str("path", options.ExtraModelPaths[i]).Msg("error loading config files from the extra model path")
+		}
+	}
+	if err := cl.LoadBackendConfigsFromPath(options.ModelPath, opts...); err != nil {
+		xlog.Startup.Error().Err(err).Msg("error loading config files")
+	}
+}
@@ -0,0 +1,47 @@
+package startup
+
+import (
+	"time"
+
+	"github.com/mudler/LocalAI/pkg/xlog"
+	"github.com/rs/zerolog"
+)
+
+// phaseTimer keeps track of how long each step of the startup process takes
+type phaseTimer struct {
+	start     time.Time
+	last      time.Time
+	phases    []string
+	durations []time.Duration
+}
+
+func newPhaseTimer() *phaseTimer {
+	now := time.Now()
+	return &phaseTimer{
+		start: now,
+		last:  now,
+	}
+}
+
+// mark records the time elapsed since the previous mark as the duration of the given phase
+func (t *phaseTimer) mark(phase string) {
+	now := time.Now()
+	d := now.Sub(t.last)
+	t.last = now
+	t.phases = append(t.phases, phase)
+	t.durations = append(t.durations, d)
+	xlog.Startup.Debug().Str("phase", phase).Dur("duration", d).Msg("startup phase completed")
+}
+
+func (t *phaseTimer) total() time.Duration {
+	return t.last.Sub(t.start)
+}
+
+// dict returns the recorded durations as a zerolog dictionary, in the order they were recorded
+func (t *phaseTimer) dict() *zerolog.Event {
+	d := zerolog.Dict()
+	for i, phase := range t.phases {
+		d = d.Dur(phase, t.durations[i])
+	}
+	return d
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/mudler/LocalAI/core/cli"
 	"github.com/mudler/LocalAI/internal"
+	"github.com/mudler/LocalAI/pkg/xlog"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -112,6 +113,21 @@ Version: ${version}
 		log.Trace().Msg("Setting logging to trace")
 	}
 
+	logFields, err := xlog.ParseFields(cli.CLI.LogFields)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid log fields")
+	}
+	if err := xlog.SetFormat(os.Stderr, cli.CLI.LogFormat, logFields); err != nil {
+		log.Fatal().Err(err).Msg("invalid log format")
+	}
+
+	// The components set to another level log at their own level, the others at the level set above
+	componentLevels, err := xlog.ParseLevels(cli.CLI.LogLevels)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid log levels")
+	}
+	xlog.SetLevels(zerolog.GlobalLevel(), componentLevels)
+
 	// Populate the application with the embedded backend assets
 	cli.CLI.Context.BackendAssets = backendAssets
 
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 
 	"github.com/mudler/LocalAI/pkg/library"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
 )
 
 func ResolvePath(dir string, paths ...string) string {
@@ -21,6 +22,10 @@ func ExtractFiles(content embed.FS, extractDir string) error {
 		return fmt.Errorf("failed to create directory: %v", err)
 	}
 
+	if err := xsysinfo.CheckDiskSpace(extractDir, extractSize(content, extractDir)); err != nil {
+		return fmt.Errorf("cannot extract the backend assets: %w", err)
+	}
+
 	// Walk through the embedded FS and extract files
 	err = fs.WalkDir(content, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -60,3 +65,26 @@ func ExtractFiles(content embed.FS, extractDir string) error {
 
 	return err
 }
+
+// extractSize returns the bytes the extraction adds to the files already extracted, which are overwritten
+func extractSize(content embed.FS, extractDir string) uint64 {
+	var size uint64
+	fs.WalkDir(content, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		add := info.Size()
+		if fi, err := os.Stat(filepath.Join(extractDir, path)); err == nil {
+			add -= fi.Size()
+		}
+		if add > 0 {
+			size += uint64(add)
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,46 @@
+// Package correlation carries the correlation ID of a request through its whole lifecycle:
+// the API logs, the backend calls and the backend logs.
+package correlation
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultHeader is the HTTP header carrying the correlation ID, unless configured otherwise
+	DefaultHeader = "X-Correlation-ID"
+	// MetadataKey is the gRPC metadata key carrying the correlation ID to the backends
+	MetadataKey = "x-correlation-id"
+	// LogField is the field of the log lines holding the correlation ID
+	LogField = "correlation_id"
+)
+
+type contextKey struct{}
+
+// WithID returns a copy of ctx carrying the correlation ID, along with a logger tagging its lines with it
+func WithID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, contextKey{}, id)
+	logger := log.Logger.With().Str(LogField, id).Logger()
+	return logger.WithContext(ctx)
+}
+
+// FromContext returns the correlation ID carried by ctx, or an empty string if there is none
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logger returns the logger of the request, which tags its lines with the correlation ID.
+// Without a correlation ID in ctx, it returns the global logger.
+func Logger(ctx context.Context) *zerolog.Logger {
+	if FromContext(ctx) == "" {
+		return &log.Logger
+	}
+	return zerolog.Ctx(ctx)
+}
@@ -0,0 +1,36 @@
+package correlation_test
+
+import (
+	"bytes"
+	"context"
+
+	. "github.com/mudler/LocalAI/pkg/correlation"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Correlation", func() {
+	It("carries the correlation ID in the context", func() {
+		Expect(FromContext(context.Background())).To(BeEmpty())
+
+		ctx := WithID(context.Background(), "abc")
+		Expect(FromContext(ctx)).To(Equal("abc"))
+	})
+
+	It("tags the log lines of the request", func() {
+		previous := log.Logger
+		defer func() { log.Logger = previous }()
+
+		var buf bytes.Buffer
+		log.Logger = zerolog.New(&buf)
+
+		Logger(context.Background()).Info().Msg("untagged")
+		Expect(buf.String()).ToNot(ContainSubstring(LogField))
+
+		buf.Reset()
+		Logger(WithID(context.Background(), "abc")).Info().Msg("tagged")
+		Expect(buf.String()).To(ContainSubstring(`"correlation_id":"abc"`))
+	})
+})
@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Credential is a header sent with the requests to a host, e.g. the authorization of a private server
+type Credential struct {
+	// Scheme is the scheme of the URLs, so that the credentials of an https server are never sent in clear
+	Scheme string
+	// Host is the host of the URLs, with the port if it is not the default one of the scheme
+	Host   string
+	Header string
+	Value  string
+}
+
+var (
+	credentialsMu sync.RWMutex
+	credentials   []Credential
+)
+
+// SetCredentials sets the headers sent with the requests to each host, replacing the previous ones.
+// When a host has several credentials, the first one is sent.
+func SetCredentials(c []Credential) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	credentials = append([]Credential{}, c...)
+}
+
+// urlCredentials returns the credentials of the scheme and the host of a URL
+func urlCredentials(u *url.URL) []Credential {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	var found []Credential
+	for _, c := range credentials {
+		if strings.EqualFold(c.Scheme, u.Scheme) && strings.EqualFold(c.Host, u.Host) {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// authorize adds the credentials of the scheme and the host of the request, unless the request already sets
+// the header
+func authorize(req *http.Request) {
+	for _, c := range urlCredentials(req.URL) {
+		if req.Header.Get(c.Header) == "" {
+			req.Header.Set(c.Header, c.Value)
+		}
+	}
+}
+
+// httpClient sends the requests of the downloads. The credentials of a host are not sent to the other hosts it
+// redirects to, e.g. a CDN, nor in clear when an https host redirects to http: the redirect gets the
+// credentials of its own scheme and host instead.
+var httpClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if prev := via[len(via)-1]; !strings.EqualFold(prev.URL.Scheme, req.URL.Scheme) || !strings.EqualFold(prev.URL.Host, req.URL.Host) {
+			for _, c := range urlCredentials(prev.URL) {
+				req.Header.Del(c.Header)
+			}
+			authorize(req)
+		}
+		return nil
+	},
+}
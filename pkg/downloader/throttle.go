@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk bounds the bytes read at once by a throttled download, so that the downloads sharing the
+// rate take turns
+const throttleChunk = 32 * 1024
+
+// downloadRate is the rate shared by all the downloads
+var downloadRate = &rateLimiter{}
+
+// SetMaxDownloadRate caps the rate of all the downloads together, in bytes per second. 0 removes the cap.
+func SetMaxDownloadRate(bytesPerSecond int64) {
+	downloadRate.setRate(bytesPerSecond)
+}
+
+// rateLimiter is a token bucket of bytes, refilled at rate bytes per second, with a burst of a second
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) setRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(bytesPerSecond)
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// wait takes n bytes from the bucket, waiting for them to be refilled if needed
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	// the bytes are taken even if they are not there yet, so that the waiting downloads are served in turn
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledReader reads at the rate of the limiter
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter writes at the rate of the limiter. It throttles the downloads that report the bytes
+// downloaded to a writer, e.g. the OCI images
+type throttledWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if err := t.limiter.wait(t.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return t.w.Write(p)
+}
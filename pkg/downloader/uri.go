@@ -1,8 +1,10 @@
 package downloader
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"net/url"
@@ -10,11 +12,13 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/mudler/LocalAI/pkg/oci"
 	"github.com/mudler/LocalAI/pkg/utils"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
 	"github.com/rs/zerolog/log"
 )
 
@@ -74,8 +78,9 @@ func (uri URI) DownloadWithAuthorizationAndCallback(basePath string, authorizati
 	if authorization != "" {
 		req.Header.Add("Authorization", authorization)
 	}
+	authorize(req)
 
-	response, err := http.DefaultClient.Do(req)
+	response, err := httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -190,32 +195,192 @@ func (s URI) ResolveURL() string {
 	return string(s)
 }
 
-func removePartialFile(tmpFilePath string) error {
-	_, err := os.Stat(tmpFilePath)
-	if err == nil {
-		log.Debug().Msgf("Removing temporary file %s", tmpFilePath)
-		err = os.Remove(tmpFilePath)
-		if err != nil {
-			err1 := fmt.Errorf("failed to remove temporary download file %s: %v", tmpFilePath, err)
-			log.Warn().Msg(err1.Error())
-			return err1
+const downloadAttempts = 5
+
+var downloadRetryDelay = 2 * time.Second
+
+// permanentDownloadError is returned for failures that retrying would not fix (e.g. 404)
+type permanentDownloadError struct {
+	error
+}
+
+func (e permanentDownloadError) Unwrap() error {
+	return e.error
+}
+
+// downloadToPartialFile downloads url into tmpFilePath. If tmpFilePath already exists,
+// only the missing bytes are requested with an HTTP Range request.
+func downloadToPartialFile(ctx context.Context, url, tmpFilePath string, progress *progressWriter) error {
+	var offset int64
+	if info, err := os.Stat(tmpFilePath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return permanentDownloadError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	authorize(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download file %q: %v", tmpFilePath, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		log.Info().Msgf("Resuming download of %q from %s", url, formatBytes(offset))
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the partial file is already complete
+		return seedHash(progress.hash, tmpFilePath, offset)
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("failed to download url %q, invalid status code %d", url, resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return permanentDownloadError{fmt.Errorf("failed to download url %q, invalid status code %d", url, resp.StatusCode)}
+	default:
+		// the server does not support ranges (or there was nothing to resume): start from scratch
+		offset = 0
+		flags |= os.O_TRUNC
+	}
+
+	if resp.ContentLength > 0 {
+		if err := xsysinfo.CheckDiskSpace(filepath.Dir(tmpFilePath), uint64(resp.ContentLength)); err != nil {
+			return permanentDownloadError{fmt.Errorf("cannot download %q: %w", url, err)}
 		}
 	}
+
+	outFile, err := os.OpenFile(tmpFilePath, flags, 0644)
+	if err != nil {
+		return permanentDownloadError{fmt.Errorf("failed to create file %q: %v", tmpFilePath, err)}
+	}
+	defer outFile.Close()
+
+	// the hash covers the whole file, so that the SHA of a resumed download can be checked without reading it again
+	if err := seedHash(progress.hash, tmpFilePath, offset); err != nil {
+		return permanentDownloadError{err}
+	}
+	progress.written = offset
+	progress.total = 0
+	if resp.ContentLength > 0 {
+		progress.total = offset + resp.ContentLength
+	}
+
+	_, err = io.Copy(io.MultiWriter(outFile, progress), &throttledReader{ctx: ctx, r: resp.Body, limiter: downloadRate})
+	if err != nil {
+		return fmt.Errorf("failed to write file %q: %v", tmpFilePath, err)
+	}
+
+	return nil
+}
+
+// downloadArtifactFile pulls the file of an OCI artifact named after filePath, or its single file, to filePath
+// and verifies its SHA
+func downloadArtifactFile(ctx context.Context, artifact *oci.Artifact, filePath, sha string, fileN, total int, downloadStatus func(string, string, string, float64)) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0750); err != nil {
+		return fmt.Errorf("failed to create parent directory for file %q: %v", filePath, err)
+	}
+
+	progress := &progressWriter{
+		fileName:       filePath,
+		hash:           sha256.New(),
+		fileNo:         fileN,
+		totalFiles:     total,
+		downloadStatus: downloadStatus,
+	}
+	err := artifact.FetchFile(ctx, filepath.Base(filePath), filePath, func(desc ocispec.Descriptor) io.Writer {
+		progress.total = desc.Size
+		return &throttledWriter{ctx: ctx, limiter: downloadRate, w: progress}
+	})
+	if err != nil {
+		return err
+	}
+
+	if sha != "" {
+		if calculatedSHA := fmt.Sprintf("%x", progress.hash.Sum(nil)); calculatedSHA != sha {
+			if err := os.Remove(filePath); err != nil {
+				log.Warn().Err(err).Msgf("failed to remove file %q", filePath)
+			}
+			return fmt.Errorf("SHA mismatch for file %q ( calculated: %s != metadata: %s )", filePath, calculatedSHA, sha)
+		}
+	}
+
+	log.Info().Msgf("File %q downloaded and verified", filePath)
+	return nil
+}
+
+// seedHash resets h and feeds it the first n bytes of the file at path, which were downloaded by a previous attempt
+func seedHash(h hash.Hash, path string, n int64) error {
+	h.Reset()
+	if n == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read partial file %q: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return fmt.Errorf("failed to hash partial file %q: %v", path, err)
+	}
 	return nil
 }
 
+// ContentLength returns the size of the file at the URI without downloading it, or -1 if it is not known in
+// advance, e.g. for the OCI images or when the server doesn't tell it. Only the HTTP servers are asked for it,
+// the size of a local file is read from the disk.
+func (uri URI) ContentLength(ctx context.Context) (int64, error) {
+	url := uri.ResolveURL()
+	switch {
+	case strings.HasPrefix(url, LocalPrefix):
+		fi, err := os.Stat(strings.TrimPrefix(url, LocalPrefix))
+		if err != nil {
+			return -1, err
+		}
+		return fi.Size(), nil
+	case !strings.HasPrefix(url, HTTPPrefix) && !strings.HasPrefix(url, HTTPSPrefix):
+		return -1, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return -1, err
+	}
+	authorize(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return -1, fmt.Errorf("failed to get the size of %q, invalid status code %d", uri, resp.StatusCode)
+	}
+	return resp.ContentLength, nil
+}
+
 func (uri URI) DownloadFile(filePath, sha string, fileN, total int, downloadStatus func(string, string, string, float64)) error {
+	return uri.DownloadFileWithContext(context.Background(), filePath, sha, fileN, total, downloadStatus)
+}
+
+// DownloadFileWithContext is like DownloadFile, but the download is interrupted when ctx is canceled.
+// In that case the partial file is removed, so it is not resumed later on.
+func (uri URI) DownloadFileWithContext(ctx context.Context, filePath, sha string, fileN, total int, downloadStatus func(string, string, string, float64)) error {
 	url := uri.ResolveURL()
 	if uri.LooksLikeOCI() {
 		progressStatus := func(desc ocispec.Descriptor) io.Writer {
-			return &progressWriter{
+			return &throttledWriter{ctx: ctx, limiter: downloadRate, w: &progressWriter{
 				fileName:       filePath,
 				total:          desc.Size,
 				hash:           sha256.New(),
 				fileNo:         fileN,
 				totalFiles:     total,
 				downloadStatus: downloadStatus,
-			}
+			}}
 		}
 
 		if strings.HasPrefix(url, OllamaPrefix) {
@@ -224,6 +389,16 @@ func (uri URI) DownloadFile(filePath, sha string, fileN, total int, downloadStat
 		}
 
 		url = strings.TrimPrefix(url, OCIPrefix)
+
+		// Artifacts (e.g. pushed with `oras push`) carry the model files as plain layers
+		artifact, err := oci.GetArtifact(ctx, url)
+		if err != nil {
+			return fmt.Errorf("failed to get %q: %v", url, err)
+		}
+		if artifact != nil {
+			return downloadArtifactFile(ctx, artifact, filePath, sha, fileN, total, downloadStatus)
+		}
+
 		img, err := oci.GetImage(url, "", nil, nil)
 		if err != nil {
 			return fmt.Errorf("failed to get image %q: %v", url, err)
@@ -238,7 +413,7 @@ func (uri URI) DownloadFile(filePath, sha string, fileN, total int, downloadStat
 		// File exists, check SHA
 		if sha != "" {
 			// Verify SHA
-			calculatedSHA, err := calculateSHA(filePath)
+			calculatedSHA, err := CalculateSHA(filePath)
 			if err != nil {
 				return fmt.Errorf("failed to calculate SHA for file %q: %v", filePath, err)
 			}
@@ -266,50 +441,52 @@ func (uri URI) DownloadFile(filePath, sha string, fileN, total int, downloadStat
 
 	log.Info().Msgf("Downloading %q", url)
 
-	// Download file
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download file %q: %v", filePath, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("failed to download url %q, invalid status code %d", url, resp.StatusCode)
-	}
-
 	// Create parent directory
 	err = os.MkdirAll(filepath.Dir(filePath), 0750)
 	if err != nil {
 		return fmt.Errorf("failed to create parent directory for file %q: %v", filePath, err)
 	}
 
-	// save partial download to dedicated file
+	// save partial download to dedicated file. If a partial file is already there
+	// (e.g. from a previous interrupted download), the download is resumed from where it stopped
 	tmpFilePath := filePath + ".partial"
 
-	// remove tmp file
-	err = removePartialFile(tmpFilePath)
-	if err != nil {
-		return err
-	}
-
-	// Create and write file content
-	outFile, err := os.Create(tmpFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to create file %q: %v", tmpFilePath, err)
-	}
-	defer outFile.Close()
-
 	progress := &progressWriter{
 		fileName:       tmpFilePath,
-		total:          resp.ContentLength,
 		hash:           sha256.New(),
 		fileNo:         fileN,
 		totalFiles:     total,
 		downloadStatus: downloadStatus,
 	}
-	_, err = io.Copy(io.MultiWriter(outFile, progress), resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file %q: %v", filePath, err)
+
+	// a canceled download is not resumed, its partial file is removed
+	canceled := func() error {
+		log.Info().Msgf("Download of %q canceled", url)
+		if err := os.Remove(tmpFilePath); err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Msgf("failed to remove temporary download file %s", tmpFilePath)
+		}
+		return ctx.Err()
+	}
+
+	delay := downloadRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = downloadToPartialFile(ctx, url, tmpFilePath, progress)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return canceled()
+		}
+		if _, permanent := err.(permanentDownloadError); permanent || attempt >= downloadAttempts {
+			return err
+		}
+		log.Warn().Err(err).Msgf("Download of %q failed (attempt %d/%d), retrying in %s", url, attempt, downloadAttempts, delay)
+		select {
+		case <-ctx.Done():
+			return canceled()
+		case <-time.After(delay):
+		}
+		delay *= 2
 	}
 
 	err = os.Rename(tmpFilePath, filePath)
@@ -318,10 +495,14 @@ func (uri URI) DownloadFile(filePath, sha string, fileN, total int, downloadStat
 	}
 
 	if sha != "" {
-		// Verify SHA
+		// Verify SHA. The hash was seeded with the bytes of the partial file when the download was resumed
 		calculatedSHA := fmt.Sprintf("%x", progress.hash.Sum(nil))
 		if calculatedSHA != sha {
 			log.Debug().Msgf("SHA mismatch for file %q ( calculated: %s != metadata: %s )", filePath, calculatedSHA, sha)
+			// remove the file, so next time it is downloaded from scratch instead of being resumed
+			if err := os.Remove(filePath); err != nil {
+				log.Warn().Err(err).Msgf("failed to remove file %q", filePath)
+			}
 			return fmt.Errorf("SHA mismatch for file %q ( calculated: %s != metadata: %s )", filePath, calculatedSHA, sha)
 		}
 	} else {
@@ -332,6 +513,12 @@ func (uri URI) DownloadFile(filePath, sha string, fileN, total int, downloadStat
 	if utils.IsArchive(filePath) {
 		basePath := filepath.Dir(filePath)
 		log.Info().Msgf("File %q is an archive, uncompressing to %s", filePath, basePath)
+		// the archive uncompresses to at least its size
+		if fi, err := os.Stat(filePath); err == nil {
+			if err := xsysinfo.CheckDiskSpace(basePath, uint64(fi.Size())); err != nil {
+				return fmt.Errorf("cannot uncompress %q: %w", filePath, err)
+			}
+		}
 		if err := utils.ExtractArchive(filePath, basePath); err != nil {
 			log.Debug().Msgf("Failed decompressing %q: %s", filePath, err.Error())
 			return err
@@ -354,7 +541,8 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
-func calculateSHA(filePath string) (string, error) {
+// CalculateSHA returns the hex encoded SHA256 of a file
+func CalculateSHA(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
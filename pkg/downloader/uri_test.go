@@ -1,9 +1,28 @@
 package downloader_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/registry"
 	. "github.com/mudler/LocalAI/pkg/downloader"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry/remote"
 )
 
 var _ = Describe("Gallery API tests", func() {
@@ -36,5 +55,237 @@ var _ = Describe("Gallery API tests", func() {
 				}),
 			).ToNot(HaveOccurred())
 		})
+		It("only asks the HTTP servers for the size of the files", func() {
+			file := filepath.Join(GinkgoT().TempDir(), "model.bin")
+			Expect(os.WriteFile(file, []byte("0123456789"), 0600)).To(Succeed())
+			Expect(URI(LocalPrefix + file).ContentLength(context.TODO())).To(Equal(int64(10)))
+			Expect(URI("ollama://gemma:2b").ContentLength(context.TODO())).To(Equal(int64(-1)))
+			Expect(URI("ftp://example.com/model.bin").ContentLength(context.TODO())).To(Equal(int64(-1)))
+		})
+	})
+
+	Context("DownloadFile", func() {
+		var content []byte
+		var sha string
+		var requests []string
+		var requestsMu sync.Mutex
+		var server *httptest.Server
+		var dir string
+
+		BeforeEach(func() {
+			content = bytes.Repeat([]byte("localai"), 1024)
+			sha = fmt.Sprintf("%x", sha256.Sum256(content))
+			requests = []string{}
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestsMu.Lock()
+				requests = append(requests, r.Header.Get("Range"))
+				requestsMu.Unlock()
+				http.ServeContent(w, r, "model.bin", time.Time{}, bytes.NewReader(content))
+			}))
+			var err error
+			dir, err = os.MkdirTemp("", "downloader")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+
+		It("downloads and verifies a file", func() {
+			filePath := filepath.Join(dir, "model.bin")
+			Expect(URI(server.URL).DownloadFile(filePath, sha, 1, 1, func(string, string, string, float64) {})).To(Succeed())
+			Expect(os.ReadFile(filePath)).To(Equal(content))
+			Expect(requests).To(Equal([]string{""}))
+		})
+
+		It("resumes a partial download", func() {
+			filePath := filepath.Join(dir, "model.bin")
+			Expect(os.WriteFile(filePath+".partial", content[:100], 0644)).To(Succeed())
+			Expect(URI(server.URL).DownloadFile(filePath, sha, 1, 1, func(string, string, string, float64) {})).To(Succeed())
+			Expect(os.ReadFile(filePath)).To(Equal(content))
+			Expect(requests).To(Equal([]string{"bytes=100-"}))
+			Expect(filePath + ".partial").ToNot(BeAnExistingFile())
+		})
+
+		It("detects a corrupted partial file when resuming", func() {
+			filePath := filepath.Join(dir, "model.bin")
+			Expect(os.WriteFile(filePath+".partial", bytes.Repeat([]byte("x"), 100), 0644)).To(Succeed())
+			err := URI(server.URL).DownloadFile(filePath, sha, 1, 1, func(string, string, string, float64) {})
+			Expect(err).To(MatchError(ContainSubstring("SHA mismatch")))
+			Expect(requests).To(Equal([]string{"bytes=100-"}))
+			Expect(filePath).ToNot(BeAnExistingFile())
+		})
+
+		It("stops and removes the partial file when canceled", func() {
+			filePath := filepath.Join(dir, "model.bin")
+			Expect(os.WriteFile(filePath+".partial", content[:100], 0644)).To(Succeed())
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := URI(server.URL).DownloadFileWithContext(ctx, filePath, sha, 1, 1, func(string, string, string, float64) {})
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(filePath).ToNot(BeAnExistingFile())
+			Expect(filePath + ".partial").ToNot(BeAnExistingFile())
+		})
+
+		It("removes the file if the SHA does not match", func() {
+			filePath := filepath.Join(dir, "model.bin")
+			Expect(URI(server.URL).DownloadFile(filePath, "invalid", 1, 1, func(string, string, string, float64) {})).ToNot(Succeed())
+			Expect(filePath).ToNot(BeAnExistingFile())
+		})
+
+		It("fails before writing the files that don't fit on the disk", func() {
+			xsysinfo.SetDiskHeadroom(1 << 62)
+			defer xsysinfo.SetDiskHeadroom(0)
+
+			filePath := filepath.Join(dir, "model.bin")
+			err := URI(server.URL).DownloadFile(filePath, sha, 1, 1, func(string, string, string, float64) {})
+			Expect(err).To(MatchError(xsysinfo.ErrInsufficientDisk))
+			Expect(err).To(MatchError(ContainSubstring("insufficient disk: need 7.0 KiB have")))
+			Expect(filePath + ".partial").ToNot(BeAnExistingFile())
+			Expect(requests).To(HaveLen(1))
+			// the headroom only applies to the writes adding bytes
+			Expect(xsysinfo.CheckDiskSpace(dir, 0)).To(Succeed())
+		})
+
+		It("caps the rate of the downloads together", func() {
+			// two files of 7KiB at 14KiB/s take a second
+			SetMaxDownloadRate(14 * 1024)
+			defer SetMaxDownloadRate(0)
+
+			start := time.Now()
+			errs := make(chan error, 2)
+			for _, name := range []string{"a.bin", "b.bin"} {
+				go func() {
+					errs <- URI(server.URL).DownloadFile(filepath.Join(dir, name), sha, 1, 1, func(string, string, string, float64) {})
+				}()
+			}
+			Expect(<-errs).To(Succeed())
+			Expect(<-errs).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 800*time.Millisecond))
+			Expect(os.ReadFile(filepath.Join(dir, "b.bin"))).To(Equal(content))
+		})
+	})
+
+	Context("Credentials", func() {
+		It("sends the credentials of a host, and not to the hosts it redirects to", func() {
+			var mu sync.Mutex
+			headers := map[string]string{}
+			record := func(name string, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				headers[name] = r.Header.Get("X-Api-Key")
+			}
+			cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				record("cdn", r)
+				w.Write([]byte("model"))
+			}))
+			defer cdn.Close()
+			private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				record(r.URL.Path, r)
+				if r.URL.Path == "/redirect" {
+					http.Redirect(w, r, cdn.URL+"/model.bin", http.StatusFound)
+					return
+				}
+				w.Write([]byte("- name: model\n"))
+			}))
+			defer private.Close()
+
+			SetCredentials([]Credential{{Scheme: "http", Host: strings.TrimPrefix(private.URL, "http://"), Header: "X-Api-Key", Value: "secret"}})
+			defer SetCredentials(nil)
+
+			Expect(URI(private.URL+"/index.yaml").DownloadWithCallback("", func(string, []byte) error { return nil })).To(Succeed())
+			dir := GinkgoT().TempDir()
+			Expect(URI(private.URL+"/redirect").DownloadFile(filepath.Join(dir, "model.bin"), "", 1, 1, func(string, string, string, float64) {})).To(Succeed())
+			Expect(os.ReadFile(filepath.Join(dir, "model.bin"))).To(Equal([]byte("model")))
+			Expect(headers).To(Equal(map[string]string{"/index.yaml": "secret", "/redirect": "secret", "cdn": ""}))
+		})
+
+		It("doesn't send the credentials of an https host in clear", func() {
+			var header string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Get("X-Api-Key")
+				w.Write([]byte("- name: model\n"))
+			}))
+			defer server.Close()
+
+			SetCredentials([]Credential{{Scheme: "https", Host: strings.TrimPrefix(server.URL, "http://"), Header: "X-Api-Key", Value: "secret"}})
+			defer SetCredentials(nil)
+
+			Expect(URI(server.URL+"/index.yaml").DownloadWithCallback("", func(string, []byte) error { return nil })).To(Succeed())
+			Expect(header).To(BeEmpty())
+		})
+	})
+
+	Context("OCI artifacts", func() {
+		var registryServer *httptest.Server
+		var dir string
+
+		// pushArtifact pushes an artifact with a layer per file, as `oras push` does, and returns its reference
+		pushArtifact := func(files map[string][]byte) string {
+			ctx := context.Background()
+			host := strings.TrimPrefix(registryServer.URL, "http://")
+			repo, err := remote.NewRepository(host + "/models/phi")
+			Expect(err).ToNot(HaveOccurred())
+			repo.PlainHTTP = true
+
+			layers := []ocispec.Descriptor{}
+			for name, content := range files {
+				desc, err := oras.PushBytes(ctx, repo, "application/octet-stream", content)
+				Expect(err).ToNot(HaveOccurred())
+				desc.Annotations = map[string]string{ocispec.AnnotationTitle: name}
+				layers = append(layers, desc)
+			}
+			manifest, err := oras.PackManifest(ctx, repo, oras.PackManifestVersion1_1, "application/vnd.localai.model", oras.PackManifestOptions{Layers: layers})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repo.Tag(ctx, manifest, "latest")).To(Succeed())
+			return "oci://" + host + "/models/phi:latest"
+		}
+
+		BeforeEach(func() {
+			registryServer = httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+			dir = GinkgoT().TempDir()
+		})
+
+		AfterEach(func() {
+			registryServer.Close()
+		})
+
+		It("pulls the file of an artifact to the file path and verifies its SHA", func() {
+			content := []byte("weights")
+			reference := pushArtifact(map[string][]byte{"phi.gguf": content, "README.md": []byte("readme")})
+
+			filePath := filepath.Join(dir, "phi-renamed.gguf")
+			err := URI(reference).DownloadFile(filePath, fmt.Sprintf("%x", sha256.Sum256(content)), 1, 1, func(string, string, string, float64) {})
+			Expect(err).To(MatchError(ContainSubstring(`no single file named "phi-renamed.gguf"`)))
+
+			filePath = filepath.Join(dir, "phi.gguf")
+			Expect(URI(reference).DownloadFile(filePath, fmt.Sprintf("%x", sha256.Sum256(content)), 1, 1, func(string, string, string, float64) {})).To(Succeed())
+			Expect(os.ReadFile(filePath)).To(Equal(content))
+			Expect(filepath.Join(dir, "README.md")).ToNot(BeAnExistingFile())
+		})
+
+		It("pulls the single file of an artifact whatever its name", func() {
+			reference := pushArtifact(map[string][]byte{"phi.gguf": []byte("weights")})
+
+			filePath := filepath.Join(dir, "model.bin")
+			Expect(URI(reference).DownloadFile(filePath, "", 1, 1, func(string, string, string, float64) {})).To(Succeed())
+			Expect(os.ReadFile(filePath)).To(Equal([]byte("weights")))
+		})
+
+		It("removes the file if the SHA does not match", func() {
+			reference := pushArtifact(map[string][]byte{"phi.gguf": []byte("weights")})
+
+			filePath := filepath.Join(dir, "phi.gguf")
+			err := URI(reference).DownloadFile(filePath, "invalid", 1, 1, func(string, string, string, float64) {})
+			Expect(err).To(MatchError(ContainSubstring("SHA mismatch")))
+			Expect(filePath).ToNot(BeAnExistingFile())
+		})
+
+		It("fails if the artifact can't be fetched", func() {
+			host := strings.TrimPrefix(registryServer.URL, "http://")
+			err := URI("oci://"+host+"/models/missing:latest").DownloadFile(filepath.Join(dir, "model.bin"), "", 1, 1, func(string, string, string, float64) {})
+			Expect(err).To(MatchError(ContainSubstring("failed to get")))
+		})
 	})
 })
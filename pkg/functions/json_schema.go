@@ -0,0 +1,247 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mudler/LocalAI/pkg/functions/grammars"
+)
+
+// supportedJSONSchemaKeywords are the JSON schema keywords that can be
+// enforced by the grammar (or are annotations that do not constrain the output)
+var supportedJSONSchemaKeywords = map[string]bool{
+	"type":                 true,
+	"properties":           true,
+	"required":             true,
+	"additionalProperties": true,
+	"items":                true,
+	"enum":                 true,
+	"const":                true,
+	"oneOf":                true,
+	"anyOf":                true,
+	"$ref":                 true,
+	"$defs":                true,
+	"$schema":              true,
+	"title":                true,
+	"description":          true,
+	"default":              true,
+	"examples":             true,
+}
+
+// CheckJSONSchema returns an error if the schema uses features that cannot be
+// enforced when constraining the generation with a grammar
+func CheckJSONSchema(schema map[string]interface{}) error {
+	return checkJSONSchema(schema, "#")
+}
+
+func checkJSONSchema(schema map[string]interface{}, path string) error {
+	keywords := []string{}
+	for k := range schema {
+		keywords = append(keywords, k)
+	}
+	sort.Strings(keywords)
+
+	for _, k := range keywords {
+		if !supportedJSONSchemaKeywords[k] {
+			return fmt.Errorf("unsupported JSON schema feature %q at %s", k, path)
+		}
+	}
+
+	if t, exists := schema["type"]; exists {
+		if _, ok := t.(string); !ok {
+			return fmt.Errorf("unsupported JSON schema feature at %s: type must be a single string", path)
+		}
+	}
+
+	if ref, exists := schema["$ref"].(string); exists && !strings.HasPrefix(ref, "#/$defs/") {
+		return fmt.Errorf("unsupported JSON schema feature at %s: only references to #/$defs are supported", path)
+	}
+
+	for _, k := range []string{"properties", "$defs"} {
+		if m, ok := schema[k].(map[string]interface{}); ok {
+			for name, sub := range m {
+				if err := checkSubSchema(sub, fmt.Sprintf("%s/%s/%s", path, k, name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if items, exists := schema["items"]; exists {
+		if err := checkSubSchema(items, path+"/items"); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range []string{"oneOf", "anyOf"} {
+		if alternatives, exists := schema[k]; exists {
+			list, ok := alternatives.([]interface{})
+			if !ok {
+				return fmt.Errorf("invalid JSON schema at %s/%s: expected an array", path, k)
+			}
+			for i, sub := range list {
+				if err := checkSubSchema(sub, fmt.Sprintf("%s/%s/%d", path, k, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkSubSchema(sub interface{}, path string) error {
+	m, ok := sub.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid JSON schema at %s: expected an object", path)
+	}
+	return checkJSONSchema(m, path)
+}
+
+// JSONSchemaGrammar converts a JSON schema into a BNF grammar constraining the output to match it
+func JSONSchemaGrammar(schema map[string]interface{}, propOrder string) (string, error) {
+	if err := CheckJSONSchema(schema); err != nil {
+		return "", err
+	}
+
+	dat, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	return grammars.NewJSONSchemaConverter(propOrder).GrammarFromBytes(dat)
+}
+
+// ValidateJSONSchema checks that the JSON document matches the schema.
+// It supports the same keywords as CheckJSONSchema.
+func ValidateJSONSchema(schema map[string]interface{}, document string) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		return fmt.Errorf("output is not valid JSON: %w", err)
+	}
+	return validateJSONSchema(schema, value, schema, "$")
+}
+
+func validateJSONSchema(schema map[string]interface{}, value interface{}, root map[string]interface{}, path string) error {
+	if ref, exists := schema["$ref"].(string); exists {
+		defs, _ := root["$defs"].(map[string]interface{})
+		def, ok := defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: definition not found: %s", path, ref)
+		}
+		return validateJSONSchema(def, value, root, path)
+	}
+
+	if alternatives, ok := schema["anyOf"].([]interface{}); ok {
+		if countMatchingSchemas(alternatives, value, root, path) == 0 {
+			return fmt.Errorf("%s: does not match any of the schemas in anyOf", path)
+		}
+	}
+
+	if alternatives, ok := schema["oneOf"].([]interface{}); ok {
+		if countMatchingSchemas(alternatives, value, root, path) != 1 {
+			return fmt.Errorf("%s: does not match exactly one of the schemas in oneOf", path)
+		}
+	}
+
+	if constVal, exists := schema["const"]; exists && !reflect.DeepEqual(constVal, value) {
+		return fmt.Errorf("%s: expected %v", path, constVal)
+	}
+
+	if enumVals, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enumVals {
+			if reflect.DeepEqual(v, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enumVals)
+		}
+	}
+
+	schemaType, _ := schema["type"].(string)
+	if schemaType != "" && !matchesJSONType(schemaType, value) {
+		return fmt.Errorf("%s: expected %s", path, schemaType)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, exists := v[name]; !exists {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			propSchema, ok := properties[k].(map[string]interface{})
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, k)
+				}
+				continue
+			}
+			if err := validateJSONSchema(propSchema, v[k], root, path+"."+k); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateJSONSchema(items, item, root, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func countMatchingSchemas(alternatives []interface{}, value interface{}, root map[string]interface{}, path string) int {
+	matches := 0
+	for _, alternative := range alternatives {
+		if s, ok := alternative.(map[string]interface{}); ok && validateJSONSchema(s, value, root, path) == nil {
+			matches++
+		}
+	}
+	return matches
+}
+
+func matchesJSONType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package functions_test
+
+import (
+	"encoding/json"
+
+	. "github.com/mudler/LocalAI/pkg/functions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func mustSchema(s string) map[string]interface{} {
+	var schema map[string]interface{}
+	Expect(json.Unmarshal([]byte(s), &schema)).To(Succeed())
+	return schema
+}
+
+var _ = Describe("LocalAI structured outputs", func() {
+	personSchema := `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"age": {"type": "integer"},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"address": {"$ref": "#/$defs/address"}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"$defs": {
+			"address": {"type": "object", "properties": {"city": {"type": "string"}}}
+		}
+	}`
+
+	Context("CheckJSONSchema", func() {
+		It("accepts the supported keywords", func() {
+			Expect(CheckJSONSchema(mustSchema(personSchema))).To(Succeed())
+		})
+
+		It("rejects unsupported keywords", func() {
+			err := CheckJSONSchema(mustSchema(`{"type": "object", "properties": {"name": {"type": "string", "pattern": "^[a-z]+$"}}}`))
+			Expect(err).To(MatchError(`unsupported JSON schema feature "pattern" at #/properties/name`))
+		})
+
+		It("rejects multiple types", func() {
+			err := CheckJSONSchema(mustSchema(`{"type": ["string", "null"]}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("JSONSchemaGrammar", func() {
+		It("converts the schema to a grammar", func() {
+			grammar, err := JSONSchemaGrammar(mustSchema(personSchema), "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(grammar).To(ContainSubstring(`root ::=`))
+			Expect(grammar).To(ContainSubstring(`"\"admin\""`))
+		})
+
+		It("returns an error for unsupported schemas", func() {
+			_, err := JSONSchemaGrammar(mustSchema(`{"type": "string", "format": "email"}`), "")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("ValidateJSONSchema", func() {
+		schema := mustSchema(personSchema)
+
+		It("accepts matching documents", func() {
+			Expect(ValidateJSONSchema(schema, `{"name": "Ada", "age": 36, "role": "admin", "tags": ["math"], "address": {"city": "London"}}`)).To(Succeed())
+		})
+
+		It("rejects invalid JSON", func() {
+			Expect(ValidateJSONSchema(schema, `{"name": "Ada"`)).To(HaveOccurred())
+		})
+
+		It("rejects documents that do not match", func() {
+			Expect(ValidateJSONSchema(schema, `{"name": "Ada"}`)).To(MatchError(`$: missing required property "age"`))
+			Expect(ValidateJSONSchema(schema, `{"name": "Ada", "age": 36.5}`)).To(MatchError(`$.age: expected integer`))
+			Expect(ValidateJSONSchema(schema, `{"name": "Ada", "age": 36, "role": "root"}`)).To(HaveOccurred())
+			Expect(ValidateJSONSchema(schema, `{"name": "Ada", "age": 36, "tags": [1]}`)).To(MatchError(`$.tags[0]: expected string`))
+			Expect(ValidateJSONSchema(schema, `{"name": "Ada", "age": 36, "email": "ada@example.com"}`)).To(MatchError(`$: unexpected property "email"`))
+			Expect(ValidateJSONSchema(schema, `{"name": "Ada", "age": 36, "address": {"city": 1}}`)).To(MatchError(`$.address.city: expected string`))
+		})
+
+		It("validates alternatives", func() {
+			s := mustSchema(`{"anyOf": [{"type": "string"}, {"type": "integer"}]}`)
+			Expect(ValidateJSONSchema(s, `"foo"`)).To(Succeed())
+			Expect(ValidateJSONSchema(s, `1`)).To(Succeed())
+			Expect(ValidateJSONSchema(s, `true`)).To(HaveOccurred())
+		})
+	})
+})
@@ -87,6 +87,10 @@ type FunctionsConfig struct {
 	// This might be useful for certain models trained with the function name as the first token.
 	FunctionNameKey      string `yaml:"function_name_key"`
 	FunctionArgumentsKey string `yaml:"function_arguments_key"`
+
+	// ToolCallParser selects the format used to extract the tool calls from the response:
+	// json (default), llama3, hermes or mistral. When not set, it is guessed from the model family
+	ToolCallParser string `yaml:"tool_call_parser"`
 }
 
 type ReplaceResult struct {
@@ -216,6 +220,10 @@ func ParseFunctionCall(llmresult string, functionConfig FunctionsConfig) []FuncC
 	}
 	log.Debug().Msgf("LLM result(function cleanup): %s", llmresult)
 
+	if results, ok := parseNamedToolCalls(llmresult, functionConfig); ok {
+		return results
+	}
+
 	functionNameKey := defaultFunctionNameKey
 	functionArgumentsKey := defaultFunctionArgumentsKey
 	if functionConfig.FunctionNameKey != "" {
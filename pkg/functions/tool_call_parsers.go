@@ -0,0 +1,147 @@
+package functions
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Named tool call parsers, selectable with `function.tool_call_parser` in the model config
+const (
+	ToolCallParserJSON    = "json"
+	ToolCallParserLLaMa3  = "llama3"
+	ToolCallParserHermes  = "hermes"
+	ToolCallParserMistral = "mistral"
+)
+
+// toolCallParser extracts the tool calls from the raw LLM output in the
+// format a model family was trained with
+type toolCallParser func(llmresult string) []FuncCallResults
+
+var toolCallParsers = map[string]toolCallParser{
+	ToolCallParserLLaMa3:  parseLLaMa3ToolCalls,
+	ToolCallParserHermes:  parseHermesToolCalls,
+	ToolCallParserMistral: parseMistralToolCalls,
+}
+
+var (
+	hermesToolCallRegex   = regexp.MustCompile(`(?s)<tool_call>\s*(.*?)\s*(?:</tool_call>|$)`)
+	llama3FunctionRegex   = regexp.MustCompile(`(?s)<function=([^>]+)>(.*?)</function>`)
+	llama3PythonTagPrefix = "<|python_tag|>"
+	mistralToolCallsToken = "[TOOL_CALLS]"
+)
+
+// parseNamedToolCalls parses the LLM output with the parser named in the config.
+// It returns false if no named parser is configured, or if it found no tool call,
+// so that the generic parsing can take over.
+func parseNamedToolCalls(llmresult string, functionConfig FunctionsConfig) ([]FuncCallResults, bool) {
+	name := functionConfig.ToolCallParser
+	if name == "" || name == ToolCallParserJSON {
+		return nil, false
+	}
+
+	parser, ok := toolCallParsers[name]
+	if !ok {
+		log.Warn().Str("parser", name).Msg("unknown tool call parser, falling back to the default JSON parser")
+		return nil, false
+	}
+
+	results := parser(llmresult)
+	log.Debug().Str("parser", name).Msgf("Tool calls: %+v", results)
+	return results, len(results) > 0
+}
+
+// toolCallsFromObjects maps decoded JSON objects to tool calls, reading the
+// function name and arguments from the given keys
+func toolCallsFromObjects(objs []map[string]any, nameKey string, argumentsKeys ...string) []FuncCallResults {
+	results := []FuncCallResults{}
+	for _, obj := range objs {
+		name, ok := obj[nameKey].(string)
+		if !ok || name == "" {
+			continue
+		}
+
+		var args any = map[string]any{}
+		for _, k := range argumentsKeys {
+			if v, ok := obj[k]; ok {
+				args = v
+				break
+			}
+		}
+
+		results = append(results, FuncCallResults{Name: name, Arguments: stringifyArguments(args)})
+	}
+	return results
+}
+
+// stringifyArguments returns the arguments as a JSON string, as OpenAI does.
+// Arguments that are already a string are returned as they are.
+func stringifyArguments(args any) string {
+	if s, ok := args.(string); ok {
+		return s
+	}
+	d, _ := json.Marshal(args)
+	return string(d)
+}
+
+// parseJSONObjects decodes a JSON array of objects, or a sequence of JSON objects
+func parseJSONObjects(s string) []map[string]any {
+	s = strings.TrimSpace(s)
+	var objs []map[string]any
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &objs); err == nil {
+			return objs
+		}
+		s = strings.TrimPrefix(s, "[")
+	}
+	objs, err := ParseJSON(s)
+	if err != nil {
+		log.Debug().Err(err).Str("llmresult", s).Msg("unable to parse tool calls")
+	}
+	return objs
+}
+
+// parseHermesToolCalls parses calls wrapped in <tool_call></tool_call> tags, e.g.
+// <tool_call>{"name": "get_weather", "arguments": {"location": "Rome"}}</tool_call>
+func parseHermesToolCalls(llmresult string) []FuncCallResults {
+	results := []FuncCallResults{}
+	for _, m := range hermesToolCallRegex.FindAllStringSubmatch(llmresult, -1) {
+		results = append(results, toolCallsFromObjects(parseJSONObjects(m[1]), defaultFunctionNameKey, defaultFunctionArgumentsKey)...)
+	}
+	return results
+}
+
+// parseLLaMa3ToolCalls parses the LLaMa 3.1 formats: JSON calls with "parameters",
+// optionally after <|python_tag|> and separated by ";", or
+// <function=get_weather>{"location": "Rome"}</function>
+func parseLLaMa3ToolCalls(llmresult string) []FuncCallResults {
+	results := []FuncCallResults{}
+	for _, m := range llama3FunctionRegex.FindAllStringSubmatch(llmresult, -1) {
+		var args any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(m[2])), &args); err != nil {
+			args = strings.TrimSpace(m[2])
+		}
+		results = append(results, FuncCallResults{Name: strings.TrimSpace(m[1]), Arguments: stringifyArguments(args)})
+	}
+	if len(results) > 0 {
+		return results
+	}
+
+	if i := strings.Index(llmresult, llama3PythonTagPrefix); i >= 0 {
+		llmresult = llmresult[i+len(llama3PythonTagPrefix):]
+	}
+	// the calls are decoded one after the other, skipping the ";" between them:
+	// the arguments can contain ";" as well
+	return toolCallsFromObjects(parseJSONObjects(llmresult), defaultFunctionNameKey, "parameters", defaultFunctionArgumentsKey)
+}
+
+// parseMistralToolCalls parses a JSON array of calls after the [TOOL_CALLS] token, e.g.
+// [TOOL_CALLS] [{"name": "get_weather", "arguments": {"location": "Rome"}}]
+func parseMistralToolCalls(llmresult string) []FuncCallResults {
+	if i := strings.Index(llmresult, mistralToolCallsToken); i >= 0 {
+		llmresult = llmresult[i+len(mistralToolCallsToken):]
+	}
+	return toolCallsFromObjects(parseJSONObjects(llmresult), defaultFunctionNameKey, defaultFunctionArgumentsKey)
+}
@@ -0,0 +1,130 @@
+package functions_test
+
+import (
+	. "github.com/mudler/LocalAI/pkg/functions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LocalAI tool call parsers", func() {
+	var functionConfig FunctionsConfig
+
+	BeforeEach(func() {
+		functionConfig = FunctionsConfig{}
+	})
+
+	Context("hermes", func() {
+		BeforeEach(func() {
+			functionConfig.ToolCallParser = ToolCallParserHermes
+		})
+
+		It("parses calls wrapped in tool_call tags", func() {
+			input := "<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"location\": \"Rome\", \"unit\": \"celsius\"}}\n</tool_call>\n<tool_call>\n{\"name\": \"get_time\", \"arguments\": {\"timezone\": \"CET\"}}\n</tool_call>"
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("get_weather"))
+			Expect(results[0].Arguments).To(Equal(`{"location":"Rome","unit":"celsius"}`))
+			Expect(results[1].Name).To(Equal("get_time"))
+			Expect(results[1].Arguments).To(Equal(`{"timezone":"CET"}`))
+		})
+
+		It("parses a call with a missing closing tag", func() {
+			input := `Let me check. <tool_call>{"name": "get_weather", "arguments": {"location": "Rome"}}`
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("get_weather"))
+			Expect(results[0].Arguments).To(Equal(`{"location":"Rome"}`))
+		})
+	})
+
+	Context("llama3", func() {
+		BeforeEach(func() {
+			functionConfig.ToolCallParser = ToolCallParserLLaMa3
+		})
+
+		It("parses JSON calls after the python tag", func() {
+			input := `<|python_tag|>{"name": "get_weather", "parameters": {"location": "Rome"}}; {"name": "get_time", "parameters": {"timezone": "CET"}}`
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("get_weather"))
+			Expect(results[0].Arguments).To(Equal(`{"location":"Rome"}`))
+			Expect(results[1].Name).To(Equal("get_time"))
+			Expect(results[1].Arguments).To(Equal(`{"timezone":"CET"}`))
+		})
+
+		It("keeps the separators in the arguments", func() {
+			input := `<|python_tag|>{"name": "run_query", "parameters": {"sql": "SELECT 1; SELECT 2"}};{"name": "get_time", "parameters": {"timezone": "CET"}}`
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("run_query"))
+			Expect(results[0].Arguments).To(Equal(`{"sql":"SELECT 1; SELECT 2"}`))
+			Expect(results[1].Name).To(Equal("get_time"))
+		})
+
+		It("parses function tags", func() {
+			input := `<function=get_weather>{"location": "Rome"}</function>`
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("get_weather"))
+			Expect(results[0].Arguments).To(Equal(`{"location":"Rome"}`))
+		})
+	})
+
+	Context("mistral", func() {
+		BeforeEach(func() {
+			functionConfig.ToolCallParser = ToolCallParserMistral
+		})
+
+		It("parses the array of calls after the TOOL_CALLS token", func() {
+			input := `[TOOL_CALLS] [{"name": "get_weather", "arguments": {"location": "Rome"}}, {"name": "get_time", "arguments": {"timezone": "CET"}}]`
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("get_weather"))
+			Expect(results[0].Arguments).To(Equal(`{"location":"Rome"}`))
+			Expect(results[1].Name).To(Equal("get_time"))
+			Expect(results[1].Arguments).To(Equal(`{"timezone":"CET"}`))
+		})
+
+		It("parses the array when the token is stripped from the output", func() {
+			input := `[{"name": "get_weather", "arguments": {"location": "Rome"}}]`
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("get_weather"))
+			Expect(results[0].Arguments).To(Equal(`{"location":"Rome"}`))
+		})
+	})
+
+	Context("fallback", func() {
+		It("uses the generic parser when the named parser finds no call", func() {
+			functionConfig.ToolCallParser = ToolCallParserHermes
+			functionConfig.FunctionNameKey = "function"
+			input := `{"function": "add", "arguments": {"x": 5, "y": 3}}`
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("add"))
+			Expect(results[0].Arguments).To(Equal(`{"x":5,"y":3}`))
+		})
+
+		It("uses the generic parser for unknown parsers", func() {
+			functionConfig.ToolCallParser = "unknown"
+			input := `{"name": "add", "arguments": {"x": 5, "y": 3}}`
+
+			results := ParseFunctionCall(input, functionConfig)
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("add"))
+		})
+
+		It("returns no calls for plain text", func() {
+			functionConfig.ToolCallParser = ToolCallParserLLaMa3
+			Expect(ParseFunctionCall("The weather in Rome is sunny; enjoy.", functionConfig)).To(BeEmpty())
+		})
+	})
+})
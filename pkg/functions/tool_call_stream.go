@@ -0,0 +1,392 @@
+package functions
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ToolCallDelta is a fragment of a tool call streamed while the LLM generates it, as OpenAI does:
+// the first fragment of a call carries its name, and the arguments of the call are the concatenation
+// of the Arguments of its fragments
+type ToolCallDelta struct {
+	Index     int
+	Name      string
+	Arguments string
+}
+
+// ToolCallStream extracts the tool calls from the LLM output token by token. The calls are read as
+// JSON objects with the function name and arguments keys of the config, or in the format of the
+// llama3 tool call parser, and their arguments are streamed as they are generated. The output is
+// parsed incrementally: each token is read once.
+//
+// The configs rewriting the output with regexes can not be parsed before the output is complete:
+// their calls are only sent by Finish.
+type ToolCallStream struct {
+	config        FunctionsConfig
+	noAction      string
+	nameKey       string
+	argumentsKeys []string
+	text          strings.Builder
+
+	// pos is the index of the output where the search of the next call resumes
+	pos int
+	// pending is the call being read, nil between the calls
+	pending *callScanner
+	// calls are the calls read so far, the last one possibly partial
+	calls []partialToolCall
+
+	// streamed is the number of bytes of the arguments of each call already sent
+	streamed []int
+	// answering is set when the LLM chose the no action function: its output is an answer, not calls
+	answering bool
+}
+
+// NewToolCallStream returns a ToolCallStream for the functions config of the model. The output of
+// the LLM choosing noAction first is an answer, no tool call is streamed from it.
+func NewToolCallStream(config FunctionsConfig, noAction string) *ToolCallStream {
+	s := &ToolCallStream{
+		config:        config,
+		noAction:      noAction,
+		nameKey:       defaultFunctionNameKey,
+		argumentsKeys: []string{defaultFunctionArgumentsKey},
+	}
+	if config.FunctionNameKey != "" {
+		s.nameKey = config.FunctionNameKey
+	}
+	if config.FunctionArgumentsKey != "" {
+		s.argumentsKeys = []string{config.FunctionArgumentsKey}
+	}
+	if config.ToolCallParser == ToolCallParserLLaMa3 {
+		s.argumentsKeys = []string{"parameters", defaultFunctionArgumentsKey}
+	}
+	return s
+}
+
+// Streamable reports whether the tool calls can be parsed from the partial output with the config
+func (s *ToolCallStream) Streamable() bool {
+	c := s.config
+	return len(c.ResponseRegex) == 0 && len(c.JSONRegexMatch) == 0 &&
+		len(c.ReplaceFunctionResults) == 0 && len(c.ReplaceLLMResult) == 0
+}
+
+// Push appends a token of the LLM output and returns the tool call fragments it completes
+func (s *ToolCallStream) Push(token string) []ToolCallDelta {
+	s.text.WriteString(token)
+	if s.answering || !s.Streamable() {
+		return nil
+	}
+	s.scan()
+
+	deltas := []ToolCallDelta{}
+	// the calls before the last one streamed were sent whole
+	for i := max(len(s.streamed)-1, 0); i < len(s.calls); i++ {
+		call := s.calls[i]
+		if i == len(s.streamed) {
+			if i == 0 && call.name == s.noAction {
+				s.answering = true
+				return nil
+			}
+			s.streamed = append(s.streamed, 0)
+			deltas = append(deltas, ToolCallDelta{Index: i, Name: call.name})
+		}
+		args := call.arguments
+		if strings.HasPrefix(args, `"`) {
+			// the arguments are encoded as a string, sent once decoded
+			if !call.complete || s.streamed[i] > 0 {
+				continue
+			}
+			var decoded string
+			if err := json.Unmarshal([]byte(args), &decoded); err != nil {
+				continue
+			}
+			args = decoded
+		}
+		if len(args) > s.streamed[i] {
+			deltas = append(deltas, ToolCallDelta{Index: i, Arguments: args[s.streamed[i]:]})
+			s.streamed[i] = len(args)
+		}
+	}
+	return deltas
+}
+
+// Streamed returns the number of tool calls sent by Push
+func (s *ToolCallStream) Streamed() int {
+	return len(s.streamed)
+}
+
+// Finish returns the fragments of the parsed tool calls of the complete output which were not sent
+// by Push. The calls Push sent are complete: their arguments were streamed as generated.
+func (s *ToolCallStream) Finish(results []FuncCallResults) []ToolCallDelta {
+	deltas := []ToolCallDelta{}
+	for i := len(s.streamed); i < len(results); i++ {
+		deltas = append(deltas,
+			ToolCallDelta{Index: i, Name: results[i].Name},
+			ToolCallDelta{Index: i, Arguments: results[i].Arguments},
+		)
+		s.streamed = append(s.streamed, len(results[i].Arguments))
+	}
+	return deltas
+}
+
+// partialToolCall is a tool call read from a partial output
+type partialToolCall struct {
+	name string
+	// arguments is the raw JSON of the arguments generated so far
+	arguments string
+	complete  bool
+}
+
+const llama3FunctionPrefix = "<function="
+
+// scan reads the output generated since the previous scan, resuming the call being read. The calls
+// are added to s.calls once their name is read.
+func (s *ToolCallStream) scan() {
+	text := s.text.String()
+	llama3 := s.config.ToolCallParser == ToolCallParserLLaMa3
+	for {
+		if s.pending == nil {
+			for s.pos < len(text) && s.pending == nil {
+				switch {
+				case llama3 && strings.HasPrefix(text[s.pos:], llama3FunctionPrefix):
+					s.pending = &callScanner{llama3: true, pos: s.pos + len(llama3FunctionPrefix), state: scanLLaMa3Name, index: -1}
+				case llama3 && strings.HasPrefix(llama3FunctionPrefix, text[s.pos:]):
+					// the prefix of a call may be cut by the end of the output
+					return
+				case text[s.pos] == '{':
+					s.pending = &callScanner{pos: s.pos + 1, index: -1, fields: map[string]partialJSONValue{}}
+				default:
+					s.pos++
+				}
+			}
+			if s.pending == nil {
+				return
+			}
+		}
+
+		c := s.pending
+		done := c.scan(text)
+		call := c.call(s.nameKey, s.argumentsKeys)
+		switch {
+		case c.index >= 0:
+			s.calls[c.index] = call
+		case call.name != "":
+			c.index = len(s.calls)
+			s.calls = append(s.calls, call)
+		}
+		if !done {
+			return
+		}
+		s.pos, s.pending = c.pos, nil
+	}
+}
+
+// callScanner reads a tool call incrementally, as a JSON object or a llama3
+// <function=name>{arguments}</function> call
+type callScanner struct {
+	llama3 bool
+	// pos is the index of the output where the scan resumes
+	pos   int
+	state scanState
+	// value reads the key or the value of a field, or the arguments of a llama3 call
+	value jsonValueScanner
+	key   string
+
+	fields map[string]partialJSONValue
+	// name is the name of the call, set once read
+	name string
+	// arguments are the arguments of a llama3 call
+	arguments partialJSONValue
+
+	// index is the index of the call in the calls of the stream, -1 until its name is read
+	index int
+}
+
+type scanState int
+
+const (
+	// JSON objects
+	scanField scanState = iota
+	scanKey
+	scanColon
+	scanValueStart
+	scanValue
+	// llama3 calls
+	scanLLaMa3Name
+	scanLLaMa3ArgumentsStart
+	scanLLaMa3Arguments
+)
+
+// partialJSONValue is the raw JSON of a value, possibly cut by the end of the output
+type partialJSONValue struct {
+	raw      string
+	complete bool
+}
+
+// call returns the call read so far
+func (c *callScanner) call(nameKey string, argumentsKeys []string) partialToolCall {
+	if c.llama3 {
+		return partialToolCall{name: c.name, arguments: c.arguments.raw, complete: c.arguments.complete}
+	}
+	if name, ok := c.fields[nameKey]; ok && name.complete && c.name == "" {
+		if err := json.Unmarshal([]byte(name.raw), &c.name); err != nil {
+			c.name = ""
+		}
+	}
+	call := partialToolCall{name: c.name}
+	for _, k := range argumentsKeys {
+		if v, ok := c.fields[k]; ok {
+			call.arguments, call.complete = v.raw, v.complete
+			break
+		}
+	}
+	return call
+}
+
+// scan reads the call from the output, and returns whether the call ended
+func (c *callScanner) scan(text string) bool {
+	if c.llama3 {
+		return c.scanLLaMa3(text)
+	}
+	for {
+		switch c.state {
+		case scanField:
+			c.pos = skipSpaces(text, c.pos)
+			if c.pos == len(text) {
+				return false
+			}
+			switch text[c.pos] {
+			case '}':
+				c.pos++
+				return true
+			case ',':
+				c.pos++
+			case '"':
+				c.value = jsonValueScanner{start: c.pos, pos: c.pos}
+				c.state = scanKey
+			default:
+				// not JSON, the object is skipped
+				return true
+			}
+		case scanKey:
+			end, complete := c.value.scan(text)
+			if !complete {
+				return false
+			}
+			c.pos = end
+			if err := json.Unmarshal([]byte(text[c.value.start:end]), &c.key); err != nil {
+				return true
+			}
+			c.state = scanColon
+		case scanColon:
+			c.pos = skipSpaces(text, c.pos)
+			if c.pos == len(text) {
+				return false
+			}
+			if text[c.pos] != ':' {
+				return true
+			}
+			c.pos++
+			c.state = scanValueStart
+		case scanValueStart:
+			c.pos = skipSpaces(text, c.pos)
+			if c.pos == len(text) {
+				return false
+			}
+			c.value = jsonValueScanner{start: c.pos, pos: c.pos}
+			c.state = scanValue
+		case scanValue:
+			end, complete := c.value.scan(text)
+			c.fields[c.key] = partialJSONValue{raw: text[c.value.start:end], complete: complete}
+			if !complete {
+				return false
+			}
+			c.pos = end
+			c.state = scanField
+		}
+	}
+}
+
+// scanLLaMa3 reads a llama3 call, from the start of its name
+func (c *callScanner) scanLLaMa3(text string) bool {
+	for {
+		switch c.state {
+		case scanLLaMa3Name:
+			closing := strings.IndexByte(text[c.pos:], '>')
+			if closing < 0 {
+				return false
+			}
+			c.name = strings.TrimSpace(text[c.pos : c.pos+closing])
+			c.pos += closing + 1
+			c.state = scanLLaMa3ArgumentsStart
+		case scanLLaMa3ArgumentsStart:
+			c.pos = skipSpaces(text, c.pos)
+			if c.pos == len(text) {
+				return false
+			}
+			if text[c.pos] != '{' {
+				// a call without arguments
+				return true
+			}
+			c.value = jsonValueScanner{start: c.pos, pos: c.pos}
+			c.state = scanLLaMa3Arguments
+		case scanLLaMa3Arguments:
+			end, complete := c.value.scan(text)
+			c.arguments = partialJSONValue{raw: text[c.value.start:end], complete: complete}
+			c.pos = end
+			return complete
+		}
+	}
+}
+
+// jsonValueScanner finds the end of a JSON value, resuming where the previous scan stopped
+type jsonValueScanner struct {
+	start, pos int
+	depth      int
+	inString   bool
+	escaped    bool
+}
+
+// scan returns the index following the JSON value, and whether the value is complete rather than
+// cut by the end of the text
+func (v *jsonValueScanner) scan(text string) (int, bool) {
+	for ; v.pos < len(text); v.pos++ {
+		c := text[v.pos]
+		switch {
+		case v.inString:
+			switch {
+			case v.escaped:
+				v.escaped = false
+			case c == '\\':
+				v.escaped = true
+			case c == '"':
+				v.inString = false
+				if v.depth == 0 {
+					return v.pos + 1, true
+				}
+			}
+		case c == '"':
+			v.inString = true
+		case c == '{' || c == '[':
+			v.depth++
+		case c == '}' || c == ']':
+			if v.depth == 0 {
+				return v.pos, true
+			}
+			v.depth--
+			if v.depth == 0 {
+				return v.pos + 1, true
+			}
+		case v.depth == 0 && (c == ',' || c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			// end of a number or literal
+			return v.pos, true
+		}
+	}
+	return len(text), false
+}
+
+func skipSpaces(text string, i int) int {
+	for i < len(text) && strings.IndexByte(" \t\n\r", text[i]) >= 0 {
+		i++
+	}
+	return i
+}
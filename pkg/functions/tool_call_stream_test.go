@@ -0,0 +1,126 @@
+package functions_test
+
+import (
+	. "github.com/mudler/LocalAI/pkg/functions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// streamToolCalls pushes the output to the stream a few bytes at a time, as the tokens
+// of an LLM, and rebuilds the tool calls from the fragments
+func streamToolCalls(stream *ToolCallStream, output string, results []FuncCallResults) (calls []FuncCallResults, fragments int) {
+	apply := func(deltas []ToolCallDelta) {
+		for _, d := range deltas {
+			Expect(d.Index).To(BeNumerically("<=", len(calls)))
+			if d.Index == len(calls) {
+				Expect(d.Name).ToNot(BeEmpty(), "the first fragment of a call carries its name")
+				calls = append(calls, FuncCallResults{})
+			}
+			calls[d.Index].Name += d.Name
+			calls[d.Index].Arguments += d.Arguments
+			fragments++
+		}
+	}
+	for i := 0; i < len(output); i += 3 {
+		apply(stream.Push(output[i:min(i+3, len(output))]))
+	}
+	apply(stream.Finish(results))
+	return calls, fragments
+}
+
+var _ = Describe("ToolCallStream", func() {
+	var functionConfig FunctionsConfig
+
+	BeforeEach(func() {
+		functionConfig = FunctionsConfig{}
+	})
+
+	It("streams the arguments of the calls as they are generated", func() {
+		output := `[{"name": "get_weather", "arguments": {"location": "Rome", "unit": "celsius"}}, {"name": "get_time", "arguments": {"timezone": "Europe/Rome"}}]`
+
+		calls, fragments := streamToolCalls(NewToolCallStream(functionConfig, "answer"), output, ParseFunctionCall(output, functionConfig))
+		Expect(calls).To(HaveLen(2))
+		Expect(calls[0].Name).To(Equal("get_weather"))
+		Expect(calls[0].Arguments).To(MatchJSON(`{"location": "Rome", "unit": "celsius"}`))
+		Expect(calls[1].Name).To(Equal("get_time"))
+		Expect(calls[1].Arguments).To(MatchJSON(`{"timezone": "Europe/Rome"}`))
+		Expect(fragments).To(BeNumerically(">", 10))
+	})
+
+	It("waits for the name of a call before streaming its arguments", func() {
+		stream := NewToolCallStream(functionConfig, "answer")
+		Expect(stream.Push(`{"arguments": {"location": "Ro`)).To(BeEmpty())
+		Expect(stream.Push(`me"}, "name": "get_wea`)).To(BeEmpty())
+		Expect(stream.Push(`ther"}`)).To(Equal([]ToolCallDelta{
+			{Index: 0, Name: "get_weather"},
+			{Index: 0, Arguments: `{"location": "Rome"}`},
+		}))
+	})
+
+	It("decodes the arguments encoded as a string", func() {
+		output := `{"name": "get_weather", "arguments": "{\"location\": \"Rome\"}"}`
+
+		calls, _ := streamToolCalls(NewToolCallStream(functionConfig, "answer"), output, nil)
+		Expect(calls).To(Equal([]FuncCallResults{{Name: "get_weather", Arguments: `{"location": "Rome"}`}}))
+	})
+
+	It("uses the name and arguments keys of the config", func() {
+		functionConfig.FunctionNameKey = "function"
+		functionConfig.FunctionArgumentsKey = "params"
+		output := `{"function": "get_weather", "params": {"location": "Rome"}}`
+
+		calls, _ := streamToolCalls(NewToolCallStream(functionConfig, "answer"), output, ParseFunctionCall(output, functionConfig))
+		Expect(calls).To(HaveLen(1))
+		Expect(calls[0].Name).To(Equal("get_weather"))
+		Expect(calls[0].Arguments).To(MatchJSON(`{"location": "Rome"}`))
+	})
+
+	It("streams the calls in the formats of the tool call parsers", func() {
+		for parser, output := range map[string]string{
+			ToolCallParserHermes:  "<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"location\": \"Rome\"}}\n</tool_call>",
+			ToolCallParserMistral: `[TOOL_CALLS] [{"name": "get_weather", "arguments": {"location": "Rome"}}]`,
+			ToolCallParserLLaMa3:  `<function=get_weather>{"location": "Rome"}</function>`,
+		} {
+			functionConfig.ToolCallParser = parser
+			calls, _ := streamToolCalls(NewToolCallStream(functionConfig, "answer"), output, ParseFunctionCall(output, functionConfig))
+			Expect(calls).To(HaveLen(1), parser)
+			Expect(calls[0].Name).To(Equal("get_weather"), parser)
+			Expect(calls[0].Arguments).To(MatchJSON(`{"location": "Rome"}`), parser)
+		}
+	})
+
+	It("reads the calls cut anywhere between the tokens", func() {
+		functionConfig.ToolCallParser = ToolCallParserLLaMa3
+		stream := NewToolCallStream(functionConfig, "answer")
+		Expect(stream.Push(`<func`)).To(BeEmpty())
+		Expect(stream.Push(`tion=get_weather`)).To(BeEmpty())
+		Expect(stream.Push(`> {"location": `)).To(Equal([]ToolCallDelta{
+			{Index: 0, Name: "get_weather"},
+			{Index: 0, Arguments: `{"location": `},
+		}))
+		Expect(stream.Push(`"Rome"}</function><function=get_time>{}`)).To(Equal([]ToolCallDelta{
+			{Index: 0, Arguments: `"Rome"}`},
+			{Index: 1, Name: "get_time"},
+			{Index: 1, Arguments: `{}`},
+		}))
+	})
+
+	It("streams no call when the LLM chooses to answer", func() {
+		stream := NewToolCallStream(functionConfig, "answer")
+		for _, token := range []string{`{"name": "ans`, `wer", "arguments": {"message": "Hi"}}`} {
+			Expect(stream.Push(token)).To(BeEmpty())
+		}
+		Expect(stream.Streamed()).To(BeZero())
+	})
+
+	It("sends the calls whole when the config rewrites the output", func() {
+		functionConfig.ResponseRegex = []string{`(?P<name>\w+)\((?P<arguments>.*)\)`}
+		output := `get_weather({"location": "Rome"})`
+		stream := NewToolCallStream(functionConfig, "answer")
+		Expect(stream.Streamable()).To(BeFalse())
+
+		calls, fragments := streamToolCalls(stream, output, ParseFunctionCall(output, functionConfig))
+		Expect(calls).To(Equal([]FuncCallResults{{Name: "get_weather", Arguments: `{"location": "Rome"}`}}))
+		Expect(fragments).To(Equal(2))
+	})
+})
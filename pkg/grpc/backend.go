@@ -35,14 +35,18 @@ type Backend interface {
 	IsBusy() bool
 	HealthCheck(ctx context.Context) (bool, error)
 	Embeddings(ctx context.Context, in *pb.PredictOptions, opts ...grpc.CallOption) (*pb.EmbeddingResult, error)
+	EmbeddingBatch(ctx context.Context, in *pb.EmbeddingBatchRequest, opts ...grpc.CallOption) (*pb.EmbeddingBatchResult, error)
 	Predict(ctx context.Context, in *pb.PredictOptions, opts ...grpc.CallOption) (*pb.Reply, error)
 	LoadModel(ctx context.Context, in *pb.ModelOptions, opts ...grpc.CallOption) (*pb.Result, error)
-	PredictStream(ctx context.Context, in *pb.PredictOptions, f func(s []byte), opts ...grpc.CallOption) error
+	PredictStream(ctx context.Context, in *pb.PredictOptions, f func(reply *pb.Reply), opts ...grpc.CallOption) error
 	GenerateImage(ctx context.Context, in *pb.GenerateImageRequest, opts ...grpc.CallOption) (*pb.Result, error)
+	GenerateImageStream(ctx context.Context, in *pb.GenerateImageRequest, f func(*pb.ImageProgress), opts ...grpc.CallOption) error
 	TTS(ctx context.Context, in *pb.TTSRequest, opts ...grpc.CallOption) (*pb.Result, error)
 	SoundGeneration(ctx context.Context, in *pb.SoundGenerationRequest, opts ...grpc.CallOption) (*pb.Result, error)
 	AudioTranscription(ctx context.Context, in *pb.TranscriptRequest, opts ...grpc.CallOption) (*pb.TranscriptResult, error)
+	AudioTranscriptionStream(ctx context.Context, in *pb.TranscriptRequest, f func(*pb.TranscriptSegment), opts ...grpc.CallOption) error
 	TokenizeString(ctx context.Context, in *pb.PredictOptions, opts ...grpc.CallOption) (*pb.TokenizationResponse, error)
+	Detokenize(ctx context.Context, in *pb.DetokenizeRequest, opts ...grpc.CallOption) (*pb.DetokenizationResponse, error)
 	Status(ctx context.Context) (*pb.StatusResponse, error)
 
 	StoresSet(ctx context.Context, in *pb.StoresSetOptions, opts ...grpc.CallOption) (*pb.Result, error)
@@ -51,6 +55,28 @@ type Backend interface {
 	StoresFind(ctx context.Context, in *pb.StoresFindOptions, opts ...grpc.CallOption) (*pb.StoresFindResult, error)
 
 	Rerank(ctx context.Context, in *pb.RerankRequest, opts ...grpc.CallOption) (*pb.RerankResult, error)
+	Classify(ctx context.Context, in *pb.ClassifyRequest, opts ...grpc.CallOption) (*pb.ClassifyResult, error)
 
 	GetTokenMetrics(ctx context.Context, in *pb.MetricsRequest, opts ...grpc.CallOption) (*pb.MetricsResponse, error)
+
+	SetThreads(ctx context.Context, in *pb.SetThreadsRequest, opts ...grpc.CallOption) (*pb.Result, error)
+
+	Capabilities(ctx context.Context) (*pb.CapabilitiesResponse, error)
 }
+
+// The capabilities a backend can report for the loaded model
+const (
+	CapabilityGenerate        = "generate"
+	CapabilityEmbed           = "embed"
+	CapabilityTokenize        = "tokenize"
+	CapabilityImage           = "image"
+	CapabilityTranscription   = "transcription"
+	CapabilityTTS             = "tts"
+	CapabilitySoundGeneration = "sound_generation"
+	CapabilityRerank          = "rerank"
+
+	// optional features, only assumed when reported
+	CapabilityImageBatch    = "image_batch"
+	CapabilityEmbedBatch    = "embed_batch"
+	CapabilityImageProgress = "image_progress"
+)
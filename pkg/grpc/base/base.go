@@ -49,14 +49,28 @@ func (llm *Base) Embeddings(opts *pb.PredictOptions) ([]float32, error) {
 	return []float32{}, fmt.Errorf("unimplemented")
 }
 
+func (llm *Base) EmbeddingBatch(opts []*pb.PredictOptions) ([][]float32, error) {
+	return nil, fmt.Errorf("unimplemented")
+}
+
 func (llm *Base) GenerateImage(*pb.GenerateImageRequest) error {
 	return fmt.Errorf("unimplemented")
 }
 
+func (llm *Base) GenerateImageStream(opts *pb.GenerateImageRequest, progress chan *pb.ImageProgress) error {
+	close(progress)
+	return fmt.Errorf("unimplemented")
+}
+
 func (llm *Base) AudioTranscription(*pb.TranscriptRequest) (pb.TranscriptResult, error) {
 	return pb.TranscriptResult{}, fmt.Errorf("unimplemented")
 }
 
+func (llm *Base) AudioTranscriptionStream(opts *pb.TranscriptRequest, results chan *pb.TranscriptSegment) error {
+	close(results)
+	return fmt.Errorf("unimplemented")
+}
+
 func (llm *Base) TTS(*pb.TTSRequest) error {
 	return fmt.Errorf("unimplemented")
 }
@@ -69,6 +83,22 @@ func (llm *Base) TokenizeString(opts *pb.PredictOptions) (pb.TokenizationRespons
 	return pb.TokenizationResponse{}, fmt.Errorf("unimplemented")
 }
 
+func (llm *Base) Detokenize(opts *pb.DetokenizeRequest) (pb.DetokenizationResponse, error) {
+	return pb.DetokenizationResponse{}, fmt.Errorf("unimplemented")
+}
+
+// SetThreads changes the number of threads of the loaded model. The backends which can not change it
+// without loading the model again leave it unimplemented.
+func (llm *Base) SetThreads(threads int32) error {
+	return fmt.Errorf("unimplemented")
+}
+
+// Capabilities returns the features the backend supports with the loaded model. The backends which
+// leave it unimplemented are assumed to support everything, and fail on the calls they do not support.
+func (llm *Base) Capabilities() ([]string, error) {
+	return nil, fmt.Errorf("unimplemented")
+}
+
 // backends may wish to call this to capture the gopsutil info, then enhance with additional memory usage details?
 func (llm *Base) Status() (pb.StatusResponse, error) {
 	return pb.StatusResponse{
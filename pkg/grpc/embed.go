@@ -6,10 +6,13 @@ import (
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var _ Backend = new(embedBackend)
 var _ pb.Backend_PredictStreamServer = new(embedBackendServerStream)
+var _ pb.Backend_AudioTranscriptionStreamServer = new(embedTranscriptionServerStream)
+var _ pb.Backend_GenerateImageStreamServer = new(embedImageServerStream)
 
 type embedBackend struct {
 	s *server
@@ -27,6 +30,10 @@ func (e *embedBackend) Embeddings(ctx context.Context, in *pb.PredictOptions, op
 	return e.s.Embedding(ctx, in)
 }
 
+func (e *embedBackend) EmbeddingBatch(ctx context.Context, in *pb.EmbeddingBatchRequest, opts ...grpc.CallOption) (*pb.EmbeddingBatchResult, error) {
+	return e.s.EmbeddingBatch(ctx, in)
+}
+
 func (e *embedBackend) Predict(ctx context.Context, in *pb.PredictOptions, opts ...grpc.CallOption) (*pb.Reply, error) {
 	return e.s.Predict(ctx, in)
 }
@@ -35,7 +42,7 @@ func (e *embedBackend) LoadModel(ctx context.Context, in *pb.ModelOptions, opts
 	return e.s.LoadModel(ctx, in)
 }
 
-func (e *embedBackend) PredictStream(ctx context.Context, in *pb.PredictOptions, f func(s []byte), opts ...grpc.CallOption) error {
+func (e *embedBackend) PredictStream(ctx context.Context, in *pb.PredictOptions, f func(reply *pb.Reply), opts ...grpc.CallOption) error {
 	bs := &embedBackendServerStream{
 		ctx: ctx,
 		fn:  f,
@@ -47,6 +54,14 @@ func (e *embedBackend) GenerateImage(ctx context.Context, in *pb.GenerateImageRe
 	return e.s.GenerateImage(ctx, in)
 }
 
+func (e *embedBackend) GenerateImageStream(ctx context.Context, in *pb.GenerateImageRequest, f func(*pb.ImageProgress), opts ...grpc.CallOption) error {
+	bs := &embedImageServerStream{
+		ctx: ctx,
+		fn:  f,
+	}
+	return e.s.GenerateImageStream(in, bs)
+}
+
 func (e *embedBackend) TTS(ctx context.Context, in *pb.TTSRequest, opts ...grpc.CallOption) (*pb.Result, error) {
 	return e.s.TTS(ctx, in)
 }
@@ -59,10 +74,22 @@ func (e *embedBackend) AudioTranscription(ctx context.Context, in *pb.Transcript
 	return e.s.AudioTranscription(ctx, in)
 }
 
+func (e *embedBackend) AudioTranscriptionStream(ctx context.Context, in *pb.TranscriptRequest, f func(*pb.TranscriptSegment), opts ...grpc.CallOption) error {
+	bs := &embedTranscriptionServerStream{
+		ctx: ctx,
+		fn:  f,
+	}
+	return e.s.AudioTranscriptionStream(in, bs)
+}
+
 func (e *embedBackend) TokenizeString(ctx context.Context, in *pb.PredictOptions, opts ...grpc.CallOption) (*pb.TokenizationResponse, error) {
 	return e.s.TokenizeString(ctx, in)
 }
 
+func (e *embedBackend) Detokenize(ctx context.Context, in *pb.DetokenizeRequest, opts ...grpc.CallOption) (*pb.DetokenizationResponse, error) {
+	return e.s.Detokenize(ctx, in)
+}
+
 func (e *embedBackend) Status(ctx context.Context) (*pb.StatusResponse, error) {
 	return e.s.Status(ctx, &pb.HealthMessage{})
 }
@@ -87,17 +114,32 @@ func (e *embedBackend) Rerank(ctx context.Context, in *pb.RerankRequest, opts ..
 	return e.s.Rerank(ctx, in)
 }
 
+func (e *embedBackend) Classify(ctx context.Context, in *pb.ClassifyRequest, opts ...grpc.CallOption) (*pb.ClassifyResult, error) {
+	return e.s.Classify(ctx, in)
+}
+
 func (e *embedBackend) GetTokenMetrics(ctx context.Context, in *pb.MetricsRequest, opts ...grpc.CallOption) (*pb.MetricsResponse, error) {
 	return e.s.GetMetrics(ctx, in)
 }
 
+func (e *embedBackend) SetThreads(ctx context.Context, in *pb.SetThreadsRequest, opts ...grpc.CallOption) (*pb.Result, error) {
+	return e.s.SetThreads(ctx, in)
+}
+
+func (e *embedBackend) Capabilities(ctx context.Context) (*pb.CapabilitiesResponse, error) {
+	return e.s.Capabilities(ctx, &pb.HealthMessage{})
+}
+
 type embedBackendServerStream struct {
 	ctx context.Context
-	fn  func(s []byte)
+	fn  func(reply *pb.Reply)
 }
 
 func (e *embedBackendServerStream) Send(reply *pb.Reply) error {
-	e.fn(reply.GetMessage())
+	if err := e.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	e.fn(reply)
 	return nil
 }
 
@@ -126,3 +168,75 @@ func (e *embedBackendServerStream) SendMsg(m any) error {
 func (e *embedBackendServerStream) RecvMsg(m any) error {
 	return nil
 }
+
+type embedTranscriptionServerStream struct {
+	ctx context.Context
+	fn  func(*pb.TranscriptSegment)
+}
+
+func (e *embedTranscriptionServerStream) Send(segment *pb.TranscriptSegment) error {
+	e.fn(segment)
+	return nil
+}
+
+func (e *embedTranscriptionServerStream) SetHeader(md metadata.MD) error {
+	return nil
+}
+
+func (e *embedTranscriptionServerStream) SendHeader(md metadata.MD) error {
+	return nil
+}
+
+func (e *embedTranscriptionServerStream) SetTrailer(md metadata.MD) {
+}
+
+func (e *embedTranscriptionServerStream) Context() context.Context {
+	return e.ctx
+}
+
+func (e *embedTranscriptionServerStream) SendMsg(m any) error {
+	if x, ok := m.(*pb.TranscriptSegment); ok {
+		return e.Send(x)
+	}
+	return nil
+}
+
+func (e *embedTranscriptionServerStream) RecvMsg(m any) error {
+	return nil
+}
+
+type embedImageServerStream struct {
+	ctx context.Context
+	fn  func(*pb.ImageProgress)
+}
+
+func (e *embedImageServerStream) Send(progress *pb.ImageProgress) error {
+	e.fn(progress)
+	return nil
+}
+
+func (e *embedImageServerStream) SetHeader(md metadata.MD) error {
+	return nil
+}
+
+func (e *embedImageServerStream) SendHeader(md metadata.MD) error {
+	return nil
+}
+
+func (e *embedImageServerStream) SetTrailer(md metadata.MD) {
+}
+
+func (e *embedImageServerStream) Context() context.Context {
+	return e.ctx
+}
+
+func (e *embedImageServerStream) SendMsg(m any) error {
+	if x, ok := m.(*pb.ImageProgress); ok {
+		return e.Send(x)
+	}
+	return nil
+}
+
+func (e *embedImageServerStream) RecvMsg(m any) error {
+	return nil
+}
@@ -13,12 +13,18 @@ type LLM interface {
 	PredictStream(*pb.PredictOptions, chan string) error
 	Load(*pb.ModelOptions) error
 	Embeddings(*pb.PredictOptions) ([]float32, error)
+	EmbeddingBatch([]*pb.PredictOptions) ([][]float32, error)
 	GenerateImage(*pb.GenerateImageRequest) error
+	GenerateImageStream(*pb.GenerateImageRequest, chan *pb.ImageProgress) error
 	AudioTranscription(*pb.TranscriptRequest) (pb.TranscriptResult, error)
+	AudioTranscriptionStream(*pb.TranscriptRequest, chan *pb.TranscriptSegment) error
 	TTS(*pb.TTSRequest) error
 	SoundGeneration(*pb.SoundGenerationRequest) error
 	TokenizeString(*pb.PredictOptions) (pb.TokenizationResponse, error)
+	Detokenize(*pb.DetokenizeRequest) (pb.DetokenizationResponse, error)
 	Status() (pb.StatusResponse, error)
+	SetThreads(threads int32) error
+	Capabilities() ([]string, error)
 
 	StoresSet(*pb.StoresSetOptions) error
 	StoresDelete(*pb.StoresDeleteOptions) error
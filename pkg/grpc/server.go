@@ -8,6 +8,8 @@ import (
 
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // A GRPC Server that allows to run LLM inference.
@@ -42,6 +44,23 @@ func (s *server) Embedding(ctx context.Context, in *pb.PredictOptions) (*pb.Embe
 	return &pb.EmbeddingResult{Embeddings: embeds}, nil
 }
 
+func (s *server) EmbeddingBatch(ctx context.Context, in *pb.EmbeddingBatchRequest) (*pb.EmbeddingBatchResult, error) {
+	if s.llm.Locking() {
+		s.llm.Lock()
+		defer s.llm.Unlock()
+	}
+	embeds, err := s.llm.EmbeddingBatch(in.Requests)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.EmbeddingBatchResult{}
+	for _, e := range embeds {
+		res.Results = append(res.Results, &pb.EmbeddingResult{Embeddings: e})
+	}
+	return res, nil
+}
+
 func (s *server) LoadModel(ctx context.Context, in *pb.ModelOptions) (*pb.Result, error) {
 	if s.llm.Locking() {
 		s.llm.Lock()
@@ -75,6 +94,23 @@ func (s *server) GenerateImage(ctx context.Context, in *pb.GenerateImageRequest)
 	return &pb.Result{Message: "Image generated", Success: true}, nil
 }
 
+func (s *server) GenerateImageStream(in *pb.GenerateImageRequest, stream pb.Backend_GenerateImageStreamServer) error {
+	if s.llm.Locking() {
+		s.llm.Lock()
+		defer s.llm.Unlock()
+	}
+	progressChan := make(chan *pb.ImageProgress)
+
+	done := sendStream(stream.Context(), progressChan, stream.Send)
+
+	err := s.llm.GenerateImageStream(in, progressChan)
+	if sendErr := <-done; sendErr != nil {
+		return sendErr
+	}
+
+	return err
+}
+
 func (s *server) TTS(ctx context.Context, in *pb.TTSRequest) (*pb.Result, error) {
 	if s.llm.Locking() {
 		s.llm.Lock()
@@ -128,6 +164,23 @@ func (s *server) AudioTranscription(ctx context.Context, in *pb.TranscriptReques
 	return tresult, nil
 }
 
+func (s *server) AudioTranscriptionStream(in *pb.TranscriptRequest, stream pb.Backend_AudioTranscriptionStreamServer) error {
+	if s.llm.Locking() {
+		s.llm.Lock()
+		defer s.llm.Unlock()
+	}
+	resultChan := make(chan *pb.TranscriptSegment)
+
+	done := sendStream(stream.Context(), resultChan, stream.Send)
+
+	err := s.llm.AudioTranscriptionStream(in, resultChan)
+	if sendErr := <-done; sendErr != nil {
+		return sendErr
+	}
+
+	return err
+}
+
 func (s *server) PredictStream(in *pb.PredictOptions, stream pb.Backend_PredictStreamServer) error {
 	if s.llm.Locking() {
 		s.llm.Lock()
@@ -135,20 +188,42 @@ func (s *server) PredictStream(in *pb.PredictOptions, stream pb.Backend_PredictS
 	}
 	resultChan := make(chan string)
 
-	done := make(chan bool)
-	go func() {
-		for result := range resultChan {
-			stream.Send(newReply(result))
-		}
-		done <- true
-	}()
+	done := sendStream(stream.Context(), resultChan, func(result string) error {
+		return stream.Send(newReply(result))
+	})
 
 	err := s.llm.PredictStream(in, resultChan)
-	<-done
+	if sendErr := <-done; sendErr != nil {
+		return sendErr
+	}
 
 	return err
 }
 
+// sendStream sends the results of a backend to the client until the first error. Once the client is gone the
+// remaining results are drained without being sent, so that the backend is not blocked writing to the channel.
+// The returned channel gets the error that stopped the sending, if any, once the backend closed the results.
+func sendStream[T any](ctx context.Context, results chan T, send func(T) error) chan error {
+	done := make(chan error)
+	go func() {
+		var sendErr error
+		for result := range results {
+			if sendErr != nil {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				sendErr = status.FromContextError(err).Err()
+				continue
+			}
+			if err := send(result); err != nil {
+				sendErr = err
+			}
+		}
+		done <- sendErr
+	}()
+	return done
+}
+
 func (s *server) TokenizeString(ctx context.Context, in *pb.PredictOptions) (*pb.TokenizationResponse, error) {
 	if s.llm.Locking() {
 		s.llm.Lock()
@@ -170,6 +245,38 @@ func (s *server) TokenizeString(ctx context.Context, in *pb.PredictOptions) (*pb
 	}, err
 }
 
+func (s *server) Detokenize(ctx context.Context, in *pb.DetokenizeRequest) (*pb.DetokenizationResponse, error) {
+	if s.llm.Locking() {
+		s.llm.Lock()
+		defer s.llm.Unlock()
+	}
+	res, err := s.llm.Detokenize(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (s *server) SetThreads(ctx context.Context, in *pb.SetThreadsRequest) (*pb.Result, error) {
+	if s.llm.Locking() {
+		s.llm.Lock()
+		defer s.llm.Unlock()
+	}
+	if err := s.llm.SetThreads(in.Threads); err != nil {
+		return &pb.Result{Message: fmt.Sprintf("Error setting threads: %s", err.Error()), Success: false}, err
+	}
+	return &pb.Result{Message: "Threads set", Success: true}, nil
+}
+
+func (s *server) Capabilities(ctx context.Context, in *pb.HealthMessage) (*pb.CapabilitiesResponse, error) {
+	capabilities, err := s.llm.Capabilities()
+	if err != nil {
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	}
+	return &pb.CapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
 func (s *server) Status(ctx context.Context, in *pb.HealthMessage) (*pb.StatusResponse, error) {
 	res, err := s.llm.Status()
 	if err != nil {
@@ -0,0 +1,148 @@
+package grpc_test
+
+import (
+	"context"
+
+	"github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/grpc/base"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// tokenLLM streams the same token until it has produced all of them
+type tokenLLM struct {
+	base.Base
+	tokens   int
+	produced chan int
+}
+
+func (llm *tokenLLM) PredictStream(opts *pb.PredictOptions, results chan string) error {
+	go func() {
+		n := 0
+		for ; n < llm.tokens; n++ {
+			results <- "token"
+		}
+		close(results)
+		llm.produced <- n
+	}()
+	return nil
+}
+
+var _ = Describe("PredictStream", func() {
+	It("streams every token", func() {
+		llm := &tokenLLM{tokens: 10, produced: make(chan int, 1)}
+		grpc.Provide("test-stream", llm)
+		client := grpc.NewClient("test-stream", false, nil, false)
+
+		received := 0
+		err := client.PredictStream(context.Background(), &pb.PredictOptions{}, func(reply *pb.Reply) {
+			received++
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(received).To(Equal(10))
+	})
+
+	It("stops sending and returns canceled when the caller cancels", func() {
+		llm := &tokenLLM{tokens: 100, produced: make(chan int, 1)}
+		grpc.Provide("test-cancel", llm)
+		client := grpc.NewClient("test-cancel", false, nil, false)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := 0
+		err := client.PredictStream(ctx, &pb.PredictOptions{}, func(reply *pb.Reply) {
+			received++
+			cancel()
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(status.Code(err)).To(Equal(codes.Canceled))
+		Expect(received).To(Equal(1))
+		// the backend is not left blocked on the tokens nobody reads
+		Eventually(llm.produced).Should(Receive(Equal(100)))
+	})
+})
+
+// segmentLLM streams the same transcript segment until it has produced all of them
+type segmentLLM struct {
+	base.Base
+	segments int
+	produced chan int
+}
+
+func (llm *segmentLLM) AudioTranscriptionStream(opts *pb.TranscriptRequest, results chan *pb.TranscriptSegment) error {
+	go func() {
+		n := 0
+		for ; n < llm.segments; n++ {
+			results <- &pb.TranscriptSegment{Text: "segment"}
+		}
+		close(results)
+		llm.produced <- n
+	}()
+	return nil
+}
+
+var _ = Describe("AudioTranscriptionStream", func() {
+	It("stops sending and returns canceled when the caller cancels", func() {
+		llm := &segmentLLM{segments: 100, produced: make(chan int, 1)}
+		grpc.Provide("test-transcription-cancel", llm)
+		client := grpc.NewClient("test-transcription-cancel", false, nil, false)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := 0
+		err := client.AudioTranscriptionStream(ctx, &pb.TranscriptRequest{}, func(segment *pb.TranscriptSegment) {
+			received++
+			cancel()
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(status.Code(err)).To(Equal(codes.Canceled))
+		Expect(received).To(Equal(1))
+		Eventually(llm.produced).Should(Receive(Equal(100)))
+	})
+})
+
+// stepLLM reports the progress of each step of an image generation until it has done all of them
+type stepLLM struct {
+	base.Base
+	steps    int
+	produced chan int
+}
+
+func (llm *stepLLM) GenerateImageStream(opts *pb.GenerateImageRequest, progress chan *pb.ImageProgress) error {
+	go func() {
+		n := 0
+		for ; n < llm.steps; n++ {
+			progress <- &pb.ImageProgress{}
+		}
+		close(progress)
+		llm.produced <- n
+	}()
+	return nil
+}
+
+var _ = Describe("GenerateImageStream", func() {
+	It("stops sending and returns canceled when the caller cancels", func() {
+		llm := &stepLLM{steps: 100, produced: make(chan int, 1)}
+		grpc.Provide("test-image-cancel", llm)
+		client := grpc.NewClient("test-image-cancel", false, nil, false)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := 0
+		err := client.GenerateImageStream(ctx, &pb.GenerateImageRequest{}, func(progress *pb.ImageProgress) {
+			received++
+			cancel()
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(status.Code(err)).To(Equal(codes.Canceled))
+		Expect(received).To(Equal(1))
+		Eventually(llm.produced).Should(Receive(Equal(100)))
+	})
+})
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	grpc "github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/xlog"
+)
+
+const (
+	// addressPoolProbeInterval is the interval between the probes of the unhealthy addresses of a pool
+	addressPoolProbeInterval = 30 * time.Second
+	addressPoolProbeTimeout  = 5 * time.Second
+)
+
+// addressPool balances the models of an external backend over the replicas of the backend, given as a
+// comma-separated list of addresses. Each model is loaded on the next healthy replica, round-robin.
+// The replicas failing their health check are skipped, and probed periodically to rejoin the pool
+// once they recover.
+type addressPool struct {
+	sync.Mutex
+	addresses []string
+	healthy   []bool
+	next      int
+	check     func(ctx context.Context, address string) bool
+}
+
+func isAddressPool(uri string) bool {
+	return strings.Contains(uri, ",")
+}
+
+func newAddressPool(uri string, check func(ctx context.Context, address string) bool) *addressPool {
+	p := &addressPool{check: check}
+	for _, address := range strings.Split(uri, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			p.addresses = append(p.addresses, address)
+		}
+	}
+	// the addresses are checked before being picked, so they start healthy
+	p.healthy = make([]bool, len(p.addresses))
+	for i := range p.healthy {
+		p.healthy[i] = true
+	}
+	return p
+}
+
+func healthCheckAddress(ctx context.Context, address string) bool {
+	ctx, cancel := context.WithTimeout(ctx, addressPoolProbeTimeout)
+	defer cancel()
+	alive, _ := grpc.NewClient(address, false, nil, false).HealthCheck(ctx)
+	return alive
+}
+
+// pick returns the next healthy address, checking its health first. When no address is known to be
+// healthy, all of them are probed again before giving up. The health checks run without the lock held,
+// so that the concurrent picks do not wait for them.
+func (p *addressPool) pick(ctx context.Context) (string, error) {
+	checked := make([]bool, len(p.addresses))
+	for _, probeAll := range []bool{false, true} {
+		for {
+			idx, found := p.candidate(checked, probeAll)
+			if !found {
+				break
+			}
+			healthy := p.check(ctx, p.addresses[idx])
+			p.Lock()
+			p.healthy[idx] = healthy
+			p.Unlock()
+			if healthy {
+				return p.addresses[idx], nil
+			}
+			xlog.Model.Warn().Str("address", p.addresses[idx]).Msg("external backend replica is unhealthy, skipping it")
+		}
+	}
+	return "", fmt.Errorf("no healthy address among %s", strings.Join(p.addresses, ","))
+}
+
+// candidate returns the next address to check round-robin, skipping the checked addresses and, unless
+// probeAll is set, the unhealthy ones. The round-robin moves past the candidate, marked as checked.
+func (p *addressPool) candidate(checked []bool, probeAll bool) (int, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	for i := range p.addresses {
+		idx := (p.next + i) % len(p.addresses)
+		if checked[idx] || !p.healthy[idx] && !probeAll {
+			continue
+		}
+		checked[idx] = true
+		p.next = idx + 1
+		return idx, true
+	}
+	return 0, false
+}
+
+// probeUnhealthy checks again the unhealthy addresses, so that the recovered ones rejoin the pool
+func (p *addressPool) probeUnhealthy(ctx context.Context) {
+	p.Lock()
+	unhealthy := []int{}
+	for idx, healthy := range p.healthy {
+		if !healthy {
+			unhealthy = append(unhealthy, idx)
+		}
+	}
+	p.Unlock()
+
+	for _, idx := range unhealthy {
+		if !p.check(ctx, p.addresses[idx]) {
+			continue
+		}
+		xlog.Model.Info().Str("address", p.addresses[idx]).Msg("external backend replica recovered")
+		p.Lock()
+		p.healthy[idx] = true
+		p.Unlock()
+	}
+}
+
+// addressPool returns the pool of the addresses of uri, probing its unhealthy addresses in the background
+// from its creation until ctx is done, when the pool is dropped
+func (ml *ModelLoader) addressPool(ctx context.Context, uri string) *addressPool {
+	ml.poolsMu.Lock()
+	defer ml.poolsMu.Unlock()
+
+	if ml.pools == nil {
+		ml.pools = map[string]*addressPool{}
+	}
+	p, exists := ml.pools[uri]
+	if !exists {
+		p = newAddressPool(uri, healthCheckAddress)
+		ml.pools[uri] = p
+		go func() {
+			ticker := time.NewTicker(addressPoolProbeInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					ml.poolsMu.Lock()
+					delete(ml.pools, uri)
+					ml.poolsMu.Unlock()
+					return
+				case <-ticker.C:
+					p.probeUnhealthy(ctx)
+				}
+			}
+		}()
+	}
+	return p
+}
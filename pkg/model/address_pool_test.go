@@ -0,0 +1,118 @@
+package model
+
+import (
+	"context"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeReplicas is the health of the replicas of a backend, by address
+type fakeReplicas struct {
+	sync.Mutex
+	healthy map[string]bool
+}
+
+func (f *fakeReplicas) set(address string, healthy bool) {
+	f.Lock()
+	defer f.Unlock()
+	f.healthy[address] = healthy
+}
+
+func (f *fakeReplicas) check(_ context.Context, address string) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.healthy[address]
+}
+
+var _ = Describe("addressPool", func() {
+	var replicas *fakeReplicas
+
+	BeforeEach(func() {
+		replicas = &fakeReplicas{healthy: map[string]bool{"a:1": true, "b:2": true, "c:3": true}}
+	})
+
+	It("only pools the lists of addresses", func() {
+		Expect(isAddressPool("127.0.0.1:50051")).To(BeFalse())
+		Expect(isAddressPool("127.0.0.1:50051,127.0.0.1:50052")).To(BeTrue())
+	})
+
+	It("picks the healthy addresses round-robin, skipping the unhealthy ones", func() {
+		pool := newAddressPool("a:1, b:2,c:3", replicas.check)
+		Expect(pool.addresses).To(Equal([]string{"a:1", "b:2", "c:3"}))
+
+		picked := []string{}
+		for range 4 {
+			address, err := pool.pick(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			picked = append(picked, address)
+		}
+		Expect(picked).To(Equal([]string{"a:1", "b:2", "c:3", "a:1"}))
+
+		replicas.set("c:3", false)
+		picked = []string{}
+		for range 3 {
+			address, err := pool.pick(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			picked = append(picked, address)
+		}
+		Expect(picked).To(Equal([]string{"b:2", "a:1", "b:2"}))
+	})
+
+	It("lets the recovered addresses rejoin the pool", func() {
+		replicas.set("a:1", false)
+		pool := newAddressPool("a:1,b:2", replicas.check)
+		Expect(pool.pick(context.Background())).To(Equal("b:2"))
+		Expect(pool.pick(context.Background())).To(Equal("b:2"))
+
+		replicas.set("a:1", true)
+		pool.probeUnhealthy(context.Background())
+		Expect(pool.pick(context.Background())).To(Equal("a:1"))
+	})
+
+	It("does not hold the pool during the health checks", func() {
+		checking, release := make(chan struct{}), make(chan struct{})
+		pool := newAddressPool("a:1,b:2", func(ctx context.Context, address string) bool {
+			if address == "a:1" {
+				close(checking)
+				<-release
+			}
+			return replicas.check(ctx, address)
+		})
+
+		slow := make(chan string)
+		go func() {
+			address, _ := pool.pick(context.Background())
+			slow <- address
+		}()
+		<-checking
+		// the next pick goes on with the next address while the first one is checked
+		Expect(pool.pick(context.Background())).To(Equal("b:2"))
+
+		close(release)
+		Eventually(slow).Should(Receive(Equal("a:1")))
+	})
+
+	It("stops probing the addresses once the context is done", func() {
+		ml := NewModelLoader(GinkgoT().TempDir())
+		ctx, cancel := context.WithCancel(context.Background())
+		pool := ml.addressPool(ctx, "a:1,b:2")
+		Expect(ml.addressPool(ctx, "a:1,b:2")).To(BeIdenticalTo(pool))
+
+		cancel()
+		Eventually(func() bool {
+			ml.poolsMu.Lock()
+			defer ml.poolsMu.Unlock()
+			_, exists := ml.pools["a:1,b:2"]
+			return exists
+		}).Should(BeFalse())
+	})
+
+	It("fails when no address is healthy", func() {
+		replicas.set("a:1", false)
+		replicas.set("b:2", false)
+		_, err := newAddressPool("a:1,b:2", replicas.check).pick(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("no healthy address among a:1,b:2")))
+	})
+})
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// startWithAffinity calls start, starting a process, from an OS thread bound to the CPUs so that the
+// process inherits its affinity
+func startWithAffinity(cpus []int, start func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		// the thread is never unlocked: it exits with the goroutine rather than running other goroutines
+		// bound to the CPUs
+		runtime.LockOSThread()
+
+		var set unix.CPUSet
+		for _, cpu := range cpus {
+			set.Set(cpu)
+		}
+		if err := unix.SchedSetaffinity(0, &set); err != nil {
+			errs <- fmt.Errorf("failed setting the CPU affinity: %w", err)
+			return
+		}
+		errs <- start()
+	}()
+	return <-errs
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package model
+
+import "github.com/mudler/LocalAI/pkg/xlog"
+
+// startWithAffinity calls start: the CPU affinity of the processes is only set on Linux
+func startWithAffinity(cpus []int, start func() error) error {
+	xlog.Model.Warn().Msg("the CPU affinity of the backends is only supported on Linux")
+	return start()
+}
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mudler/LocalAI/pkg/xlog"
+	"github.com/rs/zerolog"
+)
+
+// DefaultBackendLogLines is the number of lines of output kept per backend, unless configured otherwise
+const DefaultBackendLogLines = 1000
+
+// BackendLogLine is a line of output of a backend process
+type BackendLogLine struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// logBuffer keeps the last lines of output of a backend, in a ring buffer bounding its memory
+type logBuffer struct {
+	sync.Mutex
+	lines []BackendLogLine
+	next  int
+	full  bool
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{lines: make([]BackendLogLine, size)}
+}
+
+func (b *logBuffer) add(stream, text string) {
+	b.Lock()
+	defer b.Unlock()
+	b.lines[b.next] = BackendLogLine{Time: time.Now(), Stream: stream, Text: text}
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// last returns the last n lines, oldest first, or all of them if n is 0
+func (b *logBuffer) last(n int) []BackendLogLine {
+	b.Lock()
+	defer b.Unlock()
+
+	lines := append([]BackendLogLine{}, b.lines[:b.next]...)
+	if b.full {
+		lines = append(append([]BackendLogLine{}, b.lines[b.next:]...), lines...)
+	}
+	if n > 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// SetBackendLogLines sets the number of lines of output kept per backend, 0 disables the capture
+func (ml *ModelLoader) SetBackendLogLines(lines int) {
+	ml.logsMu.Lock()
+	defer ml.logsMu.Unlock()
+	ml.logLines = lines
+}
+
+// SetForwardBackendLogs writes the output of the backends to the logs at the level of each line, with the
+// backend and the model, instead of at the debug level
+func (ml *ModelLoader) SetForwardBackendLogs(forward bool) {
+	ml.logsMu.Lock()
+	defer ml.logsMu.Unlock()
+	ml.forwardLogs = forward
+}
+
+func (ml *ModelLoader) forwardBackendLogs() bool {
+	ml.logsMu.Lock()
+	defer ml.logsMu.Unlock()
+	return ml.forwardLogs
+}
+
+// logLevelPrefix matches the level at the start of a line, e.g. "INFO [main] ...", "[WARN] ...", "ERROR:root:...",
+// or in a logfmt line, e.g. "... level=warn ..."
+var logLevelPrefix = regexp.MustCompile(`(?i)^\s*[\[(]?(trace|debug|dbg|info|inf|notice|warn|warning|wrn|error|err|fatal|critical|crit)\b|\blevel=(\w+)`)
+
+// backendLogLevel returns the level of a line of output of a backend, info if it has no known level
+func backendLogLevel(line string) zerolog.Level {
+	m := logLevelPrefix.FindStringSubmatch(line)
+	if m == nil {
+		return zerolog.InfoLevel
+	}
+	switch strings.ToLower(m[1] + m[2]) {
+	case "trace":
+		return zerolog.TraceLevel
+	case "debug", "dbg":
+		return zerolog.DebugLevel
+	case "warn", "warning", "wrn":
+		return zerolog.WarnLevel
+	// the backend failing is not a failure of LocalAI, it is never logged as fatal
+	case "error", "err", "fatal", "critical", "crit":
+		return zerolog.ErrorLevel
+	default:
+		return zerolog.InfoLevel
+	}
+}
+
+// logBackendLine writes a line of output of a backend to the logs
+func logBackendLine(forward bool, backend, modelID, serverAddress, stream, text string) {
+	if !forward {
+		xlog.Model.Debug().Msgf("GRPC(%s): %s %s", strings.Join([]string{modelID, serverAddress}, "-"), stream, text)
+		return
+	}
+	xlog.Model.WithLevel(backendLogLevel(text)).Str("backend", backend).Str("model", modelID).Str("stream", stream).Msg(text)
+}
+
+// backendLogs returns the buffer capturing the output of the backend of the model, or nil if the
+// capture is disabled. The buffer of a model is kept when its backend restarts, showing why it stopped.
+func (ml *ModelLoader) backendLogs(modelID string) *logBuffer {
+	ml.logsMu.Lock()
+	defer ml.logsMu.Unlock()
+
+	if ml.logLines <= 0 {
+		return nil
+	}
+	if ml.logs == nil {
+		ml.logs = map[string]*logBuffer{}
+	}
+	b, exists := ml.logs[modelID]
+	if !exists || len(b.lines) != ml.logLines {
+		b = newLogBuffer(ml.logLines)
+		ml.logs[modelID] = b
+	}
+	return b
+}
+
+// BackendLogs returns the last n lines of output of the backend of the model, oldest first, or all the
+// lines kept if n is 0. It reports false if no output was captured for the model.
+func (ml *ModelLoader) BackendLogs(modelID string, n int) ([]BackendLogLine, bool) {
+	ml.logsMu.Lock()
+	b, exists := ml.logs[modelID]
+	ml.logsMu.Unlock()
+	if !exists {
+		return nil, false
+	}
+	return b.last(n), true
+}
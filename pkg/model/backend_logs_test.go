@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+)
+
+func logTexts(lines []BackendLogLine) []string {
+	texts := []string{}
+	for _, l := range lines {
+		texts = append(texts, l.Stream+": "+l.Text)
+	}
+	return texts
+}
+
+var _ = Describe("logBuffer", func() {
+	It("keeps the last lines, oldest first", func() {
+		b := newLogBuffer(3)
+		Expect(b.last(0)).To(BeEmpty())
+
+		b.add("stdout", "1")
+		b.add("stderr", "2")
+		Expect(logTexts(b.last(0))).To(Equal([]string{"stdout: 1", "stderr: 2"}))
+
+		for i := 3; i <= 5; i++ {
+			b.add("stdout", fmt.Sprint(i))
+		}
+		Expect(logTexts(b.last(0))).To(Equal([]string{"stdout: 3", "stdout: 4", "stdout: 5"}))
+		Expect(logTexts(b.last(2))).To(Equal([]string{"stdout: 4", "stdout: 5"}))
+		Expect(logTexts(b.last(10))).To(HaveLen(3))
+	})
+})
+
+var _ = Describe("BackendLogs", func() {
+	It("captures the output of the backends", func() {
+		backend := filepath.Join(GinkgoT().TempDir(), "backend")
+		Expect(os.WriteFile(backend, []byte("#!/bin/sh\necho started\necho failed >&2\n"), 0700)).To(Succeed())
+
+		ml := NewModelLoader("")
+		_, exists := ml.BackendLogs("model", 0)
+		Expect(exists).To(BeFalse())
+
+		_, err := ml.startProcess("backend", backend, "", nil, "model", "127.0.0.1:50051")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() []string {
+			lines, _ := ml.BackendLogs("model", 0)
+			return logTexts(lines)
+		}).Should(ConsistOf("stdout: started", "stderr: failed"))
+	})
+
+	It("parses the level of the lines of output", func() {
+		for line, level := range map[string]zerolog.Level{
+			"INFO [                    main] HTTP server listening": zerolog.InfoLevel,
+			"[WARN] the context size is larger than the model's":    zerolog.WarnLevel,
+			"ERROR:root:cannot load the model":                      zerolog.ErrorLevel,
+			"time=2024-01-01 level=debug msg=loaded":                zerolog.DebugLevel,
+			"critical: out of memory":                               zerolog.ErrorLevel,
+			"llama_model_loader: loaded meta data":                  zerolog.InfoLevel,
+			"information about the model":                           zerolog.InfoLevel,
+		} {
+			Expect(backendLogLevel(line)).To(Equal(level), line)
+		}
+	})
+
+	It("does not capture the output when disabled", func() {
+		ml := NewModelLoader("")
+		ml.SetBackendLogLines(0)
+		Expect(ml.backendLogs("model")).To(BeNil())
+	})
+})
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	grpc "github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/xlog"
+)
+
+// ErrUnsupportedCapability is wrapped by the errors of the requests needing a feature the backend of the
+// model reports not supporting, such as a chat request to an embeddings model
+var ErrUnsupportedCapability = errors.New("unsupported capability")
+
+// capabilityQueryTimeout bounds the time a backend has to report its capabilities after loading a model
+const capabilityQueryTimeout = 10 * time.Second
+
+// capabilityFeatures describe the capabilities in the errors returned to the users
+var capabilityFeatures = map[string]string{
+	grpc.CapabilityGenerate:        "text generation",
+	grpc.CapabilityEmbed:           "embeddings",
+	grpc.CapabilityTokenize:        "tokenization",
+	grpc.CapabilityImage:           "image generation",
+	grpc.CapabilityTranscription:   "audio transcription",
+	grpc.CapabilityTTS:             "text to speech",
+	grpc.CapabilitySoundGeneration: "sound generation",
+	grpc.CapabilityRerank:          "reranking",
+}
+
+// queryCapabilities asks the backend which features it supports with the loaded model. It returns nil
+// for the backends which do not report them.
+func queryCapabilities(ctx context.Context, client grpc.Backend) map[string]bool {
+	ctx, cancel := context.WithTimeout(ctx, capabilityQueryTimeout)
+	defer cancel()
+
+	res, err := client.Capabilities(ctx)
+	if err != nil || res == nil {
+		xlog.Model.Debug().Err(err).Msg("the backend does not report its capabilities")
+		return nil
+	}
+	capabilities := map[string]bool{}
+	for _, capability := range res.GetCapabilities() {
+		capabilities[capability] = true
+	}
+	return capabilities
+}
+
+// Supports reports whether the backend supports the capability with the model. The capabilities of the
+// backends which do not report them are all assumed.
+func (m *Model) Supports(capability string) bool {
+	return m.capabilities == nil || m.capabilities[capability]
+}
+
+// Reports reports whether the backend reports the capability with the model. The optional features,
+// such as batching, are only used when reported.
+func (m *Model) Reports(capability string) bool {
+	return m.capabilities[capability]
+}
+
+// CheckCapability returns an error wrapping ErrUnsupportedCapability if the backend of the loaded model
+// reports not supporting the capability. Nothing is known of the models which are not loaded.
+func (ml *ModelLoader) CheckCapability(modelID, capability string) error {
+	ml.mu.Lock()
+	m, exists := ml.models[modelID]
+	ml.mu.Unlock()
+	if !exists || m.Supports(capability) {
+		return nil
+	}
+	feature, exists := capabilityFeatures[capability]
+	if !exists {
+		feature = capability
+	}
+	return fmt.Errorf("%w: the model %q does not support %s", ErrUnsupportedCapability, modelID, feature)
+}
+
+// ReportsCapability reports whether the backend of the loaded model reports the capability
+func (ml *ModelLoader) ReportsCapability(modelID, capability string) bool {
+	ml.mu.Lock()
+	m, exists := ml.models[modelID]
+	ml.mu.Unlock()
+	return exists && m.Reports(capability)
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/mudler/LocalAI/pkg/xlog"
+	process "github.com/mudler/go-processmanager"
+)
+
+// ContainerBackendPrefix marks the external backends running in a container, e.g.
+// container://quay.io/go-skynet/local-ai-backends:vllm
+const ContainerBackendPrefix = "container://"
+
+// DefaultContainerRuntime is the runtime used to run the container backends, unless configured otherwise
+const DefaultContainerRuntime = "docker"
+
+var invalidContainerNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// container is a backend running in a container, which is removed when the model is unloaded
+type container struct {
+	runtime string
+	name    string
+
+	// removed is closed once the container is removed, err is the error removing it
+	once    sync.Once
+	removed chan struct{}
+	err     error
+}
+
+func newContainer(runtime, name string) *container {
+	return &container{runtime: runtime, name: name, removed: make(chan struct{})}
+}
+
+// remove removes the container, once: the later calls return the error of the first
+func (c *container) remove() error {
+	c.once.Do(func() {
+		defer close(c.removed)
+		out, err := exec.Command(c.runtime, "rm", "-f", c.name).CombinedOutput()
+		if err != nil {
+			xlog.Model.Error().Err(err).Str("container", c.name).Msgf("failed removing the backend container: %s", strings.TrimSpace(string(out)))
+			c.err = err
+			return
+		}
+		xlog.Model.Debug().Str("container", c.name).Msg("backend container removed")
+	})
+	return c.err
+}
+
+// removeOnSignal removes the container when LocalAI is interrupted, as the runtime client is killed on
+// shutdown, which does not always stop the container. The signals are no longer watched once the
+// container is removed, when the model is unloaded.
+func (c *container) removeOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(ch)
+		select {
+		case <-ch:
+			c.remove()
+		case <-c.removed:
+		}
+	}()
+}
+
+// startContainer runs the image as the backend serving at serverAddress. The image must run a gRPC
+// backend accepting the --addr flag, like the backend binaries. The port of serverAddress is published on
+// the loopback interface, and the model paths are mounted at the same paths, so that the model files
+// sent to the backend resolve in the container too.
+func (ml *ModelLoader) startContainer(runtime, image, id, serverAddress string) (*process.Process, *container, error) {
+	runtimePath, err := exec.LookPath(runtime)
+	if err != nil {
+		return nil, nil, fmt.Errorf("container runtime %q not found: %w", runtime, err)
+	}
+	_, port, err := net.SplitHostPort(serverAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := newContainer(runtimePath, fmt.Sprintf("localai-%s-%s", strings.Trim(invalidContainerNameChars.ReplaceAllString(id, "-"), "-."), port))
+	args := []string{
+		"run", "--rm", "--name", c.name,
+		"-p", fmt.Sprintf("127.0.0.1:%s:%s", port, port),
+	}
+	for i, path := range ml.ModelPaths() {
+		mount := fmt.Sprintf("%s:%s", path, path)
+		// only the models path is writable, the backends download there
+		if i > 0 {
+			mount += ":ro"
+		}
+		args = append(args, "-v", mount)
+	}
+	args = append(args, image, "--addr", fmt.Sprintf("0.0.0.0:%s", port))
+
+	xlog.Model.Debug().Msgf("Starting container %s for %s: %s %s", c.name, id, runtime, strings.Join(args, " "))
+
+	p, err := ml.runProcess(image, runtimePath, "", nil, id, serverAddress, args...)
+	if err != nil {
+		c.remove()
+		return p, nil, err
+	}
+
+	c.removeOnSignal()
+	return p, c, nil
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("startContainer", func() {
+	It("runs the image with the port published and the model paths mounted, and removes it", func() {
+		dir := GinkgoT().TempDir()
+		calls := filepath.Join(dir, "calls")
+		runtime := filepath.Join(dir, "fake-runtime")
+		Expect(os.WriteFile(runtime, []byte("#!/bin/sh\necho \"$@\" >> "+calls+"\n"), 0700)).To(Succeed())
+
+		ml := NewModelLoader("/models", "/shared")
+		p, c, err := ml.startContainer(runtime, "example/backend:v1", "my model", "127.0.0.1:50051")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(func() { p.Stop() })
+		Expect(c.name).To(Equal("localai-my-model-50051"))
+
+		Eventually(func() string {
+			data, _ := os.ReadFile(calls)
+			return string(data)
+		}).Should(Equal("run --rm --name localai-my-model-50051 -p 127.0.0.1:50051:50051 -v /models:/models -v /shared:/shared:ro example/backend:v1 --addr 0.0.0.0:50051\n"))
+
+		Expect(c.remove()).To(Succeed())
+		Expect(c.removed).To(BeClosed())
+		// the container is removed once, and its signal handler stops
+		Expect(c.remove()).To(Succeed())
+		data, err := os.ReadFile(calls)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Split(strings.TrimSpace(string(data)), "\n")).To(HaveLen(2))
+		Expect(string(data)).To(HaveSuffix("rm -f localai-my-model-50051\n"))
+	})
+
+	It("fails when the runtime is not installed", func() {
+		_, _, err := NewModelLoader("/models").startContainer("missing-runtime", "example/backend:v1", "model", "127.0.0.1:50051")
+		Expect(err).To(MatchError(ContainSubstring("container runtime \"missing-runtime\" not found")))
+	})
+})
@@ -1,11 +1,13 @@
 package model
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
 	"time"
@@ -14,9 +16,9 @@ import (
 	grpc "github.com/mudler/LocalAI/pkg/grpc"
 	"github.com/mudler/LocalAI/pkg/library"
 	"github.com/mudler/LocalAI/pkg/utils"
+	"github.com/mudler/LocalAI/pkg/xlog"
 	"github.com/mudler/LocalAI/pkg/xsysinfo"
 	"github.com/phayes/freeport"
-	"github.com/rs/zerolog/log"
 
 	"github.com/elliotchance/orderedmap/v2"
 )
@@ -40,6 +42,7 @@ const (
 	LLamaCPPFallback = "llama-cpp-fallback"
 	LLamaCPPCUDA     = "llama-cpp-cuda"
 	LLamaCPPHipblas  = "llama-cpp-hipblas"
+	LLamaCPPMetal    = "llama-cpp-metal"
 	LLamaCPPSycl16   = "llama-cpp-sycl_16"
 	LLamaCPPSycl32   = "llama-cpp-sycl_32"
 
@@ -61,11 +64,16 @@ func backendPath(assetDir, backend string) string {
 }
 
 // backendsInAssetDir returns the list of backends in the asset directory
-// that should be loaded
+// that should be loaded. A missing directory, e.g. on a fresh install where the
+// assets were not extracted yet, has no backends rather than being an error.
 func backendsInAssetDir(assetDir string) ([]string, error) {
 	// Exclude backends from automatic loading
 	excludeBackends := []string{LocalStoreBackend}
 	entry, err := os.ReadDir(backendPath(assetDir, ""))
+	if errors.Is(err, os.ErrNotExist) {
+		xlog.Model.Warn().Str("dir", backendPath(assetDir, "")).Msg("backend assets directory not found, no embedded backends available")
+		return []string{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +105,7 @@ ENTRY:
 	if autoDetect {
 		// if we find the llama.cpp variants, show them of as a single backend (llama-cpp) as later we are going to pick that up
 		// when starting the service
-		foundLCPPAVX, foundLCPPAVX2, foundLCPPFallback, foundLCPPGRPC, foundLCPPCuda, foundLCPPHipblas, foundSycl16, foundSycl32 := false, false, false, false, false, false, false, false
+		foundLCPPAVX, foundLCPPAVX2, foundLCPPFallback, foundLCPPGRPC, foundLCPPCuda, foundLCPPHipblas, foundLCPPMetal, foundSycl16, foundSycl32 := false, false, false, false, false, false, false, false, false
 		if _, ok := backends[LLamaCPP]; !ok {
 			for _, e := range entry {
 				if strings.Contains(e.Name(), LLamaCPPAVX2) && !foundLCPPAVX2 {
@@ -124,6 +132,10 @@ ENTRY:
 					backends[LLamaCPP] = append(backends[LLamaCPP], LLamaCPPHipblas)
 					foundLCPPHipblas = true
 				}
+				if strings.Contains(e.Name(), LLamaCPPMetal) && !foundLCPPMetal {
+					backends[LLamaCPP] = append(backends[LLamaCPP], LLamaCPPMetal)
+					foundLCPPMetal = true
+				}
 				if strings.Contains(e.Name(), LLamaCPPSycl16) && !foundSycl16 {
 					backends[LLamaCPP] = append(backends[LLamaCPP], LLamaCPPSycl16)
 					foundSycl16 = true
@@ -181,6 +193,34 @@ ENTRY:
 	return orderedBackends.Keys(), nil
 }
 
+// prioritizeBackends moves the backends of the priority list to the front, in the order of the list,
+// keeping the relative order of the others. The entries of the list which are not in backends are ignored.
+func prioritizeBackends(backends, priority []string) []string {
+	if len(priority) == 0 {
+		return backends
+	}
+
+	ordered := make([]string, 0, len(backends))
+	for _, p := range priority {
+		if slices.Contains(backends, p) && !slices.Contains(ordered, p) {
+			ordered = append(ordered, p)
+		}
+	}
+	for _, b := range backends {
+		if !slices.Contains(ordered, b) {
+			ordered = append(ordered, b)
+		}
+	}
+	return ordered
+}
+
+// withoutBackends removes the disabled backends from the list
+func withoutBackends(backends, disabled []string) []string {
+	return slices.DeleteFunc(backends, func(b string) bool {
+		return slices.Contains(disabled, b)
+	})
+}
+
 // selectGRPCProcess selects the GRPC process to start based on system capabilities
 func selectGRPCProcess(backend, assetDir string, f16 bool) string {
 	foundCUDA := false
@@ -195,7 +235,7 @@ func selectGRPCProcess(backend, assetDir string, f16 bool) string {
 
 	// Note: This environment variable is read by the LocalAI's llama.cpp grpc-server
 	if os.Getenv("LLAMACPP_GRPC_SERVERS") != "" {
-		log.Info().Msgf("[%s] attempting to load with GRPC variant", LLamaCPPGRPC)
+		xlog.Model.Info().Msgf("[%s] attempting to load with GRPC variant", LLamaCPPGRPC)
 		return backendPath(assetDir, LLamaCPPGRPC)
 	}
 
@@ -205,21 +245,21 @@ func selectGRPCProcess(backend, assetDir string, f16 bool) string {
 			if strings.Contains(gpu.String(), "nvidia") {
 				p := backendPath(assetDir, LLamaCPPCUDA)
 				if _, err := os.Stat(p); err == nil {
-					log.Info().Msgf("[%s] attempting to load with CUDA variant", backend)
+					xlog.Model.Info().Msgf("[%s] attempting to load with CUDA variant", backend)
 					grpcProcess = p
 					foundCUDA = true
 				} else {
-					log.Debug().Msgf("Nvidia GPU device found, no embedded CUDA variant found. You can ignore this message if you are using container with CUDA support")
+					xlog.Model.Debug().Msgf("Nvidia GPU device found, no embedded CUDA variant found. You can ignore this message if you are using container with CUDA support")
 				}
 			}
 			if strings.Contains(gpu.String(), "amd") {
 				p := backendPath(assetDir, LLamaCPPHipblas)
 				if _, err := os.Stat(p); err == nil {
-					log.Info().Msgf("[%s] attempting to load with HIPBLAS variant", backend)
+					xlog.Model.Info().Msgf("[%s] attempting to load with HIPBLAS variant", backend)
 					grpcProcess = p
 					foundAMDGPU = true
 				} else {
-					log.Debug().Msgf("AMD GPU device found, no embedded HIPBLAS variant found. You can ignore this message if you are using container with HIPBLAS support")
+					xlog.Model.Debug().Msgf("AMD GPU device found, no embedded HIPBLAS variant found. You can ignore this message if you are using container with HIPBLAS support")
 				}
 			}
 			if strings.Contains(gpu.String(), "intel") {
@@ -229,11 +269,11 @@ func selectGRPCProcess(backend, assetDir string, f16 bool) string {
 				}
 				p := backendPath(assetDir, backend)
 				if _, err := os.Stat(p); err == nil {
-					log.Info().Msgf("[%s] attempting to load with Intel variant", backend)
+					xlog.Model.Info().Msgf("[%s] attempting to load with Intel variant", backend)
 					grpcProcess = p
 					foundIntelGPU = true
 				} else {
-					log.Debug().Msgf("Intel GPU device found, no embedded SYCL variant found. You can ignore this message if you are using container with SYCL support")
+					xlog.Model.Debug().Msgf("Intel GPU device found, no embedded SYCL variant found. You can ignore this message if you are using container with SYCL support")
 				}
 			}
 		}
@@ -243,22 +283,31 @@ func selectGRPCProcess(backend, assetDir string, f16 bool) string {
 		return grpcProcess
 	}
 
+	if hasMetal(runtime.GOOS, runtime.GOARCH) {
+		p := backendPath(assetDir, LLamaCPPMetal)
+		if _, err := os.Stat(p); err == nil {
+			xlog.Model.Info().Msgf("[%s] attempting to load with Metal variant", backend)
+			return p
+		}
+		xlog.Model.Debug().Msgf("Apple Silicon GPU found, no embedded Metal variant found")
+	}
+
 	if xsysinfo.HasCPUCaps(cpuid.AVX2) {
 		p := backendPath(assetDir, LLamaCPPAVX2)
 		if _, err := os.Stat(p); err == nil {
-			log.Info().Msgf("[%s] attempting to load with AVX2 variant", backend)
+			xlog.Model.Info().Msgf("[%s] attempting to load with AVX2 variant", backend)
 			grpcProcess = p
 		}
 	} else if xsysinfo.HasCPUCaps(cpuid.AVX) {
 		p := backendPath(assetDir, LLamaCPPAVX)
 		if _, err := os.Stat(p); err == nil {
-			log.Info().Msgf("[%s] attempting to load with AVX variant", backend)
+			xlog.Model.Info().Msgf("[%s] attempting to load with AVX variant", backend)
 			grpcProcess = p
 		}
 	} else {
 		p := backendPath(assetDir, LLamaCPPFallback)
 		if _, err := os.Stat(p); err == nil {
-			log.Info().Msgf("[%s] attempting to load with fallback variant", backend)
+			xlog.Model.Info().Msgf("[%s] attempting to load with fallback variant", backend)
 			grpcProcess = p
 		}
 	}
@@ -266,12 +315,28 @@ func selectGRPCProcess(backend, assetDir string, f16 bool) string {
 	return grpcProcess
 }
 
+// hasMetal reports whether the system has a Metal GPU: all the Apple Silicon Macs have one
+func hasMetal(goos, goarch string) bool {
+	return goos == "darwin" && goarch == "arm64"
+}
+
+// grpcModel returns the loader of the model with the backend, notifying the load observers
+func (ml *ModelLoader) grpcModel(backend string, o *Options) func(string, string, string) (*Model, error) {
+	load := ml.startGRPCModel(backend, o)
+	return func(modelID, modelName, modelFile string) (*Model, error) {
+		start := time.Now()
+		m, err := load(modelID, modelName, modelFile)
+		ml.observeLoad(modelID, backend, time.Since(start), err)
+		return m, err
+	}
+}
+
 // starts the grpcModelProcess for the backend, and returns a grpc client
 // It also loads the model
-func (ml *ModelLoader) grpcModel(backend string, o *Options) func(string, string, string) (*Model, error) {
+func (ml *ModelLoader) startGRPCModel(backend string, o *Options) func(string, string, string) (*Model, error) {
 	return func(modelID, modelName, modelFile string) (*Model, error) {
 
-		log.Debug().Msgf("Loading Model %s with gRPC (file: %s) (backend: %s): %+v", modelID, modelFile, backend, *o)
+		xlog.Model.Debug().Msgf("Loading Model %s with gRPC (file: %s) (backend: %s): %+v", modelID, modelFile, backend, *o)
 
 		var client *Model
 
@@ -288,33 +353,56 @@ func (ml *ModelLoader) grpcModel(backend string, o *Options) func(string, string
 			if os.Getenv(env) == "" {
 				err := os.Setenv(env, ml.ModelPath)
 				if err != nil {
-					log.Error().Err(err).Str("name", env).Str("modelPath", ml.ModelPath).Msg("unable to set environment variable to modelPath")
+					xlog.Model.Error().Err(err).Str("name", env).Str("modelPath", ml.ModelPath).Msg("unable to set environment variable to modelPath")
 				}
 			}
 		}
 
 		// Check if the backend is provided as external
 		if uri, ok := o.externalBackends[backend]; ok {
-			log.Debug().Msgf("Loading external backend: %s", uri)
-			// check if uri is a file or a address
-			if fi, err := os.Stat(uri); err == nil {
-				log.Debug().Msgf("external backend is file: %+v", fi)
+			xlog.Model.Debug().Msgf("Loading external backend: %s", uri)
+			// check if uri is a container, a file or a address
+			if image, isContainer := strings.CutPrefix(uri, ContainerBackendPrefix); isContainer {
+				serverAddress, err := getFreeAddress()
+				if err != nil {
+					return nil, fmt.Errorf("failed allocating free ports: %s", err.Error())
+				}
+				containerRuntime := cmp.Or(o.containerRuntime, DefaultContainerRuntime)
+				process, container, err := ml.startContainer(containerRuntime, image, modelID, serverAddress)
+				if err != nil {
+					xlog.Model.Error().Err(err).Str("image", image).Msg("failed to launch the backend container")
+					return nil, err
+				}
+
+				xlog.Model.Debug().Msgf("GRPC Service Started in container %s", container.name)
+
+				client = NewModel(modelID, serverAddress, process)
+				client.container = container
+			} else if fi, err := os.Stat(uri); err == nil {
+				xlog.Model.Debug().Msgf("external backend is file: %+v", fi)
 				serverAddress, err := getFreeAddress()
 				if err != nil {
 					return nil, fmt.Errorf("failed allocating free ports: %s", err.Error())
 				}
 				// Make sure the process is executable
-				process, err := ml.startProcess(uri, modelID, serverAddress)
+				process, err := ml.startProcess(backend, uri, o.backendWorkDir, o.cpuAffinity, modelID, serverAddress)
 				if err != nil {
-					log.Error().Err(err).Str("path", uri).Msg("failed to launch ")
+					xlog.Model.Error().Err(err).Str("path", uri).Msg("failed to launch ")
 					return nil, err
 				}
 
-				log.Debug().Msgf("GRPC Service Started")
+				xlog.Model.Debug().Msgf("GRPC Service Started")
 
 				client = NewModel(modelID, serverAddress, process)
+			} else if isAddressPool(uri) {
+				address, err := ml.addressPool(o.context, uri).pick(o.context)
+				if err != nil {
+					return nil, fmt.Errorf("external backend %s: %w", backend, err)
+				}
+				xlog.Model.Debug().Msgf("external backend is a pool of addresses, using %s", address)
+				client = NewModel(modelID, address, nil)
 			} else {
-				log.Debug().Msg("external backend is a uri")
+				xlog.Model.Debug().Msg("external backend is a uri")
 				// address
 				client = NewModel(modelID, uri, nil)
 			}
@@ -347,38 +435,45 @@ func (ml *ModelLoader) grpcModel(backend string, o *Options) func(string, string
 			args, grpcProcess = library.LoadLDSO(o.assetDir, args, grpcProcess)
 
 			// Make sure the process is executable in any circumstance
-			process, err := ml.startProcess(grpcProcess, modelID, serverAddress, args...)
+			process, err := ml.startProcess(backend, grpcProcess, o.backendWorkDir, o.cpuAffinity, modelID, serverAddress, args...)
 			if err != nil {
 				return nil, err
 			}
 
-			log.Debug().Msgf("GRPC Service Started")
+			xlog.Model.Debug().Msgf("GRPC Service Started")
 
 			client = NewModel(modelID, serverAddress, process)
 		}
 
-		log.Debug().Msgf("Wait for the service to start up")
+		xlog.Model.Debug().Msgf("Wait for the service to start up")
 
 		// Wait for the service to start up
 		ready := false
 		for i := 0; i < o.grpcAttempts; i++ {
 			alive, err := client.GRPC(o.parallelRequests, ml.wd).HealthCheck(context.Background())
 			if alive {
-				log.Debug().Msgf("GRPC Service Ready")
+				xlog.Model.Debug().Msgf("GRPC Service Ready")
 				ready = true
 				break
 			}
 			if err != nil && i == o.grpcAttempts-1 {
-				log.Error().Err(err).Msg("failed starting/connecting to the gRPC service")
+				xlog.Model.Error().Err(err).Msg("failed starting/connecting to the gRPC service")
 			}
 			time.Sleep(time.Duration(o.grpcAttemptsDelay) * time.Second)
 		}
 
-		if !ready {
-			log.Debug().Msgf("GRPC Service NOT ready")
+		stop := func() {
 			if process := client.Process(); process != nil {
 				process.Stop()
 			}
+			if client.container != nil {
+				client.container.remove()
+			}
+		}
+
+		if !ready {
+			xlog.Model.Debug().Msgf("GRPC Service NOT ready")
+			stop()
 			return nil, fmt.Errorf("grpc service not ready")
 		}
 
@@ -386,20 +481,30 @@ func (ml *ModelLoader) grpcModel(backend string, o *Options) func(string, string
 		options.Model = modelName
 		options.ModelFile = modelFile
 
-		log.Debug().Msgf("GRPC: Loading model with options: %+v", options)
+		xlog.Model.Debug().Msgf("GRPC: Loading model with options: %+v", options)
 
 		res, err := client.GRPC(o.parallelRequests, ml.wd).LoadModel(o.context, &options)
 		if err != nil {
-			if process := client.Process(); process != nil {
-				process.Stop()
-			}
+			err = loadFailure(client, modelFile, err)
+			stop()
 			return nil, fmt.Errorf("could not load model: %w", err)
 		}
 		if !res.Success {
-			if process := client.Process(); process != nil {
-				process.Stop()
+			err = loadFailure(client, modelFile, errors.New(res.Message))
+			stop()
+			return nil, fmt.Errorf("could not load model (no success): %w", err)
+		}
+
+		client.capabilities = queryCapabilities(o.context, client.GRPC(o.parallelRequests, ml.wd))
+
+		if o.warmup != nil {
+			// a failed warmup is not fatal, the model is loaded anyway
+			start := time.Now()
+			if err := o.warmup(o.context, client.GRPC(o.parallelRequests, ml.wd)); err != nil {
+				xlog.Model.Warn().Err(err).Str("model", modelID).Msg("failed warming up the model")
+			} else {
+				xlog.Model.Info().Str("model", modelID).Dur("duration", time.Since(start)).Msg("model warmed up")
 			}
-			return nil, fmt.Errorf("could not load model (no success): %s", res.Message)
 		}
 
 		return client, nil
@@ -413,19 +518,19 @@ func (ml *ModelLoader) ListAvailableBackends(assetdir string) ([]string, error)
 func (ml *ModelLoader) BackendLoader(opts ...Option) (client grpc.Backend, err error) {
 	o := NewOptions(opts...)
 
-	log.Info().Msgf("Loading model '%s' with backend %s", o.modelID, o.backendString)
+	xlog.Model.Info().Msgf("Loading model '%s' with backend %s", o.modelID, o.backendString)
 
 	backend := strings.ToLower(o.backendString)
 	if realBackend, exists := Aliases[backend]; exists {
 		backend = realBackend
-		log.Debug().Msgf("%s is an alias of %s", backend, realBackend)
+		xlog.Model.Debug().Msgf("%s is an alias of %s", backend, realBackend)
 	}
 
 	if o.singleActiveBackend {
-		log.Debug().Msgf("Stopping all backends except '%s'", o.modelID)
+		xlog.Model.Debug().Msgf("Stopping all backends except '%s'", o.modelID)
 		err := ml.StopGRPC(allExcept(o.modelID))
 		if err != nil {
-			log.Error().Err(err).Str("keptModel", o.modelID).Msg("error while shutting down all backends except for the keptModel")
+			xlog.Model.Error().Err(err).Str("keptModel", o.modelID).Msg("error while shutting down all backends except for the keptModel")
 		}
 	}
 
@@ -453,20 +558,25 @@ func (ml *ModelLoader) GreedyLoader(opts ...Option) (grpc.Backend, error) {
 	// Return earlier if we have a model already loaded
 	// (avoid looping through all the backends)
 	if m := ml.CheckIsLoaded(o.modelID); m != nil {
-		log.Debug().Msgf("Model '%s' already loaded", o.modelID)
+		xlog.Model.Debug().Msgf("Model '%s' already loaded", o.modelID)
 
 		return m.GRPC(o.parallelRequests, ml.wd), nil
 	}
 
 	// If we can have only one backend active, kill all the others (except external backends)
 	if o.singleActiveBackend {
-		log.Debug().Msgf("Stopping all backends except '%s'", o.modelID)
+		xlog.Model.Debug().Msgf("Stopping all backends except '%s'", o.modelID)
 		err := ml.StopGRPC(allExcept(o.modelID))
 		if err != nil {
-			log.Error().Err(err).Str("keptModel", o.modelID).Msg("error while shutting down all backends except for the keptModel - greedyloader continuing")
+			xlog.Model.Error().Err(err).Str("keptModel", o.modelID).Msg("error while shutting down all backends except for the keptModel - greedyloader continuing")
 		}
 	}
 
+	if o.mappedBackend != "" {
+		xlog.Model.Info().Msgf("Loading the model '%s' with the backend '%s' of the backend mapping", o.modelID, o.mappedBackend)
+		return ml.BackendLoader(append(opts, WithBackendString(o.mappedBackend))...)
+	}
+
 	var err error
 
 	// get backends embedded in the binary
@@ -480,26 +590,29 @@ func (ml *ModelLoader) GreedyLoader(opts ...Option) (grpc.Backend, error) {
 		autoLoadBackends = append(autoLoadBackends, b)
 	}
 
-	log.Debug().Msgf("Loading from the following backends (in order): %+v", autoLoadBackends)
+	autoLoadBackends = prioritizeBackends(autoLoadBackends, o.backendPriority)
+	autoLoadBackends = withoutBackends(autoLoadBackends, o.disabledBackends)
+
+	xlog.Model.Debug().Msgf("Loading from the following backends (in order): %+v", autoLoadBackends)
 
-	log.Info().Msgf("Trying to load the model '%s' with the backend '%s'", o.modelID, autoLoadBackends)
+	xlog.Model.Info().Msgf("Trying to load the model '%s' with the backend '%s'", o.modelID, autoLoadBackends)
 
 	for _, key := range autoLoadBackends {
-		log.Info().Msgf("[%s] Attempting to load", key)
+		xlog.Model.Info().Msgf("[%s] Attempting to load", key)
 		options := append(opts, []Option{
 			WithBackendString(key),
 		}...)
 
 		model, modelerr := ml.BackendLoader(options...)
 		if modelerr == nil && model != nil {
-			log.Info().Msgf("[%s] Loads OK", key)
+			xlog.Model.Info().Msgf("[%s] Loads OK", key)
 			return model, nil
 		} else if modelerr != nil {
 			err = errors.Join(err, fmt.Errorf("[%s]: %w", key, modelerr))
-			log.Info().Msgf("[%s] Fails: %s", key, modelerr.Error())
+			xlog.Model.Info().Msgf("[%s] Fails: %s", key, modelerr.Error())
 		} else if model == nil {
 			err = errors.Join(err, fmt.Errorf("backend %s returned no usable model", key))
-			log.Info().Msgf("[%s] Fails: %s", key, "backend returned no usable model")
+			xlog.Model.Info().Msgf("[%s] Fails: %s", key, "backend returned no usable model")
 		}
 
 		if autoDetect && key == LLamaCPP && err != nil {
@@ -523,15 +636,15 @@ func (ml *ModelLoader) GreedyLoader(opts ...Option) (grpc.Backend, error) {
 			}
 
 			// Autodetection failed, try the fallback
-			log.Info().Msgf("[%s] Autodetection failed, trying the fallback", key)
+			xlog.Model.Info().Msgf("[%s] Autodetection failed, trying the fallback", key)
 			options = append(options, WithBackendString(backendToUse))
 			model, modelerr = ml.BackendLoader(options...)
 			if modelerr == nil && model != nil {
-				log.Info().Msgf("[%s] Loads OK", key)
+				xlog.Model.Info().Msgf("[%s] Loads OK", key)
 				return model, nil
 			} else {
 				err = errors.Join(err, fmt.Errorf("[%s]: %w", key, modelerr))
-				log.Info().Msgf("[%s] Fails: %s", key, modelerr.Error())
+				xlog.Model.Info().Msgf("[%s] Fails: %s", key, modelerr.Error())
 			}
 		}
 	}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("prioritizeBackends", func() {
+	backends := []string{LLamaCPP, LlamaGGML, "whisper", LCHuggingFaceBackend, BertEmbeddingsBackend}
+
+	It("keeps the order without a priority list", func() {
+		Expect(prioritizeBackends(backends, nil)).To(Equal(backends))
+	})
+
+	It("moves the prioritized backends first, keeping the order of the others", func() {
+		Expect(prioritizeBackends(backends, []string{LCHuggingFaceBackend, "whisper"})).To(Equal(
+			[]string{LCHuggingFaceBackend, "whisper", LLamaCPP, LlamaGGML, BertEmbeddingsBackend},
+		))
+	})
+
+	It("ignores the backends which are not available", func() {
+		Expect(prioritizeBackends(backends, []string{"missing", BertEmbeddingsBackend, BertEmbeddingsBackend})).To(Equal(
+			[]string{BertEmbeddingsBackend, LLamaCPP, LlamaGGML, "whisper", LCHuggingFaceBackend},
+		))
+	})
+})
+
+var _ = Describe("withoutBackends", func() {
+	It("removes the disabled backends, keeping the order of the others", func() {
+		backends := []string{LLamaCPP, RwkvBackend, "whisper", RwkvBackend}
+		Expect(withoutBackends(backends, []string{RwkvBackend, "missing"})).To(Equal([]string{LLamaCPP, "whisper"}))
+	})
+})
+
+var _ = Describe("backendsInAssetDir", func() {
+	It("returns no backends when the asset directory does not exist", func() {
+		backends, err := backendsInAssetDir(filepath.Join(GinkgoT().TempDir(), "missing"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backends).To(BeEmpty())
+	})
+
+	It("keeps the other IO errors", func() {
+		assetDir := filepath.Join(GinkgoT().TempDir(), "file")
+		Expect(os.WriteFile(assetDir, []byte{}, 0600)).To(Succeed())
+		_, err := backendsInAssetDir(assetDir)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("shows the Metal variant as llama-cpp", func() {
+		if !autoDetect {
+			Skip("the llama.cpp variants are shown as they are without autodetection")
+		}
+		assetDir := GinkgoT().TempDir()
+		Expect(os.MkdirAll(backendPath(assetDir, ""), 0750)).To(Succeed())
+		for _, b := range []string{LLamaCPPMetal, LLamaCPPFallback} {
+			Expect(os.WriteFile(backendPath(assetDir, b), []byte{}, 0750)).To(Succeed())
+		}
+		backends, err := backendsInAssetDir(assetDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backends).To(Equal([]string{LLamaCPP, LLamaCPPFallback}))
+	})
+})
+
+var _ = Describe("selectGRPCProcess", func() {
+	It("detects the Metal GPUs of the Apple Silicon Macs", func() {
+		Expect(hasMetal("darwin", "arm64")).To(BeTrue())
+		Expect(hasMetal("darwin", "amd64")).To(BeFalse())
+		Expect(hasMetal("linux", "arm64")).To(BeFalse())
+	})
+
+	It("selects the Metal variant on Apple Silicon", func() {
+		if runtime.GOOS != "darwin" || runtime.GOARCH != "arm64" {
+			Skip("Metal is only available on Apple Silicon")
+		}
+		assetDir := GinkgoT().TempDir()
+		Expect(os.MkdirAll(backendPath(assetDir, ""), 0750)).To(Succeed())
+		for _, b := range []string{LLamaCPPMetal, LLamaCPPFallback} {
+			Expect(os.WriteFile(backendPath(assetDir, b), []byte{}, 0750)).To(Succeed())
+		}
+		Expect(selectGRPCProcess(LLamaCPP, assetDir, true)).To(Equal(backendPath(assetDir, LLamaCPPMetal)))
+	})
+})
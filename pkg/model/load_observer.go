@@ -0,0 +1,57 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The categories of the failed loads of the models
+const (
+	LoadErrorNotFound = "not-found"
+	LoadErrorOOM      = "oom"
+	LoadErrorTimeout  = "timeout"
+	LoadErrorOther    = "other"
+)
+
+// LoadObserver is notified of each attempt to load a model with a backend, with its duration and its
+// error, if any
+type LoadObserver func(modelID, backend string, duration time.Duration, err error)
+
+// OnLoad registers an observer of the loads of the models
+func (ml *ModelLoader) OnLoad(fn LoadObserver) {
+	ml.loadObserversMu.Lock()
+	defer ml.loadObserversMu.Unlock()
+	ml.loadObservers = append(ml.loadObservers, fn)
+}
+
+func (ml *ModelLoader) observeLoad(modelID, backend string, duration time.Duration, err error) {
+	ml.loadObserversMu.Lock()
+	observers := ml.loadObservers
+	ml.loadObserversMu.Unlock()
+
+	for _, fn := range observers {
+		fn(modelID, backend, duration, err)
+	}
+}
+
+// LoadErrorCategory returns the coarse category of the error of a failed load: the model or the backend
+// were not found, the backend ran out of memory, it did not start or load in time, or any other error
+func LoadErrorCategory(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case IsOOM(err):
+		return LoadErrorOOM
+	case errors.Is(err, os.ErrNotExist), strings.Contains(msg, "not found"), strings.Contains(msg, "no such file"):
+		return LoadErrorNotFound
+	case errors.Is(err, context.DeadlineExceeded), status.Code(err) == codes.DeadlineExceeded,
+		strings.Contains(msg, "grpc service not ready"):
+		return LoadErrorTimeout
+	}
+	return LoadErrorOther
+}
@@ -10,10 +10,9 @@ import (
 	"time"
 
 	"github.com/mudler/LocalAI/pkg/templates"
+	"github.com/mudler/LocalAI/pkg/xlog"
 
 	"github.com/mudler/LocalAI/pkg/utils"
-
-	"github.com/rs/zerolog/log"
 )
 
 // new idea: what if we declare a struct of these here, and use a loop to check?
@@ -21,17 +20,35 @@ import (
 // TODO: Split ModelLoader and TemplateLoader? Just to keep things more organized. Left together to share a mutex until I look into that. Would split if we seperate directories for .bin/.yaml and .tmpl
 type ModelLoader struct {
 	ModelPath string
-	mu        sync.Mutex
-	models    map[string]*Model
-	templates *templates.TemplateCache
-	wd        *WatchDog
+	// extraPaths are searched for the models missing from ModelPath, in order
+	extraPaths []string
+	mu         sync.Mutex
+	models     map[string]*Model
+	templates  *templates.TemplateCache
+	wd         *WatchDog
+	sv         *Supervisor
+	// pools are the address pools of the external backends with several replicas, by URI
+	pools   map[string]*addressPool
+	poolsMu sync.Mutex
+	// logs capture the last lines of output of the backends, by model
+	logs        map[string]*logBuffer
+	logLines    int
+	forwardLogs bool
+	logsMu      sync.Mutex
+	// loadObservers are notified of the loads of the models
+	loadObservers   []LoadObserver
+	loadObserversMu sync.Mutex
 }
 
-func NewModelLoader(modelPath string) *ModelLoader {
+// NewModelLoader returns a loader of the models in modelPath. The models missing from it are searched
+// in the extra paths, in order, the first one containing the model wins.
+func NewModelLoader(modelPath string, extraPaths ...string) *ModelLoader {
 	nml := &ModelLoader{
-		ModelPath: modelPath,
-		models:    make(map[string]*Model),
-		templates: templates.NewTemplateCache(modelPath),
+		ModelPath:  modelPath,
+		extraPaths: extraPaths,
+		models:     make(map[string]*Model),
+		logLines:   DefaultBackendLogLines,
+		templates:  templates.NewTemplateCache(modelPath),
 	}
 
 	return nml
@@ -41,8 +58,48 @@ func (ml *ModelLoader) SetWatchDog(wd *WatchDog) {
 	ml.wd = wd
 }
 
+func (ml *ModelLoader) SetSupervisor(sv *Supervisor) {
+	ml.sv = sv
+}
+
+// Supervisor returns the supervisor restarting the failing models, or nil if there is none
+func (ml *ModelLoader) Supervisor() *Supervisor {
+	return ml.sv
+}
+
+// ReportInference records the outcome of an inference of the model, for the supervisor
+func (ml *ModelLoader) ReportInference(modelID string, err error) {
+	if ml.sv != nil {
+		ml.sv.Report(modelID, err)
+	}
+}
+
+// ModelPaths returns the paths searched for the models, in order
+func (ml *ModelLoader) ModelPaths() []string {
+	return append([]string{ml.ModelPath}, ml.extraPaths...)
+}
+
+// ResolveModelFile returns the path of the model file in the first model path containing it, or in
+// ModelPath if none does
+func (ml *ModelLoader) ResolveModelFile(s string) string {
+	for _, path := range ml.ModelPaths() {
+		if utils.ExistsInPath(path, s) {
+			if path != ml.ModelPath {
+				xlog.Model.Debug().Str("model", s).Str("path", path).Msg("model resolved from an extra model path")
+			}
+			return filepath.Join(path, s)
+		}
+	}
+	return filepath.Join(ml.ModelPath, s)
+}
+
 func (ml *ModelLoader) ExistsInModelPath(s string) bool {
-	return utils.ExistsInPath(ml.ModelPath, s)
+	for _, path := range ml.ModelPaths() {
+		if utils.ExistsInPath(path, s) {
+			return true
+		}
+	}
+	return false
 }
 
 var knownFilesToSkip []string = []string{
@@ -73,10 +130,23 @@ func (ml *ModelLoader) ListFilesInModelPath() ([]string, error) {
 	if err != nil {
 		return []string{}, err
 	}
+	for _, path := range ml.extraPaths {
+		extra, err := os.ReadDir(path)
+		if err != nil {
+			xlog.Model.Warn().Err(err).Str("path", path).Msg("cannot read the extra model path")
+			continue
+		}
+		files = append(files, extra...)
+	}
 
 	models := []string{}
+	seen := map[string]bool{}
 FILE:
 	for _, file := range files {
+		// a model in several paths is listed once
+		if seen[file.Name()] {
+			continue
+		}
 
 		for _, skip := range knownFilesToSkip {
 			if strings.EqualFold(file.Name(), skip) {
@@ -96,6 +166,7 @@ FILE:
 			continue
 		}
 
+		seen[file.Name()] = true
 		models = append(models, file.Name())
 	}
 
@@ -120,29 +191,68 @@ func (ml *ModelLoader) LoadModel(modelID, modelName string, loader func(string,
 		return model, nil
 	}
 
-	// Load the model and keep it in memory for later use
-	modelFile := filepath.Join(ml.ModelPath, modelName)
-	log.Debug().Msgf("Loading model in memory from file: %s", modelFile)
-
 	ml.mu.Lock()
 	defer ml.mu.Unlock()
+	// another request may have loaded the model while waiting for the lock
+	if model, ok := ml.models[modelID]; ok {
+		return model, nil
+	}
+	return ml.loadModel(modelID, modelName, loader)
+}
+
+// loadModel loads the model and keeps it in memory for later use. ml.mu must be held.
+func (ml *ModelLoader) loadModel(modelID, modelName string, loader func(string, string, string) (*Model, error)) (*Model, error) {
+	modelFile := ml.ResolveModelFile(modelName)
+	if shards := utils.GGUFShards(modelFile); shards != nil {
+		if missing := utils.MissingGGUFShards(modelFile); len(missing) > 0 {
+			return nil, fmt.Errorf("the model %s is missing the GGUF shards %s", modelName, strings.Join(missing, ", "))
+		}
+		// the backend loads the other shards from the first one
+		modelFile = shards[0]
+	}
+	xlog.Model.Debug().Msgf("Loading model in memory from file: %s", modelFile)
+
 	model, err := loader(modelID, modelName, modelFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load model with internal loader: %s", err)
+		return nil, fmt.Errorf("failed to load model with internal loader: %w", err)
 	}
 
 	if model == nil {
 		return nil, fmt.Errorf("loader didn't return a model")
 	}
 
+	model.name = modelName
+	model.loader = loader
 	ml.models[modelID] = model
 
 	return model, nil
 }
 
+// RestartModel stops the backend of a loaded model and loads the model again. The lock is held from the
+// shutdown to the reload, so that the model can't be loaded or evicted by another caller in between.
+func (ml *ModelLoader) RestartModel(modelID string) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	model, ok := ml.models[modelID]
+	if !ok {
+		return fmt.Errorf("model %s not found", modelID)
+	}
+
+	if err := ml.shutdownModel(modelID); err != nil {
+		return err
+	}
+	_, err := ml.loadModel(modelID, model.name, model.loader)
+	return err
+}
+
 func (ml *ModelLoader) ShutdownModel(modelName string) error {
 	ml.mu.Lock()
 	defer ml.mu.Unlock()
+	return ml.shutdownModel(modelName)
+}
+
+// shutdownModel waits for the backend of the model to be idle and stops it. ml.mu must be held.
+func (ml *ModelLoader) shutdownModel(modelName string) error {
 	model, ok := ml.models[modelName]
 	if !ok {
 		return fmt.Errorf("model %s not found", modelName)
@@ -150,7 +260,7 @@ func (ml *ModelLoader) ShutdownModel(modelName string) error {
 
 	retries := 1
 	for model.GRPC(false, ml.wd).IsBusy() {
-		log.Debug().Msgf("%s busy. Waiting.", modelName)
+		xlog.Model.Debug().Msgf("%s busy. Waiting.", modelName)
 		dur := time.Duration(retries*2) * time.Second
 		if dur > retryTimeout {
 			dur = retryTimeout
@@ -159,7 +269,7 @@ func (ml *ModelLoader) ShutdownModel(modelName string) error {
 		retries++
 
 		if retries > 10 && os.Getenv("LOCALAI_FORCE_BACKEND_SHUTDOWN") == "true" {
-			log.Warn().Msgf("Model %s is still busy after %d retries. Forcing shutdown.", modelName, retries)
+			xlog.Model.Warn().Msgf("Model %s is still busy after %d retries. Forcing shutdown.", modelName, retries)
 			break
 		}
 	}
@@ -175,28 +285,28 @@ func (ml *ModelLoader) CheckIsLoaded(s string) *Model {
 		return nil
 	}
 
-	log.Debug().Msgf("Model already loaded in memory: %s", s)
+	xlog.Model.Debug().Msgf("Model already loaded in memory: %s", s)
 	client := m.GRPC(false, ml.wd)
 
-	log.Debug().Msgf("Checking model availability (%s)", s)
+	xlog.Model.Debug().Msgf("Checking model availability (%s)", s)
 	cTimeout, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
 	alive, err := client.HealthCheck(cTimeout)
 	if !alive {
-		log.Warn().Msgf("GRPC Model not responding: %s", err.Error())
-		log.Warn().Msgf("Deleting the process in order to recreate it")
+		xlog.Model.Warn().Msgf("GRPC Model not responding: %s", err.Error())
+		xlog.Model.Warn().Msgf("Deleting the process in order to recreate it")
 		process := m.Process()
 		if process == nil {
-			log.Error().Msgf("Process not found for '%s' and the model is not responding anymore !", s)
+			xlog.Model.Error().Msgf("Process not found for '%s' and the model is not responding anymore !", s)
 			return m
 		}
 		if !process.IsAlive() {
-			log.Debug().Msgf("GRPC Process is not responding: %s", s)
+			xlog.Model.Debug().Msgf("GRPC Process is not responding: %s", s)
 			// stop and delete the process, this forces to re-load the model and re-create again the service
 			err := ml.deleteProcess(s)
 			if err != nil {
-				log.Error().Err(err).Str("process", s).Msg("error stopping process")
+				xlog.Model.Error().Err(err).Str("process", s).Msg("error stopping process")
 			}
 			return nil
 		}
@@ -1,10 +1,15 @@
 package model_test
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/mudler/LocalAI/pkg/grpc"
+	"github.com/mudler/LocalAI/pkg/grpc/base"
+	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 	"github.com/mudler/LocalAI/pkg/model"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -61,6 +66,37 @@ var _ = Describe("ModelLoader", func() {
 		})
 	})
 
+	Context("extra model paths", func() {
+		var extraPath string
+
+		BeforeEach(func() {
+			extraPath = GinkgoT().TempDir()
+			modelLoader = model.NewModelLoader(modelPath, extraPath)
+		})
+
+		It("should resolve the models from the first path containing them", func() {
+			os.Create(filepath.Join(modelPath, "shared.model"))
+			os.Create(filepath.Join(extraPath, "shared.model"))
+			os.Create(filepath.Join(extraPath, "extra.model"))
+
+			Expect(modelLoader.ModelPaths()).To(Equal([]string{modelPath, extraPath}))
+			Expect(modelLoader.ExistsInModelPath("extra.model")).To(BeTrue())
+			Expect(modelLoader.ResolveModelFile("shared.model")).To(Equal(filepath.Join(modelPath, "shared.model")))
+			Expect(modelLoader.ResolveModelFile("extra.model")).To(Equal(filepath.Join(extraPath, "extra.model")))
+			Expect(modelLoader.ResolveModelFile("missing.model")).To(Equal(filepath.Join(modelPath, "missing.model")))
+		})
+
+		It("should list the models of all the paths once", func() {
+			os.Create(filepath.Join(modelPath, "shared.model"))
+			os.Create(filepath.Join(extraPath, "shared.model"))
+			os.Create(filepath.Join(extraPath, "extra.model"))
+
+			files, err := modelLoader.ListFilesInModelPath()
+			Expect(err).To(BeNil())
+			Expect(files).To(ConsistOf("shared.model", "extra.model"))
+		})
+	})
+
 	Context("LoadModel", func() {
 		It("should load a model and keep it in memory", func() {
 			mockModel = model.NewModel("foo", "test.model", nil)
@@ -84,6 +120,24 @@ var _ = Describe("ModelLoader", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(model).To(BeNil())
 		})
+
+		It("loads the first shard of a split model once all the shards are present", func() {
+			var loadedFile string
+			mockLoader := func(modelID, modelName, modelFile string) (*model.Model, error) {
+				loadedFile = modelFile
+				return model.NewModel(modelID, modelName, nil), nil
+			}
+			Expect(os.WriteFile(filepath.Join(modelPath, "big-00001-of-00002.gguf"), []byte{}, 0600)).To(Succeed())
+
+			_, err := modelLoader.LoadModel("big", "big-00001-of-00002.gguf", mockLoader)
+			Expect(err).To(MatchError(ContainSubstring("missing the GGUF shards " + filepath.Join(modelPath, "big-00002-of-00002.gguf"))))
+			Expect(loadedFile).To(BeEmpty())
+
+			Expect(os.WriteFile(filepath.Join(modelPath, "big-00002-of-00002.gguf"), []byte{}, 0600)).To(Succeed())
+			_, err = modelLoader.LoadModel("big", "big-00002-of-00002.gguf", mockLoader)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loadedFile).To(Equal(filepath.Join(modelPath, "big-00001-of-00002.gguf")))
+		})
 	})
 
 	Context("ShutdownModel", func() {
@@ -100,4 +154,223 @@ var _ = Describe("ModelLoader", func() {
 			Expect(modelLoader.CheckIsLoaded("foo")).To(BeNil())
 		})
 	})
+	Context("RestartModel", func() {
+		It("loads the model again with the same loader", func() {
+			loads := 0
+			mockLoader := func(modelID, modelName, modelFile string) (*model.Model, error) {
+				loads++
+				return model.NewModel(modelID, modelName, nil), nil
+			}
+
+			_, err := modelLoader.LoadModel("foo", "test.model", mockLoader)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(modelLoader.RestartModel("foo")).To(Succeed())
+			Expect(loads).To(Equal(2))
+		})
+
+		It("doesn't load the models which are not loaded anymore", func() {
+			loads := 0
+			mockLoader := func(modelID, modelName, modelFile string) (*model.Model, error) {
+				loads++
+				return model.NewModel(modelID, modelName, nil), nil
+			}
+
+			_, err := modelLoader.LoadModel("foo", "test.model", mockLoader)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(modelLoader.ShutdownModel("foo")).To(Succeed())
+			Expect(modelLoader.RestartModel("foo")).To(MatchError(ContainSubstring("not found")))
+			Expect(loads).To(Equal(1))
+		})
+	})
+	Context("BackendLoader", func() {
+		It("warms up the model once loaded", func() {
+			grpc.Provide("warmup-test", &warmupLLM{})
+
+			var warmedUp []string
+			warmup := func(ctx context.Context, b grpc.Backend) error {
+				reply, err := b.Predict(ctx, &pb.PredictOptions{Prompt: "Hello", Tokens: 1})
+				if err == nil {
+					warmedUp = append(warmedUp, string(reply.Message))
+				}
+				return err
+			}
+			opts := []model.Option{
+				model.WithBackendString("warmup"),
+				model.WithExternalBackend("warmup", "warmup-test"),
+				model.WithModel("test.model"),
+				model.WithModelID("warmup"),
+				model.WithWarmup(warmup),
+			}
+
+			_, err := modelLoader.BackendLoader(opts...)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warmedUp).To(Equal([]string{"Hello"}))
+
+			// the model is already loaded
+			_, err = modelLoader.BackendLoader(opts...)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warmedUp).To(HaveLen(1))
+		})
+
+		It("loads the model even if the warmup fails", func() {
+			grpc.Provide("warmup-fail-test", &warmupLLM{})
+
+			_, err := modelLoader.BackendLoader(
+				model.WithBackendString("warmup"),
+				model.WithExternalBackend("warmup", "warmup-fail-test"),
+				model.WithModel("test.model"),
+				model.WithModelID("warmup-fail"),
+				model.WithWarmup(func(ctx context.Context, b grpc.Backend) error {
+					return errors.New("warmup failed")
+				}),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(modelLoader.CheckIsLoaded("warmup-fail")).ToNot(BeNil())
+		})
+
+		It("tells the backends running out of memory from the broken models", func() {
+			grpc.Provide("oom-test", &failingLoadLLM{err: errors.New("CUDA error: out of memory")})
+			grpc.Provide("broken-test", &failingLoadLLM{err: errors.New("invalid magic number")})
+
+			_, err := modelLoader.BackendLoader(
+				model.WithBackendString("oom"),
+				model.WithExternalBackend("oom", "oom-test"),
+				model.WithModel("test.model"),
+				model.WithModelID("oom"),
+			)
+			Expect(err).To(MatchError(ContainSubstring("out of memory")))
+			Expect(model.IsOOM(err)).To(BeTrue())
+
+			_, err = modelLoader.BackendLoader(
+				model.WithBackendString("broken"),
+				model.WithExternalBackend("broken", "broken-test"),
+				model.WithModel("test.model"),
+				model.WithModelID("broken"),
+			)
+			Expect(err).To(MatchError(ContainSubstring("invalid magic number")))
+			Expect(model.IsOOM(err)).To(BeFalse())
+		})
+
+		It("notifies the observers of the loads", func() {
+			grpc.Provide("observed-test", &warmupLLM{})
+			grpc.Provide("observed-oom-test", &failingLoadLLM{err: errors.New("CUDA error: out of memory")})
+
+			type load struct {
+				model, backend, category string
+			}
+			var loads []load
+			modelLoader.OnLoad(func(modelID, backend string, duration time.Duration, err error) {
+				category := ""
+				if err != nil {
+					category = model.LoadErrorCategory(err)
+				}
+				loads = append(loads, load{modelID, backend, category})
+			})
+
+			_, err := modelLoader.BackendLoader(
+				model.WithBackendString("observed"),
+				model.WithExternalBackend("observed", "observed-test"),
+				model.WithModel("test.model"),
+				model.WithModelID("observed"),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = modelLoader.BackendLoader(
+				model.WithBackendString("observed-oom"),
+				model.WithExternalBackend("observed-oom", "observed-oom-test"),
+				model.WithModel("test.model"),
+				model.WithModelID("observed-oom"),
+			)
+			Expect(err).To(HaveOccurred())
+			Expect(loads).To(Equal([]load{{"observed", "observed", ""}, {"observed-oom", "observed-oom", model.LoadErrorOOM}}))
+
+			Expect(model.LoadErrorCategory(errors.New("backend not found: /backends/foo"))).To(Equal(model.LoadErrorNotFound))
+			Expect(model.LoadErrorCategory(errors.New("grpc service not ready"))).To(Equal(model.LoadErrorTimeout))
+			Expect(model.LoadErrorCategory(errors.New("invalid magic number"))).To(Equal(model.LoadErrorOther))
+		})
+
+		It("checks the capabilities reported by the backends", func() {
+			grpc.Provide("embed-only-test", &embedOnlyLLM{})
+			grpc.Provide("unreported-test", &warmupLLM{})
+
+			_, err := modelLoader.BackendLoader(
+				model.WithBackendString("embed-only"),
+				model.WithExternalBackend("embed-only", "embed-only-test"),
+				model.WithModel("test.model"),
+				model.WithModelID("embed-only"),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(modelLoader.CheckCapability("embed-only", grpc.CapabilityEmbed)).To(Succeed())
+			err = modelLoader.CheckCapability("embed-only", grpc.CapabilityGenerate)
+			Expect(errors.Is(err, model.ErrUnsupportedCapability)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("does not support text generation")))
+
+			// the backends not reporting their capabilities are allowed everything
+			_, err = modelLoader.BackendLoader(
+				model.WithBackendString("unreported"),
+				model.WithExternalBackend("unreported", "unreported-test"),
+				model.WithModel("test.model"),
+				model.WithModelID("unreported"),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(modelLoader.CheckCapability("unreported", grpc.CapabilityGenerate)).To(Succeed())
+		})
+	})
+	Context("GreedyLoader", func() {
+		It("loads the model with the backend of the mapping instead of trying the backends", func() {
+			grpc.Provide("mapped-test", &warmupLLM{})
+			grpc.Provide("unmapped-test", &failingLoadLLM{err: errors.New("invalid magic number")})
+
+			var backends []string
+			modelLoader.OnLoad(func(modelID, backend string, duration time.Duration, err error) {
+				backends = append(backends, backend)
+			})
+
+			_, err := modelLoader.GreedyLoader(
+				model.WithMappedBackend("mapped"),
+				model.WithExternalBackend("unmapped", "unmapped-test"),
+				model.WithExternalBackend("mapped", "mapped-test"),
+				model.WithAssetDir(GinkgoT().TempDir()),
+				model.WithModel("test.model"),
+				model.WithModelID("mapped-model"),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backends).To(Equal([]string{"mapped"}))
+		})
+	})
 })
+
+// failingLoadLLM is a backend failing to load the models
+type failingLoadLLM struct {
+	base.SingleThread
+	err error
+}
+
+func (llm *failingLoadLLM) Load(opts *pb.ModelOptions) error {
+	return llm.err
+}
+
+// embedOnlyLLM is a backend only computing embeddings
+type embedOnlyLLM struct {
+	base.SingleThread
+}
+
+func (llm *embedOnlyLLM) Load(opts *pb.ModelOptions) error {
+	return nil
+}
+
+func (llm *embedOnlyLLM) Capabilities() ([]string, error) {
+	return []string{grpc.CapabilityEmbed}, nil
+}
+
+// warmupLLM is a backend echoing the prompts
+type warmupLLM struct {
+	base.SingleThread
+}
+
+func (llm *warmupLLM) Load(opts *pb.ModelOptions) error {
+	return nil
+}
+
+func (llm *warmupLLM) Predict(opts *pb.PredictOptions) (string, error) {
+	return opts.Prompt, nil
+}
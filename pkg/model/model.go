@@ -12,6 +12,13 @@ type Model struct {
 	address string
 	client  grpc.Backend
 	process *process.Process
+	// container is set for the backends running in a container
+	container *container
+	// name and loader load the model again on restart
+	name   string
+	loader func(string, string, string) (*Model, error)
+	// capabilities are the features the backend reported supporting with the model, nil if unknown
+	capabilities map[string]bool
 	sync.Mutex
 }
 
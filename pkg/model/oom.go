@@ -0,0 +1,84 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mudler/LocalAI/pkg/utils"
+	"github.com/mudler/LocalAI/pkg/xlog"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
+)
+
+// ErrBackendOOM is wrapped by the errors of the backends which ran out of memory loading a model, which
+// may load with more memory or a smaller quantization, unlike a broken model
+var ErrBackendOOM = errors.New("backend out of memory")
+
+// IsOOM reports whether the backend failed because it ran out of memory
+func IsOOM(err error) bool {
+	return errors.Is(err, ErrBackendOOM)
+}
+
+// oomSignatures are the messages of the backends running out of memory (llama.cpp, pytorch, vulkan...),
+// in lower case
+var oomSignatures = []string{
+	"out of memory",
+	"outofmemory",
+	"out_of_memory",
+	"erroroutofdevicememory",
+	"cudamalloc failed",
+	"failed to allocate",
+}
+
+// stderrTailSize is the size of the end of the output of the backend searched for the OOM signatures
+const stderrTailSize = 64 * 1024
+
+func isOOMMessage(s string) bool {
+	s = strings.ToLower(s)
+	for _, signature := range oomSignatures {
+		if strings.Contains(s, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileTail returns the end of the file, up to size bytes
+func fileTail(path string, size int64) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > size {
+		f.Seek(-size, io.SeekEnd)
+	}
+	data, _ := io.ReadAll(f)
+	return string(data)
+}
+
+// loadFailure returns the error of a model failing to load, wrapping ErrBackendOOM when the error or the
+// output of the backend show that it ran out of memory
+func loadFailure(m *Model, modelFile string, err error) error {
+	oom := isOOMMessage(err.Error())
+	if !oom && m.Process() != nil {
+		oom = isOOMMessage(fileTail(m.Process().StderrPath(), stderrTailSize))
+	}
+	if !oom {
+		return err
+	}
+
+	event := xlog.Model.Error().Err(err).Str("model", m.ID)
+	if size, sizeErr := utils.GGUFSize(modelFile); sizeErr == nil {
+		// the weights are the bulk of the memory of a model, the context comes on top
+		event = event.Uint64("estimated_bytes", uint64(size))
+	}
+	if total, free, memErr := xsysinfo.GPUMemory(); memErr == nil {
+		event = event.Uint64("vram_total_bytes", total).Uint64("vram_free_bytes", free)
+	}
+	event.Msg("backend ran out of memory loading the model")
+
+	return fmt.Errorf("%w: %w", ErrBackendOOM, err)
+}
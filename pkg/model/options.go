@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 
+	"github.com/mudler/LocalAI/pkg/grpc"
 	pb "github.com/mudler/LocalAI/pkg/grpc/proto"
 )
 
@@ -16,11 +17,19 @@ type Options struct {
 	gRPCOptions *pb.ModelOptions
 
 	externalBackends map[string]string
+	containerRuntime string
+	backendWorkDir   string
+	cpuAffinity      []int
 
 	grpcAttempts        int
 	grpcAttemptsDelay   int
 	singleActiveBackend bool
 	parallelRequests    bool
+	backendPriority     []string
+	disabledBackends    []string
+	mappedBackend       string
+
+	warmup func(context.Context, grpc.Backend) error
 }
 
 type Option func(*Options)
@@ -38,6 +47,52 @@ func WithExternalBackend(name string, uri string) Option {
 	}
 }
 
+// WithBackendWorkDir sets the working directory of the backend process, instead of the directory of the
+// backend binary
+func WithBackendWorkDir(path string) Option {
+	return func(o *Options) {
+		o.backendWorkDir = path
+	}
+}
+
+// WithCPUAffinity binds the backend process to the CPUs
+func WithCPUAffinity(cpus []int) Option {
+	return func(o *Options) {
+		o.cpuAffinity = cpus
+	}
+}
+
+// WithContainerRuntime sets the runtime (docker, podman) running the external backends given as
+// container://image URIs
+func WithContainerRuntime(runtime string) Option {
+	return func(o *Options) {
+		o.containerRuntime = runtime
+	}
+}
+
+// WithBackendPriority sets the backends the greedy loader tries first, in order. The other
+// backends are tried after them, in their usual order.
+func WithBackendPriority(backends []string) Option {
+	return func(o *Options) {
+		o.backendPriority = backends
+	}
+}
+
+// WithDisabledBackends sets the backends the greedy loader never tries
+func WithDisabledBackends(backends []string) Option {
+	return func(o *Options) {
+		o.disabledBackends = backends
+	}
+}
+
+// WithMappedBackend sets the backend the greedy loader uses instead of detecting one, from the
+// backend mapping
+func WithMappedBackend(backend string) Option {
+	return func(o *Options) {
+		o.mappedBackend = backend
+	}
+}
+
 func WithGRPCAttempts(attempts int) Option {
 	return func(o *Options) {
 		o.grpcAttempts = attempts
@@ -86,6 +141,13 @@ func WithSingleActiveBackend() Option {
 	}
 }
 
+// WithWarmup sets the dummy request sent to the backend once the model is loaded
+func WithWarmup(warmup func(context.Context, grpc.Backend) error) Option {
+	return func(o *Options) {
+		o.warmup = warmup
+	}
+}
+
 func WithModelID(id string) Option {
 	return func(o *Options) {
 		o.modelID = id
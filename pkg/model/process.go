@@ -7,36 +7,40 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"syscall"
 
 	"github.com/hpcloud/tail"
+	"github.com/mudler/LocalAI/pkg/xlog"
+	"github.com/mudler/LocalAI/pkg/xsysinfo"
 	process "github.com/mudler/go-processmanager"
-	"github.com/rs/zerolog/log"
 )
 
 func (ml *ModelLoader) deleteProcess(s string) error {
 	defer delete(ml.models, s)
 
-	log.Debug().Msgf("Deleting process %s", s)
+	xlog.Model.Debug().Msgf("Deleting process %s", s)
 
 	m, exists := ml.models[s]
 	if !exists {
-		log.Error().Msgf("Model does not exist %s", s)
+		xlog.Model.Error().Msgf("Model does not exist %s", s)
 		// Nothing to do
 		return nil
 	}
 
+	if m.container != nil {
+		defer m.container.remove()
+	}
+
 	process := m.Process()
 	if process == nil {
-		log.Error().Msgf("No process for %s", s)
+		xlog.Model.Error().Msgf("No process for %s", s)
 		// Nothing to do as there is no process
 		return nil
 	}
 
 	err := process.Stop()
 	if err != nil {
-		log.Error().Err(err).Msgf("(deleteProcess) error while deleting process %s", s)
+		xlog.Model.Error().Err(err).Msgf("(deleteProcess) error while deleting process %s", s)
 	}
 
 	return err
@@ -70,25 +74,48 @@ func (ml *ModelLoader) GetGRPCPID(id string) (int, error) {
 	return strconv.Atoi(p.Process().PID)
 }
 
-func (ml *ModelLoader) startProcess(grpcProcess, id string, serverAddress string, args ...string) (*process.Process, error) {
+// startProcess runs the binary of the backend in workDir, or in the directory of the binary if workDir is empty
+func (ml *ModelLoader) startProcess(backend, grpcProcess, workDir string, cpus []int, id string, serverAddress string, args ...string) (*process.Process, error) {
 	// Make sure the process is executable
 	if err := os.Chmod(grpcProcess, 0700); err != nil {
 		return nil, err
 	}
 
-	log.Debug().Msgf("Loading GRPC Process: %s", grpcProcess)
+	xlog.Model.Debug().Msgf("Loading GRPC Process: %s", grpcProcess)
 
-	log.Debug().Msgf("GRPC Service for %s will be running at: '%s'", id, serverAddress)
+	xlog.Model.Debug().Msgf("GRPC Service for %s will be running at: '%s'", id, serverAddress)
 
-	workDir, err := filepath.Abs(filepath.Dir(grpcProcess))
+	// the binary is run relative to the working directory
+	name := filepath.Base(grpcProcess)
+	if workDir == "" {
+		workDir = filepath.Dir(grpcProcess)
+	} else {
+		abs, err := filepath.Abs(grpcProcess)
+		if err != nil {
+			return nil, err
+		}
+		name = abs
+	}
+	workDir, err := filepath.Abs(workDir)
 	if err != nil {
 		return nil, err
 	}
+	if fi, err := os.Stat(workDir); err != nil {
+		return nil, fmt.Errorf("invalid backend working directory: %w", err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("invalid backend working directory: %s is not a directory", workDir)
+	}
+
+	return ml.runProcess(backend, name, workDir, cpus, id, serverAddress, append(args, []string{"--addr", serverAddress}...)...)
+}
 
+// runProcess runs the process serving a backend at serverAddress, bound to the cpus when set, and forwards
+// its output to the logs
+func (ml *ModelLoader) runProcess(backend, name, workDir string, cpus []int, id, serverAddress string, args ...string) (*process.Process, error) {
 	grpcControlProcess := process.New(
 		process.WithTemporaryStateDir(),
-		process.WithName(filepath.Base(grpcProcess)),
-		process.WithArgs(append(args, []string{"--addr", serverAddress}...)...),
+		process.WithName(name),
+		process.WithArgs(args...),
 		process.WithEnvironment(os.Environ()...),
 		process.WithWorkDir(workDir),
 	)
@@ -98,11 +125,16 @@ func (ml *ModelLoader) startProcess(grpcProcess, id string, serverAddress string
 		ml.wd.AddAddressModelMap(serverAddress, id)
 	}
 
-	if err := grpcControlProcess.Run(); err != nil {
+	if len(cpus) > 0 {
+		xlog.Model.Info().Msgf("Binding the backend of %s to the CPUs %s", id, xsysinfo.FormatCPUSet(cpus))
+		if err := startWithAffinity(cpus, grpcControlProcess.Run); err != nil {
+			return grpcControlProcess, err
+		}
+	} else if err := grpcControlProcess.Run(); err != nil {
 		return grpcControlProcess, err
 	}
 
-	log.Debug().Msgf("GRPC Service state dir: %s", grpcControlProcess.StateDir())
+	xlog.Model.Debug().Msgf("GRPC Service state dir: %s", grpcControlProcess.StateDir())
 	// clean up process
 	go func() {
 		c := make(chan os.Signal, 1)
@@ -110,26 +142,34 @@ func (ml *ModelLoader) startProcess(grpcProcess, id string, serverAddress string
 		<-c
 		err := grpcControlProcess.Stop()
 		if err != nil {
-			log.Error().Err(err).Msg("error while shutting down grpc process")
+			xlog.Model.Error().Err(err).Msg("error while shutting down grpc process")
 		}
 	}()
 
+	logs := ml.backendLogs(id)
+	forward := ml.forwardBackendLogs()
 	go func() {
 		t, err := tail.TailFile(grpcControlProcess.StderrPath(), tail.Config{Follow: true})
 		if err != nil {
-			log.Debug().Msgf("Could not tail stderr")
+			xlog.Model.Debug().Msgf("Could not tail stderr")
 		}
 		for line := range t.Lines {
-			log.Debug().Msgf("GRPC(%s): stderr %s", strings.Join([]string{id, serverAddress}, "-"), line.Text)
+			logBackendLine(forward, backend, id, serverAddress, "stderr", line.Text)
+			if logs != nil {
+				logs.add("stderr", line.Text)
+			}
 		}
 	}()
 	go func() {
 		t, err := tail.TailFile(grpcControlProcess.StdoutPath(), tail.Config{Follow: true})
 		if err != nil {
-			log.Debug().Msgf("Could not tail stdout")
+			xlog.Model.Debug().Msgf("Could not tail stdout")
 		}
 		for line := range t.Lines {
-			log.Debug().Msgf("GRPC(%s): stdout %s", strings.Join([]string{id, serverAddress}, "-"), line.Text)
+			logBackendLine(forward, backend, id, serverAddress, "stdout", line.Text)
+			if logs != nil {
+				logs.add("stdout", line.Text)
+			}
 		}
 	}()
 
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("startProcess", func() {
+	var backendDir, backend, out string
+
+	BeforeEach(func() {
+		backendDir = GinkgoT().TempDir()
+		out = filepath.Join(GinkgoT().TempDir(), "pwd")
+		backend = filepath.Join(backendDir, "backend")
+		Expect(os.WriteFile(backend, []byte("#!/bin/sh\npwd > "+out+"\n"), 0700)).To(Succeed())
+	})
+
+	workDir := func() string {
+		data, _ := os.ReadFile(out)
+		return strings.TrimSpace(string(data))
+	}
+
+	It("runs the backend in its own directory by default", func() {
+		_, err := NewModelLoader("").startProcess("backend", backend, "", nil, "model", "127.0.0.1:50051")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(workDir).Should(Equal(backendDir))
+	})
+
+	It("runs the backend in the configured directory", func() {
+		dir := GinkgoT().TempDir()
+		_, err := NewModelLoader("").startProcess("backend", backend, dir, nil, "model", "127.0.0.1:50051")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(workDir).Should(Equal(dir))
+	})
+
+	It("fails when the configured directory does not exist", func() {
+		_, err := NewModelLoader("").startProcess("backend", backend, filepath.Join(backendDir, "missing"), nil, "model", "127.0.0.1:50051")
+		Expect(err).To(MatchError(ContainSubstring("invalid backend working directory")))
+
+		_, err = NewModelLoader("").startProcess("backend", backend, backend, nil, "model", "127.0.0.1:50051")
+		Expect(err).To(MatchError(ContainSubstring("is not a directory")))
+	})
+
+	It("binds the backend to the configured CPUs", func() {
+		if runtime.GOOS != "linux" {
+			Skip("the CPU affinity is only set on Linux")
+		}
+		Expect(os.WriteFile(backend, []byte("#!/bin/sh\ngrep Cpus_allowed_list /proc/self/status | cut -f2 > "+out+"\n"), 0700)).To(Succeed())
+
+		_, err := NewModelLoader("").startProcess("backend", backend, "", []int{0}, "model", "127.0.0.1:50051")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(workDir).Should(Equal("0"))
+	})
+})
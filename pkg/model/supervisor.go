@@ -0,0 +1,117 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mudler/LocalAI/pkg/xlog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxBackoffShift caps the doubling of the backoff between the restarts of a model
+const maxBackoffShift = 6
+
+// Supervisor restarts the backend of a model once its inferences failed a number of times in a row,
+// rather than returning errors until someone restarts it by hand. The restarts of a model are spaced
+// by a backoff, doubling at each restart, and stop after a maximum number of restarts.
+type Supervisor struct {
+	sync.Mutex
+	rm          Restarter
+	failures    int
+	backoff     time.Duration
+	maxRestarts int
+	onRestart   []func(modelID string)
+	models      map[string]*supervisedModel
+}
+
+type supervisedModel struct {
+	failures    int
+	restarts    int
+	lastRestart time.Time
+	restarting  bool
+	gaveUp      bool
+}
+
+type Restarter interface {
+	RestartModel(modelID string) error
+}
+
+// NewSupervisor returns a supervisor restarting the models after failures inferences failed in a row,
+// waiting at least backoff after a restart before the next one, for up to maxRestarts restarts per
+// model (0 is unlimited)
+func NewSupervisor(rm Restarter, failures int, backoff time.Duration, maxRestarts int) *Supervisor {
+	return &Supervisor{
+		rm:          rm,
+		failures:    failures,
+		backoff:     backoff,
+		maxRestarts: maxRestarts,
+		models:      map[string]*supervisedModel{},
+	}
+}
+
+// OnRestart registers a function called after each restart
+func (s *Supervisor) OnRestart(fn func(modelID string)) {
+	s.Lock()
+	defer s.Unlock()
+	s.onRestart = append(s.onRestart, fn)
+}
+
+// Report records the outcome of an inference, and restarts the model in the background when needed.
+// The requests canceled by the clients are not failures of the backend.
+func (s *Supervisor) Report(modelID string, err error) {
+	if errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	m, exists := s.models[modelID]
+	if !exists {
+		m = &supervisedModel{}
+		s.models[modelID] = m
+	}
+	if err == nil {
+		m.failures = 0
+		return
+	}
+
+	m.failures++
+	if m.failures < s.failures || m.restarting {
+		return
+	}
+	if s.maxRestarts > 0 && m.restarts >= s.maxRestarts {
+		if !m.gaveUp {
+			m.gaveUp = true
+			xlog.Model.Error().Str("model", modelID).Int("restarts", m.restarts).Msg("model keeps failing, giving up restarting it")
+		}
+		return
+	}
+	if m.restarts > 0 && time.Since(m.lastRestart) < s.backoff<<min(m.restarts-1, maxBackoffShift) {
+		return
+	}
+
+	m.failures = 0
+	m.restarts++
+	m.lastRestart = time.Now()
+	m.restarting = true
+	xlog.Model.Warn().Err(err).Str("model", modelID).Int("restart", m.restarts).Msgf("inferences failed %d times in a row, restarting the backend", s.failures)
+
+	go func() {
+		if err := s.rm.RestartModel(modelID); err != nil {
+			xlog.Model.Error().Err(err).Str("model", modelID).Msg("failed restarting the backend")
+		}
+
+		s.Lock()
+		m.restarting = false
+		hooks := s.onRestart
+		s.Unlock()
+
+		for _, fn := range hooks {
+			fn(modelID)
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package model_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mudler/LocalAI/pkg/model"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Supervisor", func() {
+	var (
+		restarter *countingRestarter
+		failure   = errors.New("inference failed")
+	)
+
+	BeforeEach(func() {
+		restarter = &countingRestarter{}
+	})
+
+	It("restarts a model after consecutive failures", func() {
+		sv := model.NewSupervisor(restarter, 3, 0, 0)
+		restarted := make(chan string, 1)
+		sv.OnRestart(func(modelID string) { restarted <- modelID })
+
+		sv.Report("llama", failure)
+		sv.Report("llama", failure)
+		// a success resets the failures
+		sv.Report("llama", nil)
+		sv.Report("llama", failure)
+		sv.Report("llama", failure)
+		// the canceled requests are not failures of the backend
+		sv.Report("llama", context.Canceled)
+		Expect(restarter.count("llama")).To(Equal(0))
+
+		sv.Report("llama", failure)
+		Eventually(restarted).Should(Receive(Equal("llama")))
+		Expect(restarter.count("llama")).To(Equal(1))
+	})
+
+	It("spaces the restarts with a backoff, and stops after the maximum", func() {
+		sv := model.NewSupervisor(restarter, 1, 50*time.Millisecond, 2)
+		restarted := make(chan string, 2)
+		sv.OnRestart(func(modelID string) { restarted <- modelID })
+
+		sv.Report("llama", failure)
+		Eventually(restarted).Should(Receive())
+
+		// within the backoff
+		sv.Report("llama", failure)
+		Consistently(restarted, 20*time.Millisecond).ShouldNot(Receive())
+
+		time.Sleep(50 * time.Millisecond)
+		sv.Report("llama", failure)
+		Eventually(restarted).Should(Receive())
+
+		// the maximum number of restarts is reached
+		time.Sleep(100 * time.Millisecond)
+		sv.Report("llama", failure)
+		Consistently(restarted, 20*time.Millisecond).ShouldNot(Receive())
+		Expect(restarter.count("llama")).To(Equal(2))
+	})
+})
+
+type countingRestarter struct {
+	sync.Mutex
+	restarts map[string]int
+}
+
+func (r *countingRestarter) RestartModel(modelID string) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.restarts == nil {
+		r.restarts = map[string]int{}
+	}
+	r.restarts[modelID]++
+	return nil
+}
+
+func (r *countingRestarter) count(modelID string) int {
+	r.Lock()
+	defer r.Unlock()
+	return r.restarts[modelID]
+}
@@ -15,6 +15,7 @@ type PromptTemplateData struct {
 	SuppressSystemPrompt bool // used by chat specifically to indicate that SystemPrompt above should be _ignored_
 	Input                string
 	Instruction          string
+	Suffix               string // the text following the completion, for the fill-in-the-middle templates
 	Functions            []functions.Function
 	MessageIndex         int
 }
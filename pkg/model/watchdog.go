@@ -4,8 +4,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mudler/LocalAI/pkg/xlog"
 	process "github.com/mudler/go-processmanager"
-	"github.com/rs/zerolog/log"
 )
 
 // WatchDog tracks all the requests from GRPC clients.
@@ -23,6 +23,7 @@ type WatchDog struct {
 	timeout, idletimeout time.Duration
 	addressMap           map[string]*process.Process
 	addressModelMap      map[string]string
+	keepWarm             map[string]bool
 	pm                   ProcessManager
 	stop                 chan bool
 
@@ -44,6 +45,17 @@ func NewWatchDog(pm ProcessManager, timeoutBusy, timeoutIdle time.Duration, busy
 		busyCheck:       busy,
 		idleCheck:       idle,
 		addressModelMap: make(map[string]string),
+		keepWarm:        make(map[string]bool),
+	}
+}
+
+// KeepWarm exempts the models from the idle check: once loaded, they are only stopped when busy for
+// too long
+func (wd *WatchDog) KeepWarm(models ...string) {
+	wd.Lock()
+	defer wd.Unlock()
+	for _, m := range models {
+		wd.keepWarm[m] = true
 	}
 }
 
@@ -80,16 +92,16 @@ func (wd *WatchDog) UnMark(ModelAddress string) {
 }
 
 func (wd *WatchDog) Run() {
-	log.Info().Msg("[WatchDog] starting watchdog")
+	xlog.Model.Info().Msg("[WatchDog] starting watchdog")
 
 	for {
 		select {
 		case <-wd.stop:
-			log.Info().Msg("[WatchDog] Stopping watchdog")
+			xlog.Model.Info().Msg("[WatchDog] Stopping watchdog")
 			return
 		case <-time.After(30 * time.Second):
 			if !wd.busyCheck && !wd.idleCheck {
-				log.Info().Msg("[WatchDog] No checks enabled, stopping watchdog")
+				xlog.Model.Info().Msg("[WatchDog] No checks enabled, stopping watchdog")
 				return
 			}
 			if wd.busyCheck {
@@ -105,22 +117,25 @@ func (wd *WatchDog) Run() {
 func (wd *WatchDog) checkIdle() {
 	wd.Lock()
 	defer wd.Unlock()
-	log.Debug().Msg("[WatchDog] Watchdog checks for idle connections")
+	xlog.Model.Debug().Msg("[WatchDog] Watchdog checks for idle connections")
 	for address, t := range wd.idleTime {
-		log.Debug().Msgf("[WatchDog] %s: idle connection", address)
+		xlog.Model.Debug().Msgf("[WatchDog] %s: idle connection", address)
+		if wd.keepWarm[wd.addressModelMap[address]] {
+			continue
+		}
 		if time.Since(t) > wd.idletimeout {
-			log.Warn().Msgf("[WatchDog] Address %s is idle for too long, killing it", address)
+			xlog.Model.Warn().Msgf("[WatchDog] Address %s is idle for too long, killing it", address)
 			model, ok := wd.addressModelMap[address]
 			if ok {
 				if err := wd.pm.ShutdownModel(model); err != nil {
-					log.Error().Err(err).Str("model", model).Msg("[watchdog] error shutting down model")
+					xlog.Model.Error().Err(err).Str("model", model).Msg("[watchdog] error shutting down model")
 				}
-				log.Debug().Msgf("[WatchDog] model shut down: %s", address)
+				xlog.Model.Debug().Msgf("[WatchDog] model shut down: %s", address)
 				delete(wd.idleTime, address)
 				delete(wd.addressModelMap, address)
 				delete(wd.addressMap, address)
 			} else {
-				log.Warn().Msgf("[WatchDog] Address %s unresolvable", address)
+				xlog.Model.Warn().Msgf("[WatchDog] Address %s unresolvable", address)
 				delete(wd.idleTime, address)
 			}
 		}
@@ -130,25 +145,25 @@ func (wd *WatchDog) checkIdle() {
 func (wd *WatchDog) checkBusy() {
 	wd.Lock()
 	defer wd.Unlock()
-	log.Debug().Msg("[WatchDog] Watchdog checks for busy connections")
+	xlog.Model.Debug().Msg("[WatchDog] Watchdog checks for busy connections")
 
 	for address, t := range wd.timetable {
-		log.Debug().Msgf("[WatchDog] %s: active connection", address)
+		xlog.Model.Debug().Msgf("[WatchDog] %s: active connection", address)
 
 		if time.Since(t) > wd.timeout {
 
 			model, ok := wd.addressModelMap[address]
 			if ok {
-				log.Warn().Msgf("[WatchDog] Model %s is busy for too long, killing it", model)
+				xlog.Model.Warn().Msgf("[WatchDog] Model %s is busy for too long, killing it", model)
 				if err := wd.pm.ShutdownModel(model); err != nil {
-					log.Error().Err(err).Str("model", model).Msg("[watchdog] error shutting down model")
+					xlog.Model.Error().Err(err).Str("model", model).Msg("[watchdog] error shutting down model")
 				}
-				log.Debug().Msgf("[WatchDog] model shut down: %s", address)
+				xlog.Model.Debug().Msgf("[WatchDog] model shut down: %s", address)
 				delete(wd.timetable, address)
 				delete(wd.addressModelMap, address)
 				delete(wd.addressMap, address)
 			} else {
-				log.Warn().Msgf("[WatchDog] Address %s unresolvable", address)
+				xlog.Model.Warn().Msgf("[WatchDog] Address %s unresolvable", address)
 				delete(wd.timetable, address)
 			}
 		}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// shutdownRecorder records the models stopped by the watchdog
+type shutdownRecorder struct {
+	models []string
+}
+
+func (r *shutdownRecorder) ShutdownModel(modelName string) error {
+	r.models = append(r.models, modelName)
+	return nil
+}
+
+var _ = Describe("WatchDog", func() {
+	It("stops the idle backends, except the ones of the models kept warm", func() {
+		pm := &shutdownRecorder{}
+		wd := NewWatchDog(pm, time.Minute, time.Millisecond, true, true)
+		wd.KeepWarm("hot")
+		for address, model := range map[string]string{"127.0.0.1:1": "hot", "127.0.0.1:2": "cold"} {
+			wd.AddAddressModelMap(address, model)
+			wd.Mark(address)
+			wd.UnMark(address)
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		wd.checkIdle()
+		Expect(pm.models).To(Equal([]string{"cold"}))
+		wd.checkIdle()
+		Expect(pm.models).To(Equal([]string{"cold"}))
+	})
+
+	It("still stops the models kept warm when they are busy for too long", func() {
+		pm := &shutdownRecorder{}
+		wd := NewWatchDog(pm, time.Millisecond, time.Minute, true, true)
+		wd.KeepWarm("hot")
+		wd.AddAddressModelMap("127.0.0.1:1", "hot")
+		wd.Mark("127.0.0.1:1")
+		time.Sleep(5 * time.Millisecond)
+
+		wd.checkBusy()
+		Expect(pm.models).To(Equal([]string{"hot"}))
+	})
+})
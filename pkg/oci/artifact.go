@@ -0,0 +1,158 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// newArtifactRepository connects to the repository of the given reference,
+// using the credentials from the docker configuration file (e.g. ~/.docker/config.json), if any.
+// The reference is normalized like the images are, e.g. "alpine" is "index.docker.io/library/alpine:latest",
+// and the registries on localhost are accessed over plain HTTP.
+func newArtifactRepository(reference string) (*remote.Repository, error) {
+	ref, err := name.ParseReference(reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference %q: %v", reference, err)
+	}
+
+	repo, err := remote.NewRepository(ref.Context().Name() + referenceSeparator(ref) + ref.Identifier())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository: %v", err)
+	}
+	repo.PlainHTTP = ref.Context().Scheme() == "http"
+
+	client := &auth.Client{
+		Client: retry.DefaultClient,
+		Cache:  auth.NewCache(),
+	}
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err == nil {
+		client.Credential = credentials.Credential(store)
+	}
+	repo.Client = client
+
+	return repo, nil
+}
+
+func referenceSeparator(ref name.Reference) string {
+	if _, ok := ref.(name.Digest); ok {
+		return "@"
+	}
+	return ":"
+}
+
+// Artifact is an OCI artifact whose layers are plain files (e.g. pushed with `oras push`), rather than a container image
+type Artifact struct {
+	repo     *remote.Repository
+	manifest *ocispec.Manifest
+}
+
+// GetArtifact fetches the manifest of the reference. It returns nil, without an error, if the reference
+// is not an artifact, i.e. if it is an image index or if some of its layers have no title annotation.
+func GetArtifact(ctx context.Context, reference string) (*Artifact, error) {
+	repo, err := newArtifactRepository(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := repo.Resolve(ctx, repo.Reference.Reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %q: %v", repo.Reference, err)
+	}
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		return nil, nil
+	}
+
+	dat, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %v", err)
+	}
+
+	manifest := &ocispec.Manifest{}
+	if err := json.Unmarshal(dat, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	if len(manifest.Layers) == 0 {
+		return nil, nil
+	}
+	for _, layer := range manifest.Layers {
+		if layer.Annotations[ocispec.AnnotationTitle] == "" {
+			return nil, nil
+		}
+	}
+
+	return &Artifact{repo: repo, manifest: manifest}, nil
+}
+
+// Files returns the titles of the layers of the artifact
+func (a *Artifact) Files() []string {
+	files := []string{}
+	for _, layer := range a.manifest.Layers {
+		files = append(files, layer.Annotations[ocispec.AnnotationTitle])
+	}
+	return files
+}
+
+// FetchFile pulls the layer titled name into dst, verifying its digest while pulling. An artifact
+// with a single layer is pulled whatever its title.
+func (a *Artifact) FetchFile(ctx context.Context, name, dst string, statusWriter func(ocispec.Descriptor) io.Writer) error {
+	layers := a.manifest.Layers
+	if len(layers) > 1 {
+		layers = []ocispec.Descriptor{}
+		for _, layer := range a.manifest.Layers {
+			if layer.Annotations[ocispec.AnnotationTitle] == name {
+				layers = append(layers, layer)
+			}
+		}
+		if len(layers) != 1 {
+			return fmt.Errorf("artifact %q has no single file named %q, its files are %v", a.repo.Reference, name, a.Files())
+		}
+	}
+
+	return fetchArtifactLayer(ctx, a.repo, layers[0], dst, statusWriter)
+}
+
+func fetchArtifactLayer(ctx context.Context, repo *remote.Repository, layer ocispec.Descriptor, dst string, statusWriter func(ocispec.Descriptor) io.Writer) error {
+	rc, err := repo.Fetch(ctx, layer)
+	if err != nil {
+		return fmt.Errorf("failed to fetch layer %s: %v", layer.Digest, err)
+	}
+	defer rc.Close()
+
+	tmp := dst + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if statusWriter != nil {
+		w = io.MultiWriter(f, statusWriter(layer))
+	}
+
+	vr := content.NewVerifyReader(rc, layer)
+	if _, err := io.Copy(w, vr); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write layer %s: %v", layer.Digest, err)
+	}
+	if err := vr.Verify(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to verify layer %s: %v", layer.Digest, err)
+	}
+
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,195 @@
+package signature
+
+// This package verifies detached signatures in the minisign format (https://jedisct1.github.io/minisign/)
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	trustedCommentPrefix = "trusted comment: "
+
+	// legacyAlgorithm signs the whole file content
+	legacyAlgorithm = "Ed"
+	// prehashedAlgorithm signs the BLAKE2b-512 hash of the file content
+	prehashedAlgorithm = "ED"
+
+	// maxLegacyFileSize is the size of the largest file verified with a legacy signature, which needs
+	// the whole file in memory
+	maxLegacyFileSize = 64 << 20
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+type PublicKey struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+type Signature struct {
+	algorithm       string
+	keyID           [8]byte
+	signature       []byte
+	trustedComment  string
+	globalSignature []byte
+}
+
+// ParsePublicKey parses a minisign public key. It accepts both the base64 encoded key
+// and the content of a public key file (including the untrusted comment line)
+func ParsePublicKey(s string) (*PublicKey, error) {
+	lines := nonEmptyLines(s)
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("empty public key")
+	}
+
+	dat, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	if len(dat) != 2+8+ed25519.PublicKeySize || string(dat[:2]) != legacyAlgorithm {
+		return nil, fmt.Errorf("invalid public key")
+	}
+
+	pk := &PublicKey{key: ed25519.PublicKey(dat[10:])}
+	copy(pk.keyID[:], dat[2:10])
+	return pk, nil
+}
+
+// ParsePublicKeys parses a list of minisign public keys, failing on the first invalid one
+func ParsePublicKeys(keys []string) ([]*PublicKey, error) {
+	pks := []*PublicKey{}
+	for i, k := range keys {
+		pk, err := ParsePublicKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("trusted key %d: %w", i+1, err)
+		}
+		pks = append(pks, pk)
+	}
+	return pks, nil
+}
+
+// ParseSignature parses the content of a minisign signature file
+func ParseSignature(dat []byte) (*Signature, error) {
+	lines := nonEmptyLines(string(dat))
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return nil, fmt.Errorf("invalid signature file format")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(sig) != 2+8+ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid signature length")
+	}
+
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid global signature encoding: %w", err)
+	}
+
+	s := &Signature{
+		algorithm:       string(sig[:2]),
+		signature:       sig[10:],
+		trustedComment:  strings.TrimPrefix(lines[2], trustedCommentPrefix),
+		globalSignature: globalSignature,
+	}
+	copy(s.keyID[:], sig[2:10])
+
+	if s.algorithm != legacyAlgorithm && s.algorithm != prehashedAlgorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", s.algorithm)
+	}
+
+	return s, nil
+}
+
+// VerifyFile checks that sig is a valid signature of the file in path by the public key pk
+func (pk *PublicKey) VerifyFile(path string, sig *Signature) error {
+	if pk.keyID != sig.keyID {
+		return fmt.Errorf("%w: signed with a different key", ErrInvalidSignature)
+	}
+
+	var message []byte
+	if sig.algorithm == prehashedAlgorithm {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h, err := blake2b.New512(nil)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		message = h.Sum(nil)
+	} else {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.Size() > maxLegacyFileSize {
+			return fmt.Errorf("%w: legacy signatures are not supported for files larger than %d MiB, sign the file with a prehashed signature (minisign -S, without -l)", ErrInvalidSignature, maxLegacyFileSize>>20)
+		}
+		dat, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		message = dat
+	}
+
+	if !ed25519.Verify(pk.key, message, sig.signature) {
+		return ErrInvalidSignature
+	}
+
+	// the global signature covers the trusted comment as well
+	if !ed25519.Verify(pk.key, append(bytes.Clone(sig.signature), []byte(sig.trustedComment)...), sig.globalSignature) {
+		return fmt.Errorf("%w: trusted comment does not match", ErrInvalidSignature)
+	}
+
+	return nil
+}
+
+// VerifyFileWithKeys checks that the signature in sigData is a valid signature of the file in path
+// by any of the given public keys
+func VerifyFileWithKeys(path string, sigData []byte, keys []string) error {
+	sig, err := ParseSignature(sigData)
+	if err != nil {
+		return err
+	}
+
+	pks, err := ParsePublicKeys(keys)
+	if err != nil {
+		return err
+	}
+	for _, pk := range pks {
+		if pk.keyID != sig.keyID {
+			continue
+		}
+		return pk.VerifyFile(path, sig)
+	}
+
+	return fmt.Errorf("%w: not signed by any trusted key", ErrInvalidSignature)
+}
+
+func nonEmptyLines(s string) []string {
+	lines := []string{}
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
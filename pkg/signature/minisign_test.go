@@ -0,0 +1,89 @@
+package signature_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+
+	. "github.com/mudler/LocalAI/pkg/signature"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/blake2b"
+)
+
+// sign creates a minisign signature file for content, using the pre-hashed algorithm
+func sign(priv ed25519.PrivateKey, keyID []byte, content []byte) []byte {
+	hash := blake2b.Sum512(content)
+	return signMessage(priv, "ED", keyID, hash[:])
+}
+
+func signMessage(priv ed25519.PrivateKey, algorithm string, keyID []byte, message []byte) []byte {
+	sig := ed25519.Sign(priv, message)
+	trustedComment := "timestamp:0\tfile:model.bin"
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), []byte(trustedComment)...))
+
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyID...), sig...)) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+func publicKey(pub ed25519.PublicKey, keyID []byte) string {
+	return "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), pub...))
+}
+
+var _ = Describe("minisign", func() {
+	var pub ed25519.PublicKey
+	var priv ed25519.PrivateKey
+	var keyID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	var file string
+	var content = []byte("model content")
+
+	BeforeEach(func() {
+		var err error
+		pub, priv, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		file = filepath.Join(GinkgoT().TempDir(), "model.bin")
+		Expect(os.WriteFile(file, content, 0600)).To(Succeed())
+	})
+
+	It("verifies a valid signature", func() {
+		Expect(VerifyFileWithKeys(file, sign(priv, keyID, content), []string{publicKey(pub, keyID)})).To(Succeed())
+	})
+
+	It("rejects a tampered file", func() {
+		Expect(os.WriteFile(file, []byte("tampered"), 0600)).To(Succeed())
+		err := VerifyFileWithKeys(file, sign(priv, keyID, content), []string{publicKey(pub, keyID)})
+		Expect(err).To(MatchError(ErrInvalidSignature))
+	})
+
+	It("rejects signatures from untrusted keys", func() {
+		otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		err = VerifyFileWithKeys(file, sign(priv, keyID, content), []string{publicKey(otherPub, []byte{8, 7, 6, 5, 4, 3, 2, 1})})
+		Expect(err).To(MatchError(ErrInvalidSignature))
+	})
+
+	It("rejects malformed signatures", func() {
+		Expect(VerifyFileWithKeys(file, []byte("garbage"), []string{publicKey(pub, keyID)})).ToNot(Succeed())
+	})
+
+	It("verifies legacy signatures of small files only", func() {
+		Expect(VerifyFileWithKeys(file, signMessage(priv, "Ed", keyID, content), []string{publicKey(pub, keyID)})).To(Succeed())
+
+		Expect(os.Truncate(file, 65<<20)).To(Succeed())
+		err := VerifyFileWithKeys(file, signMessage(priv, "Ed", keyID, content), []string{publicKey(pub, keyID)})
+		Expect(err).To(MatchError(ContainSubstring("legacy signatures are not supported for files larger than 64 MiB")))
+	})
+
+	It("fails on invalid trusted keys", func() {
+		_, err := ParsePublicKeys([]string{publicKey(pub, keyID), "invalid"})
+		Expect(err).To(MatchError(ContainSubstring("trusted key 2")))
+		keys, err := ParsePublicKeys([]string{publicKey(pub, keyID)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(HaveLen(1))
+	})
+})
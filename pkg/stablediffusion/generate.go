@@ -4,11 +4,16 @@
 package stablediffusion
 
 import (
+	"fmt"
+
 	stableDiffusion "github.com/mudler/go-stable-diffusion"
 )
 
-func GenerateImage(height, width, mode, step, seed int, positive_prompt, negative_prompt, dst, asset_dir string) error {
+func GenerateImage(height, width, mode, step, seed int, positive_prompt, negative_prompt, src, dst, asset_dir string) error {
 	if height > 512 || width > 512 {
+		if src != "" {
+			return fmt.Errorf("image to image is supported only for images up to 512x512")
+		}
 		return stableDiffusion.GenerateImageUpscaled(
 			height,
 			width,
@@ -29,7 +34,7 @@ func GenerateImage(height, width, mode, step, seed int, positive_prompt, negativ
 		positive_prompt,
 		negative_prompt,
 		dst,
-		"",
+		src,
 		asset_dir,
 	)
 }
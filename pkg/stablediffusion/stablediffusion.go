@@ -15,6 +15,6 @@ func New(assetDir string) (*StableDiffusion, error) {
 	}, nil
 }
 
-func (s *StableDiffusion) GenerateImage(height, width, mode, step, seed int, positive_prompt, negative_prompt, dst string) error {
-	return GenerateImage(height, width, mode, step, seed, positive_prompt, negative_prompt, dst, s.assetDir)
+func (s *StableDiffusion) GenerateImage(height, width, mode, step, seed int, positive_prompt, negative_prompt, src, dst string) error {
+	return GenerateImage(height, width, mode, step, seed, positive_prompt, negative_prompt, src, dst, s.assetDir)
 }
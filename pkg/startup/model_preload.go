@@ -1,6 +1,7 @@
 package startup
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -18,13 +19,18 @@ import (
 // InstallModels will preload models from the given list of URLs and galleries
 // It will download the model if it is not already present in the model path
 // It will also try to resolve if the model is an embedded model YAML configuration
-func InstallModels(galleries []config.Gallery, modelLibraryURL string, modelPath string, enforceScan bool, downloadStatus func(string, string, string, float64), models ...string) error {
+// The downloads stop when ctx is cancelled
+func InstallModels(ctx context.Context, galleries []config.Gallery, modelLibraryURL string, modelPath string, enforceScan bool, trustedKeys []string, downloadStatus func(string, string, string, float64), models ...string) error {
 	// create an error that groups all errors
 	var err error
 
 	lib, _ := embedded.GetRemoteLibraryShorteners(modelLibraryURL, modelPath)
 
 	for _, url := range models {
+		if ctx.Err() != nil {
+			return errors.Join(err, ctx.Err())
+		}
+
 		// As a best effort, try to resolve the model from the remote library
 		// if it's not resolved we try with the other method below
 		if modelLibraryURL != "" {
@@ -66,7 +72,7 @@ func InstallModels(galleries []config.Gallery, modelLibraryURL string, modelPath
 			// check if file exists
 			if _, e := os.Stat(filepath.Join(modelPath, ociName)); errors.Is(e, os.ErrNotExist) {
 				modelDefinitionFilePath := filepath.Join(modelPath, ociName)
-				e := uri.DownloadFile(modelDefinitionFilePath, "", 0, 0, func(fileName, current, total string, percent float64) {
+				e := uri.DownloadFileWithContext(ctx, modelDefinitionFilePath, "", 0, 0, func(fileName, current, total string, percent float64) {
 					utils.DisplayDownloadFunction(fileName, current, total, percent)
 				})
 				if e != nil {
@@ -97,7 +103,7 @@ func InstallModels(galleries []config.Gallery, modelLibraryURL string, modelPath
 
 			// check if file exists
 			if _, e := os.Stat(modelPath); errors.Is(e, os.ErrNotExist) {
-				e := uri.DownloadFile(modelPath, "", 0, 0, func(fileName, current, total string, percent float64) {
+				e := uri.DownloadFileWithContext(ctx, modelPath, "", 0, 0, func(fileName, current, total string, percent float64) {
 					utils.DisplayDownloadFunction(fileName, current, total, percent)
 				})
 				if e != nil {
@@ -125,7 +131,7 @@ func InstallModels(galleries []config.Gallery, modelLibraryURL string, modelPath
 				}
 			} else {
 				// Check if it's a model gallery, or print a warning
-				e, found := installModel(galleries, url, modelPath, downloadStatus, enforceScan)
+				e, found := installModel(ctx, galleries, url, modelPath, downloadStatus, enforceScan, trustedKeys)
 				if e != nil && found {
 					log.Error().Err(err).Msgf("[startup] failed installing model '%s'", url)
 					err = errors.Join(err, e)
@@ -139,7 +145,7 @@ func InstallModels(galleries []config.Gallery, modelLibraryURL string, modelPath
 	return err
 }
 
-func installModel(galleries []config.Gallery, modelName, modelPath string, downloadStatus func(string, string, string, float64), enforceScan bool) (error, bool) {
+func installModel(ctx context.Context, galleries []config.Gallery, modelName, modelPath string, downloadStatus func(string, string, string, float64), enforceScan bool, trustedKeys []string) (error, bool) {
 	models, err := gallery.AvailableGalleryModels(galleries, modelPath)
 	if err != nil {
 		return err, false
@@ -155,7 +161,7 @@ func installModel(galleries []config.Gallery, modelName, modelPath string, downl
 	}
 
 	log.Info().Str("model", modelName).Str("license", model.License).Msg("installing model")
-	err = gallery.InstallModelFromGallery(galleries, modelName, modelPath, gallery.GalleryModel{}, downloadStatus, enforceScan)
+	err = gallery.InstallModelFromGallery(ctx, galleries, modelName, modelPath, gallery.GalleryModel{}, downloadStatus, enforceScan, trustedKeys)
 	if err != nil {
 		return err, true
 	}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,8 +14,17 @@ var base64DownloadClient http.Client = http.Client{
 	Timeout: 30 * time.Second,
 }
 
+// ErrContentTooLarge is returned when the content exceeds the size limit
+var ErrContentTooLarge = errors.New("content exceeds the size limit")
+
 // GetContentURIAsBase64 checks if the string is an URL, if it's an URL downloads the content in memory encodes it in base64 and returns the base64 string, otherwise returns the string by stripping base64 data headers
 func GetContentURIAsBase64(s string) (string, error) {
+	return GetContentURIAsBase64WithLimit(s, 0)
+}
+
+// GetContentURIAsBase64WithLimit is like GetContentURIAsBase64, but fails with ErrContentTooLarge
+// if the content is larger than limit bytes. A limit of 0 disables the check.
+func GetContentURIAsBase64WithLimit(s string, limit int64) (string, error) {
 	if strings.HasPrefix(s, "http") {
 		// download the image
 		resp, err := base64DownloadClient.Get(s)
@@ -23,11 +33,24 @@ func GetContentURIAsBase64(s string) (string, error) {
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed downloading %s: %s", s, resp.Status)
+		}
+
+		var body io.Reader = resp.Body
+		if limit > 0 {
+			// read one byte more than the limit to detect larger content
+			body = io.LimitReader(resp.Body, limit+1)
+		}
+
 		// read the image data into memory
-		data, err := io.ReadAll(resp.Body)
+		data, err := io.ReadAll(body)
 		if err != nil {
 			return "", err
 		}
+		if limit > 0 && int64(len(data)) > limit {
+			return "", ErrContentTooLarge
+		}
 
 		// encode the image data in base64
 		encoded := base64.StdEncoding.EncodeToString(data)
@@ -36,12 +59,12 @@ func GetContentURIAsBase64(s string) (string, error) {
 		return encoded, nil
 	}
 
-	// if the string instead is prefixed with "data:image/...;base64,", drop it
-	dropPrefix := []string{"data:image/jpeg;base64,", "data:image/png;base64,"}
-	for _, prefix := range dropPrefix {
-		if strings.HasPrefix(s, prefix) {
-			return strings.ReplaceAll(s, prefix, ""), nil
+	// if the string instead is prefixed with "data:<media type>;base64,", drop it
+	if header, data, found := strings.Cut(s, ","); found && strings.HasPrefix(header, "data:") && strings.HasSuffix(header, ";base64") {
+		if limit > 0 && int64(base64.StdEncoding.DecodedLen(len(data))) > limit {
+			return "", ErrContentTooLarge
 		}
+		return data, nil
 	}
 	return "", fmt.Errorf("not valid string")
 }
@@ -21,6 +21,19 @@ var _ = Describe("utils/base64 tests", func() {
 		Expect(err).To(BeNil())
 		Expect(b64).To(Equal("BAR"))
 	})
+	It("GetContentURIAsBase64 can strip any base64 data url prefix", func() {
+		b64, err := GetContentURIAsBase64("data:image/webp;base64,BAZ")
+		Expect(err).To(BeNil())
+		Expect(b64).To(Equal("BAZ"))
+	})
+	It("GetContentURIAsBase64WithLimit rejects content over the limit", func() {
+		input := "data:image/png;base64,AAAAAAAAAAAA"
+		_, err := GetContentURIAsBase64WithLimit(input, 4)
+		Expect(err).To(MatchError(ErrContentTooLarge))
+		b64, err := GetContentURIAsBase64WithLimit(input, 9)
+		Expect(err).To(BeNil())
+		Expect(b64).To(Equal("AAAAAAAAAAAA"))
+	})
 	It("GetImageURLAsBase64 returns an error for bogus data", func() {
 		input := "FOO"
 		b64, err := GetContentURIAsBase64(input)
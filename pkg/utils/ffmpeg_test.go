@@ -0,0 +1,34 @@
+package utils_test
+
+import (
+	. "github.com/mudler/LocalAI/pkg/utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/ffmpeg tests", func() {
+	It("encodes to the requested format on the standard output", func() {
+		args, err := AudioConvertArgs("in.wav", "opus", 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(args).To(Equal([]string{"-loglevel", "error", "-i", "in.wav", "-c:a", "libopus", "-f", "ogg", "pipe:1"}))
+	})
+	It("chains the atempo filters for the speeds out of their range", func() {
+		args, err := AudioConvertArgs("in.wav", "mp3", 4)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(args).To(ContainElements("-filter:a", "atempo=2,atempo=2"))
+
+		args, err = AudioConvertArgs("in.wav", "mp3", 0.25)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(args).To(ContainElements("-filter:a", "atempo=0.5,atempo=0.5"))
+
+		args, err = AudioConvertArgs("in.wav", "mp3", 1.5)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(args).To(ContainElements("-filter:a", "atempo=1.5"))
+	})
+	It("rejects the unsupported formats", func() {
+		_, err := AudioConvertArgs("in.wav", "ogg", 1)
+		Expect(err).To(HaveOccurred())
+		_, supported := AudioContentType("ogg")
+		Expect(supported).To(BeFalse())
+	})
+})
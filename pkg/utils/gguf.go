@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// ggufShardRegex matches the files of a GGUF model split in shards, e.g. model-00001-of-00003.gguf
+var ggufShardRegex = regexp.MustCompile(`^(.*)-(\d{5})-of-(\d{5})\.gguf$`)
+
+// GGUFShards returns the paths of all the shards of a GGUF model split in shards, in order, given the path
+// of any of them. The first one is the shard to load, holding the metadata. It returns nil for a file
+// that is not a shard.
+func GGUFShards(path string) []string {
+	m := ggufShardRegex.FindStringSubmatch(path)
+	if m == nil {
+		return nil
+	}
+	shard, _ := strconv.Atoi(m[2])
+	total, _ := strconv.Atoi(m[3])
+	if shard == 0 || shard > total {
+		return nil
+	}
+
+	shards := make([]string, total)
+	for i := range shards {
+		shards[i] = fmt.Sprintf("%s-%05d-of-%s.gguf", m[1], i+1, m[3])
+	}
+	return shards
+}
+
+// MissingGGUFShards returns the shards of a GGUF model split in shards that don't exist, given the path of
+// any of them
+func MissingGGUFShards(path string) []string {
+	var missing []string
+	for _, shard := range GGUFShards(path) {
+		if _, err := os.Stat(shard); err != nil {
+			missing = append(missing, shard)
+		}
+	}
+	return missing
+}
+
+// GGUFSize returns the size of a GGUF model, summing the sizes of its shards if it is split
+func GGUFSize(path string) (int64, error) {
+	files := GGUFShards(path)
+	if files == nil {
+		files = []string{path}
+	}
+
+	var size int64
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return 0, err
+		}
+		size += fi.Size()
+	}
+	return size, nil
+}
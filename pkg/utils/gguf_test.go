@@ -0,0 +1,36 @@
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/mudler/LocalAI/pkg/utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/gguf tests", func() {
+	It("lists the shards of a split model from any of them", func() {
+		shards := []string{"/models/llama-00001-of-00003.gguf", "/models/llama-00002-of-00003.gguf", "/models/llama-00003-of-00003.gguf"}
+		Expect(GGUFShards("/models/llama-00001-of-00003.gguf")).To(Equal(shards))
+		Expect(GGUFShards("/models/llama-00002-of-00003.gguf")).To(Equal(shards))
+	})
+	It("doesn't list the shards of a file that is not split", func() {
+		Expect(GGUFShards("/models/llama.Q4_K_M.gguf")).To(BeNil())
+		Expect(GGUFShards("/models/llama-00004-of-00003.gguf")).To(BeNil())
+	})
+	It("reports the missing shards and sums the size of the shards", func() {
+		dir := GinkgoT().TempDir()
+		first := filepath.Join(dir, "llama-00001-of-00003.gguf")
+		Expect(os.WriteFile(first, []byte("abc"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "llama-00003-of-00003.gguf"), []byte("de"), 0600)).To(Succeed())
+
+		Expect(MissingGGUFShards(first)).To(Equal([]string{filepath.Join(dir, "llama-00002-of-00003.gguf")}))
+		_, err := GGUFSize(first)
+		Expect(err).To(HaveOccurred())
+
+		Expect(os.WriteFile(filepath.Join(dir, "llama-00002-of-00003.gguf"), []byte("f"), 0600)).To(Succeed())
+		Expect(MissingGGUFShards(first)).To(BeEmpty())
+		Expect(GGUFSize(first)).To(Equal(int64(6)))
+	})
+})
@@ -0,0 +1,70 @@
+package xlog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// The formats of the logs
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// fieldNames are the standard fields of the log lines whose name can be changed, with the zerolog setting of
+// their name
+var fieldNames = map[string]*string{
+	"timestamp": &zerolog.TimestampFieldName,
+	"level":     &zerolog.LevelFieldName,
+	"message":   &zerolog.MessageFieldName,
+	"error":     &zerolog.ErrorFieldName,
+}
+
+// ParseFields parses the names of the standard fields of the log lines, e.g. "timestamp=@timestamp,level=severity"
+func ParseFields(s string) (map[string]string, error) {
+	fields := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		field, name, found := strings.Cut(pair, "=")
+		field, name = strings.TrimSpace(field), strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid log field %q, expected field=name", pair)
+		}
+		if _, ok := fieldNames[field]; !ok {
+			return nil, fmt.Errorf("unknown log field %q, expected one of timestamp, level, message, error", field)
+		}
+		fields[field] = name
+	}
+	return fields, nil
+}
+
+// SetFormat writes the logs to out in the format, console or json, with the standard fields renamed as in
+// fields. It is called before logging from other goroutines, as the names of the fields are global.
+func SetFormat(out io.Writer, format string, fields map[string]string) error {
+	var w io.Writer
+	switch format {
+	case "", FormatConsole:
+		w = zerolog.ConsoleWriter{Out: out}
+	case FormatJSON:
+		w = out
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatConsole, FormatJSON)
+	}
+
+	for field, name := range fields {
+		setting, ok := fieldNames[field]
+		if !ok {
+			return fmt.Errorf("unknown log field %q", field)
+		}
+		*setting = name
+	}
+	log.Logger = log.Output(w)
+	return nil
+}
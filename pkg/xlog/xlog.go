@@ -0,0 +1,123 @@
+// Package xlog scopes the logs of the parts of LocalAI, so that the level of each one can be raised or
+// lowered on its own, e.g. the model loader at the debug level without the logs of every HTTP request.
+package xlog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Component is a part of LocalAI logging at its own level
+type Component string
+
+const (
+	Model   Component = "model"
+	Startup Component = "startup"
+	HTTP    Component = "http"
+)
+
+// Components are the components whose level can be set
+var Components = []Component{Model, Startup, HTTP}
+
+var (
+	levelsMu sync.RWMutex
+	levels   = map[Component]zerolog.Level{}
+)
+
+// ParseLevels parses the levels of the components, e.g. "model=debug,http=warn"
+func ParseLevels(s string) (map[Component]zerolog.Level, error) {
+	parsed := map[Component]zerolog.Level{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid log level %q, expected component=level", pair)
+		}
+		c := Component(strings.TrimSpace(name))
+		if !isComponent(c) {
+			return nil, fmt.Errorf("unknown log component %q, expected one of %v", c, Components)
+		}
+		level, err := zerolog.ParseLevel(strings.TrimSpace(value))
+		if err != nil || value == "" {
+			return nil, fmt.Errorf("invalid log level %q for the component %s", value, c)
+		}
+		parsed[c] = level
+	}
+	return parsed, nil
+}
+
+// SetLevels sets the level of the logs and the levels of the components overriding it. zerolog drops the
+// events below its global level whatever the level of their logger, so the global level is lowered to the
+// lowest of the levels and the level of the global logger is set to level instead.
+//
+// As zerolog.GlobalLevel is lowered, a logger other than the global logger and the loggers of the components
+// (e.g. zerolog.New in a dependency) logs at the lowest level of the components unless it sets its own level.
+func SetLevels(level zerolog.Level, components map[Component]zerolog.Level) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	lowest := level
+	levels = map[Component]zerolog.Level{}
+	for c, l := range components {
+		levels[c] = l
+		lowest = min(lowest, l)
+	}
+	log.Logger = log.Logger.Level(level)
+	zerolog.SetGlobalLevel(lowest)
+}
+
+// Level returns the level of the component, the level of the global logger if it is not overridden
+func (c Component) Level() zerolog.Level {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+	if l, ok := levels[c]; ok {
+		return l
+	}
+	return log.Logger.GetLevel()
+}
+
+// Logger returns the global logger at the level of the component
+func (c Component) Logger() *zerolog.Logger {
+	l := log.Logger.Level(c.Level())
+	return &l
+}
+
+// Trace starts a new message with trace level, if enabled for the component
+func (c Component) Trace() *zerolog.Event { return c.Logger().Trace() }
+
+// Debug starts a new message with debug level, if enabled for the component
+func (c Component) Debug() *zerolog.Event { return c.Logger().Debug() }
+
+// Info starts a new message with info level, if enabled for the component
+func (c Component) Info() *zerolog.Event { return c.Logger().Info() }
+
+// Warn starts a new message with warn level, if enabled for the component
+func (c Component) Warn() *zerolog.Event { return c.Logger().Warn() }
+
+// Error starts a new message with error level, if enabled for the component
+func (c Component) Error() *zerolog.Event { return c.Logger().Error() }
+
+// Fatal starts a new message with fatal level. The program exits once the message is sent.
+func (c Component) Fatal() *zerolog.Event { return c.Logger().Fatal() }
+
+// Err starts a new message with error level with err as a field if not nil, or with info level otherwise
+func (c Component) Err(err error) *zerolog.Event { return c.Logger().Err(err) }
+
+// WithLevel starts a new message with level, if enabled for the component
+func (c Component) WithLevel(level zerolog.Level) *zerolog.Event { return c.Logger().WithLevel(level) }
+
+func isComponent(c Component) bool {
+	for _, known := range Components {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
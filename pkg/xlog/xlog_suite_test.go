@@ -0,0 +1,13 @@
+package xlog_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestXLog(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "xlog test suite")
+}
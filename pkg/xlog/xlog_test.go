@@ -0,0 +1,94 @@
+package xlog_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/mudler/LocalAI/pkg/xlog"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var _ = Describe("Component levels", func() {
+	It("parses the levels of the components", func() {
+		levels, err := ParseLevels(" model=debug, http=WARN ,")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(levels).To(Equal(map[Component]zerolog.Level{Model: zerolog.DebugLevel, HTTP: zerolog.WarnLevel}))
+
+		levels, err = ParseLevels("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(levels).To(BeEmpty())
+
+		for _, invalid := range []string{"model", "modle=debug", "model=loud", "model="} {
+			_, err := ParseLevels(invalid)
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+	})
+
+	It("logs each component at its level, and the others at the global level", func() {
+		logger, global := log.Logger, zerolog.GlobalLevel()
+		DeferCleanup(func() {
+			log.Logger = logger
+			zerolog.SetGlobalLevel(global)
+		})
+
+		var out bytes.Buffer
+		log.Logger = zerolog.New(&out)
+		SetLevels(zerolog.InfoLevel, map[Component]zerolog.Level{Model: zerolog.DebugLevel, HTTP: zerolog.WarnLevel})
+		DeferCleanup(SetLevels, global, map[Component]zerolog.Level{})
+
+		Model.Debug().Msg("model debug")
+		HTTP.Info().Msg("http info")
+		HTTP.Warn().Msg("http warn")
+		Startup.Debug().Msg("startup debug")
+		Startup.Info().Msg("startup info")
+		log.Debug().Msg("global debug")
+		log.Info().Msg("global info")
+
+		Expect(out.String()).To(ContainSubstring("model debug"))
+		Expect(out.String()).ToNot(ContainSubstring("http info"))
+		Expect(out.String()).To(ContainSubstring("http warn"))
+		Expect(out.String()).ToNot(ContainSubstring("startup debug"))
+		Expect(out.String()).To(ContainSubstring("startup info"))
+		Expect(out.String()).ToNot(ContainSubstring("global debug"))
+		Expect(out.String()).To(ContainSubstring("global info"))
+	})
+})
+
+var _ = Describe("Format", func() {
+	It("parses the names of the fields", func() {
+		fields, err := ParseFields("timestamp=@timestamp, level=severity")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(Equal(map[string]string{"timestamp": "@timestamp", "level": "severity"}))
+
+		for _, invalid := range []string{"timestamp", "time=ts", "level="} {
+			_, err := ParseFields(invalid)
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+	})
+
+	It("writes the logs in JSON with the fields renamed", func() {
+		logger := log.Logger
+		timestamp, level, message := zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.MessageFieldName
+		DeferCleanup(func() {
+			log.Logger = logger
+			zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.MessageFieldName = timestamp, level, message
+		})
+
+		var out bytes.Buffer
+		log.Logger = zerolog.New(nil).With().Timestamp().Logger()
+		Expect(SetFormat(&out, FormatJSON, map[string]string{"timestamp": "@timestamp", "level": "severity", "message": "msg"})).To(Succeed())
+		Model.Info().Str("model", "phi-2").Msg("loaded")
+
+		line := map[string]any{}
+		Expect(json.Unmarshal(out.Bytes(), &line)).To(Succeed())
+		Expect(line).To(HaveKeyWithValue("severity", "info"))
+		Expect(line).To(HaveKeyWithValue("msg", "loaded"))
+		Expect(line).To(HaveKeyWithValue("model", "phi-2"))
+		Expect(line).To(HaveKey("@timestamp"))
+
+		Expect(SetFormat(&out, "xml", nil)).To(HaveOccurred())
+	})
+})
@@ -1,13 +1,24 @@
 package xsysinfo
 
 import (
+	"slices"
 	"sort"
+	"sync"
 
 	"github.com/jaypipes/ghw"
 	"github.com/klauspost/cpuid/v2"
 )
 
+// cpuCapabilities caches the capabilities of the CPUs, which do not change while LocalAI runs
+var cpuCapabilities = sync.OnceValues(readCPUCapabilities)
+
+// CPUCapabilities returns the sorted capabilities of the CPUs, such as avx2 or avx512f, read once
 func CPUCapabilities() ([]string, error) {
+	caps, err := cpuCapabilities()
+	return slices.Clone(caps), err
+}
+
+func readCPUCapabilities() ([]string, error) {
 	cpu, err := ghw.CPU()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,79 @@
+package xsysinfo
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseCPUSet parses a list of CPUs in the format of taskset and cpusets, such as "0-3,8,10-11",
+// returning the CPUs sorted and without duplicates
+func ParseCPUSet(s string) ([]int, error) {
+	cpus := []int{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid CPU %q in the CPU set %q", first, s)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(last))
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid CPU range %q in the CPU set %q", part, s)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("empty CPU set %q", s)
+	}
+	slices.Sort(cpus)
+	return slices.Compact(cpus), nil
+}
+
+// FormatCPUSet formats sorted CPUs as a list of ranges, as ParseCPUSet reads them
+func FormatCPUSet(cpus []int) string {
+	parts := []string{}
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// allCPUs returns as many CPUs as the process can use, numbered from 0
+func allCPUs() []int {
+	cpus := make([]int, runtime.NumCPU())
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus
+}
+
+// CheckCPUSet returns an error if a CPU of the set is not available to the process
+func CheckCPUSet(cpus []int) error {
+	available := AvailableCPUs()
+	for _, cpu := range cpus {
+		if !slices.Contains(available, cpu) {
+			return fmt.Errorf("CPU %d is not available, the available CPUs are %s", cpu, FormatCPUSet(available))
+		}
+	}
+	return nil
+}
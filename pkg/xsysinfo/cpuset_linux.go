@@ -0,0 +1,18 @@
+package xsysinfo
+
+import "golang.org/x/sys/unix"
+
+// AvailableCPUs returns the CPUs the process can run on, from its affinity
+func AvailableCPUs() []int {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return allCPUs()
+	}
+	cpus := []int{}
+	for cpu := 0; cpu < len(set)*64; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
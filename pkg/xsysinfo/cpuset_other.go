@@ -0,0 +1,8 @@
+//go:build !linux
+
+package xsysinfo
+
+// AvailableCPUs returns the CPUs of the host: the affinity of the process is only known on Linux
+func AvailableCPUs() []int {
+	return allCPUs()
+}
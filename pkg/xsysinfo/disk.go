@@ -0,0 +1,84 @@
+package xsysinfo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// ErrInsufficientDisk is returned by the large writes (downloads, extractions) that don't fit on their volume
+var ErrInsufficientDisk = errors.New("insufficient disk")
+
+var diskHeadroom atomic.Uint64
+
+// SetDiskHeadroom sets the bytes to leave free on a volume after a large write, so that the instance
+// keeps working once it is done
+func SetDiskHeadroom(bytes uint64) {
+	diskHeadroom.Store(bytes)
+}
+
+// DiskHeadroom returns the bytes left free on a volume after a large write
+func DiskHeadroom() uint64 {
+	return diskHeadroom.Load()
+}
+
+// FreeDiskSpace returns the bytes available on the volume of path. The path doesn't need to exist yet.
+func FreeDiskSpace(path string) (uint64, error) {
+	usage, err := disk.Usage(existingParent(path))
+	if err != nil {
+		return 0, err
+	}
+	return usage.Free, nil
+}
+
+// CheckDiskSpace fails with ErrInsufficientDisk if writing need bytes on the volume of path leaves less than
+// the headroom free. The headroom only applies to the writes adding bytes: writing nothing, e.g. extracting
+// files already extracted, always fits. The write is not prevented if the free space can't be read.
+func CheckDiskSpace(path string, need uint64) error {
+	if need == 0 {
+		return nil
+	}
+	free, err := FreeDiskSpace(path)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("cannot read the free disk space")
+		return nil
+	}
+	headroom := DiskHeadroom()
+	if need+headroom > free {
+		return fmt.Errorf("%w: need %s have %s free in %s (keeping %s free)", ErrInsufficientDisk, FormatBytes(need), FormatBytes(free), path, FormatBytes(headroom))
+	}
+	return nil
+}
+
+// FormatBytes formats a number of bytes with a binary unit, e.g. 1.5 GiB
+func FormatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// existingParent returns the path, or its closest parent that exists
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
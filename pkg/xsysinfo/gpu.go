@@ -1,6 +1,11 @@
 package xsysinfo
 
 import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
 	"github.com/jaypipes/ghw"
 	"github.com/jaypipes/ghw/pkg/gpu"
 )
@@ -13,3 +18,33 @@ func GPUs() ([]*gpu.GraphicsCard, error) {
 
 	return gpu.GraphicsCards, nil
 }
+
+// GPUMemory returns the total and the free memory of the NVIDIA GPUs, in bytes, as reported by nvidia-smi
+func GPUMemory() (total, free uint64, err error) {
+	out, err := exec.Command("nvidia-smi", "--query-gpu=memory.total,memory.free", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseGPUMemory(string(out))
+}
+
+// parseGPUMemory sums the memory of the GPUs, one "total, free" line per GPU in MiB
+func parseGPUMemory(out string) (total, free uint64, err error) {
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			return 0, 0, fmt.Errorf("unexpected nvidia-smi output: %q", line)
+		}
+		t, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		f, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += t << 20
+		free += f << 20
+	}
+	return total, free, nil
+}